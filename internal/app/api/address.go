@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type AddressHandler struct{}
+
+var (
+	AddressRepo connectors.AddressRepository
+
+	addressRegExp = regexp.MustCompile(`^\/user\/address[\/]*$`)
+)
+
+type addressRequest struct {
+	UserID     int    `json:"user_id" validate:"required,numeric,gt=0"`
+	Label      string `json:"label" validate:"omitempty,max=64"`
+	Recipient  string `json:"recipient" validate:"required,max=255"`
+	Phone      string `json:"phone" validate:"required,max=32"`
+	Street     string `json:"street" validate:"required,max=255"`
+	City       string `json:"city" validate:"required,max=128"`
+	PostalCode string `json:"postal_code" validate:"required,max=16"`
+	Country    string `json:"country" validate:"required,len=2,alpha"`
+	IsDefault  bool   `json:"is_default"`
+}
+
+func (a *AddressHandler) AddressHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case !addressRegExp.MatchString(r.URL.Path):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	case r.Method == http.MethodGet:
+		a.GetAddresses(w, r)
+	case r.Method == http.MethodPost:
+		a.CreateAddress(w, r)
+	case r.Method == http.MethodPut:
+		a.UpdateAddress(w, r)
+	case r.Method == http.MethodDelete:
+		a.DeleteAddress(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	}
+}
+
+// GetAddresses writes the address book of the user_id parameter, oldest first
+func (a *AddressHandler) GetAddresses(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseQueryInt(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate user id exists
+	_, err := UserRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	addresses, total, err := AddressRepo.GetAddressesByUserID(r.Context(), userID, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the addresses", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(addresses), total, func(i int) *pagination.Cursor { return pagination.IDCursor(addresses[i].ID) })
+	writeHTTPPage(r.Context(), w, addresses[start:end], meta)
+}
+
+// CreateAddress adds an address to the address book of the user, the first address of a user is its default
+func (a *AddressHandler) CreateAddress(w http.ResponseWriter, r *http.Request) {
+	address := &addressRequest{}
+	if !readJSONRequest(w, r, address) {
+		return
+	}
+
+	rec := address.record()
+	rec.CreatedAt = time.Now()
+	result, err := AddressRepo.CreateAddress(r.Context(), rec)
+	if err != nil {
+		message := "Internal Server Error"
+		if errors.Is(err, connectors.ErrUserNotFound) {
+			message = "User ID not found"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/user/address?user_id=%d", result.UserID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "Success", headers, result, nil)
+}
+
+// UpdateAddress replaces the address of the id parameter, the orders already shipped to it keep their copy
+func (a *AddressHandler) UpdateAddress(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	address := &addressRequest{}
+	if !readJSONRequest(w, r, address) {
+		return
+	}
+
+	rec := address.record()
+	rec.ID = id
+	result, err := AddressRepo.UpdateAddress(r.Context(), rec)
+	if err != nil {
+		message := "Internal Server Error"
+		if errors.Is(err, connectors.ErrAddressNotFound) {
+			message = "Address ID not found"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, result, nil)
+}
+
+// DeleteAddress removes the address of the id parameter from the address book of the user_id parameter
+func (a *AddressHandler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseQueryInt(w, r, "user_id")
+	if !ok {
+		return
+	}
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := AddressRepo.DeleteAddress(r.Context(), userID, id)
+	if err != nil {
+		message := "Internal Server Error"
+		if errors.Is(err, connectors.ErrAddressNotFound) {
+			message = "Address ID not found"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+}
+
+// record the AddressRecord of the request
+func (a *addressRequest) record() *connectors.AddressRecord {
+	return &connectors.AddressRecord{
+		UserID: a.UserID,
+		Label:  a.Label,
+		PostalAddress: connectors.PostalAddress{
+			Recipient:  a.Recipient,
+			Phone:      a.Phone,
+			Street:     a.Street,
+			City:       a.City,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+		},
+		IsDefault: a.IsDefault,
+	}
+}
+
+// shippingAddress the address an order of the user is shipped to: the address of addressID when it is given,
+// the default address of the user otherwise. An order of a user without addresses is not shipped anywhere,
+// nil is returned then. ErrAddressNotFound is returned when addressID is not in the address book of the user.
+func shippingAddress(ctx context.Context, userID int, addressID int) (*connectors.PostalAddress, error) {
+	if addressID == 0 {
+		address, err := AddressRepo.GetDefaultAddress(ctx, userID)
+		if errors.Is(err, connectors.ErrAddressNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &address.PostalAddress, nil
+	}
+
+	address, err := AddressRepo.GetAddressByID(ctx, addressID)
+	if err != nil {
+		return nil, err
+	}
+	if address.UserID != userID {
+		return nil, connectors.ErrAddressNotFound
+	}
+	return &address.PostalAddress, nil
+}
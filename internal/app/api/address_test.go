@@ -0,0 +1,197 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/user/address"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-country", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1, "recipient": "donny", "phone": "0812", "street": "jl. sudirman 1", "city": "jakarta", "postal_code": "10220", "country": "IDN"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-user-not-found", func(t *testing.T) {
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("CreateAddress", mock.Anything, mock.Anything).Return((*connectors.AddressRecord)(nil), connectors.ErrUserNotFound).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 9, "recipient": "donny", "phone": "0812", "street": "jl. sudirman 1", "city": "jakarta", "postal_code": "10220", "country": "ID"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "User ID not found", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("CreateAddress", mock.Anything, mock.MatchedBy(func(rec *connectors.AddressRecord) bool {
+			return rec.UserID == 1 && rec.Label == "office" && rec.City == "jakarta" && rec.IsDefault && !rec.CreatedAt.IsZero()
+		})).Return(&connectors.AddressRecord{ID: 3, UserID: 1, IsDefault: true}, nil).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1, "label": "office", "recipient": "donny", "phone": "0812", "street": "jl. sudirman 1", "city": "jakarta", "postal_code": "10220", "country": "ID", "is_default": true}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/user/address?user_id=1", recorder.Header().Get("Location"))
+		AddressRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetAddresses(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/user/address"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-user-id-not-present", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint, nil))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1}, nil).Once()
+		UserRepo = UserRepoMock
+
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("GetAddressesByUserID", mock.Anything, 1, mock.Anything).Return([]*connectors.AddressRecord{{ID: 3, UserID: 1}, {ID: 4, UserID: 1}}, 2, nil).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint+"?user_id=1", nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+	})
+}
+
+func TestUpdateAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/user/address?id=3"
+	method := "PUT"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	s := `{"user_id": 1, "recipient": "donny", "phone": "0812", "street": "jl. thamrin 2", "city": "jakarta", "postal_code": "10230", "country": "ID"}`
+
+	t.Run("error-address-not-found", func(t *testing.T) {
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("UpdateAddress", mock.Anything, mock.Anything).Return((*connectors.AddressRecord)(nil), connectors.ErrAddressNotFound).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		updateRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		updateRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, updateRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Address ID not found", resBody.Message)
+		assert.Equal(t, "address_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("UpdateAddress", mock.Anything, mock.MatchedBy(func(rec *connectors.AddressRecord) bool {
+			return rec.ID == 3 && rec.UserID == 1 && rec.Street == "jl. thamrin 2"
+		})).Return(&connectors.AddressRecord{ID: 3, UserID: 1}, nil).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		updateRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		updateRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, updateRequest)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		AddressRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestDeleteAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "DELETE"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-id-not-present", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, "/user/address?user_id=1", nil))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("DeleteAddress", mock.Anything, 1, 3).Return("address deleted successfully", nil).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, "/user/address?user_id=1&id=3", nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "address deleted successfully", resBody.Message)
+	})
+}
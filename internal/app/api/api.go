@@ -2,15 +2,20 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/arieffian/mw-backend-test/internal/config"
 	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/constants/response"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	helper "github.com/arieffian/mw-backend-test/pkg/helpers"
 
 	log "github.com/sirupsen/logrus"
@@ -31,14 +36,48 @@ var (
 
 	// transactionHandler http handler for transaction routing
 	transactionHandler *TransactionHandler
+
+	// userHandler http handler for user routing
+	userHandler *UserHandler
+
+	// couponHandler http handler for coupon routing
+	couponHandler *CouponHandler
+
+	// cartHandler http handler for cart routing
+	cartHandler *CartHandler
+
+	// paymentHandler http handler for payment routing
+	paymentHandler *PaymentHandler
+
+	// addressHandler http handler for address book routing
+	addressHandler *AddressHandler
+
+	// shipmentHandler http handler for shipment routing
+	shipmentHandler *ShipmentHandler
+
+	// categoryHandler http handler for category routing
+	categoryHandler *CategoryHandler
+
+	// variantHandler http handler for product variant routing
+	variantHandler *VariantHandler
+
+	// warehouseHandler http handler for warehouse routing
+	warehouseHandler *WarehouseHandler
+
+	// searchHandler http handler for product search routing
+	searchHandler *SearchHandler
 )
 
 func Start() {
 	configureLogging()
 	log.Infof("Starting api service")
 	InitializeDB()
+	InitializePaymentGateway()
 	InitializeRouter()
 
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	go sweepExpired(sweepCtx, time.Duration(config.GetInt("order.reservation.sweep.interval"))*time.Second)
+
 	var wait time.Duration
 
 	address := fmt.Sprintf("%s:%s", config.Get("server.host"), config.Get("server.port"))
@@ -66,6 +105,7 @@ func Start() {
 
 	// Block until we receive our signal.
 	<-c
+	stopSweep()
 
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(context.Background(), wait)
@@ -81,7 +121,8 @@ func Start() {
 }
 
 func InitializeDB() {
-	if config.Get("db.type") == "mysql" {
+	switch strings.ToUpper(config.Get("db.type")) {
+	case "MYSQL":
 		log.Warnf("Using MYSQL")
 
 		// init repo
@@ -89,13 +130,53 @@ func InitializeDB() {
 		ProductRepo = connectors.GetMySQLDBInstance()
 		TransactionRepo = connectors.GetMySQLDBInstance()
 		UserRepo = connectors.GetMySQLDBInstance()
-	} else {
+		CouponRepo = connectors.GetMySQLDBInstance()
+		CartRepo = connectors.GetMySQLDBInstance()
+		ReservationRepo = connectors.GetMySQLDBInstance()
+		PaymentRepo = connectors.GetMySQLDBInstance()
+		AddressRepo = connectors.GetMySQLDBInstance()
+		ShipmentRepo = connectors.GetMySQLDBInstance()
+		CategoryRepo = connectors.GetMySQLDBInstance()
+		VariantRepo = connectors.GetMySQLDBInstance()
+		WarehouseRepo = connectors.GetMySQLDBInstance()
+		ProductSearcher = connectors.GetMySQLDBInstance()
+	case "INMEMORY":
+		log.Warnf("Using INMEMORY")
+
+		// init repo
+		BrandRepo = connectors.GetInMemoryDBInstance()
+		ProductRepo = connectors.GetInMemoryDBInstance()
+		TransactionRepo = connectors.GetInMemoryDBInstance()
+		UserRepo = connectors.GetInMemoryDBInstance()
+		CouponRepo = connectors.GetInMemoryDBInstance()
+		CartRepo = connectors.GetInMemoryDBInstance()
+		ReservationRepo = connectors.GetInMemoryDBInstance()
+		PaymentRepo = connectors.GetInMemoryDBInstance()
+		AddressRepo = connectors.GetInMemoryDBInstance()
+		ShipmentRepo = connectors.GetInMemoryDBInstance()
+		CategoryRepo = connectors.GetInMemoryDBInstance()
+		VariantRepo = connectors.GetInMemoryDBInstance()
+		WarehouseRepo = connectors.GetInMemoryDBInstance()
+		ProductSearcher = connectors.GetInMemoryDBInstance()
+	default:
 		apiLogger.Fatal("unknown database type")
-		panic(fmt.Sprintf("unknown database type %s. Correct your configuration 'db.type' or env-var 'AAA_DB_TYPE'. allowed values are INMEMORY or MYSQL", config.Get("db.type")))
+		panic(fmt.Sprintf("unknown database type %s. Correct your configuration 'db.type' or env-var 'MW_TEST_DB_TYPE'. allowed values are INMEMORY or MYSQL", config.Get("db.type")))
 	}
 
 }
 
+// InitializePaymentGateway sets the PaymentGateway of the payment.gateway configuration
+func InitializePaymentGateway() {
+	switch strings.ToUpper(config.Get("payment.gateway")) {
+	case "FAKE":
+		log.Warnf("Using FAKE payment gateway")
+		PaymentGateway = connectors.NewFakePaymentGatewayFromConfig()
+	default:
+		apiLogger.Fatal("unknown payment gateway")
+		panic(fmt.Sprintf("unknown payment gateway %s. Correct your configuration 'payment.gateway' or env-var 'MW_TEST_PAYMENT_GATEWAY'. allowed values are FAKE", config.Get("payment.gateway")))
+	}
+}
+
 func configureLogging() {
 	lLevel := config.Get("server.log.level")
 	fmt.Println("Setting log level to ", lLevel)
@@ -134,6 +215,16 @@ func InitializeRouter() {
 	brandHandler = &BrandHandler{}
 	productHandler = &ProductHandler{}
 	transactionHandler = &TransactionHandler{}
+	userHandler = &UserHandler{}
+	couponHandler = &CouponHandler{}
+	cartHandler = &CartHandler{}
+	paymentHandler = &PaymentHandler{}
+	addressHandler = &AddressHandler{}
+	shipmentHandler = &ShipmentHandler{}
+	categoryHandler = &CategoryHandler{}
+	variantHandler = &VariantHandler{}
+	warehouseHandler = &WarehouseHandler{}
+	searchHandler = &SearchHandler{}
 
 	apiRoutes()
 }
@@ -143,5 +234,121 @@ func apiRoutes() {
 	Router.HandleFunc("/brand", brandHandler.BrandHttpHandler)
 	Router.HandleFunc("/product", productHandler.ProductHttpHandler)
 	Router.HandleFunc("/product/brand", productHandler.ProductHttpHandler)
+	Router.HandleFunc("/product/category", productHandler.ProductHttpHandler)
+	Router.HandleFunc("/products", productHandler.ProductHttpHandler)
+	Router.HandleFunc("/product/stock", productHandler.ProductHttpHandler)
+	Router.HandleFunc("/product/stock/history", productHandler.ProductHttpHandler)
+	Router.HandleFunc("/product/variant", variantHandler.VariantHttpHandler)
+	Router.HandleFunc("/warehouse", warehouseHandler.WarehouseHttpHandler)
+	Router.HandleFunc("/warehouse/stock", warehouseHandler.WarehouseHttpHandler)
+	Router.HandleFunc("/warehouse/transfer", warehouseHandler.WarehouseHttpHandler)
+	Router.HandleFunc("/search", searchHandler.SearchHttpHandler)
 	Router.HandleFunc("/order", transactionHandler.TransactionHttpHandler)
+	Router.HandleFunc("/order/cancel", transactionHandler.TransactionHttpHandler)
+	Router.HandleFunc("/order/status", transactionHandler.TransactionHttpHandler)
+	Router.HandleFunc("/order/history", transactionHandler.TransactionHttpHandler)
+	Router.HandleFunc("/order/pay", paymentHandler.PaymentHttpHandler)
+	Router.HandleFunc("/order/refund", paymentHandler.PaymentHttpHandler)
+	Router.HandleFunc("/order/payments", paymentHandler.PaymentHttpHandler)
+	Router.HandleFunc("/payment/webhook", paymentHandler.PaymentHttpHandler)
+	Router.HandleFunc("/order/ship", shipmentHandler.ShipmentHttpHandler)
+	Router.HandleFunc("/order/shipments", shipmentHandler.ShipmentHttpHandler)
+	Router.HandleFunc("/shipment/status", shipmentHandler.ShipmentHttpHandler)
+	Router.HandleFunc("/user", userHandler.UserHttpHandler)
+	Router.HandleFunc("/user/orders", userHandler.UserHttpHandler)
+	Router.HandleFunc("/user/address", addressHandler.AddressHttpHandler)
+	Router.HandleFunc("/category", categoryHandler.CategoryHttpHandler)
+	Router.HandleFunc("/category/tree", categoryHandler.CategoryHttpHandler)
+	Router.HandleFunc("/coupon", couponHandler.CouponHttpHandler)
+	Router.HandleFunc("/cart", cartHandler.CartHttpHandler)
+	Router.HandleFunc("/cart/item", cartHandler.CartHttpHandler)
+	Router.HandleFunc("/cart/checkout", cartHandler.CartHttpHandler)
+}
+
+// parseQueryID parses the mandatory id query parameter, writing the error response when it is missing or not numeric
+func parseQueryID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	query := r.URL.Query()
+
+	//check if id present and greater than 0
+	sID := query.Get("id")
+	if sID == "" {
+		writeHTTPError(r.Context(), w, "Parameter ID not found", connectors.ErrBadRequest)
+		return 0, false
+	}
+
+	//check if id is number or not
+	id, err := strconv.Atoi(sID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Parameter ID is not numeric", connectors.NewBadRequestError(err))
+		return 0, false
+	}
+
+	return id, true
+}
+
+// parseQueryInt parses the mandatory numeric query parameter name, writing the error response when it is missing or not numeric
+func parseQueryInt(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	s := r.URL.Query().Get(name)
+	if s == "" {
+		writeHTTPError(r.Context(), w, fmt.Sprintf("Parameter %s not found", name), connectors.ErrBadRequest)
+		return 0, false
+	}
+
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		writeHTTPError(r.Context(), w, fmt.Sprintf("Parameter %s is not numeric", name), connectors.NewBadRequestError(err))
+		return 0, false
+	}
+
+	return value, true
+}
+
+// parsePage parses the limit, offset, after and before query parameters of a list, writing the error response when they are invalid
+func parsePage(w http.ResponseWriter, r *http.Request) (*pagination.Page, bool) {
+	page, err := pagination.FromQuery(r.URL.Query())
+	if err != nil {
+		writeHTTPError(r.Context(), w, err.Error(), connectors.NewBadRequestError(err))
+		return nil, false
+	}
+
+	return page, true
+}
+
+// readJSONRequest reads the json body of r into req and validates it, writing the error response when it can not be used
+func readJSONRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	return decodeJSONRequest(w, r, req) && validateRequest(w, r, req)
+}
+
+// decodeJSONRequest reads the json body of r into req without validating it, writing the error response when it can not be read
+func decodeJSONRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errJSON := &helper.ErrorJSON{
+			Message:      "Error when parse Body request",
+			Reason:       "internal_error",
+			ErrTittleMsg: "Error parsing request",
+			ErrBodyMsg:   response.Get("general", http.StatusInternalServerError, ""),
+		}
+		helper.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "", nil, nil, errJSON)
+		return false
+	}
+
+	err = json.Unmarshal(body, req)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error processing request", connectors.NewBadRequestError(err))
+		return false
+	}
+
+	return true
+}
+
+// validateRequest validates a request read by decodeJSONRequest, writing the error response when it is invalid
+func validateRequest(w http.ResponseWriter, r *http.Request, req interface{}) bool {
+	err := validate.Struct(req)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Invalid json structure", connectors.NewValidationError(err))
+		return false
+	}
+
+	return true
 }
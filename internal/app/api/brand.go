@@ -1,14 +1,13 @@
 package api
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/arieffian/mw-backend-test/internal/connectors"
-	"github.com/arieffian/mw-backend-test/internal/constants/response"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	"github.com/arieffian/mw-backend-test/pkg/helpers"
-	"github.com/go-playground/validator"
 )
 
 type BrandHandler struct{}
@@ -17,9 +16,14 @@ type brandRequest struct {
 	Name string `json:"name" validate:"required"`
 }
 
+type brandPatchRequest struct {
+	Name *string `json:"name" validate:"omitempty,min=1"`
+}
+
 var (
 	BrandRepo connectors.BrandRepository
-	validate  *validator.Validate
+	// validate is safe for concurrent use and caches the struct metadata, so it is shared by every handler
+	validate = helpers.NewValidator()
 )
 
 func (b *BrandHandler) BrandHttpHandler(w http.ResponseWriter, r *http.Request) {
@@ -27,6 +31,16 @@ func (b *BrandHandler) BrandHttpHandler(w http.ResponseWriter, r *http.Request)
 	switch {
 	case r.Method == http.MethodPost:
 		b.CreateBrand(w, r)
+	case r.Method == http.MethodGet && r.URL.Query().Get("id") == "":
+		b.GetBrands(w, r)
+	case r.Method == http.MethodGet:
+		b.GetBrandByID(w, r)
+	case r.Method == http.MethodPut:
+		b.UpdateBrand(w, r)
+	case r.Method == http.MethodPatch:
+		b.PatchBrand(w, r)
+	case r.Method == http.MethodDelete:
+		b.DeleteBrand(w, r)
 	default:
 		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
 	}
@@ -35,42 +49,142 @@ func (b *BrandHandler) BrandHttpHandler(w http.ResponseWriter, r *http.Request)
 func (b *BrandHandler) CreateBrand(w http.ResponseWriter, r *http.Request) {
 	brand := &brandRequest{}
 
-	//Unmarshal json
-	body, err := ioutil.ReadAll(r.Body)
+	if !readJSONRequest(w, r, brand) {
+		return
+	}
+
+	bRecord := &connectors.BrandRecord{
+		Name: brand.Name,
+	}
+
+	// insert to database
+	result, err := BrandRepo.CreateBrand(r.Context(), bRecord)
+
 	if err != nil {
-		errJSON := &helpers.ErrorJSON{
-			Message:      "Error when parse Body request",
-			Reason:       "internal_error",
-			ErrTittleMsg: "Error parsing request",
-			ErrBodyMsg:   response.Get("general", http.StatusInternalServerError, ""),
-		}
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "", nil, nil, errJSON)
+		writeHTTPError(r.Context(), w, "Internal server error", err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/brand?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "brand created successfully", headers, result, nil)
+}
+
+func (b *BrandHandler) GetBrands(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r)
+	if !ok {
 		return
 	}
 
-	err = json.Unmarshal(body, &brand)
+	brands, total, err := BrandRepo.GetBrands(r.Context(), page.Fetch())
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Error processing request", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Error fetching the brand", err)
 		return
 	}
 
-	//validate json input
-	validate = validator.New()
-	err = validate.Struct(brand)
+	start, end, meta := page.Meta(r.URL, len(brands), total, func(i int) *pagination.Cursor { return pagination.IDCursor(brands[i].ID) })
+	writeHTTPPage(r.Context(), w, brands[start:end], meta)
+}
+
+func (b *BrandHandler) GetBrandByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	brand, err := BrandRepo.GetBrandByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Invalid json structure", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
 		return
 	}
 
-	bRecord := &connectors.BrandRecord{
-		Name: brand.Name,
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, brand, nil)
+}
+
+func (b *BrandHandler) UpdateBrand(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
 	}
 
-	// insert to database
-	result, err := BrandRepo.CreateBrand(r.Context(), bRecord)
+	brand := &brandRequest{}
 
+	if !readJSONRequest(w, r, brand) {
+		return
+	}
+
+	//validate brand id exists
+	bRecord, err := BrandRepo.GetBrandByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Internal server error", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
+		return
+	}
+
+	bRecord.Name = brand.Name
+
+	result, err := BrandRepo.UpdateBrand(r.Context(), bRecord)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Internal server error", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, bRecord, nil)
+}
+
+func (b *BrandHandler) PatchBrand(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	brand := &brandPatchRequest{}
+
+	if !readJSONRequest(w, r, brand) {
+		return
+	}
+
+	//validate brand id exists
+	bRecord, err := BrandRepo.GetBrandByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
+		return
+	}
+
+	// only the fields present in the body are changed
+	if brand.Name != nil {
+		bRecord.Name = *brand.Name
+	}
+
+	result, err := BrandRepo.UpdateBrand(r.Context(), bRecord)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Internal server error", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, bRecord, nil)
+}
+
+func (b *BrandHandler) DeleteBrand(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	//validate brand id exists
+	_, err := BrandRepo.GetBrandByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
+		return
+	}
+
+	result, err := BrandRepo.DeleteBrand(r.Context(), id)
+	if err != nil {
+		message := "Internal server error"
+		if errors.Is(err, connectors.ErrBrandHasProducts) {
+			message = "Brand still has products"
+		}
+		writeHTTPError(r.Context(), w, message, err)
 		return
 	}
 
@@ -3,6 +3,7 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -10,6 +11,7 @@ import (
 	"testing/iotest"
 
 	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	"github.com/arieffian/mw-backend-test/pkg/helpers"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -35,16 +37,16 @@ func TestCreateBrand(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Error processing request",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read: unexpected end of JSON input",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -54,7 +56,7 @@ func TestCreateBrand(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		BrandRepoMock := new(connectors.MockDBType)
-		BrandRepoMock.On("CreateBrand", mock.Anything, mock.Anything).Return("success", nil).Once()
+		BrandRepoMock.On("CreateBrand", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{ID: 4, Name: "predator"}, nil).Once()
 		BrandRepo = BrandRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -69,6 +71,256 @@ func TestCreateBrand(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/brand?id=4", recorder.Header().Get("Location"))
+	})
+}
+
+func TestGetBrands(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/brand"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("success", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrands", mock.Anything, mock.Anything).Return([]*connectors.BrandRecord{{ID: 1, Name: "apple"}}, 1, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		BrandRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-page", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrands", mock.Anything, &pagination.Page{Limit: 3, After: pagination.IDCursor(1)}).Return([]*connectors.BrandRecord{
+			{ID: 2, Name: "lenovo"},
+			{ID: 3, Name: "asus"},
+			{ID: 4, Name: "acer"},
+		}, 5, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?limit=2&after="+pagination.IDCursor(1).Encode(), nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &struct {
+			Data []*connectors.BrandRecord `json:"data"`
+			Meta *pagination.Meta          `json:"meta"`
+		}{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+		assert.Equal(t, &pagination.Meta{
+			Total: 5,
+			Limit: 2,
+			Next:  "/brand?after=" + pagination.IDCursor(3).Encode() + "&limit=2",
+			Prev:  "/brand?before=" + pagination.IDCursor(2).Encode() + "&limit=2",
+		}, resBody.Meta)
+		BrandRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-limit-out-of-range", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?limit=0", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Parameter limit must be between 1 and 100", resBody.Message)
+	})
+
+	t.Run("error-internal", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrands", mock.Anything, mock.Anything).Return([]*connectors.BrandRecord(nil), 0, fmt.Errorf("connection refused")).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		// the cause of an internal error is logged, never sent to the client
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		assert.Equal(t, &helpers.ErrorJSON{
+			Message:      "Internal Server Error",
+			Reason:       "internal_error",
+			ErrTittleMsg: "Internal Server Error",
+			ErrBodyMsg:   "Internal server error",
+		}, resBody.Error)
+	})
+}
+
+func TestGetBrandByID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/brand"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-query-param-not-numeric", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		q := createRequest.URL.Query()
+		q.Add("id", "a")
+		createRequest.URL.RawQuery = q.Encode()
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		httpCode := http.StatusBadRequest
+		assert.Equal(t, httpCode, recorder.Code)
+		assert.Equal(t, "Parameter ID is not numeric", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1, Name: "apple"}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		q := createRequest.URL.Query()
+		q.Add("id", "1")
+		createRequest.URL.RawQuery = q.Encode()
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+	})
+}
+
+func TestUpdateBrand(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/brand?id=1"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPut, urlEndPoint, bytes.NewReader([]byte(`{"name": ""}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+		assert.Equal(t, []*helpers.FieldErrorJSON{
+			{Field: "name", Tag: "required", Param: "", Message: "name is a required field"},
+		}, resBody.Error.Fields)
+	})
+
+	t.Run("success-put", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1, Name: "apple"}, nil).Once()
+		BrandRepoMock.On("UpdateBrand", mock.Anything, &connectors.BrandRecord{ID: 1, Name: "apple inc"}).Return("success", nil).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPut, urlEndPoint, bytes.NewReader([]byte(`{"name": "apple inc"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		BrandRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-patch-without-fields", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1, Name: "apple"}, nil).Once()
+		BrandRepoMock.On("UpdateBrand", mock.Anything, &connectors.BrandRecord{ID: 1, Name: "apple"}).Return("success", nil).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		BrandRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestDeleteBrand(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/brand?id=1"
+	method := "DELETE"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-brand-has-products", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1}, nil).Once()
+		BrandRepoMock.On("DeleteBrand", mock.Anything, 1).Return("", connectors.ErrBrandHasProducts).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Brand still has products", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1}, nil).Once()
+		BrandRepoMock.On("DeleteBrand", mock.Anything, 1).Return("success", nil).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
 		if recorder.Code != http.StatusOK {
 			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
 			t.FailNow()
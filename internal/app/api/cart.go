@@ -0,0 +1,205 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type CartHandler struct{}
+
+var (
+	CartRepo connectors.CartRepository
+
+	cartRegExp         = regexp.MustCompile(`^\/cart[\/]*$`)
+	cartItemRegExp     = regexp.MustCompile(`^\/cart\/item[\/]*$`)
+	cartCheckoutRegExp = regexp.MustCompile(`^\/cart\/checkout[\/]*$`)
+)
+
+type cartItemRequest struct {
+	UserID    int `json:"user_id" validate:"required,numeric,gt=0"`
+	ProductID int `json:"product_id" validate:"required,numeric,gt=0"`
+	Qty       int `json:"qty" validate:"required,numeric,gt=0"`
+}
+
+type cartCheckoutRequest struct {
+	UserID     int    `json:"user_id" validate:"required,numeric,gt=0"`
+	CouponCode string `json:"coupon_code" validate:"omitempty,max=64"`
+
+	// AddressID the address of the user the order is shipped to, its default address when it is not given
+	AddressID int `json:"address_id" validate:"omitempty,numeric,gt=0"`
+}
+
+func (c *CartHandler) CartHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && cartRegExp.MatchString(r.URL.Path):
+		c.GetCart(w, r)
+	case r.Method == http.MethodPost && cartItemRegExp.MatchString(r.URL.Path):
+		c.AddCartItem(w, r)
+	case r.Method == http.MethodPut && cartItemRegExp.MatchString(r.URL.Path):
+		c.UpdateCartItem(w, r)
+	case r.Method == http.MethodDelete && cartItemRegExp.MatchString(r.URL.Path):
+		c.RemoveCartItem(w, r)
+	case r.Method == http.MethodPost && cartCheckoutRegExp.MatchString(r.URL.Path):
+		c.CheckoutCart(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	}
+}
+
+// GetCart writes the cart of the user_id parameter with the current price and stock of every item
+func (c *CartHandler) GetCart(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseQueryInt(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	//validate user id exists
+	_, err := UserRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	cart, err := CartRepo.GetCart(r.Context(), userID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the cart", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, cart, nil)
+}
+
+// AddCartItem adds qty units of the product to the cart of the user
+func (c *CartHandler) AddCartItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := readCartItemRequest(w, r)
+	if !ok {
+		return
+	}
+
+	cart, err := CartRepo.AddCartItem(r.Context(), item)
+	if err != nil {
+		writeCartItemError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, cart, nil)
+}
+
+// UpdateCartItem replaces the qty of a product in the cart of the user
+func (c *CartHandler) UpdateCartItem(w http.ResponseWriter, r *http.Request) {
+	item, ok := readCartItemRequest(w, r)
+	if !ok {
+		return
+	}
+
+	cart, err := CartRepo.UpdateCartItem(r.Context(), item)
+	if err != nil {
+		writeCartItemError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, cart, nil)
+}
+
+// RemoveCartItem removes the product_id parameter from the cart of the user_id parameter
+func (c *CartHandler) RemoveCartItem(w http.ResponseWriter, r *http.Request) {
+	userID, ok := parseQueryInt(w, r, "user_id")
+	if !ok {
+		return
+	}
+	productID, ok := parseQueryInt(w, r, "product_id")
+	if !ok {
+		return
+	}
+
+	cart, err := CartRepo.RemoveCartItem(r.Context(), userID, productID)
+	if err != nil {
+		writeCartItemError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, cart, nil)
+}
+
+// readCartItemRequest reads and validates the cart item of the body and checks its user exists,
+// writing the error response when it can not be used
+func readCartItemRequest(w http.ResponseWriter, r *http.Request) (*connectors.CartItemRecord, bool) {
+	item := &cartItemRequest{}
+
+	if !readJSONRequest(w, r, item) {
+		return nil, false
+	}
+
+	//validate user id exists
+	_, err := UserRepo.GetUserByID(r.Context(), item.UserID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return nil, false
+	}
+
+	return &connectors.CartItemRecord{
+		UserID:    item.UserID,
+		ProductID: item.ProductID,
+		Qty:       item.Qty,
+		AddedAt:   time.Now(),
+	}, true
+}
+
+// writeCartItemError writes the failure of changing an item of a cart
+func writeCartItemError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Internal Server Error"
+	switch {
+	case errors.Is(err, connectors.ErrProductNotFound):
+		message = "Product ID not found"
+	case errors.Is(err, connectors.ErrCartItemNotFound):
+		message = "Product is not in the cart"
+	case errors.Is(err, connectors.ErrMoneyOverflow):
+		message = "Cart amount is too large"
+	}
+	writeHTTPError(r.Context(), w, message, err)
+}
+
+// CheckoutCart orders every item in the cart of the user and empties the cart, it responds like POST /order
+func (c *CartHandler) CheckoutCart(w http.ResponseWriter, r *http.Request) {
+	checkout := &cartCheckoutRequest{}
+
+	if !readJSONRequest(w, r, checkout) {
+		return
+	}
+
+	//validate user id exists
+	_, err := UserRepo.GetUserByID(r.Context(), checkout.UserID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	address, err := shippingAddress(r.Context(), checkout.UserID, checkout.AddressID)
+	if err != nil {
+		writeCreateTransactionError(w, r, err)
+		return
+	}
+
+	result, err := CartRepo.CheckoutCart(r.Context(), &connectors.TransactionRecord{
+		UserID:          checkout.UserID,
+		Date:            time.Now(),
+		CouponCode:      checkout.CouponCode,
+		ShippingAddress: address,
+	})
+	if err != nil {
+		writeCreateTransactionError(w, r, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/order?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "Success", headers, result, nil)
+}
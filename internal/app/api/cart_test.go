@@ -0,0 +1,249 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetCart(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/cart"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-query-param-not-present", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Parameter user_id not found", resBody.Message)
+	})
+
+	t.Run("error-user-not-found", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, connectors.ErrUserNotFound).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?user_id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "user_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("GetCart", mock.Anything, 1).Return(&connectors.CartRecord{UserID: 1, Items: []*connectors.CartItemRecord{}, Subtotal: connectors.NewMoney(0, connectors.CurrencyIDR)}, nil).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?user_id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		CartRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestCartItem(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/cart/item"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest("POST", urlEndPoint, bytes.NewReader([]byte(`{"user_id": 1, "product_id": 1, "qty": 0}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("AddCartItem", mock.Anything, mock.Anything).Return((*connectors.CartRecord)(nil), connectors.ErrProductNotFound).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest("POST", urlEndPoint, bytes.NewReader([]byte(`{"user_id": 1, "product_id": 9, "qty": 1}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Product ID not found", resBody.Message)
+		assert.Equal(t, "product_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("error-cart-item-not-found", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("UpdateCartItem", mock.Anything, mock.Anything).Return((*connectors.CartRecord)(nil), connectors.ErrCartItemNotFound).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest("PUT", urlEndPoint, bytes.NewReader([]byte(`{"user_id": 1, "product_id": 2, "qty": 3}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Product is not in the cart", resBody.Message)
+		assert.Equal(t, "cart_item_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success-add", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("AddCartItem", mock.Anything, mock.MatchedBy(func(rec *connectors.CartItemRecord) bool {
+			return rec.UserID == 1 && rec.ProductID == 2 && rec.Qty == 3
+		})).Return(&connectors.CartRecord{UserID: 1}, nil).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest("POST", urlEndPoint, bytes.NewReader([]byte(`{"user_id": 1, "product_id": 2, "qty": 3}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		CartRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-remove", func(t *testing.T) {
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("RemoveCartItem", mock.Anything, 1, 2).Return(&connectors.CartRecord{UserID: 1}, nil).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest("DELETE", urlEndPoint+"?user_id=1&product_id=2", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		CartRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestCheckoutCart(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/cart/checkout"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-cart-empty", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("GetDefaultAddress", mock.Anything, 1).Return((*connectors.AddressRecord)(nil), connectors.ErrAddressNotFound).Once()
+		AddressRepo = AddressRepoMock
+
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("CheckoutCart", mock.Anything, mock.Anything).Return((*connectors.TransactionRecord)(nil), connectors.ErrCartEmpty).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"user_id": 1}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Cart is empty", resBody.Message)
+		assert.Equal(t, "cart_empty", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("GetAddressByID", mock.Anything, 3).Return(&connectors.AddressRecord{ID: 3, UserID: 1, PostalAddress: connectors.PostalAddress{City: "surabaya"}}, nil).Once()
+		AddressRepo = AddressRepoMock
+
+		CartRepoMock := new(connectors.MockDBType)
+		CartRepoMock.On("CheckoutCart", mock.Anything, mock.MatchedBy(func(rec *connectors.TransactionRecord) bool {
+			return rec.UserID == 1 && rec.CouponCode == "HEMAT10" && rec.ShippingAddress != nil && rec.ShippingAddress.City == "surabaya"
+		})).Return(&connectors.TransactionRecord{ID: 7, UserID: 1}, nil).Once()
+		CartRepo = CartRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"user_id": 1, "coupon_code": "HEMAT10", "address_id": 3}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/order?id=7", recorder.Header().Get("Location"))
+		CartRepoMock.AssertExpectations(t)
+	})
+}
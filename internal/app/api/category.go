@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type CategoryHandler struct{}
+
+var (
+	CategoryRepo connectors.CategoryRepository
+
+	categoryRegExp     = regexp.MustCompile(`^\/category[\/]*$`)
+	categoryTreeRegExp = regexp.MustCompile(`^\/category\/tree[\/]*$`)
+)
+
+type categoryRequest struct {
+	// ParentID the category it is nested in, omitted for a root category
+	ParentID int    `json:"parent_id" validate:"omitempty,numeric,gt=0"`
+	Name     string `json:"name" validate:"required,max=255"`
+}
+
+// categoryNode a category of the tree with the categories nested in it
+type categoryNode struct {
+	*connectors.CategoryRecord
+	Children []*categoryNode
+}
+
+func (c *CategoryHandler) CategoryHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && categoryTreeRegExp.MatchString(r.URL.Path):
+		c.GetCategoryTree(w, r)
+	case categoryTreeRegExp.MatchString(r.URL.Path):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	case !categoryRegExp.MatchString(r.URL.Path):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	case r.Method == http.MethodPost:
+		c.CreateCategory(w, r)
+	case r.Method == http.MethodGet && r.URL.Query().Get("id") == "":
+		c.GetCategories(w, r)
+	case r.Method == http.MethodGet:
+		c.GetCategoryByID(w, r)
+	case r.Method == http.MethodPut:
+		c.UpdateCategory(w, r)
+	case r.Method == http.MethodDelete:
+		c.DeleteCategory(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	}
+}
+
+// CreateCategory adds a category under the category of parent_id, or at the root without one
+func (c *CategoryHandler) CreateCategory(w http.ResponseWriter, r *http.Request) {
+	category := &categoryRequest{}
+	if !readJSONRequest(w, r, category) {
+		return
+	}
+
+	if !validateCategoryParent(w, r, category.ParentID) {
+		return
+	}
+
+	result, err := CategoryRepo.CreateCategory(r.Context(), &connectors.CategoryRecord{
+		ParentID: category.ParentID,
+		Name:     category.Name,
+	})
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Internal server error", err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/category?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "category created successfully", headers, result, nil)
+}
+
+// GetCategories writes a page of the categories ordered by id, each with the id of its parent
+func (c *CategoryHandler) GetCategories(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	categories, total, err := CategoryRepo.GetCategories(r.Context(), page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the category", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(categories), total, func(i int) *pagination.Cursor { return pagination.IDCursor(categories[i].ID) })
+	writeHTTPPage(r.Context(), w, categories[start:end], meta)
+}
+
+// GetCategoryTree writes the category tree, every root category with its subcategories nested in it
+func (c *CategoryHandler) GetCategoryTree(w http.ResponseWriter, r *http.Request) {
+	categories, _, err := CategoryRepo.GetCategories(r.Context(), nil)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the category", err)
+		return
+	}
+
+	roots, _ := categoryTree(categories)
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, roots, nil)
+}
+
+// GetCategoryByID writes the category of the id parameter with its subcategories nested in it
+func (c *CategoryHandler) GetCategoryByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	categories, _, err := CategoryRepo.GetCategories(r.Context(), nil)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the category", err)
+		return
+	}
+
+	_, nodes := categoryTree(categories)
+	node, ok := nodes[id]
+	if !ok {
+		writeHTTPError(r.Context(), w, "Category ID not found", connectors.ErrCategoryNotFound)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, node, nil)
+}
+
+// UpdateCategory renames the category of the id parameter and moves it under the category of parent_id,
+// or to the root without one. Its subcategories and products move with it.
+func (c *CategoryHandler) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	category := &categoryRequest{}
+	if !readJSONRequest(w, r, category) {
+		return
+	}
+
+	//validate category id exists
+	cRecord, err := CategoryRepo.GetCategoryByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Category ID not found", err)
+		return
+	}
+
+	if !validateCategoryParent(w, r, category.ParentID) {
+		return
+	}
+
+	cRecord.ParentID = category.ParentID
+	cRecord.Name = category.Name
+
+	result, err := CategoryRepo.UpdateCategory(r.Context(), cRecord)
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrCategoryNotFound):
+			message = "Category ID not found"
+		case errors.Is(err, connectors.ErrInvalidCategoryParent):
+			message = "Category can not be moved under itself or its subcategories"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, cRecord, nil)
+}
+
+// DeleteCategory removes the category of the id parameter, its products stay in the catalog without it
+func (c *CategoryHandler) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := CategoryRepo.DeleteCategory(r.Context(), id)
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrCategoryNotFound):
+			message = "Category ID not found"
+		case errors.Is(err, connectors.ErrCategoryHasChildren):
+			message = "Category still has subcategories"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+}
+
+// validateCategoryParent checks the parent category of a request exists when it has one,
+// writing the error response when it does not
+func validateCategoryParent(w http.ResponseWriter, r *http.Request, parentID int) bool {
+	if parentID == 0 {
+		return true
+	}
+
+	_, err := CategoryRepo.GetCategoryByID(r.Context(), parentID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Parent category ID not found", err)
+		return false
+	}
+	return true
+}
+
+// categoryTree nests the categories ordered by id into their parents, and returns the root categories
+// along with every node keyed by category id
+func categoryTree(categories []*connectors.CategoryRecord) ([]*categoryNode, map[int]*categoryNode) {
+	nodes := make(map[int]*categoryNode, len(categories))
+	for _, category := range categories {
+		nodes[category.ID] = &categoryNode{CategoryRecord: category, Children: make([]*categoryNode, 0)}
+	}
+
+	roots := make([]*categoryNode, 0)
+	for _, category := range categories {
+		node := nodes[category.ID]
+		if parent, ok := nodes[category.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, nodes
+}
+
+// productCategories the breadcrumbs of the categories of a product request, nil when it has none.
+// ErrCategoryNotFound is returned when one of them does not exist.
+func productCategories(ctx context.Context, categoryIDs []int) ([]connectors.CategoryPath, error) {
+	if len(categoryIDs) == 0 {
+		return nil, nil
+	}
+	return CategoryRepo.GetCategoryPaths(ctx, categoryIDs)
+}
+
+// categoryPathIDs the id of the category every breadcrumb of paths leads to, nil when there are none
+func categoryPathIDs(paths []connectors.CategoryPath) []int {
+	if len(paths) == 0 {
+		return nil
+	}
+	ids := make([]int, 0, len(paths))
+	for _, path := range paths {
+		ids = append(ids, path.Category().ID)
+	}
+	return ids
+}
@@ -0,0 +1,261 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/category"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"parent_id": 1}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+
+	t.Run("error-parent-not-found", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 100).Return((*connectors.CategoryRecord)(nil), connectors.ErrCategoryNotFound).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"parent_id": 100, "name": "laptops"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Parent category ID not found", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 1).Return(&connectors.CategoryRecord{ID: 1, Name: "computers"}, nil).Once()
+		CategoryRepoMock.On("CreateCategory", mock.Anything, &connectors.CategoryRecord{ParentID: 1, Name: "laptops"}).
+			Return(&connectors.CategoryRecord{ID: 2, ParentID: 1, Name: "laptops"}, nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"parent_id": 1, "name": "laptops"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/category?id=2", recorder.Header().Get("Location"))
+		CategoryRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetCategories(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/category"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	categories := []*connectors.CategoryRecord{
+		{ID: 1, Name: "computers"},
+		{ID: 2, ParentID: 1, Name: "laptops"},
+		{ID: 3, ParentID: 2, Name: "gaming"},
+		{ID: 4, Name: "phones"},
+	}
+
+	t.Run("success-page", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategories", mock.Anything, &pagination.Page{Limit: 3, After: pagination.IDCursor(1)}).Return(categories[1:], 4, nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint+"?limit=2&after="+pagination.IDCursor(1).Encode(), nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &struct {
+			Data []*connectors.CategoryRecord `json:"data"`
+			Meta *pagination.Meta             `json:"meta"`
+		}{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, categories[1:3], resBody.Data)
+		assert.Equal(t, &pagination.Meta{
+			Total: 4,
+			Limit: 2,
+			Next:  "/category?after=" + pagination.IDCursor(3).Encode() + "&limit=2",
+			Prev:  "/category?before=" + pagination.IDCursor(2).Encode() + "&limit=2",
+		}, resBody.Meta)
+		CategoryRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-limit-out-of-range", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint+"?limit=0", nil))
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("success-tree", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategories", mock.Anything, (*pagination.Page)(nil)).Return(categories, len(categories), nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint+"/tree", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `[
+			{"ID": 1, "ParentID": 0, "Name": "computers", "Children": [
+				{"ID": 2, "ParentID": 1, "Name": "laptops", "Children": [
+					{"ID": 3, "ParentID": 2, "Name": "gaming", "Children": []}
+				]}
+			]},
+			{"ID": 4, "ParentID": 0, "Name": "phones", "Children": []}
+		]`, string(jsonData(t, recorder)))
+	})
+
+	t.Run("success-subtree", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategories", mock.Anything, (*pagination.Page)(nil)).Return(categories, len(categories), nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint+"?id=2", nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.JSONEq(t, `{"ID": 2, "ParentID": 1, "Name": "laptops", "Children": [
+			{"ID": 3, "ParentID": 2, "Name": "gaming", "Children": []}
+		]}`, string(jsonData(t, recorder)))
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategories", mock.Anything, (*pagination.Page)(nil)).Return(categories, len(categories), nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint+"?id=100", nil))
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+}
+
+func TestUpdateCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/category?id=1"
+	method := "PUT"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-parent", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 1).Return(&connectors.CategoryRecord{ID: 1, Name: "computers"}, nil).Once()
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 3).Return(&connectors.CategoryRecord{ID: 3, ParentID: 2, Name: "gaming"}, nil).Once()
+		CategoryRepoMock.On("UpdateCategory", mock.Anything, &connectors.CategoryRecord{ID: 1, ParentID: 3, Name: "computers"}).Return("", connectors.ErrInvalidCategoryParent).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"parent_id": 3, "name": "computers"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Category can not be moved under itself or its subcategories", resBody.Message)
+	})
+
+	t.Run("success-move-to-root", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 1).Return(&connectors.CategoryRecord{ID: 1, ParentID: 4, Name: "computers"}, nil).Once()
+		CategoryRepoMock.On("UpdateCategory", mock.Anything, &connectors.CategoryRecord{ID: 1, Name: "pc"}).Return("category updated successfully", nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"name": "pc"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		CategoryRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestDeleteCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/category?id=1"
+	method := "DELETE"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-has-children", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("DeleteCategory", mock.Anything, 1).Return("", connectors.ErrCategoryHasChildren).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint, nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Category still has subcategories", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("DeleteCategory", mock.Anything, 1).Return("category deleted successfully", nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint, nil))
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		CategoryRepoMock.AssertExpectations(t)
+	})
+}
+
+// jsonData the raw data of the response recorded by recorder
+func jsonData(t *testing.T, recorder *httptest.ResponseRecorder) json.RawMessage {
+	resBody := &struct {
+		Data json.RawMessage
+	}{}
+	err := json.Unmarshal(recorder.Body.Bytes(), resBody)
+	assert.Nil(t, err)
+	return resBody.Data
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type CouponHandler struct{}
+
+var (
+	CouponRepo connectors.CouponRepository
+)
+
+type couponRequest struct {
+	Code              string     `json:"code" validate:"required,max=64"`
+	Type              string     `json:"type" validate:"required,oneof=percentage fixed buy_x_get_y"`
+	Value             int        `json:"value" validate:"gte=0"`
+	Currency          string     `json:"currency" validate:"omitempty,len=3,alpha"`
+	BuyQty            int        `json:"buy_qty" validate:"gte=0"`
+	GetQty            int        `json:"get_qty" validate:"gte=0"`
+	BrandID           int        `json:"brand_id" validate:"gte=0"`
+	MinSpend          int        `json:"min_spend" validate:"gte=0"`
+	UsageLimit        int        `json:"usage_limit" validate:"gte=0"`
+	UsageLimitPerUser int        `json:"usage_limit_per_user" validate:"gte=0"`
+	StartsAt          *time.Time `json:"starts_at"`
+	EndsAt            *time.Time `json:"ends_at"`
+}
+
+func (c *CouponHandler) CouponHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case r.Method == http.MethodPost:
+		c.CreateCoupon(w, r)
+	case r.Method == http.MethodGet && r.URL.Query().Get("id") == "":
+		c.GetCoupons(w, r)
+	case r.Method == http.MethodGet:
+		c.GetCouponByID(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	}
+}
+
+func (c *CouponHandler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+	coupon := &couponRequest{}
+
+	if !readJSONRequest(w, r, coupon) {
+		return
+	}
+
+	//validate brand id exists, zero means the coupon applies to every brand
+	if coupon.BrandID != 0 {
+		_, err := BrandRepo.GetBrandByID(r.Context(), coupon.BrandID)
+		if err != nil {
+			writeHTTPError(r.Context(), w, "Brand ID not found", err)
+			return
+		}
+	}
+
+	cRecord := &connectors.CouponRecord{
+		Code:              coupon.Code,
+		Type:              coupon.Type,
+		Value:             coupon.Value,
+		Currency:          strings.ToUpper(coupon.Currency),
+		BuyQty:            coupon.BuyQty,
+		GetQty:            coupon.GetQty,
+		BrandID:           coupon.BrandID,
+		MinSpend:          coupon.MinSpend,
+		UsageLimit:        coupon.UsageLimit,
+		UsageLimitPerUser: coupon.UsageLimitPerUser,
+	}
+	if coupon.StartsAt != nil {
+		cRecord.StartsAt = *coupon.StartsAt
+	}
+	if coupon.EndsAt != nil {
+		cRecord.EndsAt = *coupon.EndsAt
+	}
+
+	// insert to database
+	result, err := CouponRepo.CreateCoupon(r.Context(), cRecord)
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrDuplicateCouponCode):
+			message = "Coupon code is already used"
+		case errors.Is(err, connectors.ErrValidation):
+			message = "Invalid coupon"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/coupon?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "coupon created successfully", headers, result, nil)
+}
+
+func (c *CouponHandler) GetCoupons(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	coupons, total, err := CouponRepo.GetCoupons(r.Context(), page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the coupon", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(coupons), total, func(i int) *pagination.Cursor { return pagination.IDCursor(coupons[i].ID) })
+	writeHTTPPage(r.Context(), w, coupons[start:end], meta)
+}
+
+func (c *CouponHandler) GetCouponByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	coupon, err := CouponRepo.GetCouponByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Coupon ID not found", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, coupon, nil)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateCoupon(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/coupon"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SAVE10", "type": "free_shipping"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+		assert.Equal(t, "type", resBody.Error.Fields[0].Field)
+	})
+
+	t.Run("error-brand-not-found", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 9).Return(&connectors.BrandRecord{}, connectors.ErrBrandNotFound).Once()
+		BrandRepo = BrandRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SAVE10", "type": "percentage", "value": 10, "brand_id": 9}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("error-invalid-coupon", func(t *testing.T) {
+		CouponRepoMock := new(connectors.MockDBType)
+		CouponRepoMock.On("CreateCoupon", mock.Anything, mock.Anything).Return((*connectors.CouponRecord)(nil), connectors.NewValidationError(assert.AnError)).Once()
+		CouponRepo = CouponRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SAVE10", "type": "percentage", "value": 0}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid coupon", resBody.Message)
+	})
+
+	t.Run("error-duplicate-code", func(t *testing.T) {
+		CouponRepoMock := new(connectors.MockDBType)
+		CouponRepoMock.On("CreateCoupon", mock.Anything, mock.Anything).Return((*connectors.CouponRecord)(nil), connectors.ErrDuplicateCouponCode).Once()
+		CouponRepo = CouponRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SAVE10", "type": "percentage", "value": 10}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Coupon code is already used", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var saved *connectors.CouponRecord
+		CouponRepoMock := new(connectors.MockDBType)
+		CouponRepoMock.On("CreateCoupon", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { saved = args.Get(1).(*connectors.CouponRecord) }).
+			Return(&connectors.CouponRecord{ID: 4, Code: "B2G1"}, nil).Once()
+		CouponRepo = CouponRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "b2g1", "type": "buy_x_get_y", "buy_qty": 2, "get_qty": 1, "usage_limit_per_user": 1, "ends_at": "2021-12-31T23:59:59Z"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		assert.Equal(t, "/coupon?id=4", recorder.Header().Get("Location"))
+		assert.Equal(t, 2, saved.BuyQty)
+		assert.Equal(t, 1, saved.UsageLimitPerUser)
+		assert.True(t, saved.StartsAt.IsZero())
+		assert.Equal(t, time.Date(2021, time.December, 31, 23, 59, 59, 0, time.UTC), saved.EndsAt)
+	})
+}
+
+func TestGetCoupon(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-coupon-not-found", func(t *testing.T) {
+		CouponRepoMock := new(connectors.MockDBType)
+		CouponRepoMock.On("GetCouponByID", mock.Anything, 7).Return((*connectors.CouponRecord)(nil), connectors.ErrCouponNotFound).Once()
+		CouponRepo = CouponRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, "/coupon?id=7", nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Coupon ID not found", resBody.Message)
+	})
+
+	t.Run("success-list", func(t *testing.T) {
+		CouponRepoMock := new(connectors.MockDBType)
+		CouponRepoMock.On("GetCoupons", mock.Anything, mock.Anything).Return([]*connectors.CouponRecord{{ID: 1, Code: "SAVE10"}, {ID: 2, Code: "B2G1"}}, 2, nil).Once()
+		CouponRepo = CouponRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, "/coupon", nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+	})
+}
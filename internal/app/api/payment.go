@@ -0,0 +1,327 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/constants/response"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type PaymentHandler struct{}
+
+var (
+	PaymentRepo    connectors.PaymentRepository
+	PaymentGateway connectors.PaymentGateway
+
+	paymentPayRegExp     = regexp.MustCompile(`^\/order\/pay[\/]*$`)
+	paymentRefundRegExp  = regexp.MustCompile(`^\/order\/refund[\/]*$`)
+	paymentListRegExp    = regexp.MustCompile(`^\/order\/payments[\/]*$`)
+	paymentWebhookRegExp = regexp.MustCompile(`^\/payment\/webhook[\/]*$`)
+)
+
+// paymentSignatureHeader the signature of the payload of a payment gateway webhook
+const paymentSignatureHeader = "X-Payment-Signature"
+
+type paymentRequest struct {
+	TransactionID int    `json:"transaction_id" validate:"required,numeric,gt=0"`
+	Token         string `json:"token" validate:"omitempty,max=255"`
+	Actor         string `json:"actor" validate:"required"`
+}
+
+type refundRequest struct {
+	TransactionID int    `json:"transaction_id" validate:"required,numeric,gt=0"`
+	Actor         string `json:"actor" validate:"required"`
+}
+
+func (p *PaymentHandler) PaymentHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && paymentPayRegExp.MatchString(r.URL.Path):
+		p.PayTransaction(w, r)
+	case r.Method == http.MethodPost && paymentRefundRegExp.MatchString(r.URL.Path):
+		p.RefundTransaction(w, r)
+	case r.Method == http.MethodGet && paymentListRegExp.MatchString(r.URL.Path):
+		p.GetTransactionPayments(w, r)
+	case r.Method == http.MethodPost && paymentWebhookRegExp.MatchString(r.URL.Path):
+		p.PaymentWebhook(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	}
+}
+
+// PayTransaction authorizes the grand total of a pending order with the payment gateway and captures it, which pays the order.
+// The payment is stored before the gateway is asked, so a second payment of the order is refused instead of charged too.
+// A payment the gateway answers later is accepted as pending, its outcome comes through the webhook.
+func (p *PaymentHandler) PayTransaction(w http.ResponseWriter, r *http.Request) {
+	fLog := apiLogger.WithField("func", "PayTransaction")
+
+	pay := &paymentRequest{}
+	if !readJSONRequest(w, r, pay) {
+		return
+	}
+
+	transaction, err := TransactionRepo.GetTransactionByTransactionID(r.Context(), pay.TransactionID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Transaction ID not found", err)
+		return
+	}
+
+	payment, err := PaymentRepo.CreatePayment(r.Context(), &connectors.PaymentRecord{
+		TransactionID: transaction.ID,
+		Gateway:       PaymentGateway.Name(),
+		Amount:        transaction.GrandTotal,
+		Status:        connectors.PaymentStatusPending,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		message := "Error saving the payment"
+		switch {
+		case errors.Is(err, connectors.ErrInvalidStatusTransition):
+			message = "Transaction can not be paid"
+		case errors.Is(err, connectors.ErrPaymentInProgress):
+			message = "Transaction already has a payment in progress"
+		case errors.Is(err, connectors.ErrReservationExpired):
+			message = "Reservation of the transaction expired"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	authorized, err := PaymentGateway.Authorize(r.Context(), &connectors.PaymentRequest{
+		TransactionID: transaction.ID,
+		Amount:        transaction.GrandTotal,
+		Token:         pay.Token,
+	})
+	if err != nil {
+		// the order may be paid again
+		_, failErr := PaymentRepo.UpdatePaymentStatus(r.Context(), payment.ID, connectors.PaymentStatusFailed, pay.Actor)
+		if failErr != nil {
+			fLog.Errorf("PaymentRepo.UpdatePaymentStatus got %s", failErr.Error())
+		}
+		writeHTTPError(r.Context(), w, "Payment gateway is not available", err)
+		return
+	}
+
+	payment, err = PaymentRepo.UpdatePaymentAuthorization(r.Context(), payment.ID, authorized)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error saving the payment", err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/order/payments?id=%d", transaction.ID),
+	}
+	switch payment.Status {
+	case connectors.PaymentStatusFailed:
+		writeHTTPError(r.Context(), w, "Payment is declined", connectors.ErrPaymentDeclined)
+		return
+	case connectors.PaymentStatusPending:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusAccepted, "Payment is pending", headers, payment, nil)
+		return
+	}
+
+	payment, err = captureAuthorizedPayment(r.Context(), payment, pay.Actor)
+	if err != nil {
+		writePaymentError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", headers, payment, nil)
+}
+
+// captureAuthorizedPayment takes the amount held by an authorized payment at the gateway and records it like capturePayment.
+// The payment fails when the gateway can not capture it, the customer may pay the order again.
+func captureAuthorizedPayment(ctx context.Context, payment *connectors.PaymentRecord, actor string) (*connectors.PaymentRecord, error) {
+	fLog := apiLogger.WithField("func", "captureAuthorizedPayment")
+
+	err := PaymentGateway.Capture(ctx, payment.Reference, payment.Amount)
+	if err != nil {
+		fLog.Errorf("PaymentGateway.Capture of payment %d got %s", payment.ID, err.Error())
+		_, failErr := PaymentRepo.UpdatePaymentStatus(ctx, payment.ID, connectors.PaymentStatusFailed, actor)
+		if failErr != nil {
+			fLog.Errorf("PaymentRepo.UpdatePaymentStatus got %s", failErr.Error())
+		}
+		return nil, err
+	}
+
+	return capturePayment(ctx, payment, actor)
+}
+
+// capturePayment records the payment as captured, which pays its order. When the order can not be paid anymore,
+// eg. its reservation expired and the stock is gone, the amount taken is refunded at the gateway and the payment fails.
+func capturePayment(ctx context.Context, payment *connectors.PaymentRecord, actor string) (*connectors.PaymentRecord, error) {
+	fLog := apiLogger.WithField("func", "capturePayment")
+
+	captured, err := PaymentRepo.UpdatePaymentStatus(ctx, payment.ID, connectors.PaymentStatusCaptured, actor)
+	if err == nil {
+		return captured, nil
+	}
+	if !errors.Is(err, connectors.ErrInsufficientStock) && !errors.Is(err, connectors.ErrInvalidStatusTransition) {
+		return nil, err
+	}
+
+	refundErr := PaymentGateway.Refund(ctx, payment.Reference, payment.Amount)
+	if refundErr != nil {
+		fLog.Errorf("PaymentGateway.Refund of payment %d got %s", payment.ID, refundErr.Error())
+		return nil, refundErr
+	}
+	_, failErr := PaymentRepo.UpdatePaymentStatus(ctx, payment.ID, connectors.PaymentStatusFailed, actor)
+	if failErr != nil {
+		fLog.Errorf("PaymentRepo.UpdatePaymentStatus got %s", failErr.Error())
+		return nil, failErr
+	}
+
+	return nil, err
+}
+
+// RefundTransaction gives the captured payment of an order back through the payment gateway, which refunds the order and restores its stock
+func (p *PaymentHandler) RefundTransaction(w http.ResponseWriter, r *http.Request) {
+	refund := &refundRequest{}
+	if !readJSONRequest(w, r, refund) {
+		return
+	}
+
+	transaction, err := TransactionRepo.GetTransactionByTransactionID(r.Context(), refund.TransactionID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Transaction ID not found", err)
+		return
+	}
+
+	// every payment is looked at for the captured one
+	payments, _, err := PaymentRepo.GetPaymentsByTransactionID(r.Context(), transaction.ID, nil)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the payments", err)
+		return
+	}
+
+	var captured *connectors.PaymentRecord
+	for _, payment := range payments {
+		if payment.Status == connectors.PaymentStatusCaptured {
+			captured = payment
+		}
+	}
+	if captured == nil {
+		writeHTTPError(r.Context(), w, "Transaction has no captured payment", connectors.ErrPaymentNotFound)
+		return
+	}
+
+	err = PaymentGateway.Refund(r.Context(), captured.Reference, captured.Amount)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Payment gateway is not available", err)
+		return
+	}
+
+	payment, err := PaymentRepo.UpdatePaymentStatus(r.Context(), captured.ID, connectors.PaymentStatusRefunded, refund.Actor)
+	if err != nil {
+		writePaymentError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, payment, nil)
+}
+
+// GetTransactionPayments writes the payments of the order of the id parameter, oldest first
+func (p *PaymentHandler) GetTransactionPayments(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate transaction id exists
+	_, err := TransactionRepo.GetTransactionByTransactionID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Transaction ID not found", err)
+		return
+	}
+
+	payments, total, err := PaymentRepo.GetPaymentsByTransactionID(r.Context(), id, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the payments", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(payments), total, func(i int) *pagination.Cursor { return pagination.IDCursor(payments[i].ID) })
+	writeHTTPPage(r.Context(), w, payments[start:end], meta)
+}
+
+// PaymentWebhook applies a payment event the gateway signed to the payment and its order.
+// Any 2xx response acknowledges the event, the gateway sends it again otherwise.
+func (p *PaymentHandler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		errJSON := &helpers.ErrorJSON{
+			Message:      "Error when parse Body request",
+			Reason:       "internal_error",
+			ErrTittleMsg: "Error parsing request",
+			ErrBodyMsg:   response.Get("general", http.StatusInternalServerError, ""),
+		}
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "", nil, nil, errJSON)
+		return
+	}
+
+	event, err := PaymentGateway.ParseWebhook(body, r.Header.Get(paymentSignatureHeader))
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Invalid webhook", err)
+		return
+	}
+
+	payment, err := PaymentRepo.GetPaymentByReference(r.Context(), PaymentGateway.Name(), event.Reference)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Payment not found", err)
+		return
+	}
+
+	actor := connectors.GatewayActor(PaymentGateway.Name())
+	switch event.Status {
+	case connectors.PaymentStatusAuthorized:
+		payment, err = PaymentRepo.UpdatePaymentStatus(r.Context(), payment.ID, connectors.PaymentStatusAuthorized, actor)
+		if err == nil {
+			payment, err = captureAuthorizedPayment(r.Context(), payment, actor)
+		}
+	case connectors.PaymentStatusCaptured:
+		payment, err = capturePayment(r.Context(), payment, actor)
+	default:
+		payment, err = PaymentRepo.UpdatePaymentStatus(r.Context(), payment.ID, event.Status, actor)
+	}
+
+	// the gateway sends an event again until it is acknowledged, so an event that can never be applied is acknowledged too
+	switch {
+	case errors.Is(err, connectors.ErrInsufficientStock), errors.Is(err, connectors.ErrInvalidStatusTransition):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Payment is refunded, the order can not be paid", nil, nil, nil)
+	case errors.Is(err, connectors.ErrInvalidPaymentTransition):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Payment status is past the event, the event is ignored", nil, nil, nil)
+	case err != nil:
+		writePaymentError(w, r, err)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, payment, nil)
+	}
+}
+
+// writePaymentError writes the failure of moving a payment and its order
+func writePaymentError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Internal Server Error"
+	switch {
+	case errors.Is(err, connectors.ErrInsufficientStock):
+		message = "Product qty is not enough, the payment is refunded"
+	case errors.Is(err, connectors.ErrInvalidStatusTransition):
+		message = "Transaction can not be paid, the payment is refunded"
+	case errors.Is(err, connectors.ErrInvalidPaymentTransition):
+		message = "Payment can not be moved to the status"
+	case errors.Is(err, connectors.ErrPaymentNotFound):
+		message = "Payment not found"
+	}
+	writeHTTPError(r.Context(), w, message, err)
+}
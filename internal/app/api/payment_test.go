@@ -0,0 +1,415 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPayTransaction(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/pay"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+	PaymentGateway = &connectors.FakePaymentGateway{WebhookSecret: "secret"}
+
+	pending := &connectors.TransactionRecord{ID: 1, Status: connectors.TransactionStatusPending, GrandTotal: connectors.NewMoney(2400, connectors.CurrencyIDR)}
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-transaction-not-pending", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(&connectors.TransactionRecord{ID: 1, Status: connectors.TransactionStatusCancelled}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.Anything).Return((*connectors.PaymentRecord)(nil), connectors.ErrInvalidStatusTransition).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Transaction can not be paid", resBody.Message)
+		assert.Equal(t, "invalid_status_transition", resBody.Error.Reason)
+	})
+
+	t.Run("error-payment-in-progress", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(pending, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		// the gateway is not asked to charge the order again
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.Anything).Return((*connectors.PaymentRecord)(nil), connectors.ErrPaymentInProgress).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "token": "tok_visa", "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Transaction already has a payment in progress", resBody.Message)
+		assert.Equal(t, "payment_in_progress", resBody.Error.Reason)
+		PaymentRepoMock.AssertNotCalled(t, "UpdatePaymentAuthorization", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("error-reservation-expired", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(pending, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		// the order can only be cancelled, the gateway is not asked to charge it
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.Anything).Return((*connectors.PaymentRecord)(nil), connectors.ErrReservationExpired).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "token": "tok_visa", "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Reservation of the transaction expired", resBody.Message)
+		assert.Equal(t, "reservation_expired", resBody.Error.Reason)
+		PaymentRepoMock.AssertNotCalled(t, "UpdatePaymentAuthorization", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("error-payment-declined", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(pending, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.Anything).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusPending}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentAuthorization", mock.Anything, 1, mock.MatchedBy(func(answer *connectors.GatewayPayment) bool {
+			return answer.Status == connectors.PaymentStatusFailed
+		})).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusFailed}, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "token": "tok_declined", "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusPaymentRequired, recorder.Code)
+		assert.Equal(t, "payment_declined", resBody.Error.Reason)
+		PaymentRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-insufficient-stock-refunds-payment", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(pending, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.Anything).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusPending}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentAuthorization", mock.Anything, 1, mock.Anything).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusAuthorized}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentStatus", mock.Anything, 1, connectors.PaymentStatusCaptured, "customer").Return((*connectors.PaymentRecord)(nil), connectors.ErrInsufficientStock).Once()
+		PaymentRepoMock.On("UpdatePaymentStatus", mock.Anything, 1, connectors.PaymentStatusFailed, "customer").Return(&connectors.PaymentRecord{ID: 1, Status: connectors.PaymentStatusFailed}, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "token": "tok_visa", "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Product qty is not enough, the payment is refunded", resBody.Message)
+		PaymentRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-pending", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(pending, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.Anything).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusPending}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentAuthorization", mock.Anything, 1, mock.MatchedBy(func(answer *connectors.GatewayPayment) bool {
+			return answer.Status == connectors.PaymentStatusPending && answer.Reference != ""
+		})).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusPending}, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "token": "tok_pending", "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusAccepted {
+			t.Errorf("expecting code 202 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		PaymentRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(pending, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("CreatePayment", mock.Anything, mock.MatchedBy(func(rec *connectors.PaymentRecord) bool {
+			return rec.TransactionID == 1 && rec.Gateway == connectors.FakeGatewayName && rec.Amount == pending.GrandTotal && rec.Status == connectors.PaymentStatusPending && rec.Reference == ""
+		})).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusPending}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentAuthorization", mock.Anything, 1, mock.MatchedBy(func(answer *connectors.GatewayPayment) bool {
+			return answer.Status == connectors.PaymentStatusAuthorized && answer.Reference != ""
+		})).Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusAuthorized}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentStatus", mock.Anything, 1, connectors.PaymentStatusCaptured, "customer").Return(&connectors.PaymentRecord{ID: 1, TransactionID: 1, Status: connectors.PaymentStatusCaptured}, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "token": "tok_visa", "actor": "customer"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/order/payments?id=1", recorder.Header().Get("Location"))
+		PaymentRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-concurrent-payments-charge-once", func(t *testing.T) {
+		db := connectors.NewInMemoryDB()
+		TransactionRepo = db
+		PaymentRepo = db
+		trans, err := db.CreateTransaction(context.Background(), &connectors.TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*connectors.TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+		})
+		assert.Nil(t, err)
+
+		gateway := &blockingGateway{FakePaymentGateway: connectors.FakePaymentGateway{WebhookSecret: "secret"}, authorizing: make(chan struct{}, 2), release: make(chan struct{})}
+		PaymentGateway = gateway
+		defer func() { PaymentGateway = &connectors.FakePaymentGateway{WebhookSecret: "secret"} }()
+
+		pay := func() *httptest.ResponseRecorder {
+			recorder := httptest.NewRecorder()
+			createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(fmt.Sprintf(`{"transaction_id": %d, "token": "tok_visa", "actor": "customer"}`, trans.ID))))
+			createRequest.Header.Add("Content-Type", "application/json")
+			Router.ServeHTTP(recorder, createRequest)
+			return recorder
+		}
+
+		// the first payment waits at the gateway while the second one is made
+		first := make(chan *httptest.ResponseRecorder)
+		go func() { first <- pay() }()
+		<-gateway.authorizing
+
+		second := pay()
+		assert.Equal(t, http.StatusConflict, second.Code)
+		assert.Contains(t, second.Body.String(), "payment_in_progress")
+
+		close(gateway.release)
+		assert.Equal(t, http.StatusOK, (<-first).Code)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&gateway.authorized))
+
+		payments, _, _ := db.GetPaymentsByTransactionID(context.Background(), trans.ID, nil)
+		assert.Equal(t, 1, len(payments))
+		assert.Equal(t, connectors.PaymentStatusCaptured, payments[0].Status)
+	})
+}
+
+// blockingGateway a FakePaymentGateway whose authorizations wait until release is closed
+type blockingGateway struct {
+	connectors.FakePaymentGateway
+
+	// authorizing receives a value whenever an authorization starts
+	authorizing chan struct{}
+	release     chan struct{}
+	authorized  int32
+}
+
+func (g *blockingGateway) Authorize(ctx context.Context, req *connectors.PaymentRequest) (*connectors.GatewayPayment, error) {
+	atomic.AddInt32(&g.authorized, 1)
+	g.authorizing <- struct{}{}
+	<-g.release
+	return g.FakePaymentGateway.Authorize(ctx, req)
+}
+
+func TestRefundTransaction(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/refund"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+	PaymentGateway = &connectors.FakePaymentGateway{WebhookSecret: "secret"}
+
+	t.Run("error-no-captured-payment", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(&connectors.TransactionRecord{ID: 1}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("GetPaymentsByTransactionID", mock.Anything, 1, (*pagination.Page)(nil)).Return([]*connectors.PaymentRecord{{ID: 1, Status: connectors.PaymentStatusFailed}}, 1, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Transaction has no captured payment", resBody.Message)
+		assert.Equal(t, "payment_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(&connectors.TransactionRecord{ID: 1}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("GetPaymentsByTransactionID", mock.Anything, 1, (*pagination.Page)(nil)).Return([]*connectors.PaymentRecord{
+			{ID: 1, Status: connectors.PaymentStatusFailed},
+			{ID: 2, Status: connectors.PaymentStatusCaptured},
+		}, 2, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentStatus", mock.Anything, 2, connectors.PaymentStatusRefunded, "admin").Return(&connectors.PaymentRecord{ID: 2, Status: connectors.PaymentStatusRefunded}, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		PaymentRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/payment/webhook"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+	gateway := &connectors.FakePaymentGateway{WebhookSecret: "secret"}
+	PaymentGateway = gateway
+
+	t.Run("error-invalid-signature", func(t *testing.T) {
+		payload := []byte(`{"reference": "fake_1", "status": "captured"}`)
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader(payload))
+		createRequest.Header.Add("Content-Type", "application/json")
+		createRequest.Header.Add(paymentSignatureHeader, "not-the-signature")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+		assert.Equal(t, "invalid_webhook_signature", resBody.Error.Reason)
+	})
+
+	t.Run("success-stale-event-is-acknowledged", func(t *testing.T) {
+		payload := []byte(`{"reference": "fake_1", "status": "failed"}`)
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("GetPaymentByReference", mock.Anything, connectors.FakeGatewayName, "fake_1").Return(&connectors.PaymentRecord{ID: 1, Reference: "fake_1", Status: connectors.PaymentStatusCaptured}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentStatus", mock.Anything, 1, connectors.PaymentStatusFailed, "gateway:fake").Return((*connectors.PaymentRecord)(nil), connectors.ErrInvalidPaymentTransition).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader(payload))
+		createRequest.Header.Add("Content-Type", "application/json")
+		createRequest.Header.Add(paymentSignatureHeader, gateway.Sign(payload))
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "Payment status is past the event, the event is ignored", resBody.Message)
+		PaymentRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-captured", func(t *testing.T) {
+		payload := []byte(`{"reference": "fake_1", "status": "captured"}`)
+
+		PaymentRepoMock := new(connectors.MockDBType)
+		PaymentRepoMock.On("GetPaymentByReference", mock.Anything, connectors.FakeGatewayName, "fake_1").Return(&connectors.PaymentRecord{ID: 1, Reference: "fake_1", Status: connectors.PaymentStatusPending}, nil).Once()
+		PaymentRepoMock.On("UpdatePaymentStatus", mock.Anything, 1, connectors.PaymentStatusCaptured, "gateway:fake").Return(&connectors.PaymentRecord{ID: 1, Status: connectors.PaymentStatusCaptured}, nil).Once()
+		PaymentRepo = PaymentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader(payload))
+		createRequest.Header.Add("Content-Type", "application/json")
+		createRequest.Header.Add(paymentSignatureHeader, gateway.Sign(payload))
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		PaymentRepoMock.AssertExpectations(t)
+	})
+}
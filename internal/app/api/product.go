@@ -1,16 +1,17 @@
 package api
 
 import (
-	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/arieffian/mw-backend-test/internal/connectors"
-	"github.com/arieffian/mw-backend-test/internal/constants/response"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	"github.com/arieffian/mw-backend-test/pkg/helpers"
-	"github.com/go-playground/validator"
 )
 
 type ProductHandler struct{}
@@ -19,14 +20,66 @@ type productRequest struct {
 	BrandID int    `json:"brand_id" validate:"required,numeric,gt=0"`
 	Name    string `json:"name" validate:"required"`
 	Qty     int    `json:"qty" validate:"required,numeric,gte=0"`
-	Price   int    `json:"price" validate:"required,numeric,gte=0"`
+	// Price an object of amount and currency, or a bare amount in the default currency
+	Price *connectors.Money `json:"price" validate:"required"`
+
+	// TaxClass defaults to standard when omitted
+	TaxClass string `json:"tax_class" validate:"omitempty,oneof=standard reduced exempt"`
+
+	// CategoryIDs the categories the product is listed in, it is listed in none when omitted
+	CategoryIDs []int `json:"category_ids" validate:"omitempty,dive,gt=0"`
+
+	// Actor who edits the qty of a product with PUT, the stock movement is recorded for them.
+	// It is required when the qty changes.
+	Actor string `json:"actor"`
+}
+
+type productPatchRequest struct {
+	BrandID *int    `json:"brand_id" validate:"omitempty,gt=0"`
+	Name    *string `json:"name" validate:"omitempty,min=1"`
+	Qty     *int    `json:"qty" validate:"omitempty,gte=0"`
+	// Price a bare amount keeps the currency of the product
+	Price *connectors.Money `json:"price"`
+
+	TaxClass *string `json:"tax_class" validate:"omitempty,oneof=standard reduced exempt"`
+
+	// CategoryIDs replaces the categories of the product, an empty list unlists it from every category
+	CategoryIDs *[]int `json:"category_ids" validate:"omitempty,dive,gt=0"`
+
+	// Actor who edits the qty, required when the qty changes
+	Actor string `json:"actor"`
+}
+
+type stockRequest struct {
+	ProductID int `json:"product_id" validate:"required,numeric,gt=0"`
+
+	// WarehouseID the warehouse whose stock is adjusted, the default warehouse when it is omitted
+	WarehouseID int    `json:"warehouse_id,omitempty" validate:"omitempty,numeric,gt=0"`
+	Delta       int    `json:"delta" validate:"required,numeric"`
+	Reason      string `json:"reason" validate:"required,oneof=restock adjustment"`
+	Actor       string `json:"actor" validate:"required"`
 }
 
 var (
 	ProductRepo connectors.ProductRepository
 
-	productRegExp      = regexp.MustCompile(`^\/product[\/]*$`)
-	productBrandRegExp = regexp.MustCompile(`^\/product\/brand[\/]*$`)
+	productRegExp         = regexp.MustCompile(`^\/product[\/]*$`)
+	productBrandRegExp    = regexp.MustCompile(`^\/product\/brand[\/]*$`)
+	productCategoryRegExp = regexp.MustCompile(`^\/product\/category[\/]*$`)
+	productsRegExp        = regexp.MustCompile(`^\/products[\/]*$`)
+
+	productStockRegExp        = regexp.MustCompile(`^\/product\/stock[\/]*$`)
+	productStockHistoryRegExp = regexp.MustCompile(`^\/product\/stock\/history[\/]*$`)
+
+	// productSortFields the values of the sort parameter, a leading - sorts descending
+	productSortFields = map[string]bool{
+		connectors.ProductSortID:    true,
+		connectors.ProductSortName:  true,
+		connectors.ProductSortPrice: true,
+	}
+
+	// currencyRegExp the form of an ISO 4217 currency code
+	currencyRegExp = regexp.MustCompile(`^[A-Z]{3}$`)
 )
 
 func (p *ProductHandler) ProductHttpHandler(w http.ResponseWriter, r *http.Request) {
@@ -36,8 +89,22 @@ func (p *ProductHandler) ProductHttpHandler(w http.ResponseWriter, r *http.Reque
 		p.CreateProduct(w, r)
 	case r.Method == http.MethodGet && productRegExp.MatchString(r.URL.Path):
 		p.GetProductByID(w, r)
+	case r.Method == http.MethodPut && productRegExp.MatchString(r.URL.Path):
+		p.UpdateProduct(w, r)
+	case r.Method == http.MethodPatch && productRegExp.MatchString(r.URL.Path):
+		p.PatchProduct(w, r)
+	case r.Method == http.MethodDelete && productRegExp.MatchString(r.URL.Path):
+		p.DeleteProduct(w, r)
 	case r.Method == http.MethodGet && productBrandRegExp.MatchString(r.URL.Path):
 		p.GetProductByBrandID(w, r)
+	case r.Method == http.MethodGet && productCategoryRegExp.MatchString(r.URL.Path):
+		p.GetProductByCategoryID(w, r)
+	case r.Method == http.MethodGet && productsRegExp.MatchString(r.URL.Path):
+		p.GetProducts(w, r)
+	case r.Method == http.MethodPost && productStockRegExp.MatchString(r.URL.Path):
+		p.AdjustStock(w, r)
+	case r.Method == http.MethodGet && productStockHistoryRegExp.MatchString(r.URL.Path):
+		p.GetStockHistory(w, r)
 	default:
 		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
 	}
@@ -46,114 +113,419 @@ func (p *ProductHandler) ProductHttpHandler(w http.ResponseWriter, r *http.Reque
 func (b *ProductHandler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	product := &productRequest{}
 
-	//Unmarshal json
-	body, err := ioutil.ReadAll(r.Body)
+	if !readJSONRequest(w, r, product) {
+		return
+	}
+
+	//validate brand id exists
+	_, err := BrandRepo.GetBrandByID(r.Context(), product.BrandID)
 	if err != nil {
-		errJSON := &helpers.ErrorJSON{
-			Message:      "Error when parse Body request",
-			Reason:       "internal_error",
-			ErrTittleMsg: "Error parsing request",
-			ErrBodyMsg:   response.Get("general", http.StatusInternalServerError, ""),
-		}
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "", nil, nil, errJSON)
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
 		return
 	}
 
-	err = json.Unmarshal(body, &product)
+	//validate category ids exist
+	categories, err := productCategories(r.Context(), product.CategoryIDs)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Error processing request", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Category ID not found", err)
 		return
 	}
 
-	//validate json input
-	validate = validator.New()
-	err = validate.Struct(product)
+	pRecord := &connectors.ProductRecord{
+		BrandID:     product.BrandID,
+		Name:        product.Name,
+		Qty:         product.Qty,
+		Price:       productPrice(*product.Price, connectors.DefaultCurrency()),
+		TaxClass:    productTaxClass(product.TaxClass),
+		CategoryIDs: categoryPathIDs(categories),
+	}
+
+	// insert to database
+	result, err := ProductRepo.CreateProduct(r.Context(), pRecord)
+
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Invalid json structure", nil, nil, nil)
+		message := "Internal server error"
+		if errors.Is(err, connectors.ErrCategoryNotFound) {
+			message = "Category ID not found"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+	result.Categories = categories
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/product?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "product created successfully", headers, result, nil)
+}
+
+func (p *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	product, err := ProductRepo.GetProductByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, product, nil)
+}
+
+func (p *ProductHandler) GetProductByBrandID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
 		return
 	}
 
 	//validate brand id exists
-	_, err = BrandRepo.GetBrandByID(r.Context(), product.BrandID)
+	_, err := BrandRepo.GetBrandByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Brand ID not found", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
 		return
 	}
 
-	pRecord := &connectors.ProductRecord{
-		BrandID: product.BrandID,
-		Name:    product.Name,
-		Qty:     product.Qty,
-		Price:   product.Price,
+	products, total, err := ProductRepo.GetProductByBrandID(r.Context(), id, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the product", err)
+		return
 	}
 
-	// insert to database
-	result, err := ProductRepo.CreateProduct(r.Context(), pRecord)
+	start, end, meta := page.Meta(r.URL, len(products), total, func(i int) *pagination.Cursor { return pagination.IDCursor(products[i].ID) })
+	writeHTTPPage(r.Context(), w, products[start:end], meta)
+}
+
+// GetProductByCategoryID lists the products of the category of the id parameter and of all of its subcategories
+func (p *ProductHandler) GetProductByCategoryID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
 
+	//validate category id exists
+	_, err := CategoryRepo.GetCategoryByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Internal server error", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Category ID not found", err)
 		return
 	}
 
-	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+	products, total, err := ProductRepo.GetProductsByCategoryID(r.Context(), id, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the product", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(products), total, func(i int) *pagination.Cursor { return pagination.IDCursor(products[i].ID) })
+	writeHTTPPage(r.Context(), w, products[start:end], meta)
 }
 
-func (p *ProductHandler) GetProductByID(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
+func (p *ProductHandler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
 
-	//check if id present and greater than 0
-	sID := query.Get("id")
-	if sID == "" {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Parameter ID not found", nil, nil, nil)
+	product := &productRequest{}
+
+	if !readJSONRequest(w, r, product) {
 		return
 	}
 
-	//check if id is number or not
-	id, err := strconv.Atoi(sID)
+	//validate product id exists
+	pRecord, err := ProductRepo.GetProductByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Parameter ID is not numeric", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
 		return
 	}
 
-	product, err := ProductRepo.GetProductByID(r.Context(), id)
+	pRecord.BrandID = product.BrandID
+	pRecord.Name = product.Name
+	pRecord.Qty = product.Qty
+	pRecord.Price = productPrice(*product.Price, connectors.DefaultCurrency())
+	pRecord.TaxClass = productTaxClass(product.TaxClass)
+	pRecord.CategoryIDs = product.CategoryIDs
+
+	p.saveProduct(w, r, pRecord, product.Actor)
+}
 
+func (p *ProductHandler) PatchProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	product := &productPatchRequest{}
+
+	if !readJSONRequest(w, r, product) {
+		return
+	}
+
+	//validate product id exists
+	pRecord, err := ProductRepo.GetProductByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Error fetching the product", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
 		return
 	}
 
-	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, product, nil)
+	// only the fields present in the body are changed
+	if product.BrandID != nil {
+		pRecord.BrandID = *product.BrandID
+	}
+	if product.Name != nil {
+		pRecord.Name = *product.Name
+	}
+	if product.Qty != nil {
+		pRecord.Qty = *product.Qty
+	}
+	if product.Price != nil {
+		pRecord.Price = productPrice(*product.Price, pRecord.Price.Currency)
+	}
+	if product.TaxClass != nil {
+		pRecord.TaxClass = *product.TaxClass
+	}
+	if product.CategoryIDs != nil {
+		pRecord.CategoryIDs = *product.CategoryIDs
+	}
+
+	p.saveProduct(w, r, pRecord, product.Actor)
 }
 
-func (p *ProductHandler) GetProductByBrandID(w http.ResponseWriter, r *http.Request) {
+// productPrice the price of a request, in currency when it comes without one
+func productPrice(price connectors.Money, currency string) connectors.Money {
+	price.Currency = strings.ToUpper(price.Currency)
+	if price.Currency == "" {
+		price.Currency = currency
+	}
+	return price
+}
+
+// productTaxClass the tax class of a request, a product without one is taxed at the standard rate
+func productTaxClass(taxClass string) string {
+	if taxClass == "" {
+		return connectors.TaxClassStandard
+	}
+	return taxClass
+}
+
+// saveProduct checks the brand and the categories of pRecord exist and writes pRecord, a change of qty is made by actor.
+// Shared by PUT and PATCH.
+func (p *ProductHandler) saveProduct(w http.ResponseWriter, r *http.Request, pRecord *connectors.ProductRecord, actor string) {
+	//validate brand id exists
+	_, err := BrandRepo.GetBrandByID(r.Context(), pRecord.BrandID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Brand ID not found", err)
+		return
+	}
+
+	//validate category ids exist
+	pRecord.Categories, err = productCategories(r.Context(), pRecord.CategoryIDs)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Category ID not found", err)
+		return
+	}
+	pRecord.CategoryIDs = categoryPathIDs(pRecord.Categories)
+
+	result, err := ProductRepo.UpdateProduct(r.Context(), pRecord, actor)
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrCategoryNotFound):
+			message = "Category ID not found"
+		case errors.Is(err, connectors.ErrInsufficientStock):
+			message = "Qty of the default warehouse is not enough"
+		case errors.Is(err, connectors.ErrActorRequired):
+			message = "Actor is required to change the qty"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, pRecord, nil)
+}
+
+func (p *ProductHandler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	//validate product id exists
+	_, err := ProductRepo.GetProductByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
+		return
+	}
+
+	result, err := ProductRepo.DeleteProduct(r.Context(), id)
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrProductHasOrders):
+			message = "Product is used by orders"
+		case errors.Is(err, connectors.ErrProductHasStockMovements):
+			message = "Product has stock movements"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+}
+
+// GetProducts lists the products filtered by the name, brand_id, currency, min_price, max_price and in_stock parameters,
+// ordered by the sort parameter and paged with limit and offset or with the after and before cursors
+func (p *ProductHandler) GetProducts(w http.ResponseWriter, r *http.Request) {
 	query := r.URL.Query()
 
-	//check if id present and greater than 0
-	sID := query.Get("id")
-	if sID == "" {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Parameter ID not found", nil, nil, nil)
+	page, ok := parsePage(w, r)
+	if !ok {
 		return
 	}
 
-	//check if id is number or not
-	id, err := strconv.Atoi(sID)
+	filter := &connectors.ProductFilter{
+		Name: query.Get("name"),
+		Page: page.Fetch(),
+	}
+
+	if sBrandID := query.Get("brand_id"); sBrandID != "" {
+		brandID, err := strconv.Atoi(sBrandID)
+		if err != nil || brandID < 1 {
+			writeHTTPError(r.Context(), w, "Parameter brand_id must be a positive number", connectors.ErrBadRequest)
+			return
+		}
+		filter.BrandID = brandID
+	}
+
+	if currency := strings.ToUpper(query.Get("currency")); currency != "" {
+		if !currencyRegExp.MatchString(currency) {
+			writeHTTPError(r.Context(), w, "Parameter currency must be an ISO 4217 code, eg. IDR", connectors.ErrBadRequest)
+			return
+		}
+		filter.Currency = currency
+	}
+
+	if sMinPrice := query.Get("min_price"); sMinPrice != "" {
+		minPrice, err := strconv.ParseInt(sMinPrice, 10, 64)
+		if err != nil || minPrice < 0 {
+			writeHTTPError(r.Context(), w, "Parameter min_price must be a number of 0 or greater", connectors.ErrBadRequest)
+			return
+		}
+		filter.MinPrice = &minPrice
+	}
+
+	if sMaxPrice := query.Get("max_price"); sMaxPrice != "" {
+		maxPrice, err := strconv.ParseInt(sMaxPrice, 10, 64)
+		if err != nil || maxPrice < 0 {
+			writeHTTPError(r.Context(), w, "Parameter max_price must be a number of 0 or greater", connectors.ErrBadRequest)
+			return
+		}
+		filter.MaxPrice = &maxPrice
+	}
+
+	if sInStock := query.Get("in_stock"); sInStock != "" {
+		inStock, err := strconv.ParseBool(sInStock)
+		if err != nil {
+			writeHTTPError(r.Context(), w, "Parameter in_stock is not a boolean", connectors.NewBadRequestError(err))
+			return
+		}
+		filter.InStock = inStock
+	}
+
+	if sSort := query.Get("sort"); sSort != "" {
+		filter.Desc = strings.HasPrefix(sSort, "-")
+		filter.Sort = strings.TrimPrefix(sSort, "-")
+		if !productSortFields[filter.Sort] {
+			writeHTTPError(r.Context(), w, "Parameter sort must be id, name or price, optionally prefixed with -", connectors.ErrBadRequest)
+			return
+		}
+	}
+
+	products, total, err := ProductRepo.GetProducts(r.Context(), filter)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Parameter ID is not numeric", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Error fetching the product", err)
 		return
 	}
 
-	//validate brand id exists
-	_, err = BrandRepo.GetBrandByID(r.Context(), id)
+	start, end, meta := page.Meta(r.URL, len(products), total, func(i int) *pagination.Cursor {
+		return connectors.ProductCursor(products[i], filter.Sort)
+	})
+	writeHTTPPage(r.Context(), w, products[start:end], meta)
+}
+
+// AdjustStock adds the delta to the qty of a product, a restock must add qty while an adjustment may also remove it
+func (p *ProductHandler) AdjustStock(w http.ResponseWriter, r *http.Request) {
+	stock := &stockRequest{}
+
+	if !readJSONRequest(w, r, stock) {
+		return
+	}
+	if stock.Reason == connectors.StockReasonRestock && stock.Delta < 0 {
+		writeHTTPError(r.Context(), w, "Invalid json structure", connectors.NewValidationError(errors.New("delta of a restock must be greater than 0")))
+		return
+	}
+
+	product, err := ProductRepo.AdjustStock(r.Context(), &connectors.StockMovementRecord{
+		ProductID:   stock.ProductID,
+		WarehouseID: stock.WarehouseID,
+		Delta:       stock.Delta,
+		Reason:      stock.Reason,
+		Actor:       stock.Actor,
+		CreatedAt:   time.Now(),
+	})
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrProductNotFound):
+			message = "Product ID not found"
+		case errors.Is(err, connectors.ErrWarehouseNotFound):
+			message = "Warehouse ID not found"
+		case errors.Is(err, connectors.ErrInsufficientStock):
+			message = "Product qty is not enough"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "stock adjusted successfully", nil, product, nil)
+}
+
+// GetStockHistory lists the stock movements of a product, oldest first
+func (p *ProductHandler) GetStockHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate product id exists
+	_, err := ProductRepo.GetProductByID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Brand ID not found", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
 		return
 	}
 
-	products, err := ProductRepo.GetProductByBrandID(r.Context(), id)
+	movements, total, err := ProductRepo.GetStockMovements(r.Context(), id, page.Fetch())
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Error fetching the product", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Error fetching the stock history", err)
 		return
 	}
 
-	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, products, nil)
+	start, end, meta := page.Meta(r.URL, len(movements), total, func(i int) *pagination.Cursor { return pagination.IDCursor(movements[i].ID) })
+	writeHTTPPage(r.Context(), w, movements[start:end], meta)
 }
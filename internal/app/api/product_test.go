@@ -3,7 +3,6 @@ package api
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
@@ -11,6 +10,7 @@ import (
 	"testing/iotest"
 
 	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	"github.com/arieffian/mw-backend-test/pkg/helpers"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -36,16 +36,16 @@ func TestCreateProduct(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Error processing request",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read: unexpected end of JSON input",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -55,7 +55,7 @@ func TestCreateProduct(t *testing.T) {
 
 	t.Run("error-brand-not-found", func(t *testing.T) {
 		BrandRepoMock := new(connectors.MockDBType)
-		BrandRepoMock.On("GetBrandByID", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{}, fmt.Errorf("product not found")).Once()
+		BrandRepoMock.On("GetBrandByID", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{}, connectors.ErrBrandNotFound).Once()
 		BrandRepo = BrandRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -70,16 +70,16 @@ func TestCreateProduct(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusNotFound
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Brand ID not found",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "brand not found",
+				Reason:       "brand_not_found",
+				ErrTittleMsg: "Not Found",
+				ErrBodyMsg:   "Not Found",
 			},
 		}
 
@@ -87,13 +87,67 @@ func TestCreateProduct(t *testing.T) {
 		assert.Equal(t, dataExpect, resBody)
 	})
 
+	t.Run("error-invalid-tax-class", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"brand_id": 1, "name": "predator", "qty": 3, "price": 1050, "tax_class": "luxury"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "tax_class", resBody.Error.Fields[0].Field)
+	})
+
+	t.Run("error-invalid-currency", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"brand_id": 1, "name": "predator", "qty": 3, "price": {"amount": 1050, "currency": "US"}}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "price.currency", resBody.Error.Fields[0].Field)
+	})
+
+	t.Run("success-currency", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("CreateProduct", mock.Anything, mock.MatchedBy(func(rec *connectors.ProductRecord) bool { return rec.Price == connectors.NewMoney(999, "USD") })).
+			Return(&connectors.ProductRecord{ID: 4, BrandID: 1, Name: "predator", Qty: 3, Price: connectors.NewMoney(999, "USD")}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"brand_id": 1, "name": "predator", "qty": 3, "price": {"amount": 999, "currency": "usd"}}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		ProductRepoMock.AssertExpectations(t)
+	})
+
 	t.Run("success", func(t *testing.T) {
 		BrandRepoMock := new(connectors.MockDBType)
 		BrandRepoMock.On("GetBrandByID", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{}, nil).Once()
 		BrandRepo = BrandRepoMock
 
+		// a product without a tax class is taxed at the standard rate, a bare price is in the default currency
 		ProductRepoMock := new(connectors.MockDBType)
-		ProductRepoMock.On("CreateProduct", mock.Anything, mock.Anything).Return("sucess", nil).Once()
+		ProductRepoMock.On("CreateProduct", mock.Anything, mock.MatchedBy(func(rec *connectors.ProductRecord) bool {
+			return rec.TaxClass == connectors.TaxClassStandard && rec.Price == connectors.NewMoney(1050, connectors.CurrencyIDR)
+		})).
+			Return(&connectors.ProductRecord{ID: 4, BrandID: 1, Name: "predator", Qty: 3, Price: connectors.NewMoney(1050, connectors.CurrencyIDR), TaxClass: connectors.TaxClassStandard}, nil).Once()
 		ProductRepo = ProductRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -108,10 +162,122 @@ func TestCreateProduct(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		if recorder.Code != http.StatusOK {
-			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
 			t.FailNow()
 		}
+		assert.Equal(t, "/product?id=4", recorder.Header().Get("Location"))
+	})
+
+	t.Run("error-category-not-found", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryPaths", mock.Anything, []int{100}).Return([]connectors.CategoryPath(nil), connectors.ErrCategoryNotFound).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"brand_id": 1, "name": "predator", "qty": 3, "price": 1050, "category_ids": [100]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Category ID not found", resBody.Message)
+	})
+
+	t.Run("success-with-categories", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, mock.Anything).Return(&connectors.BrandRecord{}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		computers := &connectors.CategoryRecord{ID: 1, Name: "computers"}
+		laptops := &connectors.CategoryRecord{ID: 2, ParentID: 1, Name: "laptops"}
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryPaths", mock.Anything, []int{2, 1, 2}).Return([]connectors.CategoryPath{{computers}, {computers, laptops}}, nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("CreateProduct", mock.Anything, mock.MatchedBy(func(rec *connectors.ProductRecord) bool {
+			return assert.ObjectsAreEqual([]int{1, 2}, rec.CategoryIDs)
+		})).Return(&connectors.ProductRecord{ID: 4, BrandID: 1, Name: "predator", CategoryIDs: []int{1, 2}}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"brand_id": 1, "name": "predator", "qty": 3, "price": 1050, "category_ids": [2, 1, 2]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+
+		// the response carries the breadcrumb of every category
+		resBody := &struct {
+			Data struct {
+				Categories [][]connectors.CategoryRecord
+			}
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), resBody)
+		assert.Equal(t, [][]connectors.CategoryRecord{{*computers}, {*computers, *laptops}}, resBody.Data.Categories)
+		ProductRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetProductByCategoryID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product/category"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-category-not-found", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 100).Return((*connectors.CategoryRecord)(nil), connectors.ErrCategoryNotFound).Once()
+		CategoryRepo = CategoryRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?id=100", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Category ID not found", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryByID", mock.Anything, 1).Return(&connectors.CategoryRecord{ID: 1, Name: "computers"}, nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductsByCategoryID", mock.Anything, 1, mock.Anything).Return([]*connectors.ProductRecord{{ID: 1}, {ID: 3}}, 2, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+		ProductRepoMock.AssertExpectations(t)
 	})
 }
 
@@ -134,16 +300,16 @@ func TestGetProductByID(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Parameter ID not found",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -164,16 +330,16 @@ func TestGetProductByID(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Parameter ID is not numeric",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read: strconv.Atoi: parsing \"a\": invalid syntax",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -225,16 +391,16 @@ func TestGetProductByBrandID(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Parameter ID not found",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -255,16 +421,16 @@ func TestGetProductByBrandID(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Parameter ID is not numeric",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read: strconv.Atoi: parsing \"a\": invalid syntax",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -278,7 +444,7 @@ func TestGetProductByBrandID(t *testing.T) {
 		BrandRepo = BrandRepoMock
 
 		ProductRepoMock := new(connectors.MockDBType)
-		ProductRepoMock.On("GetProductByBrandID", mock.Anything, mock.Anything).Return([]*connectors.ProductRecord{}, nil).Once()
+		ProductRepoMock.On("GetProductByBrandID", mock.Anything, mock.Anything, mock.Anything).Return([]*connectors.ProductRecord{}, 0, nil).Once()
 		ProductRepo = ProductRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -300,3 +466,440 @@ func TestGetProductByBrandID(t *testing.T) {
 	})
 
 }
+
+func TestUpdateProduct(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product?id=1"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPut, urlEndPoint, bytes.NewReader([]byte(`{"brand_id": 1, "name": "macbook pro", "qty": 3}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, []*helpers.FieldErrorJSON{
+			{Field: "price", Tag: "required", Param: "", Message: "price is a required field"},
+		}, resBody.Error.Fields)
+	})
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return((*connectors.ProductRecord)(nil), connectors.ErrProductNotFound).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPut, urlEndPoint, bytes.NewReader([]byte(`{"brand_id": 1, "name": "macbook pro", "qty": 3, "price": 1300}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("success-put", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 2).Return(&connectors.BrandRecord{ID: 2}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: connectors.NewMoney(1200, connectors.CurrencyIDR)}, nil).Once()
+		ProductRepoMock.On("UpdateProduct", mock.Anything, &connectors.ProductRecord{ID: 1, BrandID: 2, Name: "macbook pro m1", Qty: 4, Price: connectors.NewMoney(1300, connectors.CurrencyIDR), TaxClass: connectors.TaxClassStandard}, "admin").Return("success", nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPut, urlEndPoint, bytes.NewReader([]byte(`{"brand_id": 2, "name": "macbook pro m1", "qty": 4, "price": 1300, "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		ProductRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-patch-price", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: connectors.NewMoney(1200, connectors.CurrencyIDR)}, nil).Once()
+		ProductRepoMock.On("UpdateProduct", mock.Anything, &connectors.ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: connectors.NewMoney(1150, connectors.CurrencyIDR)}, "").Return("success", nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"price": 1150}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		ProductRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-patch-categories", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		CategoryRepoMock := new(connectors.MockDBType)
+		CategoryRepoMock.On("GetCategoryPaths", mock.Anything, []int{3}).Return([]connectors.CategoryPath{{{ID: 3, Name: "gaming"}}}, nil).Once()
+		CategoryRepo = CategoryRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", CategoryIDs: []int{2}}, nil).Once()
+		ProductRepoMock.On("UpdateProduct", mock.Anything, mock.MatchedBy(func(rec *connectors.ProductRecord) bool {
+			return assert.ObjectsAreEqual([]int{3}, rec.CategoryIDs) && rec.Name == "macbook pro"
+		}), "").Return("success", nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"category_ids": [3]}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		ProductRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-qty-without-actor", func(t *testing.T) {
+		for method, body := range map[string]string{
+			http.MethodPut:   `{"brand_id": 1, "name": "macbook pro", "qty": 4, "price": 1200}`,
+			http.MethodPatch: `{"qty": 4}`,
+		} {
+			BrandRepoMock := new(connectors.MockDBType)
+			BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1}, nil).Once()
+			BrandRepo = BrandRepoMock
+
+			// the qty is compared with the one the product has once it is locked
+			ProductRepoMock := new(connectors.MockDBType)
+			ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: connectors.NewMoney(1200, connectors.CurrencyIDR)}, nil).Once()
+			ProductRepoMock.On("UpdateProduct", mock.Anything, mock.Anything, "").Return("", connectors.ErrActorRequired).Once()
+			ProductRepo = ProductRepoMock
+
+			recorder := httptest.NewRecorder()
+			createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(body)))
+			createRequest.Header.Add("Content-Type", "application/json")
+			Router.ServeHTTP(recorder, createRequest)
+
+			assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code, method)
+			assert.Contains(t, recorder.Body.String(), "Actor is required to change the qty", method)
+			ProductRepoMock.AssertExpectations(t)
+		}
+	})
+
+	t.Run("success-patch-qty", func(t *testing.T) {
+		BrandRepoMock := new(connectors.MockDBType)
+		BrandRepoMock.On("GetBrandByID", mock.Anything, 1).Return(&connectors.BrandRecord{ID: 1}, nil).Once()
+		BrandRepo = BrandRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: connectors.NewMoney(1200, connectors.CurrencyIDR)}, nil).Once()
+		ProductRepoMock.On("UpdateProduct", mock.Anything, mock.MatchedBy(func(rec *connectors.ProductRecord) bool {
+			return rec.Qty == 4
+		}), "admin").Return("success", nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"qty": 4, "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		ProductRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-patch-invalid-category-id", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"category_ids": [0]}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+}
+
+func TestDeleteProduct(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product?id=1"
+	method := "DELETE"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-product-has-orders", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1}, nil).Once()
+		ProductRepoMock.On("DeleteProduct", mock.Anything, 1).Return("", connectors.ErrProductHasOrders).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Product is used by orders", resBody.Message)
+		assert.Equal(t, "product_has_orders", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1}, nil).Once()
+		ProductRepoMock.On("DeleteProduct", mock.Anything, 1).Return("success", nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+	})
+}
+
+func TestGetProducts(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-unknown-sort", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/products?sort=qty", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("error-limit-out-of-range", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/products?limit=1000", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		minPrice, maxPrice := int64(1000), int64(1500)
+		filterExpect := &connectors.ProductFilter{
+			Name:     "mac",
+			BrandID:  1,
+			MinPrice: &minPrice,
+			MaxPrice: &maxPrice,
+			InStock:  true,
+			Sort:     connectors.ProductSortPrice,
+			Desc:     true,
+			// one product more than the page to know whether there is a next page
+			Page: &pagination.Page{Limit: 3, Offset: 2},
+		}
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProducts", mock.Anything, filterExpect).Return([]*connectors.ProductRecord{{ID: 1}, {ID: 2}, {ID: 3}}, 7, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/products?name=mac&brand_id=1&min_price=1000&max_price=1500&in_stock=true&sort=-price&limit=2&offset=2", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &struct {
+			Data []*connectors.ProductRecord `json:"data"`
+			Meta *pagination.Meta            `json:"meta"`
+		}{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+		assert.Equal(t, 7, resBody.Meta.Total)
+		assert.Equal(t, 2, resBody.Meta.Offset)
+		assert.Equal(t, "/products?brand_id=1&in_stock=true&limit=2&max_price=1500&min_price=1000&name=mac&offset=4&sort=-price", resBody.Meta.Next)
+		assert.Equal(t, "/products?brand_id=1&in_stock=true&limit=2&max_price=1500&min_price=1000&name=mac&offset=0&sort=-price", resBody.Meta.Prev)
+		ProductRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-cursor", func(t *testing.T) {
+		after := connectors.ProductCursor(&connectors.ProductRecord{ID: 2, Name: "legion"}, connectors.ProductSortName)
+		filterExpect := &connectors.ProductFilter{
+			Sort: connectors.ProductSortName,
+			Page: &pagination.Page{Limit: 3, After: after},
+		}
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProducts", mock.Anything, filterExpect).Return([]*connectors.ProductRecord{{ID: 4, Name: "macbook air"}, {ID: 1, Name: "macbook pro"}}, 4, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/products?sort=name&limit=2&after="+after.Encode(), nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		resBody := &struct {
+			Data []*connectors.ProductRecord `json:"data"`
+			Meta *pagination.Meta            `json:"meta"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+		assert.Equal(t, &pagination.Meta{
+			Total: 4,
+			Limit: 2,
+			Prev:  "/products?before=" + connectors.ProductCursor(resBody.Data[0], connectors.ProductSortName).Encode() + "&limit=2&sort=name",
+		}, resBody.Meta)
+		ProductRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-offset-and-cursor", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/products?offset=2&after="+pagination.IDCursor(1).Encode(), nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+}
+
+func TestAdjustStock(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product/stock"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-negative-restock", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"product_id": 1, "delta": -2, "reason": "restock", "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+
+	t.Run("error-qty-not-enough", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("AdjustStock", mock.Anything, mock.Anything).Return((*connectors.ProductRecord)(nil), connectors.ErrInsufficientStock).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"product_id": 1, "delta": -5, "reason": "adjustment", "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Product qty is not enough", resBody.Message)
+	})
+
+	t.Run("error-warehouse-not-found", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("AdjustStock", mock.Anything, mock.MatchedBy(func(rec *connectors.StockMovementRecord) bool {
+			return rec.WarehouseID == 100
+		})).Return((*connectors.ProductRecord)(nil), connectors.ErrWarehouseNotFound).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"product_id": 1, "warehouse_id": 100, "delta": 5, "reason": "restock", "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Warehouse ID not found", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("AdjustStock", mock.Anything, mock.MatchedBy(func(rec *connectors.StockMovementRecord) bool {
+			return rec.ProductID == 1 && rec.Delta == 5 && rec.Reason == connectors.StockReasonRestock && rec.Actor == "admin" && !rec.CreatedAt.IsZero()
+		})).Return(&connectors.ProductRecord{ID: 1, Qty: 8}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"product_id": 1, "delta": 5, "reason": "restock", "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		ProductRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetStockHistory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 100).Return((*connectors.ProductRecord)(nil), connectors.ErrProductNotFound).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/product/stock/history?id=100", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1}, nil).Once()
+		ProductRepoMock.On("GetStockMovements", mock.Anything, 1, mock.Anything).Return([]*connectors.StockMovementRecord{
+			{ID: 1, ProductID: 1, Delta: 3, Reason: connectors.StockReasonInitial, Actor: "system"},
+			{ID: 2, ProductID: 1, Delta: -1, Reason: connectors.StockReasonSale, TransactionID: 1, Actor: "user:1"},
+		}, 2, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/product/stock/history?id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &struct {
+			Data []*connectors.StockMovementRecord `json:"data"`
+		}{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+		assert.Equal(t, connectors.StockReasonSale, resBody.Data[1].Reason)
+	})
+}
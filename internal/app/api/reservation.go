@@ -0,0 +1,53 @@
+package api
+
+import (
+	"context"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+)
+
+// ReservationRepo the repository holding the stock reservations of pending orders
+var ReservationRepo connectors.ReservationRepository
+
+// sweepExpired cancels the pending orders whose reservation expired, then deletes the expired stock reservations
+// and the expired idempotency keys every interval until ctx is done. An expired reservation already stops holding stock,
+// its order can not be paid anymore, and an expired key is already treated as a new one: the sweep only keeps
+// the statuses of the orders current and their tables small. An interval of zero or less disables the sweep.
+func sweepExpired(ctx context.Context, interval time.Duration) {
+	fLog := apiLogger.WithField("func", "sweepExpired")
+	if interval <= 0 {
+		fLog.Infof("expiry sweep is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			cancelled, err := ReservationRepo.CancelExpiredTransactions(ctx, now)
+			if err != nil {
+				fLog.Errorf("ReservationRepo.CancelExpiredTransactions got %s", err.Error())
+			} else if cancelled > 0 {
+				fLog.Infof("cancelled %d expired orders", cancelled)
+			}
+
+			released, err := ReservationRepo.ReleaseExpiredReservations(ctx, now)
+			if err != nil {
+				fLog.Errorf("ReservationRepo.ReleaseExpiredReservations got %s", err.Error())
+			} else if released > 0 {
+				fLog.Infof("released %d expired reservations", released)
+			}
+
+			deleted, err := TransactionRepo.DeleteExpiredIdempotencyKeys(ctx, now)
+			if err != nil {
+				fLog.Errorf("TransactionRepo.DeleteExpiredIdempotencyKeys got %s", err.Error())
+			} else if deleted > 0 {
+				fLog.Infof("deleted %d expired idempotency keys", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSweepExpired(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("disabled", func(t *testing.T) {
+		ReservationRepoMock := new(connectors.MockDBType)
+		ReservationRepo = ReservationRepoMock
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepo = TransactionRepoMock
+
+		// returns right away instead of blocking until the context is done
+		sweepExpired(context.Background(), 0)
+		ReservationRepoMock.AssertNotCalled(t, "CancelExpiredTransactions", mock.Anything, mock.Anything)
+		ReservationRepoMock.AssertNotCalled(t, "ReleaseExpiredReservations", mock.Anything, mock.Anything)
+		TransactionRepoMock.AssertNotCalled(t, "DeleteExpiredIdempotencyKeys", mock.Anything, mock.Anything)
+	})
+
+	t.Run("success-keeps-sweeping-after-error", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		ReservationRepoMock := new(connectors.MockDBType)
+		// the reservations are released on every tick, even when cancelling the expired orders failed
+		ReservationRepoMock.On("CancelExpiredTransactions", mock.Anything, mock.Anything).Return(0, fmt.Errorf("Error DB")).Once()
+		ReservationRepoMock.On("CancelExpiredTransactions", mock.Anything, mock.Anything).Return(1, nil).Once()
+		ReservationRepoMock.On("CancelExpiredTransactions", mock.Anything, mock.Anything).Return(0, nil).Maybe()
+		ReservationRepoMock.On("ReleaseExpiredReservations", mock.Anything, mock.Anything).Return(0, fmt.Errorf("Error DB")).Once()
+		ReservationRepoMock.On("ReleaseExpiredReservations", mock.Anything, mock.Anything).Return(2, nil).Once()
+		// the ticker may fire once more before the sweep sees the cancellation
+		ReservationRepoMock.On("ReleaseExpiredReservations", mock.Anything, mock.Anything).Return(0, nil).Maybe()
+		ReservationRepo = ReservationRepoMock
+
+		// the keys are purged on every tick, even when releasing the reservations failed
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("DeleteExpiredIdempotencyKeys", mock.Anything, mock.Anything).Return(1, nil).Once()
+		TransactionRepoMock.On("DeleteExpiredIdempotencyKeys", mock.Anything, mock.Anything).Return(0, fmt.Errorf("Error DB")).Once().Run(func(args mock.Arguments) {
+			cancel()
+		})
+		TransactionRepoMock.On("DeleteExpiredIdempotencyKeys", mock.Anything, mock.Anything).Return(0, nil).Maybe()
+		TransactionRepo = TransactionRepoMock
+
+		done := make(chan struct{})
+		go func() {
+			sweepExpired(ctx, time.Millisecond)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("sweep did not stop after its context was cancelled")
+		}
+		ReservationRepoMock.AssertExpectations(t)
+		TransactionRepoMock.AssertExpectations(t)
+	})
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/constants/response"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	log "github.com/sirupsen/logrus"
+)
+
+// errorKindStatus the http status answering every kind of domain error, anything else is a 500
+var errorKindStatus = map[connectors.ErrorKind]int{
+	connectors.KindBadRequest:        http.StatusBadRequest,
+	connectors.KindValidation:        http.StatusUnprocessableEntity,
+	connectors.KindNotFound:          http.StatusNotFound,
+	connectors.KindConflict:          http.StatusConflict,
+	connectors.KindInsufficientStock: http.StatusConflict,
+	connectors.KindPaymentDeclined:   http.StatusPaymentRequired,
+	connectors.KindUnauthorized:      http.StatusUnauthorized,
+}
+
+// writeHTTPPage writes data, a page of a list, as a success response with the meta block of the page
+func writeHTTPPage(ctx context.Context, w http.ResponseWriter, data interface{}, meta *pagination.Meta) {
+	helpers.WriteHTTPMetaResponse(ctx, w, http.StatusOK, "Success", nil, data, meta, nil)
+}
+
+// writeHTTPError writes err as a failure response. The http status and ErrorJSON.Reason come from the kind and code
+// of the connectors domain error in the chain of err, any other error is an internal server error whose text is not exposed.
+// A validation error made from validator.ValidationErrors also lists every failed field.
+func writeHTTPError(ctx context.Context, w http.ResponseWriter, message string, err error) {
+	var domainErr *connectors.Error
+	if !errors.As(err, &domainErr) {
+		log.Errorf("%s. Got %s", message, err)
+		helpers.WriteHTTPResponse(ctx, w, http.StatusInternalServerError, message, nil, nil, &helpers.ErrorJSON{
+			Message:      http.StatusText(http.StatusInternalServerError),
+			Reason:       "internal_error",
+			ErrTittleMsg: http.StatusText(http.StatusInternalServerError),
+			ErrBodyMsg:   response.Get("general", http.StatusInternalServerError, ""),
+		})
+		return
+	}
+
+	httpRespCode, ok := errorKindStatus[domainErr.Kind]
+	if !ok {
+		httpRespCode = http.StatusInternalServerError
+	}
+	helpers.WriteHTTPResponse(ctx, w, httpRespCode, message, nil, nil, &helpers.ErrorJSON{
+		Message:      err.Error(),
+		Reason:       domainErr.Code,
+		ErrTittleMsg: http.StatusText(httpRespCode),
+		ErrBodyMsg:   response.Get("general", httpRespCode, ""),
+		Fields:       helpers.ValidationFields(err, validationMessage),
+	})
+}
+
+// validationMessage the message of a failed tag configured with the response configuration key validation.422.<tag>
+func validationMessage(tag string) (string, bool) {
+	return response.Lookup("validation", http.StatusUnprocessableEntity, tag)
+}
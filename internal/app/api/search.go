@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type SearchHandler struct{}
+
+type searchResponse struct {
+	// Hits the page of matching products, the most relevant first
+	Hits []*connectors.SearchHit `json:"hits"`
+
+	Facets searchFacets `json:"facets"`
+}
+
+// searchFacets the number of matching products per brand and per price bucket, on every page
+type searchFacets struct {
+	Brands       []*connectors.BrandFacet       `json:"brands"`
+	PriceBuckets []*connectors.PriceBucketFacet `json:"price_buckets"`
+}
+
+const searchMaxQueryLength = 255
+
+var (
+	ProductSearcher connectors.Searcher
+
+	searchRegExp = regexp.MustCompile(`^\/search[\/]*$`)
+)
+
+func (s *SearchHandler) SearchHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case !searchRegExp.MatchString(r.URL.Path):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	case r.Method == http.MethodGet:
+		s.SearchProducts(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	}
+}
+
+// SearchProducts writes the page of the products whose name, brand or categories match the words of the q parameter,
+// the most relevant first, with the number of matching products per brand and per price bucket.
+// The relevance order has no cursor, the pages are asked with limit and offset.
+func (s *SearchHandler) SearchProducts(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	q := &connectors.SearchQuery{
+		Query: strings.TrimSpace(query.Get("q")),
+	}
+	if q.Query == "" {
+		writeHTTPError(r.Context(), w, "Parameter q not found", connectors.ErrBadRequest)
+		return
+	}
+	if len(q.Query) > searchMaxQueryLength {
+		writeHTTPError(r.Context(), w, fmt.Sprintf("Parameter q must be at most %d characters", searchMaxQueryLength), connectors.ErrBadRequest)
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+	if page.Keyset() {
+		writeHTTPError(r.Context(), w, "Parameter after and before are not supported, search is paged with offset", connectors.ErrBadRequest)
+		return
+	}
+	q.Limit, q.Offset = page.Limit, page.Offset
+
+	found, err := ProductSearcher.SearchProducts(r.Context(), q)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error searching the products", err)
+		return
+	}
+
+	result := &searchResponse{
+		Hits: found.Hits,
+		Facets: searchFacets{
+			Brands:       found.Brands,
+			PriceBuckets: found.PriceBuckets,
+		},
+	}
+
+	// the hits are read with the page itself, the total tells whether there is a next page
+	_, _, meta := page.Meta(r.URL, len(found.Hits), found.Total, nil)
+	writeHTTPPage(r.Context(), w, result, meta)
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestSearchProducts(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-query-not-found", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/search?q=%20", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Parameter q not found", resBody.Message)
+	})
+
+	t.Run("error-limit-out-of-range", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/search?q=mac&limit=1000", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("error-cursor", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/search?q=mac&after="+pagination.IDCursor(1).Encode(), nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	t.Run("error-method-not-allowed", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPost, "/search?q=mac", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	})
+
+	t.Run("error-searcher", func(t *testing.T) {
+		SearcherMock := new(connectors.MockDBType)
+		SearcherMock.On("SearchProducts", mock.Anything, mock.Anything).Return((*connectors.SearchResult)(nil), fmt.Errorf("connection refused")).Once()
+		ProductSearcher = SearcherMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/search?q=mac", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+		SearcherMock.AssertExpectations(t)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		queryExpect := &connectors.SearchQuery{Query: "macbok pro", Limit: 1, Offset: 1}
+		SearcherMock := new(connectors.MockDBType)
+		SearcherMock.On("SearchProducts", mock.Anything, queryExpect).Return(&connectors.SearchResult{
+			Hits:         []*connectors.SearchHit{{ProductRecord: &connectors.ProductRecord{ID: 4}, Score: 1.5}},
+			Total:        3,
+			Brands:       []*connectors.BrandFacet{{BrandID: 1, Name: "apple", Count: 3}},
+			PriceBuckets: []*connectors.PriceBucketFacet{{Currency: connectors.CurrencyIDR, Min: 1000, Count: 3}},
+		}, nil).Once()
+		ProductSearcher = SearcherMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/search?q=macbok+pro&limit=1&offset=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &struct {
+			Data searchResponse   `json:"data"`
+			Meta *pagination.Meta `json:"meta"`
+		}{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, 1, len(resBody.Data.Hits))
+		assert.Equal(t, "apple", resBody.Data.Facets.Brands[0].Name)
+		assert.Equal(t, &pagination.Meta{
+			Total:  3,
+			Limit:  1,
+			Offset: 1,
+			Next:   "/search?limit=1&offset=2&q=macbok+pro",
+			Prev:   "/search?limit=1&offset=0&q=macbok+pro",
+		}, resBody.Meta)
+		SearcherMock.AssertExpectations(t)
+	})
+}
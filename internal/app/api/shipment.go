@@ -0,0 +1,138 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type ShipmentHandler struct{}
+
+var (
+	ShipmentRepo connectors.ShipmentRepository
+
+	shipmentShipRegExp   = regexp.MustCompile(`^\/order\/ship[\/]*$`)
+	shipmentListRegExp   = regexp.MustCompile(`^\/order\/shipments[\/]*$`)
+	shipmentStatusRegExp = regexp.MustCompile(`^\/shipment\/status[\/]*$`)
+)
+
+type shipmentRequest struct {
+	TransactionID  int    `json:"transaction_id" validate:"required,numeric,gt=0"`
+	Carrier        string `json:"carrier" validate:"required,max=64"`
+	TrackingNumber string `json:"tracking_number" validate:"required,max=128"`
+	Actor          string `json:"actor" validate:"required"`
+}
+
+type shipmentStatusRequest struct {
+	ShipmentID int    `json:"shipment_id" validate:"required,numeric,gt=0"`
+	Status     string `json:"status" validate:"required,oneof=in_transit out_for_delivery delivered returned"`
+	Actor      string `json:"actor" validate:"required"`
+}
+
+func (s *ShipmentHandler) ShipmentHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && shipmentShipRegExp.MatchString(r.URL.Path):
+		s.ShipTransaction(w, r)
+	case r.Method == http.MethodGet && shipmentListRegExp.MatchString(r.URL.Path):
+		s.GetTransactionShipments(w, r)
+	case r.Method == http.MethodPost && shipmentStatusRegExp.MatchString(r.URL.Path):
+		s.UpdateShipmentStatus(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	}
+}
+
+// ShipTransaction hands a parcel of a paid order to a carrier, the first shipment of the order moves it to shipped
+func (s *ShipmentHandler) ShipTransaction(w http.ResponseWriter, r *http.Request) {
+	ship := &shipmentRequest{}
+	if !readJSONRequest(w, r, ship) {
+		return
+	}
+
+	shipment, err := ShipmentRepo.CreateShipment(r.Context(), &connectors.ShipmentRecord{
+		TransactionID:  ship.TransactionID,
+		Carrier:        ship.Carrier,
+		TrackingNumber: ship.TrackingNumber,
+		CreatedAt:      time.Now(),
+	}, ship.Actor)
+	if err != nil {
+		writeShipmentError(w, r, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/order/shipments?id=%d", shipment.TransactionID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "Success", headers, shipment, nil)
+}
+
+// GetTransactionShipments writes the shipments of the order of the id parameter, oldest first
+func (s *ShipmentHandler) GetTransactionShipments(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate transaction id exists
+	_, err := TransactionRepo.GetTransactionByTransactionID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Transaction ID not found", err)
+		return
+	}
+
+	shipments, total, err := ShipmentRepo.GetShipmentsByTransactionID(r.Context(), id, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the shipments", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(shipments), total, func(i int) *pagination.Cursor { return pagination.IDCursor(shipments[i].ID) })
+	writeHTTPPage(r.Context(), w, shipments[start:end], meta)
+}
+
+// UpdateShipmentStatus records the progress the carrier reports for a shipment,
+// delivering the last shipment on its way completes the order
+func (s *ShipmentHandler) UpdateShipmentStatus(w http.ResponseWriter, r *http.Request) {
+	status := &shipmentStatusRequest{}
+	if !readJSONRequest(w, r, status) {
+		return
+	}
+
+	shipment, err := ShipmentRepo.UpdateShipmentStatus(r.Context(), status.ShipmentID, status.Status, status.Actor)
+	if err != nil {
+		writeShipmentError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, shipment, nil)
+}
+
+// writeShipmentError writes the failure of creating or moving a shipment
+func writeShipmentError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Internal Server Error"
+	switch {
+	case errors.Is(err, connectors.ErrTransactionNotFound):
+		message = "Transaction ID not found"
+	case errors.Is(err, connectors.ErrInvalidStatusTransition):
+		message = "Transaction can not be shipped"
+	case errors.Is(err, connectors.ErrDuplicateTrackingNumber):
+		message = "Tracking number is already used by the carrier"
+	case errors.Is(err, connectors.ErrShipmentNotFound):
+		message = "Shipment ID not found"
+	case errors.Is(err, connectors.ErrInvalidShipmentTransition):
+		message = "Shipment can not be moved to the status"
+	}
+	writeHTTPError(r.Context(), w, message, err)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestShipTransaction(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/ship"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	s := `{"transaction_id": 1, "carrier": "jne", "tracking_number": "JNE123", "actor": "admin"}`
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+
+	t.Run("error-transaction-not-paid", func(t *testing.T) {
+		ShipmentRepoMock := new(connectors.MockDBType)
+		ShipmentRepoMock.On("CreateShipment", mock.Anything, mock.Anything, "admin").Return((*connectors.ShipmentRecord)(nil), connectors.ErrInvalidStatusTransition).Once()
+		ShipmentRepo = ShipmentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Transaction can not be shipped", resBody.Message)
+		assert.Equal(t, "invalid_status_transition", resBody.Error.Reason)
+	})
+
+	t.Run("error-duplicate-tracking-number", func(t *testing.T) {
+		ShipmentRepoMock := new(connectors.MockDBType)
+		ShipmentRepoMock.On("CreateShipment", mock.Anything, mock.Anything, "admin").Return((*connectors.ShipmentRecord)(nil), connectors.ErrDuplicateTrackingNumber).Once()
+		ShipmentRepo = ShipmentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "duplicate_tracking_number", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ShipmentRepoMock := new(connectors.MockDBType)
+		ShipmentRepoMock.On("CreateShipment", mock.Anything, mock.MatchedBy(func(rec *connectors.ShipmentRecord) bool {
+			return rec.TransactionID == 1 && rec.Carrier == "jne" && rec.TrackingNumber == "JNE123"
+		}), "admin").Return(&connectors.ShipmentRecord{ID: 7, TransactionID: 1, Status: connectors.ShipmentStatusInTransit}, nil).Once()
+		ShipmentRepo = ShipmentRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/order/shipments?id=1", recorder.Header().Get("Location"))
+	})
+}
+
+func TestGetTransactionShipments(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/shipments?id=1"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-transaction-not-found", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return((*connectors.TransactionRecord)(nil), connectors.ErrTransactionNotFound).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint, nil))
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(&connectors.TransactionRecord{ID: 1}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		ShipmentRepoMock := new(connectors.MockDBType)
+		ShipmentRepoMock.On("GetShipmentsByTransactionID", mock.Anything, 1, mock.Anything).Return([]*connectors.ShipmentRecord{{ID: 7, TransactionID: 1}}, 1, nil).Once()
+		ShipmentRepo = ShipmentRepoMock
+
+		recorder := httptest.NewRecorder()
+		Router.ServeHTTP(recorder, httptest.NewRequest(method, urlEndPoint, nil))
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 1)
+	})
+}
+
+func TestUpdateShipmentStatus(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/shipment/status"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-unknown-status", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		updateRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"shipment_id": 7, "status": "lost", "actor": "carrier:jne"}`)))
+		updateRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, updateRequest)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+
+	t.Run("error-invalid-shipment-transition", func(t *testing.T) {
+		ShipmentRepoMock := new(connectors.MockDBType)
+		ShipmentRepoMock.On("UpdateShipmentStatus", mock.Anything, 7, connectors.ShipmentStatusReturned, "carrier:jne").Return((*connectors.ShipmentRecord)(nil), connectors.ErrInvalidShipmentTransition).Once()
+		ShipmentRepo = ShipmentRepoMock
+
+		recorder := httptest.NewRecorder()
+		updateRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"shipment_id": 7, "status": "returned", "actor": "carrier:jne"}`)))
+		updateRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, updateRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Shipment can not be moved to the status", resBody.Message)
+		assert.Equal(t, "invalid_shipment_transition", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ShipmentRepoMock := new(connectors.MockDBType)
+		ShipmentRepoMock.On("UpdateShipmentStatus", mock.Anything, 7, connectors.ShipmentStatusDelivered, "carrier:jne").Return(&connectors.ShipmentRecord{ID: 7, Status: connectors.ShipmentStatusDelivered}, nil).Once()
+		ShipmentRepo = ShipmentRepoMock
+
+		recorder := httptest.NewRecorder()
+		updateRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"shipment_id": 7, "status": "delivered", "actor": "carrier:jne"}`)))
+		updateRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, updateRequest)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		ShipmentRepoMock.AssertExpectations(t)
+	})
+}
@@ -1,16 +1,19 @@
 package api
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"io/ioutil"
+	"errors"
+	"fmt"
 	"net/http"
-	"strconv"
+	"regexp"
 	"time"
 
+	"github.com/arieffian/mw-backend-test/internal/config"
 	"github.com/arieffian/mw-backend-test/internal/connectors"
-	"github.com/arieffian/mw-backend-test/internal/constants/response"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	"github.com/arieffian/mw-backend-test/pkg/helpers"
-	"github.com/go-playground/validator"
 )
 
 type TransactionHandler struct{}
@@ -18,25 +21,66 @@ type TransactionHandler struct{}
 var (
 	TransactionRepo connectors.TransactionRepository
 	UserRepo        connectors.UserRepository
+
+	transactionRegExp        = regexp.MustCompile(`^\/order[\/]*$`)
+	transactionCancelRegExp  = regexp.MustCompile(`^\/order\/cancel[\/]*$`)
+	transactionStatusRegExp  = regexp.MustCompile(`^\/order\/status[\/]*$`)
+	transactionHistoryRegExp = regexp.MustCompile(`^\/order\/history[\/]*$`)
+)
+
+const (
+	// idempotencyKeyHeader makes a retried POST /order replay the first response instead of ordering twice
+	idempotencyKeyHeader = "Idempotency-Key"
+
+	// idempotentReplayedHeader set on a response replayed from an idempotency key
+	idempotentReplayedHeader = "Idempotent-Replayed"
+
+	// idempotencyKeyMaxLength the size of idempotency_keys.idempotency_key
+	idempotencyKeyMaxLength = 255
 )
 
 type transactionRequest struct {
-	UserID int                        `json:"user_id" validate:"required,numeric,gt=0"`
-	Detail []trasanctionDetailRequest `json:"detail" validate:"required"`
+	UserID     int                        `json:"user_id" validate:"required,numeric,gt=0"`
+	Detail     []trasanctionDetailRequest `json:"detail" validate:"required,dive"`
+	CouponCode string                     `json:"coupon_code" validate:"omitempty,max=64"`
+
+	// AddressID the address of the user the order is shipped to, its default address when it is not given
+	AddressID int `json:"address_id" validate:"omitempty,numeric,gt=0"`
 }
 
 type trasanctionDetailRequest struct {
 	ProductID int `json:"product_id" validate:"required,numeric,gt=0"`
 	Qty       int `json:"qty" validate:"required,numeric,gt=0"`
+
+	// VariantID the variant of the product that is ordered, the product itself when it is not given
+	VariantID int `json:"variant_id,omitempty" validate:"omitempty,numeric,gt=0"`
+}
+
+type transactionCancelRequest struct {
+	TransactionID int    `json:"transaction_id" validate:"required,numeric,gt=0"`
+	Actor         string `json:"actor" validate:"required"`
+}
+
+// transactionStatusRequest moves an order by hand, it is only paid or refunded through its payment, see PaymentHandler
+type transactionStatusRequest struct {
+	TransactionID int    `json:"transaction_id" validate:"required,numeric,gt=0"`
+	Status        string `json:"status" validate:"required,oneof=pending shipped completed cancelled"`
+	Actor         string `json:"actor" validate:"required"`
 }
 
 func (t *TransactionHandler) TransactionHttpHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("content-type", "application/json")
 	switch {
-	case r.Method == http.MethodPost:
+	case r.Method == http.MethodPost && transactionRegExp.MatchString(r.URL.Path):
 		t.CreateTransaction(w, r)
-	case r.Method == http.MethodGet:
+	case r.Method == http.MethodGet && transactionRegExp.MatchString(r.URL.Path):
 		t.GetTransactionByID(w, r)
+	case r.Method == http.MethodPost && transactionCancelRegExp.MatchString(r.URL.Path):
+		t.CancelTransaction(w, r)
+	case r.Method == http.MethodPost && transactionStatusRegExp.MatchString(r.URL.Path):
+		t.UpdateTransactionStatus(w, r)
+	case r.Method == http.MethodGet && transactionHistoryRegExp.MatchString(r.URL.Path):
+		t.GetTransactionStatusHistory(w, r)
 	default:
 		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
 	}
@@ -45,43 +89,35 @@ func (t *TransactionHandler) TransactionHttpHandler(w http.ResponseWriter, r *ht
 func (t *TransactionHandler) CreateTransaction(w http.ResponseWriter, r *http.Request) {
 	transaction := &transactionRequest{}
 
-	//Unmarshal json
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		errJSON := &helpers.ErrorJSON{
-			Message:      "Error when parse Body request",
-			Reason:       "internal_error",
-			ErrTittleMsg: "Error parsing request",
-			ErrBodyMsg:   response.Get("general", http.StatusInternalServerError, ""),
-		}
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "", nil, nil, errJSON)
+	idempotencyKey := r.Header.Get(idempotencyKeyHeader)
+	if len(idempotencyKey) > idempotencyKeyMaxLength {
+		err := connectors.NewBadRequestError(fmt.Errorf("%s is longer than %d characters", idempotencyKeyHeader, idempotencyKeyMaxLength))
+		writeHTTPError(r.Context(), w, "Invalid Idempotency-Key", err)
 		return
 	}
 
-	err = json.Unmarshal(body, &transaction)
-	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Error processing request", nil, nil, nil)
+	if !readJSONRequest(w, r, transaction) {
 		return
 	}
 
-	//validate json input
-	validate = validator.New()
-	err = validate.Struct(transaction)
+	// validate user id exists
+	_, err := UserRepo.GetUserByID(r.Context(), transaction.UserID)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Invalid json structure", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "User ID not found", err)
 		return
 	}
 
-	// validate user id exists
-	_, err = UserRepo.GetUserByID(r.Context(), transaction.UserID)
+	address, err := shippingAddress(r.Context(), transaction.UserID, transaction.AddressID)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "User ID not found", nil, nil, nil)
+		writeCreateTransactionError(w, r, err)
 		return
 	}
 
 	trans := &connectors.TransactionRecord{
-		UserID: transaction.UserID,
-		Date:   time.Now(),
+		UserID:          transaction.UserID,
+		Date:            time.Now(),
+		CouponCode:      transaction.CouponCode,
+		ShippingAddress: address,
 	}
 
 	detail := []*connectors.TransactionDetailRecord{}
@@ -89,47 +125,190 @@ func (t *TransactionHandler) CreateTransaction(w http.ResponseWriter, r *http.Re
 		// validate product id exists
 		_, err = ProductRepo.GetProductByID(r.Context(), transaction.Detail[i].ProductID)
 		if err != nil {
-			helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Product ID not found", nil, nil, nil)
+			writeHTTPError(r.Context(), w, "Product ID not found", err)
 			return
 		}
 		detail = append(detail, &connectors.TransactionDetailRecord{
 			ProductID: transaction.Detail[i].ProductID,
+			VariantID: transaction.Detail[i].VariantID,
 			Qty:       transaction.Detail[i].Qty,
 		})
 	}
 
 	trans.TransactionDetail = detail
 
-	_, err = TransactionRepo.CreateTransaction(r.Context(), trans)
+	if idempotencyKey != "" {
+		t.createTransactionIdempotent(w, r, trans, transaction, idempotencyKey)
+		return
+	}
+
+	result, err := TransactionRepo.CreateTransaction(r.Context(), trans)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Internal Server Error", nil, nil, nil)
+		writeCreateTransactionError(w, r, err)
 		return
 	}
 
-	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, nil, nil)
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/order?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "Success", headers, result, nil)
 }
 
-func (t *TransactionHandler) GetTransactionByID(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query()
-
-	//check if id present and greater than 0
-	sID := query.Get("id")
-	if sID == "" {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Parameter ID not found", nil, nil, nil)
+// createTransactionIdempotent creates the transaction once per idempotency key and replays the stored response on every repeat
+func (t *TransactionHandler) createTransactionIdempotent(w http.ResponseWriter, r *http.Request, trans *connectors.TransactionRecord, request *transactionRequest, key string) {
+	// the hash is taken from the decoded request, so a retry formatting its json differently is still the same request
+	canonical, err := json.Marshal(request)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Internal Server Error", err)
 		return
 	}
+	hash := sha256.Sum256(canonical)
+
+	now := time.Now()
+	idem := &connectors.IdempotencyRecord{
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash[:]),
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(time.Duration(config.GetInt("order.idempotency.ttl")) * time.Hour),
+	}
 
-	//check if id is number or not
-	id, err := strconv.Atoi(sID)
+	respond := func(result *connectors.TransactionRecord) (int, []byte, error) {
+		body, err := json.Marshal(result)
+		return http.StatusCreated, body, err
+	}
+
+	stored, replayed, err := TransactionRepo.CreateTransactionIdempotent(r.Context(), trans, idem, respond)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Parameter ID is not numeric", nil, nil, nil)
+		writeCreateTransactionError(w, r, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/order?id=%d", stored.TransactionID),
+	}
+	if replayed {
+		headers[idempotentReplayedHeader] = "true"
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, stored.ResponseStatus, "Success", headers, json.RawMessage(stored.ResponseBody), nil)
+}
+
+// writeCreateTransactionError writes the failure of creating a transaction
+func writeCreateTransactionError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Internal Server Error"
+	switch {
+	case errors.Is(err, connectors.ErrInsufficientStock):
+		message = "Product qty is not enough"
+	case errors.Is(err, connectors.ErrProductNotFound):
+		message = "Product ID not found"
+	case errors.Is(err, connectors.ErrVariantNotFound):
+		message = "Variant ID not found"
+	case errors.Is(err, connectors.ErrCouponNotFound):
+		message = "Coupon code not found"
+	case errors.Is(err, connectors.ErrCouponNotApplicable):
+		message = "Coupon can not be applied to the order"
+	case errors.Is(err, connectors.ErrCouponUsageExceeded):
+		message = "Coupon usage limit is reached"
+	case errors.Is(err, connectors.ErrCurrencyMismatch):
+		message = "Products of different currencies can not be ordered together"
+	case errors.Is(err, connectors.ErrMoneyOverflow):
+		message = "Order amount is too large"
+	case errors.Is(err, connectors.ErrCartEmpty):
+		message = "Cart is empty"
+	case errors.Is(err, connectors.ErrIdempotencyKeyReused):
+		message = "Idempotency-Key is already used with a different request"
+	case errors.Is(err, connectors.ErrAddressNotFound):
+		message = "Address ID not found"
+	}
+	writeHTTPError(r.Context(), w, message, err)
+}
+
+func (t *TransactionHandler) GetTransactionByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
 		return
 	}
 
 	transaction, err := TransactionRepo.GetTransactionByTransactionID(r.Context(), id)
 	if err != nil {
-		helpers.WriteHTTPResponse(r.Context(), w, http.StatusInternalServerError, "Error fetching the transaction", nil, nil, nil)
+		writeHTTPError(r.Context(), w, "Transaction ID not found", err)
+		return
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, transaction, nil)
+}
+
+func (t *TransactionHandler) CancelTransaction(w http.ResponseWriter, r *http.Request) {
+	cancel := &transactionCancelRequest{}
+
+	if !readJSONRequest(w, r, cancel) {
+		return
+	}
+
+	t.writeTransactionStatus(w, r, cancel.TransactionID, connectors.TransactionStatusCancelled, cancel.Actor)
+}
+
+func (t *TransactionHandler) UpdateTransactionStatus(w http.ResponseWriter, r *http.Request) {
+	status := &transactionStatusRequest{}
+	if !decodeJSONRequest(w, r, status) {
+		return
+	}
+
+	// paying or refunding by hand would take or give back the stock while the gateway holds no money for it
+	if status.Status == connectors.TransactionStatusPaid || status.Status == connectors.TransactionStatusRefunded {
+		writeHTTPError(r.Context(), w, fmt.Sprintf("Transaction can only be %s through its payment", status.Status), connectors.ErrInvalidStatusTransition)
+		return
+	}
+
+	if !validateRequest(w, r, status) {
+		return
+	}
+
+	t.writeTransactionStatus(w, r, status.TransactionID, status.Status, status.Actor)
+}
+
+// writeTransactionStatus moves the transaction to status and writes the updated transaction as response
+func (t *TransactionHandler) writeTransactionStatus(w http.ResponseWriter, r *http.Request, transactionID int, status string, actor string) {
+	transaction, err := TransactionRepo.UpdateTransactionStatus(r.Context(), transactionID, status, actor)
+	if err != nil {
+		message := "Internal Server Error"
+		switch {
+		case errors.Is(err, connectors.ErrInvalidStatusTransition):
+			message = fmt.Sprintf("Transaction can not be moved to %s", status)
+		case errors.Is(err, connectors.ErrTransactionNotFound):
+			message = "Transaction ID not found"
+		case errors.Is(err, connectors.ErrInsufficientStock):
+			message = "Product qty is not enough"
+		}
+		writeHTTPError(r.Context(), w, message, err)
 		return
 	}
+
 	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, transaction, nil)
 }
+
+func (t *TransactionHandler) GetTransactionStatusHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate transaction id exists
+	_, err := TransactionRepo.GetTransactionByTransactionID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Transaction ID not found", err)
+		return
+	}
+
+	history, total, err := TransactionRepo.GetTransactionStatusHistory(r.Context(), id, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the transaction history", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(history), total, func(i int) *pagination.Cursor { return pagination.IDCursor(history[i].ID) })
+	writeHTTPPage(r.Context(), w, history[start:end], meta)
+}
@@ -2,15 +2,19 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"testing/iotest"
 
 	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/constants/response"
 	"github.com/arieffian/mw-backend-test/pkg/helpers"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
@@ -36,16 +40,16 @@ func TestTransactionByID(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Parameter ID not found",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -66,16 +70,16 @@ func TestTransactionByID(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Parameter ID is not numeric",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read: strconv.Atoi: parsing \"a\": invalid syntax",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -117,6 +121,11 @@ func TestCreateTransaction(t *testing.T) {
 	Router = http.NewServeMux()
 	InitializeRouter()
 
+	// the users ordering have no address book unless a test sets its own
+	AddressRepoMock := new(connectors.MockDBType)
+	AddressRepoMock.On("GetDefaultAddress", mock.Anything, mock.Anything).Return((*connectors.AddressRecord)(nil), connectors.ErrAddressNotFound)
+	AddressRepo = AddressRepoMock
+
 	t.Run("error-unmarshal", func(t *testing.T) {
 		recorder := httptest.NewRecorder()
 		createRequest := httptest.NewRequest(method, urlEndPoint, iotest.DataErrReader(bytes.NewReader(nil)))
@@ -127,16 +136,16 @@ func TestCreateTransaction(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusBadRequest
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Error processing request",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "request can not be read: unexpected end of JSON input",
+				Reason:       "bad_request",
+				ErrTittleMsg: "Bad Request",
+				ErrBodyMsg:   "Bad Request",
 			},
 		}
 
@@ -144,9 +153,29 @@ func TestCreateTransaction(t *testing.T) {
 		assert.Equal(t, dataExpect, resBody)
 	})
 
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"detail": [{"product_id": 1, "qty": -1}]}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		fieldsExpect := []*helpers.FieldErrorJSON{
+			{Field: "user_id", Tag: "required", Param: "", Message: "user_id is a required field"},
+			{Field: "detail[0].qty", Tag: "gt", Param: "0", Message: "qty must be greater than 0"},
+		}
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "validation_failed", resBody.Error.Reason)
+		assert.Equal(t, fieldsExpect, resBody.Error.Fields)
+	})
+
 	t.Run("error-user-not-found", func(t *testing.T) {
 		UserRepoMock := new(connectors.MockDBType)
-		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, fmt.Errorf("product not found")).Once()
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, connectors.ErrUserNotFound).Once()
 		UserRepo = UserRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -161,16 +190,16 @@ func TestCreateTransaction(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusNotFound
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "User ID not found",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "user not found",
+				Reason:       "user_not_found",
+				ErrTittleMsg: "Not Found",
+				ErrBodyMsg:   "Not Found",
 			},
 		}
 
@@ -184,7 +213,7 @@ func TestCreateTransaction(t *testing.T) {
 		UserRepo = UserRepoMock
 
 		ProductRepoMock := new(connectors.MockDBType)
-		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, fmt.Errorf("product not found")).Once()
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, connectors.ErrProductNotFound).Once()
 		ProductRepo = ProductRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -199,16 +228,16 @@ func TestCreateTransaction(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusNotFound
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
 			Message: "Product ID not found",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "product not found",
+				Reason:       "product_not_found",
+				ErrTittleMsg: "Not Found",
+				ErrBodyMsg:   "Not Found",
 			},
 		}
 
@@ -226,7 +255,7 @@ func TestCreateTransaction(t *testing.T) {
 		ProductRepo = ProductRepoMock
 
 		TransactionRepoMock := new(connectors.MockDBType)
-		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.Anything).Return("", fmt.Errorf("product qty is not enoug")).Once()
+		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.Anything).Return(&connectors.TransactionRecord{}, connectors.ErrInsufficientStock).Once()
 		TransactionRepo = TransactionRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -241,16 +270,16 @@ func TestCreateTransaction(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
-		httpCode := http.StatusInternalServerError
+		httpCode := http.StatusConflict
 		dataExpect := &helpers.ResponseJSON{
 			Status:  httpCode,
-			Message: "Internal Server Error",
+			Message: "Product qty is not enough",
 			Data:    nil,
 			Error: &helpers.ErrorJSON{
-				Message:      "Internal Server Error",
-				Reason:       "",
-				ErrTittleMsg: "Internal Server Error",
-				ErrBodyMsg:   "Internal Server Error",
+				Message:      "product qty is not enough",
+				Reason:       "insufficient_stock",
+				ErrTittleMsg: "Conflict",
+				ErrBodyMsg:   "Conflict",
 			},
 		}
 
@@ -268,7 +297,7 @@ func TestCreateTransaction(t *testing.T) {
 		ProductRepo = ProductRepoMock
 
 		TransactionRepoMock := new(connectors.MockDBType)
-		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.Anything).Return("", nil).Once()
+		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.Anything).Return(&connectors.TransactionRecord{ID: 2, UserID: 1, GrandTotal: connectors.NewMoney(3300, connectors.CurrencyIDR)}, nil).Once()
 		TransactionRepo = TransactionRepoMock
 
 		recorder := httptest.NewRecorder()
@@ -283,11 +312,474 @@ func TestCreateTransaction(t *testing.T) {
 		resBody := &helpers.ResponseJSON{}
 		json.Unmarshal(rawBody, resBody)
 
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/order?id=2", recorder.Header().Get("Location"))
+
+	})
+
+	t.Run("error-variant-not-found", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.MatchedBy(func(rec *connectors.TransactionRecord) bool {
+			return rec.TransactionDetail[0].ProductID == 1 && rec.TransactionDetail[0].VariantID == 7
+		})).Return((*connectors.TransactionRecord)(nil), connectors.ErrVariantNotFound).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"variant_id": 7,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Variant ID not found", resBody.Message)
+		TransactionRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-coupon-not-applicable", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		var rec *connectors.TransactionRecord
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { rec = args.Get(1).(*connectors.TransactionRecord) }).
+			Return(&connectors.TransactionRecord{}, connectors.ErrCouponNotApplicable).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"qty": 1}],"coupon_code": "SAVE10"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Coupon can not be applied to the order", resBody.Message)
+		assert.Equal(t, "coupon_not_applicable", resBody.Error.Reason)
+		assert.Equal(t, "SAVE10", rec.CouponCode)
+	})
+
+	t.Run("error-currency-mismatch", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, nil).Twice()
+		ProductRepo = ProductRepoMock
+
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.Anything).Return(&connectors.TransactionRecord{}, connectors.ErrCurrencyMismatch).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"qty": 1},{"product_id": 4,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Products of different currencies can not be ordered together", resBody.Message)
+		assert.Equal(t, "currency_mismatch", resBody.Error.Reason)
+	})
+
+	t.Run("error-idempotency-key-too-long", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		createRequest.Header.Add("Idempotency-Key", strings.Repeat("k", 256))
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Invalid Idempotency-Key", resBody.Message)
+	})
+
+	t.Run("error-idempotency-key-reused", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("CreateTransactionIdempotent", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return((*connectors.IdempotencyRecord)(nil), false, connectors.ErrIdempotencyKeyReused).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		createRequest.Header.Add("Idempotency-Key", "key-1")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Idempotency-Key is already used with a different request", resBody.Message)
+		assert.Equal(t, "idempotency_key_reused", resBody.Error.Reason)
+	})
+
+	t.Run("idempotent-replay", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		var idem *connectors.IdempotencyRecord
+		stored := &connectors.IdempotencyRecord{Key: "key-1", TransactionID: 2, ResponseStatus: http.StatusCreated, ResponseBody: []byte(`{"id":2,"user_id":1}`)}
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("CreateTransactionIdempotent", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { idem = args.Get(2).(*connectors.IdempotencyRecord) }).
+			Return(stored, true, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		createRequest.Header.Add("Idempotency-Key", "key-1")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		assert.Equal(t, "/order?id=2", recorder.Header().Get("Location"))
+		assert.Equal(t, "true", recorder.Header().Get("Idempotent-Replayed"))
+		assert.Equal(t, map[string]interface{}{"id": float64(2), "user_id": float64(1)}, resBody.Data)
+		assert.Equal(t, "key-1", idem.Key)
+		assert.Len(t, idem.RequestHash, 64)
+		assert.True(t, idem.ExpiresAt.After(idem.CreatedAt))
+	})
+
+	t.Run("error-address-of-another-user", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("GetAddressByID", mock.Anything, 4).Return(&connectors.AddressRecord{ID: 4, UserID: 2}, nil).Once()
+		AddressRepo = AddressRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"address_id": 4,"detail": [{"product_id": 1,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Address ID not found", resBody.Message)
+		assert.Equal(t, "address_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success-shipped-to-default-address", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, mock.Anything).Return(&connectors.UserRecord{}, nil).Once()
+		UserRepo = UserRepoMock
+
+		AddressRepoMock := new(connectors.MockDBType)
+		AddressRepoMock.On("GetDefaultAddress", mock.Anything, 1).Return(&connectors.AddressRecord{ID: 3, UserID: 1, PostalAddress: connectors.PostalAddress{City: "surabaya"}}, nil).Once()
+		AddressRepo = AddressRepoMock
+
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, mock.Anything).Return(&connectors.ProductRecord{}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("CreateTransaction", mock.Anything, mock.MatchedBy(func(rec *connectors.TransactionRecord) bool {
+			return rec.ShippingAddress != nil && rec.ShippingAddress.City == "surabaya"
+		})).Return(&connectors.TransactionRecord{ID: 2, UserID: 1}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"user_id": 1,"detail": [{"product_id": 1,"qty": 1}]}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusCreated, recorder.Code)
+		TransactionRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestCreateTransactionConcurrent(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("no-oversell", func(t *testing.T) {
+		db := connectors.NewInMemoryDB()
+		product, _ := db.CreateProduct(context.Background(), &connectors.ProductRecord{BrandID: 1, Name: "macbook air", Qty: 5, Price: connectors.NewMoney(900, connectors.CurrencyIDR)})
+		UserRepo = db
+		ProductRepo = db
+		TransactionRepo = db
+		AddressRepo = db
+
+		var wg sync.WaitGroup
+		codes := make(chan int, 20)
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				recorder := httptest.NewRecorder()
+				s := fmt.Sprintf(`{"user_id": 1,"detail": [{"product_id": %d,"qty": 1}]}`, product.ID)
+				createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+				createRequest.Header.Add("Content-Type", "application/json")
+				Router.ServeHTTP(recorder, createRequest)
+				codes <- recorder.Code
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		created := 0
+		for code := range codes {
+			if code == http.StatusCreated {
+				created++
+			}
+		}
+
+		stock, _ := db.GetProductByID(context.Background(), product.ID)
+		assert.Equal(t, 5, created)
+		assert.Equal(t, 5, stock.Qty)
+		assert.Equal(t, 0, stock.Available)
+	})
+}
+
+func TestCancelTransaction(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/cancel"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-invalid-transition", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("UpdateTransactionStatus", mock.Anything, 1, connectors.TransactionStatusCancelled, "donny").Return(&connectors.TransactionRecord{}, connectors.ErrInvalidStatusTransition).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "actor": "donny"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Transaction can not be moved to cancelled", resBody.Message)
+		assert.Equal(t, "invalid_status_transition", resBody.Error.Reason)
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("UpdateTransactionStatus", mock.Anything, 100, connectors.TransactionStatusCancelled, "donny").Return((*connectors.TransactionRecord)(nil), connectors.ErrTransactionNotFound).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 100, "actor": "donny"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Transaction ID not found", resBody.Message)
+		assert.Equal(t, "transaction_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("UpdateTransactionStatus", mock.Anything, 1, connectors.TransactionStatusCancelled, "donny").Return(&connectors.TransactionRecord{ID: 1, Status: connectors.TransactionStatusCancelled}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "actor": "donny"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
 		if recorder.Code != http.StatusOK {
 			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
 			t.FailNow()
 		}
+		TransactionRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestUpdateTransactionStatus(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/status"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-unknown-status", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "status": "lost", "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+		assert.Equal(t, []*helpers.FieldErrorJSON{
+			{Field: "status", Tag: "oneof", Param: "pending shipped completed cancelled", Message: "status must be one of [pending shipped completed cancelled]"},
+		}, resBody.Error.Fields)
+	})
+
+	t.Run("error-paid-or-refunded-by-hand", func(t *testing.T) {
+		for _, status := range []string{connectors.TransactionStatusPaid, connectors.TransactionStatusRefunded} {
+			TransactionRepoMock := new(connectors.MockDBType)
+			TransactionRepo = TransactionRepoMock
+
+			recorder := httptest.NewRecorder()
+			createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(fmt.Sprintf(`{"transaction_id": 1, "status": "%s", "actor": "admin"}`, status))))
+			createRequest.Header.Add("Content-Type", "application/json")
+			Router.ServeHTTP(recorder, createRequest)
+
+			rawBody, _ := ioutil.ReadAll(recorder.Body)
+			resBody := &helpers.ResponseJSON{}
+			json.Unmarshal(rawBody, resBody)
+
+			assert.Equal(t, http.StatusConflict, recorder.Code)
+			assert.Equal(t, fmt.Sprintf("Transaction can only be %s through its payment", status), resBody.Message)
+			assert.Equal(t, "invalid_status_transition", resBody.Error.Reason)
+			TransactionRepoMock.AssertNotCalled(t, "UpdateTransactionStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("error-overridden-message", func(t *testing.T) {
+		response.SetConfig("validation.422.required", "please fill in {field}")
+		defer response.SetConfig("validation.422.required", "")
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "status": "shipped"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
 
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, []*helpers.FieldErrorJSON{
+			{Field: "actor", Tag: "required", Param: "", Message: "please fill in actor"},
+		}, resBody.Error.Fields)
 	})
 
+	t.Run("success", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("UpdateTransactionStatus", mock.Anything, 1, connectors.TransactionStatusShipped, "admin").Return(&connectors.TransactionRecord{ID: 1, Status: connectors.TransactionStatusShipped}, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"transaction_id": 1, "status": "shipped", "actor": "admin"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+	})
+}
+
+func TestGetTransactionStatusHistory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/order/history?id=1"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("success", func(t *testing.T) {
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionByTransactionID", mock.Anything, 1).Return(&connectors.TransactionRecord{ID: 1}, nil).Once()
+		TransactionRepoMock.On("GetTransactionStatusHistory", mock.Anything, 1, mock.Anything).Return([]*connectors.TransactionStatusHistoryRecord{{ID: 1, TransactionID: 1, ToStatus: "pending"}}, 1, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+	})
 }
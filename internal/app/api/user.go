@@ -0,0 +1,278 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type UserHandler struct{}
+
+var (
+	userRegExp       = regexp.MustCompile(`^\/user[\/]*$`)
+	userOrdersRegExp = regexp.MustCompile(`^\/user\/orders[\/]*$`)
+)
+
+type userRequest struct {
+	Name    string `json:"name" validate:"required"`
+	Email   string `json:"email" validate:"required,email"`
+	Address string `json:"address"`
+}
+
+type userPatchRequest struct {
+	Name    *string `json:"name" validate:"omitempty,min=1"`
+	Email   *string `json:"email" validate:"omitempty,email"`
+	Address *string `json:"address"`
+}
+
+func (u *UserHandler) UserHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case r.Method == http.MethodGet && userOrdersRegExp.MatchString(r.URL.Path):
+		u.GetUserOrders(w, r)
+	case !userRegExp.MatchString(r.URL.Path):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	case r.Method == http.MethodPost:
+		u.CreateUser(w, r)
+	case r.Method == http.MethodGet && r.URL.Query().Get("id") == "":
+		u.GetUsers(w, r)
+	case r.Method == http.MethodGet:
+		u.GetUserByID(w, r)
+	case r.Method == http.MethodPut:
+		u.UpdateUser(w, r)
+	case r.Method == http.MethodPatch:
+		u.PatchUser(w, r)
+	case r.Method == http.MethodDelete:
+		u.DeleteUser(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	}
+}
+
+func (u *UserHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	user := &userRequest{}
+
+	if !readJSONRequest(w, r, user) {
+		return
+	}
+
+	uRecord := &connectors.UserRecord{
+		Name:    user.Name,
+		Email:   user.Email,
+		Address: user.Address,
+	}
+
+	// insert to database
+	result, err := UserRepo.CreateUser(r.Context(), uRecord)
+	if err != nil {
+		message := "Internal server error"
+		if errors.Is(err, connectors.ErrDuplicateEmail) {
+			message = "Email is already registered"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/user?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "user created successfully", headers, result, nil)
+}
+
+func (u *UserHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	users, total, err := UserRepo.GetUsers(r.Context(), page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the user", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(users), total, func(i int) *pagination.Cursor { return pagination.IDCursor(users[i].ID) })
+	writeHTTPPage(r.Context(), w, users[start:end], meta)
+}
+
+func (u *UserHandler) GetUserByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := UserRepo.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, user, nil)
+}
+
+func (u *UserHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	user := &userRequest{}
+
+	if !readJSONRequest(w, r, user) {
+		return
+	}
+
+	//validate user id exists
+	uRecord, err := UserRepo.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	uRecord.Name = user.Name
+	uRecord.Email = user.Email
+	uRecord.Address = user.Address
+
+	u.saveUser(w, r, uRecord)
+}
+
+func (u *UserHandler) PatchUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	user := &userPatchRequest{}
+
+	if !readJSONRequest(w, r, user) {
+		return
+	}
+
+	//validate user id exists
+	uRecord, err := UserRepo.GetUserByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	// only the fields present in the body are changed
+	if user.Name != nil {
+		uRecord.Name = *user.Name
+	}
+	if user.Email != nil {
+		uRecord.Email = *user.Email
+	}
+	if user.Address != nil {
+		uRecord.Address = *user.Address
+	}
+
+	u.saveUser(w, r, uRecord)
+}
+
+// saveUser writes the updated user to database and writes it as response
+func (u *UserHandler) saveUser(w http.ResponseWriter, r *http.Request, uRecord *connectors.UserRecord) {
+	result, err := UserRepo.UpdateUser(r.Context(), uRecord)
+	if err != nil {
+		message := "Internal server error"
+		switch {
+		case errors.Is(err, connectors.ErrDuplicateEmail):
+			message = "Email is already registered"
+		case errors.Is(err, connectors.ErrUserNotFound):
+			// deleted after it was read above
+			message = "User ID not found"
+		}
+		writeHTTPError(r.Context(), w, message, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, uRecord, nil)
+}
+
+func (u *UserHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := UserRepo.DeleteUser(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+}
+
+// GetUserOrders lists the orders of a user newest first, one page at a time.
+// from and to accept RFC 3339 timestamps or dates, a date in to includes the whole day.
+func (u *UserHandler) GetUserOrders(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	userID, ok := parseQueryInt(w, r, "user_id")
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	filter := &connectors.TransactionFilter{
+		UserID: userID,
+		Page:   page.Fetch(),
+	}
+
+	if sFrom := query.Get("from"); sFrom != "" {
+		from, _, err := parseDateParam(sFrom)
+		if err != nil {
+			writeHTTPError(r.Context(), w, "Parameter from is not a valid date", connectors.NewBadRequestError(err))
+			return
+		}
+		filter.From = from
+	}
+
+	if sTo := query.Get("to"); sTo != "" {
+		to, dateOnly, err := parseDateParam(sTo)
+		if err != nil {
+			writeHTTPError(r.Context(), w, "Parameter to is not a valid date", connectors.NewBadRequestError(err))
+			return
+		}
+		if dateOnly {
+			to = to.AddDate(0, 0, 1)
+		}
+		filter.To = to
+	}
+
+	//validate user id exists
+	_, err := UserRepo.GetUserByID(r.Context(), userID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "User ID not found", err)
+		return
+	}
+
+	orders, total, err := TransactionRepo.GetTransactionsByUserID(r.Context(), filter)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the orders", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(orders), total, func(i int) *pagination.Cursor { return connectors.TransactionCursor(orders[i]) })
+	writeHTTPPage(r.Context(), w, orders[start:end], meta)
+}
+
+// parseDateParam parses a RFC 3339 timestamp or a date, dateOnly reports which one was given
+func parseDateParam(value string) (t time.Time, dateOnly bool, err error) {
+	t, err = time.Parse(time.RFC3339, value)
+	if err == nil {
+		return t, false, nil
+	}
+	t, err = time.ParseInLocation("2006-01-02", value, time.Local)
+	return t, true, err
+}
@@ -0,0 +1,354 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/user"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-email", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"name": "donny", "email": "donny"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-duplicate-email", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("CreateUser", mock.Anything, mock.Anything).Return((*connectors.UserRecord)(nil), connectors.ErrDuplicateEmail).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"name": "donny", "email": "donny@arieffian.com"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Email is already registered", resBody.Message)
+		assert.Equal(t, "duplicate_email", resBody.Error.Reason)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("CreateUser", mock.Anything, &connectors.UserRecord{Name: "donny", Email: "donny@example.com", Address: "jakarta"}).
+			Return(&connectors.UserRecord{ID: 3, Name: "donny", Email: "donny@example.com", Address: "jakarta"}, nil).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(`{"name": "donny", "email": "donny@example.com", "address": "jakarta"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/user?id=3", recorder.Header().Get("Location"))
+		UserRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetUsers(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("success-list", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUsers", mock.Anything, mock.Anything).Return([]*connectors.UserRecord{{ID: 1, Name: "donny"}}, 1, nil).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		UserRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("error-get-not-found", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 100).Return((*connectors.UserRecord)(nil), connectors.ErrUserNotFound).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user?id=100", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "User ID not found", resBody.Message)
+		assert.Equal(t, "user_not_found", resBody.Error.Reason)
+	})
+
+	t.Run("success-get", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1, Name: "donny"}, nil).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user?id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		UserRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/user?id=1"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-email", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"email": "donny"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-duplicate-email", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1, Name: "donny", Email: "donny@arieffian.com"}, nil).Once()
+		UserRepoMock.On("UpdateUser", mock.Anything, mock.Anything).Return("", connectors.ErrDuplicateEmail).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"email": "jane@arieffian.com"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+	})
+
+	t.Run("success-put", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1, Name: "donny", Email: "donny@arieffian.com", Address: "jakarta"}, nil).Once()
+		UserRepoMock.On("UpdateUser", mock.Anything, &connectors.UserRecord{ID: 1, Name: "donny arieffian", Email: "donny@example.com"}).Return("success", nil).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPut, urlEndPoint, bytes.NewReader([]byte(`{"name": "donny arieffian", "email": "donny@example.com"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		UserRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-patch", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1, Name: "donny", Email: "donny@arieffian.com", Address: "jakarta"}, nil).Once()
+		UserRepoMock.On("UpdateUser", mock.Anything, &connectors.UserRecord{ID: 1, Name: "donny", Email: "donny@arieffian.com", Address: "bandung"}).Return("success", nil).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodPatch, urlEndPoint, bytes.NewReader([]byte(`{"address": "bandung"}`)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		UserRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/user?id=1"
+	method := "DELETE"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-not-found", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("DeleteUser", mock.Anything, 1).Return("", connectors.ErrUserNotFound).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("DeleteUser", mock.Anything, 1).Return("user deleted successfully", nil).Once()
+		UserRepo = UserRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint, nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		UserRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetUserOrders(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-user-id-not-found", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user/orders", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Parameter user_id not found", resBody.Message)
+		assert.Equal(t, "bad_request", resBody.Error.Reason)
+	})
+
+	t.Run("error-invalid-cursor", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user/orders?user_id=1&after=abc", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, "Parameter after is not a valid cursor", resBody.Message)
+	})
+
+	t.Run("success-next-cursor", func(t *testing.T) {
+		date := time.Date(2021, 9, 2, 10, 0, 0, 0, time.Local)
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1}, nil).Once()
+		UserRepo = UserRepoMock
+
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionsByUserID", mock.Anything, &connectors.TransactionFilter{
+			UserID: 1,
+			From:   time.Date(2021, 9, 1, 0, 0, 0, 0, time.Local),
+			To:     time.Date(2021, 9, 3, 0, 0, 0, 0, time.Local),
+			Page:   &pagination.Page{Limit: 2},
+		}).Return([]*connectors.TransactionRecord{
+			{ID: 3, UserID: 1, Date: date},
+			{ID: 2, UserID: 1, Date: date},
+		}, 5, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user/orders?user_id=1&limit=1&from=2021-09-01&to=2021-09-02", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+
+		resBody := &struct {
+			Data []*connectors.TransactionRecord `json:"data"`
+			Meta *pagination.Meta                `json:"meta"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), resBody)
+		assert.Len(t, resBody.Data, 1)
+		assert.Equal(t, 3, resBody.Data[0].ID)
+		assert.Equal(t, 5, resBody.Meta.Total)
+		assert.Equal(t, "", resBody.Meta.Prev)
+
+		next, err := url.Parse(resBody.Meta.Next)
+		assert.Nil(t, err)
+		assert.Equal(t, "2021-09-01", next.Query().Get("from"))
+		cursor, err := pagination.DecodeCursor(next.Query().Get("after"))
+		assert.Nil(t, err)
+		assert.Equal(t, connectors.TransactionCursor(&connectors.TransactionRecord{ID: 3, Date: date}), cursor)
+		TransactionRepoMock.AssertExpectations(t)
+	})
+
+	t.Run("success-last-page", func(t *testing.T) {
+		UserRepoMock := new(connectors.MockDBType)
+		UserRepoMock.On("GetUserByID", mock.Anything, 1).Return(&connectors.UserRecord{ID: 1}, nil).Once()
+		UserRepo = UserRepoMock
+
+		TransactionRepoMock := new(connectors.MockDBType)
+		TransactionRepoMock.On("GetTransactionsByUserID", mock.Anything, mock.Anything).Return([]*connectors.TransactionRecord{{ID: 1, UserID: 1}}, 1, nil).Once()
+		TransactionRepo = TransactionRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(http.MethodGet, "/user/orders?user_id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		resBody := &struct {
+			Data []*connectors.TransactionRecord `json:"data"`
+			Meta *pagination.Meta                `json:"meta"`
+		}{}
+		json.Unmarshal(recorder.Body.Bytes(), resBody)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 1)
+		assert.Equal(t, &pagination.Meta{Total: 1, Limit: pagination.DefaultLimit()}, resBody.Meta)
+	})
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type VariantHandler struct{}
+
+var (
+	VariantRepo connectors.VariantRepository
+
+	variantRegExp = regexp.MustCompile(`^\/product\/variant[\/]*$`)
+)
+
+type variantRequest struct {
+	SKU string `json:"sku" validate:"required,max=64"`
+
+	// Options the option attributes telling the variant apart, eg. {"size": "M", "color": "black"}
+	Options map[string]string `json:"options" validate:"omitempty,dive,keys,min=1,max=64,endkeys,max=255"`
+
+	// Price an object of amount and currency, or a bare amount in the currency of the product
+	Price *connectors.Money `json:"price" validate:"required"`
+	Qty   int               `json:"qty" validate:"numeric,gte=0"`
+
+	// Actor who edits the qty of a variant with PUT, the stock movement is recorded for them.
+	// It is required when the qty changes.
+	Actor string `json:"actor"`
+}
+
+type createVariantRequest struct {
+	ProductID int `json:"product_id" validate:"required,numeric,gt=0"`
+	variantRequest
+}
+
+func (v *VariantHandler) VariantHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case !variantRegExp.MatchString(r.URL.Path):
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	case r.Method == http.MethodGet && r.URL.Query().Get("product_id") != "":
+		v.GetVariantsByProductID(w, r)
+	case r.Method == http.MethodGet:
+		v.GetVariantByID(w, r)
+	case r.Method == http.MethodPost:
+		v.CreateVariant(w, r)
+	case r.Method == http.MethodPut:
+		v.UpdateVariant(w, r)
+	case r.Method == http.MethodDelete:
+		v.DeleteVariant(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+	}
+}
+
+// GetVariantByID writes the variant of the id parameter with the qty available to new orders
+func (v *VariantHandler) GetVariantByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	variant, err := VariantRepo.GetVariantByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Variant ID not found", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, variant, nil)
+}
+
+// GetVariantsByProductID writes the variants of the product of the product_id parameter
+func (v *VariantHandler) GetVariantsByProductID(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseQueryInt(w, r, "product_id")
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate product id exists
+	_, err := ProductRepo.GetProductByID(r.Context(), productID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
+		return
+	}
+
+	variants, total, err := VariantRepo.GetVariantsByProductID(r.Context(), productID, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the variants", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(variants), total, func(i int) *pagination.Cursor { return pagination.IDCursor(variants[i].ID) })
+	writeHTTPPage(r.Context(), w, variants[start:end], meta)
+}
+
+// CreateVariant adds a variant with its own sku, price and stock to a product
+func (v *VariantHandler) CreateVariant(w http.ResponseWriter, r *http.Request) {
+	variant := &createVariantRequest{}
+	if !readJSONRequest(w, r, variant) {
+		return
+	}
+
+	//validate product id exists
+	product, err := ProductRepo.GetProductByID(r.Context(), variant.ProductID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
+		return
+	}
+
+	rec := variant.record(product.Price.Currency)
+	rec.ProductID = variant.ProductID
+	result, err := VariantRepo.CreateVariant(r.Context(), rec)
+	if err != nil {
+		writeVariantError(w, r, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/product/variant?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "Success", headers, result, nil)
+}
+
+// UpdateVariant replaces the sku, options, price and qty of the variant of the id parameter
+func (v *VariantHandler) UpdateVariant(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	variant := &variantRequest{}
+	if !readJSONRequest(w, r, variant) {
+		return
+	}
+
+	current, err := VariantRepo.GetVariantByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Variant ID not found", err)
+		return
+	}
+
+	rec := variant.record(current.Price.Currency)
+	rec.ID = id
+	result, err := VariantRepo.UpdateVariant(r.Context(), rec, variant.Actor)
+	if err != nil {
+		writeVariantError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+}
+
+// DeleteVariant removes the variant of the id parameter, a variant that was ordered can not be removed
+func (v *VariantHandler) DeleteVariant(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	result, err := VariantRepo.DeleteVariant(r.Context(), id)
+	if err != nil {
+		writeVariantError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, nil, nil)
+}
+
+// record the ProductVariantRecord of the request, its price is in currency when it comes without one
+func (v *variantRequest) record(currency string) *connectors.ProductVariantRecord {
+	return &connectors.ProductVariantRecord{
+		SKU:     v.SKU,
+		Options: v.Options,
+		Price:   productPrice(*v.Price, currency),
+		Qty:     v.Qty,
+	}
+}
+
+func writeVariantError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Internal Server Error"
+	switch {
+	case errors.Is(err, connectors.ErrProductNotFound):
+		message = "Product ID not found"
+	case errors.Is(err, connectors.ErrVariantNotFound):
+		message = "Variant ID not found"
+	case errors.Is(err, connectors.ErrDuplicateSKU):
+		message = "SKU is already used by another variant"
+	case errors.Is(err, connectors.ErrVariantHasOrders):
+		message = "Variant is referenced by orders"
+	case errors.Is(err, connectors.ErrInsufficientStock):
+		message = "Qty of the default warehouse is not enough"
+	case errors.Is(err, connectors.ErrActorRequired):
+		message = "Actor is required to change the qty"
+	}
+	writeHTTPError(r.Context(), w, message, err)
+}
@@ -0,0 +1,164 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product/variant"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-json-structure", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"product_id": 1, "price": 1500, "qty": 4}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-duplicate-sku", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, Price: connectors.NewMoney(1200, connectors.CurrencyIDR)}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		VariantRepoMock := new(connectors.MockDBType)
+		VariantRepoMock.On("CreateVariant", mock.Anything, mock.Anything).Return((*connectors.ProductVariantRecord)(nil), connectors.ErrDuplicateSKU).Once()
+		VariantRepo = VariantRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"product_id": 1, "sku": "MBP-SLV", "price": 1500, "qty": 4}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "SKU is already used by another variant", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1, Price: connectors.NewMoney(1200, "USD")}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		// a bare price is in the currency of the product
+		VariantRepoMock := new(connectors.MockDBType)
+		VariantRepoMock.On("CreateVariant", mock.Anything, mock.MatchedBy(func(rec *connectors.ProductVariantRecord) bool {
+			return rec.ProductID == 1 && rec.SKU == "MBP-SLV" && rec.Options["color"] == "silver" && rec.Price == connectors.NewMoney(1500, "USD") && rec.Qty == 4
+		})).Return(&connectors.ProductVariantRecord{ID: 7, ProductID: 1}, nil).Once()
+		VariantRepo = VariantRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"product_id": 1, "sku": "MBP-SLV", "options": {"color": "silver"}, "price": 1500, "qty": 4}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/product/variant?id=7", recorder.Header().Get("Location"))
+		VariantRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetVariants(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product/variant"
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-variant-not-found", func(t *testing.T) {
+		VariantRepoMock := new(connectors.MockDBType)
+		VariantRepoMock.On("GetVariantByID", mock.Anything, 100).Return((*connectors.ProductVariantRecord)(nil), connectors.ErrVariantNotFound).Once()
+		VariantRepo = VariantRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?id=100", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+		assert.Equal(t, "Variant ID not found", resBody.Message)
+	})
+
+	t.Run("success-by-product-id", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 1).Return(&connectors.ProductRecord{ID: 1}, nil).Once()
+		ProductRepo = ProductRepoMock
+
+		VariantRepoMock := new(connectors.MockDBType)
+		VariantRepoMock.On("GetVariantsByProductID", mock.Anything, 1, mock.Anything).Return([]*connectors.ProductVariantRecord{{ID: 7, ProductID: 1, SKU: "MBP-SLV"}}, 1, nil).Once()
+		VariantRepo = VariantRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?product_id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		VariantRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestDeleteVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/product/variant"
+	method := "DELETE"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-variant-has-orders", func(t *testing.T) {
+		VariantRepoMock := new(connectors.MockDBType)
+		VariantRepoMock.On("DeleteVariant", mock.Anything, 7).Return("", connectors.ErrVariantHasOrders).Once()
+		VariantRepo = VariantRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, urlEndPoint+"?id=7", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Variant is referenced by orders", resBody.Message)
+	})
+}
@@ -0,0 +1,271 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+)
+
+type WarehouseHandler struct{}
+
+var (
+	WarehouseRepo connectors.WarehouseRepository
+
+	warehouseRegExp         = regexp.MustCompile(`^\/warehouse[\/]*$`)
+	warehouseStockRegExp    = regexp.MustCompile(`^\/warehouse\/stock[\/]*$`)
+	warehouseTransferRegExp = regexp.MustCompile(`^\/warehouse\/transfer[\/]*$`)
+)
+
+type warehouseRequest struct {
+	Code string `json:"code" validate:"required,max=32"`
+	Name string `json:"name" validate:"required,max=255"`
+
+	// City and Country where the warehouse is, the country as an ISO 3166-1 alpha-2 code
+	City    string `json:"city" validate:"required,max=255"`
+	Country string `json:"country" validate:"required,len=2,alpha"`
+}
+
+type transferRequest struct {
+	FromWarehouseID int `json:"from_warehouse_id" validate:"required,numeric,gt=0"`
+	ToWarehouseID   int `json:"to_warehouse_id" validate:"required,numeric,gt=0,nefield=FromWarehouseID"`
+	ProductID       int `json:"product_id" validate:"required,numeric,gt=0"`
+
+	// VariantID the variant moved, omitted to move the product itself
+	VariantID int    `json:"variant_id,omitempty" validate:"omitempty,numeric,gt=0"`
+	Qty       int    `json:"qty" validate:"required,numeric,gt=0"`
+	Actor     string `json:"actor" validate:"required"`
+}
+
+func (wh *WarehouseHandler) WarehouseHttpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("content-type", "application/json")
+	switch {
+	case warehouseRegExp.MatchString(r.URL.Path):
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("id") == "":
+			wh.GetWarehouses(w, r)
+		case r.Method == http.MethodGet:
+			wh.GetWarehouseByID(w, r)
+		case r.Method == http.MethodPost:
+			wh.CreateWarehouse(w, r)
+		case r.Method == http.MethodPut:
+			wh.UpdateWarehouse(w, r)
+		default:
+			helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+		}
+	case warehouseStockRegExp.MatchString(r.URL.Path):
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("product_id") != "":
+			wh.GetProductWarehouseStock(w, r)
+		case r.Method == http.MethodGet:
+			wh.GetWarehouseStock(w, r)
+		default:
+			helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+		}
+	case warehouseTransferRegExp.MatchString(r.URL.Path):
+		if r.Method != http.MethodPost {
+			helpers.WriteHTTPResponse(r.Context(), w, http.StatusMethodNotAllowed, "Method not Allowed", nil, nil, nil)
+			return
+		}
+		wh.TransferStock(w, r)
+	default:
+		helpers.WriteHTTPResponse(r.Context(), w, http.StatusNotFound, "404 page not found", nil, nil, nil)
+	}
+}
+
+// GetWarehouses writes every warehouse ordered by id
+func (wh *WarehouseHandler) GetWarehouses(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	warehouses, total, err := WarehouseRepo.GetWarehouses(r.Context(), page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the warehouses", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(warehouses), total, func(i int) *pagination.Cursor { return pagination.IDCursor(warehouses[i].ID) })
+	writeHTTPPage(r.Context(), w, warehouses[start:end], meta)
+}
+
+// GetWarehouseByID writes the warehouse of the id parameter
+func (wh *WarehouseHandler) GetWarehouseByID(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	warehouse, err := WarehouseRepo.GetWarehouseByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Warehouse ID not found", err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "Success", nil, warehouse, nil)
+}
+
+// CreateWarehouse adds a location stock can be kept in
+func (wh *WarehouseHandler) CreateWarehouse(w http.ResponseWriter, r *http.Request) {
+	warehouse := &warehouseRequest{}
+	if !readJSONRequest(w, r, warehouse) {
+		return
+	}
+
+	result, err := WarehouseRepo.CreateWarehouse(r.Context(), warehouse.record())
+	if err != nil {
+		writeWarehouseError(w, r, err)
+		return
+	}
+
+	headers := map[string]string{
+		"Location": fmt.Sprintf("/warehouse?id=%d", result.ID),
+	}
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusCreated, "warehouse created successfully", headers, result, nil)
+}
+
+// UpdateWarehouse replaces the code, name, city and country of the warehouse of the id parameter
+func (wh *WarehouseHandler) UpdateWarehouse(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	warehouse := &warehouseRequest{}
+	if !readJSONRequest(w, r, warehouse) {
+		return
+	}
+
+	//validate warehouse id exists
+	_, err := WarehouseRepo.GetWarehouseByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Warehouse ID not found", err)
+		return
+	}
+
+	rec := warehouse.record()
+	rec.ID = id
+	result, err := WarehouseRepo.UpdateWarehouse(r.Context(), rec)
+	if err != nil {
+		writeWarehouseError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, result, nil, rec, nil)
+}
+
+// GetWarehouseStock writes the qty of every product and variant kept in the warehouse of the id parameter
+func (wh *WarehouseHandler) GetWarehouseStock(w http.ResponseWriter, r *http.Request) {
+	id, ok := parseQueryID(w, r)
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate warehouse id exists
+	_, err := WarehouseRepo.GetWarehouseByID(r.Context(), id)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Warehouse ID not found", err)
+		return
+	}
+
+	stock, total, err := WarehouseRepo.GetWarehouseStock(r.Context(), id, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the warehouse stock", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(stock), total, func(i int) *pagination.Cursor { return connectors.WarehouseStockCursor(stock[i]) })
+	writeHTTPPage(r.Context(), w, stock[start:end], meta)
+}
+
+// GetProductWarehouseStock writes the qty every warehouse keeps of the product of the product_id parameter and of its variants
+func (wh *WarehouseHandler) GetProductWarehouseStock(w http.ResponseWriter, r *http.Request) {
+	productID, ok := parseQueryInt(w, r, "product_id")
+	if !ok {
+		return
+	}
+
+	page, ok := parsePage(w, r)
+	if !ok {
+		return
+	}
+
+	//validate product id exists
+	_, err := ProductRepo.GetProductByID(r.Context(), productID)
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Product ID not found", err)
+		return
+	}
+
+	stock, total, err := WarehouseRepo.GetProductWarehouseStock(r.Context(), productID, page.Fetch())
+	if err != nil {
+		writeHTTPError(r.Context(), w, "Error fetching the warehouse stock", err)
+		return
+	}
+
+	start, end, meta := page.Meta(r.URL, len(stock), total, func(i int) *pagination.Cursor { return connectors.ProductWarehouseStockCursor(stock[i]) })
+	writeHTTPPage(r.Context(), w, stock[start:end], meta)
+}
+
+// TransferStock moves qty of a product or variant from a warehouse to another, the qty of the product does not change
+func (wh *WarehouseHandler) TransferStock(w http.ResponseWriter, r *http.Request) {
+	transfer := &transferRequest{}
+	if !readJSONRequest(w, r, transfer) {
+		return
+	}
+
+	movements, err := WarehouseRepo.TransferStock(r.Context(), &connectors.StockTransferRecord{
+		FromWarehouseID: transfer.FromWarehouseID,
+		ToWarehouseID:   transfer.ToWarehouseID,
+		ProductID:       transfer.ProductID,
+		VariantID:       transfer.VariantID,
+		Qty:             transfer.Qty,
+		Actor:           transfer.Actor,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		writeWarehouseError(w, r, err)
+		return
+	}
+
+	helpers.WriteHTTPResponse(r.Context(), w, http.StatusOK, "stock transferred successfully", nil, movements, nil)
+}
+
+// record the WarehouseRecord of the request, with the country code upper cased
+func (wh *warehouseRequest) record() *connectors.WarehouseRecord {
+	return &connectors.WarehouseRecord{
+		Code:    wh.Code,
+		Name:    wh.Name,
+		City:    wh.City,
+		Country: strings.ToUpper(wh.Country),
+	}
+}
+
+func writeWarehouseError(w http.ResponseWriter, r *http.Request, err error) {
+	message := "Internal Server Error"
+	switch {
+	case errors.Is(err, connectors.ErrWarehouseNotFound):
+		message = "Warehouse ID not found"
+	case errors.Is(err, connectors.ErrDuplicateWarehouseCode):
+		message = "Code is already used by another warehouse"
+	case errors.Is(err, connectors.ErrProductNotFound):
+		message = "Product ID not found"
+	case errors.Is(err, connectors.ErrVariantNotFound):
+		message = "Variant ID not found"
+	case errors.Is(err, connectors.ErrInsufficientStock):
+		message = "Qty of the source warehouse is not enough"
+	}
+	writeHTTPError(r.Context(), w, message, err)
+}
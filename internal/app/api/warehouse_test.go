@@ -0,0 +1,229 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/arieffian/mw-backend-test/internal/connectors"
+	"github.com/arieffian/mw-backend-test/pkg/helpers"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCreateWarehouse(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/warehouse"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-invalid-country", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SUB", "name": "surabaya warehouse", "city": "surabaya", "country": "IDN"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+		assert.Equal(t, "Invalid json structure", resBody.Message)
+	})
+
+	t.Run("error-duplicate-code", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("CreateWarehouse", mock.Anything, mock.Anything).Return((*connectors.WarehouseRecord)(nil), connectors.ErrDuplicateWarehouseCode).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "MAIN", "name": "main warehouse", "city": "jakarta", "country": "ID"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Code is already used by another warehouse", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("CreateWarehouse", mock.Anything, mock.MatchedBy(func(rec *connectors.WarehouseRecord) bool {
+			return rec.Code == "SUB" && rec.City == "surabaya" && rec.Country == "ID"
+		})).Return(&connectors.WarehouseRecord{ID: 2, Code: "SUB", Name: "surabaya warehouse", City: "surabaya", Country: "ID"}, nil).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SUB", "name": "surabaya warehouse", "city": "surabaya", "country": "id"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusCreated {
+			t.Errorf("expecting code 201 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		assert.Equal(t, "/warehouse?id=2", recorder.Header().Get("Location"))
+		WarehouseRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestUpdateWarehouse(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "PUT"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-warehouse-not-found", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("GetWarehouseByID", mock.Anything, 100).Return((*connectors.WarehouseRecord)(nil), connectors.ErrWarehouseNotFound).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SUB", "name": "surabaya warehouse", "city": "surabaya", "country": "ID"}`
+		createRequest := httptest.NewRequest(method, "/warehouse?id=100", bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("GetWarehouseByID", mock.Anything, 2).Return(&connectors.WarehouseRecord{ID: 2}, nil).Once()
+		WarehouseRepoMock.On("UpdateWarehouse", mock.Anything, mock.MatchedBy(func(rec *connectors.WarehouseRecord) bool {
+			return rec.ID == 2 && rec.Code == "SBY"
+		})).Return("warehouse updated successfully", nil).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"code": "SBY", "name": "surabaya warehouse", "city": "surabaya", "country": "ID"}`
+		createRequest := httptest.NewRequest(method, "/warehouse?id=2", bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		WarehouseRepoMock.AssertExpectations(t)
+	})
+}
+
+func TestGetWarehouseStock(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	method := "GET"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		ProductRepoMock := new(connectors.MockDBType)
+		ProductRepoMock.On("GetProductByID", mock.Anything, 100).Return((*connectors.ProductRecord)(nil), connectors.ErrProductNotFound).Once()
+		ProductRepo = ProductRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/warehouse/stock?product_id=100", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("success-by-warehouse", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("GetWarehouseByID", mock.Anything, 1).Return(&connectors.WarehouseRecord{ID: 1}, nil).Once()
+		WarehouseRepoMock.On("GetWarehouseStock", mock.Anything, 1, mock.Anything).Return([]*connectors.WarehouseStockRecord{
+			{WarehouseID: 1, ProductID: 1, Qty: 3},
+			{WarehouseID: 1, ProductID: 1, VariantID: 7, Qty: 2},
+		}, 2, nil).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		createRequest := httptest.NewRequest(method, "/warehouse/stock?id=1", nil)
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &struct {
+			Data []*connectors.WarehouseStockRecord `json:"data"`
+		}{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Len(t, resBody.Data, 2)
+		assert.Equal(t, 7, resBody.Data[1].VariantID)
+	})
+}
+
+func TestTransferStock(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	urlEndPoint := "/warehouse/transfer"
+	method := "POST"
+	Router = http.NewServeMux()
+	InitializeRouter()
+
+	t.Run("error-same-warehouse", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		s := `{"from_warehouse_id": 1, "to_warehouse_id": 1, "product_id": 1, "qty": 2, "actor": "admin"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, recorder.Code)
+	})
+
+	t.Run("error-qty-not-enough", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("TransferStock", mock.Anything, mock.Anything).Return(([]*connectors.StockMovementRecord)(nil), connectors.ErrInsufficientStock).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"from_warehouse_id": 1, "to_warehouse_id": 2, "product_id": 1, "qty": 20, "actor": "admin"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		rawBody, _ := ioutil.ReadAll(recorder.Body)
+		resBody := &helpers.ResponseJSON{}
+		json.Unmarshal(rawBody, resBody)
+
+		assert.Equal(t, http.StatusConflict, recorder.Code)
+		assert.Equal(t, "Qty of the source warehouse is not enough", resBody.Message)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		WarehouseRepoMock := new(connectors.MockDBType)
+		WarehouseRepoMock.On("TransferStock", mock.Anything, mock.MatchedBy(func(rec *connectors.StockTransferRecord) bool {
+			return rec.FromWarehouseID == 1 && rec.ToWarehouseID == 2 && rec.ProductID == 1 && rec.VariantID == 7 && rec.Qty == 2 && rec.Actor == "admin" && !rec.CreatedAt.IsZero()
+		})).Return([]*connectors.StockMovementRecord{
+			{ID: 5, ProductID: 1, VariantID: 7, WarehouseID: 1, Delta: -2, Reason: connectors.StockReasonTransfer, Actor: "admin"},
+			{ID: 6, ProductID: 1, VariantID: 7, WarehouseID: 2, Delta: 2, Reason: connectors.StockReasonTransfer, Actor: "admin"},
+		}, nil).Once()
+		WarehouseRepo = WarehouseRepoMock
+
+		recorder := httptest.NewRecorder()
+		s := `{"from_warehouse_id": 1, "to_warehouse_id": 2, "product_id": 1, "variant_id": 7, "qty": 2, "actor": "admin"}`
+		createRequest := httptest.NewRequest(method, urlEndPoint, bytes.NewReader([]byte(s)))
+		createRequest.Header.Add("Content-Type", "application/json")
+		Router.ServeHTTP(recorder, createRequest)
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("expecting code 200 but got %d. Body %s", recorder.Code, recorder.Body.String())
+			t.FailNow()
+		}
+		WarehouseRepoMock.AssertExpectations(t)
+	})
+}
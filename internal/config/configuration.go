@@ -34,13 +34,53 @@ func initialize() {
 	defCfg["server.context.timeout"] = "30" // seconds
 
 	//Configuration db
-	defCfg["db.type"] = "mysql"
+	defCfg["db.type"] = "mysql" // mysql, inmemory
 	defCfg["db.user"] = "mw-backend"
 	defCfg["db.password"] = "mw-backend"
 	defCfg["db.database"] = "mw-backend"
 	defCfg["db.host"] = "127.0.0.1"
 	defCfg["db.port"] = "3306"
 
+	// retry of db transactions failing on deadlock or lock wait timeout
+	defCfg["db.tx.retry.attempts"] = "3"    // total attempts, including the first one
+	defCfg["db.tx.retry.base.delay"] = "20" // milliseconds, doubled on every attempt
+	defCfg["db.tx.retry.max.delay"] = "500" // milliseconds
+
+	// page size of the list endpoints, a request may ask up to the max with its limit parameter
+	defCfg["pagination.limit.default"] = "20"
+	defCfg["pagination.limit.max"] = "100"
+
+	// replay window of the Idempotency-Key header of POST /order
+	defCfg["order.idempotency.ttl"] = "24" // hours, a key is forgotten and may be used again afterwards
+
+	// stock held by pending orders
+	defCfg["order.reservation.ttl"] = "15"            // minutes, an unpaid order stops holding its stock afterwards
+	defCfg["order.reservation.sweep.interval"] = "60" // seconds between sweeps of expired orders and reservations, 0 disables them
+
+	// warehouses the stock of a paid order is taken from: single_warehouse_first, nearest or split
+	defCfg["order.allocation.strategy"] = "single_warehouse_first"
+
+	// payment gateway orders are paid through, only fake is available
+	defCfg["payment.gateway"] = "fake"
+	defCfg["payment.fake.webhook.secret"] = "" // hmac key of the webhooks of the fake gateway, no webhook is accepted while it is empty
+
+	// currency of the prices sent without one, an ISO 4217 code
+	defCfg["currency.default"] = "IDR"
+
+	// tax of orders, the rate of a tax class is in percent and 0 charges no tax
+	defCfg["tax.rate.standard"] = "0"
+	defCfg["tax.rate.reduced"] = "0"
+	defCfg["tax.prices.include.tax"] = "false" // true takes the tax out of the prices instead of adding it on top
+
+	// shipping cost of orders with a shipping address, in minor units of the currency of the order
+	defCfg["shipping.rate.flat"] = "0"      // charged once per order
+	defCfg["shipping.rate.per.item"] = "0"  // charged for every unit ordered
+	defCfg["shipping.rate.free.from"] = "0" // orders whose discounted subtotal reaches it ship free, 0 never ships free
+
+	// price buckets the products found by GET /search are counted in, the upper bounds in minor units separated by commas.
+	// The last bucket has no upper bound, an empty list puts every price in one bucket.
+	defCfg["search.facet.price.buckets"] = "1000,5000,10000,50000"
+
 	// time
 	defCfg["time.default"] = "02 Jan 70 00:00 WIB" // RFC822 --> 1970-01-02 00:00:00
 
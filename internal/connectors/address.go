@@ -0,0 +1,19 @@
+package connectors
+
+import "strings"
+
+// normalizeAddress a copy of rec with its country code upper case
+func normalizeAddress(rec *AddressRecord) *AddressRecord {
+	address := *rec
+	address.Country = strings.ToUpper(address.Country)
+	return &address
+}
+
+// copyPostalAddress returns a copy of address, nil when it is nil
+func copyPostalAddress(address *PostalAddress) *PostalAddress {
+	if address == nil {
+		return nil
+	}
+	a := *address
+	return &a
+}
@@ -0,0 +1,56 @@
+package connectors
+
+import "errors"
+
+// priceCartItem fills the product fields of item from p, its product with its Available qty filled
+func priceCartItem(item *CartItemRecord, p *ProductRecord) error {
+	subTotal, err := p.Price.Mul(int64(item.Qty))
+	if err != nil {
+		return err
+	}
+
+	item.Name = p.Name
+	item.Price = p.Price
+	item.SubTotal = subTotal
+	item.AvailableQty = p.Available
+	item.InStock = p.Available >= item.Qty
+	return nil
+}
+
+// newCartRecord the cart of userID holding items, their product fields must be filled.
+// An empty cart is in DefaultCurrency, a cart mixing currencies has a zero Subtotal without a currency.
+func newCartRecord(userID int, items []*CartItemRecord) (*CartRecord, error) {
+	currency := DefaultCurrency()
+	if len(items) > 0 {
+		currency = items[0].Price.Currency
+	}
+
+	cart := &CartRecord{
+		UserID:   userID,
+		Items:    items,
+		Subtotal: NewMoney(0, currency),
+	}
+	for _, item := range items {
+		subtotal, err := cart.Subtotal.Add(item.SubTotal)
+		if errors.Is(err, ErrCurrencyMismatch) {
+			cart.Subtotal = Money{}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		cart.Subtotal = subtotal
+	}
+
+	return cart, nil
+}
+
+// cartOrder the transaction of rec with the items of a cart as its detail, in the order they were added
+func cartOrder(rec *TransactionRecord, items []*CartItemRecord) *TransactionRecord {
+	order := *rec
+	order.TransactionDetail = make([]*TransactionDetailRecord, 0, len(items))
+	for _, item := range items {
+		order.TransactionDetail = append(order.TransactionDetail, &TransactionDetailRecord{ProductID: item.ProductID, Qty: item.Qty})
+	}
+	return &order
+}
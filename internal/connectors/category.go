@@ -0,0 +1,60 @@
+package connectors
+
+import "sort"
+
+// Category the category a breadcrumb leads to, nil for an empty breadcrumb
+func (p CategoryPath) Category() *CategoryRecord {
+	if len(p) == 0 {
+		return nil
+	}
+	return p[len(p)-1]
+}
+
+// distinctCategoryIDs the distinct ids of categoryIDs ordered by id
+func distinctCategoryIDs(categoryIDs []int) []int {
+	seen := make(map[int]bool, len(categoryIDs))
+	ids := make([]int, 0, len(categoryIDs))
+	for _, id := range categoryIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+// categoryPath the breadcrumb of the category of categoryID made of copies of the categories keyed by id,
+// which must hold the category and all of its ancestors. ErrCategoryNotFound is returned when they do not.
+func categoryPath(categories map[int]*CategoryRecord, categoryID int) (CategoryPath, error) {
+	path := make(CategoryPath, 0)
+	for id := categoryID; id != 0; {
+		category, ok := categories[id]
+		// a path longer than the tree only happens on a cycle, which the connectors never store
+		if !ok || len(path) == len(categories) {
+			return nil, ErrCategoryNotFound
+		}
+		c := *category
+		path = append(path, &c)
+		id = category.ParentID
+	}
+
+	// the path was walked up from the category, the breadcrumb starts at the root
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// categoryPaths the breadcrumb of every category of categoryIDs in their order, see categoryPath
+func categoryPaths(categories map[int]*CategoryRecord, categoryIDs []int) ([]CategoryPath, error) {
+	paths := make([]CategoryPath, 0, len(categoryIDs))
+	for _, id := range categoryIDs {
+		path, err := categoryPath(categories, id)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
@@ -0,0 +1,173 @@
+package connectors
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// CouponTypePercentage takes Value percent off the eligible products
+	CouponTypePercentage = "percentage"
+
+	// CouponTypeFixed takes the amount Value off the eligible products, spread over them by their sub total
+	CouponTypeFixed = "fixed"
+
+	// CouponTypeBuyXGetY gives GetQty units of an eligible product free for every BuyQty units paid
+	CouponTypeBuyXGetY = "buy_x_get_y"
+)
+
+// couponLine an order line priced from its product or its variant, the unit a coupon discounts.
+// The amounts are in minor units of the currency of the order.
+type couponLine struct {
+	ProductID int
+	VariantID int
+	BrandID   int
+	Price     int64
+	Qty       int
+
+	// SubTotal Price times Qty
+	SubTotal int64
+}
+
+// normalizeCouponCode coupon codes are matched case-insensitively, they are stored upper case
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// newCouponNotApplicable an ErrCouponNotApplicable saying why the coupon does not apply
+func newCouponNotApplicable(format string, args ...interface{}) error {
+	return &Error{Kind: ErrCouponNotApplicable.Kind, Code: ErrCouponNotApplicable.Code, Message: ErrCouponNotApplicable.Message, Err: fmt.Errorf(format, args...)}
+}
+
+// validateCoupon checks the values a coupon needs for its type
+func validateCoupon(c *CouponRecord) error {
+	switch c.Type {
+	case CouponTypePercentage:
+		if c.Value < 1 || c.Value > 100 {
+			return NewValidationError(errors.New("value of a percentage coupon must be between 1 and 100"))
+		}
+	case CouponTypeFixed:
+		if c.Value < 1 {
+			return NewValidationError(errors.New("value of a fixed coupon must be greater than 0"))
+		}
+	case CouponTypeBuyXGetY:
+		if c.BuyQty < 1 || c.GetQty < 1 {
+			return NewValidationError(errors.New("buy_qty and get_qty of a buy_x_get_y coupon must be greater than 0"))
+		}
+	default:
+		return NewValidationError(fmt.Errorf("unknown coupon type %s", c.Type))
+	}
+
+	if c.Currency == "" && (c.Type == CouponTypeFixed || c.MinSpend > 0) {
+		return NewValidationError(errors.New("currency is required by a fixed coupon and by a min spend"))
+	}
+
+	if !c.StartsAt.IsZero() && !c.EndsAt.IsZero() && !c.EndsAt.After(c.StartsAt) {
+		return NewValidationError(errors.New("ends_at must be after starts_at"))
+	}
+
+	return nil
+}
+
+// checkCouponUsable checks the coupon is valid at now and has uses left, globally and for the user
+func checkCouponUsable(c *CouponRecord, now time.Time, totalUses int, userUses int) error {
+	if !c.StartsAt.IsZero() && now.Before(c.StartsAt) {
+		return newCouponNotApplicable("coupon %s is valid from %s", c.Code, c.StartsAt.Format(time.RFC3339))
+	}
+	if !c.EndsAt.IsZero() && !now.Before(c.EndsAt) {
+		return newCouponNotApplicable("coupon %s expired at %s", c.Code, c.EndsAt.Format(time.RFC3339))
+	}
+	if c.UsageLimit > 0 && totalUses >= c.UsageLimit {
+		return ErrCouponUsageExceeded
+	}
+	if c.UsageLimitPerUser > 0 && userUses >= c.UsageLimitPerUser {
+		return ErrCouponUsageExceeded
+	}
+	return nil
+}
+
+// couponDiscounts the discount the coupon gives every line of an order in currency, in the order of lines.
+// Only the lines of the brand of a brand-scoped coupon are eligible, and they must add up to the min spend.
+// The sub totals of lines must add up without overflowing.
+func couponDiscounts(c *CouponRecord, currency string, lines []couponLine) ([]int64, error) {
+	if c.Currency != "" && c.Currency != currency {
+		return nil, newCouponNotApplicable("coupon %s is in %s, the order is in %s", c.Code, c.Currency, currency)
+	}
+
+	discounts := make([]int64, len(lines))
+
+	eligible := make([]int, 0, len(lines))
+	var eligibleTotal int64
+	for i, line := range lines {
+		if c.BrandID != 0 && line.BrandID != c.BrandID {
+			continue
+		}
+		eligible = append(eligible, i)
+		eligibleTotal += line.SubTotal
+	}
+	if len(eligible) == 0 {
+		return nil, newCouponNotApplicable("no product of the order is eligible for coupon %s", c.Code)
+	}
+	if eligibleTotal < int64(c.MinSpend) {
+		return nil, newCouponNotApplicable("coupon %s needs a spend of %d, the order has %d", c.Code, c.MinSpend, eligibleTotal)
+	}
+
+	switch c.Type {
+	case CouponTypePercentage:
+		for _, i := range eligible {
+			discounts[i] = mulDiv(lines[i].SubTotal, int64(c.Value), 100)
+		}
+	case CouponTypeFixed:
+		amount := int64(c.Value)
+		if amount > eligibleTotal {
+			amount = eligibleTotal
+		}
+		// spread by sub total, the rounding left over goes to the last line so the lines add up to amount
+		left := amount
+		for n, i := range eligible {
+			if n == len(eligible)-1 {
+				discounts[i] = left
+				break
+			}
+			discounts[i] = mulDiv(amount, lines[i].SubTotal, eligibleTotal)
+			left -= discounts[i]
+		}
+	case CouponTypeBuyXGetY:
+		// the free units are counted per product, the same product may appear in several lines,
+		// in several variants priced apart, and they go to its cheapest units first
+		productQty := make(map[int]int)
+		for _, i := range eligible {
+			productQty[lines[i].ProductID] += lines[i].Qty
+		}
+		freeQty := make(map[int]int, len(productQty))
+		for productID, qty := range productQty {
+			freeQty[productID] = qty / (c.BuyQty + c.GetQty) * c.GetQty
+		}
+		cheapest := make([]int, len(eligible))
+		copy(cheapest, eligible)
+		sort.SliceStable(cheapest, func(a, b int) bool {
+			return lines[cheapest[a]].Price < lines[cheapest[b]].Price
+		})
+		for _, i := range cheapest {
+			free := freeQty[lines[i].ProductID]
+			if free > lines[i].Qty {
+				free = lines[i].Qty
+			}
+			discounts[i] = int64(free) * lines[i].Price
+			freeQty[lines[i].ProductID] -= free
+		}
+	}
+
+	var total int64
+	for _, discount := range discounts {
+		total += discount
+	}
+	if total == 0 {
+		return nil, newCouponNotApplicable("the order does not qualify for a discount of coupon %s", c.Code)
+	}
+
+	return discounts, nil
+}
@@ -0,0 +1,132 @@
+package connectors
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCouponDiscounts(t *testing.T) {
+	lines := []couponLine{
+		{ProductID: 1, BrandID: 1, Price: 1200, Qty: 2, SubTotal: 2400},
+		{ProductID: 2, BrandID: 2, Price: 1000, Qty: 1, SubTotal: 1000},
+		{ProductID: 1, BrandID: 1, Price: 1200, Qty: 1, SubTotal: 1200},
+	}
+
+	tests := []struct {
+		name    string
+		coupon  *CouponRecord
+		want    []int64
+		wantErr error
+	}{
+		{"percentage", &CouponRecord{Type: CouponTypePercentage, Value: 10}, []int64{240, 100, 120}, nil},
+		{"percentage-brand-scoped", &CouponRecord{Type: CouponTypePercentage, Value: 10, BrandID: 2}, []int64{0, 100, 0}, nil},
+		{"fixed-spread-by-sub-total", &CouponRecord{Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR}, []int64{52, 21, 27}, nil},
+		{"fixed-capped-at-eligible-total", &CouponRecord{Type: CouponTypeFixed, Value: 5000, BrandID: 2, Currency: CurrencyIDR}, []int64{0, 1000, 0}, nil},
+		{"buy-2-get-1-across-lines", &CouponRecord{Type: CouponTypeBuyXGetY, BuyQty: 2, GetQty: 1}, []int64{1200, 0, 0}, nil},
+		{"min-spend-reached", &CouponRecord{Type: CouponTypePercentage, Value: 50, BrandID: 2, MinSpend: 1000, Currency: CurrencyIDR}, []int64{0, 500, 0}, nil},
+		{"min-spend-not-reached", &CouponRecord{Type: CouponTypePercentage, Value: 50, BrandID: 2, MinSpend: 1001, Currency: CurrencyIDR}, nil, ErrCouponNotApplicable},
+		{"no-eligible-product", &CouponRecord{Type: CouponTypePercentage, Value: 10, BrandID: 3}, nil, ErrCouponNotApplicable},
+		{"other-currency", &CouponRecord{Type: CouponTypeFixed, Value: 100, Currency: "USD"}, nil, ErrCouponNotApplicable},
+		{"buy-x-get-y-not-enough-qty", &CouponRecord{Type: CouponTypeBuyXGetY, BuyQty: 3, GetQty: 1, BrandID: 1}, nil, ErrCouponNotApplicable},
+	}
+
+	for _, tt := range tests {
+		got, err := couponDiscounts(tt.coupon, CurrencyIDR, lines)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: couponDiscounts got error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: couponDiscounts got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCouponDiscountsBuyXGetYVariants(t *testing.T) {
+	// two variants of product 1 priced apart, the expensive one ordered first
+	lines := []couponLine{
+		{ProductID: 1, VariantID: 11, BrandID: 1, Price: 2000, Qty: 2, SubTotal: 4000},
+		{ProductID: 1, VariantID: 12, BrandID: 1, Price: 1500, Qty: 2, SubTotal: 3000},
+		{ProductID: 1, BrandID: 1, Price: 1200, Qty: 2, SubTotal: 2400},
+	}
+
+	tests := []struct {
+		name   string
+		coupon *CouponRecord
+		want   []int64
+	}{
+		{"buy-2-get-1-cheapest-first", &CouponRecord{Type: CouponTypeBuyXGetY, BuyQty: 2, GetQty: 1}, []int64{0, 0, 2400}},
+		{"buy-1-get-1-spills-to-next-cheapest", &CouponRecord{Type: CouponTypeBuyXGetY, BuyQty: 1, GetQty: 1}, []int64{0, 1500, 2400}},
+	}
+
+	for _, tt := range tests {
+		got, err := couponDiscounts(tt.coupon, CurrencyIDR, lines)
+		if err != nil {
+			t.Errorf("%s: couponDiscounts got error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: couponDiscounts got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCouponUsable(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		coupon    *CouponRecord
+		totalUses int
+		userUses  int
+		wantErr   error
+	}{
+		{"no-bounds", &CouponRecord{}, 100, 100, nil},
+		{"inside-window", &CouponRecord{StartsAt: now.Add(-time.Hour), EndsAt: now.Add(time.Hour)}, 0, 0, nil},
+		{"not-started", &CouponRecord{StartsAt: now.Add(time.Hour)}, 0, 0, ErrCouponNotApplicable},
+		{"expired", &CouponRecord{EndsAt: now}, 0, 0, ErrCouponNotApplicable},
+		{"global-limit-left", &CouponRecord{UsageLimit: 2}, 1, 1, nil},
+		{"global-limit-reached", &CouponRecord{UsageLimit: 2}, 2, 0, ErrCouponUsageExceeded},
+		{"user-limit-reached", &CouponRecord{UsageLimitPerUser: 1}, 1, 1, ErrCouponUsageExceeded},
+	}
+
+	for _, tt := range tests {
+		err := checkCouponUsable(tt.coupon, now, tt.totalUses, tt.userUses)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: checkCouponUsable got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateCoupon(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		coupon  *CouponRecord
+		wantErr bool
+	}{
+		{"percentage", &CouponRecord{Type: CouponTypePercentage, Value: 100}, false},
+		{"percentage-over-100", &CouponRecord{Type: CouponTypePercentage, Value: 101}, true},
+		{"fixed-without-value", &CouponRecord{Type: CouponTypeFixed, Currency: CurrencyIDR}, true},
+		{"fixed", &CouponRecord{Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR}, false},
+		{"fixed-without-currency", &CouponRecord{Type: CouponTypeFixed, Value: 100}, true},
+		{"min-spend-without-currency", &CouponRecord{Type: CouponTypePercentage, Value: 10, MinSpend: 1000}, true},
+		{"buy-x-get-y", &CouponRecord{Type: CouponTypeBuyXGetY, BuyQty: 2, GetQty: 1}, false},
+		{"buy-x-get-y-without-get-qty", &CouponRecord{Type: CouponTypeBuyXGetY, BuyQty: 2}, true},
+		{"unknown-type", &CouponRecord{Type: "free_shipping", Value: 1}, true},
+		{"ends-before-start", &CouponRecord{Type: CouponTypeFixed, Value: 1, Currency: CurrencyIDR, StartsAt: now, EndsAt: now}, true},
+	}
+
+	for _, tt := range tests {
+		err := validateCoupon(tt.coupon)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateCoupon got %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if err != nil && !errors.Is(err, ErrValidation) {
+			t.Errorf("%s: validateCoupon got %v, want a validation error", tt.name, err)
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"time"
 
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+
 	//Anonymous import for mysql initialization
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
@@ -27,21 +29,165 @@ type UserRecord struct {
 	Address string
 }
 
+// PostalAddress where an order is delivered, kept in the address book of a user and copied onto every order shipped to it
+type PostalAddress struct {
+	Recipient  string
+	Phone      string
+	Street     string
+	City       string
+	PostalCode string
+
+	// Country ISO 3166-1 alpha-2 code, eg. ID
+	Country string
+}
+
+// AddressRecord an entity representative of addresses table, an address in the address book of a user
+type AddressRecord struct {
+	ID     int
+	UserID int
+
+	// Label a name the user gives the address, eg. home or office
+	Label string
+	PostalAddress
+
+	// IsDefault the address orders are shipped to when they do not pick one, a user has at most one default address
+	IsDefault bool
+
+	CreatedAt time.Time
+}
+
 // ProductRecord an entity representative of products table
 type ProductRecord struct {
 	ID      int
 	BrandID int
 	Name    string
 	Qty     int
-	Price   int
+	Price   Money
+
+	// TaxClass one of TaxClassStandard, TaxClassReduced or TaxClassExempt
+	TaxClass string
+
+	// Available the qty that can still be ordered, Qty minus the qty reserved by pending orders
+	// plus the Available of every variant. It is only filled by GetProductByID.
+	Available int
+
+	// CategoryIDs the distinct categories the product is listed in, ordered by category id.
+	// CreateProduct and UpdateProduct replace the categories of the product with them.
+	CategoryIDs []int
+
+	// Categories the breadcrumb of every category of CategoryIDs, in its order.
+	// It is only filled by GetProductByID and GetProductsByCategoryID.
+	Categories []CategoryPath
+
+	// Variants the variants the product is sold in ordered by variant id, empty when it is only sold as itself.
+	// It is only filled by GetProductByID.
+	Variants []*ProductVariantRecord
+}
+
+// ProductVariantRecord an entity representative of product_variants table, a variant of a product with its own price and stock.
+// Qty is the stock of the variant only, the Qty of its product is the stock of the orders that do not pick a variant.
+type ProductVariantRecord struct {
+	ID        int
+	ProductID int
+
+	// SKU the stock keeping unit of the variant, unique across every variant
+	SKU string
+
+	// Options what sets the variant apart, eg. color: black and strap: leather
+	Options map[string]string
+
+	Price Money
+	Qty   int
+
+	// Available the qty that can still be ordered, Qty minus the qty reserved by pending orders.
+	// It is filled by every read of the variant.
+	Available int
+}
+
+// WarehouseRecord an entity representative of warehouses table, a location stock is kept in
+type WarehouseRecord struct {
+	ID int
+
+	// Code a short unique name of the warehouse, eg. JKT
+	Code string
+	Name string
+
+	// City and Country where the warehouse is, the nearest allocation strategy ships from the warehouses closest to the order
+	City    string
+	Country string
+}
+
+// WarehouseStockRecord an entity representative of warehouse_stock table, the qty of a product or variant kept in a warehouse.
+// The qty of a product or variant is the qty it has in every warehouse added up.
+type WarehouseStockRecord struct {
+	WarehouseID int
+	ProductID   int
+
+	// VariantID the variant kept, zero when it is the product itself
+	VariantID int
+	Qty       int
+}
+
+// StockTransferRecord the qty of a product or variant moved from a warehouse to another
+type StockTransferRecord struct {
+	FromWarehouseID int
+	ToWarehouseID   int
+	ProductID       int
+
+	// VariantID the variant moved, zero when it is the product itself
+	VariantID int
+	Qty       int
+
+	Actor     string
+	CreatedAt time.Time
+}
+
+// CategoryRecord an entity representative of categories table
+type CategoryRecord struct {
+	ID int
+
+	// ParentID the category it is nested in, zero for a root category
+	ParentID int
+	Name     string
 }
 
+// CategoryPath the breadcrumb of a category, from its root down to the category itself
+type CategoryPath []*CategoryRecord
+
 // TransactionRecord an entity representative of transactions table
 type TransactionRecord struct {
-	ID         int
-	UserID     int
-	Date       time.Time
-	GrandTotal int
+	ID     int
+	UserID int
+	Date   time.Time
+
+	// GrandTotal what the customer pays, every amount of the order is in the currency of its products
+	GrandTotal Money
+	Status     string
+
+	// CouponCode the coupon applied to the order, empty when there is none
+	CouponCode string
+
+	// Subtotal the sub total of every detail added up, before the discount
+	Subtotal Money
+
+	// Discount the discount of every detail added up
+	Discount Money
+
+	// Tax the tax of every detail added up. GrandTotal is Subtotal - Discount + ShippingCost, plus Tax unless TaxInclusive,
+	// in which case Tax is the part of the discounted amount that is tax.
+	Tax          Money
+	TaxInclusive bool
+
+	// ShippingAddress a copy of the address the order is shipped to, a later change of the address book does not change it.
+	// It is nil for an order without a shipping address.
+	ShippingAddress *PostalAddress
+
+	// ShippingCost what the ShippingRateProvider charged to ship the order, it is part of the GrandTotal
+	ShippingCost Money
+
+	// ReservedUntil a pending order holds the qty of its detail until then, afterwards it can not be paid and the sweep cancels it.
+	// It is only set on the transaction returned when the order is created.
+	ReservedUntil time.Time
 
 	TransactionDetail []*TransactionDetailRecord
 }
@@ -50,38 +196,577 @@ type TransactionRecord struct {
 type TransactionDetailRecord struct {
 	TransactionID int
 	ProductID     int
-	Qty           int
-	SubTotal      int
+
+	// VariantID the variant of the product the qty is ordered in, zero when the product itself is ordered
+	VariantID int
+	Qty       int
+
+	// SubTotal price times qty, before the discount
+	SubTotal Money
+
+	// Discount the amount of every discount line added up
+	Discount  Money
+	Discounts []*TransactionDiscountRecord
+
+	// Tax the tax of SubTotal - Discount
+	Tax Money
+
+	// Allocations the warehouses the qty was taken from when the order was paid, empty before
+	Allocations []*AllocationRecord
+}
+
+// AllocationRecord an entity representative of transaction_detail_allocations table, the qty of a detail taken from a warehouse
+type AllocationRecord struct {
+	WarehouseID int
+	Qty         int
+}
+
+// TransactionDiscountRecord an entity representative of transaction_detail_discounts table, the discount a coupon gives a detail
+type TransactionDiscountRecord struct {
+	CouponID   int
+	CouponCode string
+	Amount     Money
+}
+
+// CouponRecord an entity representative of coupons table
+type CouponRecord struct {
+	ID   int
+	Code string
+
+	// Type one of CouponTypePercentage, CouponTypeFixed or CouponTypeBuyXGetY
+	Type string
+
+	// Value the percent off of a percentage coupon, the amount off of a fixed coupon in minor units of Currency
+	Value int
+
+	// Currency the currency of the amount of a fixed coupon and of MinSpend, the coupon only applies to orders in it.
+	// Empty applies to orders in any currency, it is only allowed for a percentage or buy_x_get_y coupon without a min spend.
+	Currency string
+
+	// BuyQty and GetQty a buy_x_get_y coupon gives GetQty units free for every BuyQty units paid
+	BuyQty int
+	GetQty int
+
+	// BrandID only the products of the brand are discounted, zero means every product
+	BrandID int
+
+	// MinSpend the eligible products of an order must add up to at least it, in minor units of Currency
+	MinSpend int
+
+	// UsageLimit and UsageLimitPerUser orders the coupon may be used on in total and by a single user, zero means unlimited
+	UsageLimit        int
+	UsageLimitPerUser int
+
+	// StartsAt and EndsAt the coupon is valid from StartsAt until before EndsAt, zero means no bound
+	StartsAt time.Time
+	EndsAt   time.Time
+}
+
+// TransactionStatusHistoryRecord an entity representative of transaction_status_history table
+type TransactionStatusHistoryRecord struct {
+	ID            int
+	TransactionID int
+	FromStatus    string
+	ToStatus      string
+	Actor         string
+	CreatedAt     time.Time
+}
+
+// StockMovementRecord an entity representative of stock_movements table, one row for every change of a product qty
+type StockMovementRecord struct {
+	ID        int
+	ProductID int
+
+	// VariantID the variant whose qty changed, zero when the qty of the product itself changed
+	VariantID int
+
+	// WarehouseID the warehouse whose stock changed, zero stands for DefaultWarehouseID when the movement is recorded
+	WarehouseID int
+	Delta       int
+	Reason      string
+
+	// TransactionID the order that caused the movement, zero when there is none
+	TransactionID int
+
+	Actor     string
+	CreatedAt time.Time
+}
+
+// IdempotencyRecord an entity representative of idempotency_keys table, the response of the order created for a key
+type IdempotencyRecord struct {
+	Key string
+
+	// RequestHash hex sha256 of the request the key was first used with
+	RequestHash string
+
+	TransactionID  int
+	ResponseStatus int
+	ResponseBody   []byte
+	CreatedAt      time.Time
+
+	// ExpiresAt the key is forgotten from then on and may be used for a new order
+	ExpiresAt time.Time
+}
+
+// PaymentRecord an entity representative of payments table, a payment of an order through a PaymentGateway
+type PaymentRecord struct {
+	ID            int
+	TransactionID int
+
+	// Gateway the name of the gateway the payment is made through
+	Gateway string
+
+	// Reference the id of the payment at the gateway, unique per gateway
+	Reference string
+
+	// Amount the grand total of the order when the payment was made
+	Amount Money
+
+	// Status one of PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusFailed or PaymentStatusRefunded
+	Status string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ShipmentRecord an entity representative of shipments table, a parcel of an order handed to a carrier
+type ShipmentRecord struct {
+	ID            int
+	TransactionID int
+	Carrier       string
+
+	// TrackingNumber the number the carrier tracks the parcel with, unique per carrier
+	TrackingNumber string
+
+	// Status one of ShipmentStatusInTransit, ShipmentStatusOutForDelivery, ShipmentStatusDelivered or ShipmentStatusReturned
+	Status string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CartItemRecord an entity representative of cart_items table, a product in the cart of a user.
+// The product fields are read from the product every time the cart is retrieved, so they are always current.
+type CartItemRecord struct {
+	UserID    int
+	ProductID int
+	Qty       int
+	AddedAt   time.Time
+
+	// Name and Price the current name and price of the product, SubTotal is Price times Qty
+	Name     string
+	Price    Money
+	SubTotal Money
+
+	// AvailableQty the qty of the product that can still be ordered, InStock reports whether it covers Qty
+	AvailableQty int
+	InStock      bool
+}
+
+// CartRecord the cart of a user with the current price and stock of every item
+type CartRecord struct {
+	UserID int
+
+	// Items oldest first, empty when the cart is
+	Items []*CartItemRecord
+
+	// Subtotal the sub total of every item added up. It is zero without a currency when the items
+	// are priced in different currencies, such a cart can not be checked out.
+	Subtotal Money
+}
+
+// IdempotentResponse builds the response stored with an idempotency key from the created transaction
+type IdempotentResponse func(trans *TransactionRecord) (status int, body []byte, err error)
+
+// TransactionFilter narrows and pages the orders returned by GetTransactionsByUserID
+type TransactionFilter struct {
+	UserID int
+
+	// From only orders dated at or after it, zero means no lower bound
+	From time.Time
+
+	// To only orders dated before it, zero means no upper bound
+	To time.Time
+
+	// Page the page of the orders returned, nil returns all of them
+	Page *pagination.Page
+}
+
+// ProductSortID, ProductSortName and ProductSortPrice the fields GetProducts can order by
+const (
+	ProductSortID    = "id"
+	ProductSortName  = "name"
+	ProductSortPrice = "price"
+)
+
+// ProductFilter narrows, sorts and pages the products returned by GetProducts
+type ProductFilter struct {
+	// Name only products whose name contains it, empty means any name
+	Name string
+
+	// BrandID only products of the brand, zero means any brand
+	BrandID int
+
+	// Currency only products priced in it, empty means any currency
+	Currency string
+
+	// MinPrice only products priced at or above it in minor units, nil means no lower bound
+	MinPrice *int64
+
+	// MaxPrice only products priced at or below it in minor units, nil means no upper bound
+	MaxPrice *int64
+
+	// InStock only products with qty left, of their own or of one of their variants
+	InStock bool
+
+	// Sort one of ProductSortID, ProductSortName or ProductSortPrice, empty orders by id.
+	// Products with the same value are ordered by id so the pages are stable.
+	Sort string
+
+	// Desc orders descending instead of ascending
+	Desc bool
+
+	// Page the page of the products returned, nil returns all of them. The cursor of a product is its value of Sort and its id.
+	Page *pagination.Page
 }
 
 type UserRepository interface {
 	// GetUserByID retrieves an UserRecord from database where the user id is specified.
+	// Soft deleted users are not returned.
 	GetUserByID(ctx context.Context, userID int) (*UserRecord, error)
+
+	// GetUsers retrieves every UserRecord that is not soft deleted from database ordered by user id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetUsers(ctx context.Context, page *pagination.Page) ([]*UserRecord, int, error)
+
+	// CreateUser insert an entity record of user into database and returns the persisted record.
+	// ErrDuplicateEmail is returned when the email is already used.
+	CreateUser(ctx context.Context, rec *UserRecord) (*UserRecord, error)
+
+	// UpdateUser update an entity record of user in database where the user id is specified.
+	// ErrDuplicateEmail is returned when the email is already used by another user.
+	UpdateUser(ctx context.Context, rec *UserRecord) (string, error)
+
+	// DeleteUser soft delete an entity record of user where the user id is specified.
+	// The email of a soft deleted user stays reserved.
+	DeleteUser(ctx context.Context, userID int) (string, error)
+}
+
+type AddressRepository interface {
+	// CreateAddress insert an entity record of address into database and returns the persisted record.
+	// The first address of a user is its default, and a default address takes the default from the other addresses of the user.
+	// ErrUserNotFound is returned when the user does not exist.
+	CreateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error)
+
+	// GetAddressByID retrieves an AddressRecord from database where the address id is specified.
+	GetAddressByID(ctx context.Context, addressID int) (*AddressRecord, error)
+
+	// GetDefaultAddress retrieves the default address of a user, ErrAddressNotFound is returned when it has none.
+	GetDefaultAddress(ctx context.Context, userID int) (*AddressRecord, error)
+
+	// GetAddressesByUserID retrieves the address book of a user ordered by address id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetAddressesByUserID(ctx context.Context, userID int, page *pagination.Page) ([]*AddressRecord, int, error)
+
+	// UpdateAddress update an entity record of address in database where the address id and user id are specified,
+	// and returns the updated record. Making it the default takes the default from the other addresses of the user,
+	// the default address can not be unset this way. The orders already shipped to the address keep their copy of it.
+	// ErrAddressNotFound is returned when the user has no such address.
+	UpdateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error)
+
+	// DeleteAddress delete an entity record of address from database where the address id and user id are specified.
+	// Deleting the default address makes the oldest remaining address of the user its default.
+	// ErrAddressNotFound is returned when the user has no such address.
+	DeleteAddress(ctx context.Context, userID int, addressID int) (string, error)
 }
 
 type BrandRepository interface {
 	// GetBrandByID retrieves an BrandRecord from database where the brand id is specified.
 	GetBrandByID(ctx context.Context, brandID int) (*BrandRecord, error)
 
-	// CreateBrand insert an entity record of brand into database.
-	CreateBrand(ctx context.Context, rec *BrandRecord) (string, error)
+	// GetBrands retrieves every BrandRecord from database ordered by brand id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetBrands(ctx context.Context, page *pagination.Page) ([]*BrandRecord, int, error)
+
+	// CreateBrand insert an entity record of brand into database and returns the persisted record.
+	CreateBrand(ctx context.Context, rec *BrandRecord) (*BrandRecord, error)
+
+	// UpdateBrand update an entity record of brand in database where the brand id is specified.
+	UpdateBrand(ctx context.Context, rec *BrandRecord) (string, error)
+
+	// DeleteBrand delete an entity record of brand from database where the brand id is specified.
+	// ErrBrandHasProducts is returned when products or coupons still reference the brand.
+	DeleteBrand(ctx context.Context, brandID int) (string, error)
 }
 
 type ProductRepository interface {
-	// CreateProduct insert an entity record of product into database.
-	CreateProduct(ctx context.Context, rec *ProductRecord) (string, error)
+	// CreateProduct insert an entity record of product into database and returns the persisted record.
+	// The qty of the product is recorded as its initial stock movement.
+	// ErrCategoryNotFound is returned when one of its categories does not exist.
+	CreateProduct(ctx context.Context, rec *ProductRecord) (*ProductRecord, error)
 
 	// GetProductByID retrieves an ProductRecord from database where the product id is specified.
 	GetProductByID(ctx context.Context, productID int) (*ProductRecord, error)
 
-	// GetProductByBrandID retrieves an array of ProductRecord from database where the brand id is specified.
-	GetProductByBrandID(ctx context.Context, brandID int) ([]*ProductRecord, error)
+	// GetProductByBrandID retrieves an array of ProductRecord from database where the brand id is specified, ordered by product id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetProductByBrandID(ctx context.Context, brandID int, page *pagination.Page) ([]*ProductRecord, int, error)
+
+	// GetProducts retrieves the products matching the filter, sorted and paged as the filter asks,
+	// with the number of products matching the filter.
+	GetProducts(ctx context.Context, filter *ProductFilter) ([]*ProductRecord, int, error)
+
+	// GetProductsByCategoryID retrieves the products listed in a category or any of its subcategories,
+	// once each and ordered by product id, with their categories.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetProductsByCategoryID(ctx context.Context, categoryID int, page *pagination.Page) ([]*ProductRecord, int, error)
+
+	// UpdateProduct update an entity record of product in database where the product id is specified.
+	// A change of qty is recorded as an adjustment stock movement made by actor,
+	// ErrActorRequired is returned when the qty changes without one.
+	// ErrCategoryNotFound is returned when one of its categories does not exist.
+	UpdateProduct(ctx context.Context, rec *ProductRecord, actor string) (string, error)
+
+	// DeleteProduct delete an entity record of product from database where the product id is specified.
+	// ErrProductHasOrders is returned when transaction details still reference the product,
+	// ErrProductHasStockMovements when its stock ledger does, the ledger outlives the product.
+	DeleteProduct(ctx context.Context, productID int) (string, error)
+
+	// AdjustStock adds the delta of rec to the qty of its product and of its warehouse and records rec in the stock movements
+	// in the same db transaction, then returns the updated product.
+	// ErrInsufficientStock is returned when a negative delta would take the qty of the warehouse below zero,
+	// ErrWarehouseNotFound when the warehouse does not exist.
+	AdjustStock(ctx context.Context, rec *StockMovementRecord) (*ProductRecord, error)
+
+	// GetStockMovements retrieves the stock movements of a product, oldest first.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetStockMovements(ctx context.Context, productID int, page *pagination.Page) ([]*StockMovementRecord, int, error)
+}
+
+type CategoryRepository interface {
+	// CreateCategory insert an entity record of category into database and returns the persisted record.
+	// ErrCategoryNotFound is returned when its parent does not exist.
+	CreateCategory(ctx context.Context, rec *CategoryRecord) (*CategoryRecord, error)
+
+	// GetCategoryByID retrieves an CategoryRecord from database where the category id is specified.
+	GetCategoryByID(ctx context.Context, categoryID int) (*CategoryRecord, error)
+
+	// GetCategories retrieves every CategoryRecord from database ordered by category id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetCategories(ctx context.Context, page *pagination.Page) ([]*CategoryRecord, int, error)
+
+	// GetCategoryPaths retrieves the breadcrumb of every distinct category of categoryIDs, ordered by category id.
+	// ErrCategoryNotFound is returned when one of them does not exist.
+	GetCategoryPaths(ctx context.Context, categoryIDs []int) ([]CategoryPath, error)
+
+	// UpdateCategory update an entity record of category in database where the category id is specified.
+	// ErrCategoryNotFound is returned when the category or its new parent does not exist,
+	// ErrInvalidCategoryParent when the new parent is the category itself or one of its subcategories.
+	UpdateCategory(ctx context.Context, rec *CategoryRecord) (string, error)
+
+	// DeleteCategory delete an entity record of category from database where the category id is specified,
+	// the products listed in it are unlisted. ErrCategoryHasChildren is returned when it still has subcategories.
+	DeleteCategory(ctx context.Context, categoryID int) (string, error)
+}
+
+type VariantRepository interface {
+	// CreateVariant insert an entity record of variant into database and returns the persisted record.
+	// The qty of the variant is recorded as its initial stock movement.
+	// ErrProductNotFound is returned when its product does not exist, ErrDuplicateSKU when the sku is already used.
+	CreateVariant(ctx context.Context, rec *ProductVariantRecord) (*ProductVariantRecord, error)
+
+	// GetVariantByID retrieves an ProductVariantRecord from database where the variant id is specified.
+	GetVariantByID(ctx context.Context, variantID int) (*ProductVariantRecord, error)
+
+	// GetVariantsByProductID retrieves the variants of a product ordered by variant id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetVariantsByProductID(ctx context.Context, productID int, page *pagination.Page) ([]*ProductVariantRecord, int, error)
+
+	// UpdateVariant update an entity record of variant in database where the variant id is specified, its product does not change.
+	// A change of qty is recorded as an adjustment stock movement made by actor,
+	// ErrActorRequired is returned when the qty changes without one.
+	// ErrDuplicateSKU is returned when the sku is already used by another variant.
+	UpdateVariant(ctx context.Context, rec *ProductVariantRecord, actor string) (string, error)
+
+	// DeleteVariant delete an entity record of variant from database where the variant id is specified.
+	// ErrVariantHasOrders is returned when transaction details still reference the variant.
+	DeleteVariant(ctx context.Context, variantID int) (string, error)
+}
+
+type WarehouseRepository interface {
+	// CreateWarehouse insert an entity record of warehouse into database and returns the persisted record.
+	// ErrDuplicateWarehouseCode is returned when the code is already used.
+	CreateWarehouse(ctx context.Context, rec *WarehouseRecord) (*WarehouseRecord, error)
+
+	// GetWarehouseByID retrieves an WarehouseRecord from database where the warehouse id is specified.
+	GetWarehouseByID(ctx context.Context, warehouseID int) (*WarehouseRecord, error)
+
+	// GetWarehouses retrieves every WarehouseRecord from database ordered by warehouse id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetWarehouses(ctx context.Context, page *pagination.Page) ([]*WarehouseRecord, int, error)
+
+	// UpdateWarehouse update an entity record of warehouse in database where the warehouse id is specified.
+	// ErrDuplicateWarehouseCode is returned when the code is already used by another warehouse.
+	UpdateWarehouse(ctx context.Context, rec *WarehouseRecord) (string, error)
+
+	// GetWarehouseStock retrieves the stock kept in a warehouse ordered by product id then variant id, the product itself first.
+	// The page of it page asks is returned with the number of rows, a nil page returns all of it. The cursor of a row
+	// is its product id and variant id.
+	GetWarehouseStock(ctx context.Context, warehouseID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error)
+
+	// GetProductWarehouseStock retrieves the stock of a product and of its variants in every warehouse,
+	// ordered by warehouse id then variant id, the product itself first.
+	// The page of it page asks is returned with the number of rows, a nil page returns all of it. The cursor of a row
+	// is its warehouse id and variant id.
+	GetProductWarehouseStock(ctx context.Context, productID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error)
+
+	// TransferStock moves qty of a product or variant from a warehouse to another, recording a transfer stock movement
+	// out of the first and into the second in the same db transaction, and returns both movements.
+	// The qty of the product or variant does not change. ErrWarehouseNotFound is returned when a warehouse does not exist,
+	// ErrInsufficientStock when the source warehouse does not have the qty.
+	TransferStock(ctx context.Context, rec *StockTransferRecord) ([]*StockMovementRecord, error)
 }
 
 type TransactionRepository interface {
-	// CreateTransaction insert an entity record of transaction into database.
-	CreateTransaction(ctx context.Context, rec *TransactionRecord) (string, error)
+	// CreateTransaction insert an entity record of transaction into database and returns the persisted record,
+	// including the computed grand total and the sub total of every detail.
+	// The qty of every detail is reserved for the pending order instead of taken from the products, until the order is paid
+	// or the reservation expires. ErrInsufficientStock is returned when the qty not reserved by other orders does not cover it.
+	// A detail with a variant is priced at the price of the variant and reserves its qty from the variant instead of the product,
+	// ErrVariantNotFound is returned when the variant does not exist or belongs to another product.
+	// The allocation strategy of the connector picks the warehouses the order ships from and the qty is reserved in them,
+	// the qty the other pending orders reserved in a warehouse can not be allocated again.
+	// The tax of every detail is computed by the TaxCalculator of the connector from its sub total after the discount.
+	// The coupon of rec.CouponCode, when set, is redeemed in the same db transaction and its discount lines are stored per detail;
+	// ErrCouponNotFound, ErrCouponNotApplicable or ErrCouponUsageExceeded is returned when it can not be used.
+	// rec.ShippingAddress is stored with the transaction and the ShippingRateProvider of the connector charges its ShippingCost,
+	// an order without a shipping address is not charged for shipping.
+	CreateTransaction(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error)
+
+	// CreateTransactionIdempotent is CreateTransaction guarded by the idempotency key of idem.
+	// The first request with the key creates the transaction and stores the key, its RequestHash and the response
+	// built by respond in the same db transaction. A repeat of the request returns the stored record instead,
+	// with replayed set, and ErrIdempotencyKeyReused is returned when the key comes with a different RequestHash.
+	// An expired key is treated as a new one, idem.CreatedAt is the current time the expiry is checked against.
+	CreateTransactionIdempotent(ctx context.Context, rec *TransactionRecord, idem *IdempotencyRecord, respond IdempotentResponse) (stored *IdempotencyRecord, replayed bool, err error)
+
+	// DeleteExpiredIdempotencyKeys deletes the idempotency keys that expired at or before now and returns how many were deleted.
+	// An expired key is already treated as a new one, deleting it only frees its row.
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
 
-	// GetTransactionByTransactionID retrieves the detail of a transaction from database where the transaction id is specified.
+	// GetTransactionByTransactionID retrieves the detail of a transaction from database where the transaction id is specified,
+	// with the discount lines and the warehouse allocations of every detail.
 	GetTransactionByTransactionID(ctx context.Context, transactionID int) (*TransactionRecord, error)
+
+	// UpdateTransactionStatus moves a transaction to status and records the change in its status history.
+	// Moving a pending order releases its reservation, and paying it takes the qty of every detail from the products
+	// in the same db transaction; ErrInsufficientStock is returned when its reservation expired and the qty was ordered by others.
+	// Moving a paid order to cancelled or refunded restores the qty of every detail to the products.
+	// The qty of a detail with a variant is taken from and restored to the variant instead.
+	// The qty of a paid detail is taken from the warehouses CreateTransaction reserved it in, they are recorded
+	// in its Allocations and get the qty back when it is restored. The allocation strategy of the connector picks them again
+	// when the reservation expired or the warehouses no longer hold its qty, eg. after a transfer.
+	// ErrInvalidStatusTransition is returned when the current status does not allow the move.
+	UpdateTransactionStatus(ctx context.Context, transactionID int, status string, actor string) (*TransactionRecord, error)
+
+	// GetTransactionStatusHistory retrieves the status changes of a transaction, oldest first.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetTransactionStatusHistory(ctx context.Context, transactionID int, page *pagination.Page) ([]*TransactionStatusHistoryRecord, int, error)
+
+	// GetTransactionsByUserID retrieves the transactions of a user with their detail, newest first, paged as the filter asks
+	// with the number of transactions matching the filter. Orders with the same date are ordered by descending id
+	// so the cursor of an order, its date in unix nanoseconds and its id, is stable.
+	GetTransactionsByUserID(ctx context.Context, filter *TransactionFilter) ([]*TransactionRecord, int, error)
+}
+
+type CartRepository interface {
+	// GetCart retrieves the cart of a user with the current price and stock of every product in it.
+	GetCart(ctx context.Context, userID int) (*CartRecord, error)
+
+	// AddCartItem adds the qty of rec to the cart of its user, on top of the qty of the product already in the cart,
+	// and returns the updated cart. ErrProductNotFound is returned when the product does not exist.
+	AddCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error)
+
+	// UpdateCartItem replaces the qty of a product in the cart of a user and returns the updated cart.
+	// ErrCartItemNotFound is returned when the product is not in the cart.
+	UpdateCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error)
+
+	// RemoveCartItem removes a product from the cart of a user and returns the updated cart.
+	// ErrCartItemNotFound is returned when the product is not in the cart.
+	RemoveCartItem(ctx context.Context, userID int, productID int) (*CartRecord, error)
+
+	// CheckoutCart creates the transaction of the items in the cart of rec.UserID like CreateTransaction does,
+	// and empties the cart in the same db transaction. The detail of rec is replaced by the items of the cart,
+	// ErrCartEmpty is returned when there is none.
+	CheckoutCart(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error)
+}
+
+type ReservationRepository interface {
+	// ReleaseExpiredReservations deletes the stock reservations that expired at or before now and returns how many were deleted.
+	// An expired reservation already stopped holding stock, releasing it only frees its rows.
+	ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error)
+
+	// CancelExpiredTransactions cancels, as the system, the pending transactions whose reservation expired at or before now
+	// and returns how many were cancelled. A transaction with a pending or authorized payment is left to its payment.
+	CancelExpiredTransactions(ctx context.Context, now time.Time) (int, error)
+}
+
+type PaymentRepository interface {
+	// CreatePayment insert an entity record of payment into database and returns the persisted record.
+	// A payment is created pending and without a reference before the gateway is asked, see UpdatePaymentAuthorization.
+	// The transaction is locked so it gets a single active payment: ErrInvalidStatusTransition is returned when it can not
+	// be paid, ErrPaymentInProgress when it already has a pending, authorized or captured payment and
+	// ErrReservationExpired when the reservation of the pending transaction expired at the CreatedAt of rec.
+	// Its status does not move the transaction, only UpdatePaymentStatus does.
+	// ErrTransactionNotFound is returned when the transaction does not exist.
+	CreatePayment(ctx context.Context, rec *PaymentRecord) (*PaymentRecord, error)
+
+	// UpdatePaymentAuthorization records the reference and the status the gateway answered the authorization of a
+	// pending payment with. Neither moves the transaction.
+	// ErrInvalidPaymentTransition is returned when the payment already got its answer.
+	UpdatePaymentAuthorization(ctx context.Context, paymentID int, answer *GatewayPayment) (*PaymentRecord, error)
+
+	// GetPaymentByReference retrieves the PaymentRecord of a gateway with the gateway reference specified.
+	GetPaymentByReference(ctx context.Context, gateway string, reference string) (*PaymentRecord, error)
+
+	// GetPaymentsByTransactionID retrieves the payments of a transaction, oldest first.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetPaymentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*PaymentRecord, int, error)
+
+	// UpdatePaymentStatus moves a payment to status and its transaction along with it in the same db transaction:
+	// a captured payment moves the transaction to paid like UpdateTransactionStatus does, and fails with its error when the
+	// transaction can not be paid; a refunded payment moves the transaction to refunded when its status allows it.
+	// Moving a payment to the status it already has changes nothing, so a repeated webhook is harmless.
+	// ErrInvalidPaymentTransition is returned when the current status of the payment does not allow the move.
+	UpdatePaymentStatus(ctx context.Context, paymentID int, status string, actor string) (*PaymentRecord, error)
+}
+
+type ShipmentRepository interface {
+	// CreateShipment insert an entity record of shipment into database and returns the persisted record, in transit.
+	// The first shipment of a paid transaction moves it to shipped in the same db transaction, a shipped transaction
+	// may get more shipments for the parcels sent later. ErrInvalidStatusTransition is returned for a transaction
+	// in any other status, ErrDuplicateTrackingNumber when the carrier already has a shipment with the tracking number.
+	CreateShipment(ctx context.Context, rec *ShipmentRecord, actor string) (*ShipmentRecord, error)
+
+	// GetShipmentsByTransactionID retrieves the shipments of a transaction, oldest first.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetShipmentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*ShipmentRecord, int, error)
+
+	// UpdateShipmentStatus moves a shipment to status. Once no other shipment of its shipped transaction is on its way,
+	// delivering it completes the transaction in the same db transaction. Moving a shipment to the status it already has
+	// changes nothing. ErrInvalidShipmentTransition is returned when the current status of the shipment does not allow the move.
+	UpdateShipmentStatus(ctx context.Context, shipmentID int, status string, actor string) (*ShipmentRecord, error)
+}
+
+type CouponRepository interface {
+	// CreateCoupon insert an entity record of coupon into database and returns the persisted record.
+	// The code is stored upper case, ErrDuplicateCouponCode is returned when it is already used.
+	CreateCoupon(ctx context.Context, rec *CouponRecord) (*CouponRecord, error)
+
+	// GetCouponByID retrieves an CouponRecord from database where the coupon id is specified.
+	GetCouponByID(ctx context.Context, couponID int) (*CouponRecord, error)
+
+	// GetCoupons retrieves every CouponRecord from database ordered by coupon id.
+	// The page of them page asks is returned with the number of them, a nil page returns all of them.
+	GetCoupons(ctx context.Context, page *pagination.Page) ([]*CouponRecord, int, error)
 }
@@ -0,0 +1,215 @@
+package connectors
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// ErrorKind the class of a domain error, the api answers every kind with its own http status
+type ErrorKind int
+
+const (
+	// KindInternal an unexpected failure the client can not do anything about
+	KindInternal ErrorKind = iota
+
+	// KindBadRequest the request can not be read, eg. malformed json or a non numeric id
+	KindBadRequest
+
+	// KindValidation the request is readable but its values are not acceptable
+	KindValidation
+
+	// KindNotFound the requested record does not exist
+	KindNotFound
+
+	// KindConflict the request conflicts with the current state of the record
+	KindConflict
+
+	// KindInsufficientStock the order asks for more qty than the product has in stock
+	KindInsufficientStock
+
+	// KindPaymentDeclined the payment gateway refused to take the payment
+	KindPaymentDeclined
+
+	// KindUnauthorized the request can not prove where it comes from, eg. a webhook with a wrong signature
+	KindUnauthorized
+)
+
+// Error a domain error with its kind and a stable machine readable code.
+// Two errors with the same code match with errors.Is, so a sentinel also matches the errors made from it.
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+
+	// Err the underlying cause, may be nil
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying cause
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a domain error with the same code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+var (
+	// ErrBadRequest matches every error made by NewBadRequestError
+	ErrBadRequest = &Error{Kind: KindBadRequest, Code: "bad_request", Message: "request can not be read"}
+
+	// ErrValidation matches every error made by NewValidationError
+	ErrValidation = &Error{Kind: KindValidation, Code: "validation_failed", Message: "request is not valid"}
+
+	// ErrBrandNotFound returned when no brand has the requested id
+	ErrBrandNotFound = &Error{Kind: KindNotFound, Code: "brand_not_found", Message: "brand not found"}
+
+	// ErrProductNotFound returned when no product has the requested id
+	ErrProductNotFound = &Error{Kind: KindNotFound, Code: "product_not_found", Message: "product not found"}
+
+	// ErrUserNotFound returned when no user, or only a soft deleted one, has the requested id
+	ErrUserNotFound = &Error{Kind: KindNotFound, Code: "user_not_found", Message: "user not found"}
+
+	// ErrTransactionNotFound returned when no transaction has the requested id
+	ErrTransactionNotFound = &Error{Kind: KindNotFound, Code: "transaction_not_found", Message: "transaction not found"}
+
+	// ErrCouponNotFound returned when no coupon has the requested id or code
+	ErrCouponNotFound = &Error{Kind: KindNotFound, Code: "coupon_not_found", Message: "coupon not found"}
+
+	// ErrPaymentNotFound returned when no payment has the requested id or gateway reference, or the order has no payment to act on
+	ErrPaymentNotFound = &Error{Kind: KindNotFound, Code: "payment_not_found", Message: "payment not found"}
+
+	// ErrAddressNotFound returned when the user has no address with the requested id, or no default address
+	ErrAddressNotFound = &Error{Kind: KindNotFound, Code: "address_not_found", Message: "address not found"}
+
+	// ErrShipmentNotFound returned when no shipment has the requested id
+	ErrShipmentNotFound = &Error{Kind: KindNotFound, Code: "shipment_not_found", Message: "shipment not found"}
+
+	// ErrVariantNotFound returned when no variant has the requested id, or an order picks a variant of another product
+	ErrVariantNotFound = &Error{Kind: KindNotFound, Code: "variant_not_found", Message: "variant not found"}
+
+	// ErrWarehouseNotFound returned when no warehouse has the requested id
+	ErrWarehouseNotFound = &Error{Kind: KindNotFound, Code: "warehouse_not_found", Message: "warehouse not found"}
+
+	// ErrCategoryNotFound returned when no category has the requested id, or a product or category refers to one that does not exist
+	ErrCategoryNotFound = &Error{Kind: KindNotFound, Code: "category_not_found", Message: "category not found"}
+
+	// ErrCartItemNotFound returned when the product is not in the cart of the user
+	ErrCartItemNotFound = &Error{Kind: KindNotFound, Code: "cart_item_not_found", Message: "product is not in the cart"}
+
+	// ErrCartEmpty returned when a cart without items is checked out
+	ErrCartEmpty = &Error{Kind: KindValidation, Code: "cart_empty", Message: "cart has no items"}
+
+	// ErrBrandHasProducts returned when a brand is deleted while products or brand-scoped coupons still reference it
+	ErrBrandHasProducts = &Error{Kind: KindConflict, Code: "brand_has_products", Message: "brand is still referenced by products or coupons"}
+
+	// ErrCategoryHasChildren returned when a category is deleted while it still has subcategories
+	ErrCategoryHasChildren = &Error{Kind: KindConflict, Code: "category_has_children", Message: "category still has subcategories"}
+
+	// ErrInvalidCategoryParent returned when a category is moved under itself or one of its subcategories
+	ErrInvalidCategoryParent = &Error{Kind: KindConflict, Code: "invalid_category_parent", Message: "category can not be moved under itself or its subcategories"}
+
+	// ErrProductHasOrders returned when a product is deleted while transaction details still reference it through fk_transaction_detail_products1
+	ErrProductHasOrders = &Error{Kind: KindConflict, Code: "product_has_orders", Message: "product is still referenced by orders"}
+
+	// ErrProductHasStockMovements returned when a product is deleted while its stock ledger still references it through fk_stock_movements_products1
+	ErrProductHasStockMovements = &Error{Kind: KindConflict, Code: "product_has_stock_movements", Message: "product still has stock movements"}
+
+	// ErrVariantHasOrders returned when a variant is deleted while transaction details still reference it through fk_transaction_detail_product_variants1
+	ErrVariantHasOrders = &Error{Kind: KindConflict, Code: "variant_has_orders", Message: "variant is still referenced by orders"}
+
+	// ErrDuplicateWarehouseCode returned when a warehouse is saved with a code another warehouse already has
+	ErrDuplicateWarehouseCode = &Error{Kind: KindConflict, Code: "duplicate_warehouse_code", Message: "warehouse code is already used"}
+
+	// ErrDuplicateSKU returned when a variant is saved with a sku another variant already has
+	ErrDuplicateSKU = &Error{Kind: KindConflict, Code: "duplicate_sku", Message: "sku is already used by another variant"}
+
+	// ErrDuplicateEmail returned when a user is saved with an email another user already has
+	ErrDuplicateEmail = &Error{Kind: KindConflict, Code: "duplicate_email", Message: "email is already used by another user"}
+
+	// ErrDuplicateCouponCode returned when a coupon is saved with a code another coupon already has
+	ErrDuplicateCouponCode = &Error{Kind: KindConflict, Code: "duplicate_coupon_code", Message: "coupon code is already used by another coupon"}
+
+	// ErrCouponUsageExceeded returned when an order uses a coupon that reached its global or per user usage limit
+	ErrCouponUsageExceeded = &Error{Kind: KindConflict, Code: "coupon_usage_exceeded", Message: "coupon usage limit is reached"}
+
+	// ErrCouponNotApplicable returned when an order uses a coupon outside its validity window, below its min spend
+	// or without a product it discounts. The errors made from it carry the reason.
+	ErrCouponNotApplicable = &Error{Kind: KindValidation, Code: "coupon_not_applicable", Message: "coupon can not be applied to the order"}
+
+	// ErrInvalidStatusTransition returned when an order is moved to a status its current status does not allow
+	ErrInvalidStatusTransition = &Error{Kind: KindConflict, Code: "invalid_status_transition", Message: "transaction status transition is not allowed"}
+
+	// ErrInvalidShipmentTransition returned when a shipment is moved to a status its current status does not allow
+	ErrInvalidShipmentTransition = &Error{Kind: KindConflict, Code: "invalid_shipment_transition", Message: "shipment status transition is not allowed"}
+
+	// ErrDuplicateTrackingNumber returned when a shipment is saved with a tracking number another shipment of the carrier already has
+	ErrDuplicateTrackingNumber = &Error{Kind: KindConflict, Code: "duplicate_tracking_number", Message: "tracking number is already used by another shipment of the carrier"}
+
+	// ErrInvalidPaymentTransition returned when a payment is moved to a status its current status does not allow
+	ErrInvalidPaymentTransition = &Error{Kind: KindConflict, Code: "invalid_payment_transition", Message: "payment status transition is not allowed"}
+
+	// ErrPaymentInProgress returned when an order is paid while another of its payments is pending, authorized or captured
+	ErrPaymentInProgress = &Error{Kind: KindConflict, Code: "payment_in_progress", Message: "order already has a payment in progress"}
+
+	// ErrPaymentDeclined returned when the payment gateway declines to authorize a payment
+	ErrPaymentDeclined = &Error{Kind: KindPaymentDeclined, Code: "payment_declined", Message: "payment is declined"}
+
+	// ErrInvalidWebhookSignature returned when the signature of a payment gateway webhook does not match its payload
+	ErrInvalidWebhookSignature = &Error{Kind: KindUnauthorized, Code: "invalid_webhook_signature", Message: "webhook signature is not valid"}
+
+	// ErrIdempotencyKeyReused returned when an idempotency key is sent again with a different request
+	ErrIdempotencyKeyReused = &Error{Kind: KindValidation, Code: "idempotency_key_reused", Message: "idempotency key is already used by a different request"}
+
+	// ErrCurrencyMismatch returned when amounts of different currencies are combined, eg. an order of products priced in different currencies
+	ErrCurrencyMismatch = &Error{Kind: KindValidation, Code: "currency_mismatch", Message: "amounts of different currencies can not be combined"}
+
+	// ErrMoneyOverflow returned when an amount does not fit in 64 bits, eg. the sub total of a huge qty
+	ErrMoneyOverflow = &Error{Kind: KindValidation, Code: "amount_overflow", Message: "amount is too large"}
+
+	// ErrReservationExpired returned when a pending order is paid after its reservation expired, it can only be cancelled
+	ErrReservationExpired = &Error{Kind: KindConflict, Code: "reservation_expired", Message: "reservation of the order expired"}
+
+	// ErrActorRequired returned when the qty of a product or variant changes without an actor,
+	// every stock movement is recorded with who made it
+	ErrActorRequired = &Error{Kind: KindValidation, Code: "actor_required", Message: "actor is required to change the qty"}
+
+	// ErrInsufficientStock returned when an order asks for more qty than the product has in stock
+	ErrInsufficientStock = &Error{Kind: KindInsufficientStock, Code: "insufficient_stock", Message: "product qty is not enough"}
+)
+
+// NewBadRequestError wraps the failure of reading a request, eg. a json syntax error
+func NewBadRequestError(err error) error {
+	return &Error{Kind: ErrBadRequest.Kind, Code: ErrBadRequest.Code, Message: ErrBadRequest.Message, Err: err}
+}
+
+// NewValidationError wraps the failure of validating a request
+func NewValidationError(err error) error {
+	return &Error{Kind: ErrValidation.Kind, Code: ErrValidation.Code, Message: ErrValidation.Message, Err: err}
+}
+
+// ErrorKindOf returns the kind of the domain error in the chain of err, KindInternal when there is none
+func ErrorKindOf(err error) ErrorKind {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Kind
+	}
+	return KindInternal
+}
+
+// notFound translates sql.ErrNoRows into the not found error of the entity, other errors are returned as they are
+func notFound(err error, notFoundErr error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErr
+	}
+	return err
+}
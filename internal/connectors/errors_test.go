@@ -0,0 +1,41 @@
+package connectors
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestError(t *testing.T) {
+	t.Run("wrapped-sentinel", func(t *testing.T) {
+		err := fmt.Errorf("get brand 1: %w", ErrBrandNotFound)
+
+		assert.True(t, errors.Is(err, ErrBrandNotFound))
+		assert.False(t, errors.Is(err, ErrProductNotFound))
+		assert.Equal(t, KindNotFound, ErrorKindOf(err))
+	})
+
+	t.Run("constructed-error-matches-sentinel", func(t *testing.T) {
+		cause := errors.New("Key: 'brandRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag")
+		err := NewValidationError(cause)
+
+		assert.True(t, errors.Is(err, ErrValidation))
+		assert.True(t, errors.Is(err, cause))
+		assert.Equal(t, KindValidation, ErrorKindOf(err))
+		assert.Equal(t, "request is not valid: "+cause.Error(), err.Error())
+	})
+
+	t.Run("other-error-is-internal", func(t *testing.T) {
+		assert.Equal(t, KindInternal, ErrorKindOf(errors.New("connection refused")))
+	})
+
+	t.Run("translate-sql-no-rows", func(t *testing.T) {
+		assert.Equal(t, ErrUserNotFound, notFound(sql.ErrNoRows, ErrUserNotFound))
+
+		other := errors.New("connection refused")
+		assert.Equal(t, other, notFound(other, ErrUserNotFound))
+	})
+}
@@ -0,0 +1,2832 @@
+package connectors
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+)
+
+var (
+	inMemoryLog        = log.WithField("file", "inmemory_db_connector.go")
+	inMemoryDbInstance *InMemoryDB
+	inMemoryDbOnce     sync.Once
+)
+
+// GetInMemoryDBInstance initializes the InMemoryDB instance
+func GetInMemoryDBInstance() *InMemoryDB {
+	inMemoryDbOnce.Do(func() {
+		inMemoryDbInstance = NewInMemoryDB()
+		inMemoryDbInstance.SetTaxCalculator(NewRateTaxCalculatorFromConfig())
+		inMemoryDbInstance.SetShippingRateProvider(NewFlatShippingRateProviderFromConfig())
+		inMemoryDbInstance.SetReservationTTL(reservationTTLFromConfig())
+		inMemoryDbInstance.SetAllocationStrategy(NewAllocationStrategyFromConfig())
+		inMemoryDbInstance.SetSearchPriceBuckets(priceBucketsFromConfig())
+	})
+	return inMemoryDbInstance
+}
+
+// NewInMemoryDB creates an InMemoryDB instance seeded with the same data as sql/000002_init_data.up.sql
+func NewInMemoryDB() *InMemoryDB {
+	db := &InMemoryDB{
+		users:             make(map[int]*UserRecord),
+		brands:            make(map[int]*BrandRecord),
+		products:          make(map[int]*ProductRecord),
+		variants:          make(map[int]*ProductVariantRecord),
+		transactions:      make(map[int]*TransactionRecord),
+		transactionDetail: make(map[int][]*TransactionDetailRecord),
+		statusHistory:     make(map[int][]*TransactionStatusHistoryRecord),
+		stockMovements:    make(map[int][]*StockMovementRecord),
+		warehouses:        make(map[int]*WarehouseRecord),
+		warehouseStock:    make(warehouseStock),
+		idempotencyKeys:   make(map[string]*IdempotencyRecord),
+		coupons:           make(map[int]*CouponRecord),
+		couponRedemptions: make(map[int][]*couponRedemption),
+		carts:             make(map[int][]*CartItemRecord),
+		reservations:      make(map[int][]*stockReservation),
+		payments:          make(map[int]*PaymentRecord),
+		addresses:         make(map[int]*AddressRecord),
+		shipments:         make(map[int]*ShipmentRecord),
+		categories:        make(map[int]*CategoryRecord),
+		productCategories: make(map[int][]int),
+		deletedUsers:      make(map[int]time.Time),
+		search:            newSearchIndex(),
+	}
+	db.seed()
+	return db
+}
+
+// InMemoryDB db instance keeping every table in memory, guarded by a single lock
+type InMemoryDB struct {
+	mu sync.RWMutex
+
+	users    map[int]*UserRecord
+	brands   map[int]*BrandRecord
+	products map[int]*ProductRecord
+
+	// variants every product variant keyed by variant id
+	variants map[int]*ProductVariantRecord
+
+	transactions      map[int]*TransactionRecord
+	transactionDetail map[int][]*TransactionDetailRecord
+	statusHistory     map[int][]*TransactionStatusHistoryRecord
+
+	// stockMovements the stock ledger of every product keyed by product id, oldest first
+	stockMovements map[int][]*StockMovementRecord
+
+	// warehouses every warehouse keyed by warehouse id
+	warehouses map[int]*WarehouseRecord
+
+	// warehouseStock the qty every warehouse keeps of every product and variant, the rows of warehouse_stock
+	warehouseStock warehouseStock
+
+	// idempotencyKeys the response of the orders created with an Idempotency-Key, keyed by the key
+	idempotencyKeys map[string]*IdempotencyRecord
+
+	coupons map[int]*CouponRecord
+
+	// couponRedemptions the orders every coupon was used on, keyed by coupon id
+	couponRedemptions map[int][]*couponRedemption
+
+	// carts the items in the cart of every user keyed by user id, in the order they were added.
+	// Only the fields stored in cart_items are kept, the product fields are filled when the cart is read.
+	carts map[int][]*CartItemRecord
+
+	// reservations the stock reserved by every pending order keyed by transaction id, one per product and one per variant
+	// in every warehouse it is reserved in
+	reservations map[int][]*stockReservation
+
+	// payments every payment keyed by payment id
+	payments map[int]*PaymentRecord
+
+	// addresses the address book of every user keyed by address id
+	addresses map[int]*AddressRecord
+
+	// shipments every shipment keyed by shipment id
+	shipments map[int]*ShipmentRecord
+
+	// categories every category keyed by category id
+	categories map[int]*CategoryRecord
+
+	// productCategories the categories every product is listed in keyed by product id, ordered by category id
+	productCategories map[int][]int
+
+	// deletedUsers soft deleted user ids with their deletion time, the rows stay in users like they do in mysql
+	deletedUsers map[int]time.Time
+
+	// search the words of the name, brand and categories of every product, indexed again whenever one of them changes
+	search *searchIndex
+
+	// taxCalculator computes the tax of an order, nil charges no tax
+	taxCalculator TaxCalculator
+
+	// shippingRates prices the shipping of an order, nil ships for free
+	shippingRates ShippingRateProvider
+
+	// reservationTTL how long a pending order holds its stock, zero falls back to defaultReservationTTL
+	reservationTTL time.Duration
+
+	// allocation picks the warehouses the stock of an order is reserved in when it is created, nil falls back to defaultAllocation
+	allocation AllocationStrategy
+
+	// priceBuckets the sorted upper bounds of the price buckets of the search facets, none puts every price in one bucket
+	priceBuckets []int64
+
+	lastUserID          int
+	lastBrandID         int
+	lastProductID       int
+	lastTransactionID   int
+	lastStatusHistoryID int
+	lastStockMovementID int
+	lastCouponID        int
+	lastPaymentID       int
+	lastAddressID       int
+	lastShipmentID      int
+	lastCategoryID      int
+	lastVariantID       int
+	lastWarehouseID     int
+}
+
+// SetTaxCalculator replaces the TaxCalculator orders are taxed with
+func (db *InMemoryDB) SetTaxCalculator(calc TaxCalculator) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.taxCalculator = calc
+}
+
+// taxCalc the TaxCalculator of db, noTax when it has none, the caller must hold the lock
+func (db *InMemoryDB) taxCalc() TaxCalculator {
+	if db.taxCalculator == nil {
+		return noTax
+	}
+	return db.taxCalculator
+}
+
+// SetShippingRateProvider replaces the ShippingRateProvider the shipping of orders is priced with
+func (db *InMemoryDB) SetShippingRateProvider(rates ShippingRateProvider) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.shippingRates = rates
+}
+
+// shippingRateProvider the ShippingRateProvider of db, freeShipping when it has none, the caller must hold the lock
+func (db *InMemoryDB) shippingRateProvider() ShippingRateProvider {
+	if db.shippingRates == nil {
+		return freeShipping
+	}
+	return db.shippingRates
+}
+
+// SetReservationTTL replaces how long a pending order holds its stock
+func (db *InMemoryDB) SetReservationTTL(ttl time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.reservationTTL = ttl
+}
+
+// SetAllocationStrategy replaces the AllocationStrategy the warehouses of new orders are picked with
+func (db *InMemoryDB) SetAllocationStrategy(strategy AllocationStrategy) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.allocation = strategy
+}
+
+// allocationStrategy the AllocationStrategy of db, defaultAllocation when it has none, the caller must hold the lock
+func (db *InMemoryDB) allocationStrategy() AllocationStrategy {
+	if db.allocation == nil {
+		return defaultAllocation
+	}
+	return db.allocation
+}
+
+// SetSearchPriceBuckets replaces the upper bounds of the price buckets products are counted in by SearchProducts
+func (db *InMemoryDB) SetSearchPriceBuckets(bounds []int64) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.priceBuckets = normalizePriceBuckets(bounds)
+}
+
+// couponRedemption a row of coupon_redemptions
+type couponRedemption struct {
+	UserID        int
+	TransactionID int
+}
+
+// stockReservation a row of stock_reservations, the transaction is the key it is stored under
+type stockReservation struct {
+	ProductID int
+
+	// VariantID the variant the qty is reserved from, zero when it is reserved from the product itself
+	VariantID int
+
+	// WarehouseID the warehouse the qty is reserved in
+	WarehouseID int
+	Qty         int
+	ExpiresAt   time.Time
+}
+
+// seed populates the tables with the initial data of the application
+func (db *InMemoryDB) seed() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.users[1] = &UserRecord{ID: 1, Name: "donny", Email: "donny@arieffian.com", Address: "surabaya"}
+	db.lastUserID = 1
+
+	db.brands[1] = &BrandRecord{ID: 1, Name: "apple"}
+	db.brands[2] = &BrandRecord{ID: 2, Name: "lenovo"}
+	db.brands[3] = &BrandRecord{ID: 3, Name: "asus"}
+	db.lastBrandID = 3
+
+	db.products[1] = &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: NewMoney(1200, CurrencyIDR), TaxClass: TaxClassStandard}
+	db.products[2] = &ProductRecord{ID: 2, BrandID: 2, Name: "legion", Qty: 2, Price: NewMoney(1000, CurrencyIDR), TaxClass: TaxClassStandard}
+	db.products[3] = &ProductRecord{ID: 3, BrandID: 3, Name: "rog", Qty: 1, Price: NewMoney(1100, CurrencyIDR), TaxClass: TaxClassStandard}
+	db.lastProductID = 3
+
+	// like sql/000017_warehouses.up.sql the stock there was before the warehouses is kept in the default one
+	db.warehouses[DefaultWarehouseID] = &WarehouseRecord{ID: DefaultWarehouseID, Code: "MAIN", Name: "main warehouse", City: "jakarta", Country: "ID"}
+	db.lastWarehouseID = DefaultWarehouseID
+
+	// like sql/000006_stock_movements.up.sql the ledger opens with the qty the products have
+	for _, id := range []int{1, 2, 3} {
+		db.moveStock(&StockMovementRecord{ProductID: id, Delta: db.products[id].Qty, Reason: StockReasonInitial, Actor: systemActor, CreatedAt: time.Now()})
+		db.indexProduct(id)
+	}
+
+	// like sql/000009_transaction_tax.up.sql the subtotal is backfilled from the detail
+	zero := NewMoney(0, CurrencyIDR)
+	db.transactions[1] = &TransactionRecord{ID: 1, UserID: 1, Date: time.Date(2021, time.September, 1, 12, 0, 0, 0, time.Local),
+		Subtotal: NewMoney(3300, CurrencyIDR), Discount: zero, Tax: zero, GrandTotal: NewMoney(3400, CurrencyIDR), Status: TransactionStatusCompleted}
+	db.transactionDetail[1] = []*TransactionDetailRecord{
+		{TransactionID: 1, ProductID: 1, Qty: 1, SubTotal: NewMoney(1200, CurrencyIDR), Discount: zero, Tax: zero},
+		{TransactionID: 1, ProductID: 2, Qty: 1, SubTotal: NewMoney(1000, CurrencyIDR), Discount: zero, Tax: zero},
+		{TransactionID: 1, ProductID: 3, Qty: 1, SubTotal: NewMoney(1100, CurrencyIDR), Discount: zero, Tax: zero},
+	}
+	db.lastTransactionID = 1
+}
+
+// GetBrandByID retrieves an BrandRecord from database where the brand id is specified.
+func (db *InMemoryDB) GetBrandByID(ctx context.Context, brandID int) (*BrandRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetBrandByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	brand, ok := db.brands[brandID]
+	if !ok {
+		fLog.Errorf("brand %d got %s", brandID, ErrBrandNotFound.Error())
+		return nil, ErrBrandNotFound
+	}
+
+	b := *brand
+	return &b, nil
+}
+
+// CreateBrand insert an entity record of brand into database and returns the persisted record.
+func (db *InMemoryDB) CreateBrand(ctx context.Context, rec *BrandRecord) (*BrandRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.lastBrandID++
+	brand := &BrandRecord{
+		ID:   db.lastBrandID,
+		Name: rec.Name,
+	}
+	db.brands[brand.ID] = brand
+
+	b := *brand
+	return &b, nil
+}
+
+// GetBrands retrieves every BrandRecord from database ordered by brand id.
+func (db *InMemoryDB) GetBrands(ctx context.Context, page *pagination.Page) ([]*BrandRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	brandList := make([]*BrandRecord, 0, len(db.brands))
+	for _, brand := range db.brands {
+		b := *brand
+		brandList = append(brandList, &b)
+	}
+
+	sort.Slice(brandList, func(i, j int) bool {
+		return brandList[i].ID < brandList[j].ID
+	})
+
+	start, end := page.Bounds(len(brandList), func(i int, c *pagination.Cursor) int { return compareID(brandList[i].ID, c) })
+	return brandList[start:end], len(brandList), nil
+}
+
+// UpdateBrand update an entity record of brand in database where the brand id is specified.
+func (db *InMemoryDB) UpdateBrand(ctx context.Context, rec *BrandRecord) (string, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateBrand")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	brand, ok := db.brands[rec.ID]
+	if !ok {
+		fLog.Errorf("brand %d got %s", rec.ID, ErrBrandNotFound.Error())
+		return "", ErrBrandNotFound
+	}
+	brand.Name = rec.Name
+	for _, product := range db.products {
+		if product.BrandID == rec.ID {
+			db.indexProduct(product.ID)
+		}
+	}
+
+	return "brand updated successfully", nil
+}
+
+// DeleteBrand delete an entity record of brand from database where the brand id is specified.
+// ErrBrandHasProducts is returned when products or coupons still reference the brand.
+func (db *InMemoryDB) DeleteBrand(ctx context.Context, brandID int) (string, error) {
+	fLog := inMemoryLog.WithField("func", "DeleteBrand")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.brands[brandID]; !ok {
+		fLog.Errorf("brand %d got %s", brandID, ErrBrandNotFound.Error())
+		return "", ErrBrandNotFound
+	}
+
+	// emulate fk_products_brands
+	for _, product := range db.products {
+		if product.BrandID == brandID {
+			fLog.Errorf("brand %d got %s", brandID, ErrBrandHasProducts.Error())
+			return "", ErrBrandHasProducts
+		}
+	}
+
+	// emulate fk_coupons_brands1
+	for _, coupon := range db.coupons {
+		if coupon.BrandID == brandID {
+			fLog.Errorf("brand %d got %s", brandID, ErrBrandHasProducts.Error())
+			return "", ErrBrandHasProducts
+		}
+	}
+
+	delete(db.brands, brandID)
+
+	return "brand deleted successfully", nil
+}
+
+// CreateProduct insert an entity record of product into database and returns the persisted record.
+// ErrCategoryNotFound is returned when one of its categories does not exist.
+func (db *InMemoryDB) CreateProduct(ctx context.Context, rec *ProductRecord) (*ProductRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateProduct")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// emulate fk_products_brands
+	if _, ok := db.brands[rec.BrandID]; !ok {
+		fLog.Errorf("brand %d does not exist", rec.BrandID)
+		return nil, fmt.Errorf("brand %d does not exist", rec.BrandID)
+	}
+
+	categoryIDs := distinctCategoryIDs(rec.CategoryIDs)
+	if err := db.checkCategories(categoryIDs); err != nil {
+		fLog.Errorf("categories %v got %s", categoryIDs, err.Error())
+		return nil, err
+	}
+
+	db.lastProductID++
+	product := &ProductRecord{
+		ID:       db.lastProductID,
+		BrandID:  rec.BrandID,
+		Name:     rec.Name,
+		Qty:      rec.Qty,
+		Price:    rec.Price,
+		TaxClass: rec.TaxClass,
+	}
+	db.products[product.ID] = product
+	db.setProductCategories(product.ID, categoryIDs)
+	db.indexProduct(product.ID)
+	if rec.Qty != 0 {
+		db.moveStock(&StockMovementRecord{ProductID: product.ID, Delta: rec.Qty, Reason: StockReasonInitial, Actor: systemActor, CreatedAt: time.Now()})
+	}
+
+	p := *product
+	p.CategoryIDs = categoryIDs
+	return &p, nil
+}
+
+// GetProductByID retrieves an ProductRecord from database where the product id is specified.
+func (db *InMemoryDB) GetProductByID(ctx context.Context, productID int) (*ProductRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetProductByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	product, ok := db.products[productID]
+	if !ok {
+		fLog.Errorf("product %d got %s", productID, ErrProductNotFound.Error())
+		return nil, ErrProductNotFound
+	}
+
+	now := time.Now()
+	p := *product
+	p.Available = availableQty(p.Qty, db.reservedQty(stockKey{ProductID: productID}, 0, now))
+	p.Variants = db.productVariants(productID, now)
+	for _, variant := range p.Variants {
+		p.Available += variant.Available
+	}
+	if err := db.fillProductCategories(&p); err != nil {
+		fLog.Errorf("product %d got %s", productID, err.Error())
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetProductByBrandID retrieves an array of ProductRecord from database where the brand id is specified.
+func (db *InMemoryDB) GetProductByBrandID(ctx context.Context, brandID int, page *pagination.Page) ([]*ProductRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	productList := make([]*ProductRecord, 0)
+	for _, product := range db.products {
+		if product.BrandID == brandID {
+			p := *product
+			productList = append(productList, &p)
+		}
+	}
+
+	// keep the primary key order mysql would return
+	sort.Slice(productList, func(i, j int) bool {
+		return productList[i].ID < productList[j].ID
+	})
+
+	start, end := page.Bounds(len(productList), func(i int, c *pagination.Cursor) int { return compareID(productList[i].ID, c) })
+	return productList[start:end], len(productList), nil
+}
+
+// GetProducts retrieves the products matching the filter, sorted and paged as the filter asks.
+func (db *InMemoryDB) GetProducts(ctx context.Context, filter *ProductFilter) ([]*ProductRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	name := strings.ToLower(filter.Name)
+	productList := make([]*ProductRecord, 0)
+	for _, product := range db.products {
+		switch {
+		case name != "" && !strings.Contains(strings.ToLower(product.Name), name):
+			continue
+		case filter.BrandID != 0 && product.BrandID != filter.BrandID:
+			continue
+		case filter.Currency != "" && product.Price.Currency != filter.Currency:
+			continue
+		case filter.MinPrice != nil && product.Price.Amount < *filter.MinPrice:
+			continue
+		case filter.MaxPrice != nil && product.Price.Amount > *filter.MaxPrice:
+			continue
+		case filter.InStock && !db.inStock(product):
+			continue
+		}
+		p := *product
+		productList = append(productList, &p)
+	}
+
+	sort.Slice(productList, func(i, j int) bool {
+		return compareProducts(productList[i], productList[j], filter) < 0
+	})
+
+	var cursor *pagination.Cursor
+	if filter.Page.Keyset() {
+		cursor = filter.Page.After
+		if cursor == nil {
+			cursor = filter.Page.Before
+		}
+	}
+	// the product the cursor points at, with the values it was sorted by
+	cursorProduct := &ProductRecord{}
+	if cursor != nil {
+		cursorProduct.ID, cursorProduct.Name = cursor.ID, cursor.Value
+		if filter.Sort == ProductSortPrice {
+			amount, err := strconv.ParseInt(cursor.Value, 10, 64)
+			if err != nil {
+				return nil, 0, NewBadRequestError(err)
+			}
+			cursorProduct.Price.Amount = amount
+		}
+	}
+
+	start, end := filter.Page.Bounds(len(productList), func(i int, c *pagination.Cursor) int {
+		return compareProducts(productList[i], cursorProduct, filter)
+	})
+	return productList[start:end], len(productList), nil
+}
+
+// compareProducts -1, 0 or 1 as a comes before, at or after b in the order the filter asks
+func compareProducts(a, b *ProductRecord, filter *ProductFilter) int {
+	if filter.Desc {
+		a, b = b, a
+	}
+	switch filter.Sort {
+	case ProductSortName:
+		// compare like the case insensitive collation of the mysql column
+		if cmp := strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)); cmp != 0 {
+			return cmp
+		}
+	case ProductSortPrice:
+		if cmp := compareInts(a.Price.Amount, b.Price.Amount); cmp != 0 {
+			return cmp
+		}
+	}
+	return compareInts(int64(a.ID), int64(b.ID))
+}
+
+// inStock reports whether the product or one of its variants has qty left, the caller must hold the lock
+func (db *InMemoryDB) inStock(product *ProductRecord) bool {
+	if product.Qty > 0 {
+		return true
+	}
+	for _, variant := range db.variants {
+		if variant.ProductID == product.ID && variant.Qty > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProductsByCategoryID retrieves the products listed in a category or any of its subcategories,
+// once each and ordered by product id, with their categories.
+func (db *InMemoryDB) GetProductsByCategoryID(ctx context.Context, categoryID int, page *pagination.Page) ([]*ProductRecord, int, error) {
+	fLog := inMemoryLog.WithField("func", "GetProductsByCategoryID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	subtree := db.categorySubtree(categoryID)
+	productList := make([]*ProductRecord, 0)
+	for productID, categoryIDs := range db.productCategories {
+		for _, id := range categoryIDs {
+			if subtree[id] {
+				p := *db.products[productID]
+				if err := db.fillProductCategories(&p); err != nil {
+					fLog.Errorf("product %d got %s", productID, err.Error())
+					return nil, 0, err
+				}
+				productList = append(productList, &p)
+				break
+			}
+		}
+	}
+
+	sort.Slice(productList, func(i, j int) bool {
+		return productList[i].ID < productList[j].ID
+	})
+
+	start, end := page.Bounds(len(productList), func(i int, c *pagination.Cursor) int { return compareID(productList[i].ID, c) })
+	return productList[start:end], len(productList), nil
+}
+
+// SearchProducts returns the page of q of the products whose name, brand or categories match a word of the query,
+// with the number of matching products per brand and per price bucket
+func (db *InMemoryDB) SearchProducts(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	hits := make([]*SearchHit, 0)
+	brands := make(map[int]string)
+	for productID, score := range db.search.search(q.Query) {
+		p := *db.products[productID]
+		hits = append(hits, &SearchHit{ProductRecord: &p, Score: score})
+		if brand, ok := db.brands[p.BrandID]; ok {
+			brands[brand.ID] = brand.Name
+		}
+	}
+
+	return newSearchResult(q, hits, brands, db.priceBuckets), nil
+}
+
+// indexProduct indexes the product of productID again with the names of its brand and categories, the caller must hold the lock
+func (db *InMemoryDB) indexProduct(productID int) {
+	product := db.products[productID]
+	brand := ""
+	if b, ok := db.brands[product.BrandID]; ok {
+		brand = b.Name
+	}
+	categories := make([]string, 0, len(db.productCategories[productID]))
+	for _, id := range db.productCategories[productID] {
+		categories = append(categories, db.categories[id].Name)
+	}
+	db.search.index(productID, product.Name, brand, categories)
+}
+
+// checkCategories emulates fk_product_categories_categories1, ErrCategoryNotFound is returned when one of
+// the categories of categoryIDs does not exist. The caller must hold the lock.
+func (db *InMemoryDB) checkCategories(categoryIDs []int) error {
+	for _, id := range categoryIDs {
+		if _, ok := db.categories[id]; !ok {
+			return ErrCategoryNotFound
+		}
+	}
+	return nil
+}
+
+// setProductCategories replaces the categories the product of productID is listed in with the distinct
+// categoryIDs ordered by id. The caller must hold the lock.
+func (db *InMemoryDB) setProductCategories(productID int, categoryIDs []int) {
+	if len(categoryIDs) == 0 {
+		delete(db.productCategories, productID)
+		return
+	}
+	db.productCategories[productID] = append([]int(nil), categoryIDs...)
+}
+
+// fillProductCategories sets the categories of product with their breadcrumbs. The caller must hold the lock.
+func (db *InMemoryDB) fillProductCategories(product *ProductRecord) error {
+	product.CategoryIDs = append(make([]int, 0), db.productCategories[product.ID]...)
+
+	var err error
+	product.Categories, err = categoryPaths(db.categories, product.CategoryIDs)
+	return err
+}
+
+// categorySubtree the ids of the category of categoryID and of all of its subcategories, empty when it does not exist.
+// The caller must hold the lock.
+func (db *InMemoryDB) categorySubtree(categoryID int) map[int]bool {
+	subtree := make(map[int]bool)
+	if _, ok := db.categories[categoryID]; !ok {
+		return subtree
+	}
+
+	subtree[categoryID] = true
+	// every pass adds the children of the categories already found, until a pass adds none
+	for grown := true; grown; {
+		grown = false
+		for _, category := range db.categories {
+			if !subtree[category.ID] && subtree[category.ParentID] {
+				subtree[category.ID] = true
+				grown = true
+			}
+		}
+	}
+	return subtree
+}
+
+// CreateCategory insert an entity record of category into database and returns the persisted record.
+// ErrCategoryNotFound is returned when its parent does not exist.
+func (db *InMemoryDB) CreateCategory(ctx context.Context, rec *CategoryRecord) (*CategoryRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateCategory")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// emulate fk_categories_categories1
+	if _, ok := db.categories[rec.ParentID]; rec.ParentID != 0 && !ok {
+		fLog.Errorf("parent category %d got %s", rec.ParentID, ErrCategoryNotFound.Error())
+		return nil, ErrCategoryNotFound
+	}
+
+	db.lastCategoryID++
+	category := &CategoryRecord{
+		ID:       db.lastCategoryID,
+		ParentID: rec.ParentID,
+		Name:     rec.Name,
+	}
+	db.categories[category.ID] = category
+
+	c := *category
+	return &c, nil
+}
+
+// GetCategoryByID retrieves an CategoryRecord from database where the category id is specified.
+func (db *InMemoryDB) GetCategoryByID(ctx context.Context, categoryID int) (*CategoryRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetCategoryByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	category, ok := db.categories[categoryID]
+	if !ok {
+		fLog.Errorf("category %d got %s", categoryID, ErrCategoryNotFound.Error())
+		return nil, ErrCategoryNotFound
+	}
+
+	c := *category
+	return &c, nil
+}
+
+// GetCategories retrieves every CategoryRecord from database ordered by category id.
+func (db *InMemoryDB) GetCategories(ctx context.Context, page *pagination.Page) ([]*CategoryRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	categoryList := make([]*CategoryRecord, 0, len(db.categories))
+	for _, category := range db.categories {
+		c := *category
+		categoryList = append(categoryList, &c)
+	}
+
+	sort.Slice(categoryList, func(i, j int) bool {
+		return categoryList[i].ID < categoryList[j].ID
+	})
+
+	start, end := page.Bounds(len(categoryList), func(i int, c *pagination.Cursor) int { return compareID(categoryList[i].ID, c) })
+	return categoryList[start:end], len(categoryList), nil
+}
+
+// GetCategoryPaths retrieves the breadcrumb of every distinct category of categoryIDs, ordered by category id.
+// ErrCategoryNotFound is returned when one of them does not exist.
+func (db *InMemoryDB) GetCategoryPaths(ctx context.Context, categoryIDs []int) ([]CategoryPath, error) {
+	fLog := inMemoryLog.WithField("func", "GetCategoryPaths")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	paths, err := categoryPaths(db.categories, distinctCategoryIDs(categoryIDs))
+	if err != nil {
+		fLog.Errorf("categories %v got %s", categoryIDs, err.Error())
+		return nil, err
+	}
+	return paths, nil
+}
+
+// UpdateCategory update an entity record of category in database where the category id is specified.
+// ErrCategoryNotFound is returned when the category or its new parent does not exist,
+// ErrInvalidCategoryParent when the new parent is the category itself or one of its subcategories.
+func (db *InMemoryDB) UpdateCategory(ctx context.Context, rec *CategoryRecord) (string, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateCategory")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	category, ok := db.categories[rec.ID]
+	if !ok {
+		fLog.Errorf("category %d got %s", rec.ID, ErrCategoryNotFound.Error())
+		return "", ErrCategoryNotFound
+	}
+
+	if rec.ParentID != 0 {
+		if db.categorySubtree(rec.ID)[rec.ParentID] {
+			fLog.Errorf("category %d under %d got %s", rec.ID, rec.ParentID, ErrInvalidCategoryParent.Error())
+			return "", ErrInvalidCategoryParent
+		}
+		// emulate fk_categories_categories1
+		if _, ok := db.categories[rec.ParentID]; !ok {
+			fLog.Errorf("parent category %d got %s", rec.ParentID, ErrCategoryNotFound.Error())
+			return "", ErrCategoryNotFound
+		}
+	}
+
+	category.ParentID = rec.ParentID
+	category.Name = rec.Name
+	for productID, categoryIDs := range db.productCategories {
+		for _, id := range categoryIDs {
+			if id == rec.ID {
+				db.indexProduct(productID)
+				break
+			}
+		}
+	}
+
+	return "category updated successfully", nil
+}
+
+// DeleteCategory delete an entity record of category from database where the category id is specified,
+// the products listed in it are unlisted. ErrCategoryHasChildren is returned when it still has subcategories.
+func (db *InMemoryDB) DeleteCategory(ctx context.Context, categoryID int) (string, error) {
+	fLog := inMemoryLog.WithField("func", "DeleteCategory")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.categories[categoryID]; !ok {
+		fLog.Errorf("category %d got %s", categoryID, ErrCategoryNotFound.Error())
+		return "", ErrCategoryNotFound
+	}
+
+	// emulate fk_categories_categories1
+	for _, category := range db.categories {
+		if category.ParentID == categoryID {
+			fLog.Errorf("category %d got %s", categoryID, ErrCategoryHasChildren.Error())
+			return "", ErrCategoryHasChildren
+		}
+	}
+
+	delete(db.categories, categoryID)
+	// emulate the on delete cascade of fk_product_categories_categories1
+	for productID, categoryIDs := range db.productCategories {
+		remaining := make([]int, 0, len(categoryIDs))
+		for _, id := range categoryIDs {
+			if id != categoryID {
+				remaining = append(remaining, id)
+			}
+		}
+		if len(remaining) != len(categoryIDs) {
+			db.setProductCategories(productID, remaining)
+			db.indexProduct(productID)
+		}
+	}
+
+	return "category deleted successfully", nil
+}
+
+// UpdateProduct update an entity record of product in database where the product id is specified.
+// A change of qty is recorded as an adjustment stock movement made by actor,
+// ErrActorRequired is returned when the qty changes without one.
+// ErrCategoryNotFound is returned when one of its categories does not exist.
+func (db *InMemoryDB) UpdateProduct(ctx context.Context, rec *ProductRecord, actor string) (string, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateProduct")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	product, ok := db.products[rec.ID]
+	if !ok {
+		fLog.Errorf("product %d got %s", rec.ID, ErrProductNotFound.Error())
+		return "", ErrProductNotFound
+	}
+	if rec.Qty != product.Qty && actor == "" {
+		fLog.Errorf("product %d got %s", rec.ID, ErrActorRequired.Error())
+		return "", ErrActorRequired
+	}
+
+	// emulate fk_products_brands
+	if _, ok := db.brands[rec.BrandID]; !ok {
+		fLog.Errorf("brand %d does not exist", rec.BrandID)
+		return "", fmt.Errorf("brand %d does not exist", rec.BrandID)
+	}
+
+	categoryIDs := distinctCategoryIDs(rec.CategoryIDs)
+	if err := db.checkCategories(categoryIDs); err != nil {
+		fLog.Errorf("categories %v got %s", categoryIDs, err.Error())
+		return "", err
+	}
+
+	// a change of qty is made in the default warehouse
+	key := warehouseStockKey{WarehouseID: DefaultWarehouseID, stockKey: stockKey{ProductID: rec.ID}}
+	if db.warehouseStock[key]+rec.Qty-product.Qty < 0 {
+		fLog.Errorf("product %d got %s", rec.ID, ErrInsufficientStock.Error())
+		return "", ErrInsufficientStock
+	}
+
+	db.setProductCategories(rec.ID, categoryIDs)
+
+	if rec.Qty != product.Qty {
+		db.moveStock(&StockMovementRecord{ProductID: rec.ID, Delta: rec.Qty - product.Qty, Reason: StockReasonAdjustment, Actor: actor, CreatedAt: time.Now()})
+	}
+
+	product.BrandID = rec.BrandID
+	product.Name = rec.Name
+	product.Qty = rec.Qty
+	product.Price = rec.Price
+	product.TaxClass = rec.TaxClass
+	db.indexProduct(rec.ID)
+
+	return "product updated successfully", nil
+}
+
+// DeleteProduct delete an entity record of product from database where the product id is specified.
+// ErrProductHasOrders is returned when transaction details still reference the product,
+// ErrProductHasStockMovements when its stock ledger does.
+func (db *InMemoryDB) DeleteProduct(ctx context.Context, productID int) (string, error) {
+	fLog := inMemoryLog.WithField("func", "DeleteProduct")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.products[productID]; !ok {
+		fLog.Errorf("product %d got %s", productID, ErrProductNotFound.Error())
+		return "", ErrProductNotFound
+	}
+
+	// emulate fk_transaction_detail_products1
+	for _, details := range db.transactionDetail {
+		for _, detail := range details {
+			if detail.ProductID == productID {
+				fLog.Errorf("product %d got %s", productID, ErrProductHasOrders.Error())
+				return "", ErrProductHasOrders
+			}
+		}
+	}
+
+	// emulate fk_stock_movements_products1, the ledger outlives the product
+	if len(db.stockMovements[productID]) > 0 {
+		fLog.Errorf("product %d got %s", productID, ErrProductHasStockMovements.Error())
+		return "", ErrProductHasStockMovements
+	}
+
+	delete(db.products, productID)
+	// emulate the on delete cascade of fk_cart_items_products1, fk_product_categories_products1,
+	// fk_product_variants_products1 and fk_warehouse_stock_products1
+	delete(db.productCategories, productID)
+	db.search.remove(productID)
+	for variantID, variant := range db.variants {
+		if variant.ProductID == productID {
+			delete(db.variants, variantID)
+		}
+	}
+	for key := range db.warehouseStock {
+		if key.ProductID == productID {
+			delete(db.warehouseStock, key)
+		}
+	}
+	for userID := range db.carts {
+		db.removeCartItem(userID, productID)
+	}
+
+	return "product deleted successfully", nil
+}
+
+// AdjustStock adds the delta of rec to the qty of its product and of its warehouse and records rec in the stock movements
+// in the same db transaction, then returns the updated product.
+// ErrInsufficientStock is returned when a negative delta would take the qty of the warehouse below zero,
+// ErrWarehouseNotFound when the warehouse does not exist.
+func (db *InMemoryDB) AdjustStock(ctx context.Context, rec *StockMovementRecord) (*ProductRecord, error) {
+	fLog := inMemoryLog.WithField("func", "AdjustStock")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	product, ok := db.products[rec.ProductID]
+	if !ok {
+		fLog.Errorf("product %d got %s", rec.ProductID, ErrProductNotFound.Error())
+		return nil, ErrProductNotFound
+	}
+
+	m := *rec
+	if m.WarehouseID == 0 {
+		m.WarehouseID = DefaultWarehouseID
+	}
+	// emulate fk_warehouse_stock_warehouses1
+	if _, ok := db.warehouses[m.WarehouseID]; !ok {
+		fLog.Errorf("warehouse %d got %s", m.WarehouseID, ErrWarehouseNotFound.Error())
+		return nil, ErrWarehouseNotFound
+	}
+
+	key := warehouseStockKey{WarehouseID: m.WarehouseID, stockKey: stockKey{ProductID: m.ProductID}}
+	if product.Qty+m.Delta < 0 || db.warehouseStock[key]+m.Delta < 0 {
+		fLog.Errorf("product %d got %s", rec.ProductID, ErrInsufficientStock.Error())
+		return nil, ErrInsufficientStock
+	}
+
+	product.Qty += m.Delta
+	db.moveStock(&m)
+
+	p := *product
+	return &p, nil
+}
+
+// GetStockMovements retrieves the stock movements of a product, oldest first.
+func (db *InMemoryDB) GetStockMovements(ctx context.Context, productID int, page *pagination.Page) ([]*StockMovementRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	movements := make([]*StockMovementRecord, 0, len(db.stockMovements[productID]))
+	for _, record := range db.stockMovements[productID] {
+		m := *record
+		movements = append(movements, &m)
+	}
+
+	start, end := page.Bounds(len(movements), func(i int, c *pagination.Cursor) int { return compareID(movements[i].ID, c) })
+	return movements[start:end], len(movements), nil
+}
+
+// CreateVariant insert an entity record of variant into database and returns the persisted record.
+// The qty of the variant is recorded as its initial stock movement.
+// ErrProductNotFound is returned when its product does not exist, ErrDuplicateSKU when the sku is already used.
+func (db *InMemoryDB) CreateVariant(ctx context.Context, rec *ProductVariantRecord) (*ProductVariantRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateVariant")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// emulate fk_product_variants_products1
+	if _, ok := db.products[rec.ProductID]; !ok {
+		fLog.Errorf("product %d got %s", rec.ProductID, ErrProductNotFound.Error())
+		return nil, ErrProductNotFound
+	}
+
+	if db.skuTaken(rec.SKU, 0) {
+		fLog.Errorf("sku %s got %s", rec.SKU, ErrDuplicateSKU.Error())
+		return nil, ErrDuplicateSKU
+	}
+
+	db.lastVariantID++
+	variant := copyVariant(rec)
+	variant.ID = db.lastVariantID
+	variant.Available = 0
+	db.variants[variant.ID] = variant
+	if rec.Qty != 0 {
+		db.moveStock(&StockMovementRecord{ProductID: rec.ProductID, VariantID: variant.ID, Delta: rec.Qty, Reason: StockReasonInitial, Actor: systemActor, CreatedAt: time.Now()})
+	}
+
+	v := copyVariant(variant)
+	v.Available = v.Qty
+	return v, nil
+}
+
+// GetVariantByID retrieves an ProductVariantRecord from database where the variant id is specified.
+func (db *InMemoryDB) GetVariantByID(ctx context.Context, variantID int) (*ProductVariantRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetVariantByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	variant, ok := db.variants[variantID]
+	if !ok {
+		fLog.Errorf("variant %d got %s", variantID, ErrVariantNotFound.Error())
+		return nil, ErrVariantNotFound
+	}
+
+	v := copyVariant(variant)
+	v.Available = availableQty(v.Qty, db.reservedQty(stockKey{ProductID: v.ProductID, VariantID: v.ID}, 0, time.Now()))
+	return v, nil
+}
+
+// GetVariantsByProductID retrieves the variants of a product ordered by variant id.
+func (db *InMemoryDB) GetVariantsByProductID(ctx context.Context, productID int, page *pagination.Page) ([]*ProductVariantRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	variants := db.productVariants(productID, time.Now())
+	start, end := page.Bounds(len(variants), func(i int, c *pagination.Cursor) int { return compareID(variants[i].ID, c) })
+	return variants[start:end], len(variants), nil
+}
+
+// productVariants copies of the variants of a product ordered by variant id, with the qty available at now.
+// The caller must hold the lock.
+func (db *InMemoryDB) productVariants(productID int, now time.Time) []*ProductVariantRecord {
+	variants := make([]*ProductVariantRecord, 0)
+	for _, variant := range db.variants {
+		if variant.ProductID == productID {
+			v := copyVariant(variant)
+			v.Available = availableQty(v.Qty, db.reservedQty(stockKey{ProductID: productID, VariantID: v.ID}, 0, now))
+			variants = append(variants, v)
+		}
+	}
+
+	// keep the primary key order mysql would return
+	sort.Slice(variants, func(i, j int) bool {
+		return variants[i].ID < variants[j].ID
+	})
+	return variants
+}
+
+// UpdateVariant update an entity record of variant in database where the variant id is specified, its product does not change.
+// A change of qty is recorded as an adjustment stock movement made by actor,
+// ErrActorRequired is returned when the qty changes without one.
+// ErrDuplicateSKU is returned when the sku is already used by another variant.
+func (db *InMemoryDB) UpdateVariant(ctx context.Context, rec *ProductVariantRecord, actor string) (string, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateVariant")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	variant, ok := db.variants[rec.ID]
+	if !ok {
+		fLog.Errorf("variant %d got %s", rec.ID, ErrVariantNotFound.Error())
+		return "", ErrVariantNotFound
+	}
+	if rec.Qty != variant.Qty && actor == "" {
+		fLog.Errorf("variant %d got %s", rec.ID, ErrActorRequired.Error())
+		return "", ErrActorRequired
+	}
+
+	if db.skuTaken(rec.SKU, rec.ID) {
+		fLog.Errorf("sku %s got %s", rec.SKU, ErrDuplicateSKU.Error())
+		return "", ErrDuplicateSKU
+	}
+
+	// a change of qty is made in the default warehouse
+	key := warehouseStockKey{WarehouseID: DefaultWarehouseID, stockKey: stockKey{ProductID: variant.ProductID, VariantID: variant.ID}}
+	if db.warehouseStock[key]+rec.Qty-variant.Qty < 0 {
+		fLog.Errorf("variant %d got %s", rec.ID, ErrInsufficientStock.Error())
+		return "", ErrInsufficientStock
+	}
+
+	if rec.Qty != variant.Qty {
+		db.moveStock(&StockMovementRecord{ProductID: variant.ProductID, VariantID: variant.ID, Delta: rec.Qty - variant.Qty, Reason: StockReasonAdjustment, Actor: actor, CreatedAt: time.Now()})
+	}
+
+	updated := copyVariant(rec)
+	variant.SKU = updated.SKU
+	variant.Options = updated.Options
+	variant.Price = updated.Price
+	variant.Qty = updated.Qty
+
+	return "variant updated successfully", nil
+}
+
+// DeleteVariant delete an entity record of variant from database where the variant id is specified.
+// ErrVariantHasOrders is returned when transaction details still reference the variant.
+func (db *InMemoryDB) DeleteVariant(ctx context.Context, variantID int) (string, error) {
+	fLog := inMemoryLog.WithField("func", "DeleteVariant")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.variants[variantID]; !ok {
+		fLog.Errorf("variant %d got %s", variantID, ErrVariantNotFound.Error())
+		return "", ErrVariantNotFound
+	}
+
+	// emulate fk_transaction_detail_product_variants1
+	for _, details := range db.transactionDetail {
+		for _, detail := range details {
+			if detail.VariantID == variantID {
+				fLog.Errorf("variant %d got %s", variantID, ErrVariantHasOrders.Error())
+				return "", ErrVariantHasOrders
+			}
+		}
+	}
+
+	delete(db.variants, variantID)
+	// emulate the on delete cascade of fk_warehouse_stock_product_variants1
+	for key := range db.warehouseStock {
+		if key.VariantID == variantID {
+			delete(db.warehouseStock, key)
+		}
+	}
+
+	return "variant deleted successfully", nil
+}
+
+// skuTaken reports whether a variant other than exceptVariantID has the sku, the caller must hold the lock
+func (db *InMemoryDB) skuTaken(sku string, exceptVariantID int) bool {
+	for _, variant := range db.variants {
+		if variant.ID != exceptVariantID && variant.SKU == sku {
+			return true
+		}
+	}
+	return false
+}
+
+// stockQty the qty on hand of the variant of key, or of its product when it has no variant. The caller must hold the lock.
+func (db *InMemoryDB) stockQty(key stockKey) int {
+	if key.VariantID != 0 {
+		if v, ok := db.variants[key.VariantID]; ok {
+			return v.Qty
+		}
+		return 0
+	}
+	if p, ok := db.products[key.ProductID]; ok {
+		return p.Qty
+	}
+	return 0
+}
+
+// addStockQty adds delta to the qty of the variant of key, or of its product when it has no variant.
+// The caller must hold the write lock.
+func (db *InMemoryDB) addStockQty(key stockKey, delta int) {
+	if key.VariantID != 0 {
+		if v, ok := db.variants[key.VariantID]; ok {
+			v.Qty += delta
+		}
+		return
+	}
+	if p, ok := db.products[key.ProductID]; ok {
+		p.Qty += delta
+	}
+}
+
+// CreateWarehouse insert an entity record of warehouse into database and returns the persisted record.
+// ErrDuplicateWarehouseCode is returned when the code is already used.
+func (db *InMemoryDB) CreateWarehouse(ctx context.Context, rec *WarehouseRecord) (*WarehouseRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateWarehouse")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.warehouseCodeTaken(rec.Code, 0) {
+		fLog.Errorf("code %s got %s", rec.Code, ErrDuplicateWarehouseCode.Error())
+		return nil, ErrDuplicateWarehouseCode
+	}
+
+	db.lastWarehouseID++
+	warehouse := *rec
+	warehouse.ID = db.lastWarehouseID
+	db.warehouses[warehouse.ID] = &warehouse
+
+	w := warehouse
+	return &w, nil
+}
+
+// GetWarehouseByID retrieves an WarehouseRecord from database where the warehouse id is specified.
+func (db *InMemoryDB) GetWarehouseByID(ctx context.Context, warehouseID int) (*WarehouseRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetWarehouseByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	warehouse, ok := db.warehouses[warehouseID]
+	if !ok {
+		fLog.Errorf("warehouse %d got %s", warehouseID, ErrWarehouseNotFound.Error())
+		return nil, ErrWarehouseNotFound
+	}
+
+	w := *warehouse
+	return &w, nil
+}
+
+// GetWarehouses retrieves every WarehouseRecord from database ordered by warehouse id.
+func (db *InMemoryDB) GetWarehouses(ctx context.Context, page *pagination.Page) ([]*WarehouseRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	warehouses := db.sortedWarehouses()
+	start, end := page.Bounds(len(warehouses), func(i int, c *pagination.Cursor) int { return compareID(warehouses[i].ID, c) })
+	return warehouses[start:end], len(warehouses), nil
+}
+
+// sortedWarehouses copies of every warehouse ordered by id, the caller must hold the lock
+func (db *InMemoryDB) sortedWarehouses() []*WarehouseRecord {
+	warehouses := make([]*WarehouseRecord, 0, len(db.warehouses))
+	for _, warehouse := range db.warehouses {
+		w := *warehouse
+		warehouses = append(warehouses, &w)
+	}
+
+	// keep the primary key order mysql would return
+	sort.Slice(warehouses, func(i, j int) bool {
+		return warehouses[i].ID < warehouses[j].ID
+	})
+	return warehouses
+}
+
+// UpdateWarehouse update an entity record of warehouse in database where the warehouse id is specified.
+// ErrDuplicateWarehouseCode is returned when the code is already used by another warehouse.
+func (db *InMemoryDB) UpdateWarehouse(ctx context.Context, rec *WarehouseRecord) (string, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateWarehouse")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	warehouse, ok := db.warehouses[rec.ID]
+	if !ok {
+		fLog.Errorf("warehouse %d got %s", rec.ID, ErrWarehouseNotFound.Error())
+		return "", ErrWarehouseNotFound
+	}
+
+	if db.warehouseCodeTaken(rec.Code, rec.ID) {
+		fLog.Errorf("code %s got %s", rec.Code, ErrDuplicateWarehouseCode.Error())
+		return "", ErrDuplicateWarehouseCode
+	}
+
+	warehouse.Code = rec.Code
+	warehouse.Name = rec.Name
+	warehouse.City = rec.City
+	warehouse.Country = rec.Country
+
+	return "warehouse updated successfully", nil
+}
+
+// warehouseCodeTaken reports whether a warehouse other than exceptWarehouseID has the code, the caller must hold the lock
+func (db *InMemoryDB) warehouseCodeTaken(code string, exceptWarehouseID int) bool {
+	for _, warehouse := range db.warehouses {
+		if warehouse.ID != exceptWarehouseID && warehouse.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// GetWarehouseStock retrieves the stock kept in a warehouse ordered by product id then variant id, the product itself first.
+func (db *InMemoryDB) GetWarehouseStock(ctx context.Context, warehouseID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stockList := db.stockList(func(key warehouseStockKey) bool { return key.WarehouseID == warehouseID })
+	sort.Slice(stockList, func(i, j int) bool {
+		if stockList[i].ProductID != stockList[j].ProductID {
+			return stockList[i].ProductID < stockList[j].ProductID
+		}
+		return stockList[i].VariantID < stockList[j].VariantID
+	})
+	return pageStockList(stockList, page, func(stock *WarehouseStockRecord) int { return stock.ProductID })
+}
+
+// GetProductWarehouseStock retrieves the stock of a product and of its variants in every warehouse,
+// ordered by warehouse id then variant id, the product itself first.
+func (db *InMemoryDB) GetProductWarehouseStock(ctx context.Context, productID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	stockList := db.stockList(func(key warehouseStockKey) bool { return key.ProductID == productID })
+	sort.Slice(stockList, func(i, j int) bool {
+		if stockList[i].WarehouseID != stockList[j].WarehouseID {
+			return stockList[i].WarehouseID < stockList[j].WarehouseID
+		}
+		return stockList[i].VariantID < stockList[j].VariantID
+	})
+	return pageStockList(stockList, page, func(stock *WarehouseStockRecord) int { return stock.WarehouseID })
+}
+
+// pageStockList the rows of page out of stockList sorted by the column of sortedBy then by variant id,
+// the cursor of a row is its value of the column and its variant id
+func pageStockList(stockList []*WarehouseStockRecord, page *pagination.Page, sortedBy func(stock *WarehouseStockRecord) int) ([]*WarehouseStockRecord, int, error) {
+	var cursorValue int64
+	if page.Keyset() {
+		cursor := page.After
+		if cursor == nil {
+			cursor = page.Before
+		}
+		value, err := strconv.ParseInt(cursor.Value, 10, 64)
+		if err != nil {
+			return nil, 0, NewBadRequestError(err)
+		}
+		cursorValue = value
+	}
+
+	start, end := page.Bounds(len(stockList), func(i int, c *pagination.Cursor) int {
+		if cmp := compareInts(int64(sortedBy(stockList[i])), cursorValue); cmp != 0 {
+			return cmp
+		}
+		return compareInts(int64(stockList[i].VariantID), int64(c.ID))
+	})
+	return stockList[start:end], len(stockList), nil
+}
+
+// stockList the warehouse stock rows with a qty whose key matches, the caller must hold the lock
+func (db *InMemoryDB) stockList(match func(key warehouseStockKey) bool) []*WarehouseStockRecord {
+	stockList := make([]*WarehouseStockRecord, 0)
+	for key, qty := range db.warehouseStock {
+		if qty > 0 && match(key) {
+			stockList = append(stockList, &WarehouseStockRecord{WarehouseID: key.WarehouseID, ProductID: key.ProductID, VariantID: key.VariantID, Qty: qty})
+		}
+	}
+	return stockList
+}
+
+// TransferStock moves qty of a product or variant from a warehouse to another, recording a transfer stock movement
+// out of the first and into the second in the same db transaction, and returns both movements.
+// The qty of the product or variant does not change. ErrWarehouseNotFound is returned when a warehouse does not exist,
+// ErrInsufficientStock when the source warehouse does not have the qty.
+func (db *InMemoryDB) TransferStock(ctx context.Context, rec *StockTransferRecord) ([]*StockMovementRecord, error) {
+	fLog := inMemoryLog.WithField("func", "TransferStock")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.products[rec.ProductID]; !ok {
+		fLog.Errorf("product %d got %s", rec.ProductID, ErrProductNotFound.Error())
+		return nil, ErrProductNotFound
+	}
+	if rec.VariantID != 0 {
+		if variant, ok := db.variants[rec.VariantID]; !ok || variant.ProductID != rec.ProductID {
+			fLog.Errorf("variant %d got %s", rec.VariantID, ErrVariantNotFound.Error())
+			return nil, ErrVariantNotFound
+		}
+	}
+
+	for _, warehouseID := range []int{rec.FromWarehouseID, rec.ToWarehouseID} {
+		if _, ok := db.warehouses[warehouseID]; !ok {
+			fLog.Errorf("warehouse %d got %s", warehouseID, ErrWarehouseNotFound.Error())
+			return nil, ErrWarehouseNotFound
+		}
+	}
+
+	key := stockKey{ProductID: rec.ProductID, VariantID: rec.VariantID}
+	if db.warehouseStock[warehouseStockKey{WarehouseID: rec.FromWarehouseID, stockKey: key}] < rec.Qty {
+		fLog.Errorf("warehouse %d product %d variant %d got %s", rec.FromWarehouseID, rec.ProductID, rec.VariantID, ErrInsufficientStock.Error())
+		return nil, ErrInsufficientStock
+	}
+
+	out := &StockMovementRecord{ProductID: rec.ProductID, VariantID: rec.VariantID, WarehouseID: rec.FromWarehouseID, Delta: -rec.Qty, Reason: StockReasonTransfer, Actor: rec.Actor, CreatedAt: rec.CreatedAt}
+	in := &StockMovementRecord{ProductID: rec.ProductID, VariantID: rec.VariantID, WarehouseID: rec.ToWarehouseID, Delta: rec.Qty, Reason: StockReasonTransfer, Actor: rec.Actor, CreatedAt: rec.CreatedAt}
+	// like mysql the movements are recorded in warehouse id order
+	if in.WarehouseID < out.WarehouseID {
+		db.moveStock(in)
+		db.moveStock(out)
+	} else {
+		db.moveStock(out)
+		db.moveStock(in)
+	}
+
+	o, i := *out, *in
+	return []*StockMovementRecord{&o, &i}, nil
+}
+
+// GetTransactionByTransactionID retrieves the detail of a transaction from database where the transaction id is specified,
+// with the discount lines and the warehouse allocations of every detail.
+func (db *InMemoryDB) GetTransactionByTransactionID(ctx context.Context, transactionID int) (*TransactionRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetTransactionByTransactionID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	trans, ok := db.transactions[transactionID]
+	if !ok {
+		fLog.Errorf("transaction %d got %s", transactionID, ErrTransactionNotFound.Error())
+		return nil, ErrTransactionNotFound
+	}
+
+	return db.copyTransaction(trans), nil
+}
+
+// copyTransaction returns a copy of the transaction with its detail, the caller must hold the lock
+func (db *InMemoryDB) copyTransaction(trans *TransactionRecord) *TransactionRecord {
+	transaction := *trans
+	transaction.ShippingAddress = copyPostalAddress(trans.ShippingAddress)
+	tDetail := make([]*TransactionDetailRecord, 0, len(db.transactionDetail[trans.ID]))
+	for _, detail := range db.transactionDetail[trans.ID] {
+		tDetail = append(tDetail, copyTransactionDetail(detail))
+	}
+	transaction.TransactionDetail = tDetail
+
+	return &transaction
+}
+
+// copyTransactionDetail returns a copy of the detail with its discount lines and warehouse allocations
+func copyTransactionDetail(detail *TransactionDetailRecord) *TransactionDetailRecord {
+	tD := *detail
+	if detail.Discounts != nil {
+		tD.Discounts = make([]*TransactionDiscountRecord, 0, len(detail.Discounts))
+		for _, discount := range detail.Discounts {
+			d := *discount
+			tD.Discounts = append(tD.Discounts, &d)
+		}
+	}
+	if detail.Allocations != nil {
+		tD.Allocations = make([]*AllocationRecord, 0, len(detail.Allocations))
+		for _, allocation := range detail.Allocations {
+			a := *allocation
+			tD.Allocations = append(tD.Allocations, &a)
+		}
+	}
+	return &tD
+}
+
+// CreateTransaction insert an entity record of transaction into database and returns the persisted record,
+// including the computed grand total and the sub total of every detail.
+// The coupon of rec.CouponCode, when set, is redeemed together with the transaction.
+// Every detail is validated before anything is written so a failure leaves the stock untouched, like a rolled back MySQL transaction.
+func (db *InMemoryDB) CreateTransaction(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.createTransaction(ctx, rec, nil)
+}
+
+// CreateTransactionIdempotent is CreateTransaction guarded by the idempotency key of idem.
+// The first request with the key creates the transaction and stores the key, its RequestHash and the response
+// built by respond in the same db transaction. A repeat of the request returns the stored record instead,
+// with replayed set, and ErrIdempotencyKeyReused is returned when the key comes with a different RequestHash.
+// An expired key is treated as a new one.
+func (db *InMemoryDB) CreateTransactionIdempotent(ctx context.Context, rec *TransactionRecord, idem *IdempotencyRecord, respond IdempotentResponse) (*IdempotencyRecord, bool, error) {
+	fLog := inMemoryLog.WithField("func", "CreateTransactionIdempotent")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if stored, ok := db.idempotencyKeys[idem.Key]; ok && stored.ExpiresAt.After(idem.CreatedAt) {
+		if stored.RequestHash != idem.RequestHash {
+			fLog.Errorf("key %s got %s", idem.Key, ErrIdempotencyKeyReused.Error())
+			return nil, false, ErrIdempotencyKeyReused
+		}
+		s := *stored
+		return &s, true, nil
+	}
+
+	// the response is built before anything is written, so the transaction is only kept together with its key
+	var stored *IdempotencyRecord
+	_, err := db.createTransaction(ctx, rec, func(transaction *TransactionRecord) error {
+		status, body, err := respond(transaction)
+		if err != nil {
+			fLog.Errorf("respond got %s", err.Error())
+			return err
+		}
+		stored = &IdempotencyRecord{
+			Key:            idem.Key,
+			RequestHash:    idem.RequestHash,
+			TransactionID:  transaction.ID,
+			ResponseStatus: status,
+			ResponseBody:   body,
+			CreatedAt:      idem.CreatedAt,
+			ExpiresAt:      idem.ExpiresAt,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	db.idempotencyKeys[idem.Key] = stored
+
+	s := *stored
+	return &s, false, nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes the idempotency keys that expired at or before now and returns how many were deleted.
+// An expired key is already treated as a new one, deleting it only frees its row.
+func (db *InMemoryDB) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	deleted := 0
+	for key, stored := range db.idempotencyKeys {
+		if !stored.ExpiresAt.After(now) {
+			delete(db.idempotencyKeys, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// createTransaction is CreateTransaction for a caller holding the write lock.
+// beforeCommit, when set, gets the transaction as it will be stored and aborts it without writing anything by returning an error.
+func (db *InMemoryDB) createTransaction(ctx context.Context, rec *TransactionRecord, beforeCommit func(*TransactionRecord) error) (*TransactionRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateTransaction")
+
+	// emulate fk_transaction_users1
+	if _, ok := db.users[rec.UserID]; !ok {
+		fLog.Errorf("user %d does not exist", rec.UserID)
+		return nil, fmt.Errorf("user %d does not exist", rec.UserID)
+	}
+
+	var coupon *CouponRecord
+	if rec.CouponCode != "" {
+		var err error
+		coupon, err = db.usableCoupon(rec.CouponCode, rec.UserID, rec.Date)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	products := make(map[int]*ProductRecord)
+	variants := make(map[int]*ProductVariantRecord)
+
+	//loop tx detail
+	for i := 0; i < len(rec.TransactionDetail); i++ {
+		detail := rec.TransactionDetail[i]
+
+		p, ok := db.products[detail.ProductID]
+		if !ok {
+			fLog.Errorf("product %d got %s", detail.ProductID, ErrProductNotFound.Error())
+			return nil, ErrProductNotFound
+		}
+		products[p.ID] = p
+
+		if detail.VariantID != 0 {
+			v, ok := db.variants[detail.VariantID]
+			if !ok || v.ProductID != p.ID {
+				fLog.Errorf("variant %d of product %d got %s", detail.VariantID, p.ID, ErrVariantNotFound.Error())
+				return nil, ErrVariantNotFound
+			}
+			variants[v.ID] = v
+		}
+	}
+
+	// total qty ordered per product or variant, the same one may appear in several details.
+	//check qty against what is not reserved by other pending orders
+	orderedQty, keys := orderedStock(rec.TransactionDetail)
+	for _, key := range keys {
+		if availableQty(db.stockQty(key), db.reservedQty(key, 0, rec.Date)) < orderedQty[key] {
+			fLog.Errorf("product %d variant %d got %s", key.ProductID, key.VariantID, ErrInsufficientStock.Error())
+			return nil, ErrInsufficientStock
+		}
+	}
+
+	// the warehouses the order ships from are picked now and its qty is reserved in them
+	req := db.allocationRequest(rec.ShippingAddress, rec.TransactionDetail, keys, 0, rec.Date)
+	allocations, err := db.allocationStrategy().Allocate(req)
+	if err != nil {
+		fLog.Errorf("strategy.Allocate got %s", err.Error())
+		return nil, err
+	}
+
+	price, err := priceOrder(rec.TransactionDetail, products, variants, coupon, db.taxCalc())
+	if err != nil {
+		fLog.Errorf("priceOrder got %s", err.Error())
+		return nil, err
+	}
+
+	err = shipOrder(ctx, price, rec.TransactionDetail, rec.ShippingAddress, db.shippingRateProvider())
+	if err != nil {
+		fLog.Errorf("shipOrder got %s", err.Error())
+		return nil, err
+	}
+
+	tID := db.lastTransactionID + 1
+	tDetail := make([]*TransactionDetailRecord, 0, len(rec.TransactionDetail))
+	for i, detail := range rec.TransactionDetail {
+		tD := &TransactionDetailRecord{
+			TransactionID: tID,
+			ProductID:     detail.ProductID,
+			VariantID:     detail.VariantID,
+			Qty:           detail.Qty,
+			SubTotal:      price.SubTotals[i],
+			Discount:      price.Discounts[i],
+			Tax:           price.Taxes[i],
+		}
+		if !price.Discounts[i].IsZero() {
+			tD.Discounts = []*TransactionDiscountRecord{{CouponID: coupon.ID, CouponCode: coupon.Code, Amount: price.Discounts[i]}}
+		}
+		tDetail = append(tDetail, tD)
+	}
+
+	couponCode := ""
+	if coupon != nil {
+		couponCode = coupon.Code
+	}
+	reservedUntil := reservationExpiry(rec.Date, db.reservationTTL)
+	transaction := &TransactionRecord{
+		ID:              tID,
+		UserID:          rec.UserID,
+		Date:            rec.Date,
+		GrandTotal:      price.GrandTotal,
+		Status:          TransactionStatusPending,
+		CouponCode:      couponCode,
+		Subtotal:        price.Subtotal,
+		Discount:        price.Discount,
+		Tax:             price.Tax,
+		TaxInclusive:    price.TaxInclusive,
+		ShippingAddress: copyPostalAddress(rec.ShippingAddress),
+		ShippingCost:    price.Shipping,
+	}
+
+	if beforeCommit != nil {
+		pending := *transaction
+		pending.ReservedUntil = reservedUntil
+		pending.TransactionDetail = make([]*TransactionDetailRecord, 0, len(tDetail))
+		for _, detail := range tDetail {
+			pending.TransactionDetail = append(pending.TransactionDetail, copyTransactionDetail(detail))
+		}
+		if err := beforeCommit(&pending); err != nil {
+			return nil, err
+		}
+	}
+	db.lastTransactionID = tID
+
+	// commit transaction, reserving in the order of the mysql row locks
+	reservedQty, warehouseKeys := allocatedStock(req.Lines, allocations)
+	for _, key := range warehouseKeys {
+		db.reservations[tID] = append(db.reservations[tID], &stockReservation{ProductID: key.ProductID, VariantID: key.VariantID, WarehouseID: key.WarehouseID, Qty: reservedQty[key], ExpiresAt: reservedUntil})
+	}
+
+	db.transactions[tID] = transaction
+	db.transactionDetail[tID] = tDetail
+	if coupon != nil {
+		db.couponRedemptions[coupon.ID] = append(db.couponRedemptions[coupon.ID], &couponRedemption{UserID: rec.UserID, TransactionID: tID})
+	}
+	db.appendStatusHistory(tID, "", TransactionStatusPending, userActor(rec.UserID), rec.Date)
+
+	// like mysql the reservation end is only returned by the order creation, it is not stored with the transaction
+	created := db.copyTransaction(db.transactions[tID])
+	created.ReservedUntil = reservedUntil
+	return created, nil
+}
+
+// UpdateTransactionStatus moves a transaction to status and records the change in its status history.
+// Moving a pending order releases its reservation, and paying it takes the qty of every detail from the products
+// in the same db transaction; ErrInsufficientStock is returned when its reservation expired and the qty was ordered by others.
+// Moving a paid order to cancelled or refunded restores the qty of every detail to the products.
+// The qty of a paid detail is taken from the warehouses CreateTransaction reserved it in, they are recorded
+// in its Allocations and get the qty back when it is restored. The allocation strategy of db picks them again
+// when the reservation expired or the warehouses no longer hold its qty.
+// ErrInvalidStatusTransition is returned when the current status does not allow the move.
+func (db *InMemoryDB) UpdateTransactionStatus(ctx context.Context, transactionID int, status string, actor string) (*TransactionRecord, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateTransactionStatus")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	trans, ok := db.transactions[transactionID]
+	if !ok {
+		fLog.Errorf("transaction %d got %s", transactionID, ErrTransactionNotFound.Error())
+		return nil, ErrTransactionNotFound
+	}
+
+	if !CanTransitionTransactionStatus(trans.Status, status) {
+		fLog.Errorf("transaction %d from %s to %s got %s", transactionID, trans.Status, status, ErrInvalidStatusTransition.Error())
+		return nil, ErrInvalidStatusTransition
+	}
+
+	err := db.moveTransactionStatus(trans, status, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.copyTransaction(trans), nil
+}
+
+// moveTransactionStatus moves trans to status, releasing, taking or restoring its stock and recording the change
+// in its status history. Nothing changes when an error is returned. The caller checks the move is allowed
+// and must hold the write lock.
+func (db *InMemoryDB) moveTransactionStatus(trans *TransactionRecord, status string, actor string) error {
+	fLog := inMemoryLog.WithField("func", "moveTransactionStatus")
+
+	now := time.Now()
+	switch {
+	case takesStock(trans.Status, status):
+		// every product and variant is checked and allocated before any is taken, so a failure leaves the stock untouched
+		details := db.transactionDetail[trans.ID]
+		orderedQty, keys := orderedStock(details)
+		for _, key := range keys {
+			if availableQty(db.stockQty(key), db.reservedQty(key, trans.ID, now)) < orderedQty[key] {
+				fLog.Errorf("product %d variant %d got %s", key.ProductID, key.VariantID, ErrInsufficientStock.Error())
+				return ErrInsufficientStock
+			}
+		}
+		// the qty is taken from the warehouses the order reserved it in, they are picked again when they no longer hold it
+		req := db.allocationRequest(trans.ShippingAddress, details, keys, trans.ID, now)
+		allocations, ok := reservedAllocations(req, db.transactionReservations(trans.ID, now))
+		if !ok {
+			var err error
+			allocations, err = db.allocationStrategy().Allocate(req)
+			if err != nil {
+				fLog.Errorf("strategy.Allocate got %s", err.Error())
+				return err
+			}
+		}
+
+		for _, key := range keys {
+			db.addStockQty(key, -orderedQty[key])
+		}
+		for i, detail := range details {
+			detail.Allocations = allocations[i]
+		}
+		allocatedQty, warehouseKeys := allocatedStock(req.Lines, allocations)
+		for _, key := range warehouseKeys {
+			db.moveStock(&StockMovementRecord{ProductID: key.ProductID, VariantID: key.VariantID, WarehouseID: key.WarehouseID, Delta: -allocatedQty[key], Reason: StockReasonSale, TransactionID: trans.ID, Actor: actor, CreatedAt: now})
+		}
+	case restoresStock(trans.Status, status):
+		// a detail paid before the warehouses has no allocation, its qty goes to the default warehouse
+		details := db.transactionDetail[trans.ID]
+		orderedQty, keys := orderedStock(details)
+		lines := make([]AllocationLine, 0, len(details))
+		allocations := make([][]*AllocationRecord, 0, len(details))
+		for _, detail := range details {
+			lines = append(lines, AllocationLine{ProductID: detail.ProductID, VariantID: detail.VariantID, Qty: detail.Qty})
+			lineAllocations := append([]*AllocationRecord{}, detail.Allocations...)
+			unallocated := detail.Qty
+			for _, allocation := range detail.Allocations {
+				unallocated -= allocation.Qty
+			}
+			if unallocated > 0 {
+				lineAllocations = append(lineAllocations, &AllocationRecord{WarehouseID: DefaultWarehouseID, Qty: unallocated})
+			}
+			allocations = append(allocations, lineAllocations)
+		}
+
+		for _, key := range keys {
+			db.addStockQty(key, orderedQty[key])
+		}
+		restoredQty, warehouseKeys := allocatedStock(lines, allocations)
+		for _, key := range warehouseKeys {
+			db.moveStock(&StockMovementRecord{ProductID: key.ProductID, VariantID: key.VariantID, WarehouseID: key.WarehouseID, Delta: restoredQty[key], Reason: restoreStockReason(status), TransactionID: trans.ID, Actor: actor, CreatedAt: now})
+		}
+	}
+	if trans.Status == TransactionStatusPending {
+		delete(db.reservations, trans.ID)
+	}
+
+	from := trans.Status
+	trans.Status = status
+	db.appendStatusHistory(trans.ID, from, status, actor, time.Now())
+
+	return nil
+}
+
+// GetTransactionStatusHistory retrieves the status changes of a transaction, oldest first.
+func (db *InMemoryDB) GetTransactionStatusHistory(ctx context.Context, transactionID int, page *pagination.Page) ([]*TransactionStatusHistoryRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	history := make([]*TransactionStatusHistoryRecord, 0, len(db.statusHistory[transactionID]))
+	for _, record := range db.statusHistory[transactionID] {
+		h := *record
+		history = append(history, &h)
+	}
+
+	start, end := page.Bounds(len(history), func(i int, c *pagination.Cursor) int { return compareID(history[i].ID, c) })
+	return history[start:end], len(history), nil
+}
+
+// GetTransactionsByUserID retrieves the transactions of a user with their detail, newest first.
+// Orders with the same date are ordered by descending id so the (date, id) cursor is stable.
+func (db *InMemoryDB) GetTransactionsByUserID(ctx context.Context, filter *TransactionFilter) ([]*TransactionRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	transactionList := make([]*TransactionRecord, 0)
+	for _, trans := range db.transactions {
+		if trans.UserID != filter.UserID {
+			continue
+		}
+		if !filter.From.IsZero() && trans.Date.Before(filter.From) {
+			continue
+		}
+		if !filter.To.IsZero() && !trans.Date.Before(filter.To) {
+			continue
+		}
+		transactionList = append(transactionList, trans)
+	}
+
+	sort.Slice(transactionList, func(i, j int) bool {
+		return compareTransactions(transactionList[i], transactionList[j].Date, transactionList[j].ID) < 0
+	})
+
+	var cursorDate time.Time
+	if filter.Page.Keyset() {
+		cursor := filter.Page.After
+		if cursor == nil {
+			cursor = filter.Page.Before
+		}
+		date, err := transactionCursorDate(cursor.Value)
+		if err != nil {
+			return nil, 0, NewBadRequestError(err)
+		}
+		cursorDate = date.(time.Time)
+	}
+
+	start, end := filter.Page.Bounds(len(transactionList), func(i int, c *pagination.Cursor) int {
+		return compareTransactions(transactionList[i], cursorDate, c.ID)
+	})
+	transactions := make([]*TransactionRecord, 0, end-start)
+	for _, trans := range transactionList[start:end] {
+		transactions = append(transactions, db.copyTransaction(trans))
+	}
+
+	return transactions, len(transactionList), nil
+}
+
+// compareTransactions -1, 0 or 1 as the transaction comes before, at or after the one of date and id in a newest-first listing
+func compareTransactions(trans *TransactionRecord, date time.Time, id int) int {
+	if !trans.Date.Equal(date) {
+		if trans.Date.After(date) {
+			return -1
+		}
+		return 1
+	}
+	return compareInts(int64(id), int64(trans.ID))
+}
+
+// usableCoupon finds the coupon of code and checks the user can use it at now, the caller must hold the lock
+func (db *InMemoryDB) usableCoupon(code string, userID int, now time.Time) (*CouponRecord, error) {
+	fLog := inMemoryLog.WithField("func", "usableCoupon")
+
+	code = normalizeCouponCode(code)
+	for _, coupon := range db.coupons {
+		if coupon.Code != code {
+			continue
+		}
+
+		userUses := 0
+		for _, redemption := range db.couponRedemptions[coupon.ID] {
+			if redemption.UserID == userID {
+				userUses++
+			}
+		}
+		err := checkCouponUsable(coupon, now, len(db.couponRedemptions[coupon.ID]), userUses)
+		if err != nil {
+			fLog.Errorf("coupon %s got %s", coupon.Code, err.Error())
+			return nil, err
+		}
+		return coupon, nil
+	}
+
+	fLog.Errorf("coupon %s got %s", code, ErrCouponNotFound.Error())
+	return nil, ErrCouponNotFound
+}
+
+// allocationRequest what the allocation strategy needs to allocate details shipped to address: the stock of the products
+// and variants of keys in every warehouse less the qty reserved there by the reservations active at now, leaving out
+// the ones of exceptTransactionID, and the warehouses. The caller must hold the lock.
+func (db *InMemoryDB) allocationRequest(address *PostalAddress, details []*TransactionDetailRecord, keys []stockKey, exceptTransactionID int, now time.Time) *AllocationRequest {
+	req := &AllocationRequest{
+		Lines:      make([]AllocationLine, 0, len(details)),
+		Stock:      make([]*WarehouseStockRecord, 0),
+		Warehouses: db.sortedWarehouses(),
+		Address:    copyPostalAddress(address),
+	}
+	for _, detail := range details {
+		req.Lines = append(req.Lines, AllocationLine{ProductID: detail.ProductID, VariantID: detail.VariantID, Qty: detail.Qty})
+	}
+
+	ordered := make(map[stockKey]bool, len(keys))
+	for _, key := range keys {
+		ordered[key] = true
+	}
+	for key, qty := range db.warehouseStock {
+		if ordered[key.stockKey] {
+			req.Stock = append(req.Stock, &WarehouseStockRecord{WarehouseID: key.WarehouseID, ProductID: key.ProductID, VariantID: key.VariantID, Qty: qty})
+		}
+	}
+
+	reserved := make(map[warehouseStockKey]int)
+	for transactionID := range db.reservations {
+		if transactionID == exceptTransactionID {
+			continue
+		}
+		for key, qty := range db.transactionReservations(transactionID, now) {
+			reserved[key] += qty
+		}
+	}
+	req.Stock = reservableStock(req.Stock, reserved)
+
+	return req
+}
+
+// transactionReservations the qty a transaction reserved in every warehouse by its reservations active at now,
+// none once they expired. The caller must hold the lock.
+func (db *InMemoryDB) transactionReservations(transactionID int, now time.Time) map[warehouseStockKey]int {
+	reserved := make(map[warehouseStockKey]int)
+	for _, r := range db.reservations[transactionID] {
+		if r.ExpiresAt.After(now) {
+			reserved[warehouseStockKey{WarehouseID: r.WarehouseID, stockKey: stockKey{ProductID: r.ProductID, VariantID: r.VariantID}}] += r.Qty
+		}
+	}
+	return reserved
+}
+
+// moveStock adds the delta of rec to the stock of its warehouse, DefaultWarehouseID when it has none, and appends rec
+// to the stock movements. The caller checks the warehouse holds a negative delta and must hold the write lock.
+func (db *InMemoryDB) moveStock(rec *StockMovementRecord) {
+	if rec.WarehouseID == 0 {
+		rec.WarehouseID = DefaultWarehouseID
+	}
+	db.warehouseStock[warehouseStockKey{WarehouseID: rec.WarehouseID, stockKey: stockKey{ProductID: rec.ProductID, VariantID: rec.VariantID}}] += rec.Delta
+	db.appendStockMovement(rec)
+}
+
+// appendStockMovement records a change of a product qty and sets the id of rec, the caller must hold the write lock
+func (db *InMemoryDB) appendStockMovement(rec *StockMovementRecord) {
+	db.lastStockMovementID++
+	rec.ID = db.lastStockMovementID
+	db.stockMovements[rec.ProductID] = append(db.stockMovements[rec.ProductID], rec)
+}
+
+// reservedQty sums the qty reserved from the variant of key, or from its product itself when it has no variant, by the reservations
+// active at now, leaving out the ones of exceptTransactionID. The caller must hold the lock.
+func (db *InMemoryDB) reservedQty(key stockKey, exceptTransactionID int, now time.Time) int {
+	reserved := 0
+	for transactionID, reservations := range db.reservations {
+		if transactionID == exceptTransactionID {
+			continue
+		}
+		for _, r := range reservations {
+			if r.ProductID == key.ProductID && r.VariantID == key.VariantID && r.ExpiresAt.After(now) {
+				reserved += r.Qty
+			}
+		}
+	}
+	return reserved
+}
+
+// ReleaseExpiredReservations deletes the stock reservations that expired at or before now and returns how many were deleted.
+// An expired reservation already stopped holding stock, releasing it only frees its rows.
+func (db *InMemoryDB) ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	released := 0
+	for transactionID, reservations := range db.reservations {
+		active := reservations[:0]
+		for _, r := range reservations {
+			if r.ExpiresAt.After(now) {
+				active = append(active, r)
+			} else {
+				released++
+			}
+		}
+		if len(active) == 0 {
+			delete(db.reservations, transactionID)
+		} else {
+			db.reservations[transactionID] = active
+		}
+	}
+
+	return released, nil
+}
+
+// CancelExpiredTransactions cancels, as the system, the pending transactions whose reservation expired at or before now
+// and returns how many were cancelled. A transaction with a pending or authorized payment is left to its payment.
+func (db *InMemoryDB) CancelExpiredTransactions(ctx context.Context, now time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ids := make([]int, 0)
+	for id, trans := range db.transactions {
+		if trans.Status == TransactionStatusPending && len(db.transactionReservations(id, now)) == 0 && !db.hasActivePayment(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	for i, id := range ids {
+		err := db.moveTransactionStatus(db.transactions[id], TransactionStatusCancelled, systemActor)
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(ids), nil
+}
+
+// hasActivePayment reports whether the transaction of transactionID has a pending, authorized or captured payment,
+// the caller must hold the lock
+func (db *InMemoryDB) hasActivePayment(transactionID int) bool {
+	for _, payment := range db.payments {
+		if payment.TransactionID == transactionID && isActivePaymentStatus(payment.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreatePayment insert an entity record of payment into database and returns the persisted record.
+// A payment is created pending and without a reference before the gateway is asked, see UpdatePaymentAuthorization.
+// The transaction gets a single active payment: ErrInvalidStatusTransition is returned when it can not be paid,
+// ErrPaymentInProgress when it already has a pending, authorized or captured payment and ErrReservationExpired
+// when the reservation of the pending transaction expired at the CreatedAt of rec.
+// Its status does not move the transaction, only UpdatePaymentStatus does.
+// ErrTransactionNotFound is returned when the transaction does not exist.
+func (db *InMemoryDB) CreatePayment(ctx context.Context, rec *PaymentRecord) (*PaymentRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreatePayment")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	trans, ok := db.transactions[rec.TransactionID]
+	if !ok {
+		fLog.Errorf("transaction %d got %s", rec.TransactionID, ErrTransactionNotFound.Error())
+		return nil, ErrTransactionNotFound
+	}
+	if !CanTransitionTransactionStatus(trans.Status, TransactionStatusPaid) {
+		fLog.Errorf("transaction %d in %s got %s", trans.ID, trans.Status, ErrInvalidStatusTransition.Error())
+		return nil, ErrInvalidStatusTransition
+	}
+	if db.hasActivePayment(trans.ID) {
+		fLog.Errorf("transaction %d got %s", trans.ID, ErrPaymentInProgress.Error())
+		return nil, ErrPaymentInProgress
+	}
+	if len(db.transactionReservations(trans.ID, rec.CreatedAt)) == 0 {
+		fLog.Errorf("transaction %d got %s", trans.ID, ErrReservationExpired.Error())
+		return nil, ErrReservationExpired
+	}
+
+	db.lastPaymentID++
+	payment := *rec
+	payment.ID = db.lastPaymentID
+	payment.UpdatedAt = payment.CreatedAt
+	db.payments[payment.ID] = &payment
+
+	created := payment
+	return &created, nil
+}
+
+// UpdatePaymentAuthorization records the reference and the status the gateway answered the authorization of a
+// pending payment with. Neither moves the transaction.
+// ErrInvalidPaymentTransition is returned when the payment already got its answer.
+func (db *InMemoryDB) UpdatePaymentAuthorization(ctx context.Context, paymentID int, answer *GatewayPayment) (*PaymentRecord, error) {
+	fLog := inMemoryLog.WithField("func", "UpdatePaymentAuthorization")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	payment, ok := db.payments[paymentID]
+	if !ok {
+		fLog.Errorf("payment %d got %s", paymentID, ErrPaymentNotFound.Error())
+		return nil, ErrPaymentNotFound
+	}
+	if !canAnswerPayment(payment, answer) {
+		fLog.Errorf("payment %d in %s answered %s got %s", paymentID, payment.Status, answer.Status, ErrInvalidPaymentTransition.Error())
+		return nil, ErrInvalidPaymentTransition
+	}
+
+	payment.Reference = answer.Reference
+	payment.Status = answer.Status
+	payment.UpdatedAt = time.Now()
+
+	p := *payment
+	return &p, nil
+}
+
+// GetPaymentByReference retrieves the PaymentRecord of a gateway with the gateway reference specified.
+func (db *InMemoryDB) GetPaymentByReference(ctx context.Context, gateway string, reference string) (*PaymentRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetPaymentByReference")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, payment := range db.payments {
+		// a payment the gateway did not answer yet has no reference to match, like its NULL reference in mysql
+		if payment.Reference != "" && payment.Gateway == gateway && payment.Reference == reference {
+			p := *payment
+			return &p, nil
+		}
+	}
+
+	fLog.Errorf("payment %s of %s got %s", reference, gateway, ErrPaymentNotFound.Error())
+	return nil, ErrPaymentNotFound
+}
+
+// GetPaymentsByTransactionID retrieves the payments of a transaction, oldest first.
+func (db *InMemoryDB) GetPaymentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*PaymentRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	payments := make([]*PaymentRecord, 0)
+	for _, payment := range db.payments {
+		if payment.TransactionID == transactionID {
+			p := *payment
+			payments = append(payments, &p)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool {
+		return payments[i].ID < payments[j].ID
+	})
+
+	start, end := page.Bounds(len(payments), func(i int, c *pagination.Cursor) int { return compareID(payments[i].ID, c) })
+	return payments[start:end], len(payments), nil
+}
+
+// UpdatePaymentStatus moves a payment to status and its transaction along with it in the same db transaction:
+// a captured payment moves the transaction to paid like UpdateTransactionStatus does, and fails with its error when the
+// transaction can not be paid; a refunded payment moves the transaction to refunded when its status allows it.
+// Moving a payment to the status it already has changes nothing, so a repeated webhook is harmless.
+// ErrInvalidPaymentTransition is returned when the current status of the payment does not allow the move.
+func (db *InMemoryDB) UpdatePaymentStatus(ctx context.Context, paymentID int, status string, actor string) (*PaymentRecord, error) {
+	fLog := inMemoryLog.WithField("func", "UpdatePaymentStatus")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	payment, ok := db.payments[paymentID]
+	if !ok {
+		fLog.Errorf("payment %d got %s", paymentID, ErrPaymentNotFound.Error())
+		return nil, ErrPaymentNotFound
+	}
+
+	if payment.Status != status {
+		if !CanTransitionPaymentStatus(payment.Status, status) {
+			fLog.Errorf("payment %d from %s to %s got %s", paymentID, payment.Status, status, ErrInvalidPaymentTransition.Error())
+			return nil, ErrInvalidPaymentTransition
+		}
+
+		if orderStatus := paymentTransactionStatus(status); orderStatus != "" {
+			trans := db.transactions[payment.TransactionID]
+			switch {
+			case CanTransitionTransactionStatus(trans.Status, orderStatus):
+				err := db.moveTransactionStatus(trans, orderStatus, actor)
+				if err != nil {
+					return nil, err
+				}
+			case status == PaymentStatusCaptured:
+				fLog.Errorf("transaction %d from %s to %s got %s", trans.ID, trans.Status, orderStatus, ErrInvalidStatusTransition.Error())
+				return nil, ErrInvalidStatusTransition
+			}
+		}
+
+		payment.Status = status
+		payment.UpdatedAt = time.Now()
+	}
+
+	p := *payment
+	return &p, nil
+}
+
+// CreateAddress insert an entity record of address into database and returns the persisted record.
+// The first address of a user is its default, and a default address takes the default from the other addresses of the user.
+// ErrUserNotFound is returned when the user does not exist.
+func (db *InMemoryDB) CreateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateAddress")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.activeUser(rec.UserID); !ok {
+		fLog.Errorf("user %d got %s", rec.UserID, ErrUserNotFound.Error())
+		return nil, ErrUserNotFound
+	}
+
+	address := normalizeAddress(rec)
+	if address.IsDefault {
+		db.clearDefaultAddress(address.UserID)
+	} else {
+		address.IsDefault = len(db.userAddresses(address.UserID)) == 0
+	}
+
+	db.lastAddressID++
+	address.ID = db.lastAddressID
+	db.addresses[address.ID] = address
+
+	a := *address
+	return &a, nil
+}
+
+// userAddresses the addresses of a user ordered by address id, the caller must hold the lock
+func (db *InMemoryDB) userAddresses(userID int) []*AddressRecord {
+	addresses := make([]*AddressRecord, 0)
+	for _, address := range db.addresses {
+		if address.UserID == userID {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].ID < addresses[j].ID
+	})
+	return addresses
+}
+
+// clearDefaultAddress takes the default from every address of a user, the caller must hold the write lock
+func (db *InMemoryDB) clearDefaultAddress(userID int) {
+	for _, address := range db.userAddresses(userID) {
+		address.IsDefault = false
+	}
+}
+
+// userAddress the address of a user with the id, the caller must hold the lock
+func (db *InMemoryDB) userAddress(userID int, addressID int) (*AddressRecord, bool) {
+	address, ok := db.addresses[addressID]
+	if !ok || address.UserID != userID {
+		return nil, false
+	}
+	if _, ok := db.activeUser(userID); !ok {
+		return nil, false
+	}
+	return address, true
+}
+
+// GetAddressByID retrieves an AddressRecord from database where the address id is specified.
+func (db *InMemoryDB) GetAddressByID(ctx context.Context, addressID int) (*AddressRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetAddressByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	address, ok := db.addresses[addressID]
+	if !ok {
+		fLog.Errorf("address %d got %s", addressID, ErrAddressNotFound.Error())
+		return nil, ErrAddressNotFound
+	}
+
+	a := *address
+	return &a, nil
+}
+
+// GetDefaultAddress retrieves the default address of a user, ErrAddressNotFound is returned when it has none.
+func (db *InMemoryDB) GetDefaultAddress(ctx context.Context, userID int) (*AddressRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetDefaultAddress")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, address := range db.userAddresses(userID) {
+		if address.IsDefault {
+			a := *address
+			return &a, nil
+		}
+	}
+
+	fLog.Errorf("default address of user %d got %s", userID, ErrAddressNotFound.Error())
+	return nil, ErrAddressNotFound
+}
+
+// GetAddressesByUserID retrieves the address book of a user ordered by address id.
+func (db *InMemoryDB) GetAddressesByUserID(ctx context.Context, userID int, page *pagination.Page) ([]*AddressRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	addresses := make([]*AddressRecord, 0)
+	for _, address := range db.userAddresses(userID) {
+		a := *address
+		addresses = append(addresses, &a)
+	}
+
+	start, end := page.Bounds(len(addresses), func(i int, c *pagination.Cursor) int { return compareID(addresses[i].ID, c) })
+	return addresses[start:end], len(addresses), nil
+}
+
+// UpdateAddress update an entity record of address in database where the address id and user id are specified,
+// and returns the updated record. Making it the default takes the default from the other addresses of the user,
+// the default address can not be unset this way. The orders already shipped to the address keep their copy of it.
+// ErrAddressNotFound is returned when the user has no such address.
+func (db *InMemoryDB) UpdateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateAddress")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	current, ok := db.userAddress(rec.UserID, rec.ID)
+	if !ok {
+		fLog.Errorf("address %d of user %d got %s", rec.ID, rec.UserID, ErrAddressNotFound.Error())
+		return nil, ErrAddressNotFound
+	}
+
+	address := normalizeAddress(rec)
+	address.CreatedAt = current.CreatedAt
+	switch {
+	case current.IsDefault:
+		address.IsDefault = true
+	case address.IsDefault:
+		db.clearDefaultAddress(address.UserID)
+	}
+	db.addresses[address.ID] = address
+
+	a := *address
+	return &a, nil
+}
+
+// DeleteAddress delete an entity record of address from database where the address id and user id are specified.
+// Deleting the default address makes the oldest remaining address of the user its default.
+// ErrAddressNotFound is returned when the user has no such address.
+func (db *InMemoryDB) DeleteAddress(ctx context.Context, userID int, addressID int) (string, error) {
+	fLog := inMemoryLog.WithField("func", "DeleteAddress")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	address, ok := db.userAddress(userID, addressID)
+	if !ok {
+		fLog.Errorf("address %d of user %d got %s", addressID, userID, ErrAddressNotFound.Error())
+		return "", ErrAddressNotFound
+	}
+
+	delete(db.addresses, addressID)
+	if remaining := db.userAddresses(userID); address.IsDefault && len(remaining) > 0 {
+		remaining[0].IsDefault = true
+	}
+
+	return "address deleted successfully", nil
+}
+
+// CreateShipment insert an entity record of shipment into database and returns the persisted record, in transit.
+// The first shipment of a paid transaction moves it to shipped in the same db transaction, a shipped transaction
+// may get more shipments for the parcels sent later. ErrInvalidStatusTransition is returned for a transaction
+// in any other status, ErrDuplicateTrackingNumber when the carrier already has a shipment with the tracking number.
+func (db *InMemoryDB) CreateShipment(ctx context.Context, rec *ShipmentRecord, actor string) (*ShipmentRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateShipment")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	trans, ok := db.transactions[rec.TransactionID]
+	if !ok {
+		fLog.Errorf("transaction %d got %s", rec.TransactionID, ErrTransactionNotFound.Error())
+		return nil, ErrTransactionNotFound
+	}
+	if trans.Status != TransactionStatusPaid && trans.Status != TransactionStatusShipped {
+		fLog.Errorf("transaction %d in %s got %s", trans.ID, trans.Status, ErrInvalidStatusTransition.Error())
+		return nil, ErrInvalidStatusTransition
+	}
+
+	// like shipments_carrier_tracking_number_unique
+	for _, shipment := range db.shipments {
+		if shipment.Carrier == rec.Carrier && shipment.TrackingNumber == rec.TrackingNumber {
+			fLog.Errorf("tracking number %s of %s got %s", rec.TrackingNumber, rec.Carrier, ErrDuplicateTrackingNumber.Error())
+			return nil, ErrDuplicateTrackingNumber
+		}
+	}
+
+	if trans.Status == TransactionStatusPaid {
+		err := db.moveTransactionStatus(trans, TransactionStatusShipped, actor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db.lastShipmentID++
+	shipment := *rec
+	shipment.ID = db.lastShipmentID
+	shipment.Status = ShipmentStatusInTransit
+	shipment.UpdatedAt = shipment.CreatedAt
+	db.shipments[shipment.ID] = &shipment
+
+	created := shipment
+	return &created, nil
+}
+
+// GetShipmentsByTransactionID retrieves the shipments of a transaction, oldest first.
+func (db *InMemoryDB) GetShipmentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*ShipmentRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	shipments := make([]*ShipmentRecord, 0)
+	for _, shipment := range db.shipments {
+		if shipment.TransactionID == transactionID {
+			s := *shipment
+			shipments = append(shipments, &s)
+		}
+	}
+	sort.Slice(shipments, func(i, j int) bool {
+		return shipments[i].ID < shipments[j].ID
+	})
+
+	start, end := page.Bounds(len(shipments), func(i int, c *pagination.Cursor) int { return compareID(shipments[i].ID, c) })
+	return shipments[start:end], len(shipments), nil
+}
+
+// UpdateShipmentStatus moves a shipment to status. Once no other shipment of its shipped transaction is on its way,
+// delivering it completes the transaction in the same db transaction. Moving a shipment to the status it already has
+// changes nothing. ErrInvalidShipmentTransition is returned when the current status of the shipment does not allow the move.
+func (db *InMemoryDB) UpdateShipmentStatus(ctx context.Context, shipmentID int, status string, actor string) (*ShipmentRecord, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateShipmentStatus")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	shipment, ok := db.shipments[shipmentID]
+	if !ok {
+		fLog.Errorf("shipment %d got %s", shipmentID, ErrShipmentNotFound.Error())
+		return nil, ErrShipmentNotFound
+	}
+
+	if shipment.Status != status {
+		if !CanTransitionShipmentStatus(shipment.Status, status) {
+			fLog.Errorf("shipment %d from %s to %s got %s", shipmentID, shipment.Status, status, ErrInvalidShipmentTransition.Error())
+			return nil, ErrInvalidShipmentTransition
+		}
+
+		trans := db.transactions[shipment.TransactionID]
+		if status == ShipmentStatusDelivered && trans.Status == TransactionStatusShipped && !db.otherShipmentOnItsWay(shipment) {
+			err := db.moveTransactionStatus(trans, TransactionStatusCompleted, actor)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		shipment.Status = status
+		shipment.UpdatedAt = time.Now()
+	}
+
+	s := *shipment
+	return &s, nil
+}
+
+// otherShipmentOnItsWay reports whether another shipment of the transaction of shipment is still to be delivered or returned,
+// the caller must hold the lock
+func (db *InMemoryDB) otherShipmentOnItsWay(shipment *ShipmentRecord) bool {
+	for _, other := range db.shipments {
+		if other.TransactionID == shipment.TransactionID && other.ID != shipment.ID && shipmentOnItsWay(other.Status) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendStatusHistory records a status change, the caller must hold the write lock
+func (db *InMemoryDB) appendStatusHistory(transactionID int, from, to, actor string, createdAt time.Time) {
+	db.lastStatusHistoryID++
+	db.statusHistory[transactionID] = append(db.statusHistory[transactionID], &TransactionStatusHistoryRecord{
+		ID:            db.lastStatusHistoryID,
+		TransactionID: transactionID,
+		FromStatus:    from,
+		ToStatus:      to,
+		Actor:         actor,
+		CreatedAt:     createdAt,
+	})
+}
+
+// GetCart retrieves the cart of a user with the current price and stock of every product in it.
+func (db *InMemoryDB) GetCart(ctx context.Context, userID int) (*CartRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return db.cart(userID)
+}
+
+// cart the cart of a user with the product fields of every item filled, the caller must hold the lock
+func (db *InMemoryDB) cart(userID int) (*CartRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetCart")
+
+	now := time.Now()
+	items := make([]*CartItemRecord, 0, len(db.carts[userID]))
+	for _, stored := range db.carts[userID] {
+		item := &CartItemRecord{UserID: stored.UserID, ProductID: stored.ProductID, Qty: stored.Qty, AddedAt: stored.AddedAt}
+		p := *db.products[item.ProductID]
+		p.Available = availableQty(p.Qty, db.reservedQty(stockKey{ProductID: p.ID}, 0, now))
+		err := priceCartItem(item, &p)
+		if err != nil {
+			fLog.Errorf("priceCartItem got %s", err.Error())
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	return newCartRecord(userID, items)
+}
+
+// AddCartItem adds the qty of rec to the cart of its user, on top of the qty of the product already in the cart,
+// and returns the updated cart. ErrProductNotFound is returned when the product does not exist.
+func (db *InMemoryDB) AddCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error) {
+	fLog := inMemoryLog.WithField("func", "AddCartItem")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// emulate fk_cart_items_users1 and fk_cart_items_products1
+	if _, ok := db.users[rec.UserID]; !ok {
+		fLog.Errorf("user %d does not exist", rec.UserID)
+		return nil, fmt.Errorf("user %d does not exist", rec.UserID)
+	}
+	if _, ok := db.products[rec.ProductID]; !ok {
+		fLog.Errorf("product %d got %s", rec.ProductID, ErrProductNotFound.Error())
+		return nil, ErrProductNotFound
+	}
+
+	// a product added again keeps the time it was first added, so the cart keeps its order
+	if item := db.cartItem(rec.UserID, rec.ProductID); item != nil {
+		item.Qty += rec.Qty
+	} else {
+		db.carts[rec.UserID] = append(db.carts[rec.UserID], &CartItemRecord{UserID: rec.UserID, ProductID: rec.ProductID, Qty: rec.Qty, AddedAt: rec.AddedAt})
+	}
+
+	return db.cart(rec.UserID)
+}
+
+// UpdateCartItem replaces the qty of a product in the cart of a user and returns the updated cart.
+// ErrCartItemNotFound is returned when the product is not in the cart.
+func (db *InMemoryDB) UpdateCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateCartItem")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	item := db.cartItem(rec.UserID, rec.ProductID)
+	if item == nil {
+		fLog.Errorf("product %d got %s", rec.ProductID, ErrCartItemNotFound.Error())
+		return nil, ErrCartItemNotFound
+	}
+	item.Qty = rec.Qty
+
+	return db.cart(rec.UserID)
+}
+
+// RemoveCartItem removes a product from the cart of a user and returns the updated cart.
+// ErrCartItemNotFound is returned when the product is not in the cart.
+func (db *InMemoryDB) RemoveCartItem(ctx context.Context, userID int, productID int) (*CartRecord, error) {
+	fLog := inMemoryLog.WithField("func", "RemoveCartItem")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if !db.removeCartItem(userID, productID) {
+		fLog.Errorf("product %d got %s", productID, ErrCartItemNotFound.Error())
+		return nil, ErrCartItemNotFound
+	}
+
+	return db.cart(userID)
+}
+
+// CheckoutCart creates the transaction of the items in the cart of rec.UserID like CreateTransaction does,
+// and empties the cart in the same db transaction. The detail of rec is replaced by the items of the cart,
+// ErrCartEmpty is returned when there is none.
+func (db *InMemoryDB) CheckoutCart(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CheckoutCart")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	items := db.carts[rec.UserID]
+	if len(items) == 0 {
+		fLog.Errorf("user %d got %s", rec.UserID, ErrCartEmpty.Error())
+		return nil, ErrCartEmpty
+	}
+
+	transaction, err := db.createTransaction(ctx, cartOrder(rec, items), nil)
+	if err != nil {
+		return nil, err
+	}
+	delete(db.carts, rec.UserID)
+
+	return transaction, nil
+}
+
+// cartItem the stored item of a product in the cart of a user, nil when it is not in the cart, the caller must hold the lock
+func (db *InMemoryDB) cartItem(userID int, productID int) *CartItemRecord {
+	for _, item := range db.carts[userID] {
+		if item.ProductID == productID {
+			return item
+		}
+	}
+	return nil
+}
+
+// removeCartItem removes a product from the cart of a user and reports whether it was in it, the caller must hold the write lock
+func (db *InMemoryDB) removeCartItem(userID int, productID int) bool {
+	items := db.carts[userID]
+	for i, item := range items {
+		if item.ProductID != productID {
+			continue
+		}
+		items = append(items[:i:i], items[i+1:]...)
+		if len(items) == 0 {
+			delete(db.carts, userID)
+		} else {
+			db.carts[userID] = items
+		}
+		return true
+	}
+	return false
+}
+
+// GetUserByID retrieves an UserRecord from database where the user id is specified.
+// Soft deleted users are not returned.
+func (db *InMemoryDB) GetUserByID(ctx context.Context, userID int) (*UserRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetUserByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	user, ok := db.activeUser(userID)
+	if !ok {
+		fLog.Errorf("user %d got %s", userID, ErrUserNotFound.Error())
+		return nil, ErrUserNotFound
+	}
+
+	u := *user
+	return &u, nil
+}
+
+// GetUsers retrieves every UserRecord that is not soft deleted from database ordered by user id.
+func (db *InMemoryDB) GetUsers(ctx context.Context, page *pagination.Page) ([]*UserRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	userList := make([]*UserRecord, 0, len(db.users))
+	for id, user := range db.users {
+		if _, deleted := db.deletedUsers[id]; deleted {
+			continue
+		}
+		u := *user
+		userList = append(userList, &u)
+	}
+
+	sort.Slice(userList, func(i, j int) bool {
+		return userList[i].ID < userList[j].ID
+	})
+
+	start, end := page.Bounds(len(userList), func(i int, c *pagination.Cursor) int { return compareID(userList[i].ID, c) })
+	return userList[start:end], len(userList), nil
+}
+
+// CreateUser insert an entity record of user into database and returns the persisted record.
+// ErrDuplicateEmail is returned when the email is already used.
+func (db *InMemoryDB) CreateUser(ctx context.Context, rec *UserRecord) (*UserRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateUser")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.emailTaken(rec.Email, 0) {
+		fLog.Errorf("email %s got %s", rec.Email, ErrDuplicateEmail.Error())
+		return nil, ErrDuplicateEmail
+	}
+
+	db.lastUserID++
+	user := &UserRecord{
+		ID:      db.lastUserID,
+		Name:    rec.Name,
+		Email:   rec.Email,
+		Address: rec.Address,
+	}
+	db.users[user.ID] = user
+
+	u := *user
+	return &u, nil
+}
+
+// UpdateUser update an entity record of user in database where the user id is specified.
+// ErrDuplicateEmail is returned when the email is already used by another user.
+func (db *InMemoryDB) UpdateUser(ctx context.Context, rec *UserRecord) (string, error) {
+	fLog := inMemoryLog.WithField("func", "UpdateUser")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	user, ok := db.activeUser(rec.ID)
+	if !ok {
+		fLog.Errorf("user %d got %s", rec.ID, ErrUserNotFound.Error())
+		return "", ErrUserNotFound
+	}
+
+	if db.emailTaken(rec.Email, rec.ID) {
+		fLog.Errorf("email %s got %s", rec.Email, ErrDuplicateEmail.Error())
+		return "", ErrDuplicateEmail
+	}
+
+	user.Name = rec.Name
+	user.Email = rec.Email
+	user.Address = rec.Address
+
+	return "user updated successfully", nil
+}
+
+// DeleteUser soft delete an entity record of user where the user id is specified.
+// The email of a soft deleted user stays reserved.
+func (db *InMemoryDB) DeleteUser(ctx context.Context, userID int) (string, error) {
+	fLog := inMemoryLog.WithField("func", "DeleteUser")
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.activeUser(userID); !ok {
+		fLog.Errorf("user %d got %s", userID, ErrUserNotFound.Error())
+		return "", ErrUserNotFound
+	}
+	db.deletedUsers[userID] = time.Now()
+
+	return "user deleted successfully", nil
+}
+
+// activeUser returns the user when it exists and is not soft deleted, the caller must hold the lock
+func (db *InMemoryDB) activeUser(userID int) (*UserRecord, bool) {
+	user, ok := db.users[userID]
+	if !ok {
+		return nil, false
+	}
+	if _, deleted := db.deletedUsers[userID]; deleted {
+		return nil, false
+	}
+	return user, true
+}
+
+// emailTaken emulates users_email_UNIQUE, soft deleted users included. The caller must hold the lock
+func (db *InMemoryDB) emailTaken(email string, exceptUserID int) bool {
+	for id, user := range db.users {
+		if id != exceptUserID && strings.EqualFold(user.Email, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateCoupon insert an entity record of coupon into database and returns the persisted record.
+// The code is stored upper case, ErrDuplicateCouponCode is returned when it is already used.
+func (db *InMemoryDB) CreateCoupon(ctx context.Context, rec *CouponRecord) (*CouponRecord, error) {
+	fLog := inMemoryLog.WithField("func", "CreateCoupon")
+
+	err := validateCoupon(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	coupon := *rec
+	coupon.Code = normalizeCouponCode(rec.Code)
+
+	// emulate coupons_code_unique
+	for _, c := range db.coupons {
+		if c.Code == coupon.Code {
+			fLog.Errorf("coupon %s got %s", coupon.Code, ErrDuplicateCouponCode.Error())
+			return nil, ErrDuplicateCouponCode
+		}
+	}
+
+	// emulate fk_coupons_brands1
+	if _, ok := db.brands[coupon.BrandID]; coupon.BrandID != 0 && !ok {
+		fLog.Errorf("brand %d does not exist", coupon.BrandID)
+		return nil, fmt.Errorf("brand %d does not exist", coupon.BrandID)
+	}
+
+	db.lastCouponID++
+	coupon.ID = db.lastCouponID
+	db.coupons[coupon.ID] = &coupon
+
+	c := coupon
+	return &c, nil
+}
+
+// GetCouponByID retrieves an CouponRecord from database where the coupon id is specified.
+func (db *InMemoryDB) GetCouponByID(ctx context.Context, couponID int) (*CouponRecord, error) {
+	fLog := inMemoryLog.WithField("func", "GetCouponByID")
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	coupon, ok := db.coupons[couponID]
+	if !ok {
+		fLog.Errorf("coupon %d got %s", couponID, ErrCouponNotFound.Error())
+		return nil, ErrCouponNotFound
+	}
+
+	c := *coupon
+	return &c, nil
+}
+
+// GetCoupons retrieves every CouponRecord from database ordered by coupon id.
+func (db *InMemoryDB) GetCoupons(ctx context.Context, page *pagination.Page) ([]*CouponRecord, int, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	couponList := make([]*CouponRecord, 0, len(db.coupons))
+	for _, coupon := range db.coupons {
+		c := *coupon
+		couponList = append(couponList, &c)
+	}
+	sort.Slice(couponList, func(i, j int) bool {
+		return couponList[i].ID < couponList[j].ID
+	})
+
+	start, end := page.Bounds(len(couponList), func(i int, c *pagination.Cursor) int { return compareID(couponList[i].ID, c) })
+	return couponList[start:end], len(couponList), nil
+}
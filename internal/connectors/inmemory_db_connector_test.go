@@ -0,0 +1,1788 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInMemoryBrand(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.GetBrandByID(context.Background(), 100)
+		assert.Equal(t, ErrBrandNotFound, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		created, err := db.CreateBrand(context.Background(), &BrandRecord{Name: "acer"})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, created.ID)
+
+		brand, err := db.GetBrandByID(context.Background(), 4)
+		assert.Nil(t, err)
+		assert.Equal(t, &BrandRecord{ID: 4, Name: "acer"}, brand)
+
+		_, err = db.UpdateBrand(context.Background(), &BrandRecord{ID: 4, Name: "acer predator"})
+		assert.Nil(t, err)
+
+		brands, _, err := db.GetBrands(context.Background(), nil)
+		assert.Nil(t, err)
+		assert.Len(t, brands, 4)
+		assert.Equal(t, "acer predator", brands[3].Name)
+
+		_, err = db.DeleteBrand(context.Background(), 4)
+		assert.Nil(t, err)
+
+		_, err = db.GetBrandByID(context.Background(), 4)
+		assert.Equal(t, ErrBrandNotFound, err)
+	})
+
+	t.Run("error-delete-brand-has-products", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.DeleteBrand(context.Background(), 1)
+		assert.Equal(t, ErrBrandHasProducts, err)
+	})
+}
+
+func TestInMemoryProduct(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-brand-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 100, Name: "predator", Qty: 1, Price: NewMoney(1000, CurrencyIDR)})
+		assert.NotNil(t, err)
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.GetProductByID(context.Background(), 100)
+		assert.Equal(t, ErrProductNotFound, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		created, err := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air", Qty: 5, Price: NewMoney(900, CurrencyIDR)})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, created.ID)
+
+		product, err := db.GetProductByID(context.Background(), 4)
+		assert.Nil(t, err)
+		assert.Equal(t, &ProductRecord{ID: 4, BrandID: 1, Name: "macbook air", Qty: 5, Price: NewMoney(900, CurrencyIDR), Available: 5,
+			CategoryIDs: []int{}, Categories: []CategoryPath{}, Variants: []*ProductVariantRecord{}}, product)
+
+		products, _, err := db.GetProductByBrandID(context.Background(), 1, nil)
+		assert.Nil(t, err)
+		assert.Len(t, products, 2)
+		assert.Equal(t, 1, products[0].ID)
+		assert.Equal(t, 4, products[1].ID)
+	})
+
+	t.Run("returned-record-is-a-copy", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		product.Qty = 0
+
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+	})
+}
+
+func TestInMemoryProductManagement(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("update", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 2, Name: "macbook pro m1", Qty: 4, Price: NewMoney(1300, CurrencyIDR)}, "admin")
+		assert.Nil(t, err)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, &ProductRecord{ID: 1, BrandID: 2, Name: "macbook pro m1", Qty: 4, Price: NewMoney(1300, CurrencyIDR), Available: 4,
+			CategoryIDs: []int{}, Categories: []CategoryPath{}, Variants: []*ProductVariantRecord{}}, product)
+
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 100, BrandID: 1}, "admin")
+		assert.Equal(t, ErrProductNotFound, err)
+
+		// the qty is compared with the one the product has now, not the one the caller read
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 2, Name: "macbook pro m1", Qty: 3, Price: NewMoney(1300, CurrencyIDR)}, "")
+		assert.Equal(t, ErrActorRequired, err)
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 2, Name: "macbook pro m2", Qty: 4, Price: NewMoney(1300, CurrencyIDR)}, "")
+		assert.Nil(t, err)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		// product 1 is ordered by the seeded transaction
+		_, err := db.DeleteProduct(context.Background(), 1)
+		assert.Equal(t, ErrProductHasOrders, err)
+
+		// the stock ledger of a product created with qty outlives it
+		stocked, _ := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air", Qty: 5, Price: NewMoney(900, CurrencyIDR)})
+		_, err = db.DeleteProduct(context.Background(), stocked.ID)
+		assert.Equal(t, ErrProductHasStockMovements, err)
+
+		movements, _, _ := db.GetStockMovements(context.Background(), stocked.ID, nil)
+		assert.Len(t, movements, 1)
+
+		created, _ := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air m2", Qty: 0, Price: NewMoney(900, CurrencyIDR)})
+		_, err = db.DeleteProduct(context.Background(), created.ID)
+		assert.Nil(t, err)
+
+		_, err = db.GetProductByID(context.Background(), created.ID)
+		assert.Equal(t, ErrProductNotFound, err)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "Macbook Air", Qty: 0, Price: NewMoney(900, CurrencyIDR)})
+
+		ids := func(products []*ProductRecord) []int {
+			list := make([]int, 0, len(products))
+			for _, p := range products {
+				list = append(list, p.ID)
+			}
+			return list
+		}
+		minPrice, maxPrice := int64(1000), int64(1150)
+
+		products, total, err := db.GetProducts(context.Background(), &ProductFilter{Name: "macbook", Page: &pagination.Page{Limit: 10}})
+		assert.Nil(t, err)
+		assert.Equal(t, []int{1, 4}, ids(products))
+		assert.Equal(t, 2, total)
+
+		products, _, _ = db.GetProducts(context.Background(), &ProductFilter{Name: "macbook", InStock: true, Page: &pagination.Page{Limit: 10}})
+		assert.Equal(t, []int{1}, ids(products))
+
+		products, _, _ = db.GetProducts(context.Background(), &ProductFilter{MinPrice: &minPrice, MaxPrice: &maxPrice, Page: &pagination.Page{Limit: 10}})
+		assert.Equal(t, []int{2, 3}, ids(products))
+
+		products, _, _ = db.GetProducts(context.Background(), &ProductFilter{Sort: ProductSortPrice, Desc: true, Page: &pagination.Page{Limit: 10}})
+		assert.Equal(t, []int{1, 3, 2, 4}, ids(products))
+
+		// legion, Macbook Air, macbook pro, rog
+		products, total, _ = db.GetProducts(context.Background(), &ProductFilter{Sort: ProductSortName, Page: &pagination.Page{Limit: 2, Offset: 1}})
+		assert.Equal(t, []int{4, 1}, ids(products))
+		assert.Equal(t, 4, total)
+
+		products, _, _ = db.GetProducts(context.Background(), &ProductFilter{BrandID: 1, Page: &pagination.Page{Limit: 10, Offset: 5}})
+		assert.Empty(t, products)
+
+		// by descending price the products are 1, 3, 2, 4
+		filter := &ProductFilter{Sort: ProductSortPrice, Desc: true}
+		all, _, _ := db.GetProducts(context.Background(), filter)
+
+		filter.Page = &pagination.Page{Limit: 2, After: ProductCursor(all[0], ProductSortPrice)}
+		products, total, err = db.GetProducts(context.Background(), filter)
+		assert.Nil(t, err)
+		assert.Equal(t, []int{3, 2}, ids(products))
+		assert.Equal(t, 4, total)
+
+		filter.Page = &pagination.Page{Limit: 2, Before: ProductCursor(all[3], ProductSortPrice)}
+		products, _, _ = db.GetProducts(context.Background(), filter)
+		assert.Equal(t, []int{3, 2}, ids(products))
+
+		filter.Page = &pagination.Page{Limit: 2, After: &pagination.Cursor{Value: "cheap", ID: 1}}
+		_, _, err = db.GetProducts(context.Background(), filter)
+		assert.True(t, errors.Is(err, ErrBadRequest))
+	})
+}
+
+func TestInMemoryStockMovements(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	reasons := func(movements []*StockMovementRecord) []string {
+		list := make([]string, 0, len(movements))
+		for _, m := range movements {
+			list = append(list, fmt.Sprintf("%s:%d", m.Reason, m.Delta))
+		}
+		return list
+	}
+
+	t.Run("every-qty-change-is-recorded", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}}})
+		assert.Nil(t, err)
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusPaid, "donny")
+		assert.Nil(t, err)
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusCancelled, "donny")
+		assert.Nil(t, err)
+		_, err = db.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 1, Delta: 4, Reason: StockReasonRestock, Actor: "admin", CreatedAt: time.Now()})
+		assert.Nil(t, err)
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 6, Price: NewMoney(1200, CurrencyIDR)}, "admin")
+		assert.Nil(t, err)
+
+		movements, _, err := db.GetStockMovements(context.Background(), 1, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"initial:3", "sale:-2", "cancel:2", "restock:4", "adjustment:-1"}, reasons(movements))
+		assert.Equal(t, trans.ID, movements[1].TransactionID)
+		assert.Equal(t, "donny", movements[2].Actor)
+		assert.Equal(t, "admin", movements[4].Actor)
+
+		// the ledger adds up to the qty
+		product, _ := db.GetProductByID(context.Background(), 1)
+		total := 0
+		for _, m := range movements {
+			total += m.Delta
+		}
+		assert.Equal(t, product.Qty, total)
+	})
+
+	t.Run("error-adjust-below-zero", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 3, Delta: -2, Reason: StockReasonAdjustment, Actor: "admin"})
+		assert.Equal(t, ErrInsufficientStock, err)
+
+		_, err = db.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 100, Delta: 1, Reason: StockReasonRestock, Actor: "admin"})
+		assert.Equal(t, ErrProductNotFound, err)
+
+		movements, _, _ := db.GetStockMovements(context.Background(), 3, nil)
+		assert.Equal(t, []string{"initial:1"}, reasons(movements))
+	})
+}
+
+func TestInMemoryTransaction(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-qty-not-enough", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		rec := &TransactionRecord{
+			UserID: 1,
+			Date:   time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 1, Qty: 1},
+				{ProductID: 3, Qty: 2},
+			},
+		}
+
+		_, err := db.CreateTransaction(context.Background(), rec)
+		assert.NotNil(t, err)
+
+		// nothing is decremented when one of the detail fails
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+	})
+
+	t.Run("error-same-product-exceeds-stock", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		rec := &TransactionRecord{
+			UserID: 1,
+			Date:   time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 2, Qty: 2},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+
+		_, err := db.CreateTransaction(context.Background(), rec)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		rec := &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 100, Qty: 1}},
+		}
+
+		_, err := db.CreateTransaction(context.Background(), rec)
+		assert.Equal(t, ErrProductNotFound, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		rec := &TransactionRecord{
+			UserID: 1,
+			Date:   time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 1, Qty: 2},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+
+		created, err := db.CreateTransaction(context.Background(), rec)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, created.ID)
+		assert.Equal(t, int64(3400), created.GrandTotal.Amount)
+		assert.Equal(t, 2, created.TransactionDetail[0].TransactionID)
+
+		transaction, err := db.GetTransactionByTransactionID(context.Background(), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3400), transaction.GrandTotal.Amount)
+		assert.Len(t, transaction.TransactionDetail, 2)
+		assert.Equal(t, int64(2400), transaction.TransactionDetail[0].SubTotal.Amount)
+
+		// the pending order reserves its qty without taking it
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+		assert.Equal(t, 1, product.Available)
+	})
+
+	t.Run("concurrent-no-oversell", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				rec := &TransactionRecord{
+					UserID:            1,
+					Date:              time.Now(),
+					TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+				}
+				if _, err := db.CreateTransaction(context.Background(), rec); err == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, succeeded)
+		assert.Equal(t, 0, product.Available)
+	})
+}
+
+func TestInMemoryCoupon(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	order := func(code string) *TransactionRecord {
+		return &TransactionRecord{
+			UserID:     1,
+			Date:       time.Now(),
+			CouponCode: code,
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 1, Qty: 2},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+	}
+
+	t.Run("create-and-get", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		created, err := db.CreateCoupon(context.Background(), &CouponRecord{Code: "save10", Type: CouponTypePercentage, Value: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, created.ID)
+		assert.Equal(t, "SAVE10", created.Code)
+
+		_, err = db.CreateCoupon(context.Background(), &CouponRecord{Code: "Save10", Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR})
+		assert.Equal(t, ErrDuplicateCouponCode, err)
+
+		_, err = db.CreateCoupon(context.Background(), &CouponRecord{Code: "BRAND", Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR, BrandID: 100})
+		assert.NotNil(t, err)
+
+		coupon, err := db.GetCouponByID(context.Background(), 1)
+		assert.Nil(t, err)
+		assert.Equal(t, CouponTypePercentage, coupon.Type)
+
+		coupons, _, _ := db.GetCoupons(context.Background(), nil)
+		assert.Len(t, coupons, 1)
+
+		_, err = db.GetCouponByID(context.Background(), 2)
+		assert.Equal(t, ErrCouponNotFound, err)
+	})
+
+	t.Run("order-with-brand-scoped-coupon", func(t *testing.T) {
+		db := NewInMemoryDB()
+		_, err := db.CreateCoupon(context.Background(), &CouponRecord{Code: "APPLE10", Type: CouponTypePercentage, Value: 10, BrandID: 1})
+		assert.Nil(t, err)
+
+		created, err := db.CreateTransaction(context.Background(), order("apple10"))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(3160), created.GrandTotal.Amount)
+		assert.Equal(t, int64(240), created.Discount.Amount)
+
+		transaction, err := db.GetTransactionByTransactionID(context.Background(), created.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "APPLE10", transaction.CouponCode)
+		assert.Equal(t, []*TransactionDiscountRecord{{CouponID: 1, CouponCode: "APPLE10", Amount: NewMoney(240, CurrencyIDR)}}, transaction.TransactionDetail[0].Discounts)
+		assert.Equal(t, int64(2400), transaction.TransactionDetail[0].SubTotal.Amount)
+		assert.Nil(t, transaction.TransactionDetail[1].Discounts)
+
+		// a brand with coupons can not be deleted
+		_, err = db.DeleteBrand(context.Background(), 1)
+		assert.Equal(t, ErrBrandHasProducts, err)
+	})
+
+	t.Run("error-coupon", func(t *testing.T) {
+		db := NewInMemoryDB()
+		_, err := db.CreateCoupon(context.Background(), &CouponRecord{Code: "ONCE", Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR, UsageLimitPerUser: 1})
+		assert.Nil(t, err)
+		_, err = db.CreateCoupon(context.Background(), &CouponRecord{Code: "BIG", Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR, MinSpend: 10000})
+		assert.Nil(t, err)
+		_, err = db.CreateCoupon(context.Background(), &CouponRecord{Code: "OVER", Type: CouponTypeFixed, Value: 100, Currency: CurrencyIDR, EndsAt: time.Now().Add(-time.Hour)})
+		assert.Nil(t, err)
+
+		_, err = db.CreateTransaction(context.Background(), order("NONE"))
+		assert.Equal(t, ErrCouponNotFound, err)
+
+		_, err = db.CreateTransaction(context.Background(), order("BIG"))
+		assert.True(t, errors.Is(err, ErrCouponNotApplicable))
+
+		_, err = db.CreateTransaction(context.Background(), order("OVER"))
+		assert.True(t, errors.Is(err, ErrCouponNotApplicable))
+
+		_, err = db.CreateTransaction(context.Background(), order("ONCE"))
+		assert.Nil(t, err)
+		_, err = db.CreateTransaction(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now(), CouponCode: "ONCE", TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}}})
+		assert.Equal(t, ErrCouponUsageExceeded, err)
+
+		// the failed orders left the stock untouched
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 1, product.Available)
+	})
+}
+
+// brokenTaxCalculator a TaxCalculator answering with fewer taxes than lines
+type brokenTaxCalculator struct{}
+
+func (brokenTaxCalculator) Tax(lines []TaxLine) ([]int64, error) { return nil, nil }
+func (brokenTaxCalculator) Inclusive() bool                      { return false }
+
+func TestInMemoryTransactionTax(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	newRec := func() *TransactionRecord {
+		return &TransactionRecord{
+			UserID:     1,
+			Date:       time.Now(),
+			CouponCode: "SAVE10",
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 1, Qty: 1},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+	}
+	newDB := func(calc TaxCalculator) *InMemoryDB {
+		db := NewInMemoryDB()
+		db.SetTaxCalculator(calc)
+		_, err := db.CreateCoupon(context.Background(), &CouponRecord{Code: "SAVE10", Type: CouponTypePercentage, Value: 10})
+		assert.Nil(t, err)
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 2, BrandID: 2, Name: "legion", Qty: 2, Price: NewMoney(1000, CurrencyIDR), TaxClass: TaxClassReduced}, "admin")
+		assert.Nil(t, err)
+		return db
+	}
+
+	t.Run("success-tax-after-discount", func(t *testing.T) {
+		db := newDB(&RateTaxCalculator{Rates: map[string]int{TaxClassStandard: 1100, TaxClassReduced: 500}})
+
+		transaction, err := db.CreateTransaction(context.Background(), newRec())
+		assert.Nil(t, err)
+		assert.Equal(t, int64(2200), transaction.Subtotal.Amount)
+		assert.Equal(t, int64(220), transaction.Discount.Amount)
+		assert.Equal(t, int64(164), transaction.Tax.Amount)
+		assert.Equal(t, int64(2144), transaction.GrandTotal.Amount)
+		assert.Equal(t, int64(119), transaction.TransactionDetail[0].Tax.Amount)
+		assert.Equal(t, int64(45), transaction.TransactionDetail[1].Tax.Amount)
+
+		// the reservation end is only returned when the order is created
+		assert.False(t, transaction.ReservedUntil.IsZero())
+		transaction.ReservedUntil = time.Time{}
+		stored, _ := db.GetTransactionByTransactionID(context.Background(), transaction.ID)
+		assert.Equal(t, transaction, stored)
+	})
+
+	t.Run("success-inclusive", func(t *testing.T) {
+		db := newDB(&RateTaxCalculator{Rates: map[string]int{TaxClassStandard: 1100, TaxClassReduced: 500}, PricesIncludeTax: true})
+
+		transaction, err := db.CreateTransaction(context.Background(), newRec())
+		assert.Nil(t, err)
+		assert.True(t, transaction.TaxInclusive)
+		assert.Equal(t, int64(150), transaction.Tax.Amount)
+		assert.Equal(t, int64(1980), transaction.GrandTotal.Amount)
+	})
+
+	t.Run("error-calculator", func(t *testing.T) {
+		db := newDB(brokenTaxCalculator{})
+
+		_, err := db.CreateTransaction(context.Background(), newRec())
+		assert.NotNil(t, err)
+
+		// nothing is written when the tax can not be computed
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+	})
+}
+
+func TestInMemoryTransactionIdempotent(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	newRec := func() *TransactionRecord {
+		return &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+		}
+	}
+	newIdem := func(hash string, now time.Time) *IdempotencyRecord {
+		return &IdempotencyRecord{Key: "key-1", RequestHash: hash, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	}
+	respond := func(trans *TransactionRecord) (int, []byte, error) {
+		return 201, []byte(fmt.Sprintf(`{"id":%d}`, trans.ID)), nil
+	}
+
+	t.Run("replay", func(t *testing.T) {
+		db := NewInMemoryDB()
+		now := time.Now()
+
+		stored, replayed, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("hash", now), respond)
+		assert.Nil(t, err)
+		assert.False(t, replayed)
+		assert.Equal(t, 2, stored.TransactionID)
+		assert.Equal(t, `{"id":2}`, string(stored.ResponseBody))
+
+		stored, replayed, err = db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("hash", now.Add(time.Minute)), respond)
+		assert.Nil(t, err)
+		assert.True(t, replayed)
+		assert.Equal(t, 2, stored.TransactionID)
+		assert.Equal(t, 201, stored.ResponseStatus)
+
+		// the stock is only reserved by the first request
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 2, product.Available)
+	})
+
+	t.Run("error-key-reused", func(t *testing.T) {
+		db := NewInMemoryDB()
+		now := time.Now()
+
+		_, _, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("hash", now), respond)
+		assert.Nil(t, err)
+
+		_, _, err = db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("other", now), respond)
+		assert.Equal(t, ErrIdempotencyKeyReused, err)
+	})
+
+	t.Run("expired-key", func(t *testing.T) {
+		db := NewInMemoryDB()
+		now := time.Now()
+
+		_, _, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("hash", now), respond)
+		assert.Nil(t, err)
+
+		stored, replayed, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("other", now.Add(2*time.Hour)), respond)
+		assert.Nil(t, err)
+		assert.False(t, replayed)
+		assert.Equal(t, 3, stored.TransactionID)
+	})
+
+	t.Run("delete-expired-keys", func(t *testing.T) {
+		db := NewInMemoryDB()
+		now := time.Now()
+
+		_, _, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("hash", now), respond)
+		assert.Nil(t, err)
+
+		deleted, err := db.DeleteExpiredIdempotencyKeys(context.Background(), now.Add(time.Minute))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, deleted)
+
+		deleted, err = db.DeleteExpiredIdempotencyKeys(context.Background(), now.Add(time.Hour))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, deleted)
+
+		// the key is free for a new order, the order it made is kept
+		_, replayed, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("other", now.Add(time.Hour)), respond)
+		assert.Nil(t, err)
+		assert.False(t, replayed)
+		_, err = db.GetTransactionByTransactionID(context.Background(), 2)
+		assert.Nil(t, err)
+	})
+
+	t.Run("error-respond", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, _, err := db.CreateTransactionIdempotent(context.Background(), newRec(), newIdem("hash", time.Now()), func(*TransactionRecord) (int, []byte, error) {
+			return 0, nil, fmt.Errorf("marshal error")
+		})
+		assert.NotNil(t, err)
+
+		// nothing is kept when the response can not be built
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+		_, err = db.GetTransactionByTransactionID(context.Background(), 2)
+		assert.Equal(t, ErrTransactionNotFound, err)
+	})
+}
+
+func TestInMemoryTransactionStatus(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	newOrder := func(db *InMemoryDB) *TransactionRecord {
+		rec := &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}},
+		}
+		created, _ := db.CreateTransaction(context.Background(), rec)
+		return created
+	}
+
+	t.Run("error-invalid-transition", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		// the seeded order is completed, it can only be refunded
+		_, err := db.UpdateTransactionStatus(context.Background(), 1, TransactionStatusCancelled, "donny")
+		assert.Equal(t, ErrInvalidStatusTransition, err)
+	})
+
+	t.Run("success-cancel-releases-reservation", func(t *testing.T) {
+		db := NewInMemoryDB()
+		order := newOrder(db)
+		assert.Equal(t, TransactionStatusPending, order.Status)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+		assert.Equal(t, 1, product.Available)
+
+		cancelled, err := db.UpdateTransactionStatus(context.Background(), order.ID, TransactionStatusCancelled, "donny")
+		assert.Nil(t, err)
+		assert.Equal(t, TransactionStatusCancelled, cancelled.Status)
+
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+		assert.Equal(t, 3, product.Available)
+
+		// cancelled is final, the stock can not be restored twice
+		_, err = db.UpdateTransactionStatus(context.Background(), order.ID, TransactionStatusRefunded, "donny")
+		assert.Equal(t, ErrInvalidStatusTransition, err)
+
+		history, _, err := db.GetTransactionStatusHistory(context.Background(), order.ID, nil)
+		assert.Nil(t, err)
+		assert.Len(t, history, 2)
+		assert.Equal(t, "user:1", history[0].Actor)
+		assert.Equal(t, TransactionStatusPending, history[1].FromStatus)
+		assert.Equal(t, "donny", history[1].Actor)
+	})
+
+	t.Run("success-pay-takes-stock-cancel-restores-it", func(t *testing.T) {
+		db := NewInMemoryDB()
+		order := newOrder(db)
+
+		_, err := db.UpdateTransactionStatus(context.Background(), order.ID, TransactionStatusPaid, "admin")
+		assert.Nil(t, err)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 1, product.Qty)
+		assert.Equal(t, 1, product.Available)
+
+		_, err = db.UpdateTransactionStatus(context.Background(), order.ID, TransactionStatusCancelled, "admin")
+		assert.Nil(t, err)
+
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+		assert.Equal(t, 3, product.Available)
+	})
+}
+
+func TestInMemoryStockReservation(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	order := func(db *InMemoryDB, date time.Time, qty int) (*TransactionRecord, error) {
+		return db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              date,
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: qty}},
+		})
+	}
+
+	t.Run("expired-reservation-holds-nothing", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.SetReservationTTL(time.Minute)
+		now := time.Now()
+
+		expired, err := order(db, now.Add(-2*time.Minute), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, now.Add(-time.Minute), expired.ReservedUntil)
+		_, err = order(db, now, 1)
+		assert.Nil(t, err)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 2, product.Available)
+
+		released, err := db.ReleaseExpiredReservations(context.Background(), now)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, released)
+		released, _ = db.ReleaseExpiredReservations(context.Background(), now)
+		assert.Equal(t, 0, released)
+
+		// capturing a payment started before the expiry takes the qty that is still available
+		_, err = db.UpdateTransactionStatus(context.Background(), expired.ID, TransactionStatusPaid, "admin")
+		assert.Nil(t, err)
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 1, product.Qty)
+		assert.Equal(t, 0, product.Available)
+	})
+
+	t.Run("error-expired-reservation-ordered-by-others", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.SetReservationTTL(time.Minute)
+		now := time.Now()
+
+		expired, _ := order(db, now.Add(-2*time.Minute), 2)
+		_, err := order(db, now, 3)
+		assert.Nil(t, err)
+
+		_, err = db.UpdateTransactionStatus(context.Background(), expired.ID, TransactionStatusPaid, "admin")
+		assert.Equal(t, ErrInsufficientStock, err)
+
+		// the failed payment changed nothing
+		trans, _ := db.GetTransactionByTransactionID(context.Background(), expired.ID)
+		assert.Equal(t, TransactionStatusPending, trans.Status)
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+	})
+
+	t.Run("expired-order-cancelled-and-not-paid", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.SetReservationTTL(time.Minute)
+		now := time.Now()
+
+		expired, _ := order(db, now.Add(-2*time.Minute), 1)
+		active, _ := order(db, now, 1)
+		// the payment started before the reservation expired is left to the gateway
+		paying, _ := order(db, now.Add(-2*time.Minute), 1)
+		_, err := db.CreatePayment(context.Background(), &PaymentRecord{TransactionID: paying.ID, Gateway: FakeGatewayName, Amount: paying.GrandTotal, Status: PaymentStatusPending, CreatedAt: now.Add(-2 * time.Minute)})
+		assert.Nil(t, err)
+
+		_, err = db.CreatePayment(context.Background(), &PaymentRecord{TransactionID: expired.ID, Gateway: FakeGatewayName, Amount: expired.GrandTotal, Status: PaymentStatusPending, CreatedAt: now})
+		assert.Equal(t, ErrReservationExpired, err)
+
+		cancelled, err := db.CancelExpiredTransactions(context.Background(), now)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, cancelled)
+		cancelled, _ = db.CancelExpiredTransactions(context.Background(), now)
+		assert.Equal(t, 0, cancelled)
+
+		for id, status := range map[int]string{expired.ID: TransactionStatusCancelled, active.ID: TransactionStatusPending, paying.ID: TransactionStatusPending} {
+			trans, _ := db.GetTransactionByTransactionID(context.Background(), id)
+			assert.Equal(t, status, trans.Status, id)
+		}
+		history := db.statusHistory[expired.ID]
+		assert.Equal(t, systemActor, history[len(history)-1].Actor)
+	})
+}
+
+func TestInMemoryPayment(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	// pay orders 2 units of product 1 and creates a pending payment of it
+	pay := func(db *InMemoryDB, reference string) (*TransactionRecord, *PaymentRecord) {
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}},
+		})
+		assert.Nil(t, err)
+		payment, err := db.CreatePayment(context.Background(), &PaymentRecord{
+			TransactionID: trans.ID,
+			Gateway:       FakeGatewayName,
+			Reference:     reference,
+			Amount:        trans.GrandTotal,
+			Status:        PaymentStatusPending,
+			CreatedAt:     time.Now(),
+		})
+		assert.Nil(t, err)
+		return trans, payment
+	}
+
+	t.Run("error-transaction-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CreatePayment(context.Background(), &PaymentRecord{TransactionID: 99, Gateway: FakeGatewayName, Reference: "fake_1", Status: PaymentStatusPending})
+		assert.Equal(t, ErrTransactionNotFound, err)
+		_, err = db.GetPaymentByReference(context.Background(), FakeGatewayName, "fake_1")
+		assert.Equal(t, ErrPaymentNotFound, err)
+		_, err = db.UpdatePaymentStatus(context.Background(), 99, PaymentStatusCaptured, "admin")
+		assert.Equal(t, ErrPaymentNotFound, err)
+	})
+
+	t.Run("success-capture-pays-refund-restores", func(t *testing.T) {
+		db := NewInMemoryDB()
+		trans, payment := pay(db, "fake_1")
+
+		found, err := db.GetPaymentByReference(context.Background(), FakeGatewayName, "fake_1")
+		assert.Nil(t, err)
+		assert.Equal(t, payment, found)
+		_, err = db.GetPaymentByReference(context.Background(), "other", "fake_1")
+		assert.Equal(t, ErrPaymentNotFound, err)
+
+		captured, err := db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusCaptured, "gateway:fake")
+		assert.Nil(t, err)
+		assert.Equal(t, PaymentStatusCaptured, captured.Status)
+		paid, _ := db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusPaid, paid.Status)
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 1, product.Qty)
+
+		// a repeated event changes nothing
+		_, err = db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusCaptured, "gateway:fake")
+		assert.Nil(t, err)
+		history, _, _ := db.GetTransactionStatusHistory(context.Background(), trans.ID, nil)
+		assert.Len(t, history, 2)
+
+		refunded, err := db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusRefunded, "admin")
+		assert.Nil(t, err)
+		assert.Equal(t, PaymentStatusRefunded, refunded.Status)
+		order, _ := db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusRefunded, order.Status)
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+
+		payments, _, err := db.GetPaymentsByTransactionID(context.Background(), trans.ID, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []*PaymentRecord{refunded}, payments)
+	})
+
+	t.Run("error-invalid-payment-transition", func(t *testing.T) {
+		db := NewInMemoryDB()
+		_, payment := pay(db, "fake_1")
+
+		_, err := db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusFailed, "gateway:fake")
+		assert.Nil(t, err)
+		_, err = db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusCaptured, "gateway:fake")
+		assert.Equal(t, ErrInvalidPaymentTransition, err)
+	})
+
+	t.Run("error-payment-in-progress", func(t *testing.T) {
+		db := NewInMemoryDB()
+		trans, payment := pay(db, "")
+		again := &PaymentRecord{TransactionID: trans.ID, Gateway: FakeGatewayName, Amount: trans.GrandTotal, Status: PaymentStatusPending, CreatedAt: time.Now()}
+
+		// the pending payment waits for the answer of the gateway, its order can not be paid a second time meanwhile
+		_, err := db.CreatePayment(context.Background(), again)
+		assert.Equal(t, ErrPaymentInProgress, err)
+		_, err = db.GetPaymentByReference(context.Background(), FakeGatewayName, "")
+		assert.Equal(t, ErrPaymentNotFound, err)
+
+		declined, err := db.UpdatePaymentAuthorization(context.Background(), payment.ID, &GatewayPayment{Reference: "fake_1", Status: PaymentStatusFailed})
+		assert.Nil(t, err)
+		assert.Equal(t, "fake_1", declined.Reference)
+		_, err = db.UpdatePaymentAuthorization(context.Background(), payment.ID, &GatewayPayment{Reference: "fake_2", Status: PaymentStatusAuthorized})
+		assert.Equal(t, ErrInvalidPaymentTransition, err)
+
+		// a declined payment does not hold the order
+		_, err = db.CreatePayment(context.Background(), again)
+		assert.Nil(t, err)
+
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusCancelled, "donny")
+		assert.Nil(t, err)
+		_, err = db.CreatePayment(context.Background(), again)
+		assert.Equal(t, ErrInvalidStatusTransition, err)
+	})
+
+	t.Run("error-capture-cancelled-order", func(t *testing.T) {
+		db := NewInMemoryDB()
+		trans, payment := pay(db, "fake_1")
+		_, err := db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusCancelled, "donny")
+		assert.Nil(t, err)
+
+		_, err = db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusCaptured, "gateway:fake")
+		assert.Equal(t, ErrInvalidStatusTransition, err)
+
+		// neither the payment nor the order moved
+		payments, _, _ := db.GetPaymentsByTransactionID(context.Background(), trans.ID, nil)
+		assert.Equal(t, PaymentStatusPending, payments[0].Status)
+		order, _ := db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusCancelled, order.Status)
+	})
+
+	t.Run("success-refund-cancelled-order-keeps-it-cancelled", func(t *testing.T) {
+		db := NewInMemoryDB()
+		trans, payment := pay(db, "fake_1")
+		_, err := db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusCaptured, "gateway:fake")
+		assert.Nil(t, err)
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusCancelled, "admin")
+		assert.Nil(t, err)
+
+		_, err = db.UpdatePaymentStatus(context.Background(), payment.ID, PaymentStatusRefunded, "admin")
+		assert.Nil(t, err)
+
+		// the cancel restored the stock already
+		order, _ := db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusCancelled, order.Status)
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+	})
+}
+
+func TestInMemoryAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	newRec := func(label string, isDefault bool) *AddressRecord {
+		return &AddressRecord{UserID: 1, Label: label, PostalAddress: PostalAddress{Recipient: "donny", Phone: "0812", Street: "jl. " + label, City: "surabaya", PostalCode: "60111", Country: "id"}, IsDefault: isDefault, CreatedAt: time.Now()}
+	}
+
+	t.Run("error-user-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CreateAddress(context.Background(), &AddressRecord{UserID: 99})
+		assert.Equal(t, ErrUserNotFound, err)
+		_, err = db.GetDefaultAddress(context.Background(), 99)
+		assert.Equal(t, ErrAddressNotFound, err)
+		_, err = db.DeleteAddress(context.Background(), 99, 1)
+		assert.Equal(t, ErrAddressNotFound, err)
+	})
+
+	t.Run("success-default-address", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		home, err := db.CreateAddress(context.Background(), newRec("home", false))
+		assert.Nil(t, err)
+		assert.True(t, home.IsDefault)
+		assert.Equal(t, "ID", home.Country)
+
+		office, err := db.CreateAddress(context.Background(), newRec("office", true))
+		assert.Nil(t, err)
+		def, err := db.GetDefaultAddress(context.Background(), 1)
+		assert.Nil(t, err)
+		assert.Equal(t, office.ID, def.ID)
+
+		// the default can not be unset, only taken by another address
+		office.IsDefault = false
+		office.City = "jakarta"
+		updated, err := db.UpdateAddress(context.Background(), office)
+		assert.Nil(t, err)
+		assert.True(t, updated.IsDefault)
+		assert.Equal(t, "jakarta", updated.City)
+
+		_, err = db.UpdateAddress(context.Background(), &AddressRecord{ID: home.ID, UserID: 2})
+		assert.Equal(t, ErrAddressNotFound, err)
+
+		// deleting the default makes the oldest address the default
+		_, err = db.DeleteAddress(context.Background(), 1, office.ID)
+		assert.Nil(t, err)
+		addresses, _, err := db.GetAddressesByUserID(context.Background(), 1, nil)
+		assert.Nil(t, err)
+		assert.Len(t, addresses, 1)
+		assert.Equal(t, home.ID, addresses[0].ID)
+		assert.True(t, addresses[0].IsDefault)
+	})
+
+	t.Run("success-order-keeps-its-address", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.SetShippingRateProvider(&FlatShippingRateProvider{Flat: 100, PerItem: 25})
+
+		home, err := db.CreateAddress(context.Background(), newRec("home", true))
+		assert.Nil(t, err)
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			ShippingAddress:   &home.PostalAddress,
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(150), trans.ShippingCost.Amount)
+		assert.Equal(t, trans.Subtotal.Amount+150, trans.GrandTotal.Amount)
+
+		home.Street = "jl. baru"
+		_, err = db.UpdateAddress(context.Background(), home)
+		assert.Nil(t, err)
+
+		order, err := db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "jl. home", order.ShippingAddress.Street)
+	})
+}
+
+func TestInMemoryShipment(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	// paidOrder orders 1 unit of product 1 and pays it
+	paidOrder := func(db *InMemoryDB) *TransactionRecord {
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+		})
+		assert.Nil(t, err)
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusPaid, "admin")
+		assert.Nil(t, err)
+		return trans
+	}
+
+	t.Run("error-pending-order", func(t *testing.T) {
+		db := NewInMemoryDB()
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+		})
+		assert.Nil(t, err)
+
+		_, err = db.CreateShipment(context.Background(), &ShipmentRecord{TransactionID: trans.ID, Carrier: "jne", TrackingNumber: "JNE1"}, "admin")
+		assert.Equal(t, ErrInvalidStatusTransition, err)
+		_, err = db.UpdateShipmentStatus(context.Background(), 99, ShipmentStatusDelivered, "admin")
+		assert.Equal(t, ErrShipmentNotFound, err)
+	})
+
+	t.Run("success-last-delivery-completes", func(t *testing.T) {
+		db := NewInMemoryDB()
+		trans := paidOrder(db)
+
+		first, err := db.CreateShipment(context.Background(), &ShipmentRecord{TransactionID: trans.ID, Carrier: "jne", TrackingNumber: "JNE1", CreatedAt: time.Now()}, "admin")
+		assert.Nil(t, err)
+		assert.Equal(t, ShipmentStatusInTransit, first.Status)
+		second, err := db.CreateShipment(context.Background(), &ShipmentRecord{TransactionID: trans.ID, Carrier: "jne", TrackingNumber: "JNE2", CreatedAt: time.Now()}, "admin")
+		assert.Nil(t, err)
+		_, err = db.CreateShipment(context.Background(), &ShipmentRecord{TransactionID: trans.ID, Carrier: "jne", TrackingNumber: "JNE2"}, "admin")
+		assert.Equal(t, ErrDuplicateTrackingNumber, err)
+
+		order, _ := db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusShipped, order.Status)
+
+		_, err = db.UpdateShipmentStatus(context.Background(), first.ID, ShipmentStatusDelivered, "carrier:jne")
+		assert.Nil(t, err)
+		order, _ = db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusShipped, order.Status)
+
+		_, err = db.UpdateShipmentStatus(context.Background(), second.ID, ShipmentStatusOutForDelivery, "carrier:jne")
+		assert.Nil(t, err)
+		_, err = db.UpdateShipmentStatus(context.Background(), second.ID, ShipmentStatusDelivered, "carrier:jne")
+		assert.Nil(t, err)
+		order, _ = db.GetTransactionByTransactionID(context.Background(), trans.ID)
+		assert.Equal(t, TransactionStatusCompleted, order.Status)
+
+		_, err = db.UpdateShipmentStatus(context.Background(), second.ID, ShipmentStatusReturned, "carrier:jne")
+		assert.Equal(t, ErrInvalidShipmentTransition, err)
+
+		shipments, _, err := db.GetShipmentsByTransactionID(context.Background(), trans.ID, nil)
+		assert.Nil(t, err)
+		assert.Len(t, shipments, 2)
+		assert.Equal(t, ShipmentStatusDelivered, shipments[1].Status)
+	})
+}
+
+func TestInMemoryCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	// tree creates computers > laptops > gaming and computers > desktops
+	tree := func(db *InMemoryDB) {
+		for _, rec := range []*CategoryRecord{
+			{Name: "computers"},
+			{ParentID: 1, Name: "laptops"},
+			{ParentID: 2, Name: "gaming"},
+			{ParentID: 1, Name: "desktops"},
+		} {
+			_, err := db.CreateCategory(context.Background(), rec)
+			assert.Nil(t, err)
+		}
+	}
+
+	t.Run("error-parent-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CreateCategory(context.Background(), &CategoryRecord{ParentID: 100, Name: "laptops"})
+		assert.Equal(t, ErrCategoryNotFound, err)
+		_, err = db.GetCategoryByID(context.Background(), 100)
+		assert.Equal(t, ErrCategoryNotFound, err)
+	})
+
+	t.Run("paths", func(t *testing.T) {
+		db := NewInMemoryDB()
+		tree(db)
+
+		paths, err := db.GetCategoryPaths(context.Background(), []int{4, 3, 4})
+		assert.Nil(t, err)
+		assert.Equal(t, []CategoryPath{
+			{{ID: 1, Name: "computers"}, {ID: 2, ParentID: 1, Name: "laptops"}, {ID: 3, ParentID: 2, Name: "gaming"}},
+			{{ID: 1, Name: "computers"}, {ID: 4, ParentID: 1, Name: "desktops"}},
+		}, paths)
+
+		_, err = db.GetCategoryPaths(context.Background(), []int{1, 100})
+		assert.Equal(t, ErrCategoryNotFound, err)
+	})
+
+	t.Run("products-of-subcategories", func(t *testing.T) {
+		db := NewInMemoryDB()
+		tree(db)
+
+		_, err := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air", Qty: 1, Price: NewMoney(900, CurrencyIDR), CategoryIDs: []int{100}})
+		assert.Equal(t, ErrCategoryNotFound, err)
+
+		created, err := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 3, Name: "rog strix", Qty: 1, Price: NewMoney(1500, CurrencyIDR), CategoryIDs: []int{3, 4, 3}})
+		assert.Nil(t, err)
+		assert.Equal(t, []int{3, 4}, created.CategoryIDs)
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: NewMoney(1200, CurrencyIDR), CategoryIDs: []int{2}}, "admin")
+		assert.Nil(t, err)
+
+		products, _, err := db.GetProductsByCategoryID(context.Background(), 1, nil)
+		assert.Nil(t, err)
+		assert.Len(t, products, 2)
+		assert.Equal(t, 1, products[0].ID)
+		assert.Equal(t, created.ID, products[1].ID)
+		assert.Equal(t, []int{3, 4}, products[1].CategoryIDs)
+		assert.Len(t, products[1].Categories, 2)
+		assert.Equal(t, "gaming", products[1].Categories[0].Category().Name)
+
+		products, _, err = db.GetProductsByCategoryID(context.Background(), 4, nil)
+		assert.Nil(t, err)
+		assert.Len(t, products, 1)
+
+		products, _, err = db.GetProductsByCategoryID(context.Background(), 100, nil)
+		assert.Nil(t, err)
+		assert.Len(t, products, 0)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		db := NewInMemoryDB()
+		tree(db)
+
+		_, err := db.UpdateCategory(context.Background(), &CategoryRecord{ID: 1, ParentID: 3, Name: "computers"})
+		assert.Equal(t, ErrInvalidCategoryParent, err)
+		_, err = db.UpdateCategory(context.Background(), &CategoryRecord{ID: 2, ParentID: 2, Name: "laptops"})
+		assert.Equal(t, ErrInvalidCategoryParent, err)
+		_, err = db.UpdateCategory(context.Background(), &CategoryRecord{ID: 2, ParentID: 100, Name: "laptops"})
+		assert.Equal(t, ErrCategoryNotFound, err)
+		_, err = db.UpdateCategory(context.Background(), &CategoryRecord{ID: 100, Name: "laptops"})
+		assert.Equal(t, ErrCategoryNotFound, err)
+
+		_, err = db.UpdateCategory(context.Background(), &CategoryRecord{ID: 3, ParentID: 4, Name: "gaming rigs"})
+		assert.Nil(t, err)
+		category, _ := db.GetCategoryByID(context.Background(), 3)
+		assert.Equal(t, &CategoryRecord{ID: 3, ParentID: 4, Name: "gaming rigs"}, category)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		db := NewInMemoryDB()
+		tree(db)
+		_, err := db.UpdateProduct(context.Background(), &ProductRecord{ID: 3, BrandID: 3, Name: "rog", Qty: 1, Price: NewMoney(1100, CurrencyIDR), CategoryIDs: []int{3, 4}}, "admin")
+		assert.Nil(t, err)
+
+		_, err = db.DeleteCategory(context.Background(), 2)
+		assert.Equal(t, ErrCategoryHasChildren, err)
+		_, err = db.DeleteCategory(context.Background(), 100)
+		assert.Equal(t, ErrCategoryNotFound, err)
+
+		_, err = db.DeleteCategory(context.Background(), 3)
+		assert.Nil(t, err)
+		product, _ := db.GetProductByID(context.Background(), 3)
+		assert.Equal(t, []int{4}, product.CategoryIDs)
+
+		categories, total, _ := db.GetCategories(context.Background(), nil)
+		assert.Len(t, categories, 3)
+		assert.Equal(t, 3, total)
+
+		paged, total, _ := db.GetCategories(context.Background(), &pagination.Page{Limit: 1, After: pagination.IDCursor(categories[0].ID)})
+		assert.Equal(t, []*CategoryRecord{categories[1]}, paged)
+		assert.Equal(t, 3, total)
+	})
+}
+
+func TestInMemoryUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.GetUserByID(context.Background(), 100)
+		assert.Equal(t, ErrUserNotFound, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		user, err := db.GetUserByID(context.Background(), 1)
+		assert.Nil(t, err)
+		assert.Equal(t, "donny@arieffian.com", user.Email)
+	})
+
+	t.Run("error-duplicate-email", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CreateUser(context.Background(), &UserRecord{Name: "donny", Email: "DONNY@arieffian.com"})
+		assert.Equal(t, ErrDuplicateEmail, err)
+	})
+
+	t.Run("success-soft-delete", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		created, err := db.CreateUser(context.Background(), &UserRecord{Name: "jane", Email: "jane@example.com"})
+		assert.Nil(t, err)
+
+		_, err = db.UpdateUser(context.Background(), &UserRecord{ID: created.ID, Name: "jane doe", Email: "jane@example.com", Address: "bandung"})
+		assert.Nil(t, err)
+
+		user, _ := db.GetUserByID(context.Background(), created.ID)
+		assert.Equal(t, "jane doe", user.Name)
+
+		_, err = db.DeleteUser(context.Background(), created.ID)
+		assert.Nil(t, err)
+
+		_, err = db.GetUserByID(context.Background(), created.ID)
+		assert.Equal(t, ErrUserNotFound, err)
+
+		users, _, _ := db.GetUsers(context.Background(), nil)
+		for _, u := range users {
+			assert.NotEqual(t, created.ID, u.ID)
+		}
+
+		// the email of a soft deleted user stays reserved
+		_, err = db.CreateUser(context.Background(), &UserRecord{Name: "jane", Email: "jane@example.com"})
+		assert.Equal(t, ErrDuplicateEmail, err)
+	})
+}
+
+func TestInMemoryTransactionsByUserID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	db := NewInMemoryDB()
+	user, _ := db.CreateUser(context.Background(), &UserRecord{Name: "jane", Email: "jane@example.com"})
+	product, _ := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air", Qty: 10, Price: NewMoney(900, CurrencyIDR)})
+	base := time.Date(2021, 3, 1, 10, 0, 0, 0, time.Local)
+	// two orders share a date to exercise the id tie breaker
+	dates := []time.Time{base, base.AddDate(0, 0, 1), base.AddDate(0, 0, 1), base.AddDate(0, 0, 2)}
+	for _, date := range dates {
+		_, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            user.ID,
+			Date:              date,
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: product.ID, Qty: 1}},
+		})
+		assert.Nil(t, err)
+	}
+
+	t.Run("success-pages-newest-first", func(t *testing.T) {
+		page, total, err := db.GetTransactionsByUserID(context.Background(), &TransactionFilter{UserID: user.ID, Page: &pagination.Page{Limit: 2}})
+		assert.Nil(t, err)
+		assert.Len(t, page, 2)
+		assert.Equal(t, 5, page[0].ID)
+		assert.Equal(t, 4, page[1].ID)
+		assert.Len(t, page[0].TransactionDetail, 1)
+		assert.Equal(t, 4, total)
+
+		page, _, err = db.GetTransactionsByUserID(context.Background(), &TransactionFilter{
+			UserID: user.ID,
+			Page:   &pagination.Page{Limit: 2, After: TransactionCursor(page[1])},
+		})
+		assert.Nil(t, err)
+		assert.Len(t, page, 2)
+		assert.Equal(t, 3, page[0].ID)
+		assert.Equal(t, 2, page[1].ID)
+
+		page, _, err = db.GetTransactionsByUserID(context.Background(), &TransactionFilter{
+			UserID: user.ID,
+			Page:   &pagination.Page{Limit: 2, Before: TransactionCursor(page[0])},
+		})
+		assert.Nil(t, err)
+		assert.Len(t, page, 2)
+		assert.Equal(t, 5, page[0].ID)
+		assert.Equal(t, 4, page[1].ID)
+	})
+
+	t.Run("success-date-range", func(t *testing.T) {
+		page, _, err := db.GetTransactionsByUserID(context.Background(), &TransactionFilter{
+			UserID: user.ID,
+			From:   base.AddDate(0, 0, 1),
+			To:     base.AddDate(0, 0, 2),
+		})
+		assert.Nil(t, err)
+		assert.Len(t, page, 2)
+		assert.Equal(t, 4, page[0].ID)
+		assert.Equal(t, 3, page[1].ID)
+	})
+
+	t.Run("success-other-user", func(t *testing.T) {
+		page, _, err := db.GetTransactionsByUserID(context.Background(), &TransactionFilter{UserID: 1})
+		assert.Nil(t, err)
+		assert.Len(t, page, 1)
+		assert.Equal(t, 1, page[0].ID)
+	})
+}
+
+func TestInMemoryCart(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+	item := func(productID int, qty int) *CartItemRecord {
+		return &CartItemRecord{UserID: 1, ProductID: productID, Qty: qty, AddedAt: now}
+	}
+
+	t.Run("add-update-remove", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.AddCartItem(context.Background(), item(100, 1))
+		assert.Equal(t, ErrProductNotFound, err)
+
+		db.AddCartItem(context.Background(), item(2, 1))
+		db.AddCartItem(context.Background(), item(1, 1))
+		cart, err := db.AddCartItem(context.Background(), item(2, 2))
+		assert.Nil(t, err)
+
+		// product 2 is added again, it keeps its place and adds up its qty beyond the stock of 2
+		assert.Len(t, cart.Items, 2)
+		assert.Equal(t, 2, cart.Items[0].ProductID)
+		assert.Equal(t, 3, cart.Items[0].Qty)
+		assert.Equal(t, NewMoney(3000, CurrencyIDR), cart.Items[0].SubTotal)
+		assert.Equal(t, 2, cart.Items[0].AvailableQty)
+		assert.False(t, cart.Items[0].InStock)
+		assert.Equal(t, "macbook pro", cart.Items[1].Name)
+		assert.True(t, cart.Items[1].InStock)
+		assert.Equal(t, NewMoney(4200, CurrencyIDR), cart.Subtotal)
+
+		cart, err = db.UpdateCartItem(context.Background(), item(2, 1))
+		assert.Nil(t, err)
+		assert.True(t, cart.Items[0].InStock)
+		assert.Equal(t, NewMoney(2200, CurrencyIDR), cart.Subtotal)
+
+		_, err = db.UpdateCartItem(context.Background(), item(3, 1))
+		assert.Equal(t, ErrCartItemNotFound, err)
+
+		cart, err = db.RemoveCartItem(context.Background(), 1, 2)
+		assert.Nil(t, err)
+		assert.Len(t, cart.Items, 1)
+
+		_, err = db.RemoveCartItem(context.Background(), 1, 2)
+		assert.Equal(t, ErrCartItemNotFound, err)
+
+		// the stored cart is not changed through the returned one
+		cart.Items[0].Qty = 10
+		cart, _ = db.GetCart(context.Background(), 1)
+		assert.Equal(t, 1, cart.Items[0].Qty)
+	})
+
+	t.Run("mixed-currencies", func(t *testing.T) {
+		db := NewInMemoryDB()
+		usd, _ := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "magic mouse", Qty: 5, Price: NewMoney(99, "USD")})
+
+		db.AddCartItem(context.Background(), item(1, 1))
+		cart, err := db.AddCartItem(context.Background(), item(usd.ID, 1))
+		assert.Nil(t, err)
+		assert.Equal(t, Money{}, cart.Subtotal)
+
+		_, err = db.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: now})
+		assert.True(t, errors.Is(err, ErrCurrencyMismatch))
+	})
+
+	t.Run("checkout", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: now})
+		assert.Equal(t, ErrCartEmpty, err)
+
+		db.AddCartItem(context.Background(), item(1, 2))
+		db.AddCartItem(context.Background(), item(2, 3))
+
+		// the order fails on the stock of product 2, the cart is kept for the user to fix it
+		_, err = db.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: now})
+		assert.Equal(t, ErrInsufficientStock, err)
+		cart, _ := db.GetCart(context.Background(), 1)
+		assert.Len(t, cart.Items, 2)
+
+		db.UpdateCartItem(context.Background(), item(2, 1))
+		transaction, err := db.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: now})
+		assert.Nil(t, err)
+		assert.Len(t, transaction.TransactionDetail, 2)
+		assert.Equal(t, 1, transaction.TransactionDetail[0].ProductID)
+		assert.Equal(t, int64(3400), transaction.GrandTotal.Amount)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 1, product.Available)
+
+		cart, _ = db.GetCart(context.Background(), 1)
+		assert.Empty(t, cart.Items)
+		assert.Equal(t, NewMoney(0, CurrencyIDR), cart.Subtotal)
+	})
+
+	t.Run("deleted-product-leaves-cart", func(t *testing.T) {
+		db := NewInMemoryDB()
+		// created without qty, so no stock movement keeps the product
+		product, _ := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air", Qty: 0, Price: NewMoney(900, CurrencyIDR)})
+
+		db.AddCartItem(context.Background(), item(product.ID, 1))
+		_, err := db.DeleteProduct(context.Background(), product.ID)
+		assert.Nil(t, err)
+
+		cart, err := db.GetCart(context.Background(), 1)
+		assert.Nil(t, err)
+		assert.Empty(t, cart.Items)
+	})
+}
+
+func TestInMemoryVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	newVariant := func(db *InMemoryDB) *ProductVariantRecord {
+		variant, err := db.CreateVariant(context.Background(), &ProductVariantRecord{
+			ProductID: 1,
+			SKU:       "MBP-SLV",
+			Options:   map[string]string{"color": "silver"},
+			Price:     NewMoney(1500, CurrencyIDR),
+			Qty:       4,
+		})
+		assert.Nil(t, err)
+		return variant
+	}
+
+	t.Run("crud", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		_, err := db.CreateVariant(context.Background(), &ProductVariantRecord{ProductID: 100, SKU: "X"})
+		assert.Equal(t, ErrProductNotFound, err)
+
+		variant := newVariant(db)
+		assert.Equal(t, 1, variant.ID)
+		assert.Equal(t, 4, variant.Available)
+
+		_, err = db.CreateVariant(context.Background(), &ProductVariantRecord{ProductID: 2, SKU: "MBP-SLV"})
+		assert.Equal(t, ErrDuplicateSKU, err)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 7, product.Available)
+		assert.Equal(t, []*ProductVariantRecord{variant}, product.Variants)
+
+		variant.Qty = 6
+		variant.Options = map[string]string{"color": "space grey"}
+		_, err = db.UpdateVariant(context.Background(), variant, "")
+		assert.Equal(t, ErrActorRequired, err)
+		_, err = db.UpdateVariant(context.Background(), variant, "admin")
+		assert.Nil(t, err)
+		got, err := db.GetVariantByID(context.Background(), variant.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "space grey", got.Options["color"])
+		assert.Equal(t, 6, got.Available)
+
+		movements, _, _ := db.GetStockMovements(context.Background(), 1, nil)
+		last := movements[len(movements)-1]
+		assert.Equal(t, variant.ID, last.VariantID)
+		assert.Equal(t, 2, last.Delta)
+		assert.Equal(t, StockReasonAdjustment, last.Reason)
+		assert.Equal(t, "admin", last.Actor)
+
+		_, err = db.DeleteVariant(context.Background(), variant.ID)
+		assert.Nil(t, err)
+		_, err = db.GetVariantByID(context.Background(), variant.ID)
+		assert.Equal(t, ErrVariantNotFound, err)
+		_, err = db.DeleteVariant(context.Background(), variant.ID)
+		assert.Equal(t, ErrVariantNotFound, err)
+	})
+
+	t.Run("error-variant-of-other-product", func(t *testing.T) {
+		db := NewInMemoryDB()
+		variant := newVariant(db)
+
+		_, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 2, VariantID: variant.ID, Qty: 1}},
+		})
+		assert.Equal(t, ErrVariantNotFound, err)
+	})
+
+	t.Run("order-takes-variant-stock", func(t *testing.T) {
+		db := NewInMemoryDB()
+		variant := newVariant(db)
+
+		_, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, VariantID: variant.ID, Qty: 5}},
+		})
+		assert.Equal(t, ErrInsufficientStock, err)
+
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, VariantID: variant.ID, Qty: 3}, {ProductID: 1, Qty: 1}},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, int64(4500), trans.TransactionDetail[0].SubTotal.Amount)
+		assert.Equal(t, int64(1200), trans.TransactionDetail[1].SubTotal.Amount)
+
+		// the pending order reserves the variant apart from the product itself
+		got, _ := db.GetVariantByID(context.Background(), variant.ID)
+		assert.Equal(t, 1, got.Available)
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Available)
+
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusPaid, "admin")
+		assert.Nil(t, err)
+		got, _ = db.GetVariantByID(context.Background(), variant.ID)
+		assert.Equal(t, 1, got.Qty)
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 2, product.Qty)
+
+		_, err = db.DeleteVariant(context.Background(), variant.ID)
+		assert.Equal(t, ErrVariantHasOrders, err)
+
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusCancelled, "admin")
+		assert.Nil(t, err)
+		got, _ = db.GetVariantByID(context.Background(), variant.ID)
+		assert.Equal(t, 4, got.Qty)
+		product, _ = db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+	})
+}
+
+func TestInMemoryWarehouse(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	newWarehouse := func(db *InMemoryDB) *WarehouseRecord {
+		warehouse, err := db.CreateWarehouse(context.Background(), &WarehouseRecord{Code: "SUB", Name: "surabaya warehouse", City: "surabaya", Country: "ID"})
+		assert.Nil(t, err)
+		return warehouse
+	}
+
+	t.Run("crud", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		warehouse := newWarehouse(db)
+		assert.Equal(t, 2, warehouse.ID)
+
+		_, err := db.CreateWarehouse(context.Background(), &WarehouseRecord{Code: "MAIN", Name: "another main", City: "jakarta", Country: "ID"})
+		assert.Equal(t, ErrDuplicateWarehouseCode, err)
+
+		warehouse.Code = "MAIN"
+		_, err = db.UpdateWarehouse(context.Background(), warehouse)
+		assert.Equal(t, ErrDuplicateWarehouseCode, err)
+
+		warehouse.Code = "SBY"
+		_, err = db.UpdateWarehouse(context.Background(), warehouse)
+		assert.Nil(t, err)
+		got, err := db.GetWarehouseByID(context.Background(), warehouse.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "SBY", got.Code)
+
+		_, err = db.UpdateWarehouse(context.Background(), &WarehouseRecord{ID: 100, Code: "X"})
+		assert.Equal(t, ErrWarehouseNotFound, err)
+		_, err = db.GetWarehouseByID(context.Background(), 100)
+		assert.Equal(t, ErrWarehouseNotFound, err)
+
+		warehouses, _, _ := db.GetWarehouses(context.Background(), nil)
+		assert.Equal(t, 2, len(warehouses))
+		assert.Equal(t, DefaultWarehouseID, warehouses[0].ID)
+	})
+
+	t.Run("transfer", func(t *testing.T) {
+		db := NewInMemoryDB()
+		warehouse := newWarehouse(db)
+
+		_, err := db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: 100, ProductID: 1, Qty: 1, Actor: "admin"})
+		assert.Equal(t, ErrWarehouseNotFound, err)
+		_, err = db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: warehouse.ID, ProductID: 1, VariantID: 100, Qty: 1, Actor: "admin"})
+		assert.Equal(t, ErrVariantNotFound, err)
+		_, err = db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: warehouse.ID, ProductID: 1, Qty: 4, Actor: "admin"})
+		assert.Equal(t, ErrInsufficientStock, err)
+
+		movements, err := db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: warehouse.ID, ProductID: 1, Qty: 2, Actor: "admin"})
+		assert.Nil(t, err)
+		assert.Equal(t, -2, movements[0].Delta)
+		assert.Equal(t, warehouse.ID, movements[1].WarehouseID)
+		assert.Equal(t, StockReasonTransfer, movements[1].Reason)
+
+		product, _ := db.GetProductByID(context.Background(), 1)
+		assert.Equal(t, 3, product.Qty)
+		stock, _, _ := db.GetProductWarehouseStock(context.Background(), 1, nil)
+		assert.Equal(t, []*WarehouseStockRecord{
+			{WarehouseID: DefaultWarehouseID, ProductID: 1, Qty: 1},
+			{WarehouseID: warehouse.ID, ProductID: 1, Qty: 2},
+		}, stock)
+		paged, total, err := db.GetProductWarehouseStock(context.Background(), 1, &pagination.Page{Limit: 1, After: ProductWarehouseStockCursor(stock[0])})
+		assert.Nil(t, err)
+		assert.Equal(t, stock[1:], paged)
+		assert.Equal(t, 2, total)
+
+		// the default warehouse only has 1 left to take a reduction of the qty from
+		product.Qty = 1
+		_, err = db.UpdateProduct(context.Background(), product, "admin")
+		assert.Equal(t, ErrInsufficientStock, err)
+
+		_, err = db.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 1, WarehouseID: 100, Delta: 1, Reason: StockReasonRestock, Actor: "admin"})
+		assert.Equal(t, ErrWarehouseNotFound, err)
+		product, err = db.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 1, WarehouseID: warehouse.ID, Delta: 1, Reason: StockReasonRestock, Actor: "admin"})
+		assert.Nil(t, err)
+		assert.Equal(t, 4, product.Qty)
+		stock, _, _ = db.GetWarehouseStock(context.Background(), warehouse.ID, nil)
+		assert.Equal(t, []*WarehouseStockRecord{{WarehouseID: warehouse.ID, ProductID: 1, Qty: 3}}, stock)
+	})
+
+	t.Run("order-allocated-to-warehouses", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.SetAllocationStrategy(SplitStrategy{})
+		warehouse := newWarehouse(db)
+		_, err := db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: warehouse.ID, ProductID: 1, Qty: 2, Actor: "admin"})
+		assert.Nil(t, err)
+
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}},
+		})
+		assert.Nil(t, err)
+		assert.Empty(t, trans.TransactionDetail[0].Allocations)
+
+		paid, err := db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusPaid, "donny")
+		assert.Nil(t, err)
+		assert.Equal(t, []*AllocationRecord{{WarehouseID: DefaultWarehouseID, Qty: 1}, {WarehouseID: warehouse.ID, Qty: 1}}, paid.TransactionDetail[0].Allocations)
+		stock, _, _ := db.GetProductWarehouseStock(context.Background(), 1, nil)
+		assert.Equal(t, []*WarehouseStockRecord{{WarehouseID: warehouse.ID, ProductID: 1, Qty: 1}}, stock)
+
+		_, err = db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusCancelled, "donny")
+		assert.Nil(t, err)
+		stock, _, _ = db.GetProductWarehouseStock(context.Background(), 1, nil)
+		assert.Equal(t, []*WarehouseStockRecord{
+			{WarehouseID: DefaultWarehouseID, ProductID: 1, Qty: 1},
+			{WarehouseID: warehouse.ID, ProductID: 1, Qty: 2},
+		}, stock)
+		movements, _, _ := db.GetStockMovements(context.Background(), 1, nil)
+		last := movements[len(movements)-1]
+		assert.Equal(t, warehouse.ID, last.WarehouseID)
+		assert.Equal(t, StockReasonCancel, last.Reason)
+	})
+
+	t.Run("order-takes-reserved-warehouses", func(t *testing.T) {
+		db := NewInMemoryDB()
+		warehouse := newWarehouse(db)
+		_, err := db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: warehouse.ID, ProductID: 1, Qty: 2, Actor: "admin"})
+		assert.Nil(t, err)
+
+		// the first order is reserved in the only warehouse holding all of it, the second one in what is left
+		first, err := db.CreateTransaction(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}}})
+		assert.Nil(t, err)
+		second, err := db.CreateTransaction(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}}})
+		assert.Nil(t, err)
+
+		// a strategy configured afterwards does not pick the warehouses of the pending orders again
+		db.SetAllocationStrategy(SplitStrategy{})
+		paid, err := db.UpdateTransactionStatus(context.Background(), second.ID, TransactionStatusPaid, "donny")
+		assert.Nil(t, err)
+		assert.Equal(t, []*AllocationRecord{{WarehouseID: DefaultWarehouseID, Qty: 1}}, paid.TransactionDetail[0].Allocations)
+		paid, err = db.UpdateTransactionStatus(context.Background(), first.ID, TransactionStatusPaid, "donny")
+		assert.Nil(t, err)
+		assert.Equal(t, []*AllocationRecord{{WarehouseID: warehouse.ID, Qty: 2}}, paid.TransactionDetail[0].Allocations)
+	})
+
+	t.Run("order-allocated-again-after-transfer", func(t *testing.T) {
+		db := NewInMemoryDB()
+		warehouse := newWarehouse(db)
+
+		trans, err := db.CreateTransaction(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}}})
+		assert.Nil(t, err)
+
+		// the default warehouse the order is reserved in does not hold the qty anymore, the strategy picks again
+		_, err = db.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: DefaultWarehouseID, ToWarehouseID: warehouse.ID, ProductID: 1, Qty: 3, Actor: "admin"})
+		assert.Nil(t, err)
+		paid, err := db.UpdateTransactionStatus(context.Background(), trans.ID, TransactionStatusPaid, "donny")
+		assert.Nil(t, err)
+		assert.Equal(t, []*AllocationRecord{{WarehouseID: warehouse.ID, Qty: 1}}, paid.TransactionDetail[0].Allocations)
+	})
+}
+
+func TestInMemorySearch(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("typo-ranked-with-facets", func(t *testing.T) {
+		db := NewInMemoryDB()
+		db.SetSearchPriceBuckets([]int64{1100})
+
+		_, err := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 1, Name: "macbook air", Qty: 1, Price: NewMoney(900, CurrencyIDR)})
+		assert.Nil(t, err)
+
+		result, err := db.SearchProducts(context.Background(), &SearchQuery{Query: "macbok pro", Limit: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, result.Total)
+		assert.Equal(t, 1, result.Hits[0].ID)
+		assert.Equal(t, 4, result.Hits[1].ID)
+		assert.Equal(t, []*BrandFacet{{BrandID: 1, Name: "apple", Count: 2}}, result.Brands)
+		upper := int64(1100)
+		assert.Equal(t, []*PriceBucketFacet{
+			{Currency: CurrencyIDR, Max: &upper, Count: 1},
+			{Currency: CurrencyIDR, Min: 1100, Count: 1},
+		}, result.PriceBuckets)
+
+		result, err = db.SearchProducts(context.Background(), &SearchQuery{Query: "macbook", Limit: 1, Offset: 1})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, result.Total)
+		assert.Equal(t, 1, len(result.Hits))
+
+		result, err = db.SearchProducts(context.Background(), &SearchQuery{Query: " - ", Limit: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, 0, result.Total)
+		assert.Equal(t, 0, len(result.Hits))
+	})
+
+	t.Run("kept-in-sync", func(t *testing.T) {
+		db := NewInMemoryDB()
+
+		category, err := db.CreateCategory(context.Background(), &CategoryRecord{Name: "gaming"})
+		assert.Nil(t, err)
+		_, err = db.UpdateProduct(context.Background(), &ProductRecord{ID: 2, BrandID: 2, Name: "legion 5", Qty: 2, Price: NewMoney(1000, CurrencyIDR), CategoryIDs: []int{category.ID}}, "admin")
+		assert.Nil(t, err)
+
+		result, _ := db.SearchProducts(context.Background(), &SearchQuery{Query: "gaming", Limit: 10})
+		assert.Equal(t, 1, result.Total)
+		assert.Equal(t, "legion 5", result.Hits[0].Name)
+
+		_, err = db.UpdateBrand(context.Background(), &BrandRecord{ID: 3, Name: "rog gaming"})
+		assert.Nil(t, err)
+		result, _ = db.SearchProducts(context.Background(), &SearchQuery{Query: "gaming", Limit: 10})
+		assert.Equal(t, 2, result.Total)
+		assert.Equal(t, 3, result.Hits[0].ID)
+
+		_, err = db.DeleteCategory(context.Background(), category.ID)
+		assert.Nil(t, err)
+		_, err = db.DeleteProduct(context.Background(), 3)
+		assert.Equal(t, ErrProductHasOrders, err)
+		result, _ = db.SearchProducts(context.Background(), &SearchQuery{Query: "gaming", Limit: 10})
+		assert.Equal(t, 1, result.Total)
+
+		product, err := db.CreateProduct(context.Background(), &ProductRecord{BrandID: 2, Name: "thinkpad", Price: NewMoney(1000, CurrencyIDR)})
+		assert.Nil(t, err)
+		result, _ = db.SearchProducts(context.Background(), &SearchQuery{Query: "thinkpda", Limit: 10})
+		assert.Equal(t, 1, result.Total)
+		_, err = db.DeleteProduct(context.Background(), product.ID)
+		assert.Nil(t, err)
+		result, _ = db.SearchProducts(context.Background(), &SearchQuery{Query: "thinkpad", Limit: 10})
+		assert.Equal(t, 0, result.Total)
+	})
+}
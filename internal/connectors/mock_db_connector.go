@@ -2,13 +2,15 @@ package connectors
 
 import (
 	"context"
+	"time"
 
+	"github.com/arieffian/mw-backend-test/internal/pagination"
 	"github.com/stretchr/testify/mock"
 )
 
 type MockDb struct{}
 
-//MockDBType ...
+// MockDBType ...
 type MockDBType struct {
 	mock.Mock
 }
@@ -20,15 +22,33 @@ func (m *MockDBType) GetBrandByID(ctx context.Context, brandID int) (*BrandRecor
 }
 
 // CreateBrand insert an entity record of brand into database.
-func (m *MockDBType) CreateBrand(ctx context.Context, rec *BrandRecord) (string, error) {
+func (m *MockDBType) CreateBrand(ctx context.Context, rec *BrandRecord) (*BrandRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*BrandRecord), args.Error(1)
+}
+
+// GetBrands retrieves every BrandRecord from database ordered by brand id.
+func (m *MockDBType) GetBrands(ctx context.Context, page *pagination.Page) ([]*BrandRecord, int, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]*BrandRecord), args.Int(1), args.Error(2)
+}
+
+// UpdateBrand update an entity record of brand in database where the brand id is specified.
+func (m *MockDBType) UpdateBrand(ctx context.Context, rec *BrandRecord) (string, error) {
 	args := m.Called(ctx, rec)
 	return args.String(0), args.Error(1)
 }
 
+// DeleteBrand delete an entity record of brand from database where the brand id is specified.
+func (m *MockDBType) DeleteBrand(ctx context.Context, brandID int) (string, error) {
+	args := m.Called(ctx, brandID)
+	return args.String(0), args.Error(1)
+}
+
 // CreateProduct insert an entity record of product into database.
-func (m *MockDBType) CreateProduct(ctx context.Context, rec *ProductRecord) (string, error) {
+func (m *MockDBType) CreateProduct(ctx context.Context, rec *ProductRecord) (*ProductRecord, error) {
 	args := m.Called(ctx, rec)
-	return args.String(0), args.Error(1)
+	return args.Get(0).(*ProductRecord), args.Error(1)
 }
 
 // GetProductByID retrieves an ProductRecord from database where the product id is specified.
@@ -38,10 +58,57 @@ func (m *MockDBType) GetProductByID(ctx context.Context, productID int) (*Produc
 }
 
 // GetProductByBrandID retrieves an array of ProductRecord from database where the brand id is specified.
-func (m *MockDBType) GetProductByBrandID(ctx context.Context, brandID int) ([]*ProductRecord, error) {
-	pList := make([]*ProductRecord, 0)
-	args := m.Called(ctx, brandID)
-	return pList, args.Error(1)
+func (m *MockDBType) GetProductByBrandID(ctx context.Context, brandID int, page *pagination.Page) ([]*ProductRecord, int, error) {
+	args := m.Called(ctx, brandID, page)
+	return args.Get(0).([]*ProductRecord), args.Int(1), args.Error(2)
+}
+
+// GetProducts retrieves the products matching the filter, sorted and paged as the filter asks.
+func (m *MockDBType) GetProducts(ctx context.Context, filter *ProductFilter) ([]*ProductRecord, int, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]*ProductRecord), args.Int(1), args.Error(2)
+}
+
+// GetProductsByCategoryID retrieves the products listed in a category or any of its subcategories.
+func (m *MockDBType) GetProductsByCategoryID(ctx context.Context, categoryID int, page *pagination.Page) ([]*ProductRecord, int, error) {
+	args := m.Called(ctx, categoryID, page)
+	return args.Get(0).([]*ProductRecord), args.Int(1), args.Error(2)
+}
+
+// UpdateProduct update an entity record of product in database where the product id is specified.
+func (m *MockDBType) UpdateProduct(ctx context.Context, rec *ProductRecord, actor string) (string, error) {
+	args := m.Called(ctx, rec, actor)
+	return args.String(0), args.Error(1)
+}
+
+// DeleteProduct delete an entity record of product from database where the product id is specified.
+func (m *MockDBType) DeleteProduct(ctx context.Context, productID int) (string, error) {
+	args := m.Called(ctx, productID)
+	return args.String(0), args.Error(1)
+}
+
+// AdjustStock adds the delta of rec to the qty of its product and records rec in the stock movements.
+func (m *MockDBType) AdjustStock(ctx context.Context, rec *StockMovementRecord) (*ProductRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*ProductRecord), args.Error(1)
+}
+
+// GetStockMovements retrieves the stock movements of a product, oldest first.
+func (m *MockDBType) GetStockMovements(ctx context.Context, productID int, page *pagination.Page) ([]*StockMovementRecord, int, error) {
+	args := m.Called(ctx, productID, page)
+	return args.Get(0).([]*StockMovementRecord), args.Int(1), args.Error(2)
+}
+
+// CreateTransactionIdempotent is CreateTransaction guarded by the idempotency key of idem.
+func (m *MockDBType) CreateTransactionIdempotent(ctx context.Context, rec *TransactionRecord, idem *IdempotencyRecord, respond IdempotentResponse) (*IdempotencyRecord, bool, error) {
+	args := m.Called(ctx, rec, idem, respond)
+	return args.Get(0).(*IdempotencyRecord), args.Bool(1), args.Error(2)
+}
+
+// DeleteExpiredIdempotencyKeys deletes the idempotency keys that expired at or before now and returns how many were deleted.
+func (m *MockDBType) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
 }
 
 // GetTransactionByTransactionID retrieves the detail of a transaction from database where the transaction id is specified.
@@ -51,9 +118,27 @@ func (m *MockDBType) GetTransactionByTransactionID(ctx context.Context, transact
 }
 
 // CreateTransaction insert an entity record of transaction into database.
-func (m *MockDBType) CreateTransaction(ctx context.Context, rec *TransactionRecord) (string, error) {
+func (m *MockDBType) CreateTransaction(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error) {
 	args := m.Called(ctx, rec)
-	return args.String(0), args.Error(1)
+	return args.Get(0).(*TransactionRecord), args.Error(1)
+}
+
+// UpdateTransactionStatus moves a transaction to status and records the change in its status history.
+func (m *MockDBType) UpdateTransactionStatus(ctx context.Context, transactionID int, status string, actor string) (*TransactionRecord, error) {
+	args := m.Called(ctx, transactionID, status, actor)
+	return args.Get(0).(*TransactionRecord), args.Error(1)
+}
+
+// GetTransactionStatusHistory retrieves the status changes of a transaction, oldest first.
+func (m *MockDBType) GetTransactionStatusHistory(ctx context.Context, transactionID int, page *pagination.Page) ([]*TransactionStatusHistoryRecord, int, error) {
+	args := m.Called(ctx, transactionID, page)
+	return args.Get(0).([]*TransactionStatusHistoryRecord), args.Int(1), args.Error(2)
+}
+
+// GetTransactionsByUserID retrieves the transactions of a user with their detail, newest first.
+func (m *MockDBType) GetTransactionsByUserID(ctx context.Context, filter *TransactionFilter) ([]*TransactionRecord, int, error) {
+	args := m.Called(ctx, filter)
+	return args.Get(0).([]*TransactionRecord), args.Int(1), args.Error(2)
 }
 
 // GetUserByID retrieves an UserRecord from database where the user id is specified.
@@ -61,3 +146,285 @@ func (m *MockDBType) GetUserByID(ctx context.Context, userID int) (*UserRecord,
 	args := m.Called(ctx, userID)
 	return args.Get(0).(*UserRecord), args.Error(1)
 }
+
+// GetUsers retrieves every UserRecord that is not soft deleted from database ordered by user id.
+func (m *MockDBType) GetUsers(ctx context.Context, page *pagination.Page) ([]*UserRecord, int, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]*UserRecord), args.Int(1), args.Error(2)
+}
+
+// CreateUser insert an entity record of user into database and returns the persisted record.
+func (m *MockDBType) CreateUser(ctx context.Context, rec *UserRecord) (*UserRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*UserRecord), args.Error(1)
+}
+
+// UpdateUser update an entity record of user in database where the user id is specified.
+func (m *MockDBType) UpdateUser(ctx context.Context, rec *UserRecord) (string, error) {
+	args := m.Called(ctx, rec)
+	return args.String(0), args.Error(1)
+}
+
+// DeleteUser soft delete an entity record of user where the user id is specified.
+func (m *MockDBType) DeleteUser(ctx context.Context, userID int) (string, error) {
+	args := m.Called(ctx, userID)
+	return args.String(0), args.Error(1)
+}
+
+// CreateCoupon insert an entity record of coupon into database and returns the persisted record.
+func (m *MockDBType) CreateCoupon(ctx context.Context, rec *CouponRecord) (*CouponRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*CouponRecord), args.Error(1)
+}
+
+// GetCouponByID retrieves an CouponRecord from database where the coupon id is specified.
+func (m *MockDBType) GetCouponByID(ctx context.Context, couponID int) (*CouponRecord, error) {
+	args := m.Called(ctx, couponID)
+	return args.Get(0).(*CouponRecord), args.Error(1)
+}
+
+// GetCoupons retrieves every CouponRecord from database ordered by coupon id.
+func (m *MockDBType) GetCoupons(ctx context.Context, page *pagination.Page) ([]*CouponRecord, int, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]*CouponRecord), args.Int(1), args.Error(2)
+}
+
+// GetCart retrieves the cart of a user with the current price and stock of every product in it.
+func (m *MockDBType) GetCart(ctx context.Context, userID int) (*CartRecord, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(*CartRecord), args.Error(1)
+}
+
+// AddCartItem adds the qty of rec to the cart of its user and returns the updated cart.
+func (m *MockDBType) AddCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*CartRecord), args.Error(1)
+}
+
+// UpdateCartItem replaces the qty of a product in the cart of a user and returns the updated cart.
+func (m *MockDBType) UpdateCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*CartRecord), args.Error(1)
+}
+
+// RemoveCartItem removes a product from the cart of a user and returns the updated cart.
+func (m *MockDBType) RemoveCartItem(ctx context.Context, userID int, productID int) (*CartRecord, error) {
+	args := m.Called(ctx, userID, productID)
+	return args.Get(0).(*CartRecord), args.Error(1)
+}
+
+// CheckoutCart creates the transaction of the items in the cart of rec.UserID and empties the cart.
+func (m *MockDBType) CheckoutCart(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*TransactionRecord), args.Error(1)
+}
+
+// ReleaseExpiredReservations deletes the stock reservations that expired at or before now and returns how many were deleted.
+func (m *MockDBType) ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
+}
+
+// CancelExpiredTransactions cancels the pending transactions whose reservation expired at or before now and returns how many were cancelled.
+func (m *MockDBType) CancelExpiredTransactions(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
+}
+
+// CreatePayment insert an entity record of payment into database and returns the persisted record.
+func (m *MockDBType) CreatePayment(ctx context.Context, rec *PaymentRecord) (*PaymentRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*PaymentRecord), args.Error(1)
+}
+
+// UpdatePaymentAuthorization records the reference and the status the gateway answered a pending payment with.
+func (m *MockDBType) UpdatePaymentAuthorization(ctx context.Context, paymentID int, answer *GatewayPayment) (*PaymentRecord, error) {
+	args := m.Called(ctx, paymentID, answer)
+	return args.Get(0).(*PaymentRecord), args.Error(1)
+}
+
+// GetPaymentByReference retrieves the PaymentRecord of a gateway with the gateway reference specified.
+func (m *MockDBType) GetPaymentByReference(ctx context.Context, gateway string, reference string) (*PaymentRecord, error) {
+	args := m.Called(ctx, gateway, reference)
+	return args.Get(0).(*PaymentRecord), args.Error(1)
+}
+
+// GetPaymentsByTransactionID retrieves the payments of a transaction, oldest first.
+func (m *MockDBType) GetPaymentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*PaymentRecord, int, error) {
+	args := m.Called(ctx, transactionID, page)
+	return args.Get(0).([]*PaymentRecord), args.Int(1), args.Error(2)
+}
+
+// UpdatePaymentStatus moves a payment to status and its transaction along with it.
+func (m *MockDBType) UpdatePaymentStatus(ctx context.Context, paymentID int, status string, actor string) (*PaymentRecord, error) {
+	args := m.Called(ctx, paymentID, status, actor)
+	return args.Get(0).(*PaymentRecord), args.Error(1)
+}
+
+// CreateAddress insert an entity record of address into database and returns the persisted record.
+func (m *MockDBType) CreateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*AddressRecord), args.Error(1)
+}
+
+// GetAddressByID retrieves an AddressRecord from database where the address id is specified.
+func (m *MockDBType) GetAddressByID(ctx context.Context, addressID int) (*AddressRecord, error) {
+	args := m.Called(ctx, addressID)
+	return args.Get(0).(*AddressRecord), args.Error(1)
+}
+
+// GetDefaultAddress retrieves the default address of a user.
+func (m *MockDBType) GetDefaultAddress(ctx context.Context, userID int) (*AddressRecord, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).(*AddressRecord), args.Error(1)
+}
+
+// GetAddressesByUserID retrieves the address book of a user ordered by address id.
+func (m *MockDBType) GetAddressesByUserID(ctx context.Context, userID int, page *pagination.Page) ([]*AddressRecord, int, error) {
+	args := m.Called(ctx, userID, page)
+	return args.Get(0).([]*AddressRecord), args.Int(1), args.Error(2)
+}
+
+// UpdateAddress update an entity record of address in database where the address id and user id are specified.
+func (m *MockDBType) UpdateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*AddressRecord), args.Error(1)
+}
+
+// DeleteAddress delete an entity record of address from database where the address id and user id are specified.
+func (m *MockDBType) DeleteAddress(ctx context.Context, userID int, addressID int) (string, error) {
+	args := m.Called(ctx, userID, addressID)
+	return args.String(0), args.Error(1)
+}
+
+// CreateShipment insert an entity record of shipment into database and returns the persisted record.
+func (m *MockDBType) CreateShipment(ctx context.Context, rec *ShipmentRecord, actor string) (*ShipmentRecord, error) {
+	args := m.Called(ctx, rec, actor)
+	return args.Get(0).(*ShipmentRecord), args.Error(1)
+}
+
+// GetShipmentsByTransactionID retrieves the shipments of a transaction, oldest first.
+func (m *MockDBType) GetShipmentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*ShipmentRecord, int, error) {
+	args := m.Called(ctx, transactionID, page)
+	return args.Get(0).([]*ShipmentRecord), args.Int(1), args.Error(2)
+}
+
+// UpdateShipmentStatus moves a shipment to status and completes its transaction once every shipment is delivered.
+func (m *MockDBType) UpdateShipmentStatus(ctx context.Context, shipmentID int, status string, actor string) (*ShipmentRecord, error) {
+	args := m.Called(ctx, shipmentID, status, actor)
+	return args.Get(0).(*ShipmentRecord), args.Error(1)
+}
+
+// CreateCategory insert an entity record of category into database.
+func (m *MockDBType) CreateCategory(ctx context.Context, rec *CategoryRecord) (*CategoryRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*CategoryRecord), args.Error(1)
+}
+
+// GetCategoryByID retrieves an CategoryRecord from database where the category id is specified.
+func (m *MockDBType) GetCategoryByID(ctx context.Context, categoryID int) (*CategoryRecord, error) {
+	args := m.Called(ctx, categoryID)
+	return args.Get(0).(*CategoryRecord), args.Error(1)
+}
+
+// GetCategories retrieves every CategoryRecord from database ordered by category id.
+func (m *MockDBType) GetCategories(ctx context.Context, page *pagination.Page) ([]*CategoryRecord, int, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]*CategoryRecord), args.Int(1), args.Error(2)
+}
+
+// GetCategoryPaths retrieves the breadcrumb of every distinct category of categoryIDs, ordered by category id.
+func (m *MockDBType) GetCategoryPaths(ctx context.Context, categoryIDs []int) ([]CategoryPath, error) {
+	args := m.Called(ctx, categoryIDs)
+	return args.Get(0).([]CategoryPath), args.Error(1)
+}
+
+// UpdateCategory update an entity record of category in database where the category id is specified.
+func (m *MockDBType) UpdateCategory(ctx context.Context, rec *CategoryRecord) (string, error) {
+	args := m.Called(ctx, rec)
+	return args.String(0), args.Error(1)
+}
+
+// DeleteCategory delete an entity record of category from database where the category id is specified.
+func (m *MockDBType) DeleteCategory(ctx context.Context, categoryID int) (string, error) {
+	args := m.Called(ctx, categoryID)
+	return args.String(0), args.Error(1)
+}
+
+// CreateVariant insert an entity record of variant into database.
+func (m *MockDBType) CreateVariant(ctx context.Context, rec *ProductVariantRecord) (*ProductVariantRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*ProductVariantRecord), args.Error(1)
+}
+
+// GetVariantByID retrieves an ProductVariantRecord from database where the variant id is specified.
+func (m *MockDBType) GetVariantByID(ctx context.Context, variantID int) (*ProductVariantRecord, error) {
+	args := m.Called(ctx, variantID)
+	return args.Get(0).(*ProductVariantRecord), args.Error(1)
+}
+
+// GetVariantsByProductID retrieves the variants of a product ordered by variant id.
+func (m *MockDBType) GetVariantsByProductID(ctx context.Context, productID int, page *pagination.Page) ([]*ProductVariantRecord, int, error) {
+	args := m.Called(ctx, productID, page)
+	return args.Get(0).([]*ProductVariantRecord), args.Int(1), args.Error(2)
+}
+
+// UpdateVariant update an entity record of variant in database where the variant id is specified.
+func (m *MockDBType) UpdateVariant(ctx context.Context, rec *ProductVariantRecord, actor string) (string, error) {
+	args := m.Called(ctx, rec, actor)
+	return args.String(0), args.Error(1)
+}
+
+// DeleteVariant delete an entity record of variant from database where the variant id is specified.
+func (m *MockDBType) DeleteVariant(ctx context.Context, variantID int) (string, error) {
+	args := m.Called(ctx, variantID)
+	return args.String(0), args.Error(1)
+}
+
+// CreateWarehouse insert an entity record of warehouse into database.
+func (m *MockDBType) CreateWarehouse(ctx context.Context, rec *WarehouseRecord) (*WarehouseRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).(*WarehouseRecord), args.Error(1)
+}
+
+// GetWarehouseByID retrieves an WarehouseRecord from database where the warehouse id is specified.
+func (m *MockDBType) GetWarehouseByID(ctx context.Context, warehouseID int) (*WarehouseRecord, error) {
+	args := m.Called(ctx, warehouseID)
+	return args.Get(0).(*WarehouseRecord), args.Error(1)
+}
+
+// GetWarehouses retrieves every WarehouseRecord from database ordered by warehouse id.
+func (m *MockDBType) GetWarehouses(ctx context.Context, page *pagination.Page) ([]*WarehouseRecord, int, error) {
+	args := m.Called(ctx, page)
+	return args.Get(0).([]*WarehouseRecord), args.Int(1), args.Error(2)
+}
+
+// UpdateWarehouse update an entity record of warehouse in database where the warehouse id is specified.
+func (m *MockDBType) UpdateWarehouse(ctx context.Context, rec *WarehouseRecord) (string, error) {
+	args := m.Called(ctx, rec)
+	return args.String(0), args.Error(1)
+}
+
+// GetWarehouseStock retrieves the stock kept in a warehouse.
+func (m *MockDBType) GetWarehouseStock(ctx context.Context, warehouseID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error) {
+	args := m.Called(ctx, warehouseID, page)
+	return args.Get(0).([]*WarehouseStockRecord), args.Int(1), args.Error(2)
+}
+
+// GetProductWarehouseStock retrieves the stock of a product and of its variants in every warehouse.
+func (m *MockDBType) GetProductWarehouseStock(ctx context.Context, productID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error) {
+	args := m.Called(ctx, productID, page)
+	return args.Get(0).([]*WarehouseStockRecord), args.Int(1), args.Error(2)
+}
+
+// TransferStock moves qty of a product or variant from a warehouse to another.
+func (m *MockDBType) TransferStock(ctx context.Context, rec *StockTransferRecord) ([]*StockMovementRecord, error) {
+	args := m.Called(ctx, rec)
+	return args.Get(0).([]*StockMovementRecord), args.Error(1)
+}
+
+// SearchProducts returns the page of the products matching a query with their facets.
+func (m *MockDBType) SearchProducts(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	args := m.Called(ctx, q)
+	return args.Get(0).(*SearchResult), args.Error(1)
+}
@@ -0,0 +1,120 @@
+package connectors
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+// CurrencyIDR the currency of the seed data, and of every row stored before amounts had a currency
+const CurrencyIDR = "IDR"
+
+// Money an amount in the minor unit of its currency, eg. cents, with the ISO 4217 code of the currency.
+// The arithmetic of Money checks the currencies match and the result fits in 64 bits.
+type Money struct {
+	Amount   int64  `json:"amount" validate:"gte=0"`
+	Currency string `json:"currency" validate:"omitempty,len=3,alpha"`
+}
+
+// NewMoney the Money of amount minor units of currency
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// DefaultCurrency the currency of an amount given without one
+func DefaultCurrency() string {
+	return config.Get("currency.default")
+}
+
+// UnmarshalJSON reads an object with amount and currency, or a bare number of minor units without a currency
+func (m *Money) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] != '{' && !bytes.Equal(data, []byte("null")) {
+		*m = Money{}
+		return json.Unmarshal(data, &m.Amount)
+	}
+
+	// the alias has the fields of Money without its methods, so decoding it does not recurse
+	type money Money
+	return json.Unmarshal(data, (*money)(m))
+}
+
+// String eg. 1200 IDR
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+}
+
+// IsZero reports whether the amount is zero, whatever the currency
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// Add returns m + o, ErrCurrencyMismatch is returned when o is in another currency
+func (m Money) Add(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if (o.Amount > 0 && m.Amount > math.MaxInt64-o.Amount) || (o.Amount < 0 && m.Amount < math.MinInt64-o.Amount) {
+		return Money{}, newMoneyOverflow("%s + %s", m, o)
+	}
+	return Money{Amount: m.Amount + o.Amount, Currency: m.Currency}, nil
+}
+
+// Sub returns m - o, ErrCurrencyMismatch is returned when o is in another currency
+func (m Money) Sub(o Money) (Money, error) {
+	if err := m.sameCurrency(o); err != nil {
+		return Money{}, err
+	}
+	if (o.Amount < 0 && m.Amount > math.MaxInt64+o.Amount) || (o.Amount > 0 && m.Amount < math.MinInt64+o.Amount) {
+		return Money{}, newMoneyOverflow("%s - %s", m, o)
+	}
+	return Money{Amount: m.Amount - o.Amount, Currency: m.Currency}, nil
+}
+
+// Mul returns m times n, eg. the sub total of a price and a qty
+func (m Money) Mul(n int64) (Money, error) {
+	if m.Amount == 0 || n == 0 {
+		return Money{Currency: m.Currency}, nil
+	}
+	product := m.Amount * n
+	if product/n != m.Amount || (m.Amount == -1 && n == math.MinInt64) || (n == -1 && m.Amount == math.MinInt64) {
+		return Money{}, newMoneyOverflow("%s * %d", m, n)
+	}
+	return Money{Amount: product, Currency: m.Currency}, nil
+}
+
+// sameCurrency returns ErrCurrencyMismatch unless o is in the currency of m
+func (m Money) sameCurrency(o Money) error {
+	if m.Currency != o.Currency {
+		return newCurrencyMismatch("%s and %s", m.Currency, o.Currency)
+	}
+	return nil
+}
+
+// mulDiv a * b / c rounded down, without overflowing on the intermediate product.
+// The caller makes sure the result fits, eg. a share of a at most a.
+func mulDiv(a int64, b int64, c int64) int64 {
+	r := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	return r.Div(r, big.NewInt(c)).Int64()
+}
+
+// mulDivRound a * b / c rounded half up, without overflowing on the intermediate product
+func mulDivRound(a int64, b int64, c int64) int64 {
+	r := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	r.Add(r, big.NewInt(c/2))
+	return r.Div(r, big.NewInt(c)).Int64()
+}
+
+// newCurrencyMismatch an ErrCurrencyMismatch saying which currencies were mixed
+func newCurrencyMismatch(format string, args ...interface{}) error {
+	return &Error{Kind: ErrCurrencyMismatch.Kind, Code: ErrCurrencyMismatch.Code, Message: ErrCurrencyMismatch.Message, Err: fmt.Errorf(format, args...)}
+}
+
+// newMoneyOverflow an ErrMoneyOverflow saying which operation overflowed
+func newMoneyOverflow(format string, args ...interface{}) error {
+	return &Error{Kind: ErrMoneyOverflow.Kind, Code: ErrMoneyOverflow.Code, Message: ErrMoneyOverflow.Message, Err: fmt.Errorf(format, args...)}
+}
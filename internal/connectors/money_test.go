@@ -0,0 +1,84 @@
+package connectors
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestMoneyArithmetic(t *testing.T) {
+	idr := func(amount int64) Money { return NewMoney(amount, CurrencyIDR) }
+	add := func(a, b Money) (Money, error) { return a.Add(b) }
+	sub := func(a, b Money) (Money, error) { return a.Sub(b) }
+	mul := func(n int64) func(a, b Money) (Money, error) {
+		return func(a, _ Money) (Money, error) { return a.Mul(n) }
+	}
+
+	tests := []struct {
+		name    string
+		op      func(a, b Money) (Money, error)
+		a, b    Money
+		want    Money
+		wantErr error
+	}{
+		{"add", add, idr(1200), idr(300), idr(1500), nil},
+		{"add-overflow", add, idr(math.MaxInt64), idr(1), Money{}, ErrMoneyOverflow},
+		{"add-other-currency", add, idr(1200), NewMoney(300, "USD"), Money{}, ErrCurrencyMismatch},
+		{"sub", sub, idr(1200), idr(300), idr(900), nil},
+		{"sub-below-zero", sub, idr(300), idr(1200), idr(-900), nil},
+		{"sub-overflow", sub, idr(math.MinInt64), idr(1), Money{}, ErrMoneyOverflow},
+		{"sub-other-currency", sub, idr(1200), NewMoney(300, "USD"), Money{}, ErrCurrencyMismatch},
+		{"mul", mul(3), idr(1200), Money{}, idr(3600), nil},
+		{"mul-zero", mul(0), idr(1200), Money{}, idr(0), nil},
+		{"mul-overflow", mul(2), idr(math.MaxInt64/2 + 1), Money{}, Money{}, ErrMoneyOverflow},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.op(tt.a, tt.b)
+		if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		json    string
+		want    Money
+		wantErr bool
+	}{
+		{"object", `{"amount": 1200, "currency": "USD"}`, NewMoney(1200, "USD"), false},
+		{"object-without-currency", `{"amount": 1200}`, NewMoney(1200, ""), false},
+		{"bare-amount", `1200`, NewMoney(1200, ""), false},
+		{"fraction", `12.5`, Money{}, true},
+		{"string", `"1200"`, Money{}, true},
+	}
+
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.json), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMulDiv(t *testing.T) {
+	// the intermediate product does not fit in 64 bits
+	if got := mulDiv(math.MaxInt64, 10, 100); got != math.MaxInt64/10 {
+		t.Errorf("mulDiv got %d, want %d", got, int64(math.MaxInt64/10))
+	}
+	if got := mulDivRound(1005, 1100, 10000); got != 111 {
+		t.Errorf("mulDivRound got %d, want 111", got)
+	}
+}
@@ -3,14 +3,54 @@ package connectors
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/arieffian/mw-backend-test/internal/config"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mySQLErrDupEntry ER_DUP_ENTRY, the row violates a unique index
+	mySQLErrDupEntry = 1062
+
+	// mySQLErrRowIsReferenced ER_ROW_IS_REFERENCED_2, a parent row cannot be deleted because of a foreign key constraint
+	mySQLErrRowIsReferenced = 1451
+
+	// mySQLErrNoReferencedRow ER_NO_REFERENCED_ROW_2, a child row references a parent row that does not exist
+	mySQLErrNoReferencedRow = 1452
 )
 
 var (
 	mysqlLog        = log.WithField("file", "mysql_db_connector.go")
 	mySQLDbInstance *MySQLDB
+
+	// productSortColumns the column of every sort field GetProducts accepts
+	productSortColumns = map[string]string{
+		ProductSortID:    "id",
+		ProductSortName:  "name",
+		ProductSortPrice: "price",
+	}
+
+	// likeEscaper escapes the LIKE wildcards so a name filter only matches literally
+	likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+	// searchCandidates the products that may match the query, with the names of their brand and categories to score them.
+	// The fulltext indexes of sql/000018_product_search.up.sql only narrow the products down: their ngram parser
+	// finds every word sharing a pair of letters with a term, which takes in the words a typo away from it.
+	// The query is bound three times.
+	searchCandidates = "SELECT " + productColumns + ", " +
+		"(SELECT b.name FROM brands b WHERE b.id = products.brand_id), " +
+		"(SELECT GROUP_CONCAT(c.name SEPARATOR ' ') FROM product_categories pc INNER JOIN categories c ON c.id = pc.category_id WHERE pc.product_id = products.id) " +
+		"FROM products WHERE MATCH(name) AGAINST(? IN NATURAL LANGUAGE MODE) " +
+		"OR EXISTS (SELECT 1 FROM brands b WHERE b.id = products.brand_id AND MATCH(b.name) AGAINST(? IN NATURAL LANGUAGE MODE)) " +
+		"OR EXISTS (SELECT 1 FROM product_categories pc INNER JOIN categories c ON c.id = pc.category_id " +
+		"WHERE pc.product_id = products.id AND MATCH(c.name) AGAINST(? IN NATURAL LANGUAGE MODE))"
 )
 
 // GetMySQLDBInstance initializes the MySQL.DB instance
@@ -28,6 +68,16 @@ func GetMySQLDBInstance() *MySQLDB {
 
 		mySQLDbInstance = &MySQLDB{
 			instance: db,
+			txRetryPolicy: TxRetryPolicy{
+				MaxAttempts: config.GetInt("db.tx.retry.attempts"),
+				BaseDelay:   time.Duration(config.GetInt("db.tx.retry.base.delay")) * time.Millisecond,
+				MaxDelay:    time.Duration(config.GetInt("db.tx.retry.max.delay")) * time.Millisecond,
+			},
+			taxCalculator:  NewRateTaxCalculatorFromConfig(),
+			shippingRates:  NewFlatShippingRateProviderFromConfig(),
+			reservationTTL: reservationTTLFromConfig(),
+			allocation:     NewAllocationStrategyFromConfig(),
+			priceBuckets:   priceBucketsFromConfig(),
 		}
 	}
 	return mySQLDbInstance
@@ -36,6 +86,73 @@ func GetMySQLDBInstance() *MySQLDB {
 // MySQLDB db instance
 type MySQLDB struct {
 	instance *sql.DB
+
+	// txRetryPolicy used by withTx, the zero value falls back to defaultTxRetryPolicy
+	txRetryPolicy TxRetryPolicy
+
+	// taxCalculator computes the tax of an order, nil charges no tax
+	taxCalculator TaxCalculator
+
+	// shippingRates prices the shipping of an order, nil ships for free
+	shippingRates ShippingRateProvider
+
+	// reservationTTL how long a pending order holds its stock, zero falls back to defaultReservationTTL
+	reservationTTL time.Duration
+
+	// allocation picks the warehouses the stock of an order is reserved in when it is created, nil falls back to defaultAllocation
+	allocation AllocationStrategy
+
+	// priceBuckets the sorted upper bounds of the price buckets of the search facets, none puts every price in one bucket
+	priceBuckets []int64
+}
+
+// SetTaxCalculator replaces the TaxCalculator orders are taxed with
+func (db *MySQLDB) SetTaxCalculator(calc TaxCalculator) {
+	db.taxCalculator = calc
+}
+
+// taxCalc the TaxCalculator of db, noTax when it has none
+func (db *MySQLDB) taxCalc() TaxCalculator {
+	if db.taxCalculator == nil {
+		return noTax
+	}
+	return db.taxCalculator
+}
+
+// SetShippingRateProvider replaces the ShippingRateProvider the shipping of orders is priced with
+func (db *MySQLDB) SetShippingRateProvider(rates ShippingRateProvider) {
+	db.shippingRates = rates
+}
+
+// shippingRateProvider the ShippingRateProvider of db, freeShipping when it has none
+func (db *MySQLDB) shippingRateProvider() ShippingRateProvider {
+	if db.shippingRates == nil {
+		return freeShipping
+	}
+	return db.shippingRates
+}
+
+// SetSearchPriceBuckets replaces the upper bounds of the price buckets products are counted in by SearchProducts
+func (db *MySQLDB) SetSearchPriceBuckets(bounds []int64) {
+	db.priceBuckets = normalizePriceBuckets(bounds)
+}
+
+// SetReservationTTL replaces how long a pending order holds its stock
+func (db *MySQLDB) SetReservationTTL(ttl time.Duration) {
+	db.reservationTTL = ttl
+}
+
+// SetAllocationStrategy replaces the AllocationStrategy picking the warehouses the stock of new orders is reserved in
+func (db *MySQLDB) SetAllocationStrategy(strategy AllocationStrategy) {
+	db.allocation = strategy
+}
+
+// allocationStrategy the AllocationStrategy of db, defaultAllocation when it has none
+func (db *MySQLDB) allocationStrategy() AllocationStrategy {
+	if db.allocation == nil {
+		return defaultAllocation
+	}
+	return db.allocation
 }
 
 // GetBrandByID retrieves an BrandRecord from database where the brand id is specified.
@@ -47,241 +164,3591 @@ func (db *MySQLDB) GetBrandByID(ctx context.Context, brandID int) (*BrandRecord,
 	err := row.Scan(&brand.ID, &brand.Name)
 	if err != nil {
 		fLog.Errorf("row.Scan got %s", err.Error())
-		return nil, err
+		return nil, notFound(err, ErrBrandNotFound)
 	}
 
 	return brand, nil
 }
 
-// CreateBrand insert an entity record of brand into database.
-func (db *MySQLDB) CreateBrand(ctx context.Context, rec *BrandRecord) (string, error) {
+// CreateBrand insert an entity record of brand into database and returns the persisted record.
+func (db *MySQLDB) CreateBrand(ctx context.Context, rec *BrandRecord) (*BrandRecord, error) {
 	fLog := mysqlLog.WithField("func", "CreateBrand")
 
-	_, err := db.instance.ExecContext(ctx, "INSERT INTO brands(name) VALUES(?)", rec.Name)
+	result, err := db.instance.ExecContext(ctx, "INSERT INTO brands(name) VALUES(?)", rec.Name)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
-		return "", err
+		return nil, err
+	}
+
+	bID, err := result.LastInsertId()
+	if err != nil {
+		fLog.Errorf("result.LastInsertId got %s", err.Error())
+		return nil, err
 	}
 
-	return "brand created successfully", nil
+	return &BrandRecord{
+		ID:   int(bID),
+		Name: rec.Name,
+	}, nil
 }
 
-// CreateProduct insert an entity record of product into database.
-func (db *MySQLDB) CreateProduct(ctx context.Context, rec *ProductRecord) (string, error) {
-	fLog := mysqlLog.WithField("func", "CreateProduct")
+// GetBrands retrieves every BrandRecord from database ordered by brand id.
+func (db *MySQLDB) GetBrands(ctx context.Context, page *pagination.Page) ([]*BrandRecord, int, error) {
+	brandList := make([]*BrandRecord, 0)
+	lq := &listQuery{columns: "id, name", from: "brands", order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &brandList, func(rows *sql.Rows) error {
+		brand := &BrandRecord{}
+		if err := rows.Scan(&brand.ID, &brand.Name); err != nil {
+			return err
+		}
+		brandList = append(brandList, brand)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
 
-	_, err := db.instance.ExecContext(ctx, "INSERT INTO products(brand_id, name, qty, price) VALUES(?,?,?,?)", rec.BrandID, rec.Name, rec.Qty, rec.Price)
+	return brandList, total, nil
+}
+
+// UpdateBrand update an entity record of brand in database where the brand id is specified.
+func (db *MySQLDB) UpdateBrand(ctx context.Context, rec *BrandRecord) (string, error) {
+	fLog := mysqlLog.WithField("func", "UpdateBrand")
+
+	result, err := db.instance.ExecContext(ctx, "UPDATE brands SET name=? WHERE id=?", rec.Name, rec.ID)
 	if err != nil {
 		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
 		return "", err
 	}
 
-	return "product created successfully", nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return "", err
+	}
+	if affected == 0 {
+		// mysql reports no affected rows for an unchanged brand too
+		var id int
+		err = db.instance.QueryRowContext(ctx, "SELECT id FROM brands WHERE id = ?", rec.ID).Scan(&id)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return "", notFound(err, ErrBrandNotFound)
+		}
+	}
+
+	return "brand updated successfully", nil
 }
 
-// GetProductByID retrieves an ProductRecord from database where the product id is specified.
-func (db *MySQLDB) GetProductByID(ctx context.Context, productID int) (*ProductRecord, error) {
-	fLog := mysqlLog.WithField("func", "GetProductByID")
-	product := &ProductRecord{}
+// DeleteBrand delete an entity record of brand from database where the brand id is specified.
+// ErrBrandHasProducts is returned when products or coupons still reference the brand.
+func (db *MySQLDB) DeleteBrand(ctx context.Context, brandID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "DeleteBrand")
 
-	row := db.instance.QueryRowContext(ctx, "SELECT id, brand_id, name, price, qty FROM products WHERE id = ?", productID)
-	err := row.Scan(&product.ID, &product.BrandID, &product.Name, &product.Price, &product.Qty)
+	result, err := db.instance.ExecContext(ctx, "DELETE FROM brands WHERE id=?", brandID)
 	if err != nil {
-		fLog.Errorf("row.Scan got %s", err.Error())
-		return nil, err
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mySQLErrRowIsReferenced {
+			return "", ErrBrandHasProducts
+		}
+		return "", err
 	}
 
-	return product, nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return "", err
+	}
+	if affected == 0 {
+		return "", ErrBrandNotFound
+	}
+
+	return "brand deleted successfully", nil
 }
 
-// GetProductByBrandID retrieves an array of ProductRecord from database where the brand id is specified.
-func (db *MySQLDB) GetProductByBrandID(ctx context.Context, brandID int) ([]*ProductRecord, error) {
-	fLog := mysqlLog.WithField("func", "GetProductByBrandID")
+// categoryColumns the columns scanCategory reads, in its order
+const categoryColumns = "id, parent_id, name"
 
-	q := fmt.Sprintf("SELECT id, brand_id, name, price, qty FROM products WHERE brand_id = %v", brandID)
-	rows, err := db.instance.QueryContext(ctx, q)
+// scanCategory reads a category selected with categoryColumns
+func scanCategory(row rowScanner) (*CategoryRecord, error) {
+	category := &CategoryRecord{}
+	var parentID sql.NullInt64
+	err := row.Scan(&category.ID, &parentID, &category.Name)
 	if err != nil {
-		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
 		return nil, err
 	}
-	productList := make([]*ProductRecord, 0)
-	for rows.Next() {
-		product := &ProductRecord{}
-		err := rows.Scan(&product.ID, &product.BrandID, &product.Name, &product.Price, &product.Qty)
-		if err != nil {
-			fLog.Errorf("rows.Scan got %s", err.Error())
-		} else {
-			productList = append(productList, product)
+	category.ParentID = int(parentID.Int64)
+	return category, nil
+}
+
+// CreateCategory insert an entity record of category into database and returns the persisted record.
+// ErrCategoryNotFound is returned when its parent does not exist.
+func (db *MySQLDB) CreateCategory(ctx context.Context, rec *CategoryRecord) (*CategoryRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateCategory")
+
+	parentID := sql.NullInt64{Int64: int64(rec.ParentID), Valid: rec.ParentID != 0}
+	result, err := db.instance.ExecContext(ctx, "INSERT INTO categories(parent_id, name) VALUES(?,?)", parentID, rec.Name)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		if isNoReferencedRow(err) {
+			return nil, ErrCategoryNotFound
 		}
+		return nil, err
+	}
+
+	cID, err := result.LastInsertId()
+	if err != nil {
+		fLog.Errorf("result.LastInsertId got %s", err.Error())
+		return nil, err
 	}
-	return productList, nil
+
+	return &CategoryRecord{
+		ID:       int(cID),
+		ParentID: rec.ParentID,
+		Name:     rec.Name,
+	}, nil
 }
 
-// GetTransactionByTransactionID retrieves the detail of a transaction from database where the transaction id is specified.
-func (db *MySQLDB) GetTransactionByTransactionID(ctx context.Context, transactionID int) (*TransactionRecord, error) {
-	fLog := mysqlLog.WithField("func", "GetTransactionByTransactionID")
-	transaction := &TransactionRecord{}
+// GetCategoryByID retrieves an CategoryRecord from database where the category id is specified.
+func (db *MySQLDB) GetCategoryByID(ctx context.Context, categoryID int) (*CategoryRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetCategoryByID")
 
-	row := db.instance.QueryRowContext(ctx, "SELECT id, user_id, date, grand_total FROM transactions WHERE id = ?", transactionID)
-	err := row.Scan(&transaction.ID, &transaction.UserID, &transaction.Date, &transaction.GrandTotal)
+	row := db.instance.QueryRowContext(ctx, "SELECT "+categoryColumns+" FROM categories WHERE id = ?", categoryID)
+	category, err := scanCategory(row)
 	if err != nil {
 		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrCategoryNotFound)
+	}
+
+	return category, nil
+}
+
+// GetCategories retrieves every CategoryRecord from database ordered by category id.
+func (db *MySQLDB) GetCategories(ctx context.Context, page *pagination.Page) ([]*CategoryRecord, int, error) {
+	categoryList := make([]*CategoryRecord, 0)
+	lq := &listQuery{columns: categoryColumns, from: "categories", order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &categoryList, func(rows *sql.Rows) error {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return err
+		}
+		categoryList = append(categoryList, category)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return categoryList, total, nil
+}
+
+// GetCategoryPaths retrieves the breadcrumb of every distinct category of categoryIDs, ordered by category id.
+// ErrCategoryNotFound is returned when one of them does not exist.
+func (db *MySQLDB) GetCategoryPaths(ctx context.Context, categoryIDs []int) ([]CategoryPath, error) {
+	ids := distinctCategoryIDs(categoryIDs)
+	categories, err := categoryAncestors(ctx, db.instance, ids)
+	if err != nil {
 		return nil, err
 	}
+	return categoryPaths(categories, ids)
+}
+
+// categoryAncestors reads the categories of categoryIDs and all of their ancestors keyed by id with q
+func categoryAncestors(ctx context.Context, q queryer, categoryIDs []int) (map[int]*CategoryRecord, error) {
+	fLog := mysqlLog.WithField("func", "categoryAncestors")
+
+	categories := make(map[int]*CategoryRecord)
+	if len(categoryIDs) == 0 {
+		return categories, nil
+	}
+
+	placeholders := make([]string, 0, len(categoryIDs))
+	args := make([]interface{}, 0, len(categoryIDs))
+	for _, id := range categoryIDs {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
 
-	q := fmt.Sprintf("SELECT transaction_id, product_id, qty, sub_total FROM transaction_detail WHERE transaction_id = %v", transactionID)
-	rows, err := db.instance.QueryContext(ctx, q)
+	// walk up the tree from every category, UNION keeps a shared ancestor once
+	rows, err := q.QueryContext(ctx, "WITH RECURSIVE ancestors ("+categoryColumns+") AS ("+
+		"SELECT "+categoryColumns+" FROM categories WHERE id IN ("+strings.Join(placeholders, ",")+") "+
+		"UNION SELECT c.id, c.parent_id, c.name FROM categories c INNER JOIN ancestors a ON c.id = a.parent_id"+
+		") SELECT "+categoryColumns+" FROM ancestors", args...)
 	if err != nil {
-		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		fLog.Errorf("db.QueryContext got %s", err.Error())
 		return nil, err
 	}
+	defer rows.Close()
 
-	tDetail := make([]*TransactionDetailRecord, 0)
 	for rows.Next() {
-		tD := &TransactionDetailRecord{}
-		err := rows.Scan(&tD.TransactionID, &tD.ProductID, &tD.Qty, &tD.SubTotal)
+		category, err := scanCategory(rows)
 		if err != nil {
 			fLog.Errorf("rows.Scan got %s", err.Error())
-		} else {
-			tDetail = append(tDetail, tD)
+			return nil, err
 		}
+		categories[category.ID] = category
 	}
 
-	transaction.TransactionDetail = tDetail
-
-	return transaction, nil
+	return categories, rows.Err()
 }
 
-// CreateTransaction insert an entity record of transaction into database.
-func (db *MySQLDB) CreateTransaction(ctx context.Context, rec *TransactionRecord) (string, error) {
-	fLog := mysqlLog.WithField("func", "CreateTransaction")
+// UpdateCategory update an entity record of category in database where the category id is specified.
+// ErrCategoryNotFound is returned when the category or its new parent does not exist,
+// ErrInvalidCategoryParent when the new parent is the category itself or one of its subcategories.
+func (db *MySQLDB) UpdateCategory(ctx context.Context, rec *CategoryRecord) (string, error) {
+	fLog := mysqlLog.WithField("func", "UpdateCategory")
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var id int
+		row := tx.QueryRowContext(ctx, "SELECT id FROM categories WHERE id = ? FOR UPDATE", rec.ID)
+		err := row.Scan(&id)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrCategoryNotFound)
+		}
+
+		if rec.ParentID != 0 {
+			// the category is among the ancestors of its new parent when the parent is itself or one of its subcategories
+			var found int
+			row = tx.QueryRowContext(ctx, "WITH RECURSIVE ancestors (id, parent_id) AS ("+
+				"SELECT id, parent_id FROM categories WHERE id = ? "+
+				"UNION SELECT c.id, c.parent_id FROM categories c INNER JOIN ancestors a ON c.id = a.parent_id"+
+				") SELECT COUNT(*) FROM ancestors WHERE id = ?", rec.ParentID, rec.ID)
+			err = row.Scan(&found)
+			if err != nil {
+				fLog.Errorf("row.Scan got %s", err.Error())
+				return err
+			}
+			if found > 0 {
+				return ErrInvalidCategoryParent
+			}
+		}
 
-	// start db transaction
-	tx, err := db.instance.BeginTx(ctx, nil)
+		parentID := sql.NullInt64{Int64: int64(rec.ParentID), Valid: rec.ParentID != 0}
+		_, err = tx.ExecContext(ctx, "UPDATE categories SET parent_id=?, name=? WHERE id=?", parentID, rec.Name, rec.ID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			if isNoReferencedRow(err) {
+				return ErrCategoryNotFound
+			}
+			return err
+		}
+		return nil
+	})
 	if err != nil {
-		fLog.Errorf("db.instance.BeginTx got %s", err.Error())
 		return "", err
 	}
 
-	// create transaction record
-	trans, err := tx.ExecContext(ctx, "INSERT INTO transactions(user_id, date, grand_total) VALUES(?,?,?)", rec.UserID, rec.Date, 0)
+	return "category updated successfully", nil
+}
+
+// DeleteCategory delete an entity record of category from database where the category id is specified,
+// the products listed in it are unlisted. ErrCategoryHasChildren is returned when it still has subcategories.
+func (db *MySQLDB) DeleteCategory(ctx context.Context, categoryID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "DeleteCategory")
+
+	// fk_product_categories_categories1 removes the products from the category
+	result, err := db.instance.ExecContext(ctx, "DELETE FROM categories WHERE id=?", categoryID)
 	if err != nil {
-		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			fLog.Errorf("error rollback, got %s", err.Error())
-			return "", errRollback
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mySQLErrRowIsReferenced {
+			return "", ErrCategoryHasChildren
 		}
 		return "", err
 	}
 
-	tID, err := trans.LastInsertId()
+	affected, err := result.RowsAffected()
 	if err != nil {
-		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			fLog.Errorf("error rollback, got %s", err.Error())
-			return "", errRollback
-		}
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
 		return "", err
 	}
+	if affected == 0 {
+		return "", ErrCategoryNotFound
+	}
 
-	grandTotal := 0
+	return "category deleted successfully", nil
+}
 
-	//loop tx detail
-	for i := 0; i < len(rec.TransactionDetail); i++ {
-		detail := rec.TransactionDetail[i]
+// productColumns the columns scanProduct reads, in its order
+const productColumns = "id, brand_id, name, price, currency, qty, tax_class"
+
+// scanProduct reads a product selected with productColumns, followed by the columns of extra when there are more
+func scanProduct(row rowScanner, extra ...interface{}) (*ProductRecord, error) {
+	product := &ProductRecord{}
+	dest := []interface{}{&product.ID, &product.BrandID, &product.Name, &product.Price.Amount, &product.Price.Currency, &product.Qty, &product.TaxClass}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	return product, nil
+}
 
-		//get product stock from products table
-		p, err := db.GetProductByID(ctx, detail.ProductID)
+// CreateProduct insert an entity record of product into database and returns the persisted record.
+// The qty of the product is recorded as its initial stock movement.
+// ErrCategoryNotFound is returned when one of its categories does not exist.
+func (db *MySQLDB) CreateProduct(ctx context.Context, rec *ProductRecord) (*ProductRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateProduct")
+
+	var pID int64
+	categoryIDs := distinctCategoryIDs(rec.CategoryIDs)
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO products(brand_id, name, qty, price, currency, tax_class) VALUES(?,?,?,?,?,?)", rec.BrandID, rec.Name, rec.Qty, rec.Price.Amount, rec.Price.Currency, rec.TaxClass)
 		if err != nil {
 			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-			errRollback := tx.Rollback()
-			if errRollback != nil {
-				fLog.Errorf("error rollback, got %s", err.Error())
-				return "", errRollback
-			}
-			return "", err
+			return err
 		}
 
-		//check qty
-		if p.Qty-detail.Qty < 0 {
-			fLog.Errorf("product qty is not enough")
-			errRollback := tx.Rollback()
-			if errRollback != nil {
-				fLog.Errorf("error rollback, got %s", err.Error())
-				return "", errRollback
-			}
-			return "", fmt.Errorf("product qty is not enough")
+		pID, err = result.LastInsertId()
+		if err != nil {
+			fLog.Errorf("result.LastInsertId got %s", err.Error())
+			return err
 		}
 
-		qty := p.Qty - detail.Qty
-		subTotal := p.Price * detail.Qty
-		grandTotal = grandTotal + subTotal
-
-		//update qty from products table
-		_, err = tx.ExecContext(ctx, "UPDATE products SET qty=? WHERE id=?", qty, detail.ProductID)
+		err = insertProductCategories(ctx, tx, int(pID), categoryIDs)
 		if err != nil {
-			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-			errRollback := tx.Rollback()
-			if errRollback != nil {
-				fLog.Errorf("error rollback, got %s", err.Error())
-				return "", errRollback
-			}
-			return "", err
+			return err
 		}
 
-		//insert transaction detail
-		_, err = tx.ExecContext(ctx, "INSERT INTO transaction_detail(transaction_id, product_id, price, qty, sub_total) VALUES(?,?,?,?,?)", tID, detail.ProductID, p.Price, detail.Qty, subTotal)
-		if err != nil {
-			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-			errRollback := tx.Rollback()
-			if errRollback != nil {
-				fLog.Errorf("error rollback, got %s", err.Error())
-				return "", errRollback
-			}
-			return "", err
+		if rec.Qty == 0 {
+			return nil
 		}
+		return moveStock(ctx, tx, &StockMovementRecord{
+			ProductID: int(pID),
+			Delta:     rec.Qty,
+			Reason:    StockReasonInitial,
+			Actor:     systemActor,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductRecord{
+		ID:          int(pID),
+		BrandID:     rec.BrandID,
+		Name:        rec.Name,
+		Qty:         rec.Qty,
+		Price:       rec.Price,
+		TaxClass:    rec.TaxClass,
+		CategoryIDs: categoryIDs,
+	}, nil
+}
+
+// insertProductCategories lists the product of productID in the categories of categoryIDs,
+// ErrCategoryNotFound is returned when one of them does not exist
+func insertProductCategories(ctx context.Context, tx *sql.Tx, productID int, categoryIDs []int) error {
+	fLog := mysqlLog.WithField("func", "insertProductCategories")
+
+	if len(categoryIDs) == 0 {
+		return nil
 	}
 
-	// update transaction grand total
-	_, err = tx.ExecContext(ctx, "UPDATE transactions SET grand_total=? WHERE id=?", grandTotal, tID)
+	values := make([]string, 0, len(categoryIDs))
+	args := make([]interface{}, 0, 2*len(categoryIDs))
+	for _, id := range categoryIDs {
+		values = append(values, "(?,?)")
+		args = append(args, productID, id)
+	}
+
+	_, err := tx.ExecContext(ctx, "INSERT INTO product_categories(product_id, category_id) VALUES"+strings.Join(values, ","), args...)
 	if err != nil {
 		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			fLog.Errorf("error rollback, got %s", err.Error())
-			return "", errRollback
+		if isNoReferencedRow(err) {
+			return ErrCategoryNotFound
 		}
-		return "", err
+		return err
+	}
+	return nil
+}
+
+// fillProductCategories reads the categories of every product of products, with their breadcrumbs
+func (db *MySQLDB) fillProductCategories(ctx context.Context, products []*ProductRecord) error {
+	fLog := mysqlLog.WithField("func", "fillProductCategories")
+
+	if len(products) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(products))
+	args := make([]interface{}, 0, len(products))
+	byID := make(map[int]*ProductRecord, len(products))
+	for _, product := range products {
+		placeholders = append(placeholders, "?")
+		args = append(args, product.ID)
+		product.CategoryIDs = make([]int, 0)
+		byID[product.ID] = product
 	}
 
-	// commit transaction
-	err = tx.Commit()
+	rows, err := db.instance.QueryContext(ctx, "SELECT product_id, category_id FROM product_categories WHERE product_id IN ("+strings.Join(placeholders, ",")+") ORDER BY product_id, category_id", args...)
 	if err != nil {
-		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
-		errRollback := tx.Rollback()
-		if errRollback != nil {
-			fLog.Errorf("error rollback, got %s", err.Error())
-			return "", errRollback
+		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		return err
+	}
+	defer rows.Close()
+
+	categoryIDs := make([]int, 0)
+	for rows.Next() {
+		var productID, categoryID int
+		err := rows.Scan(&productID, &categoryID)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return err
+		}
+		if product, ok := byID[productID]; ok {
+			product.CategoryIDs = append(product.CategoryIDs, categoryID)
+			categoryIDs = append(categoryIDs, categoryID)
 		}
-		return "", err
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return err
 	}
 
-	return "transaction created successfully", nil
+	categories, err := categoryAncestors(ctx, db.instance, distinctCategoryIDs(categoryIDs))
+	if err != nil {
+		return err
+	}
+	for _, product := range products {
+		product.Categories, err = categoryPaths(categories, product.CategoryIDs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// GetUserByID retrieves an UserRecord from database where the user id is specified.
-func (db *MySQLDB) GetUserByID(ctx context.Context, userID int) (*UserRecord, error) {
-	fLog := mysqlLog.WithField("func", "GetUserByID")
-	user := &UserRecord{}
+// GetProductByID retrieves an ProductRecord from database where the product id is specified.
+func (db *MySQLDB) GetProductByID(ctx context.Context, productID int) (*ProductRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetProductByID")
 
-	row := db.instance.QueryRowContext(ctx, "SELECT id, name, email, address FROM users WHERE id = ?", userID)
-	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Address)
+	now := time.Now()
+	var reserved int
+	row := db.instance.QueryRowContext(ctx, "SELECT "+productColumns+", "+reservedQtyColumn+" FROM products WHERE id = ?", now, productID)
+	product, err := scanProduct(row, &reserved)
 	if err != nil {
 		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrProductNotFound)
+	}
+	product.Available = availableQty(product.Qty, reserved)
+
+	product.Variants, err = productVariants(ctx, db.instance, productID, now)
+	if err != nil {
 		return nil, err
 	}
+	for _, variant := range product.Variants {
+		product.Available += variant.Available
+	}
 
-	return user, nil
+	err = db.fillProductCategories(ctx, []*ProductRecord{product})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// GetProductByBrandID retrieves an array of ProductRecord from database where the brand id is specified.
+func (db *MySQLDB) GetProductByBrandID(ctx context.Context, brandID int, page *pagination.Page) ([]*ProductRecord, int, error) {
+	productList := make([]*ProductRecord, 0)
+	lq := &listQuery{columns: productColumns, from: "products", where: []string{"brand_id = ?"}, args: []interface{}{brandID}, order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &productList, func(rows *sql.Rows) error {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return err
+		}
+		productList = append(productList, product)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return productList, total, nil
+}
+
+// GetProducts retrieves the products matching the filter, sorted and paged as the filter asks.
+func (db *MySQLDB) GetProducts(ctx context.Context, filter *ProductFilter) ([]*ProductRecord, int, error) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	if filter.Name != "" {
+		where = append(where, "name LIKE ?")
+		args = append(args, "%"+likeEscaper.Replace(filter.Name)+"%")
+	}
+	if filter.BrandID != 0 {
+		where = append(where, "brand_id = ?")
+		args = append(args, filter.BrandID)
+	}
+	if filter.Currency != "" {
+		where = append(where, "currency = ?")
+		args = append(args, filter.Currency)
+	}
+	if filter.MinPrice != nil {
+		where = append(where, "price >= ?")
+		args = append(args, *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		where = append(where, "price <= ?")
+		args = append(args, *filter.MaxPrice)
+	}
+	if filter.InStock {
+		where = append(where, "(qty > 0 OR EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = products.id AND v.qty > 0))")
+	}
+	// the order by column comes from a fixed list, never from the request
+	order := keyset{id: "id", desc: filter.Desc}
+	switch column := productSortColumns[filter.Sort]; filter.Sort {
+	case ProductSortName:
+		order.column = column
+	case ProductSortPrice:
+		order.column, order.value = column, intCursorValue
+	}
+
+	productList := make([]*ProductRecord, 0)
+	lq := &listQuery{columns: productColumns, from: "products", where: where, args: args, order: order}
+	total, err := db.readPage(ctx, lq, filter.Page, &productList, func(rows *sql.Rows) error {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return err
+		}
+		productList = append(productList, product)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return productList, total, nil
+}
+
+// GetProductsByCategoryID retrieves the products listed in a category or any of its subcategories,
+// once each and ordered by product id, with their categories.
+func (db *MySQLDB) GetProductsByCategoryID(ctx context.Context, categoryID int, page *pagination.Page) ([]*ProductRecord, int, error) {
+	productList := make([]*ProductRecord, 0)
+	// walk down the tree from the category, a product listed in several of its categories is selected once
+	lq := &listQuery{
+		with: "WITH RECURSIVE subtree (id) AS (" +
+			"SELECT id FROM categories WHERE id = ? " +
+			"UNION SELECT c.id FROM categories c INNER JOIN subtree s ON c.parent_id = s.id" +
+			") ",
+		columns: productColumns,
+		from:    "products",
+		where:   []string{"id IN (SELECT pc.product_id FROM product_categories pc INNER JOIN subtree s ON pc.category_id = s.id)"},
+		args:    []interface{}{categoryID},
+		order:   idKeyset,
+	}
+	total, err := db.readPage(ctx, lq, page, &productList, func(rows *sql.Rows) error {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return err
+		}
+		productList = append(productList, product)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = db.fillProductCategories(ctx, productList)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return productList, total, nil
+}
+
+// SearchProducts returns the page of q of the products whose name, brand or categories match a word of the query,
+// with the number of matching products per brand and per price bucket. The fulltext indexes find the candidates
+// with a single query, they are scored with searchScore like the in memory index scores its products and the page,
+// the total and the facets are all taken from the candidates that match.
+func (db *MySQLDB) SearchProducts(ctx context.Context, q *SearchQuery) (*SearchResult, error) {
+	fLog := mysqlLog.WithField("func", "SearchProducts")
+
+	terms := searchTerms(q.Query)
+	if len(terms) == 0 {
+		return newSearchResult(q, nil, nil, db.priceBuckets), nil
+	}
+
+	rows, err := db.instance.QueryContext(ctx, searchCandidates, q.Query, q.Query, q.Query)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := make([]*SearchHit, 0)
+	brands := make(map[int]string)
+	for rows.Next() {
+		var brand, categories sql.NullString
+		product, err := scanProduct(rows, &brand, &categories)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+
+		// a word sharing a pair of letters with a term is not always a typo of it
+		score := searchScore(terms, searchFields(product.Name, brand.String, []string{categories.String}))
+		if score == 0 {
+			continue
+		}
+		hits = append(hits, &SearchHit{ProductRecord: product, Score: score})
+		if brand.Valid {
+			brands[product.BrandID] = brand.String
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return nil, err
+	}
+
+	return newSearchResult(q, hits, brands, db.priceBuckets), nil
+}
+
+// UpdateProduct update an entity record of product in database where the product id is specified.
+// A change of qty is recorded as an adjustment stock movement made by actor,
+// ErrActorRequired is returned when the qty changes without one.
+// ErrCategoryNotFound is returned when one of its categories does not exist.
+func (db *MySQLDB) UpdateProduct(ctx context.Context, rec *ProductRecord, actor string) (string, error) {
+	fLog := mysqlLog.WithField("func", "UpdateProduct")
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		// lock the product so the recorded delta matches the qty it replaces
+		var qty int
+		row := tx.QueryRowContext(ctx, "SELECT qty FROM products WHERE id = ? FOR UPDATE", rec.ID)
+		err := row.Scan(&qty)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrProductNotFound)
+		}
+		if rec.Qty != qty && actor == "" {
+			return ErrActorRequired
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE products SET brand_id=?, name=?, qty=?, price=?, currency=?, tax_class=? WHERE id=?", rec.BrandID, rec.Name, rec.Qty, rec.Price.Amount, rec.Price.Currency, rec.TaxClass, rec.ID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM product_categories WHERE product_id = ?", rec.ID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+		err = insertProductCategories(ctx, tx, rec.ID, distinctCategoryIDs(rec.CategoryIDs))
+		if err != nil {
+			return err
+		}
+
+		if rec.Qty == qty {
+			return nil
+		}
+		return moveStock(ctx, tx, &StockMovementRecord{
+			ProductID: rec.ID,
+			Delta:     rec.Qty - qty,
+			Reason:    StockReasonAdjustment,
+			Actor:     actor,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "product updated successfully", nil
+}
+
+// DeleteProduct delete an entity record of product from database where the product id is specified.
+// ErrProductHasOrders is returned when transaction details still reference the product,
+// ErrProductHasStockMovements when its stock ledger does. Both are checked with the product locked,
+// which the orders and the stock movements of a product lock before they reference it.
+func (db *MySQLDB) DeleteProduct(ctx context.Context, productID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "DeleteProduct")
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var hasOrders, hasMovements bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM transaction_detail WHERE product_id = products.id), "+
+			"EXISTS (SELECT 1 FROM stock_movements WHERE product_id = products.id) FROM products WHERE id = ? FOR UPDATE", productID).
+			Scan(&hasOrders, &hasMovements)
+		if err != nil {
+			fLog.Errorf("tx.QueryRowContext got %s", err.Error())
+			return notFound(err, ErrProductNotFound)
+		}
+		if hasOrders {
+			return ErrProductHasOrders
+		}
+		if hasMovements {
+			return ErrProductHasStockMovements
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM products WHERE id=?", productID)
+		if err != nil {
+			fLog.Errorf("tx.ExecContext got %s", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "product deleted successfully", nil
+}
+
+// AdjustStock adds the delta of rec to the qty of its product and of its warehouse and records rec in the stock movements
+// in the same db transaction, then returns the updated product.
+// ErrInsufficientStock is returned when a negative delta would take the qty of the warehouse below zero,
+// ErrWarehouseNotFound when the warehouse does not exist.
+func (db *MySQLDB) AdjustStock(ctx context.Context, rec *StockMovementRecord) (*ProductRecord, error) {
+	fLog := mysqlLog.WithField("func", "AdjustStock")
+
+	var product *ProductRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		row := tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = ? FOR UPDATE", rec.ProductID)
+		product, err = scanProduct(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrProductNotFound)
+		}
+
+		if product.Qty+rec.Delta < 0 {
+			fLog.Errorf("product %d got %s", rec.ProductID, ErrInsufficientStock.Error())
+			return ErrInsufficientStock
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE products SET qty = qty + ? WHERE id = ?", rec.Delta, rec.ProductID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+		product.Qty += rec.Delta
+
+		return moveStock(ctx, tx, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+// GetStockMovements retrieves the stock movements of a product, oldest first.
+func (db *MySQLDB) GetStockMovements(ctx context.Context, productID int, page *pagination.Page) ([]*StockMovementRecord, int, error) {
+	movements := make([]*StockMovementRecord, 0)
+	lq := &listQuery{
+		columns: "id, product_id, variant_id, warehouse_id, delta, reason, transaction_id, actor, created_at",
+		from:    "stock_movements",
+		where:   []string{"product_id = ?"},
+		args:    []interface{}{productID},
+		order:   idKeyset,
+	}
+	total, err := db.readPage(ctx, lq, page, &movements, func(rows *sql.Rows) error {
+		m := &StockMovementRecord{}
+		var variantID, transactionID sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.ProductID, &variantID, &m.WarehouseID, &m.Delta, &m.Reason, &transactionID, &m.Actor, &m.CreatedAt); err != nil {
+			return err
+		}
+		m.VariantID = int(variantID.Int64)
+		m.TransactionID = int(transactionID.Int64)
+		movements = append(movements, m)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return movements, total, nil
+}
+
+// moveStock adds the delta of rec to the stock of its warehouse, DefaultWarehouseID when it has none, and appends rec
+// to the stock movements with tx. The qty of the product or variant itself is left to the caller.
+// ErrInsufficientStock is returned when a negative delta would take the qty of the warehouse below zero.
+func moveStock(ctx context.Context, tx *sql.Tx, rec *StockMovementRecord) error {
+	if rec.WarehouseID == 0 {
+		rec.WarehouseID = DefaultWarehouseID
+	}
+
+	err := addWarehouseStock(ctx, tx, rec.WarehouseID, stockKey{ProductID: rec.ProductID, VariantID: rec.VariantID}, rec.Delta)
+	if err != nil {
+		return err
+	}
+
+	return insertStockMovement(ctx, tx, rec)
+}
+
+// addWarehouseStock adds delta to the qty the warehouse of warehouseID keeps of the variant of key, or of its product
+// when it has no variant, with tx. ErrInsufficientStock is returned when a negative delta would take the qty below zero,
+// ErrWarehouseNotFound when the warehouse does not exist.
+func addWarehouseStock(ctx context.Context, tx *sql.Tx, warehouseID int, key stockKey, delta int) error {
+	fLog := mysqlLog.WithField("func", "addWarehouseStock")
+
+	variantID := sql.NullInt64{Int64: int64(key.VariantID), Valid: key.VariantID != 0}
+	var id, qty int
+	row := tx.QueryRowContext(ctx, "SELECT id, qty FROM warehouse_stock WHERE warehouse_id = ? AND product_id = ? AND variant_id <=> ? FOR UPDATE", warehouseID, key.ProductID, variantID)
+	err := row.Scan(&id, &qty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return err
+	}
+
+	if qty+delta < 0 {
+		fLog.Errorf("warehouse %d product %d variant %d got %s", warehouseID, key.ProductID, key.VariantID, ErrInsufficientStock.Error())
+		return ErrInsufficientStock
+	}
+
+	if id == 0 {
+		_, err = tx.ExecContext(ctx, "INSERT INTO warehouse_stock(warehouse_id, product_id, variant_id, qty) VALUES(?,?,?,?)", warehouseID, key.ProductID, variantID, delta)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE warehouse_stock SET qty = qty + ? WHERE id = ?", delta, id)
+	}
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		if isNoReferencedRow(err) {
+			return ErrWarehouseNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// insertStockMovement appends rec to the stock movements with tx and sets the id of rec, a zero VariantID or TransactionID is stored as NULL
+func insertStockMovement(ctx context.Context, tx *sql.Tx, rec *StockMovementRecord) error {
+	fLog := mysqlLog.WithField("func", "insertStockMovement")
+
+	variantID := sql.NullInt64{Int64: int64(rec.VariantID), Valid: rec.VariantID != 0}
+	transactionID := sql.NullInt64{Int64: int64(rec.TransactionID), Valid: rec.TransactionID != 0}
+	result, err := tx.ExecContext(ctx, "INSERT INTO stock_movements(product_id, variant_id, warehouse_id, delta, reason, transaction_id, actor, created_at) VALUES(?,?,?,?,?,?,?,?)", rec.ProductID, variantID, rec.WarehouseID, rec.Delta, rec.Reason, transactionID, rec.Actor, rec.CreatedAt)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return err
+	}
+
+	mID, err := result.LastInsertId()
+	if err != nil {
+		fLog.Errorf("result.LastInsertId got %s", err.Error())
+		return err
+	}
+	rec.ID = int(mID)
+
+	return nil
+}
+
+// variantColumns the columns scanVariant reads, in its order
+const variantColumns = "id, product_id, sku, options, price, currency, qty"
+
+// reservedVariantQtyColumn a column of the qty reserved for the variant of the row by the reservations active at the time of its argument
+const reservedVariantQtyColumn = "(SELECT COALESCE(SUM(r.qty), 0) FROM stock_reservations r WHERE r.variant_id = product_variants.id AND r.expires_at > ?)"
+
+// scanVariant reads a variant selected with variantColumns, followed by the columns of extra when there are more
+func scanVariant(row rowScanner, extra ...interface{}) (*ProductVariantRecord, error) {
+	variant := &ProductVariantRecord{}
+	var options []byte
+	dest := []interface{}{&variant.ID, &variant.ProductID, &variant.SKU, &options, &variant.Price.Amount, &variant.Price.Currency, &variant.Qty}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	variant.Options = make(map[string]string)
+	err = json.Unmarshal(options, &variant.Options)
+	if err != nil {
+		return nil, err
+	}
+	return variant, nil
+}
+
+// variantOptions the json of the options column, an empty object when there are none
+func variantOptions(options map[string]string) ([]byte, error) {
+	if options == nil {
+		options = map[string]string{}
+	}
+	return json.Marshal(options)
+}
+
+// CreateVariant insert an entity record of variant into database and returns the persisted record.
+// The qty of the variant is recorded as its initial stock movement.
+// ErrProductNotFound is returned when its product does not exist, ErrDuplicateSKU when the sku is already used.
+func (db *MySQLDB) CreateVariant(ctx context.Context, rec *ProductVariantRecord) (*ProductVariantRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateVariant")
+
+	options, err := variantOptions(rec.Options)
+	if err != nil {
+		fLog.Errorf("json.Marshal got %s", err.Error())
+		return nil, err
+	}
+
+	var vID int64
+	err = db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "INSERT INTO product_variants(product_id, sku, options, price, currency, qty) VALUES(?,?,?,?,?,?)", rec.ProductID, rec.SKU, options, rec.Price.Amount, rec.Price.Currency, rec.Qty)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			switch {
+			case isNoReferencedRow(err):
+				return ErrProductNotFound
+			case isDuplicateEntry(err):
+				return ErrDuplicateSKU
+			}
+			return err
+		}
+
+		vID, err = result.LastInsertId()
+		if err != nil {
+			fLog.Errorf("result.LastInsertId got %s", err.Error())
+			return err
+		}
+
+		if rec.Qty == 0 {
+			return nil
+		}
+		return moveStock(ctx, tx, &StockMovementRecord{
+			ProductID: rec.ProductID,
+			VariantID: int(vID),
+			Delta:     rec.Qty,
+			Reason:    StockReasonInitial,
+			Actor:     systemActor,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	variant := copyVariant(rec)
+	variant.ID = int(vID)
+	variant.Available = rec.Qty
+	return variant, nil
+}
+
+// GetVariantByID retrieves an ProductVariantRecord from database where the variant id is specified.
+func (db *MySQLDB) GetVariantByID(ctx context.Context, variantID int) (*ProductVariantRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetVariantByID")
+
+	var reserved int
+	row := db.instance.QueryRowContext(ctx, "SELECT "+variantColumns+", "+reservedVariantQtyColumn+" FROM product_variants WHERE id = ?", time.Now(), variantID)
+	variant, err := scanVariant(row, &reserved)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrVariantNotFound)
+	}
+	variant.Available = availableQty(variant.Qty, reserved)
+
+	return variant, nil
+}
+
+// GetVariantsByProductID retrieves the variants of a product ordered by variant id.
+func (db *MySQLDB) GetVariantsByProductID(ctx context.Context, productID int, page *pagination.Page) ([]*ProductVariantRecord, int, error) {
+	variants := make([]*ProductVariantRecord, 0)
+	lq := &listQuery{
+		columns:    variantColumns + ", " + reservedVariantQtyColumn,
+		columnArgs: []interface{}{time.Now()},
+		from:       "product_variants",
+		where:      []string{"product_id = ?"},
+		args:       []interface{}{productID},
+		order:      idKeyset,
+	}
+	total, err := db.readPage(ctx, lq, page, &variants, func(rows *sql.Rows) error {
+		var reserved int
+		variant, err := scanVariant(rows, &reserved)
+		if err != nil {
+			return err
+		}
+		variant.Available = availableQty(variant.Qty, reserved)
+		variants = append(variants, variant)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return variants, total, nil
+}
+
+// productVariants reads the variants of a product ordered by variant id with q, with the qty available at now
+func productVariants(ctx context.Context, q queryer, productID int, now time.Time) ([]*ProductVariantRecord, error) {
+	fLog := mysqlLog.WithField("func", "productVariants")
+
+	rows, err := q.QueryContext(ctx, "SELECT "+variantColumns+", "+reservedVariantQtyColumn+" FROM product_variants WHERE product_id = ? ORDER BY id", now, productID)
+	if err != nil {
+		fLog.Errorf("db.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make([]*ProductVariantRecord, 0)
+	for rows.Next() {
+		var reserved int
+		variant, err := scanVariant(rows, &reserved)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+		variant.Available = availableQty(variant.Qty, reserved)
+		variants = append(variants, variant)
+	}
+
+	return variants, rows.Err()
+}
+
+// UpdateVariant update an entity record of variant in database where the variant id is specified, its product does not change.
+// A change of qty is recorded as an adjustment stock movement made by actor,
+// ErrActorRequired is returned when the qty changes without one.
+// ErrDuplicateSKU is returned when the sku is already used by another variant.
+func (db *MySQLDB) UpdateVariant(ctx context.Context, rec *ProductVariantRecord, actor string) (string, error) {
+	fLog := mysqlLog.WithField("func", "UpdateVariant")
+
+	options, err := variantOptions(rec.Options)
+	if err != nil {
+		fLog.Errorf("json.Marshal got %s", err.Error())
+		return "", err
+	}
+
+	err = db.withTx(ctx, func(tx *sql.Tx) error {
+		// lock the variant so the recorded delta matches the qty it replaces
+		var productID, qty int
+		row := tx.QueryRowContext(ctx, "SELECT product_id, qty FROM product_variants WHERE id = ? FOR UPDATE", rec.ID)
+		err := row.Scan(&productID, &qty)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrVariantNotFound)
+		}
+		if rec.Qty != qty && actor == "" {
+			return ErrActorRequired
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE product_variants SET sku=?, options=?, price=?, currency=?, qty=? WHERE id=?", rec.SKU, options, rec.Price.Amount, rec.Price.Currency, rec.Qty, rec.ID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			if isDuplicateEntry(err) {
+				return ErrDuplicateSKU
+			}
+			return err
+		}
+
+		if rec.Qty == qty {
+			return nil
+		}
+		return moveStock(ctx, tx, &StockMovementRecord{
+			ProductID: productID,
+			VariantID: rec.ID,
+			Delta:     rec.Qty - qty,
+			Reason:    StockReasonAdjustment,
+			Actor:     actor,
+			CreatedAt: time.Now(),
+		})
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "variant updated successfully", nil
+}
+
+// DeleteVariant delete an entity record of variant from database where the variant id is specified.
+// ErrVariantHasOrders is returned when transaction details still reference the variant.
+func (db *MySQLDB) DeleteVariant(ctx context.Context, variantID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "DeleteVariant")
+
+	result, err := db.instance.ExecContext(ctx, "DELETE FROM product_variants WHERE id=?", variantID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		var mysqlErr *mysql.MySQLError
+		if errors.As(err, &mysqlErr) && mysqlErr.Number == mySQLErrRowIsReferenced {
+			return "", ErrVariantHasOrders
+		}
+		return "", err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return "", err
+	}
+	if affected == 0 {
+		return "", ErrVariantNotFound
+	}
+
+	return "variant deleted successfully", nil
+}
+
+// warehouseColumns the columns scanWarehouse reads, in its order
+const warehouseColumns = "id, code, name, city, country"
+
+// scanWarehouse reads a warehouse selected with warehouseColumns
+func scanWarehouse(row rowScanner) (*WarehouseRecord, error) {
+	warehouse := &WarehouseRecord{}
+	err := row.Scan(&warehouse.ID, &warehouse.Code, &warehouse.Name, &warehouse.City, &warehouse.Country)
+	if err != nil {
+		return nil, err
+	}
+	return warehouse, nil
+}
+
+// warehouseStockColumns the columns scanWarehouseStock reads, in its order
+const warehouseStockColumns = "warehouse_id, product_id, variant_id, qty"
+
+// scanWarehouseStock reads a warehouse stock row selected with warehouseStockColumns
+func scanWarehouseStock(row rowScanner) (*WarehouseStockRecord, error) {
+	stock := &WarehouseStockRecord{}
+	var variantID sql.NullInt64
+	err := row.Scan(&stock.WarehouseID, &stock.ProductID, &variantID, &stock.Qty)
+	if err != nil {
+		return nil, err
+	}
+	stock.VariantID = int(variantID.Int64)
+	return stock, nil
+}
+
+// CreateWarehouse insert an entity record of warehouse into database and returns the persisted record.
+// ErrDuplicateWarehouseCode is returned when the code is already used.
+func (db *MySQLDB) CreateWarehouse(ctx context.Context, rec *WarehouseRecord) (*WarehouseRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateWarehouse")
+
+	result, err := db.instance.ExecContext(ctx, "INSERT INTO warehouses(code, name, city, country) VALUES(?,?,?,?)", rec.Code, rec.Name, rec.City, rec.Country)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		if isDuplicateEntry(err) {
+			return nil, ErrDuplicateWarehouseCode
+		}
+		return nil, err
+	}
+
+	wID, err := result.LastInsertId()
+	if err != nil {
+		fLog.Errorf("result.LastInsertId got %s", err.Error())
+		return nil, err
+	}
+
+	warehouse := *rec
+	warehouse.ID = int(wID)
+	return &warehouse, nil
+}
+
+// GetWarehouseByID retrieves an WarehouseRecord from database where the warehouse id is specified.
+func (db *MySQLDB) GetWarehouseByID(ctx context.Context, warehouseID int) (*WarehouseRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetWarehouseByID")
+
+	row := db.instance.QueryRowContext(ctx, "SELECT "+warehouseColumns+" FROM warehouses WHERE id = ?", warehouseID)
+	warehouse, err := scanWarehouse(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrWarehouseNotFound)
+	}
+
+	return warehouse, nil
+}
+
+// GetWarehouses retrieves every WarehouseRecord from database ordered by warehouse id.
+func (db *MySQLDB) GetWarehouses(ctx context.Context, page *pagination.Page) ([]*WarehouseRecord, int, error) {
+	warehouses := make([]*WarehouseRecord, 0)
+	lq := &listQuery{columns: warehouseColumns, from: "warehouses", order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &warehouses, func(rows *sql.Rows) error {
+		warehouse, err := scanWarehouse(rows)
+		if err != nil {
+			return err
+		}
+		warehouses = append(warehouses, warehouse)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return warehouses, total, nil
+}
+
+// getWarehouses reads every warehouse ordered by id with q
+func getWarehouses(ctx context.Context, q queryer) ([]*WarehouseRecord, error) {
+	fLog := mysqlLog.WithField("func", "getWarehouses")
+
+	rows, err := q.QueryContext(ctx, "SELECT "+warehouseColumns+" FROM warehouses ORDER BY id")
+	if err != nil {
+		fLog.Errorf("db.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	warehouses := make([]*WarehouseRecord, 0)
+	for rows.Next() {
+		warehouse, err := scanWarehouse(rows)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+		warehouses = append(warehouses, warehouse)
+	}
+
+	return warehouses, rows.Err()
+}
+
+// UpdateWarehouse update an entity record of warehouse in database where the warehouse id is specified.
+// ErrDuplicateWarehouseCode is returned when the code is already used by another warehouse.
+func (db *MySQLDB) UpdateWarehouse(ctx context.Context, rec *WarehouseRecord) (string, error) {
+	fLog := mysqlLog.WithField("func", "UpdateWarehouse")
+
+	_, err := db.instance.ExecContext(ctx, "UPDATE warehouses SET code=?, name=?, city=?, country=? WHERE id=?", rec.Code, rec.Name, rec.City, rec.Country, rec.ID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		if isDuplicateEntry(err) {
+			return "", ErrDuplicateWarehouseCode
+		}
+		return "", err
+	}
+
+	return "warehouse updated successfully", nil
+}
+
+// GetWarehouseStock retrieves the stock kept in a warehouse ordered by product id then variant id, the product itself first.
+func (db *MySQLDB) GetWarehouseStock(ctx context.Context, warehouseID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error) {
+	return db.warehouseStock(ctx, page, "product_id", "warehouse_id = ?", warehouseID)
+}
+
+// GetProductWarehouseStock retrieves the stock of a product and of its variants in every warehouse,
+// ordered by warehouse id then variant id, the product itself first.
+func (db *MySQLDB) GetProductWarehouseStock(ctx context.Context, productID int, page *pagination.Page) ([]*WarehouseStockRecord, int, error) {
+	return db.warehouseStock(ctx, page, "warehouse_id", "product_id = ?", productID)
+}
+
+// warehouseStock reads the page of the warehouse stock rows matching where with a qty, ordered by column then variant id
+func (db *MySQLDB) warehouseStock(ctx context.Context, page *pagination.Page, column string, where string, args ...interface{}) ([]*WarehouseStockRecord, int, error) {
+	stockList := make([]*WarehouseStockRecord, 0)
+	lq := &listQuery{
+		columns: warehouseStockColumns,
+		from:    "warehouse_stock",
+		where:   []string{"qty > 0", where},
+		args:    args,
+		// the null variant of a product is the product itself, it comes first
+		order: keyset{column: column, id: "COALESCE(variant_id, 0)", value: intCursorValue},
+	}
+	total, err := db.readPage(ctx, lq, page, &stockList, func(rows *sql.Rows) error {
+		stock, err := scanWarehouseStock(rows)
+		if err != nil {
+			return err
+		}
+		stockList = append(stockList, stock)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return stockList, total, nil
+}
+
+// TransferStock moves qty of a product or variant from a warehouse to another, recording a transfer stock movement
+// out of the first and into the second in the same db transaction, and returns both movements.
+// The qty of the product or variant does not change. ErrWarehouseNotFound is returned when a warehouse does not exist,
+// ErrInsufficientStock when the source warehouse does not have the qty.
+func (db *MySQLDB) TransferStock(ctx context.Context, rec *StockTransferRecord) ([]*StockMovementRecord, error) {
+	fLog := mysqlLog.WithField("func", "TransferStock")
+
+	out := &StockMovementRecord{ProductID: rec.ProductID, VariantID: rec.VariantID, WarehouseID: rec.FromWarehouseID, Delta: -rec.Qty, Reason: StockReasonTransfer, Actor: rec.Actor, CreatedAt: rec.CreatedAt}
+	in := &StockMovementRecord{ProductID: rec.ProductID, VariantID: rec.VariantID, WarehouseID: rec.ToWarehouseID, Delta: rec.Qty, Reason: StockReasonTransfer, Actor: rec.Actor, CreatedAt: rec.CreatedAt}
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		// the product or variant is locked before its stock, like an order locks them
+		var err error
+		if rec.VariantID == 0 {
+			var id int
+			row := tx.QueryRowContext(ctx, "SELECT id FROM products WHERE id = ? FOR UPDATE", rec.ProductID)
+			err = notFound(row.Scan(&id), ErrProductNotFound)
+		} else {
+			var productID int
+			row := tx.QueryRowContext(ctx, "SELECT product_id FROM product_variants WHERE id = ? FOR UPDATE", rec.VariantID)
+			err = notFound(row.Scan(&productID), ErrVariantNotFound)
+			if err == nil && productID != rec.ProductID {
+				err = ErrVariantNotFound
+			}
+		}
+		if err != nil {
+			fLog.Errorf("product %d variant %d got %s", rec.ProductID, rec.VariantID, err.Error())
+			return err
+		}
+
+		var found int
+		row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM warehouses WHERE id IN (?,?)", rec.FromWarehouseID, rec.ToWarehouseID)
+		err = row.Scan(&found)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return err
+		}
+		if found != 2 {
+			return ErrWarehouseNotFound
+		}
+
+		// the stock of the warehouses is locked in id order
+		movements := []*StockMovementRecord{out, in}
+		if in.WarehouseID < out.WarehouseID {
+			movements = []*StockMovementRecord{in, out}
+		}
+		for _, m := range movements {
+			err = moveStock(ctx, tx, m)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return []*StockMovementRecord{out, in}, nil
+}
+
+// transactionColumns the columns scanTransaction reads, in its order
+const transactionColumns = "id, user_id, date, currency, subtotal, discount, tax, tax_inclusive, shipping_cost, grand_total, status," +
+	" shipping_recipient, shipping_phone, shipping_street, shipping_city, shipping_postal_code, shipping_country"
+
+// scanTransaction reads a transaction selected with transactionColumns, without its detail
+func scanTransaction(row rowScanner) (*TransactionRecord, error) {
+	transaction := &TransactionRecord{}
+	var currency string
+	var address [6]sql.NullString
+	err := row.Scan(&transaction.ID, &transaction.UserID, &transaction.Date, &currency, &transaction.Subtotal.Amount, &transaction.Discount.Amount, &transaction.Tax.Amount, &transaction.TaxInclusive,
+		&transaction.ShippingCost.Amount, &transaction.GrandTotal.Amount, &transaction.Status,
+		&address[0], &address[1], &address[2], &address[3], &address[4], &address[5])
+	if err != nil {
+		return nil, err
+	}
+	transaction.Subtotal.Currency = currency
+	transaction.Discount.Currency = currency
+	transaction.Tax.Currency = currency
+	transaction.ShippingCost.Currency = currency
+	transaction.GrandTotal.Currency = currency
+
+	// the columns are null for an order without a shipping address
+	if address[0].Valid {
+		transaction.ShippingAddress = &PostalAddress{
+			Recipient:  address[0].String,
+			Phone:      address[1].String,
+			Street:     address[2].String,
+			City:       address[3].String,
+			PostalCode: address[4].String,
+			Country:    address[5].String,
+		}
+	}
+	return transaction, nil
+}
+
+// shippingAddressColumns the values of the shipping_* columns of the transactions table for address, null when it is nil
+func shippingAddressColumns(address *PostalAddress) [6]sql.NullString {
+	if address == nil {
+		return [6]sql.NullString{}
+	}
+	return [6]sql.NullString{
+		{String: address.Recipient, Valid: true},
+		{String: address.Phone, Valid: true},
+		{String: address.Street, Valid: true},
+		{String: address.City, Valid: true},
+		{String: address.PostalCode, Valid: true},
+		{String: address.Country, Valid: true},
+	}
+}
+
+// newTransactionDetail a detail of the transaction, the detail columns have no currency so its amounts are in the currency of the transaction
+func newTransactionDetail(transaction *TransactionRecord) *TransactionDetailRecord {
+	currency := transaction.GrandTotal.Currency
+	return &TransactionDetailRecord{SubTotal: NewMoney(0, currency), Discount: NewMoney(0, currency), Tax: NewMoney(0, currency)}
+}
+
+// GetTransactionByTransactionID retrieves the detail of a transaction from database where the transaction id is specified,
+// with the discount lines of every detail.
+func (db *MySQLDB) GetTransactionByTransactionID(ctx context.Context, transactionID int) (*TransactionRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetTransactionByTransactionID")
+
+	row := db.instance.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id = ?", transactionID)
+	transaction, err := scanTransaction(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrTransactionNotFound)
+	}
+
+	// a detail comes once per discount line, or once with null discount columns when it has none,
+	// its allocations come as a json array on every row
+	q := "SELECT td.id, td.transaction_id, td.product_id, td.variant_id, td.qty, td.sub_total, td.tax," +
+		" (SELECT JSON_ARRAYAGG(JSON_OBJECT('WarehouseID', a.warehouse_id, 'Qty', a.qty)) FROM transaction_detail_allocations a WHERE a.transaction_detail_id = td.id)," +
+		" d.coupon_id, c.code, d.amount FROM transaction_detail td" +
+		" LEFT JOIN transaction_detail_discounts d ON d.transaction_detail_id = td.id LEFT JOIN coupons c ON c.id = d.coupon_id" +
+		" WHERE td.transaction_id = ? ORDER BY td.id, d.id"
+	rows, err := db.instance.QueryContext(ctx, q, transactionID)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tDetail := make([]*TransactionDetailRecord, 0)
+	lastDetailID := 0
+	for rows.Next() {
+		tD := newTransactionDetail(transaction)
+		var detailID int
+		var variantID, couponID sql.NullInt64
+		var couponCode sql.NullString
+		var amount sql.NullInt64
+		var allocations []byte
+		err := rows.Scan(&detailID, &tD.TransactionID, &tD.ProductID, &variantID, &tD.Qty, &tD.SubTotal.Amount, &tD.Tax.Amount, &allocations, &couponID, &couponCode, &amount)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+		tD.VariantID = int(variantID.Int64)
+		// a detail of an order that was not paid has no allocation
+		if allocations != nil {
+			err = json.Unmarshal(allocations, &tD.Allocations)
+			if err != nil {
+				fLog.Errorf("json.Unmarshal got %s", err.Error())
+				return nil, err
+			}
+		}
+		if len(tDetail) == 0 || detailID != lastDetailID {
+			tDetail = append(tDetail, tD)
+			lastDetailID = detailID
+		}
+		if couponID.Valid {
+			tD = tDetail[len(tDetail)-1]
+			tD.Discounts = append(tD.Discounts, &TransactionDiscountRecord{CouponID: int(couponID.Int64), CouponCode: couponCode.String, Amount: NewMoney(amount.Int64, transaction.GrandTotal.Currency)})
+			tD.Discount.Amount += amount.Int64
+			transaction.CouponCode = couponCode.String
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return nil, err
+	}
+
+	transaction.TransactionDetail = tDetail
+
+	return transaction, nil
+}
+
+// CreateTransaction insert an entity record of transaction into database and returns the persisted record,
+// including the computed grand total and the sub total of every detail.
+// Stock is read with SELECT ... FOR UPDATE inside the db transaction, locking the products in ascending id order
+// so concurrent orders touching the same products queue up instead of overselling or deadlocking.
+func (db *MySQLDB) CreateTransaction(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error) {
+	var transaction *TransactionRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		transaction, err = createTransaction(ctx, tx, rec, db.taxCalc(), db.shippingRateProvider(), db.allocationStrategy(), reservationExpiry(rec.Date, db.reservationTTL))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// CreateTransactionIdempotent is CreateTransaction guarded by the idempotency key of idem.
+// The first request with the key creates the transaction and stores the key, its RequestHash and the response
+// built by respond in the same db transaction. A repeat of the request returns the stored record instead,
+// with replayed set, and ErrIdempotencyKeyReused is returned when the key comes with a different RequestHash.
+// An expired key is treated as a new one.
+func (db *MySQLDB) CreateTransactionIdempotent(ctx context.Context, rec *TransactionRecord, idem *IdempotencyRecord, respond IdempotentResponse) (*IdempotencyRecord, bool, error) {
+	var stored *IdempotencyRecord
+	var replayed bool
+	var err error
+
+	// two first requests racing with the same key both miss the lookup, the one inserting the key last
+	// fails on the primary key and is rolled back, then its second run replays the order of the other one
+	for attempt := 1; attempt <= 2; attempt++ {
+		err = db.withTx(ctx, func(tx *sql.Tx) error {
+			var err error
+			stored, replayed, err = createTransactionIdempotent(ctx, tx, rec, idem, respond, db.taxCalc(), db.shippingRateProvider(), db.allocationStrategy(), reservationExpiry(rec.Date, db.reservationTTL))
+			return err
+		})
+		if err == nil || !isDuplicateEntry(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return stored, replayed, nil
+}
+
+// createTransactionIdempotent replays the response stored for the key of idem or creates the transaction and stores it, with tx
+func createTransactionIdempotent(ctx context.Context, tx *sql.Tx, rec *TransactionRecord, idem *IdempotencyRecord, respond IdempotentResponse, calc TaxCalculator, rates ShippingRateProvider, strategy AllocationStrategy, reservedUntil time.Time) (*IdempotencyRecord, bool, error) {
+	fLog := mysqlLog.WithField("func", "CreateTransactionIdempotent")
+
+	stored := &IdempotencyRecord{Key: idem.Key}
+	var body string
+	row := tx.QueryRowContext(ctx, "SELECT request_hash, transaction_id, response_status, response_body, created_at, expires_at FROM idempotency_keys WHERE idempotency_key = ? FOR UPDATE", idem.Key)
+	err := row.Scan(&stored.RequestHash, &stored.TransactionID, &stored.ResponseStatus, &body, &stored.CreatedAt, &stored.ExpiresAt)
+	switch {
+	case err == nil && stored.ExpiresAt.After(idem.CreatedAt):
+		if stored.RequestHash != idem.RequestHash {
+			fLog.Errorf("key %s got %s", idem.Key, ErrIdempotencyKeyReused.Error())
+			return nil, false, ErrIdempotencyKeyReused
+		}
+		stored.ResponseBody = []byte(body)
+		return stored, true, nil
+	case err == nil:
+		// the key expired, it is free for a new order
+		_, err = tx.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE idempotency_key = ?", idem.Key)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return nil, false, err
+		}
+	case !errors.Is(err, sql.ErrNoRows):
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, false, err
+	}
+
+	transaction, err := createTransaction(ctx, tx, rec, calc, rates, strategy, reservedUntil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	status, responseBody, err := respond(transaction)
+	if err != nil {
+		fLog.Errorf("respond got %s", err.Error())
+		return nil, false, err
+	}
+
+	stored = &IdempotencyRecord{
+		Key:            idem.Key,
+		RequestHash:    idem.RequestHash,
+		TransactionID:  transaction.ID,
+		ResponseStatus: status,
+		ResponseBody:   responseBody,
+		CreatedAt:      idem.CreatedAt,
+		ExpiresAt:      idem.ExpiresAt,
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO idempotency_keys(idempotency_key, request_hash, transaction_id, response_status, response_body, created_at, expires_at) VALUES(?,?,?,?,?,?,?)",
+		stored.Key, stored.RequestHash, stored.TransactionID, stored.ResponseStatus, string(stored.ResponseBody), stored.CreatedAt, stored.ExpiresAt)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return nil, false, err
+	}
+
+	return stored, false, nil
+}
+
+// DeleteExpiredIdempotencyKeys deletes the idempotency keys that expired at or before now and returns how many were deleted.
+// An expired key is already treated as a new one, deleting it only frees its row.
+func (db *MySQLDB) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	fLog := mysqlLog.WithField("func", "DeleteExpiredIdempotencyKeys")
+
+	result, err := db.instance.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", now)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return 0, err
+	}
+
+	return int(deleted), nil
+}
+
+// createTransaction writes the transaction, its detail, its discount lines and the stock reservations held until reservedUntil with tx,
+// taxed by calc, its shipping priced by rates and its qty reserved in the warehouses strategy picks
+func createTransaction(ctx context.Context, tx *sql.Tx, rec *TransactionRecord, calc TaxCalculator, rates ShippingRateProvider, strategy AllocationStrategy, reservedUntil time.Time) (*TransactionRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateTransaction")
+
+	// the coupon is locked before the products, so concurrent orders can not both take its last use
+	var coupon *CouponRecord
+	if rec.CouponCode != "" {
+		var err error
+		coupon, err = lockCoupon(ctx, tx, rec.CouponCode, rec.UserID, rec.Date)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// create transaction record
+	address := shippingAddressColumns(rec.ShippingAddress)
+	trans, err := tx.ExecContext(ctx, "INSERT INTO transactions(user_id, date, grand_total, status, shipping_recipient, shipping_phone, shipping_street, shipping_city, shipping_postal_code, shipping_country) VALUES(?,?,?,?,?,?,?,?,?,?)",
+		rec.UserID, rec.Date, 0, TransactionStatusPending, address[0], address[1], address[2], address[3], address[4], address[5])
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return nil, err
+	}
+
+	tID, err := trans.LastInsertId()
+	if err != nil {
+		fLog.Errorf("trans.LastInsertId got %s", err.Error())
+		return nil, err
+	}
+
+	// total qty ordered per product or variant, the same one may appear in several details
+	orderedQty, keys := orderedStock(rec.TransactionDetail)
+
+	// a product only ordered in its variants is locked too, it is read for its brand and tax class
+	productIDs := make([]int, 0, len(rec.TransactionDetail))
+	locked := make(map[int]bool)
+	for _, detail := range rec.TransactionDetail {
+		if !locked[detail.ProductID] {
+			locked[detail.ProductID] = true
+			productIDs = append(productIDs, detail.ProductID)
+		}
+	}
+	sort.Ints(productIDs)
+
+	//lock product rows in ascending id order and check the stock, every reservation of a product is written under its row lock
+	products := make(map[int]*ProductRecord, len(productIDs))
+	for _, productID := range productIDs {
+		row := tx.QueryRowContext(ctx, "SELECT "+productColumns+" FROM products WHERE id = ? FOR UPDATE", productID)
+		p, err := scanProduct(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return nil, notFound(err, ErrProductNotFound)
+		}
+		products[productID] = p
+
+		qty, ok := orderedQty[stockKey{ProductID: productID}]
+		if !ok {
+			continue
+		}
+
+		reserved, err := lockReservedQty(ctx, tx, productID, rec.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		//check qty
+		if availableQty(p.Qty, reserved) < qty {
+			fLog.Errorf("product %d got %s", productID, ErrInsufficientStock.Error())
+			return nil, ErrInsufficientStock
+		}
+	}
+
+	//then the variant rows in ascending id order, the same way
+	variants := make(map[int]*ProductVariantRecord)
+	for _, key := range keys {
+		if key.VariantID == 0 {
+			continue
+		}
+
+		row := tx.QueryRowContext(ctx, "SELECT "+variantColumns+" FROM product_variants WHERE id = ? FOR UPDATE", key.VariantID)
+		v, err := scanVariant(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return nil, notFound(err, ErrVariantNotFound)
+		}
+		if v.ProductID != key.ProductID {
+			fLog.Errorf("variant %d of product %d got %s", key.VariantID, key.ProductID, ErrVariantNotFound.Error())
+			return nil, ErrVariantNotFound
+		}
+
+		reserved, err := lockVariantReservedQty(ctx, tx, key.VariantID, rec.Date)
+		if err != nil {
+			return nil, err
+		}
+
+		if availableQty(v.Qty, reserved) < orderedQty[key] {
+			fLog.Errorf("variant %d got %s", key.VariantID, ErrInsufficientStock.Error())
+			return nil, ErrInsufficientStock
+		}
+
+		variants[key.VariantID] = v
+	}
+
+	// the warehouses the order ships from are picked now and its qty is reserved in them,
+	// their stock is locked after the products and variants like when the order is paid
+	req, err := allocationRequest(ctx, tx, rec.TransactionDetail, keys, rec.Date)
+	if err != nil {
+		return nil, err
+	}
+	req.Address = copyPostalAddress(rec.ShippingAddress)
+	allocations, err := strategy.Allocate(req)
+	if err != nil {
+		fLog.Errorf("strategy.Allocate got %s", err.Error())
+		return nil, err
+	}
+
+	reservedQty, warehouseKeys := allocatedStock(req.Lines, allocations)
+	for _, key := range warehouseKeys {
+		variantID := sql.NullInt64{Int64: int64(key.VariantID), Valid: key.VariantID != 0}
+		_, err = tx.ExecContext(ctx, "INSERT INTO stock_reservations(product_id, variant_id, warehouse_id, transaction_id, qty, expires_at, created_at) VALUES(?,?,?,?,?,?,?)",
+			key.ProductID, variantID, key.WarehouseID, tID, reservedQty[key], reservedUntil, rec.Date)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return nil, err
+		}
+	}
+
+	price, err := priceOrder(rec.TransactionDetail, products, variants, coupon, calc)
+	if err != nil {
+		fLog.Errorf("priceOrder got %s", err.Error())
+		return nil, err
+	}
+
+	err = shipOrder(ctx, price, rec.TransactionDetail, rec.ShippingAddress, rates)
+	if err != nil {
+		fLog.Errorf("shipOrder got %s", err.Error())
+		return nil, err
+	}
+
+	tDetail := make([]*TransactionDetailRecord, 0, len(rec.TransactionDetail))
+
+	//loop tx detail
+	for i := 0; i < len(rec.TransactionDetail); i++ {
+		detail := rec.TransactionDetail[i]
+		variantID := sql.NullInt64{Int64: int64(detail.VariantID), Valid: detail.VariantID != 0}
+
+		//insert transaction detail
+		result, err := tx.ExecContext(ctx, "INSERT INTO transaction_detail(transaction_id, product_id, variant_id, price, qty, sub_total, tax) VALUES(?,?,?,?,?,?,?)",
+			tID, detail.ProductID, variantID, detailPrice(detail, products, variants).Amount, detail.Qty, price.SubTotals[i].Amount, price.Taxes[i].Amount)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return nil, err
+		}
+
+		tD := &TransactionDetailRecord{
+			TransactionID: int(tID),
+			ProductID:     detail.ProductID,
+			VariantID:     detail.VariantID,
+			Qty:           detail.Qty,
+			SubTotal:      price.SubTotals[i],
+			Discount:      price.Discounts[i],
+			Tax:           price.Taxes[i],
+		}
+		if !price.Discounts[i].IsZero() {
+			detailID, err := result.LastInsertId()
+			if err != nil {
+				fLog.Errorf("result.LastInsertId got %s", err.Error())
+				return nil, err
+			}
+
+			_, err = tx.ExecContext(ctx, "INSERT INTO transaction_detail_discounts(transaction_detail_id, coupon_id, amount) VALUES(?,?,?)", detailID, coupon.ID, price.Discounts[i].Amount)
+			if err != nil {
+				fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+				return nil, err
+			}
+			tD.Discounts = []*TransactionDiscountRecord{{CouponID: coupon.ID, CouponCode: coupon.Code, Amount: price.Discounts[i]}}
+		}
+		tDetail = append(tDetail, tD)
+	}
+
+	couponCode := ""
+	if coupon != nil {
+		_, err = tx.ExecContext(ctx, "INSERT INTO coupon_redemptions(coupon_id, user_id, transaction_id, created_at) VALUES(?,?,?,?)", coupon.ID, rec.UserID, tID, rec.Date)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return nil, err
+		}
+		couponCode = coupon.Code
+	}
+
+	// update transaction totals
+	_, err = tx.ExecContext(ctx, "UPDATE transactions SET currency=?, subtotal=?, discount=?, tax=?, tax_inclusive=?, shipping_cost=?, grand_total=? WHERE id=?",
+		price.GrandTotal.Currency, price.Subtotal.Amount, price.Discount.Amount, price.Tax.Amount, price.TaxInclusive, price.Shipping.Amount, price.GrandTotal.Amount, tID)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return nil, err
+	}
+
+	// record the initial status
+	_, err = tx.ExecContext(ctx, "INSERT INTO transaction_status_history(transaction_id, from_status, to_status, actor, created_at) VALUES(?,?,?,?,?)", tID, nil, TransactionStatusPending, userActor(rec.UserID), rec.Date)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return nil, err
+	}
+
+	return &TransactionRecord{
+		ID:                int(tID),
+		UserID:            rec.UserID,
+		Date:              rec.Date,
+		GrandTotal:        price.GrandTotal,
+		Status:            TransactionStatusPending,
+		CouponCode:        couponCode,
+		Subtotal:          price.Subtotal,
+		Discount:          price.Discount,
+		Tax:               price.Tax,
+		TaxInclusive:      price.TaxInclusive,
+		ShippingAddress:   copyPostalAddress(rec.ShippingAddress),
+		ShippingCost:      price.Shipping,
+		ReservedUntil:     reservedUntil,
+		TransactionDetail: tDetail,
+	}, nil
+}
+
+// lockCoupon reads the coupon of code with SELECT ... FOR UPDATE and checks the user can use it at now, with tx.
+// The lock is held until tx ends, so the usage counted here can not change before the redemption is written.
+func lockCoupon(ctx context.Context, tx *sql.Tx, code string, userID int, now time.Time) (*CouponRecord, error) {
+	fLog := mysqlLog.WithField("func", "lockCoupon")
+
+	row := tx.QueryRowContext(ctx, "SELECT "+couponColumns+" FROM coupons WHERE code = ? FOR UPDATE", normalizeCouponCode(code))
+	coupon, err := scanCoupon(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrCouponNotFound)
+	}
+
+	var totalUses, userUses int
+	row = tx.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(user_id = ?), 0) FROM coupon_redemptions WHERE coupon_id = ?", userID, coupon.ID)
+	err = row.Scan(&totalUses, &userUses)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, err
+	}
+
+	err = checkCouponUsable(coupon, now, totalUses, userUses)
+	if err != nil {
+		fLog.Errorf("coupon %s got %s", coupon.Code, err.Error())
+		return nil, err
+	}
+
+	return coupon, nil
+}
+
+// UpdateTransactionStatus moves a transaction to status and records the change in its status history.
+// Moving a pending order releases its reservation, and paying it takes the qty of every detail from the products
+// in the same db transaction; ErrInsufficientStock is returned when its reservation expired and the qty was ordered by others.
+// Moving a paid order to cancelled or refunded restores the qty of every detail to the products.
+// ErrInvalidStatusTransition is returned when the current status does not allow the move.
+func (db *MySQLDB) UpdateTransactionStatus(ctx context.Context, transactionID int, status string, actor string) (*TransactionRecord, error) {
+	fLog := mysqlLog.WithField("func", "UpdateTransactionStatus")
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockTransactionStatus(ctx, tx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		if !CanTransitionTransactionStatus(current, status) {
+			fLog.Errorf("transaction %d from %s to %s got %s", transactionID, current, status, ErrInvalidStatusTransition.Error())
+			return ErrInvalidStatusTransition
+		}
+
+		return moveTransactionStatus(ctx, tx, transactionID, current, status, actor, db.allocationStrategy())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return db.GetTransactionByTransactionID(ctx, transactionID)
+}
+
+// lockTransactionStatus reads the status of a transaction with SELECT ... FOR UPDATE with tx,
+// so concurrent status changes are applied one after another
+func lockTransactionStatus(ctx context.Context, tx *sql.Tx, transactionID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "lockTransactionStatus")
+
+	var current string
+	row := tx.QueryRowContext(ctx, "SELECT status FROM transactions WHERE id = ? FOR UPDATE", transactionID)
+	err := row.Scan(&current)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return "", notFound(err, ErrTransactionNotFound)
+	}
+
+	return current, nil
+}
+
+// moveTransactionStatus moves a transaction locked by lockTransactionStatus from current to status with tx,
+// releasing, taking from the warehouses it reserved in or restoring its stock and recording the change in its status history.
+// strategy picks the warehouses again when its reservation no longer holds the qty. The caller checks the move is allowed.
+func moveTransactionStatus(ctx context.Context, tx *sql.Tx, transactionID int, current string, status string, actor string, strategy AllocationStrategy) error {
+	fLog := mysqlLog.WithField("func", "moveTransactionStatus")
+
+	var err error
+	var reserved map[warehouseStockKey]int
+	if current == TransactionStatusPending {
+		if takesStock(current, status) {
+			reserved, err = transactionReservations(ctx, tx, transactionID, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+		err = releaseReservations(ctx, tx, transactionID)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case takesStock(current, status):
+		err = takeTransactionStock(ctx, tx, transactionID, reserved, actor, strategy)
+	case restoresStock(current, status):
+		err = restoreTransactionStock(ctx, tx, transactionID, restoreStockReason(status), actor)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE transactions SET status=? WHERE id=?", status, transactionID)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO transaction_status_history(transaction_id, from_status, to_status, actor, created_at) VALUES(?,?,?,?,?)", transactionID, current, status, actor, time.Now())
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// transactionDetailLines reads the detail of a transaction with tx, in detail id order, with the id of every detail
+func transactionDetailLines(ctx context.Context, tx *sql.Tx, transactionID int) ([]int, []*TransactionDetailRecord, error) {
+	fLog := mysqlLog.WithField("func", "transactionDetailLines")
+
+	rows, err := tx.QueryContext(ctx, "SELECT id, product_id, variant_id, qty FROM transaction_detail WHERE transaction_id = ? ORDER BY id", transactionID)
+	if err != nil {
+		fLog.Errorf("db.tx.QueryContext got %s", err.Error())
+		return nil, nil, err
+	}
+	// the rows are drained before the caller runs its updates on the connection
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	details := make([]*TransactionDetailRecord, 0)
+	for rows.Next() {
+		var id int
+		detail := &TransactionDetailRecord{}
+		var variantID sql.NullInt64
+		err := rows.Scan(&id, &detail.ProductID, &variantID, &detail.Qty)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, nil, err
+		}
+		detail.VariantID = int(variantID.Int64)
+		ids = append(ids, id)
+		details = append(details, detail)
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return nil, nil, err
+	}
+
+	return ids, details, nil
+}
+
+// takeTransactionStock takes the qty of every detail of a paid transaction from the products and variants with tx,
+// and from the warehouses it reserved, the qty of every warehouse in reserved. strategy picks the warehouses again when
+// they do not hold that qty anymore, eg. once the reservation expired. The allocation of every detail is recorded with
+// a sale stock movement for every product and variant out of every warehouse. The reservation of the transaction must
+// be released first, the qty reserved by other pending orders can not be taken.
+// Rows are locked in the same order CreateTransaction locks them, the stock of the warehouses after them.
+func takeTransactionStock(ctx context.Context, tx *sql.Tx, transactionID int, reserved map[warehouseStockKey]int, actor string, strategy AllocationStrategy) error {
+	fLog := mysqlLog.WithField("func", "takeTransactionStock")
+
+	detailIDs, details, err := transactionDetailLines(ctx, tx, transactionID)
+	if err != nil {
+		return err
+	}
+	orderedQty, keys := orderedStock(details)
+
+	now := time.Now()
+	for _, key := range keys {
+		var qty, reserved int
+		if key.VariantID == 0 {
+			row := tx.QueryRowContext(ctx, "SELECT qty FROM products WHERE id = ? FOR UPDATE", key.ProductID)
+			err = row.Scan(&qty)
+			if err != nil {
+				fLog.Errorf("row.Scan got %s", err.Error())
+				return notFound(err, ErrProductNotFound)
+			}
+			reserved, err = lockReservedQty(ctx, tx, key.ProductID, now)
+		} else {
+			row := tx.QueryRowContext(ctx, "SELECT qty FROM product_variants WHERE id = ? FOR UPDATE", key.VariantID)
+			err = row.Scan(&qty)
+			if err != nil {
+				fLog.Errorf("row.Scan got %s", err.Error())
+				return notFound(err, ErrVariantNotFound)
+			}
+			reserved, err = lockVariantReservedQty(ctx, tx, key.VariantID, now)
+		}
+		if err != nil {
+			return err
+		}
+
+		if availableQty(qty, reserved) < orderedQty[key] {
+			fLog.Errorf("product %d variant %d got %s", key.ProductID, key.VariantID, ErrInsufficientStock.Error())
+			return ErrInsufficientStock
+		}
+
+		if key.VariantID == 0 {
+			_, err = tx.ExecContext(ctx, "UPDATE products SET qty = qty - ? WHERE id = ?", orderedQty[key], key.ProductID)
+		} else {
+			_, err = tx.ExecContext(ctx, "UPDATE product_variants SET qty = qty - ? WHERE id = ?", orderedQty[key], key.VariantID)
+		}
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+	}
+
+	req, err := allocationRequest(ctx, tx, details, keys, now)
+	if err != nil {
+		return err
+	}
+	allocations, ok := reservedAllocations(req, reserved)
+	if !ok {
+		req.Address, err = transactionShippingAddress(ctx, tx, transactionID)
+		if err != nil {
+			return err
+		}
+		allocations, err = strategy.Allocate(req)
+		if err != nil {
+			fLog.Errorf("strategy.Allocate got %s", err.Error())
+			return err
+		}
+	}
+
+	for i, detailID := range detailIDs {
+		for _, allocation := range allocations[i] {
+			_, err = tx.ExecContext(ctx, "INSERT INTO transaction_detail_allocations(transaction_detail_id, warehouse_id, qty) VALUES(?,?,?)", detailID, allocation.WarehouseID, allocation.Qty)
+			if err != nil {
+				fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+				return err
+			}
+		}
+	}
+
+	allocatedQty, warehouseKeys := allocatedStock(req.Lines, allocations)
+	for _, key := range warehouseKeys {
+		err = moveStock(ctx, tx, &StockMovementRecord{
+			ProductID:     key.ProductID,
+			VariantID:     key.VariantID,
+			WarehouseID:   key.WarehouseID,
+			Delta:         -allocatedQty[key],
+			Reason:        StockReasonSale,
+			TransactionID: transactionID,
+			Actor:         actor,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// allocationRequest reads what an AllocationStrategy needs to allocate details with tx, but the shipping address:
+// the stock of their products and variants in every warehouse, locked, less the qty reserved there by the reservations
+// active at now, and the warehouses
+func allocationRequest(ctx context.Context, tx *sql.Tx, details []*TransactionDetailRecord, keys []stockKey, now time.Time) (*AllocationRequest, error) {
+	fLog := mysqlLog.WithField("func", "allocationRequest")
+
+	req := &AllocationRequest{
+		Lines:      make([]AllocationLine, 0, len(details)),
+		Stock:      make([]*WarehouseStockRecord, 0),
+		Warehouses: make([]*WarehouseRecord, 0),
+	}
+	for _, detail := range details {
+		req.Lines = append(req.Lines, AllocationLine{ProductID: detail.ProductID, VariantID: detail.VariantID, Qty: detail.Qty})
+	}
+
+	// the stock rows are locked for every product of the order, the ones of its variants that are not ordered are skipped
+	ordered := make(map[stockKey]bool, len(keys))
+	locked := make(map[int]bool)
+	placeholders := make([]string, 0, len(keys))
+	args := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		ordered[key] = true
+		if !locked[key.ProductID] {
+			locked[key.ProductID] = true
+			placeholders = append(placeholders, "?")
+			args = append(args, key.ProductID)
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx, "SELECT warehouse_id, product_id, variant_id, qty FROM warehouse_stock WHERE product_id IN ("+strings.Join(placeholders, ",")+") ORDER BY id FOR UPDATE", args...)
+	if err != nil {
+		fLog.Errorf("db.tx.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		stock, err := scanWarehouseStock(rows)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+		if ordered[stockKey{ProductID: stock.ProductID, VariantID: stock.VariantID}] {
+			req.Stock = append(req.Stock, stock)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return nil, err
+	}
+
+	// like lockReservedQty it is a locking read, so it sees the reservations committed after the snapshot of tx was taken
+	reserved, err := scanReservations(ctx, tx, "SELECT warehouse_id, product_id, variant_id, SUM(qty) FROM stock_reservations WHERE product_id IN ("+strings.Join(placeholders, ",")+") AND expires_at > ? GROUP BY warehouse_id, product_id, variant_id FOR UPDATE", append(args, now)...)
+	if err != nil {
+		return nil, err
+	}
+	req.Stock = reservableStock(req.Stock, reserved)
+
+	req.Warehouses, err = getWarehouses(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// transactionReservations reads the qty a transaction reserved in every warehouse by its reservations active at now with tx,
+// none once they expired
+func transactionReservations(ctx context.Context, tx *sql.Tx, transactionID int, now time.Time) (map[warehouseStockKey]int, error) {
+	return scanReservations(ctx, tx, "SELECT warehouse_id, product_id, variant_id, qty FROM stock_reservations WHERE transaction_id = ? AND expires_at > ?", transactionID, now)
+}
+
+// scanReservations reads the warehouse, product, variant and qty of the reservations query selects with tx,
+// adding up the qty per product or variant and warehouse
+func scanReservations(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (map[warehouseStockKey]int, error) {
+	fLog := mysqlLog.WithField("func", "scanReservations")
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		fLog.Errorf("db.tx.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	reserved := make(map[warehouseStockKey]int)
+	for rows.Next() {
+		var key warehouseStockKey
+		var variantID sql.NullInt64
+		var qty int
+		err := rows.Scan(&key.WarehouseID, &key.ProductID, &variantID, &qty)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+		key.VariantID = int(variantID.Int64)
+		reserved[key] += qty
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return nil, err
+	}
+
+	return reserved, nil
+}
+
+// transactionShippingAddress reads the city and the country a transaction is shipped to with tx, nil when it is not shipped
+func transactionShippingAddress(ctx context.Context, tx *sql.Tx, transactionID int) (*PostalAddress, error) {
+	fLog := mysqlLog.WithField("func", "transactionShippingAddress")
+
+	var city, country sql.NullString
+	row := tx.QueryRowContext(ctx, "SELECT shipping_city, shipping_country FROM transactions WHERE id = ?", transactionID)
+	err := row.Scan(&city, &country)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrTransactionNotFound)
+	}
+	if !country.Valid {
+		return nil, nil
+	}
+
+	return &PostalAddress{City: city.String, Country: country.String}, nil
+}
+
+// restoreTransactionStock gives the qty of every detail of a transaction back to the products and variants with tx,
+// and to the warehouses it was taken from, recording a stock movement with reason for every product and variant
+// into every warehouse. A detail paid before the warehouses has no allocation, its qty goes to DefaultWarehouseID.
+// Rows are updated in the same order CreateTransaction locks them.
+func restoreTransactionStock(ctx context.Context, tx *sql.Tx, transactionID int, reason string, actor string) error {
+	fLog := mysqlLog.WithField("func", "restoreTransactionStock")
+
+	_, details, err := transactionDetailLines(ctx, tx, transactionID)
+	if err != nil {
+		return err
+	}
+	orderedQty, keys := orderedStock(details)
+
+	rows, err := tx.QueryContext(ctx, "SELECT td.product_id, td.variant_id, a.warehouse_id, a.qty FROM transaction_detail_allocations a JOIN transaction_detail td ON td.id = a.transaction_detail_id WHERE td.transaction_id = ? ORDER BY a.id", transactionID)
+	if err != nil {
+		fLog.Errorf("db.tx.QueryContext got %s", err.Error())
+		return err
+	}
+	defer rows.Close()
+	lines := make([]AllocationLine, 0)
+	allocations := make([][]*AllocationRecord, 0)
+	allocated := make(map[stockKey]int)
+	for rows.Next() {
+		var line AllocationLine
+		var variantID sql.NullInt64
+		allocation := &AllocationRecord{}
+		err := rows.Scan(&line.ProductID, &variantID, &allocation.WarehouseID, &allocation.Qty)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return err
+		}
+		line.VariantID = int(variantID.Int64)
+		lines = append(lines, line)
+		allocations = append(allocations, []*AllocationRecord{allocation})
+		allocated[stockKey{ProductID: line.ProductID, VariantID: line.VariantID}] += allocation.Qty
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return err
+	}
+	for _, key := range keys {
+		if unallocated := orderedQty[key] - allocated[key]; unallocated > 0 {
+			lines = append(lines, AllocationLine{ProductID: key.ProductID, VariantID: key.VariantID})
+			allocations = append(allocations, []*AllocationRecord{{WarehouseID: DefaultWarehouseID, Qty: unallocated}})
+		}
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		err = restoreStockQty(ctx, tx, key, orderedQty[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	restoredQty, warehouseKeys := allocatedStock(lines, allocations)
+	for _, key := range warehouseKeys {
+		err = moveStock(ctx, tx, &StockMovementRecord{
+			ProductID:     key.ProductID,
+			VariantID:     key.VariantID,
+			WarehouseID:   key.WarehouseID,
+			Delta:         restoredQty[key],
+			Reason:        reason,
+			TransactionID: transactionID,
+			Actor:         actor,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreStockQty gives qty back to the variant of key, or to its product when it has no variant, with tx
+func restoreStockQty(ctx context.Context, tx *sql.Tx, key stockKey, qty int) error {
+	fLog := mysqlLog.WithField("func", "restoreStockQty")
+
+	var err error
+	if key.VariantID == 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE products SET qty = qty + ? WHERE id = ?", qty, key.ProductID)
+	} else {
+		_, err = tx.ExecContext(ctx, "UPDATE product_variants SET qty = qty + ? WHERE id = ?", qty, key.VariantID)
+	}
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// reservedQtyColumn a column of the qty reserved for the product of the row by the reservations active at the time of its argument,
+// the reservations of its variants hold the qty of the variants instead
+const reservedQtyColumn = "(SELECT COALESCE(SUM(r.qty), 0) FROM stock_reservations r WHERE r.product_id = products.id AND r.variant_id IS NULL AND r.expires_at > ?)"
+
+// lockReservedQty sums the qty reserved for a product itself by the reservations active at now with tx.
+// It is a locking read, so it sees the reservations committed after the snapshot of tx was taken.
+func lockReservedQty(ctx context.Context, tx *sql.Tx, productID int, now time.Time) (int, error) {
+	fLog := mysqlLog.WithField("func", "lockReservedQty")
+
+	var reserved int
+	row := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(qty), 0) FROM stock_reservations WHERE product_id = ? AND variant_id IS NULL AND expires_at > ? FOR UPDATE", productID, now)
+	err := row.Scan(&reserved)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return 0, err
+	}
+
+	return reserved, nil
+}
+
+// lockVariantReservedQty sums the qty reserved for a variant by the reservations active at now with tx, like lockReservedQty
+func lockVariantReservedQty(ctx context.Context, tx *sql.Tx, variantID int, now time.Time) (int, error) {
+	fLog := mysqlLog.WithField("func", "lockVariantReservedQty")
+
+	var reserved int
+	row := tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(qty), 0) FROM stock_reservations WHERE variant_id = ? AND expires_at > ? FOR UPDATE", variantID, now)
+	err := row.Scan(&reserved)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return 0, err
+	}
+
+	return reserved, nil
+}
+
+// releaseReservations deletes the stock reservations of a transaction with tx
+func releaseReservations(ctx context.Context, tx *sql.Tx, transactionID int) error {
+	fLog := mysqlLog.WithField("func", "releaseReservations")
+
+	_, err := tx.ExecContext(ctx, "DELETE FROM stock_reservations WHERE transaction_id = ?", transactionID)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// ReleaseExpiredReservations deletes the stock reservations that expired at or before now and returns how many were deleted.
+// An expired reservation already stopped holding stock, releasing it only frees its rows.
+func (db *MySQLDB) ReleaseExpiredReservations(ctx context.Context, now time.Time) (int, error) {
+	fLog := mysqlLog.WithField("func", "ReleaseExpiredReservations")
+
+	result, err := db.instance.ExecContext(ctx, "DELETE FROM stock_reservations WHERE expires_at <= ?", now)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		return 0, err
+	}
+
+	released, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return 0, err
+	}
+
+	return int(released), nil
+}
+
+// CancelExpiredTransactions cancels, as the system, the pending transactions whose reservation expired at or before now
+// and returns how many were cancelled. A transaction with a pending or authorized payment is left to its payment.
+// Every transaction is cancelled in its own db transaction, after it is locked and checked again.
+func (db *MySQLDB) CancelExpiredTransactions(ctx context.Context, now time.Time) (int, error) {
+	fLog := mysqlLog.WithField("func", "CancelExpiredTransactions")
+
+	rows, err := db.instance.QueryContext(ctx, "SELECT id FROM transactions WHERE status = ? AND NOT EXISTS "+
+		"(SELECT 1 FROM stock_reservations r WHERE r.transaction_id = transactions.id AND r.expires_at > ?) ORDER BY id", TransactionStatusPending, now)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return 0, err
+	}
+
+	cancelled := 0
+	for _, id := range ids {
+		var moved bool
+		err := db.withTx(ctx, func(tx *sql.Tx) error {
+			moved = false
+			current, err := lockTransactionStatus(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if current != TransactionStatusPending {
+				return nil
+			}
+
+			reserved, err := transactionReservations(ctx, tx, id, now)
+			if err != nil {
+				return err
+			}
+			active, err := hasActivePayment(ctx, tx, id)
+			if err != nil {
+				return err
+			}
+			if len(reserved) > 0 || active {
+				return nil
+			}
+
+			moved = true
+			return moveTransactionStatus(ctx, tx, id, current, TransactionStatusCancelled, systemActor, db.allocationStrategy())
+		})
+		if err != nil {
+			return cancelled, err
+		}
+		if moved {
+			cancelled++
+		}
+	}
+
+	return cancelled, nil
+}
+
+// hasActivePayment reports whether the transaction of transactionID has a pending, authorized or captured payment with tx
+func hasActivePayment(ctx context.Context, tx *sql.Tx, transactionID int) (bool, error) {
+	fLog := mysqlLog.WithField("func", "hasActivePayment")
+
+	var active int
+	row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM payments WHERE transaction_id = ? AND status IN (?,?,?)",
+		transactionID, PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured)
+	err := row.Scan(&active)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return false, err
+	}
+	return active > 0, nil
+}
+
+// paymentColumns the columns scanPayment reads, in its order
+const paymentColumns = "id, transaction_id, gateway, reference, amount, currency, status, created_at, updated_at"
+
+// scanPayment reads a payment selected with paymentColumns, the reference of a payment the gateway did not answer yet is NULL
+func scanPayment(row rowScanner) (*PaymentRecord, error) {
+	payment := &PaymentRecord{}
+	var reference sql.NullString
+	err := row.Scan(&payment.ID, &payment.TransactionID, &payment.Gateway, &reference, &payment.Amount.Amount, &payment.Amount.Currency, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	payment.Reference = reference.String
+
+	return payment, nil
+}
+
+// CreatePayment insert an entity record of payment into database and returns the persisted record.
+// A payment is created pending and without a reference before the gateway is asked, see UpdatePaymentAuthorization.
+// The transaction is locked so it gets a single active payment: ErrInvalidStatusTransition is returned when it can not
+// be paid, ErrPaymentInProgress when it already has a pending, authorized or captured payment and
+// ErrReservationExpired when the reservation of the pending transaction expired at the CreatedAt of rec.
+// Its status does not move the transaction, only UpdatePaymentStatus does.
+// ErrTransactionNotFound is returned when the transaction does not exist.
+func (db *MySQLDB) CreatePayment(ctx context.Context, rec *PaymentRecord) (*PaymentRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreatePayment")
+
+	var payment PaymentRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		payment = *rec
+		payment.UpdatedAt = payment.CreatedAt
+
+		current, err := lockTransactionStatus(ctx, tx, payment.TransactionID)
+		if err != nil {
+			return err
+		}
+		if !CanTransitionTransactionStatus(current, TransactionStatusPaid) {
+			fLog.Errorf("transaction %d in %s got %s", payment.TransactionID, current, ErrInvalidStatusTransition.Error())
+			return ErrInvalidStatusTransition
+		}
+
+		// a concurrent payment of the order waits for the lock above, so it sees the payment inserted below
+		active, err := hasActivePayment(ctx, tx, payment.TransactionID)
+		if err != nil {
+			return err
+		}
+		if active {
+			fLog.Errorf("transaction %d got %s", payment.TransactionID, ErrPaymentInProgress.Error())
+			return ErrPaymentInProgress
+		}
+
+		reserved, err := transactionReservations(ctx, tx, payment.TransactionID, payment.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if len(reserved) == 0 {
+			fLog.Errorf("transaction %d got %s", payment.TransactionID, ErrReservationExpired.Error())
+			return ErrReservationExpired
+		}
+
+		reference := sql.NullString{String: payment.Reference, Valid: payment.Reference != ""}
+		result, err := tx.ExecContext(ctx, "INSERT INTO payments(transaction_id, gateway, reference, amount, currency, status, created_at, updated_at) VALUES(?,?,?,?,?,?,?,?)",
+			payment.TransactionID, payment.Gateway, reference, payment.Amount.Amount, payment.Amount.Currency, payment.Status, payment.CreatedAt, payment.UpdatedAt)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		pID, err := result.LastInsertId()
+		if err != nil {
+			fLog.Errorf("result.LastInsertId got %s", err.Error())
+			return err
+		}
+		payment.ID = int(pID)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &payment, nil
+}
+
+// UpdatePaymentAuthorization records the reference and the status the gateway answered the authorization of a
+// pending payment with. Neither moves the transaction.
+// ErrInvalidPaymentTransition is returned when the payment already got its answer.
+func (db *MySQLDB) UpdatePaymentAuthorization(ctx context.Context, paymentID int, answer *GatewayPayment) (*PaymentRecord, error) {
+	fLog := mysqlLog.WithField("func", "UpdatePaymentAuthorization")
+
+	var payment *PaymentRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE id = ? FOR UPDATE", paymentID)
+		p, err := scanPayment(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrPaymentNotFound)
+		}
+		payment = p
+
+		if !canAnswerPayment(p, answer) {
+			fLog.Errorf("payment %d in %s answered %s got %s", paymentID, p.Status, answer.Status, ErrInvalidPaymentTransition.Error())
+			return ErrInvalidPaymentTransition
+		}
+
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE payments SET reference=?, status=?, updated_at=? WHERE id=?", answer.Reference, answer.Status, now, paymentID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+		payment.Reference = answer.Reference
+		payment.Status = answer.Status
+		payment.UpdatedAt = now
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// GetPaymentByReference retrieves the PaymentRecord of a gateway with the gateway reference specified.
+func (db *MySQLDB) GetPaymentByReference(ctx context.Context, gateway string, reference string) (*PaymentRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetPaymentByReference")
+
+	row := db.instance.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE gateway = ? AND reference = ?", gateway, reference)
+	payment, err := scanPayment(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrPaymentNotFound)
+	}
+
+	return payment, nil
+}
+
+// GetPaymentsByTransactionID retrieves the payments of a transaction, oldest first.
+func (db *MySQLDB) GetPaymentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*PaymentRecord, int, error) {
+	payments := make([]*PaymentRecord, 0)
+	lq := &listQuery{columns: paymentColumns, from: "payments", where: []string{"transaction_id = ?"}, args: []interface{}{transactionID}, order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &payments, func(rows *sql.Rows) error {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return err
+		}
+		payments = append(payments, payment)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return payments, total, nil
+}
+
+// UpdatePaymentStatus moves a payment to status and its transaction along with it in the same db transaction:
+// a captured payment moves the transaction to paid like UpdateTransactionStatus does, and fails with its error when the
+// transaction can not be paid; a refunded payment moves the transaction to refunded when its status allows it.
+// Moving a payment to the status it already has changes nothing, so a repeated webhook is harmless.
+// ErrInvalidPaymentTransition is returned when the current status of the payment does not allow the move.
+// The payment row is locked before the transaction row.
+func (db *MySQLDB) UpdatePaymentStatus(ctx context.Context, paymentID int, status string, actor string) (*PaymentRecord, error) {
+	fLog := mysqlLog.WithField("func", "UpdatePaymentStatus")
+
+	var payment *PaymentRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, "SELECT "+paymentColumns+" FROM payments WHERE id = ? FOR UPDATE", paymentID)
+		p, err := scanPayment(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrPaymentNotFound)
+		}
+		payment = p
+
+		if p.Status == status {
+			return nil
+		}
+		if !CanTransitionPaymentStatus(p.Status, status) {
+			fLog.Errorf("payment %d from %s to %s got %s", paymentID, p.Status, status, ErrInvalidPaymentTransition.Error())
+			return ErrInvalidPaymentTransition
+		}
+
+		if orderStatus := paymentTransactionStatus(status); orderStatus != "" {
+			current, err := lockTransactionStatus(ctx, tx, p.TransactionID)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case CanTransitionTransactionStatus(current, orderStatus):
+				err = moveTransactionStatus(ctx, tx, p.TransactionID, current, orderStatus, actor, db.allocationStrategy())
+				if err != nil {
+					return err
+				}
+			case status == PaymentStatusCaptured:
+				fLog.Errorf("transaction %d from %s to %s got %s", p.TransactionID, current, orderStatus, ErrInvalidStatusTransition.Error())
+				return ErrInvalidStatusTransition
+			}
+		}
+
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE payments SET status=?, updated_at=? WHERE id=?", status, now, paymentID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+		payment.Status = status
+		payment.UpdatedAt = now
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return payment, nil
+}
+
+// GetTransactionStatusHistory retrieves the status changes of a transaction, oldest first.
+func (db *MySQLDB) GetTransactionStatusHistory(ctx context.Context, transactionID int, page *pagination.Page) ([]*TransactionStatusHistoryRecord, int, error) {
+	history := make([]*TransactionStatusHistoryRecord, 0)
+	lq := &listQuery{
+		columns: "id, transaction_id, from_status, to_status, actor, created_at",
+		from:    "transaction_status_history",
+		where:   []string{"transaction_id = ?"},
+		args:    []interface{}{transactionID},
+		order:   idKeyset,
+	}
+	total, err := db.readPage(ctx, lq, page, &history, func(rows *sql.Rows) error {
+		h := &TransactionStatusHistoryRecord{}
+		var fromStatus sql.NullString
+		if err := rows.Scan(&h.ID, &h.TransactionID, &fromStatus, &h.ToStatus, &h.Actor, &h.CreatedAt); err != nil {
+			return err
+		}
+		h.FromStatus = fromStatus.String
+		history = append(history, h)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return history, total, nil
+}
+
+// GetTransactionsByUserID retrieves the transactions of a user with their detail, newest first.
+// Orders with the same date are ordered by descending id so the (date, id) cursor is stable.
+// The detail of the whole page is read with a single query instead of one query per order.
+func (db *MySQLDB) GetTransactionsByUserID(ctx context.Context, filter *TransactionFilter) ([]*TransactionRecord, int, error) {
+	fLog := mysqlLog.WithField("func", "GetTransactionsByUserID")
+
+	where := []string{"user_id = ?"}
+	args := []interface{}{filter.UserID}
+	if !filter.From.IsZero() {
+		where = append(where, "date >= ?")
+		args = append(args, filter.From)
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "date < ?")
+		args = append(args, filter.To)
+	}
+
+	transactions := make([]*TransactionRecord, 0)
+	byID := make(map[int]*TransactionRecord)
+	lq := &listQuery{
+		columns: transactionColumns,
+		from:    "transactions",
+		where:   where,
+		args:    args,
+		order:   keyset{column: "date", id: "id", desc: true, value: transactionCursorDate},
+	}
+	total, err := db.readPage(ctx, lq, filter.Page, &transactions, func(rows *sql.Rows) error {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return err
+		}
+		transaction.TransactionDetail = make([]*TransactionDetailRecord, 0)
+		transactions = append(transactions, transaction)
+		byID[transaction.ID] = transaction
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(transactions) == 0 {
+		return transactions, total, nil
+	}
+
+	placeholders := make([]string, 0, len(transactions))
+	detailArgs := make([]interface{}, 0, len(transactions))
+	for _, transaction := range transactions {
+		placeholders = append(placeholders, "?")
+		detailArgs = append(detailArgs, transaction.ID)
+	}
+
+	q := "SELECT transaction_id, product_id, variant_id, qty, sub_total, tax FROM transaction_detail WHERE transaction_id IN (" + strings.Join(placeholders, ",") + ") ORDER BY transaction_id, product_id"
+	detailRows, err := db.instance.QueryContext(ctx, q, detailArgs...)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		return nil, 0, err
+	}
+	defer detailRows.Close()
+
+	for detailRows.Next() {
+		var transactionID, productID, qty int
+		var variantID sql.NullInt64
+		var subTotal, tax int64
+		err := detailRows.Scan(&transactionID, &productID, &variantID, &qty, &subTotal, &tax)
+		if err != nil {
+			fLog.Errorf("detailRows.Scan got %s", err.Error())
+			return nil, 0, err
+		}
+		if transaction, ok := byID[transactionID]; ok {
+			tD := newTransactionDetail(transaction)
+			tD.TransactionID = transactionID
+			tD.ProductID = productID
+			tD.VariantID = int(variantID.Int64)
+			tD.Qty = qty
+			tD.SubTotal.Amount = subTotal
+			tD.Tax.Amount = tax
+			transaction.TransactionDetail = append(transaction.TransactionDetail, tD)
+		}
+	}
+
+	if err := detailRows.Err(); err != nil {
+		fLog.Errorf("detailRows.Err got %s", err.Error())
+		return nil, 0, err
+	}
+
+	return transactions, total, nil
+}
+
+// queryer a *sql.DB or *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// GetCart retrieves the cart of a user with the current price and stock of every product in it.
+func (db *MySQLDB) GetCart(ctx context.Context, userID int) (*CartRecord, error) {
+	return getCart(ctx, db.instance, userID)
+}
+
+// getCart reads the cart of a user joined with its products, oldest item first, with q
+func getCart(ctx context.Context, q queryer, userID int) (*CartRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetCart")
+
+	rows, err := q.QueryContext(ctx, "SELECT c.product_id, c.qty, c.added_at, "+
+		"products.id, products.brand_id, products.name, products.price, products.currency, products.qty, products.tax_class, "+reservedQtyColumn+" "+
+		"FROM cart_items c JOIN products ON products.id = c.product_id WHERE c.user_id = ? ORDER BY c.added_at, c.product_id", time.Now(), userID)
+	if err != nil {
+		fLog.Errorf("db.QueryContext got %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*CartItemRecord, 0)
+	for rows.Next() {
+		item := &CartItemRecord{UserID: userID}
+		p := &ProductRecord{}
+		var reserved int
+		err := rows.Scan(&item.ProductID, &item.Qty, &item.AddedAt,
+			&p.ID, &p.BrandID, &p.Name, &p.Price.Amount, &p.Price.Currency, &p.Qty, &p.TaxClass, &reserved)
+		if err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return nil, err
+		}
+		p.Available = availableQty(p.Qty, reserved)
+
+		err = priceCartItem(item, p)
+		if err != nil {
+			fLog.Errorf("priceCartItem got %s", err.Error())
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return nil, err
+	}
+
+	return newCartRecord(userID, items)
+}
+
+// AddCartItem adds the qty of rec to the cart of its user, on top of the qty of the product already in the cart,
+// and returns the updated cart. ErrProductNotFound is returned when the product does not exist.
+func (db *MySQLDB) AddCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error) {
+	fLog := mysqlLog.WithField("func", "AddCartItem")
+
+	var cart *CartRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var productID int
+		row := tx.QueryRowContext(ctx, "SELECT id FROM products WHERE id = ?", rec.ProductID)
+		err := row.Scan(&productID)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrProductNotFound)
+		}
+
+		// a product added again keeps the time it was first added, so the cart keeps its order
+		_, err = tx.ExecContext(ctx, "INSERT INTO cart_items(user_id, product_id, qty, added_at) VALUES(?,?,?,?) ON DUPLICATE KEY UPDATE qty = qty + VALUES(qty)",
+			rec.UserID, rec.ProductID, rec.Qty, rec.AddedAt)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		cart, err = getCart(ctx, tx, rec.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// UpdateCartItem replaces the qty of a product in the cart of a user and returns the updated cart.
+// ErrCartItemNotFound is returned when the product is not in the cart.
+func (db *MySQLDB) UpdateCartItem(ctx context.Context, rec *CartItemRecord) (*CartRecord, error) {
+	fLog := mysqlLog.WithField("func", "UpdateCartItem")
+
+	var cart *CartRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		// the row is read first, the affected rows of an update to the same qty would be zero
+		var qty int
+		row := tx.QueryRowContext(ctx, "SELECT qty FROM cart_items WHERE user_id = ? AND product_id = ? FOR UPDATE", rec.UserID, rec.ProductID)
+		err := row.Scan(&qty)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrCartItemNotFound)
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE cart_items SET qty = ? WHERE user_id = ? AND product_id = ?", rec.Qty, rec.UserID, rec.ProductID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		cart, err = getCart(ctx, tx, rec.UserID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// RemoveCartItem removes a product from the cart of a user and returns the updated cart.
+// ErrCartItemNotFound is returned when the product is not in the cart.
+func (db *MySQLDB) RemoveCartItem(ctx context.Context, userID int, productID int) (*CartRecord, error) {
+	fLog := mysqlLog.WithField("func", "RemoveCartItem")
+
+	var cart *CartRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, "DELETE FROM cart_items WHERE user_id = ? AND product_id = ?", userID, productID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			fLog.Errorf("result.RowsAffected got %s", err.Error())
+			return err
+		}
+		if affected == 0 {
+			return ErrCartItemNotFound
+		}
+
+		cart, err = getCart(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cart, nil
+}
+
+// CheckoutCart creates the transaction of the items in the cart of rec.UserID like CreateTransaction does,
+// and empties the cart in the same db transaction. The detail of rec is replaced by the items of the cart,
+// ErrCartEmpty is returned when there is none.
+// The cart rows are locked first, so a concurrent change of the cart waits for the checkout to finish.
+func (db *MySQLDB) CheckoutCart(ctx context.Context, rec *TransactionRecord) (*TransactionRecord, error) {
+	fLog := mysqlLog.WithField("func", "CheckoutCart")
+
+	var transaction *TransactionRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, "SELECT product_id, qty FROM cart_items WHERE user_id = ? ORDER BY added_at, product_id FOR UPDATE", rec.UserID)
+		if err != nil {
+			fLog.Errorf("db.tx.QueryContext got %s", err.Error())
+			return err
+		}
+		defer rows.Close()
+
+		items := make([]*CartItemRecord, 0)
+		for rows.Next() {
+			item := &CartItemRecord{UserID: rec.UserID}
+			err := rows.Scan(&item.ProductID, &item.Qty)
+			if err != nil {
+				fLog.Errorf("rows.Scan got %s", err.Error())
+				return err
+			}
+			items = append(items, item)
+		}
+		if err := rows.Err(); err != nil {
+			fLog.Errorf("rows.Err got %s", err.Error())
+			return err
+		}
+		rows.Close()
+
+		if len(items) == 0 {
+			fLog.Errorf("user %d got %s", rec.UserID, ErrCartEmpty.Error())
+			return ErrCartEmpty
+		}
+
+		transaction, err = createTransaction(ctx, tx, cartOrder(rec, items), db.taxCalc(), db.shippingRateProvider(), db.allocationStrategy(), reservationExpiry(rec.Date, db.reservationTTL))
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM cart_items WHERE user_id = ?", rec.UserID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transaction, nil
+}
+
+// GetUserByID retrieves an UserRecord from database where the user id is specified.
+// Soft deleted users are not returned.
+func (db *MySQLDB) GetUserByID(ctx context.Context, userID int) (*UserRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetUserByID")
+	user := &UserRecord{}
+
+	row := db.instance.QueryRowContext(ctx, "SELECT id, name, email, address FROM users WHERE id = ? AND deleted_at IS NULL", userID)
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Address)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrUserNotFound)
+	}
+
+	return user, nil
+}
+
+// GetUsers retrieves every UserRecord that is not soft deleted from database ordered by user id.
+func (db *MySQLDB) GetUsers(ctx context.Context, page *pagination.Page) ([]*UserRecord, int, error) {
+	userList := make([]*UserRecord, 0)
+	lq := &listQuery{columns: "id, name, email, address", from: "users", where: []string{"deleted_at IS NULL"}, order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &userList, func(rows *sql.Rows) error {
+		user := &UserRecord{}
+		if err := rows.Scan(&user.ID, &user.Name, &user.Email, &user.Address); err != nil {
+			return err
+		}
+		userList = append(userList, user)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return userList, total, nil
+}
+
+// CreateUser insert an entity record of user into database and returns the persisted record.
+// ErrDuplicateEmail is returned when the email is already used.
+func (db *MySQLDB) CreateUser(ctx context.Context, rec *UserRecord) (*UserRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateUser")
+
+	result, err := db.instance.ExecContext(ctx, "INSERT INTO users(name, email, address) VALUES(?,?,?)", rec.Name, rec.Email, rec.Address)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		if isDuplicateEntry(err) {
+			return nil, ErrDuplicateEmail
+		}
+		return nil, err
+	}
+
+	uID, err := result.LastInsertId()
+	if err != nil {
+		fLog.Errorf("result.LastInsertId got %s", err.Error())
+		return nil, err
+	}
+
+	return &UserRecord{
+		ID:      int(uID),
+		Name:    rec.Name,
+		Email:   rec.Email,
+		Address: rec.Address,
+	}, nil
+}
+
+// UpdateUser update an entity record of user in database where the user id is specified.
+// ErrUserNotFound is returned when the user does not exist or is soft deleted,
+// ErrDuplicateEmail when the email is already used by another user.
+func (db *MySQLDB) UpdateUser(ctx context.Context, rec *UserRecord) (string, error) {
+	fLog := mysqlLog.WithField("func", "UpdateUser")
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		// mysql reports no affected rows for an unchanged user too, so look it up first
+		err := lockUser(ctx, tx, rec.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE users SET name=?, email=?, address=? WHERE id=? AND deleted_at IS NULL", rec.Name, rec.Email, rec.Address, rec.ID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			if isDuplicateEntry(err) {
+				return ErrDuplicateEmail
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "user updated successfully", nil
+}
+
+// DeleteUser soft delete an entity record of user where the user id is specified.
+// The email of a soft deleted user stays reserved.
+func (db *MySQLDB) DeleteUser(ctx context.Context, userID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "DeleteUser")
+
+	result, err := db.instance.ExecContext(ctx, "UPDATE users SET deleted_at=? WHERE id=? AND deleted_at IS NULL", time.Now(), userID)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		return "", err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		fLog.Errorf("result.RowsAffected got %s", err.Error())
+		return "", err
+	}
+	if affected == 0 {
+		return "", ErrUserNotFound
+	}
+
+	return "user deleted successfully", nil
+}
+
+// couponColumns the columns scanCoupon reads, in its order
+const couponColumns = "id, code, type, value, currency, buy_qty, get_qty, brand_id, min_spend, usage_limit, usage_limit_per_user, starts_at, ends_at"
+
+// rowScanner a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCoupon reads a coupon selected with couponColumns, the null currency, brand and dates are zero
+func scanCoupon(row rowScanner) (*CouponRecord, error) {
+	coupon := &CouponRecord{}
+	var currency sql.NullString
+	var brandID sql.NullInt64
+	var startsAt, endsAt sql.NullTime
+	err := row.Scan(&coupon.ID, &coupon.Code, &coupon.Type, &coupon.Value, &currency, &coupon.BuyQty, &coupon.GetQty, &brandID, &coupon.MinSpend, &coupon.UsageLimit, &coupon.UsageLimitPerUser, &startsAt, &endsAt)
+	if err != nil {
+		return nil, err
+	}
+	coupon.Currency = currency.String
+	coupon.BrandID = int(brandID.Int64)
+	coupon.StartsAt = startsAt.Time
+	coupon.EndsAt = endsAt.Time
+
+	return coupon, nil
+}
+
+// CreateCoupon insert an entity record of coupon into database and returns the persisted record.
+// The code is stored upper case, ErrDuplicateCouponCode is returned when it is already used.
+func (db *MySQLDB) CreateCoupon(ctx context.Context, rec *CouponRecord) (*CouponRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateCoupon")
+
+	err := validateCoupon(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	coupon := *rec
+	coupon.Code = normalizeCouponCode(rec.Code)
+	currency := sql.NullString{String: coupon.Currency, Valid: coupon.Currency != ""}
+	brandID := sql.NullInt64{Int64: int64(coupon.BrandID), Valid: coupon.BrandID != 0}
+	startsAt := sql.NullTime{Time: coupon.StartsAt, Valid: !coupon.StartsAt.IsZero()}
+	endsAt := sql.NullTime{Time: coupon.EndsAt, Valid: !coupon.EndsAt.IsZero()}
+
+	result, err := db.instance.ExecContext(ctx, "INSERT INTO coupons(code, type, value, currency, buy_qty, get_qty, brand_id, min_spend, usage_limit, usage_limit_per_user, starts_at, ends_at) VALUES(?,?,?,?,?,?,?,?,?,?,?,?)",
+		coupon.Code, coupon.Type, coupon.Value, currency, coupon.BuyQty, coupon.GetQty, brandID, coupon.MinSpend, coupon.UsageLimit, coupon.UsageLimitPerUser, startsAt, endsAt)
+	if err != nil {
+		fLog.Errorf("db.instance.ExecContext got %s", err.Error())
+		if isDuplicateEntry(err) {
+			return nil, ErrDuplicateCouponCode
+		}
+		return nil, err
+	}
+
+	cID, err := result.LastInsertId()
+	if err != nil {
+		fLog.Errorf("result.LastInsertId got %s", err.Error())
+		return nil, err
+	}
+	coupon.ID = int(cID)
+
+	return &coupon, nil
+}
+
+// GetCouponByID retrieves an CouponRecord from database where the coupon id is specified.
+func (db *MySQLDB) GetCouponByID(ctx context.Context, couponID int) (*CouponRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetCouponByID")
+
+	row := db.instance.QueryRowContext(ctx, "SELECT "+couponColumns+" FROM coupons WHERE id = ?", couponID)
+	coupon, err := scanCoupon(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrCouponNotFound)
+	}
+
+	return coupon, nil
+}
+
+// GetCoupons retrieves every CouponRecord from database ordered by coupon id.
+func (db *MySQLDB) GetCoupons(ctx context.Context, page *pagination.Page) ([]*CouponRecord, int, error) {
+	couponList := make([]*CouponRecord, 0)
+	lq := &listQuery{columns: couponColumns, from: "coupons", order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &couponList, func(rows *sql.Rows) error {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return err
+		}
+		couponList = append(couponList, coupon)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return couponList, total, nil
+}
+
+// lockUser checks the user exists and is not soft deleted with SELECT ... FOR UPDATE with tx,
+// so concurrent changes of the address book of the user are applied one after another
+func lockUser(ctx context.Context, tx *sql.Tx, userID int) error {
+	fLog := mysqlLog.WithField("func", "lockUser")
+
+	var id int
+	row := tx.QueryRowContext(ctx, "SELECT id FROM users WHERE id = ? AND deleted_at IS NULL FOR UPDATE", userID)
+	err := row.Scan(&id)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return notFound(err, ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// addressColumns the columns scanAddress reads, in its order
+const addressColumns = "id, user_id, label, recipient, phone, street, city, postal_code, country, is_default, created_at"
+
+// scanAddress reads an address selected with addressColumns
+func scanAddress(row rowScanner) (*AddressRecord, error) {
+	address := &AddressRecord{}
+	err := row.Scan(&address.ID, &address.UserID, &address.Label, &address.Recipient, &address.Phone, &address.Street, &address.City, &address.PostalCode, &address.Country, &address.IsDefault, &address.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// clearDefaultAddress takes the default from every address of a user locked by lockUser with tx
+func clearDefaultAddress(ctx context.Context, tx *sql.Tx, userID int) error {
+	fLog := mysqlLog.WithField("func", "clearDefaultAddress")
+
+	_, err := tx.ExecContext(ctx, "UPDATE addresses SET is_default = 0 WHERE user_id = ? AND is_default = 1", userID)
+	if err != nil {
+		fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// CreateAddress insert an entity record of address into database and returns the persisted record.
+// The first address of a user is its default, and a default address takes the default from the other addresses of the user.
+// ErrUserNotFound is returned when the user does not exist.
+func (db *MySQLDB) CreateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateAddress")
+
+	address := normalizeAddress(rec)
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		err := lockUser(ctx, tx, address.UserID)
+		if err != nil {
+			return err
+		}
+
+		if address.IsDefault {
+			err = clearDefaultAddress(ctx, tx, address.UserID)
+			if err != nil {
+				return err
+			}
+		} else {
+			var count int
+			err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM addresses WHERE user_id = ?", address.UserID).Scan(&count)
+			if err != nil {
+				fLog.Errorf("row.Scan got %s", err.Error())
+				return err
+			}
+			address.IsDefault = count == 0
+		}
+
+		result, err := tx.ExecContext(ctx, "INSERT INTO addresses(user_id, label, recipient, phone, street, city, postal_code, country, is_default, created_at) VALUES(?,?,?,?,?,?,?,?,?,?)",
+			address.UserID, address.Label, address.Recipient, address.Phone, address.Street, address.City, address.PostalCode, address.Country, address.IsDefault, address.CreatedAt)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		aID, err := result.LastInsertId()
+		if err != nil {
+			fLog.Errorf("result.LastInsertId got %s", err.Error())
+			return err
+		}
+		address.ID = int(aID)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// GetAddressByID retrieves an AddressRecord from database where the address id is specified.
+func (db *MySQLDB) GetAddressByID(ctx context.Context, addressID int) (*AddressRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetAddressByID")
+
+	row := db.instance.QueryRowContext(ctx, "SELECT "+addressColumns+" FROM addresses WHERE id = ?", addressID)
+	address, err := scanAddress(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrAddressNotFound)
+	}
+
+	return address, nil
+}
+
+// GetDefaultAddress retrieves the default address of a user, ErrAddressNotFound is returned when it has none.
+func (db *MySQLDB) GetDefaultAddress(ctx context.Context, userID int) (*AddressRecord, error) {
+	fLog := mysqlLog.WithField("func", "GetDefaultAddress")
+
+	row := db.instance.QueryRowContext(ctx, "SELECT "+addressColumns+" FROM addresses WHERE user_id = ? AND is_default = 1", userID)
+	address, err := scanAddress(row)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return nil, notFound(err, ErrAddressNotFound)
+	}
+
+	return address, nil
+}
+
+// GetAddressesByUserID retrieves the address book of a user ordered by address id.
+func (db *MySQLDB) GetAddressesByUserID(ctx context.Context, userID int, page *pagination.Page) ([]*AddressRecord, int, error) {
+	addresses := make([]*AddressRecord, 0)
+	lq := &listQuery{columns: addressColumns, from: "addresses", where: []string{"user_id = ?"}, args: []interface{}{userID}, order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &addresses, func(rows *sql.Rows) error {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return err
+		}
+		addresses = append(addresses, address)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return addresses, total, nil
+}
+
+// UpdateAddress update an entity record of address in database where the address id and user id are specified,
+// and returns the updated record. Making it the default takes the default from the other addresses of the user,
+// the default address can not be unset this way. The orders already shipped to the address keep their copy of it.
+// ErrAddressNotFound is returned when the user has no such address.
+func (db *MySQLDB) UpdateAddress(ctx context.Context, rec *AddressRecord) (*AddressRecord, error) {
+	fLog := mysqlLog.WithField("func", "UpdateAddress")
+
+	address := normalizeAddress(rec)
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		// the address of a missing user is missing too
+		err := lockUser(ctx, tx, address.UserID)
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrAddressNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		row := tx.QueryRowContext(ctx, "SELECT "+addressColumns+" FROM addresses WHERE id = ? AND user_id = ? FOR UPDATE", address.ID, address.UserID)
+		current, err := scanAddress(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrAddressNotFound)
+		}
+		address.CreatedAt = current.CreatedAt
+
+		switch {
+		case current.IsDefault:
+			address.IsDefault = true
+		case address.IsDefault:
+			err = clearDefaultAddress(ctx, tx, address.UserID)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx, "UPDATE addresses SET label=?, recipient=?, phone=?, street=?, city=?, postal_code=?, country=?, is_default=? WHERE id=?",
+			address.Label, address.Recipient, address.Phone, address.Street, address.City, address.PostalCode, address.Country, address.IsDefault, address.ID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// DeleteAddress delete an entity record of address from database where the address id and user id are specified.
+// Deleting the default address makes the oldest remaining address of the user its default.
+// ErrAddressNotFound is returned when the user has no such address.
+func (db *MySQLDB) DeleteAddress(ctx context.Context, userID int, addressID int) (string, error) {
+	fLog := mysqlLog.WithField("func", "DeleteAddress")
+
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		err := lockUser(ctx, tx, userID)
+		if errors.Is(err, ErrUserNotFound) {
+			return ErrAddressNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var isDefault bool
+		row := tx.QueryRowContext(ctx, "SELECT is_default FROM addresses WHERE id = ? AND user_id = ? FOR UPDATE", addressID, userID)
+		err = row.Scan(&isDefault)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrAddressNotFound)
+		}
+
+		_, err = tx.ExecContext(ctx, "DELETE FROM addresses WHERE id = ?", addressID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+
+		if isDefault {
+			_, err = tx.ExecContext(ctx, "UPDATE addresses SET is_default = 1 WHERE user_id = ? ORDER BY id LIMIT 1", userID)
+			if err != nil {
+				fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return "address deleted successfully", nil
+}
+
+// shipmentColumns the columns scanShipment reads, in its order
+const shipmentColumns = "id, transaction_id, carrier, tracking_number, status, created_at, updated_at"
+
+// scanShipment reads a shipment selected with shipmentColumns
+func scanShipment(row rowScanner) (*ShipmentRecord, error) {
+	shipment := &ShipmentRecord{}
+	err := row.Scan(&shipment.ID, &shipment.TransactionID, &shipment.Carrier, &shipment.TrackingNumber, &shipment.Status, &shipment.CreatedAt, &shipment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return shipment, nil
+}
+
+// CreateShipment insert an entity record of shipment into database and returns the persisted record, in transit.
+// The first shipment of a paid transaction moves it to shipped in the same db transaction, a shipped transaction
+// may get more shipments for the parcels sent later. ErrInvalidStatusTransition is returned for a transaction
+// in any other status, ErrDuplicateTrackingNumber when the carrier already has a shipment with the tracking number.
+func (db *MySQLDB) CreateShipment(ctx context.Context, rec *ShipmentRecord, actor string) (*ShipmentRecord, error) {
+	fLog := mysqlLog.WithField("func", "CreateShipment")
+
+	shipment := *rec
+	shipment.Status = ShipmentStatusInTransit
+	shipment.UpdatedAt = shipment.CreatedAt
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		current, err := lockTransactionStatus(ctx, tx, shipment.TransactionID)
+		if err != nil {
+			return err
+		}
+
+		switch current {
+		case TransactionStatusPaid:
+			err = moveTransactionStatus(ctx, tx, shipment.TransactionID, current, TransactionStatusShipped, actor, db.allocationStrategy())
+			if err != nil {
+				return err
+			}
+		case TransactionStatusShipped:
+		default:
+			fLog.Errorf("transaction %d in %s got %s", shipment.TransactionID, current, ErrInvalidStatusTransition.Error())
+			return ErrInvalidStatusTransition
+		}
+
+		result, err := tx.ExecContext(ctx, "INSERT INTO shipments(transaction_id, carrier, tracking_number, status, created_at, updated_at) VALUES(?,?,?,?,?,?)",
+			shipment.TransactionID, shipment.Carrier, shipment.TrackingNumber, shipment.Status, shipment.CreatedAt, shipment.UpdatedAt)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			if isDuplicateEntry(err) {
+				return ErrDuplicateTrackingNumber
+			}
+			return err
+		}
+
+		sID, err := result.LastInsertId()
+		if err != nil {
+			fLog.Errorf("result.LastInsertId got %s", err.Error())
+			return err
+		}
+		shipment.ID = int(sID)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &shipment, nil
+}
+
+// GetShipmentsByTransactionID retrieves the shipments of a transaction, oldest first.
+func (db *MySQLDB) GetShipmentsByTransactionID(ctx context.Context, transactionID int, page *pagination.Page) ([]*ShipmentRecord, int, error) {
+	shipments := make([]*ShipmentRecord, 0)
+	lq := &listQuery{columns: shipmentColumns, from: "shipments", where: []string{"transaction_id = ?"}, args: []interface{}{transactionID}, order: idKeyset}
+	total, err := db.readPage(ctx, lq, page, &shipments, func(rows *sql.Rows) error {
+		shipment, err := scanShipment(rows)
+		if err != nil {
+			return err
+		}
+		shipments = append(shipments, shipment)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return shipments, total, nil
+}
+
+// UpdateShipmentStatus moves a shipment to status. Once no other shipment of its shipped transaction is on its way,
+// delivering it completes the transaction in the same db transaction. Moving a shipment to the status it already has
+// changes nothing. ErrInvalidShipmentTransition is returned when the current status of the shipment does not allow the move.
+// The transaction row is locked before the shipment row, like CreateShipment does, so the deliveries of the shipments
+// of a transaction are applied one after another.
+func (db *MySQLDB) UpdateShipmentStatus(ctx context.Context, shipmentID int, status string, actor string) (*ShipmentRecord, error) {
+	fLog := mysqlLog.WithField("func", "UpdateShipmentStatus")
+
+	var shipment *ShipmentRecord
+	err := db.withTx(ctx, func(tx *sql.Tx) error {
+		var transactionID int
+		err := tx.QueryRowContext(ctx, "SELECT transaction_id FROM shipments WHERE id = ?", shipmentID).Scan(&transactionID)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrShipmentNotFound)
+		}
+
+		current, err := lockTransactionStatus(ctx, tx, transactionID)
+		if err != nil {
+			return err
+		}
+
+		row := tx.QueryRowContext(ctx, "SELECT "+shipmentColumns+" FROM shipments WHERE id = ? FOR UPDATE", shipmentID)
+		shipment, err = scanShipment(row)
+		if err != nil {
+			fLog.Errorf("row.Scan got %s", err.Error())
+			return notFound(err, ErrShipmentNotFound)
+		}
+
+		if shipment.Status == status {
+			return nil
+		}
+		if !CanTransitionShipmentStatus(shipment.Status, status) {
+			fLog.Errorf("shipment %d from %s to %s got %s", shipmentID, shipment.Status, status, ErrInvalidShipmentTransition.Error())
+			return ErrInvalidShipmentTransition
+		}
+
+		if status == ShipmentStatusDelivered && current == TransactionStatusShipped {
+			// a locking read, it sees the deliveries committed while waiting for the transaction row
+			var onItsWay int
+			row := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM shipments WHERE transaction_id = ? AND id <> ? AND status IN (?,?) FOR UPDATE",
+				transactionID, shipmentID, ShipmentStatusInTransit, ShipmentStatusOutForDelivery)
+			err = row.Scan(&onItsWay)
+			if err != nil {
+				fLog.Errorf("row.Scan got %s", err.Error())
+				return err
+			}
+
+			if onItsWay == 0 {
+				err = moveTransactionStatus(ctx, tx, transactionID, current, TransactionStatusCompleted, actor, db.allocationStrategy())
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		now := time.Now()
+		_, err = tx.ExecContext(ctx, "UPDATE shipments SET status=?, updated_at=? WHERE id=?", status, now, shipmentID)
+		if err != nil {
+			fLog.Errorf("db.tx.ExecContext got %s", err.Error())
+			return err
+		}
+		shipment.Status = status
+		shipment.UpdatedAt = now
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return shipment, nil
+}
+
+// isNoReferencedRow reports whether err is a foreign key violation of a child row
+func isNoReferencedRow(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mySQLErrNoReferencedRow
+}
+
+// isDuplicateEntry reports whether err is a unique index violation
+func isDuplicateEntry(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == mySQLErrDupEntry
 }
@@ -3,12 +3,17 @@ package connectors
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+	"github.com/go-sql-driver/mysql"
 	"github.com/sirupsen/logrus"
 )
 
@@ -59,11 +64,14 @@ func TestCreateBrand(t *testing.T) {
 			Name: "test brand",
 		}
 
-		_, err = mySQL.CreateBrand(context.Background(), brand)
+		result, err := mySQL.CreateBrand(context.Background(), brand)
 		if err != nil {
 			t.Error("error shouldnt be occurs")
 			t.FailNow()
 		}
+		if result.ID != 12 {
+			t.Errorf("expecting brand id 12 but got %d", result.ID)
+		}
 	})
 }
 
@@ -103,8 +111,8 @@ func TestGetBrandByID(t *testing.T) {
 		}
 
 		_, err = mySQL.GetBrandByID(context.Background(), 1)
-		if err == nil {
-			t.Error("error should be occurs")
+		if err != ErrBrandNotFound {
+			t.Errorf("expecting ErrBrandNotFound but got %v", err)
 			t.FailNow()
 		}
 	})
@@ -133,32 +141,23 @@ func TestGetBrandByID(t *testing.T) {
 	})
 }
 
-func TestCreateProduct(t *testing.T) {
+func TestGetBrands(t *testing.T) {
 	logrus.SetLevel(logrus.TraceLevel)
 	logrus.SetOutput(ioutil.Discard)
 
-	t.Run("error-create-product", func(t *testing.T) {
+	t.Run("error-exec-query-context", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectExec("INSERT INTO products").WillReturnError(fmt.Errorf("Error DB"))
-
+		mock.ExpectQuery("SELECT (.+) FROM brands").WillReturnError(fmt.Errorf("Error DB"))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
-
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		product := &ProductRecord{
-			BrandID: 1,
-			Name:    "test product",
-			Qty:     1,
-			Price:   1000,
-		}
-
-		_, err = mySQL.CreateProduct(context.Background(), product)
+		_, _, err = mySQL.GetBrands(context.Background(), nil)
 		if err == nil {
 			t.Error("error should be occurs")
 			t.FailNow()
@@ -167,7 +166,11 @@ func TestCreateProduct(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectExec("INSERT INTO products").WillReturnResult(sqlmock.NewResult(12, 1))
+		rows := sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(1, "apple").
+			AddRow(2, "lenovo")
+
+		mock.ExpectQuery("SELECT (.+) FROM brands").WillReturnRows(rows)
 
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -179,38 +182,36 @@ func TestCreateProduct(t *testing.T) {
 			instance: db,
 		}
 
-		product := &ProductRecord{
-			BrandID: 1,
-			Name:    "test product",
-			Qty:     1,
-			Price:   1000,
-		}
-
-		_, err = mySQL.CreateProduct(context.Background(), product)
+		brands, _, err := mySQL.GetBrands(context.Background(), nil)
 		if err != nil {
 			t.Error("error shouldnt be occurs")
 			t.FailNow()
 		}
+		if len(brands) != 2 {
+			t.Errorf("expecting 2 brands but got %d", len(brands))
+		}
 	})
 }
 
-func TestGetProductByID(t *testing.T) {
+func TestUpdateBrand(t *testing.T) {
 	logrus.SetLevel(logrus.TraceLevel)
 	logrus.SetOutput(ioutil.Discard)
 
-	t.Run("error-exec-query-row-context", func(t *testing.T) {
+	t.Run("error-update-brand", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(fmt.Errorf("Error DB"))
+		mock.ExpectExec("UPDATE brands").WillReturnError(fmt.Errorf("Error DB"))
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetProductByID(context.Background(), 1)
+		_, err = mySQL.UpdateBrand(context.Background(), &BrandRecord{ID: 1, Name: "test brand"})
 		if err == nil {
 			t.Error("error should be occurs")
 			t.FailNow()
@@ -219,28 +220,57 @@ func TestGetProductByID(t *testing.T) {
 
 	t.Run("error-not-found", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("UPDATE brands").WithArgs("test brand", 1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT id FROM brands WHERE id = (.+)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetProductByID(context.Background(), 1)
-		if err == nil {
-			t.Error("error should be occurs")
-			t.FailNow()
+		_, err = mySQL.UpdateBrand(context.Background(), &BrandRecord{ID: 1, Name: "test brand"})
+		if err != ErrBrandNotFound {
+			t.Errorf("expecting ErrBrandNotFound but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
 		}
 	})
 
-	t.Run("success", func(t *testing.T) {
+	t.Run("success-unchanged", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		rows := sqlmock.NewRows([]string{"id", "product_id", "name", "qty", "price"}).AddRow(1, 1, "name", 1, 1000)
+		// mysql reports no affected rows when the name does not change
+		mock.ExpectExec("UPDATE brands").WithArgs("test brand", 1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT id FROM brands WHERE id = (.+)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnRows(rows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateBrand(context.Background(), &BrandRecord{ID: 1, Name: "test brand"})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		// the brand changed, so it is not looked up
+		mock.ExpectExec("UPDATE brands").WithArgs("test brand", 1).WillReturnResult(sqlmock.NewResult(0, 1))
 
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -252,64 +282,66 @@ func TestGetProductByID(t *testing.T) {
 			instance: db,
 		}
 
-		_, err = mySQL.GetProductByID(context.Background(), 1)
+		_, err = mySQL.UpdateBrand(context.Background(), &BrandRecord{ID: 1, Name: "test brand"})
 		if err != nil {
 			t.Error("error shouldnt be occurs")
 			t.FailNow()
 		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
 	})
 }
 
-func TestGetProductByBrandID(t *testing.T) {
+func TestDeleteBrand(t *testing.T) {
 	logrus.SetLevel(logrus.TraceLevel)
 	logrus.SetOutput(ioutil.Discard)
 
-	t.Run("error-exec-query-row-context", func(t *testing.T) {
+	t.Run("error-brand-has-products", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(fmt.Errorf("Error DB"))
+		mock.ExpectExec("DELETE FROM brands").WillReturnError(&mysql.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row"})
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetProductByBrandID(context.Background(), 1)
-		if err == nil {
-			t.Error("error should be occurs")
+		_, err = mySQL.DeleteBrand(context.Background(), 1)
+		if err != ErrBrandHasProducts {
+			t.Errorf("expecting ErrBrandHasProducts but got %v", err)
 			t.FailNow()
 		}
 	})
 
 	t.Run("error-not-found", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("DELETE FROM brands").WillReturnResult(sqlmock.NewResult(0, 0))
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetProductByBrandID(context.Background(), 1)
-		if err == nil {
-			t.Error("error should be occurs")
+		_, err = mySQL.DeleteBrand(context.Background(), 1)
+		if err != ErrBrandNotFound {
+			t.Errorf("expecting ErrBrandNotFound but got %v", err)
 			t.FailNow()
 		}
 	})
 
 	t.Run("success", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		rows := sqlmock.NewRows([]string{"id", "product_id", "name", "qty", "price"}).
-			AddRow(1, 1, "name 1", 1, 1000).
-			AddRow(2, 1, "name 2", 2, 1100).
-			AddRow(3, 1, "name 3", 3, 1200)
-
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnRows(rows)
+		mock.ExpectExec("DELETE FROM brands").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
 
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -321,7 +353,7 @@ func TestGetProductByBrandID(t *testing.T) {
 			instance: db,
 		}
 
-		_, err = mySQL.GetProductByBrandID(context.Background(), 1)
+		_, err = mySQL.DeleteBrand(context.Background(), 1)
 		if err != nil {
 			t.Error("error shouldnt be occurs")
 			t.FailNow()
@@ -329,104 +361,169 @@ func TestGetProductByBrandID(t *testing.T) {
 	})
 }
 
-func TestGetTransactionByTransactionID(t *testing.T) {
+func TestCreateProduct(t *testing.T) {
 	logrus.SetLevel(logrus.TraceLevel)
 	logrus.SetOutput(ioutil.Discard)
 
-	t.Run("error-exec-query-row-context", func(t *testing.T) {
+	t.Run("error-create-product", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnError(fmt.Errorf("Error DB"))
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO products").WillReturnError(fmt.Errorf("Error DB"))
+		mock.ExpectRollback()
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetTransactionByTransactionID(context.Background(), 1)
+		product := &ProductRecord{
+			BrandID: 1,
+			Name:    "test product",
+			Qty:     1,
+			Price:   NewMoney(1000, CurrencyIDR),
+		}
+
+		_, err = mySQL.CreateProduct(context.Background(), product)
 		if err == nil {
 			t.Error("error should be occurs")
 			t.FailNow()
 		}
 	})
 
-	t.Run("error-not-found", func(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnError(sql.ErrNoRows)
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO products").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 12, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}))
+		mock.ExpectExec("INSERT INTO warehouse_stock").WithArgs(1, 12, nil, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(12, nil, 1, 1, "initial", nil, "system", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetTransactionByTransactionID(context.Background(), 1)
-		if err == nil {
-			t.Error("error should be occurs")
+		product := &ProductRecord{
+			BrandID: 1,
+			Name:    "test product",
+			Qty:     1,
+			Price:   NewMoney(1000, CurrencyIDR),
+		}
+
+		result, err := mySQL.CreateProduct(context.Background(), product)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
 			t.FailNow()
 		}
+		if result.ID != 12 {
+			t.Errorf("expecting product id 12 but got %d", result.ID)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
 	})
+}
 
-	t.Run("success", func(t *testing.T) {
-		db, mock, err := sqlmock.New()
-		rows := sqlmock.NewRows([]string{"id", "user_id", "date", "grand_total"}).
-			AddRow(1, 1, time.Now(), 1000)
-
-		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnRows(rows)
+func TestGetProductByID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
 
-		rows = sqlmock.NewRows([]string{"transaction_id", "product_id", "price", "qty", "sub_total"}).
-			AddRow(1, 1, 100, 1, 1000).
-			AddRow(1, 2, 100, 1, 1000).
-			AddRow(1, 2, 100, 1, 1000)
+	t.Run("error-exec-query-row-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
 
-		mock.ExpectQuery("SELECT (.+) FROM transaction_detail").WillReturnRows(rows)
+		_, err = mySQL.GetProductByID(context.Background(), 1)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
 
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(sql.ErrNoRows)
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
-
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetTransactionByTransactionID(context.Background(), 1)
-		if err != nil {
-			t.Error("error shouldnt be occurs")
+		_, err = mySQL.GetProductByID(context.Background(), 1)
+		if err != ErrProductNotFound {
+			t.Errorf("expecting ErrProductNotFound but got %v", err)
 			t.FailNow()
 		}
 	})
-}
 
-func TestCreateTransaction(t *testing.T) {
-	t.Run("error-begin-trx", func(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectBegin().WillReturnError(fmt.Errorf("Transaction Error"))
+		rows := sqlmock.NewRows([]string{"id", "product_id", "name", "qty", "currency", "price", "tax_class", "reserved"}).AddRow(1, 1, "name", 1, "IDR", 1000, "standard", 0)
+
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnRows(rows)
+		mock.ExpectQuery("SELECT (.+) FROM product_variants WHERE product_id = (.+) ORDER BY id").WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "sku", "options", "price", "currency", "qty", "reserved"}).
+				AddRow(7, 1, "MBP-SLV", []byte(`{"color":"silver"}`), 1100, "IDR", 4, 1))
+		mock.ExpectQuery(`SELECT product_id, category_id FROM product_categories WHERE product_id IN \(\?\)`).WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "category_id"}).AddRow(1, 2))
+		mock.ExpectQuery(`WITH RECURSIVE ancestors (.+) WHERE id IN \(\?\)`).WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name"}).AddRow(2, 1, "laptops").AddRow(1, nil, "computers"))
+
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
+
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
-		_, err = mySQL.CreateTransaction(context.Background(), &TransactionRecord{})
-		if err == nil {
-			t.Error("error should be occurs")
+
+		product, err := mySQL.GetProductByID(context.Background(), 1)
+		if err != nil {
+			t.Error("error shouldnt be occurs")
 			t.FailNow()
 		}
+		variants := []*ProductVariantRecord{{ID: 7, ProductID: 1, SKU: "MBP-SLV", Options: map[string]string{"color": "silver"}, Price: NewMoney(1100, CurrencyIDR), Qty: 4, Available: 3}}
+		// the qty of 1000 of the product itself plus the 3 of the variant not reserved
+		if product.Available != 1003 || !reflect.DeepEqual(product.Variants, variants) {
+			t.Errorf("unexpected variants %d %v", product.Available, product.Variants)
+		}
+		expected := []CategoryPath{{{ID: 1, Name: "computers"}, {ID: 2, ParentID: 1, Name: "laptops"}}}
+		if !reflect.DeepEqual(product.CategoryIDs, []int{2}) || !reflect.DeepEqual(product.Categories, expected) {
+			t.Errorf("unexpected categories %v %v", product.CategoryIDs, product.Categories)
+		}
 	})
+}
 
-	t.Run("error-create-transaction-record", func(t *testing.T) {
-		db, mock, err := sqlmock.New()
-		mock.ExpectBegin()
-		mock.ExpectExec("INSERT INTO transactions").WillReturnError(fmt.Errorf("Create Transaction Error"))
+func TestGetProductByBrandID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
 
+	t.Run("error-exec-query-row-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(fmt.Errorf("Error DB"))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -435,18 +532,17 @@ func TestCreateTransaction(t *testing.T) {
 		mySQL := MySQLDB{
 			instance: db,
 		}
-		_, err = mySQL.CreateTransaction(context.Background(), &TransactionRecord{})
+
+		_, _, err = mySQL.GetProductByBrandID(context.Background(), 1, nil)
 		if err == nil {
 			t.Error("error should be occurs")
 			t.FailNow()
 		}
 	})
 
-	t.Run("error-last-inserted-id", func(t *testing.T) {
+	t.Run("error-not-found", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectBegin()
-		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error LastInsertedID")))
-		mock.ExpectRollback()
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(sql.ErrNoRows)
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -456,7 +552,7 @@ func TestCreateTransaction(t *testing.T) {
 			instance: db,
 		}
 
-		_, err = mySQL.CreateTransaction(context.Background(), &TransactionRecord{})
+		_, _, err = mySQL.GetProductByBrandID(context.Background(), 1, nil)
 		if err == nil {
 			t.Error("error should be occurs")
 			t.FailNow()
@@ -465,19 +561,12 @@ func TestCreateTransaction(t *testing.T) {
 
 	t.Run("success", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectBegin()
-		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
-
-		rows := sqlmock.NewRows([]string{"id", "product_id", "name", "qty", "price"}).AddRow(1, 1, "name", 1, 1000)
-		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnRows(rows)
-
-		mock.ExpectExec("UPDATE products").WillReturnResult(sqlmock.NewResult(12, 1))
-
-		mock.ExpectExec("INSERT INTO transaction_detail").WillReturnResult(sqlmock.NewResult(12, 1))
+		rows := sqlmock.NewRows([]string{"id", "product_id", "name", "qty", "currency", "price", "tax_class"}).
+			AddRow(1, 1, "name 1", 1, "IDR", 1000, "standard").
+			AddRow(2, 1, "name 2", 2, "IDR", 1100, "standard").
+			AddRow(3, 1, "name 3", 3, "IDR", 1200, "standard")
 
-		mock.ExpectExec("UPDATE transactions").WillReturnResult(sqlmock.NewResult(12, 1))
-
-		mock.ExpectCommit()
+		mock.ExpectQuery(`SELECT (.+) FROM products WHERE brand_id = \?`).WithArgs(1).WillReturnRows(rows)
 
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -489,35 +578,21 @@ func TestCreateTransaction(t *testing.T) {
 			instance: db,
 		}
 
-		var det []*TransactionDetailRecord
-
-		det = append(det, &TransactionDetailRecord{
-			ProductID: 1,
-			Qty:       1,
-		})
-
-		rec := &TransactionRecord{
-			UserID:            1,
-			Date:              time.Now(),
-			TransactionDetail: det,
-		}
-
-		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		_, _, err = mySQL.GetProductByBrandID(context.Background(), 1, nil)
 		if err != nil {
 			t.Error("error shouldnt be occurs")
 			t.FailNow()
 		}
 	})
-
 }
 
-func TestGetUserByID(t *testing.T) {
+func TestGetProducts(t *testing.T) {
 	logrus.SetLevel(logrus.TraceLevel)
 	logrus.SetOutput(ioutil.Discard)
 
-	t.Run("error-exec-query-row-context", func(t *testing.T) {
+	t.Run("error-query", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnError(fmt.Errorf("Error DB"))
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnError(fmt.Errorf("Error DB"))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -527,16 +602,18 @@ func TestGetUserByID(t *testing.T) {
 			instance: db,
 		}
 
-		_, err = mySQL.GetUserByID(context.Background(), 1)
+		_, _, err = mySQL.GetProducts(context.Background(), &ProductFilter{Page: &pagination.Page{Limit: 10}})
 		if err == nil {
 			t.Error("error should be occurs")
 			t.FailNow()
 		}
 	})
 
-	t.Run("error-not-found", func(t *testing.T) {
+	t.Run("success-default", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnError(sql.ErrNoRows)
+		rows := sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).
+			AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard")
+		mock.ExpectQuery(`SELECT (.+) FROM products ORDER BY id ASC LIMIT \? OFFSET \?`).WithArgs(10, 0).WillReturnRows(rows)
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
@@ -546,33 +623,4360 @@ func TestGetUserByID(t *testing.T) {
 			instance: db,
 		}
 
-		_, err = mySQL.GetUserByID(context.Background(), 1)
-		if err == nil {
-			t.Error("error should be occurs")
+		products, total, err := mySQL.GetProducts(context.Background(), &ProductFilter{Page: &pagination.Page{Limit: 10}})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
 			t.FailNow()
 		}
+		// the first page is not full, it holds every product
+		if total != 1 {
+			t.Errorf("expecting 1 product in total but got %d", total)
+		}
+		if len(products) != 1 || !reflect.DeepEqual(*products[0], ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Price: NewMoney(1200, CurrencyIDR), Qty: 3, TaxClass: TaxClassStandard}) {
+			t.Errorf("unexpected products %v", products)
+		}
 	})
 
-	t.Run("success", func(t *testing.T) {
+	t.Run("success-filtered", func(t *testing.T) {
 		db, mock, err := sqlmock.New()
-		rows := sqlmock.NewRows([]string{"id", "email", "name", "address"}).AddRow(1, "donny@arieffian.com", "donny", "surabaya")
+		rows := sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"})
+		minPrice, maxPrice := int64(100), int64(2000)
+		mock.ExpectQuery(`SELECT (.+) FROM products WHERE name LIKE \? AND brand_id = \? AND price >= \? AND price <= \? AND \(qty > 0 OR EXISTS \(SELECT 1 FROM product_variants v WHERE v.product_id = products.id AND v.qty > 0\)\) ORDER BY price DESC, id DESC LIMIT \? OFFSET \?`).
+			WithArgs(`%50\%%`, 2, minPrice, maxPrice, 5, 10).
+			WillReturnRows(rows)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM products WHERE name LIKE \? AND brand_id = \? AND price >= \? AND price <= \? AND \(qty > 0 OR (.+)\)$`).
+			WithArgs(`%50\%%`, 2, minPrice, maxPrice).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(10))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
 
-		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnRows(rows)
+		filter := &ProductFilter{
+			Name:     "50%",
+			BrandID:  2,
+			MinPrice: &minPrice,
+			MaxPrice: &maxPrice,
+			InStock:  true,
+			Sort:     ProductSortPrice,
+			Desc:     true,
+			Page:     &pagination.Page{Limit: 5, Offset: 10},
+		}
+		_, total, err := mySQL.GetProducts(context.Background(), filter)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if total != 10 {
+			t.Errorf("expecting 10 products in total but got %d", total)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
 
+	t.Run("success-before-cursor", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		// the rows before the cursor are read backwards
+		rows := sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).
+			AddRow(3, 1, "macbook air", 900, "IDR", 3, "standard").
+			AddRow(2, 1, "legion", 1100, "IDR", 3, "standard")
+		mock.ExpectQuery(`SELECT (.+) FROM products WHERE \(price > \? OR \(price = \? AND id > \?\)\) ORDER BY price ASC, id ASC LIMIT \?$`).
+			WithArgs(int64(800), int64(800), 4, 2).
+			WillReturnRows(rows)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM products$`).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(4))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
-
 		defer db.Close()
 		// inject sqlmock.DB into MySQLDB
 		mySQL := MySQLDB{
 			instance: db,
 		}
 
-		_, err = mySQL.GetUserByID(context.Background(), 1)
+		filter := &ProductFilter{
+			Sort: ProductSortPrice,
+			Desc: true,
+			Page: &pagination.Page{Limit: 2, Before: &pagination.Cursor{Value: "800", ID: 4}},
+		}
+		products, total, err := mySQL.GetProducts(context.Background(), filter)
 		if err != nil {
-			t.Error("error shouldnt be occurs")
+			t.Errorf("error shouldnt be occurs, got %s", err)
 			t.FailNow()
 		}
+		if len(products) != 2 || products[0].ID != 2 || products[1].ID != 3 || total != 4 {
+			t.Errorf("unexpected products %v of %d", products, total)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-invalid-cursor", func(t *testing.T) {
+		mySQL := MySQLDB{}
+
+		filter := &ProductFilter{Sort: ProductSortPrice, Page: &pagination.Page{Limit: 2, After: &pagination.Cursor{Value: "cheap", ID: 4}}}
+		_, _, err := mySQL.GetProducts(context.Background(), filter)
+		if !errors.Is(err, ErrBadRequest) {
+			t.Errorf("expecting a bad request but got %v", err)
+		}
+	})
+}
+
+func TestUpdateProduct(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-update-product", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectExec("UPDATE products").WillReturnError(fmt.Errorf("Error DB"))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: NewMoney(1300, CurrencyIDR)}, "admin")
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-actor-required", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(2))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		// the qty was changed since the caller read it as 3
+		_, err = mySQL.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: NewMoney(1300, CurrencyIDR)}, "")
+		if err != ErrActorRequired {
+			t.Errorf("expecting ErrActorRequired but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-category-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectExec("UPDATE products").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM product_categories WHERE product_id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO product_categories").WithArgs(1, 100).WillReturnError(&mysql.MySQLError{Number: mySQLErrNoReferencedRow})
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: NewMoney(1300, CurrencyIDR), CategoryIDs: []int{100}}, "admin")
+		if err != ErrCategoryNotFound {
+			t.Errorf("expecting ErrCategoryNotFound but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(2))
+		mock.ExpectExec("UPDATE products").WithArgs(1, "macbook pro", 3, 1300, "IDR", "reduced", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("DELETE FROM product_categories WHERE product_id = ?").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec(`INSERT INTO product_categories\(product_id, category_id\) VALUES\(\?,\?\),\(\?,\?\)`).WithArgs(1, 1, 1, 2).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(1, 2))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 1, 1, "adjustment", nil, "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateProduct(context.Background(), &ProductRecord{ID: 1, BrandID: 1, Name: "macbook pro", Qty: 3, Price: NewMoney(1300, CurrencyIDR), TaxClass: TaxClassReduced, CategoryIDs: []int{2, 1, 2}}, "admin")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestDeleteProduct(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	tests := []struct {
+		name         string
+		hasOrders    bool
+		hasMovements bool
+		want         error
+	}{
+		{"error-product-has-orders", true, true, ErrProductHasOrders},
+		{"error-product-has-stock-movements", false, true, ErrProductHasStockMovements},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT EXISTS (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).
+				WillReturnRows(sqlmock.NewRows([]string{"orders", "movements"}).AddRow(tt.hasOrders, tt.hasMovements))
+			mock.ExpectRollback()
+
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			defer db.Close()
+			// inject sqlmock.DB into MySQLDB
+			mySQL := MySQLDB{
+				instance: db,
+			}
+
+			_, err = mySQL.DeleteProduct(context.Background(), 1)
+			if err != tt.want {
+				t.Errorf("expecting %v but got %v", tt.want, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteProduct(context.Background(), 1)
+		if err != ErrProductNotFound {
+			t.Errorf("expecting ErrProductNotFound but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT EXISTS (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"orders", "movements"}).AddRow(false, false))
+		mock.ExpectExec("DELETE FROM products").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteProduct(context.Background(), 1)
+		if err != nil {
+			t.Error("error shouldnt be occurs")
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestAdjustStock(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 1, Delta: 5, Reason: StockReasonRestock, Actor: "admin"})
+		if err != ErrProductNotFound {
+			t.Errorf("expecting ErrProductNotFound but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-qty-not-enough", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 2, "standard"))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 1, Delta: -3, Reason: StockReasonAdjustment, Actor: "admin"})
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 2, "standard"))
+		mock.ExpectExec("UPDATE products SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(5, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(1, 2))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(5, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 1, 5, "restock", nil, "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		product, err := mySQL.AdjustStock(context.Background(), &StockMovementRecord{ProductID: 1, Delta: 5, Reason: StockReasonRestock, Actor: "admin", CreatedAt: time.Now()})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if product.Qty != 7 {
+			t.Errorf("expecting qty 7 but got %d", product.Qty)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetStockMovements(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-query", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM stock_movements").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.GetStockMovements(context.Background(), 1, nil)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "product_id", "variant_id", "warehouse_id", "delta", "reason", "transaction_id", "actor", "created_at"}).
+			AddRow(1, 1, nil, 1, 3, "initial", nil, "system", time.Now()).
+			AddRow(2, 1, nil, 1, -1, "sale", 12, "user:1", time.Now())
+		mock.ExpectQuery("SELECT (.+) FROM stock_movements WHERE product_id = (.+) ORDER BY id").WithArgs(1).WillReturnRows(rows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		movements, _, err := mySQL.GetStockMovements(context.Background(), 1, nil)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(movements) != 2 || movements[0].TransactionID != 0 || movements[1].TransactionID != 12 || movements[1].Delta != -1 {
+			t.Errorf("unexpected movements %+v %+v", movements[0], movements[1])
+		}
+	})
+}
+
+func TestGetTransactionByTransactionID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-query-row-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetTransactionByTransactionID(context.Background(), 1)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnError(sql.ErrNoRows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetTransactionByTransactionID(context.Background(), 1)
+		if err != ErrTransactionNotFound {
+			t.Errorf("expecting ErrTransactionNotFound but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).
+			AddRow(1, 1, time.Now(), "IDR", 1000, 0, 0, false, 0, 1000, "pending", nil, nil, nil, nil, nil, nil)
+
+		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnRows(rows)
+
+		rows = sqlmock.NewRows([]string{"id", "transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax", "allocations", "coupon_id", "code", "amount"}).
+			AddRow(1, 1, 1, nil, 1, 1000, 0, []byte(`[{"WarehouseID": 1, "Qty": 1}]`), nil, nil, nil).
+			AddRow(2, 1, 2, nil, 1, 1000, 0, nil, nil, nil, nil).
+			AddRow(3, 1, 2, nil, 1, 1000, 0, nil, nil, nil, nil)
+
+		mock.ExpectQuery(`SELECT (.+) FROM transaction_detail td (.+) WHERE td.transaction_id = \?`).WithArgs(1).WillReturnRows(rows)
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transaction, err := mySQL.GetTransactionByTransactionID(context.Background(), 1)
+		if err != nil {
+			t.Error("error shouldnt be occurs")
+			t.FailNow()
+		}
+		if len(transaction.TransactionDetail[0].Allocations) != 1 || transaction.TransactionDetail[0].Allocations[0].WarehouseID != 1 {
+			t.Errorf("expecting the detail to be allocated to warehouse 1 but got %v", transaction.TransactionDetail[0].Allocations)
+		}
+	})
+}
+
+// stockRowColumns the columns allocationRequest reads the stock of the warehouses and the qty reserved there with
+var stockRowColumns = []string{"warehouse_id", "product_id", "variant_id", "qty"}
+
+// expectAllocationRequest expects the reads of allocationRequest: the stock rows of the warehouses, the qty the other
+// pending orders reserved in them and the main warehouse
+func expectAllocationRequest(mock sqlmock.Sqlmock, stock *sqlmock.Rows, reserved *sqlmock.Rows) {
+	mock.ExpectQuery("SELECT (.+) FROM warehouse_stock WHERE product_id IN (.+) FOR UPDATE").WillReturnRows(stock)
+	mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE product_id IN (.+) GROUP BY (.+) FOR UPDATE").WillReturnRows(reserved)
+	mock.ExpectQuery("SELECT (.+) FROM warehouses ORDER BY id").WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "city", "country"}).AddRow(1, "MAIN", "main warehouse", "jakarta", "ID"))
+}
+
+func TestCreateTransaction(t *testing.T) {
+	t.Run("error-begin-trx", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin().WillReturnError(fmt.Errorf("Transaction Error"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		_, err = mySQL.CreateTransaction(context.Background(), &TransactionRecord{})
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-create-transaction-record", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnError(fmt.Errorf("Create Transaction Error"))
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		_, err = mySQL.CreateTransaction(context.Background(), &TransactionRecord{})
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-last-inserted-id", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("error LastInsertedID")))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), &TransactionRecord{})
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+
+		rows := sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "name", 1000, "IDR", 1, "standard")
+		mock.ExpectQuery("SELECT (.+) FROM products").WillReturnRows(rows)
+
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 1), sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("INSERT INTO stock_reservations").WithArgs(1, nil, 1, 12, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectExec("INSERT INTO transaction_detail").WillReturnResult(sqlmock.NewResult(12, 1))
+
+		mock.ExpectExec("UPDATE transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		var det []*TransactionDetailRecord
+
+		det = append(det, &TransactionDetailRecord{
+			ProductID: 1,
+			Qty:       1,
+		})
+
+		rec := &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: det,
+		}
+
+		result, err := mySQL.CreateTransaction(context.Background(), rec)
+		if err != nil {
+			t.Error("error shouldnt be occurs")
+			t.FailNow()
+		}
+		if result.ID != 12 || result.GrandTotal.Amount != 1000 {
+			t.Errorf("expecting transaction id 12 with grand total 1000 but got %d and %d", result.ID, result.GrandTotal.Amount)
+		}
+		if len(result.TransactionDetail) != 1 || result.TransactionDetail[0].SubTotal.Amount != 1000 {
+			t.Errorf("expecting one detail with sub total 1000 but got %+v", result.TransactionDetail)
+		}
+	})
+
+}
+
+func TestCreateTransactionLocking(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("lock-products-in-id-order", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+
+		// product 3 is ordered twice, it is locked once after product 1 and reserved for the total qty
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE product_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(2))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(3, 3, "rog", 1100, "IDR", 2, "standard"))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE product_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(3, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+
+		// the stock of the warehouses is locked last, the qty is reserved in them in the same order
+		expectAllocationRequest(mock,
+			sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3).AddRow(1, 3, nil, 2),
+			sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 2))
+		mock.ExpectExec("INSERT INTO stock_reservations").WithArgs(1, nil, 1, 12, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO stock_reservations").WithArgs(3, nil, 1, 12, 2, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 3, nil, 1100, 1, 1100, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 1, 1200, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 3, nil, 1100, 1, 1100, 0).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 3400, 0, 0, false, 0, 3400, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(12, nil, "pending", "user:1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{
+			UserID: 1,
+			Date:   time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 3, Qty: 1},
+				{ProductID: 1, Qty: 1},
+				{ProductID: 3, Qty: 1},
+			},
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-qty-not-enough", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 1, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}},
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-reserved-by-other-orders", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(3))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-insufficient-stock-rolls-back-earlier-locks", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+
+		// the products are locked in ascending id order, then the variants in ascending id order,
+		// whatever the order of the details, so two orders of the same rows always queue on the first one
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(2, 1, "macbook air", 1000, "IDR", 5, "standard"))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE product_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(2, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(5).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(5, 3, "rog", 1100, "IDR", 5, "standard"))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE product_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(5, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectQuery("SELECT (.+) FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(4).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "sku", "options", "price", "currency", "qty"}).AddRow(4, 2, "MBA-GLD", []byte(`{"color":"gold"}`), 1100, "IDR", 2))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE variant_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(4, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+
+		// the last unit of variant 9 is reserved by another order, the order fails before reserving anything
+		// and the rollback releases the row locks taken above
+		mock.ExpectQuery("SELECT (.+) FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(9).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "sku", "options", "price", "currency", "qty"}).AddRow(9, 2, "MBA-SLV", []byte(`{"color":"silver"}`), 1100, "IDR", 1))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE variant_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(9, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(1))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{
+			UserID: 1,
+			Date:   time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 2, VariantID: 9, Qty: 1},
+				{ProductID: 5, Qty: 1},
+				{ProductID: 2, VariantID: 4, Qty: 1},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateTransactionWithCoupon(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	couponRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "code", "type", "value", "currency", "buy_qty", "get_qty", "brand_id", "min_spend", "usage_limit", "usage_limit_per_user", "starts_at", "ends_at"}).
+			AddRow(5, "SAVE10", "percentage", 10, nil, 0, 0, 1, 0, 10, 1, nil, nil)
+	}
+	newRec := func() *TransactionRecord {
+		return &TransactionRecord{
+			UserID:     1,
+			Date:       time.Now(),
+			CouponCode: "save10",
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 1, Qty: 2},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+	}
+
+	t.Run("error-coupon-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM coupons WHERE code = (.+) FOR UPDATE").WithArgs("SAVE10").WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), newRec())
+		if err != ErrCouponNotFound {
+			t.Errorf("expecting ErrCouponNotFound but got %v", err)
+		}
+	})
+
+	t.Run("error-usage-per-user-exceeded", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM coupons").WillReturnRows(couponRows())
+		mock.ExpectQuery("SELECT COUNT(.+) FROM coupon_redemptions").WithArgs(1, 5).
+			WillReturnRows(sqlmock.NewRows([]string{"total", "user"}).AddRow(3, 1))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateTransaction(context.Background(), newRec())
+		if err != ErrCouponUsageExceeded {
+			t.Errorf("expecting ErrCouponUsageExceeded but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM coupons").WillReturnRows(couponRows())
+		mock.ExpectQuery("SELECT COUNT(.+) FROM coupon_redemptions").
+			WillReturnRows(sqlmock.NewRows([]string{"total", "user"}).AddRow(3, 0))
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectQuery("SELECT (.+) FROM products").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(2, 2, "legion", 1000, "IDR", 2, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3).AddRow(1, 2, nil, 2), sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("INSERT INTO stock_reservations").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO stock_reservations").WillReturnResult(sqlmock.NewResult(1, 1))
+
+		// only the apple product is discounted by the brand-scoped coupon
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 2, 2400, 0).WillReturnResult(sqlmock.NewResult(30, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail_discounts").WithArgs(30, 5, 240).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 2, nil, 1000, 1, 1000, 0).WillReturnResult(sqlmock.NewResult(31, 1))
+		mock.ExpectExec("INSERT INTO coupon_redemptions").WithArgs(5, 1, 12, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 3400, 240, 0, false, 0, 3160, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transaction, err := mySQL.CreateTransaction(context.Background(), newRec())
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.GrandTotal.Amount != 3160 || transaction.Discount.Amount != 240 || transaction.CouponCode != "SAVE10" {
+			t.Errorf("expecting grand total 3160 with discount 240 of SAVE10, got %d %d %s", transaction.GrandTotal.Amount, transaction.Discount.Amount, transaction.CouponCode)
+		}
+		if transaction.TransactionDetail[0].Discount.Amount != 240 || transaction.TransactionDetail[1].Discount.Amount != 0 {
+			t.Errorf("expecting discount 240 and 0 per detail, got %d and %d", transaction.TransactionDetail[0].Discount.Amount, transaction.TransactionDetail[1].Discount.Amount)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetTransactionDiscounts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	mock.ExpectQuery("SELECT (.+) FROM transactions").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).AddRow(12, 1, time.Now(), "IDR", 3400, 240, 0, false, 0, 3160, "pending", nil, nil, nil, nil, nil, nil))
+	mock.ExpectQuery("SELECT (.+) FROM transaction_detail td LEFT JOIN transaction_detail_discounts").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax", "allocations", "coupon_id", "code", "amount"}).
+			AddRow(30, 12, 1, nil, 2, 2400, 0, nil, 5, "SAVE10", 240).
+			AddRow(31, 12, 2, nil, 1, 1000, 0, nil, nil, nil, nil))
+
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	// inject sqlmock.DB into MySQLDB
+	mySQL := MySQLDB{
+		instance: db,
+	}
+
+	transaction, err := mySQL.GetTransactionByTransactionID(context.Background(), 12)
+	if err != nil {
+		t.Errorf("error shouldnt be occurs, got %s", err)
+		t.FailNow()
+	}
+	if len(transaction.TransactionDetail) != 2 || transaction.Discount.Amount != 240 || transaction.CouponCode != "SAVE10" {
+		t.Errorf("expecting 2 details with discount 240 of SAVE10, got %d %d %s", len(transaction.TransactionDetail), transaction.Discount.Amount, transaction.CouponCode)
+		t.FailNow()
+	}
+	discounts := transaction.TransactionDetail[0].Discounts
+	if len(discounts) != 1 || discounts[0].CouponID != 5 || discounts[0].Amount.Amount != 240 {
+		t.Errorf("expecting a discount line of 240 from coupon 5, got %v", discounts)
+	}
+	if transaction.TransactionDetail[1].Discounts != nil {
+		t.Errorf("expecting no discount line on the second detail, got %v", transaction.TransactionDetail[1].Discounts)
+	}
+}
+
+func TestCreateTransactionWithTax(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	rates := map[string]int{TaxClassStandard: 1100, TaxClassReduced: 500}
+	newRec := func() *TransactionRecord {
+		return &TransactionRecord{
+			UserID: 1,
+			Date:   time.Now(),
+			TransactionDetail: []*TransactionDetailRecord{
+				{ProductID: 1, Qty: 1},
+				{ProductID: 2, Qty: 1},
+			},
+		}
+	}
+	expectProducts := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectQuery("SELECT (.+) FROM products").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(2, 2, "legion", 1000, "IDR", 2, "reduced"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3).AddRow(1, 2, nil, 2), sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("INSERT INTO stock_reservations").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO stock_reservations").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	t.Run("success-exclusive", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectProducts(mock)
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 1, 1200, 132).WillReturnResult(sqlmock.NewResult(30, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 2, nil, 1000, 1, 1000, 50).WillReturnResult(sqlmock.NewResult(31, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 2200, 0, 182, false, 0, 2382, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		mySQL.SetTaxCalculator(&RateTaxCalculator{Rates: rates})
+
+		transaction, err := mySQL.CreateTransaction(context.Background(), newRec())
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.Subtotal.Amount != 2200 || transaction.Tax.Amount != 182 || transaction.GrandTotal.Amount != 2382 || transaction.TaxInclusive {
+			t.Errorf("expecting subtotal 2200, exclusive tax 182 and grand total 2382, got %d %d %d %v", transaction.Subtotal.Amount, transaction.Tax.Amount, transaction.GrandTotal.Amount, transaction.TaxInclusive)
+		}
+		if transaction.TransactionDetail[0].Tax.Amount != 132 || transaction.TransactionDetail[1].Tax.Amount != 50 {
+			t.Errorf("expecting tax 132 and 50 per detail, got %d and %d", transaction.TransactionDetail[0].Tax.Amount, transaction.TransactionDetail[1].Tax.Amount)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-inclusive", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectProducts(mock)
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 1, 1200, 119).WillReturnResult(sqlmock.NewResult(30, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 2, nil, 1000, 1, 1000, 48).WillReturnResult(sqlmock.NewResult(31, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 2200, 0, 167, true, 0, 2200, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		mySQL.SetTaxCalculator(&RateTaxCalculator{Rates: rates, PricesIncludeTax: true})
+
+		transaction, err := mySQL.CreateTransaction(context.Background(), newRec())
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.Tax.Amount != 167 || transaction.GrandTotal.Amount != 2200 || !transaction.TaxInclusive {
+			t.Errorf("expecting inclusive tax 167 and grand total 2200, got %d %d %v", transaction.Tax.Amount, transaction.GrandTotal.Amount, transaction.TaxInclusive)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateTransactionWithShipping(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	address := &PostalAddress{Recipient: "donny", Phone: "0812", Street: "jl. sudirman 1", City: "jakarta", PostalCode: "10220", Country: "ID"}
+	newRec := func() *TransactionRecord {
+		return &TransactionRecord{
+			UserID:            1,
+			Date:              time.Now(),
+			ShippingAddress:   address,
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 2}},
+		}
+	}
+	expectProduct := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("INSERT INTO transactions").WithArgs(1, sqlmock.AnyArg(), 0, "pending", "donny", "0812", "jl. sudirman 1", "jakarta", "10220", "ID").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3), sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("INSERT INTO stock_reservations").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectProduct(mock)
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 2, 2400, 0).WillReturnResult(sqlmock.NewResult(30, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 2400, 0, 0, false, 150, 2550, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		mySQL.SetShippingRateProvider(&FlatShippingRateProvider{Flat: 100, PerItem: 25})
+
+		transaction, err := mySQL.CreateTransaction(context.Background(), newRec())
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.ShippingCost.Amount != 150 || transaction.GrandTotal.Amount != 2550 {
+			t.Errorf("expecting shipping cost 150 and grand total 2550, got %d and %d", transaction.ShippingCost.Amount, transaction.GrandTotal.Amount)
+		}
+		if transaction.ShippingAddress == nil || *transaction.ShippingAddress != *address {
+			t.Errorf("expecting the shipping address %+v, got %+v", address, transaction.ShippingAddress)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("free-shipping", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectProduct(mock)
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 2, 2400, 0).WillReturnResult(sqlmock.NewResult(30, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 2400, 0, 0, false, 0, 2400, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		mySQL.SetShippingRateProvider(&FlatShippingRateProvider{Flat: 100, PerItem: 25, FreeFrom: 2000})
+
+		transaction, err := mySQL.CreateTransaction(context.Background(), newRec())
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.ShippingCost.Amount != 0 || transaction.GrandTotal.Amount != 2400 {
+			t.Errorf("expecting free shipping and grand total 2400, got %d and %d", transaction.ShippingCost.Amount, transaction.GrandTotal.Amount)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateCoupon(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-invalid-coupon", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateCoupon(context.Background(), &CouponRecord{Code: "half", Type: CouponTypePercentage, Value: 150})
+		if !errors.Is(err, ErrValidation) {
+			t.Errorf("expecting a validation error but got %v", err)
+		}
+	})
+
+	t.Run("error-duplicate-code", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO coupons").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateCoupon(context.Background(), &CouponRecord{Code: "half", Type: CouponTypePercentage, Value: 50})
+		if err != ErrDuplicateCouponCode {
+			t.Errorf("expecting ErrDuplicateCouponCode but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO coupons").
+			WithArgs("HALF", "percentage", 50, nil, 0, 0, nil, 0, 0, 0, nil, nil).
+			WillReturnResult(sqlmock.NewResult(3, 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		coupon, err := mySQL.CreateCoupon(context.Background(), &CouponRecord{Code: " half ", Type: CouponTypePercentage, Value: 50})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if coupon.ID != 3 || coupon.Code != "HALF" {
+			t.Errorf("expecting coupon 3 HALF, got %d %s", coupon.ID, coupon.Code)
+		}
+	})
+}
+
+func TestGetCoupons(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	columns := []string{"id", "code", "type", "value", "currency", "buy_qty", "get_qty", "brand_id", "min_spend", "usage_limit", "usage_limit_per_user", "starts_at", "ends_at"}
+	endsAt := time.Date(2021, time.December, 31, 0, 0, 0, 0, time.UTC)
+
+	t.Run("error-coupon-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM coupons WHERE id = ?").WithArgs(9).WillReturnError(sql.ErrNoRows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetCouponByID(context.Background(), 9)
+		if err != ErrCouponNotFound {
+			t.Errorf("expecting ErrCouponNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM coupons ORDER BY id").WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(1, "SAVE10", "percentage", 10, nil, 0, 0, nil, 0, 0, 0, nil, nil).
+			AddRow(2, "B2G1", "buy_x_get_y", 0, nil, 2, 1, 3, 0, 100, 1, nil, endsAt))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		coupons, _, err := mySQL.GetCoupons(context.Background(), nil)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(coupons) != 2 || coupons[0].BrandID != 0 || coupons[1].BrandID != 3 || !coupons[1].EndsAt.Equal(endsAt) {
+			t.Errorf("unexpected coupons %v %v", coupons[0], coupons[1])
+		}
+	})
+}
+
+func TestCreateTransactionIdempotent(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+	idem := &IdempotencyRecord{Key: "key-1", RequestHash: "hash", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	respond := func(trans *TransactionRecord) (int, []byte, error) {
+		return 201, []byte(fmt.Sprintf(`{"id":%d}`, trans.ID)), nil
+	}
+	idempotencyColumns := []string{"request_hash", "transaction_id", "response_status", "response_body", "created_at", "expires_at"}
+
+	expectCreateTransaction := func(mock sqlmock.Sqlmock) {
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "name", 1000, "IDR", 1, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 1), sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("INSERT INTO stock_reservations").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE transactions").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	newRec := func() *TransactionRecord {
+		return &TransactionRecord{
+			UserID:            1,
+			Date:              now,
+			TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, Qty: 1}},
+		}
+	}
+
+	t.Run("new-key", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys WHERE idempotency_key = (.+) FOR UPDATE").WithArgs("key-1").WillReturnError(sql.ErrNoRows)
+		expectCreateTransaction(mock)
+		mock.ExpectExec("INSERT INTO idempotency_keys").WithArgs("key-1", "hash", 12, 201, `{"id":12}`, now, now.Add(time.Hour)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		stored, replayed, err := mySQL.CreateTransactionIdempotent(context.Background(), newRec(), idem, respond)
+		if err != nil {
+			t.Errorf("error should not be occurs, got %s", err)
+			t.FailNow()
+		}
+		if replayed || stored.TransactionID != 12 {
+			t.Errorf("expecting a new transaction 12, got %d replayed %t", stored.TransactionID, replayed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WithArgs("key-1").
+			WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow("hash", 7, 201, `{"id":7}`, now.Add(-time.Minute), now.Add(time.Hour)))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		stored, replayed, err := mySQL.CreateTransactionIdempotent(context.Background(), newRec(), idem, respond)
+		if err != nil {
+			t.Errorf("error should not be occurs, got %s", err)
+			t.FailNow()
+		}
+		if !replayed || stored.TransactionID != 7 || string(stored.ResponseBody) != `{"id":7}` {
+			t.Errorf("expecting the replay of transaction 7, got %d %s replayed %t", stored.TransactionID, stored.ResponseBody, replayed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-key-reused", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WithArgs("key-1").
+			WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow("other", 7, 201, `{"id":7}`, now.Add(-time.Minute), now.Add(time.Hour)))
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.CreateTransactionIdempotent(context.Background(), newRec(), idem, respond)
+		if err != ErrIdempotencyKeyReused {
+			t.Errorf("expecting ErrIdempotencyKeyReused, got %v", err)
+		}
+	})
+
+	t.Run("expired-key", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WithArgs("key-1").
+			WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow("other", 7, 201, `{"id":7}`, now.Add(-25*time.Hour), now.Add(-time.Hour)))
+		mock.ExpectExec("DELETE FROM idempotency_keys").WithArgs("key-1").WillReturnResult(sqlmock.NewResult(0, 1))
+		expectCreateTransaction(mock)
+		mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		stored, replayed, err := mySQL.CreateTransactionIdempotent(context.Background(), newRec(), idem, respond)
+		if err != nil {
+			t.Errorf("error should not be occurs, got %s", err)
+			t.FailNow()
+		}
+		if replayed || stored.TransactionID != 12 {
+			t.Errorf("expecting a new transaction 12, got %d replayed %t", stored.TransactionID, replayed)
+		}
+	})
+
+	t.Run("concurrent-first-request-replays", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		// the key is inserted by another request in the meantime, the retry replays its response
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WithArgs("key-1").WillReturnError(sql.ErrNoRows)
+		expectCreateTransaction(mock)
+		mock.ExpectExec("INSERT INTO idempotency_keys").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"})
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM idempotency_keys").WithArgs("key-1").
+			WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow("hash", 13, 201, `{"id":13}`, now, now.Add(time.Hour)))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		stored, replayed, err := mySQL.CreateTransactionIdempotent(context.Background(), newRec(), idem, respond)
+		if err != nil {
+			t.Errorf("error should not be occurs, got %s", err)
+			t.FailNow()
+		}
+		if !replayed || stored.TransactionID != 13 {
+			t.Errorf("expecting the replay of transaction 13, got %d replayed %t", stored.TransactionID, replayed)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdateTransactionStatus(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusCancelled, "donny")
+		if err != ErrTransactionNotFound {
+			t.Errorf("expecting ErrTransactionNotFound but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-invalid-transition", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("shipped"))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusCancelled, "donny")
+		if err != ErrInvalidStatusTransition {
+			t.Errorf("expecting ErrInvalidStatusTransition but got %v", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-cancel-pending-releases-reservation", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE transaction_id = (.+)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("cancelled", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(1, "pending", "cancelled", "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).AddRow(1, 1, time.Now(), "IDR", 3400, 0, 0, false, 0, 3400, "cancelled", nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery("SELECT (.+) FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax", "allocations", "coupon_id", "code", "amount"}).AddRow(1, 1, 1, nil, 2, 2400, 0, nil, nil, nil, nil))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transaction, err := mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusCancelled, "donny")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.Status != TransactionStatusCancelled {
+			t.Errorf("expecting status cancelled but got %s", transaction.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+	t.Run("success-pay-takes-stock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		// the order reserved its qty in warehouse 2 when it was created
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns).AddRow(2, 1, nil, 2))
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE transaction_id = (.+)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT id, product_id, variant_id, qty FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "variant_id", "qty"}).AddRow(10, 1, nil, 2))
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectExec("UPDATE products SET qty = qty - (.+) WHERE id = (.+)").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT (.+) FROM warehouse_stock WHERE product_id IN \\(\\?\\) ORDER BY id FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 1).AddRow(2, 1, nil, 2))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE product_id IN \\(\\?\\) AND expires_at > \\? GROUP BY (.+) FOR UPDATE").WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns))
+		mock.ExpectQuery("SELECT (.+) FROM warehouses ORDER BY id").WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "city", "country"}).AddRow(1, "MAIN", "main warehouse", "jakarta", "ID").AddRow(2, "SUB", "surabaya warehouse", "surabaya", "ID"))
+		// the warehouse the order reserved its qty in ships it, the strategy does not pick again
+		mock.ExpectExec("INSERT INTO transaction_detail_allocations").WithArgs(10, 2, 2).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(2, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(4, 2))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(-2, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 2, -2, "sale", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("paid", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(1, "pending", "paid", "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).AddRow(1, 1, time.Now(), "IDR", 2400, 0, 0, false, 0, 2400, "paid", nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery("SELECT (.+) FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax", "allocations", "coupon_id", "code", "amount"}).AddRow(1, 1, 1, nil, 2, 2400, 0, nil, nil, nil, nil))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transaction, err := mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusPaid, "donny")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.Status != TransactionStatusPaid {
+			t.Errorf("expecting status paid but got %s", transaction.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-pay-reserved-by-other-orders", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		// the reservation of the order expired
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE transaction_id = (.+)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT id, product_id, variant_id, qty FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "variant_id", "qty"}).AddRow(10, 1, nil, 2))
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(2))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusPaid, "donny")
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-cancel-restores-stock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("paid"))
+		mock.ExpectQuery("SELECT id, product_id, variant_id, qty FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "variant_id", "qty"}).AddRow(10, 3, nil, 1).AddRow(11, 1, nil, 2).AddRow(12, 3, nil, 1))
+		// product 1 was split over both warehouses, product 3 was paid before the warehouses and has no allocation
+		mock.ExpectQuery("SELECT (.+) FROM transaction_detail_allocations a JOIN transaction_detail td").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "variant_id", "warehouse_id", "qty"}).AddRow(1, nil, 2, 1).AddRow(1, nil, 1, 1))
+		mock.ExpectExec("UPDATE products SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE products SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(2, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(1, 0))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 1, 1, "cancel", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(2, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(4, 0))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(1, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 2, 1, "cancel", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 3, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}))
+		mock.ExpectExec("INSERT INTO warehouse_stock").WithArgs(1, 3, nil, 2).WillReturnResult(sqlmock.NewResult(5, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(3, nil, 1, 2, "cancel", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("cancelled", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(1, "paid", "cancelled", "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).AddRow(1, 1, time.Now(), "IDR", 3400, 0, 0, false, 0, 3400, "cancelled", nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery("SELECT (.+) FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax", "allocations", "coupon_id", "code", "amount"}).AddRow(1, 1, 1, nil, 2, 2400, 0, nil, nil, nil, nil))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transaction, err := mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusCancelled, "donny")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.Status != TransactionStatusCancelled {
+			t.Errorf("expecting status cancelled but got %s", transaction.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestReleaseExpiredReservations(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM stock_reservations").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.ReleaseExpiredReservations(context.Background(), time.Now())
+		if err == nil {
+			t.Error("error should be occurs")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		now := time.Now()
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE expires_at <= (.+)").WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 3))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		released, err := mySQL.ReleaseExpiredReservations(context.Background(), now)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if released != 3 {
+			t.Errorf("expecting 3 released reservations but got %d", released)
+		}
+	})
+}
+
+func TestCancelExpiredTransactions(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-query-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT id FROM transactions WHERE status = (.+) AND NOT EXISTS").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CancelExpiredTransactions(context.Background(), time.Now())
+		if err == nil {
+			t.Error("error should be occurs")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		now := time.Now()
+		mock.ExpectQuery("SELECT id FROM transactions WHERE status = (.+) AND NOT EXISTS (.+) ORDER BY id").WithArgs(TransactionStatusPending, now).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(4).AddRow(5))
+		noReservations := sqlmock.NewRows([]string{"warehouse_id", "product_id", "variant_id", "qty"})
+
+		// order 3 expired without a payment, it is cancelled by the system
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(TransactionStatusPending))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(3, now).WillReturnRows(noReservations)
+		mock.ExpectQuery("SELECT COUNT(.+) FROM payments WHERE transaction_id = (.+) AND status IN").WithArgs(3, "pending", "authorized", "captured").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE transaction_id = (.+)").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("UPDATE transactions SET status=(.+) WHERE id=(.+)").WithArgs(TransactionStatusCancelled, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(3, TransactionStatusPending, TransactionStatusCancelled, systemActor, sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+
+		// order 4 has a payment in progress, it is left to its payment
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(4).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(TransactionStatusPending))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(4, now).
+			WillReturnRows(sqlmock.NewRows([]string{"warehouse_id", "product_id", "variant_id", "qty"}))
+		mock.ExpectQuery("SELECT COUNT(.+) FROM payments WHERE transaction_id = (.+) AND status IN").WithArgs(4, "pending", "authorized", "captured").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectCommit()
+
+		// order 5 was paid since it was selected
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(5).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(TransactionStatusPaid))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		cancelled, err := mySQL.CancelExpiredTransactions(context.Background(), now)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if cancelled != 1 {
+			t.Errorf("expecting 1 cancelled order but got %d", cancelled)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestDeleteExpiredIdempotencyKeys(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM idempotency_keys").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteExpiredIdempotencyKeys(context.Background(), time.Now())
+		if err == nil {
+			t.Error("error should be occurs")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		now := time.Now()
+		mock.ExpectExec("DELETE FROM idempotency_keys WHERE expires_at <= (.+)").WithArgs(now).WillReturnResult(sqlmock.NewResult(0, 2))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		deleted, err := mySQL.DeleteExpiredIdempotencyKeys(context.Background(), now)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if deleted != 2 {
+			t.Errorf("expecting 2 deleted keys but got %d", deleted)
+		}
+	})
+}
+
+func TestCreatePayment(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+	payment := &PaymentRecord{TransactionID: 2, Gateway: "fake", Amount: NewMoney(2400, "IDR"), Status: PaymentStatusPending, CreatedAt: now}
+
+	tests := []struct {
+		name    string
+		status  string
+		active  int
+		expired bool
+		err     error
+	}{
+		{name: "error-transaction-not-pending", status: TransactionStatusPaid, err: ErrInvalidStatusTransition},
+		{name: "error-payment-in-progress", status: TransactionStatusPending, active: 1, err: ErrPaymentInProgress},
+		{name: "error-reservation-expired", status: TransactionStatusPending, expired: true, err: ErrReservationExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			mock.ExpectBegin()
+			mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.status))
+			if tt.active > 0 || tt.expired {
+				mock.ExpectQuery("SELECT COUNT(.+) FROM payments WHERE transaction_id = (.+) AND status IN").WithArgs(2, "pending", "authorized", "captured").
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.active))
+			}
+			if tt.expired {
+				// the reservation expired, or the sweep already deleted it
+				mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(2, now).
+					WillReturnRows(sqlmock.NewRows([]string{"warehouse_id", "product_id", "variant_id", "qty"}))
+			}
+			mock.ExpectRollback()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+			// inject sqlmock.DB into MySQLDB
+			mySQL := MySQLDB{
+				instance: db,
+			}
+
+			_, err = mySQL.CreatePayment(context.Background(), payment)
+			if err != tt.err {
+				t.Errorf("expecting %v but got %v", tt.err, err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+
+	t.Run("error-transaction-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"status"}))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreatePayment(context.Background(), payment)
+		if err != ErrTransactionNotFound {
+			t.Errorf("expecting ErrTransactionNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		mock.ExpectQuery("SELECT COUNT(.+) FROM payments WHERE transaction_id = (.+) AND status IN").WithArgs(2, "pending", "authorized", "captured").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(2, now).
+			WillReturnRows(sqlmock.NewRows([]string{"warehouse_id", "product_id", "variant_id", "qty"}).AddRow(DefaultWarehouseID, 1, nil, 2))
+		// the gateway did not answer yet, so the payment has no reference
+		mock.ExpectExec("INSERT INTO payments").WithArgs(2, "fake", nil, 2400, "IDR", "pending", now, now).WillReturnResult(sqlmock.NewResult(5, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		created, err := mySQL.CreatePayment(context.Background(), payment)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if created.ID != 5 || !created.UpdatedAt.Equal(now) {
+			t.Errorf("expecting payment 5 updated at its creation but got %+v", created)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdatePaymentAuthorization(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	columns := []string{"id", "transaction_id", "gateway", "reference", "amount", "currency", "status", "created_at", "updated_at"}
+	answer := &GatewayPayment{Reference: "fake_1", Status: PaymentStatusAuthorized}
+
+	t.Run("error-already-answered", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(5).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(5, 2, "fake", "fake_1", 2400, "IDR", "authorized", time.Now(), time.Now()))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdatePaymentAuthorization(context.Background(), 5, answer)
+		if err != ErrInvalidPaymentTransition {
+			t.Errorf("expecting ErrInvalidPaymentTransition but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(5).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(5, 2, "fake", nil, 2400, "IDR", "pending", time.Now(), time.Now()))
+		mock.ExpectExec("UPDATE payments SET reference=(.+), status=(.+), updated_at=(.+) WHERE id=(.+)").WithArgs("fake_1", "authorized", sqlmock.AnyArg(), 5).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		payment, err := mySQL.UpdatePaymentAuthorization(context.Background(), 5, answer)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if payment.Reference != "fake_1" || payment.Status != PaymentStatusAuthorized {
+			t.Errorf("expecting payment 5 authorized as fake_1 but got %+v", payment)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetPayments(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	columns := []string{"id", "transaction_id", "gateway", "reference", "amount", "currency", "status", "created_at", "updated_at"}
+
+	t.Run("error-reference-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE gateway = (.+) AND reference = (.+)").WithArgs("fake", "fake_9").WillReturnRows(sqlmock.NewRows(columns))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetPaymentByReference(context.Background(), "fake", "fake_9")
+		if err != ErrPaymentNotFound {
+			t.Errorf("expecting ErrPaymentNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-by-transaction", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		now := time.Now()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE transaction_id = (.+) ORDER BY id").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(1, 2, "fake", "fake_1", 2400, "IDR", "failed", now, now).
+				AddRow(2, 2, "fake", "fake_2", 2400, "IDR", "captured", now, now))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		payments, _, err := mySQL.GetPaymentsByTransactionID(context.Background(), 2, nil)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(payments) != 2 || payments[1].Status != PaymentStatusCaptured || payments[1].Amount != NewMoney(2400, "IDR") {
+			t.Errorf("expecting the failed and the captured payment but got %+v", payments)
+		}
+	})
+}
+
+func TestUpdatePaymentStatus(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	columns := []string{"id", "transaction_id", "gateway", "reference", "amount", "currency", "status", "created_at", "updated_at"}
+
+	t.Run("error-invalid-payment-transition", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 2, "fake", "fake_1", 2400, "IDR", "failed", time.Now(), time.Now()))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdatePaymentStatus(context.Background(), 1, PaymentStatusCaptured, "gateway:fake")
+		if err != ErrInvalidPaymentTransition {
+			t.Errorf("expecting ErrInvalidPaymentTransition but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-same-status-changes-nothing", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 2, "fake", "fake_1", 2400, "IDR", "captured", time.Now(), time.Now()))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		payment, err := mySQL.UpdatePaymentStatus(context.Background(), 1, PaymentStatusCaptured, "gateway:fake")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if payment.Status != PaymentStatusCaptured {
+			t.Errorf("expecting status captured but got %s", payment.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-capture-pays-order", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 2, "fake", "fake_1", 2400, "IDR", "authorized", time.Now(), time.Now()))
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(2, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 2))
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE transaction_id = (.+)").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT id, product_id, variant_id, qty FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "variant_id", "qty"}).AddRow(10, 1, nil, 2))
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectExec("UPDATE products SET qty = qty - (.+) WHERE id = (.+)").WithArgs(2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3), sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("INSERT INTO transaction_detail_allocations").WithArgs(10, 1, 2).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(1, 3))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(-2, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 1, -2, "sale", 2, "gateway:fake", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("paid", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(2, "pending", "paid", "gateway:fake", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE payments SET status=(.+), updated_at=(.+) WHERE id=(.+)").WithArgs("captured", sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		payment, err := mySQL.UpdatePaymentStatus(context.Background(), 1, PaymentStatusCaptured, "gateway:fake")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if payment.Status != PaymentStatusCaptured {
+			t.Errorf("expecting status captured but got %s", payment.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-capture-cancelled-order", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 2, "fake", "fake_1", 2400, "IDR", "pending", time.Now(), time.Now()))
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("cancelled"))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdatePaymentStatus(context.Background(), 1, PaymentStatusCaptured, "gateway:fake")
+		if err != ErrInvalidStatusTransition {
+			t.Errorf("expecting ErrInvalidStatusTransition but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-refund-cancelled-order-keeps-it-cancelled", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT (.+) FROM payments WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(1, 2, "fake", "fake_1", 2400, "IDR", "captured", time.Now(), time.Now()))
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(2).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("cancelled"))
+		mock.ExpectExec("UPDATE payments SET status").WithArgs("refunded", sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		payment, err := mySQL.UpdatePaymentStatus(context.Background(), 1, PaymentStatusRefunded, "admin")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if payment.Status != PaymentStatusRefunded {
+			t.Errorf("expecting status refunded but got %s", payment.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetTransactionStatusHistory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-query-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM transaction_status_history").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.GetTransactionStatusHistory(context.Background(), 1, nil)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "transaction_id", "from_status", "to_status", "actor", "created_at"}).
+			AddRow(1, 1, nil, "pending", "user:1", time.Now()).
+			AddRow(2, 1, "pending", "cancelled", "donny", time.Now())
+		mock.ExpectQuery("SELECT (.+) FROM transaction_status_history").WillReturnRows(rows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		history, _, err := mySQL.GetTransactionStatusHistory(context.Background(), 1, nil)
+		if err != nil {
+			t.Error("error shouldnt be occurs")
+			t.FailNow()
+		}
+		if len(history) != 2 || history[0].FromStatus != "" || history[1].FromStatus != "pending" {
+			t.Errorf("unexpected history %+v", history)
+		}
+	})
+}
+
+func TestGetUserByID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-query-row-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetUserByID(context.Background(), 1)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnError(sql.ErrNoRows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetUserByID(context.Background(), 1)
+		if err != ErrUserNotFound {
+			t.Errorf("expecting ErrUserNotFound but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "email", "name", "address"}).AddRow(1, "donny@arieffian.com", "donny", "surabaya")
+
+		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnRows(rows)
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetUserByID(context.Background(), 1)
+		if err != nil {
+			t.Error("error shouldnt be occurs")
+			t.FailNow()
+		}
+	})
+}
+
+func TestGetUsers(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-query-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM users").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.GetUsers(context.Background(), nil)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "name", "email", "address"}).
+			AddRow(1, "donny", "donny@arieffian.com", "surabaya").
+			AddRow(2, "jane", "jane@arieffian.com", "jakarta")
+		mock.ExpectQuery("SELECT (.+) FROM users WHERE deleted_at IS NULL").WillReturnRows(rows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		users, _, err := mySQL.GetUsers(context.Background(), nil)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(users) != 2 {
+			t.Errorf("expecting 2 users but got %d", len(users))
+		}
+	})
+}
+
+func TestCreateUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-duplicate-email", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO users").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'donny@arieffian.com' for key 'users_email_UNIQUE'"})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateUser(context.Background(), &UserRecord{Name: "donny", Email: "donny@arieffian.com"})
+		if err != ErrDuplicateEmail {
+			t.Errorf("expecting ErrDuplicateEmail but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO users").WithArgs("donny", "donny@example.com", "surabaya").WillReturnResult(sqlmock.NewResult(3, 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		user, err := mySQL.CreateUser(context.Background(), &UserRecord{Name: "donny", Email: "donny@example.com", Address: "surabaya"})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if user.ID != 3 {
+			t.Errorf("expecting user id 3 but got %d", user.ID)
+		}
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-duplicate-email", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) AND deleted_at IS NULL FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("UPDATE users").WillReturnError(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'jane@arieffian.com' for key 'users_email_UNIQUE'"})
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateUser(context.Background(), &UserRecord{ID: 1, Name: "donny", Email: "jane@arieffian.com"})
+		if err != ErrDuplicateEmail {
+			t.Errorf("expecting ErrDuplicateEmail but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		// a soft deleted user is not found either
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) AND deleted_at IS NULL FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateUser(context.Background(), &UserRecord{ID: 1, Name: "donny", Email: "donny@example.com"})
+		if err != ErrUserNotFound {
+			t.Errorf("expecting ErrUserNotFound but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) AND deleted_at IS NULL FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("UPDATE users SET").WithArgs("donny", "donny@example.com", "surabaya", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateUser(context.Background(), &UserRecord{ID: 1, Name: "donny", Email: "donny@example.com", Address: "surabaya"})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("UPDATE users SET deleted_at").WillReturnResult(sqlmock.NewResult(0, 0))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteUser(context.Background(), 1)
+		if err != ErrUserNotFound {
+			t.Errorf("expecting ErrUserNotFound but got %v", err)
+			t.FailNow()
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("UPDATE users SET deleted_at").WithArgs(sqlmock.AnyArg(), 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteUser(context.Background(), 1)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+	})
+}
+
+func TestGetTransactionsByUserID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-query-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.GetTransactionsByUserID(context.Background(), &TransactionFilter{UserID: 1, Page: &pagination.Page{Limit: 10}})
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("success-empty-page", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transactions, _, err := mySQL.GetTransactionsByUserID(context.Background(), &TransactionFilter{UserID: 1, Page: &pagination.Page{Limit: 10}})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(transactions) != 0 {
+			t.Errorf("expecting no transaction but got %d", len(transactions))
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+		to := time.Date(2021, 2, 1, 0, 0, 0, 0, time.Local)
+		// a cursor carries the date in utc
+		after := time.Date(2021, 1, 20, 0, 0, 0, 0, time.UTC)
+		rows := sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).
+			AddRow(5, 1, time.Date(2021, 1, 15, 0, 0, 0, 0, time.Local), "IDR", 2000, 0, 0, false, 0, 2000, "paid", nil, nil, nil, nil, nil, nil).
+			AddRow(3, 1, time.Date(2021, 1, 10, 0, 0, 0, 0, time.Local), "IDR", 1000, 0, 0, false, 0, 1000, "completed", nil, nil, nil, nil, nil, nil)
+		mock.ExpectQuery(`SELECT (.+) FROM transactions WHERE user_id = \? AND date >= \? AND date < \? AND \(date < \? OR \(date = \? AND id < \?\)\) ORDER BY date DESC, id DESC LIMIT \?`).
+			WithArgs(1, from, to, after, after, 7, 3).
+			WillReturnRows(rows)
+		// a page after a cursor does not tell the number of orders
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM transactions WHERE user_id = \? AND date >= \? AND date < \?$`).
+			WithArgs(1, from, to).
+			WillReturnRows(sqlmock.NewRows([]string{"COUNT(*)"}).AddRow(6))
+		// the detail of the whole page comes from one query
+		detailRows := sqlmock.NewRows([]string{"transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax"}).
+			AddRow(3, 1, nil, 1, 1000, 0).
+			AddRow(5, 1, nil, 1, 1000, 0).
+			AddRow(5, 2, nil, 1, 1000, 0)
+		mock.ExpectQuery(`SELECT (.+) FROM transaction_detail WHERE transaction_id IN \(\?,\?\)`).
+			WithArgs(5, 3).
+			WillReturnRows(detailRows)
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		filter := &TransactionFilter{
+			UserID: 1,
+			From:   from,
+			To:     to,
+			Page:   &pagination.Page{Limit: 3, After: &pagination.Cursor{Value: strconv.FormatInt(after.UnixNano(), 10), ID: 7}},
+		}
+		transactions, total, err := mySQL.GetTransactionsByUserID(context.Background(), filter)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(transactions) != 2 || transactions[0].ID != 5 || transactions[1].ID != 3 || total != 6 {
+			t.Errorf("unexpected transactions %+v of %d", transactions, total)
+			t.FailNow()
+		}
+		if len(transactions[0].TransactionDetail) != 2 || len(transactions[1].TransactionDetail) != 1 {
+			t.Errorf("detail is not grouped by transaction")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetCart(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	columns := []string{"product_id", "qty", "added_at", "id", "brand_id", "name", "price", "currency", "product_qty", "tax_class", "reserved"}
+	addedAt := time.Date(2021, time.September, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("error-db", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM cart_items").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetCart(context.Background(), 1)
+		if err == nil {
+			t.Error("error should be occurs")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM cart_items c JOIN products ON products.id = c.product_id WHERE c.user_id = (.+) ORDER BY c.added_at, c.product_id").WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, 3, addedAt, 2, 2, "legion", 1000, "IDR", 2, "standard", 0).
+				AddRow(1, 1, addedAt, 1, 1, "macbook pro", 1200, "IDR", 3, "standard", 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		cart, err := mySQL.GetCart(context.Background(), 1)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(cart.Items) != 2 || cart.Items[0].SubTotal != NewMoney(3000, CurrencyIDR) || cart.Items[0].InStock || !cart.Items[1].InStock {
+			t.Errorf("unexpected cart items %+v %+v", cart.Items[0], cart.Items[1])
+		}
+		if cart.Subtotal != NewMoney(4200, CurrencyIDR) {
+			t.Errorf("expecting subtotal 4200 IDR, got %s", cart.Subtotal)
+		}
+	})
+}
+
+func TestAddCartItem(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM products WHERE id = ?").WithArgs(9).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.AddCartItem(context.Background(), &CartItemRecord{UserID: 1, ProductID: 9, Qty: 1, AddedAt: now})
+		if err != ErrProductNotFound {
+			t.Errorf("expecting ErrProductNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM products WHERE id = ?").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("INSERT INTO cart_items(.+) ON DUPLICATE KEY UPDATE qty = qty \\+ VALUES\\(qty\\)").WithArgs(1, 1, 2, now).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT (.+) FROM cart_items").WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "qty", "added_at", "id", "brand_id", "name", "price", "currency", "product_qty", "tax_class", "reserved"}).
+				AddRow(1, 2, now, 1, 1, "macbook pro", 1200, "IDR", 3, "standard", 0))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		cart, err := mySQL.AddCartItem(context.Background(), &CartItemRecord{UserID: 1, ProductID: 1, Qty: 2, AddedAt: now})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(cart.Items) != 1 || cart.Subtotal != NewMoney(2400, CurrencyIDR) {
+			t.Errorf("unexpected cart %+v", cart)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdateCartItem(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-cart-item-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT qty FROM cart_items WHERE user_id = (.+) AND product_id = (.+) FOR UPDATE").WithArgs(1, 2).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateCartItem(context.Background(), &CartItemRecord{UserID: 1, ProductID: 2, Qty: 1})
+		if err != ErrCartItemNotFound {
+			t.Errorf("expecting ErrCartItemNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-same-qty", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT qty FROM cart_items WHERE user_id = (.+) AND product_id = (.+) FOR UPDATE").WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(1))
+		// mysql reports no affected row when the qty does not change
+		mock.ExpectExec("UPDATE cart_items SET qty = (.+) WHERE user_id = (.+) AND product_id = (.+)").WithArgs(1, 1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("SELECT (.+) FROM cart_items").WithArgs(sqlmock.AnyArg(), 1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "qty", "added_at", "id", "brand_id", "name", "price", "currency", "product_qty", "tax_class", "reserved"}).
+				AddRow(2, 1, time.Now(), 2, 2, "legion", 1000, "IDR", 2, "standard", 0))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		cart, err := mySQL.UpdateCartItem(context.Background(), &CartItemRecord{UserID: 1, ProductID: 2, Qty: 1})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(cart.Items) != 1 || cart.Items[0].Qty != 1 {
+			t.Errorf("unexpected cart %+v", cart)
+		}
+	})
+}
+
+func TestRemoveCartItem(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-cart-item-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM cart_items WHERE user_id = (.+) AND product_id = (.+)").WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.RemoveCartItem(context.Background(), 1, 2)
+		if err != ErrCartItemNotFound {
+			t.Errorf("expecting ErrCartItemNotFound but got %v", err)
+		}
+	})
+}
+
+func TestCheckoutCart(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-cart-empty", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id, qty FROM cart_items WHERE user_id = (.+) ORDER BY added_at, product_id FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "qty"}))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now()})
+		if err != ErrCartEmpty {
+			t.Errorf("expecting ErrCartEmpty but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-insufficient-stock-keeps-cart", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id, qty FROM cart_items").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "qty"}).AddRow(1, 5))
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now()})
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id, qty FROM cart_items").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "qty"}).AddRow(1, 2))
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations").WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(1))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3), sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 1))
+		mock.ExpectExec("INSERT INTO stock_reservations").WithArgs(1, nil, 1, 12, 2, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, nil, 1200, 2, 2400, 0).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("DELETE FROM cart_items WHERE user_id = (.+)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		transaction, err := mySQL.CheckoutCart(context.Background(), &TransactionRecord{UserID: 1, Date: time.Now()})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.ID != 12 || transaction.GrandTotal != NewMoney(2400, CurrencyIDR) || len(transaction.TransactionDetail) != 1 {
+			t.Errorf("unexpected transaction %+v", transaction)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+	newRec := func(isDefault bool) *AddressRecord {
+		return &AddressRecord{UserID: 1, Label: "home", PostalAddress: PostalAddress{Recipient: "donny", Phone: "0812", Street: "jl. sudirman 1", City: "jakarta", PostalCode: "10220", Country: "id"}, IsDefault: isDefault, CreatedAt: now}
+	}
+
+	t.Run("error-user-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateAddress(context.Background(), newRec(false))
+		if err != ErrUserNotFound {
+			t.Errorf("expecting ErrUserNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-first-address-is-default", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT COUNT(.+) FROM addresses WHERE user_id = (.+)").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec("INSERT INTO addresses").WithArgs(1, "home", "donny", "0812", "jl. sudirman 1", "jakarta", "10220", "ID", true, now).WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		address, err := mySQL.CreateAddress(context.Background(), newRec(false))
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if address.ID != 4 || !address.IsDefault || address.Country != "ID" {
+			t.Errorf("expecting default address 4 in ID but got %+v", address)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-default-takes-default", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectExec("UPDATE addresses SET is_default = 0 WHERE user_id = (.+) AND is_default = 1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO addresses").WithArgs(1, "home", "donny", "0812", "jl. sudirman 1", "jakarta", "10220", "ID", true, now).WillReturnResult(sqlmock.NewResult(5, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		address, err := mySQL.CreateAddress(context.Background(), newRec(true))
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if address.ID != 5 || !address.IsDefault {
+			t.Errorf("expecting default address 5 but got %+v", address)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdateAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	createdAt := time.Date(2021, 1, 10, 0, 0, 0, 0, time.Local)
+	columns := []string{"id", "user_id", "label", "recipient", "phone", "street", "city", "postal_code", "country", "is_default", "created_at"}
+	rec := &AddressRecord{ID: 4, UserID: 1, Label: "office", PostalAddress: PostalAddress{Recipient: "donny", Phone: "0812", Street: "jl. thamrin 2", City: "jakarta", PostalCode: "10230", Country: "ID"}}
+
+	t.Run("error-address-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT (.+) FROM addresses WHERE id = (.+) AND user_id = (.+) FOR UPDATE").WithArgs(4, 1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateAddress(context.Background(), rec)
+		if err != ErrAddressNotFound {
+			t.Errorf("expecting ErrAddressNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-keeps-default", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT (.+) FROM addresses WHERE id = (.+) AND user_id = (.+) FOR UPDATE").WithArgs(4, 1).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(4, 1, "home", "donny", "0812", "jl. sudirman 1", "jakarta", "10220", "ID", true, createdAt))
+		mock.ExpectExec("UPDATE addresses SET label=(.+) WHERE id=(.+)").WithArgs("office", "donny", "0812", "jl. thamrin 2", "jakarta", "10230", "ID", true, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		address, err := mySQL.UpdateAddress(context.Background(), rec)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if !address.IsDefault || !address.CreatedAt.Equal(createdAt) {
+			t.Errorf("expecting the default address created at %s but got %+v", createdAt, address)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestDeleteAddress(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-user-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteAddress(context.Background(), 1, 4)
+		if err != ErrAddressNotFound {
+			t.Errorf("expecting ErrAddressNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-default-moves-to-oldest", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM users WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT is_default FROM addresses WHERE id = (.+) AND user_id = (.+) FOR UPDATE").WithArgs(4, 1).WillReturnRows(sqlmock.NewRows([]string{"is_default"}).AddRow(true))
+		mock.ExpectExec("DELETE FROM addresses WHERE id = (.+)").WithArgs(4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("UPDATE addresses SET is_default = 1 WHERE user_id = (.+) ORDER BY id LIMIT 1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteAddress(context.Background(), 1, 4)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateShipment(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	now := time.Now()
+	rec := &ShipmentRecord{TransactionID: 1, Carrier: "jne", TrackingNumber: "JNE123", CreatedAt: now}
+
+	t.Run("error-invalid-status", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateShipment(context.Background(), rec, "admin")
+		if err != ErrInvalidStatusTransition {
+			t.Errorf("expecting ErrInvalidStatusTransition but got %v", err)
+		}
+	})
+
+	t.Run("error-duplicate-tracking-number", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("shipped"))
+		mock.ExpectExec("INSERT INTO shipments").WillReturnError(&mysql.MySQLError{Number: mySQLErrDupEntry, Message: "Duplicate entry"})
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateShipment(context.Background(), rec, "admin")
+		if err != ErrDuplicateTrackingNumber {
+			t.Errorf("expecting ErrDuplicateTrackingNumber but got %v", err)
+		}
+	})
+
+	t.Run("success-paid-is-shipped", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("paid"))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("shipped", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(1, "paid", "shipped", "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectExec("INSERT INTO shipments").WithArgs(1, "jne", "JNE123", "in_transit", now, now).WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		shipment, err := mySQL.CreateShipment(context.Background(), rec, "admin")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if shipment.ID != 7 || shipment.Status != ShipmentStatusInTransit {
+			t.Errorf("expecting shipment 7 in transit but got %+v", shipment)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdateShipmentStatus(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	columns := []string{"id", "transaction_id", "carrier", "tracking_number", "status", "created_at", "updated_at"}
+	createdAt := time.Date(2021, 1, 10, 0, 0, 0, 0, time.Local)
+	expectShipment := func(mock sqlmock.Sqlmock, status string) {
+		mock.ExpectQuery("SELECT transaction_id FROM shipments WHERE id = (.+)").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"transaction_id"}).AddRow(1))
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("shipped"))
+		mock.ExpectQuery("SELECT (.+) FROM shipments WHERE id = (.+) FOR UPDATE").WithArgs(7).
+			WillReturnRows(sqlmock.NewRows(columns).AddRow(7, 1, "jne", "JNE123", status, createdAt, createdAt))
+	}
+
+	t.Run("error-shipment-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT transaction_id FROM shipments WHERE id = (.+)").WithArgs(7).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateShipmentStatus(context.Background(), 7, ShipmentStatusDelivered, "carrier:jne")
+		if err != ErrShipmentNotFound {
+			t.Errorf("expecting ErrShipmentNotFound but got %v", err)
+		}
+	})
+
+	t.Run("error-invalid-transition", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectShipment(mock, ShipmentStatusDelivered)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateShipmentStatus(context.Background(), 7, ShipmentStatusReturned, "carrier:jne")
+		if err != ErrInvalidShipmentTransition {
+			t.Errorf("expecting ErrInvalidShipmentTransition but got %v", err)
+		}
+	})
+
+	t.Run("success-another-shipment-on-its-way", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectShipment(mock, ShipmentStatusInTransit)
+		mock.ExpectQuery("SELECT COUNT(.+) FROM shipments WHERE transaction_id = (.+) FOR UPDATE").WithArgs(1, 7, "in_transit", "out_for_delivery").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectExec("UPDATE shipments SET status=(.+), updated_at=(.+) WHERE id=(.+)").WithArgs("delivered", sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		shipment, err := mySQL.UpdateShipmentStatus(context.Background(), 7, ShipmentStatusDelivered, "carrier:jne")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if shipment.Status != ShipmentStatusDelivered {
+			t.Errorf("expecting the shipment delivered but got %s", shipment.Status)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-last-delivery-completes", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		expectShipment(mock, ShipmentStatusOutForDelivery)
+		mock.ExpectQuery("SELECT COUNT(.+) FROM shipments WHERE transaction_id = (.+) FOR UPDATE").WithArgs(1, 7, "in_transit", "out_for_delivery").
+			WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("completed", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(1, "shipped", "completed", "carrier:jne", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectExec("UPDATE shipments SET status=(.+), updated_at=(.+) WHERE id=(.+)").WithArgs("delivered", sqlmock.AnyArg(), 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateShipmentStatus(context.Background(), 7, ShipmentStatusDelivered, "carrier:jne")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetProductsByCategoryID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("WITH RECURSIVE subtree").WithArgs(1).WillReturnError(errors.New("connection refused"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.GetProductsByCategoryID(context.Background(), 1, nil)
+		if err == nil {
+			t.Error("error should be occurs")
+		}
+	})
+
+	t.Run("success-empty", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("WITH RECURSIVE subtree").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		products, _, err := mySQL.GetProductsByCategoryID(context.Background(), 1, nil)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(products) != 0 {
+			t.Errorf("expecting no products but got %v", products)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).
+			AddRow(1, 1, "macbook pro", 1200, "IDR", 3, "standard").
+			AddRow(2, 2, "legion", 1000, "IDR", 2, "standard")
+		mock.ExpectQuery(`WITH RECURSIVE subtree (.+) FROM products WHERE id IN (.+) ORDER BY id`).WithArgs(1).WillReturnRows(rows)
+		mock.ExpectQuery(`SELECT product_id, category_id FROM product_categories WHERE product_id IN \(\?,\?\)`).WithArgs(1, 2).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "category_id"}).AddRow(1, 2).AddRow(1, 3).AddRow(2, 3))
+		mock.ExpectQuery(`WITH RECURSIVE ancestors (.+) WHERE id IN \(\?,\?\)`).WithArgs(2, 3).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name"}).AddRow(2, 1, "laptops").AddRow(3, 1, "gaming").AddRow(1, nil, "computers"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		products, _, err := mySQL.GetProductsByCategoryID(context.Background(), 1, nil)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		computers := &CategoryRecord{ID: 1, Name: "computers"}
+		laptops := &CategoryRecord{ID: 2, ParentID: 1, Name: "laptops"}
+		gaming := &CategoryRecord{ID: 3, ParentID: 1, Name: "gaming"}
+		if len(products) != 2 ||
+			!reflect.DeepEqual(products[0].Categories, []CategoryPath{{computers, laptops}, {computers, gaming}}) ||
+			!reflect.DeepEqual(products[1].Categories, []CategoryPath{{computers, gaming}}) {
+			t.Errorf("unexpected products %v", products)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-parent-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO categories").WithArgs(100, "laptops").WillReturnError(&mysql.MySQLError{Number: mySQLErrNoReferencedRow})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateCategory(context.Background(), &CategoryRecord{ParentID: 100, Name: "laptops"})
+		if err != ErrCategoryNotFound {
+			t.Errorf("expecting ErrCategoryNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-root", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO categories").WithArgs(nil, "computers").WillReturnResult(sqlmock.NewResult(1, 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		category, err := mySQL.CreateCategory(context.Background(), &CategoryRecord{Name: "computers"})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if *category != (CategoryRecord{ID: 1, Name: "computers"}) {
+			t.Errorf("unexpected category %+v", category)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetCategories(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-exec-query-context", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM categories").WillReturnError(fmt.Errorf("Error DB"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, _, err = mySQL.GetCategories(context.Background(), nil)
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+
+	t.Run("success-page", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		rows := sqlmock.NewRows([]string{"id", "parent_id", "name"}).
+			AddRow(2, 1, "laptops").
+			AddRow(3, nil, "phones")
+		mock.ExpectQuery(`SELECT id, parent_id, name FROM categories WHERE id > \? ORDER BY id ASC LIMIT \?$`).WithArgs(1, 2).WillReturnRows(rows)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM categories$`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		categories, total, err := mySQL.GetCategories(context.Background(), &pagination.Page{Limit: 2, After: pagination.IDCursor(1)})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if total != 5 {
+			t.Errorf("expecting 5 categories in total but got %d", total)
+		}
+		want := []*CategoryRecord{{ID: 2, ParentID: 1, Name: "laptops"}, {ID: 3, Name: "phones"}}
+		if !reflect.DeepEqual(categories, want) {
+			t.Errorf("unexpected categories %v", categories)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetCategoryPaths(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery(`WITH RECURSIVE ancestors (.+) WHERE id IN \(\?,\?\)`).WithArgs(1, 100).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name"}).AddRow(1, nil, "computers"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetCategoryPaths(context.Background(), []int{100, 1})
+		if err != ErrCategoryNotFound {
+			t.Errorf("expecting ErrCategoryNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-no-categories", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		paths, err := mySQL.GetCategoryPaths(context.Background(), nil)
+		if err != nil || len(paths) != 0 {
+			t.Errorf("expecting no paths but got %v %v", paths, err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdateCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM categories WHERE id = (.+) FOR UPDATE").WithArgs(100).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateCategory(context.Background(), &CategoryRecord{ID: 100, Name: "laptops"})
+		if err != ErrCategoryNotFound {
+			t.Errorf("expecting ErrCategoryNotFound but got %v", err)
+		}
+	})
+
+	t.Run("error-parent-is-a-subcategory", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM categories WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("WITH RECURSIVE ancestors (.+) SELECT COUNT").WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateCategory(context.Background(), &CategoryRecord{ID: 1, ParentID: 2, Name: "computers"})
+		if err != ErrInvalidCategoryParent {
+			t.Errorf("expecting ErrInvalidCategoryParent but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM categories WHERE id = (.+) FOR UPDATE").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectQuery("WITH RECURSIVE ancestors (.+) SELECT COUNT").WithArgs(2, 3).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+		mock.ExpectExec("UPDATE categories").WithArgs(2, "gaming laptops", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateCategory(context.Background(), &CategoryRecord{ID: 3, ParentID: 2, Name: "gaming laptops"})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestDeleteCategory(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-has-children", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM categories").WithArgs(1).WillReturnError(&mysql.MySQLError{Number: mySQLErrRowIsReferenced})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteCategory(context.Background(), 1)
+		if err != ErrCategoryHasChildren {
+			t.Errorf("expecting ErrCategoryHasChildren but got %v", err)
+		}
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM categories").WithArgs(100).WillReturnResult(sqlmock.NewResult(0, 0))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteCategory(context.Background(), 100)
+		if err != ErrCategoryNotFound {
+			t.Errorf("expecting ErrCategoryNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM categories").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteCategory(context.Background(), 3)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+		}
+	})
+}
+
+func TestCreateVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-duplicate-sku", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO product_variants").WillReturnError(&mysql.MySQLError{Number: mySQLErrDupEntry})
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateVariant(context.Background(), &ProductVariantRecord{ProductID: 1, SKU: "MBP-SLV", Price: NewMoney(1100, CurrencyIDR)})
+		if err != ErrDuplicateSKU {
+			t.Errorf("expecting ErrDuplicateSKU but got %v", err)
+		}
+	})
+
+	t.Run("error-product-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO product_variants").WillReturnError(&mysql.MySQLError{Number: mySQLErrNoReferencedRow})
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateVariant(context.Background(), &ProductVariantRecord{ProductID: 100, SKU: "MBP-SLV", Price: NewMoney(1100, CurrencyIDR)})
+		if err != ErrProductNotFound {
+			t.Errorf("expecting ErrProductNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO product_variants").WithArgs(1, "MBP-SLV", []byte(`{"color":"silver"}`), 1100, "IDR", 4).WillReturnResult(sqlmock.NewResult(7, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}))
+		mock.ExpectExec("INSERT INTO warehouse_stock").WithArgs(1, 1, 7, 4).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, 7, 1, 4, "initial", nil, "system", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		variant, err := mySQL.CreateVariant(context.Background(), &ProductVariantRecord{ProductID: 1, SKU: "MBP-SLV", Options: map[string]string{"color": "silver"}, Price: NewMoney(1100, CurrencyIDR), Qty: 4})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if variant.ID != 7 || variant.Available != 4 {
+			t.Errorf("unexpected variant %+v", variant)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestUpdateVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id, qty FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(100).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateVariant(context.Background(), &ProductVariantRecord{ID: 100, SKU: "MBP-SLV"}, "admin")
+		if err != ErrVariantNotFound {
+			t.Errorf("expecting ErrVariantNotFound but got %v", err)
+		}
+	})
+
+	t.Run("success-records-adjustment", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id, qty FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).
+			WillReturnRows(sqlmock.NewRows([]string{"product_id", "qty"}).AddRow(1, 4))
+		mock.ExpectExec("UPDATE product_variants SET").WithArgs("MBP-SLV", []byte(`{}`), 1150, "IDR", 6, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(3, 4))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(2, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, 7, 1, 2, "adjustment", nil, "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.UpdateVariant(context.Background(), &ProductVariantRecord{ID: 7, SKU: "MBP-SLV", Price: NewMoney(1150, CurrencyIDR), Qty: 6}, "admin")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestDeleteVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-has-orders", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM product_variants").WithArgs(7).WillReturnError(&mysql.MySQLError{Number: mySQLErrRowIsReferenced})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteVariant(context.Background(), 7)
+		if err != ErrVariantHasOrders {
+			t.Errorf("expecting ErrVariantHasOrders but got %v", err)
+		}
+	})
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("DELETE FROM product_variants").WithArgs(100).WillReturnResult(sqlmock.NewResult(0, 0))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.DeleteVariant(context.Background(), 100)
+		if err != ErrVariantNotFound {
+			t.Errorf("expecting ErrVariantNotFound but got %v", err)
+		}
+	})
+}
+
+func TestCreateTransactionWithVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	productRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class"}).AddRow(1, 1, "macbook pro", 1200, "IDR", 0, "standard")
+	}
+	variantRows := func(productID int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "product_id", "sku", "options", "price", "currency", "qty"}).AddRow(7, productID, "MBP-SLV", []byte(`{"color":"silver"}`), 1500, "IDR", 3)
+	}
+
+	t.Run("error-variant-of-other-product", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(productRows())
+		mock.ExpectQuery("SELECT (.+) FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).WillReturnRows(variantRows(2))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, VariantID: 7, Qty: 1}}}
+		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		if err != ErrVariantNotFound {
+			t.Errorf("expecting ErrVariantNotFound but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success-reserves-variant-stock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+
+		// the product is only ordered in its variant, it is locked for its brand and tax class without reserving its own qty
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(productRows())
+		mock.ExpectQuery("SELECT (.+) FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).WillReturnRows(variantRows(1))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE variant_id = (.+) AND expires_at > (.+) FOR UPDATE").WithArgs(7, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(1))
+		expectAllocationRequest(mock, sqlmock.NewRows(stockRowColumns).AddRow(1, 1, 7, 3), sqlmock.NewRows(stockRowColumns).AddRow(1, 1, 7, 1))
+		mock.ExpectExec("INSERT INTO stock_reservations").WithArgs(1, 7, 1, 12, 2, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail").WithArgs(12, 1, 7, 1500, 2, 3000, 0).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("UPDATE transactions").WithArgs("IDR", 3000, 0, 0, false, 0, 3000, 12).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, VariantID: 7, Qty: 2}}}
+		result, err := mySQL.CreateTransaction(context.Background(), rec)
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if result.TransactionDetail[0].VariantID != 7 || result.GrandTotal.Amount != 3000 {
+			t.Errorf("unexpected transaction %+v", result.TransactionDetail[0])
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-insufficient-variant-stock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("INSERT INTO transactions").WillReturnResult(sqlmock.NewResult(12, 1))
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(productRows())
+		mock.ExpectQuery("SELECT (.+) FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).WillReturnRows(variantRows(1))
+		mock.ExpectQuery("SELECT COALESCE(.+) FROM stock_reservations WHERE variant_id = (.+)").WithArgs(7, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(2))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		rec := &TransactionRecord{UserID: 1, Date: time.Now(), TransactionDetail: []*TransactionDetailRecord{{ProductID: 1, VariantID: 7, Qty: 2}}}
+		_, err = mySQL.CreateTransaction(context.Background(), rec)
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+		}
+	})
+}
+
+func TestUpdateTransactionStatusVariant(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("success-pay-takes-variant-stock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT status FROM transactions WHERE id = (.+) FOR UPDATE").
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow("pending"))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE transaction_id = (.+) AND expires_at > (.+)").WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns))
+		mock.ExpectExec("DELETE FROM stock_reservations WHERE transaction_id = (.+)").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+
+		// the product itself is taken before its variant, the order CreateTransaction locks them in
+		mock.ExpectQuery("SELECT id, product_id, variant_id, qty FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "variant_id", "qty"}).AddRow(1, 1, 7, 2).AddRow(2, 1, nil, 1))
+		mock.ExpectQuery("SELECT qty FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE product_id = (.+) AND variant_id IS NULL").WithArgs(1, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(0))
+		mock.ExpectExec("UPDATE products SET qty = qty - (.+) WHERE id = (.+)").WithArgs(1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery("SELECT qty FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"qty"}).AddRow(3))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE variant_id = (.+)").WithArgs(7, sqlmock.AnyArg()).WillReturnRows(sqlmock.NewRows([]string{"reserved"}).AddRow(1))
+		mock.ExpectExec("UPDATE product_variants SET qty = qty - (.+) WHERE id = (.+)").WithArgs(2, 7).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		// the reservation of the order expired so the warehouses are picked again, the other order keeps its unit of variant 7
+		// in the main warehouse. The order ships to surabaya: the variant is taken from its warehouse first, the rest from the main one
+		mock.ExpectQuery("SELECT (.+) FROM warehouse_stock WHERE product_id IN").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns).AddRow(1, 1, nil, 3).AddRow(1, 1, 7, 2).AddRow(1, 1, 8, 5).AddRow(2, 1, 7, 1))
+		mock.ExpectQuery("SELECT (.+) FROM stock_reservations WHERE product_id IN").WithArgs(1, sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows(stockRowColumns).AddRow(1, 1, 7, 1))
+		mock.ExpectQuery("SELECT (.+) FROM warehouses ORDER BY id").WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "city", "country"}).AddRow(1, "MAIN", "main warehouse", "jakarta", "ID").AddRow(2, "SUB", "surabaya warehouse", "surabaya", "ID"))
+		mock.ExpectQuery("SELECT shipping_city, shipping_country FROM transactions").WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"shipping_city", "shipping_country"}).AddRow("Surabaya", "ID"))
+		mock.ExpectExec("INSERT INTO transaction_detail_allocations").WithArgs(1, 2, 1).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail_allocations").WithArgs(1, 1, 1).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectExec("INSERT INTO transaction_detail_allocations").WithArgs(2, 1, 1).WillReturnResult(sqlmock.NewResult(3, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(1, 3))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(-1, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 1, -1, "sale", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(2, 2))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(-1, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, 7, 1, -1, "sale", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(2, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(4, 1))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(-1, 4).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, 7, 2, -1, "sale", 1, "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(3, 1))
+
+		mock.ExpectExec("UPDATE transactions SET status").WithArgs("paid", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO transaction_status_history").WithArgs(1, "pending", "paid", "donny", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+		mock.ExpectQuery("SELECT (.+) FROM transactions").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "date", "currency", "subtotal", "discount", "tax", "tax_inclusive", "shipping_cost", "grand_total", "status", "shipping_recipient", "shipping_phone", "shipping_street", "shipping_city", "shipping_postal_code", "shipping_country"}).AddRow(1, 1, time.Now(), "IDR", 4200, 0, 0, false, 0, 4200, "paid", nil, nil, nil, nil, nil, nil))
+		mock.ExpectQuery("SELECT (.+) FROM transaction_detail").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "transaction_id", "product_id", "variant_id", "qty", "sub_total", "tax", "allocations", "coupon_id", "code", "amount"}).
+				AddRow(1, 1, 1, 7, 2, 3000, 0, nil, nil, nil, nil).
+				AddRow(2, 1, 1, nil, 1, 1200, 0, nil, nil, nil, nil))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		mySQL.SetAllocationStrategy(NearestWarehouseStrategy{})
+
+		transaction, err := mySQL.UpdateTransactionStatus(context.Background(), 1, TransactionStatusPaid, "donny")
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if transaction.TransactionDetail[0].VariantID != 7 || transaction.TransactionDetail[1].VariantID != 0 {
+			t.Errorf("unexpected detail variants %+v %+v", transaction.TransactionDetail[0], transaction.TransactionDetail[1])
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestCreateWarehouse(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-duplicate-code", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO warehouses").WillReturnError(&mysql.MySQLError{Number: mySQLErrDupEntry})
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.CreateWarehouse(context.Background(), &WarehouseRecord{Code: "MAIN", Name: "main warehouse", City: "jakarta", Country: "ID"})
+		if err != ErrDuplicateWarehouseCode {
+			t.Errorf("expecting ErrDuplicateWarehouseCode but got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectExec("INSERT INTO warehouses").WithArgs("SUB", "surabaya warehouse", "surabaya", "ID").WillReturnResult(sqlmock.NewResult(2, 1))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		warehouse, err := mySQL.CreateWarehouse(context.Background(), &WarehouseRecord{Code: "SUB", Name: "surabaya warehouse", City: "surabaya", Country: "ID"})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if warehouse.ID != 2 {
+			t.Errorf("expecting warehouse id 2 but got %d", warehouse.ID)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestGetWarehouseByID(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM warehouses WHERE id = (.+)").WithArgs(100).WillReturnRows(sqlmock.NewRows([]string{"id", "code", "name", "city", "country"}))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.GetWarehouseByID(context.Background(), 100)
+		if err != ErrWarehouseNotFound {
+			t.Errorf("expecting ErrWarehouseNotFound but got %v", err)
+		}
+	})
+}
+
+func TestGetProductWarehouseStock(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	db, mock, err := sqlmock.New()
+	mock.ExpectQuery("SELECT (.+) FROM warehouse_stock WHERE qty > 0 AND product_id = (.+) ORDER BY warehouse_id ASC, COALESCE\\(variant_id, 0\\) ASC").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"warehouse_id", "product_id", "variant_id", "qty"}).AddRow(1, 1, nil, 2).AddRow(1, 1, 7, 1).AddRow(2, 1, nil, 3))
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	// inject sqlmock.DB into MySQLDB
+	mySQL := MySQLDB{
+		instance: db,
+	}
+
+	stock, _, err := mySQL.GetProductWarehouseStock(context.Background(), 1, nil)
+	if err != nil {
+		t.Errorf("error shouldnt be occurs, got %s", err)
+		t.FailNow()
+	}
+	if len(stock) != 3 || stock[1].VariantID != 7 || stock[2].WarehouseID != 2 {
+		t.Errorf("unexpected stock %v", stock)
+	}
+}
+
+func TestTransferStock(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error-warehouse-not-found", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT COUNT(.+) FROM warehouses").WithArgs(1, 100).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: 1, ToWarehouseID: 100, ProductID: 1, Qty: 1, Actor: "admin"})
+		if err != ErrWarehouseNotFound {
+			t.Errorf("expecting ErrWarehouseNotFound but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-variant-of-another-product", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(2))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: 1, ToWarehouseID: 2, ProductID: 1, VariantID: 7, Qty: 1, Actor: "admin"})
+		if err != ErrVariantNotFound {
+			t.Errorf("expecting ErrVariantNotFound but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-insufficient-stock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT id FROM products WHERE id = (.+) FOR UPDATE").WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+		mock.ExpectQuery("SELECT COUNT(.+) FROM warehouses").WithArgs(2, 1).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(1, 5))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(3, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, nil, 1, 3, "transfer", nil, "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(2, 1, nil).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(2, 2))
+		mock.ExpectRollback()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: 2, ToWarehouseID: 1, ProductID: 1, Qty: 3, Actor: "admin"})
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT product_id FROM product_variants WHERE id = (.+) FOR UPDATE").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"product_id"}).AddRow(1))
+		mock.ExpectQuery("SELECT COUNT(.+) FROM warehouses").WithArgs(1, 2).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(1, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}).AddRow(3, 4))
+		mock.ExpectExec("UPDATE warehouse_stock SET qty = qty \\+ (.+) WHERE id = (.+)").WithArgs(-3, 3).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, 7, 1, -3, "transfer", nil, "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectQuery("SELECT id, qty FROM warehouse_stock WHERE warehouse_id = (.+) FOR UPDATE").WithArgs(2, 1, 7).WillReturnRows(sqlmock.NewRows([]string{"id", "qty"}))
+		mock.ExpectExec("INSERT INTO warehouse_stock").WithArgs(2, 1, 7, 3).WillReturnResult(sqlmock.NewResult(4, 1))
+		mock.ExpectExec("INSERT INTO stock_movements").WithArgs(1, 7, 2, 3, "transfer", nil, "admin", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(2, 1))
+		mock.ExpectCommit()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		movements, err := mySQL.TransferStock(context.Background(), &StockTransferRecord{FromWarehouseID: 1, ToWarehouseID: 2, ProductID: 1, VariantID: 7, Qty: 3, Actor: "admin", CreatedAt: time.Now()})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(movements) != 2 || movements[0].ID != 1 || movements[0].Delta != -3 || movements[1].ID != 2 || movements[1].WarehouseID != 2 {
+			t.Errorf("unexpected movements %+v %+v", movements[0], movements[1])
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+}
+
+func TestSearchProducts(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	t.Run("error", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE MATCH\\(name\\)").WithArgs("macbok", "macbok", "macbok").WillReturnError(errors.New("connection refused"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		_, err = mySQL.SearchProducts(context.Background(), &SearchQuery{Query: "macbok", Limit: 10})
+		if err == nil {
+			t.Error("error should be occurs")
+		}
+	})
+
+	t.Run("success-no-words", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+
+		result, err := mySQL.SearchProducts(context.Background(), &SearchQuery{Query: "--", Limit: 10})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if result.Total != 0 || len(result.Hits) != 0 {
+			t.Errorf("expecting no products but got %v", result)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		// the candidates are scored once, the page, the total and the facets all come from the same rows.
+		// The brand of product 5 is gone, it is counted in the total but in no brand facet.
+		// mac mini shares letters with the query but is no typo of it, it does not match.
+		mock.ExpectQuery("SELECT (.+), \\(SELECT b.name FROM brands b WHERE b.id = products.brand_id\\), \\(SELECT GROUP_CONCAT(.+)\\) FROM products WHERE MATCH\\(name\\) AGAINST(.+) OR EXISTS (.+) OR EXISTS (.+)$").
+			WithArgs("macbok", "macbok", "macbok").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class", "brand", "categories"}).
+				AddRow(4, 1, "macbook air", 900, CurrencyIDR, 1, TaxClassStandard, "apple", nil).
+				AddRow(5, 9, "macbook case", 1500, CurrencyIDR, 1, TaxClassStandard, nil, "accessories").
+				AddRow(6, 1, "mac mini", 800, CurrencyIDR, 1, TaxClassStandard, "apple", "desktop").
+				AddRow(1, 1, "macbook pro", 1200, CurrencyIDR, 3, TaxClassStandard, "apple", "laptop"))
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+		}
+		mySQL.SetSearchPriceBuckets([]int64{1100})
+
+		result, err := mySQL.SearchProducts(context.Background(), &SearchQuery{Query: "macbok", Limit: 1})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if len(result.Hits) != 1 || result.Hits[0].ID != 1 || result.Hits[0].Score != searchNameWeight*0.5 {
+			t.Errorf("expecting macbook pro but got %v", result.Hits)
+		}
+		if result.Total != 3 || len(result.Brands) != 1 || result.Brands[0].Count != 2 {
+			t.Errorf("expecting 3 products, 2 of apple, but got %d in %v", result.Total, result.Brands)
+		}
+		if len(result.PriceBuckets) != 2 || result.PriceBuckets[0].Count != 1 || result.PriceBuckets[1].Count != 2 {
+			t.Errorf("expecting 1 product below and 2 from 1100 but got %v", result.PriceBuckets)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
 	})
 }
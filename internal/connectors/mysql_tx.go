@@ -0,0 +1,128 @@
+package connectors
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mySQLErrLockWaitTimeout ER_LOCK_WAIT_TIMEOUT, the statement gave up waiting for a row lock
+	mySQLErrLockWaitTimeout = 1205
+
+	// mySQLErrLockDeadlock ER_LOCK_DEADLOCK, InnoDB picked this transaction as the deadlock victim and rolled it back
+	mySQLErrLockDeadlock = 1213
+)
+
+var (
+	txLog = log.WithField("file", "mysql_tx.go")
+
+	// defaultTxRetryPolicy used when the MySQLDB is not configured with its own policy
+	defaultTxRetryPolicy = TxRetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   20 * time.Millisecond,
+		MaxDelay:    500 * time.Millisecond,
+	}
+)
+
+// TxRetryPolicy bounds how often and how long a unit of work is retried after a retryable MySQL error
+type TxRetryPolicy struct {
+	// MaxAttempts total number of runs, including the first one
+	MaxAttempts int
+
+	// BaseDelay backoff before the second attempt, doubled for every further attempt
+	BaseDelay time.Duration
+
+	// MaxDelay upper bound of a single backoff
+	MaxDelay time.Duration
+}
+
+// backoff returns a random delay between zero and the exponential backoff of the attempt (full jitter),
+// so transactions that deadlocked on each other do not retry in lockstep
+func (p TxRetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << uint(attempt-1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// isRetryableTxError reports whether the whole db transaction can safely be run again.
+// InnoDB rolls back the complete transaction on a deadlock, and a lock wait timeout leaves nothing we rely on committed.
+func isRetryableTxError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	return mysqlErr.Number == mySQLErrLockDeadlock || mysqlErr.Number == mySQLErrLockWaitTimeout
+}
+
+// withTx runs fn inside a db transaction, commits when fn succeeds and rolls back when it fails.
+// Deadlocks and lock wait timeouts restart fn in a fresh db transaction with a jittered backoff,
+// until the retry policy is exhausted or ctx is done, in which case ctx.Err() is returned instead of the last error.
+// fn must not keep state between runs.
+func (db *MySQLDB) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	fLog := txLog.WithField("func", "withTx")
+
+	policy := db.txRetryPolicy
+	if policy.MaxAttempts <= 0 {
+		policy = defaultTxRetryPolicy
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = db.runTx(ctx, fn)
+		if err == nil || !isRetryableTxError(err) || attempt >= policy.MaxAttempts {
+			return err
+		}
+
+		delay := policy.backoff(attempt)
+		fLog.Warnf("attempt %d got %s, retrying in %s", attempt, err.Error(), delay)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			fLog.Errorf("ctx.Done got %s after attempt %d got %s", ctx.Err().Error(), attempt, err.Error())
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// runTx runs fn exactly once inside a db transaction
+func (db *MySQLDB) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	fLog := txLog.WithField("func", "runTx")
+
+	// start db transaction
+	tx, err := db.instance.BeginTx(ctx, nil)
+	if err != nil {
+		fLog.Errorf("db.instance.BeginTx got %s", err.Error())
+		return err
+	}
+
+	err = fn(tx)
+	if err != nil {
+		errRollback := tx.Rollback()
+		if errRollback != nil {
+			fLog.Errorf("error rollback, got %s", errRollback.Error())
+		}
+		return err
+	}
+
+	// commit transaction
+	err = tx.Commit()
+	if err != nil {
+		fLog.Errorf("tx.Commit got %s", err.Error())
+		return err
+	}
+
+	return nil
+}
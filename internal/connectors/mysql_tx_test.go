@@ -0,0 +1,203 @@
+package connectors
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/sirupsen/logrus"
+)
+
+func TestWithTx(t *testing.T) {
+	logrus.SetLevel(logrus.TraceLevel)
+	logrus.SetOutput(ioutil.Discard)
+
+	policy := TxRetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    5 * time.Millisecond,
+	}
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}
+	lockWaitTimeout := &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}
+
+	t.Run("retry-deadlock-then-commit", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE products").WillReturnError(deadlock)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE products").WillReturnError(lockWaitTimeout)
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectExec("UPDATE products").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance:      db,
+			txRetryPolicy: policy,
+		}
+
+		runs := 0
+		err = mySQL.withTx(context.Background(), func(tx *sql.Tx) error {
+			runs++
+			_, err := tx.ExecContext(context.Background(), "UPDATE products SET qty = qty - 1 WHERE id = 1")
+			return err
+		})
+		if err != nil {
+			t.Errorf("error shouldnt be occurs, got %s", err)
+			t.FailNow()
+		}
+		if runs != 3 {
+			t.Errorf("expecting 3 runs but got %d", runs)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-attempts-exhausted", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		for i := 0; i < policy.MaxAttempts; i++ {
+			mock.ExpectBegin()
+			mock.ExpectExec("UPDATE products").WillReturnError(deadlock)
+			mock.ExpectRollback()
+		}
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance:      db,
+			txRetryPolicy: policy,
+		}
+
+		err = mySQL.withTx(context.Background(), func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(context.Background(), "UPDATE products SET qty = qty - 1 WHERE id = 1")
+			return err
+		})
+		if err != deadlock {
+			t.Errorf("expecting the deadlock error but got %v", err)
+			t.FailNow()
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("there were unfulfilled expectations: %s", err)
+		}
+	})
+
+	t.Run("error-not-retryable", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance:      db,
+			txRetryPolicy: policy,
+		}
+
+		runs := 0
+		err = mySQL.withTx(context.Background(), func(tx *sql.Tx) error {
+			runs++
+			return ErrInsufficientStock
+		})
+		if err != ErrInsufficientStock {
+			t.Errorf("expecting ErrInsufficientStock but got %v", err)
+			t.FailNow()
+		}
+		if runs != 1 {
+			t.Errorf("expecting 1 run but got %d", runs)
+		}
+	})
+
+	t.Run("error-context-done", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectRollback()
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance: db,
+			txRetryPolicy: TxRetryPolicy{
+				MaxAttempts: 3,
+				BaseDelay:   time.Hour,
+				MaxDelay:    time.Hour,
+			},
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		runs := 0
+		err = mySQL.withTx(ctx, func(tx *sql.Tx) error {
+			runs++
+			cancel()
+			return deadlock
+		})
+		if err != context.Canceled {
+			t.Errorf("expecting context.Canceled but got %v", err)
+			t.FailNow()
+		}
+		if runs != 1 {
+			t.Errorf("expecting 1 run but got %d", runs)
+		}
+	})
+
+	t.Run("error-commit", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		mock.ExpectBegin()
+		mock.ExpectCommit().WillReturnError(fmt.Errorf("Commit Error"))
+
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		defer db.Close()
+		// inject sqlmock.DB into MySQLDB
+		mySQL := MySQLDB{
+			instance:      db,
+			txRetryPolicy: policy,
+		}
+
+		err = mySQL.withTx(context.Background(), func(tx *sql.Tx) error {
+			return nil
+		})
+		if err == nil {
+			t.Error("error should be occurs")
+			t.FailNow()
+		}
+	})
+}
+
+func TestTxRetryPolicyBackoff(t *testing.T) {
+	policy := TxRetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   10 * time.Millisecond,
+		MaxDelay:    25 * time.Millisecond,
+	}
+
+	for attempt := 1; attempt <= 4; attempt++ {
+		for i := 0; i < 100; i++ {
+			delay := policy.backoff(attempt)
+			if delay < 0 || delay > policy.MaxDelay {
+				t.Fatalf("attempt %d got delay %s outside [0, %s]", attempt, delay, policy.MaxDelay)
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package connectors
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+)
+
+// keyset the order of a list read with a pagination.Page: by a column then by id, or by id alone
+type keyset struct {
+	// column the sort column, empty when the list is sorted by id alone
+	column string
+
+	// id the id column, it orders the rows with the same column value
+	id string
+
+	// desc sorts descending instead of ascending
+	desc bool
+
+	// value the argument compared with column out of the Value of a cursor, nil compares the Value itself
+	value func(s string) (interface{}, error)
+}
+
+// idKeyset the order of the lists sorted by id alone
+var idKeyset = keyset{id: "id"}
+
+// listQuery the statements reading a list a page at a time and counting its rows
+type listQuery struct {
+	// with a WITH clause both statements start with, its arguments are the first of args
+	with string
+
+	// columns the selected columns, columnArgs the arguments of their placeholders
+	columns    string
+	columnArgs []interface{}
+
+	// from the table, where the conditions of the rows of the list and args the arguments of both
+	from  string
+	where []string
+	args  []interface{}
+
+	// order the order of the list
+	order keyset
+}
+
+// query the statement reading the rows of page followed by its arguments. The rows before a cursor are read in
+// the reverse order, reversed reports it so they are put back in the order of the list once read.
+// An invalid cursor is an ErrBadRequest.
+func (lq *listQuery) query(page *pagination.Page) (q string, qArgs []interface{}, reversed bool, err error) {
+	k := lq.order
+	where := append(make([]string, 0, len(lq.where)+1), lq.where...)
+	qArgs = make([]interface{}, 0, len(lq.columnArgs)+len(lq.args)+5)
+	if lq.with != "" {
+		// the arguments of the WITH clause come before the ones of the columns
+		qArgs = append(qArgs, lq.args...)
+		qArgs = append(qArgs, lq.columnArgs...)
+	} else {
+		qArgs = append(qArgs, lq.columnArgs...)
+		qArgs = append(qArgs, lq.args...)
+	}
+
+	var cursor *pagination.Cursor
+	if page != nil {
+		cursor = page.After
+		if page.Before != nil {
+			cursor = page.Before
+			reversed = true
+		}
+	}
+
+	desc := k.desc != reversed
+	op, direction := ">", "ASC"
+	if desc {
+		op, direction = "<", "DESC"
+	}
+
+	if cursor != nil {
+		if k.column == "" {
+			where = append(where, k.id+" "+op+" ?")
+			qArgs = append(qArgs, cursor.ID)
+		} else {
+			var value interface{} = cursor.Value
+			if k.value != nil {
+				value, err = k.value(cursor.Value)
+				if err != nil {
+					return "", nil, false, NewBadRequestError(err)
+				}
+			}
+			where = append(where, fmt.Sprintf("(%s %s ? OR (%s = ? AND %s %s ?))", k.column, op, k.column, k.id, op))
+			qArgs = append(qArgs, value, value, cursor.ID)
+		}
+	}
+
+	q = lq.with + "SELECT " + lq.columns + " FROM " + lq.from + whereClause(where) + " ORDER BY "
+	if k.column != "" {
+		q += k.column + " " + direction + ", "
+	}
+	q += k.id + " " + direction
+
+	if page != nil && page.Limit > 0 {
+		q += " LIMIT ?"
+		qArgs = append(qArgs, page.Limit)
+		if !page.Keyset() {
+			q += " OFFSET ?"
+			qArgs = append(qArgs, page.Offset)
+		}
+	}
+	return q, qArgs, reversed, nil
+}
+
+// whereClause the WHERE clause of the conditions of where, empty without conditions
+func whereClause(where []string) string {
+	if len(where) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(where, " AND ")
+}
+
+// readPage reads the rows of page of the list of lq, calling scan for every row, and returns the number of rows
+// of the list. list points at the slice scan appends to, it is kept in the order of the list.
+func (db *MySQLDB) readPage(ctx context.Context, lq *listQuery, page *pagination.Page, list interface{}, scan func(rows *sql.Rows) error) (int, error) {
+	fLog := mysqlLog.WithField("func", "readPage")
+
+	q, args, reversed, err := lq.query(page)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := db.instance.QueryContext(ctx, q, args...)
+	if err != nil {
+		fLog.Errorf("db.instance.QueryContext got %s", err.Error())
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			fLog.Errorf("rows.Scan got %s", err.Error())
+			return 0, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		fLog.Errorf("rows.Err got %s", err.Error())
+		return 0, err
+	}
+	if reversed {
+		reverseRows(reflect.ValueOf(list).Elem().Interface())
+	}
+
+	if total, ok := page.Total(n); ok {
+		return total, nil
+	}
+
+	var total int
+	err = db.instance.QueryRowContext(ctx, lq.with+"SELECT COUNT(*) FROM "+lq.from+whereClause(lq.where), lq.args...).Scan(&total)
+	if err != nil {
+		fLog.Errorf("row.Scan got %s", err.Error())
+		return 0, err
+	}
+	return total, nil
+}
+
+// reverseRows reverses the order of the slice rows in place
+func reverseRows(rows interface{}) {
+	swap := reflect.Swapper(rows)
+	for i, j := 0, reflect.ValueOf(rows).Len()-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
+
+// intCursorValue the Value of a cursor of a list sorted by an integer column
+func intCursorValue(s string) (interface{}, error) {
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// compareID compares the row of id with the row of the cursor in a list sorted by id alone, like pagination.Page.Bounds asks
+func compareID(id int, c *pagination.Cursor) int {
+	return compareInts(int64(id), int64(c.ID))
+}
+
+// compareInts -1, 0 or 1 as a is less than, equal to or greater than b
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// transactionCursorDate the date of the Value of a cursor of a transaction, its unix time in nanoseconds
+func transactionCursorDate(s string) (interface{}, error) {
+	nanos, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
+
+// TransactionCursor the cursor of trans in the orders of GetTransactionsByUserID
+func TransactionCursor(trans *TransactionRecord) *pagination.Cursor {
+	return &pagination.Cursor{Value: strconv.FormatInt(trans.Date.UnixNano(), 10), ID: trans.ID}
+}
+
+// ProductCursor the cursor of product in the products of GetProducts sorted by sortBy
+func ProductCursor(product *ProductRecord, sortBy string) *pagination.Cursor {
+	cursor := pagination.IDCursor(product.ID)
+	switch sortBy {
+	case ProductSortName:
+		cursor.Value = product.Name
+	case ProductSortPrice:
+		cursor.Value = strconv.FormatInt(product.Price.Amount, 10)
+	}
+	return cursor
+}
+
+// WarehouseStockCursor the cursor of stock in the stock of GetWarehouseStock
+func WarehouseStockCursor(stock *WarehouseStockRecord) *pagination.Cursor {
+	return &pagination.Cursor{Value: strconv.Itoa(stock.ProductID), ID: stock.VariantID}
+}
+
+// ProductWarehouseStockCursor the cursor of stock in the stock of GetProductWarehouseStock
+func ProductWarehouseStockCursor(stock *WarehouseStockRecord) *pagination.Cursor {
+	return &pagination.Cursor{Value: strconv.Itoa(stock.WarehouseID), ID: stock.VariantID}
+}
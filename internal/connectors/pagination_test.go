@@ -0,0 +1,91 @@
+package connectors
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/pagination"
+)
+
+func TestListQuery(t *testing.T) {
+	now := time.Date(2021, time.September, 1, 12, 0, 0, 0, time.UTC)
+	date := &pagination.Cursor{Value: "1630497600000000000", ID: 7}
+
+	tests := []struct {
+		name         string
+		lq           *listQuery
+		page         *pagination.Page
+		wantQuery    string
+		wantArgs     []interface{}
+		wantReversed bool
+	}{
+		{
+			"all",
+			&listQuery{columns: "id, name", from: "brands", order: idKeyset},
+			nil,
+			"SELECT id, name FROM brands ORDER BY id ASC",
+			[]interface{}{},
+			false,
+		},
+		{
+			"offset",
+			&listQuery{columns: "id", from: "payments", where: []string{"transaction_id = ?"}, args: []interface{}{3}, order: idKeyset},
+			&pagination.Page{Limit: 10, Offset: 20},
+			"SELECT id FROM payments WHERE transaction_id = ? ORDER BY id ASC LIMIT ? OFFSET ?",
+			[]interface{}{3, 10, 20},
+			false,
+		},
+		{
+			"after-id",
+			&listQuery{columns: "id, qty", columnArgs: []interface{}{now}, from: "product_variants", where: []string{"product_id = ?"}, args: []interface{}{1}, order: idKeyset},
+			&pagination.Page{Limit: 5, After: pagination.IDCursor(4)},
+			"SELECT id, qty FROM product_variants WHERE product_id = ? AND id > ? ORDER BY id ASC LIMIT ?",
+			[]interface{}{now, 1, 4, 5},
+			false,
+		},
+		{
+			"before-desc-column",
+			&listQuery{columns: "id", from: "transactions", order: keyset{column: "date", id: "id", desc: true, value: transactionCursorDate}},
+			&pagination.Page{Limit: 5, Before: date},
+			"SELECT id FROM transactions WHERE (date > ? OR (date = ? AND id > ?)) ORDER BY date ASC, id ASC LIMIT ?",
+			[]interface{}{now, now, 7, 5},
+			true,
+		},
+		{
+			"with",
+			&listQuery{with: "WITH subtree (id) AS (SELECT ?) ", columns: "id", from: "products", where: []string{"id IN (SELECT id FROM subtree)"}, args: []interface{}{2}, order: idKeyset},
+			&pagination.Page{Limit: 5},
+			"WITH subtree (id) AS (SELECT ?) SELECT id FROM products WHERE id IN (SELECT id FROM subtree) ORDER BY id ASC LIMIT ? OFFSET ?",
+			[]interface{}{2, 5, 0},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, args, reversed, err := tt.lq.query(tt.page)
+			if err != nil {
+				t.Fatalf("error shouldnt be occurs, got %s", err)
+			}
+			if q != tt.wantQuery {
+				t.Errorf("query got %q, want %q", q, tt.wantQuery)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("args got %v, want %v", args, tt.wantArgs)
+			}
+			if reversed != tt.wantReversed {
+				t.Errorf("reversed got %v, want %v", reversed, tt.wantReversed)
+			}
+		})
+	}
+
+	t.Run("error-invalid-cursor", func(t *testing.T) {
+		lq := &listQuery{columns: "id", from: "transactions", order: keyset{column: "date", id: "id", value: transactionCursorDate}}
+		_, _, _, err := lq.query(&pagination.Page{Limit: 5, After: &pagination.Cursor{Value: "yesterday", ID: 1}})
+		if !errors.Is(err, ErrBadRequest) {
+			t.Errorf("expecting a bad request but got %v", err)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+package connectors
+
+const (
+	// PaymentStatusPending the gateway has not answered yet, it sends the outcome with a webhook
+	PaymentStatusPending = "pending"
+
+	// PaymentStatusAuthorized the gateway holds the amount, it is taken once the payment is captured
+	PaymentStatusAuthorized = "authorized"
+
+	// PaymentStatusCaptured the amount is taken and the order is paid
+	PaymentStatusCaptured = "captured"
+
+	// PaymentStatusFailed the payment is declined, voided or could not be completed, nothing is taken
+	PaymentStatusFailed = "failed"
+
+	// PaymentStatusRefunded the amount is given back to the customer
+	PaymentStatusRefunded = "refunded"
+)
+
+var (
+	// paymentStatusTransitions every status a payment may move to from a given status.
+	// failed and refunded are final.
+	paymentStatusTransitions = map[string][]string{
+		PaymentStatusPending:    {PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusFailed},
+		PaymentStatusAuthorized: {PaymentStatusCaptured, PaymentStatusFailed},
+		PaymentStatusCaptured:   {PaymentStatusRefunded},
+	}
+)
+
+// CanTransitionPaymentStatus reports whether a payment in status from may move to status to
+func CanTransitionPaymentStatus(from, to string) bool {
+	for _, status := range paymentStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// isActivePaymentStatus reports whether a payment in status holds, or may still take, the amount of its order.
+// An order has a single active payment at a time.
+func isActivePaymentStatus(status string) bool {
+	switch status {
+	case PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusCaptured:
+		return true
+	}
+	return false
+}
+
+// canAnswerPayment reports whether payment is still waiting for the answer of the gateway to its authorization
+// and answer is one the gateway may give
+func canAnswerPayment(payment *PaymentRecord, answer *GatewayPayment) bool {
+	if payment.Status != PaymentStatusPending || payment.Reference != "" {
+		return false
+	}
+	switch answer.Status {
+	case PaymentStatusPending, PaymentStatusAuthorized, PaymentStatusFailed:
+		return true
+	}
+	return false
+}
+
+// paymentTransactionStatus the status a payment moving to status moves its transaction to, empty when it does not move it
+func paymentTransactionStatus(status string) string {
+	switch status {
+	case PaymentStatusCaptured:
+		return TransactionStatusPaid
+	case PaymentStatusRefunded:
+		return TransactionStatusRefunded
+	}
+	return ""
+}
+
+// GatewayActor the actor recorded in the status history for changes made by the payment gateway, eg. through its webhook
+func GatewayActor(gateway string) string {
+	return "gateway:" + gateway
+}
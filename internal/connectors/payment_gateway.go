@@ -0,0 +1,146 @@
+package connectors
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+const (
+	// FakeGatewayName the name of FakePaymentGateway, stored with its payments
+	FakeGatewayName = "fake"
+
+	// FakeTokenDeclined a payment token FakePaymentGateway declines
+	FakeTokenDeclined = "tok_declined"
+
+	// FakeTokenPending a payment token FakePaymentGateway answers later, the outcome is sent to the webhook
+	FakeTokenPending = "tok_pending"
+)
+
+// PaymentRequest the payment of an order a gateway is asked to authorize
+type PaymentRequest struct {
+	TransactionID int
+	Amount        Money
+
+	// Token the payment method of the customer as the gateway tokenized it, eg. a card
+	Token string
+}
+
+// GatewayPayment the answer of a gateway to an authorization
+type GatewayPayment struct {
+	// Reference the id of the payment at the gateway
+	Reference string
+
+	// Status PaymentStatusAuthorized, PaymentStatusPending or PaymentStatusFailed
+	Status string
+}
+
+// PaymentEvent a change of a payment the gateway sends to the webhook
+type PaymentEvent struct {
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+}
+
+// PaymentGateway takes the payment of orders. The api authorizes the grand total of an order, captures it and refunds it,
+// and the gateway reports the outcome of the payments it could not answer right away with signed webhooks.
+type PaymentGateway interface {
+	// Name identifies the gateway, it is stored with every payment made through it
+	Name() string
+
+	// Authorize asks the gateway to hold the amount of req. The payment is authorized, pending when the gateway
+	// sends the outcome with a webhook later, or failed when it is declined.
+	Authorize(ctx context.Context, req *PaymentRequest) (*GatewayPayment, error)
+
+	// Capture takes the amount held by an authorized payment
+	Capture(ctx context.Context, reference string, amount Money) error
+
+	// Refund gives the amount taken by a captured payment back
+	Refund(ctx context.Context, reference string, amount Money) error
+
+	// ParseWebhook checks the signature of a webhook payload and decodes its event.
+	// ErrInvalidWebhookSignature is returned when the signature does not match the payload.
+	ParseWebhook(payload []byte, signature string) (*PaymentEvent, error)
+}
+
+// FakePaymentGateway a PaymentGateway that does not move any money, for local use and tests.
+// It declines FakeTokenDeclined, answers FakeTokenPending with a pending payment and authorizes any other token.
+// Its webhook payload is a json PaymentEvent signed with the hex hmac-sha256 of WebhookSecret.
+type FakePaymentGateway struct {
+	// WebhookSecret the key webhooks are signed with, no webhook is accepted when it is empty
+	WebhookSecret string
+}
+
+// NewFakePaymentGatewayFromConfig the FakePaymentGateway of the payment.fake.* configuration
+func NewFakePaymentGatewayFromConfig() *FakePaymentGateway {
+	return &FakePaymentGateway{WebhookSecret: config.Get("payment.fake.webhook.secret")}
+}
+
+// Name identifies the gateway, it is stored with every payment made through it
+func (g *FakePaymentGateway) Name() string {
+	return FakeGatewayName
+}
+
+// Authorize answers with the status of the token of req and a random reference
+func (g *FakePaymentGateway) Authorize(ctx context.Context, req *PaymentRequest) (*GatewayPayment, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := &GatewayPayment{Reference: "fake_" + hex.EncodeToString(b), Status: PaymentStatusAuthorized}
+	switch req.Token {
+	case FakeTokenDeclined:
+		payment.Status = PaymentStatusFailed
+	case FakeTokenPending:
+		payment.Status = PaymentStatusPending
+	}
+	return payment, nil
+}
+
+// Capture always succeeds
+func (g *FakePaymentGateway) Capture(ctx context.Context, reference string, amount Money) error {
+	return nil
+}
+
+// Refund always succeeds
+func (g *FakePaymentGateway) Refund(ctx context.Context, reference string, amount Money) error {
+	return nil
+}
+
+// Sign the signature of a webhook payload, what the gateway would send along with it
+func (g *FakePaymentGateway) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(g.WebhookSecret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseWebhook checks the signature of a webhook payload and decodes its event.
+// ErrInvalidWebhookSignature is returned when the signature does not match the payload.
+func (g *FakePaymentGateway) ParseWebhook(payload []byte, signature string) (*PaymentEvent, error) {
+	if g.WebhookSecret == "" || !hmac.Equal([]byte(g.Sign(payload)), []byte(signature)) {
+		return nil, ErrInvalidWebhookSignature
+	}
+
+	event := &PaymentEvent{}
+	err := json.Unmarshal(payload, event)
+	if err != nil {
+		return nil, NewBadRequestError(err)
+	}
+	if event.Reference == "" {
+		return nil, NewBadRequestError(fmt.Errorf("webhook event has no reference"))
+	}
+	switch event.Status {
+	case PaymentStatusAuthorized, PaymentStatusCaptured, PaymentStatusFailed, PaymentStatusRefunded:
+	default:
+		return nil, NewBadRequestError(fmt.Errorf("webhook event of payment %s has an unknown status %q", event.Reference, event.Status))
+	}
+
+	return event, nil
+}
@@ -0,0 +1,86 @@
+package connectors
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCanTransitionPaymentStatus(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{PaymentStatusPending, PaymentStatusAuthorized, true},
+		{PaymentStatusPending, PaymentStatusCaptured, true},
+		{PaymentStatusPending, PaymentStatusRefunded, false},
+		{PaymentStatusAuthorized, PaymentStatusCaptured, true},
+		{PaymentStatusAuthorized, PaymentStatusFailed, true},
+		{PaymentStatusCaptured, PaymentStatusRefunded, true},
+		{PaymentStatusCaptured, PaymentStatusFailed, false},
+		{PaymentStatusFailed, PaymentStatusCaptured, false},
+		{PaymentStatusRefunded, PaymentStatusCaptured, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransitionPaymentStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionPaymentStatus(%s, %s) got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestFakePaymentGateway(t *testing.T) {
+	gateway := &FakePaymentGateway{WebhookSecret: "secret"}
+
+	t.Run("authorize-by-token", func(t *testing.T) {
+		tests := map[string]string{
+			"tok_visa":        PaymentStatusAuthorized,
+			"":                PaymentStatusAuthorized,
+			FakeTokenDeclined: PaymentStatusFailed,
+			FakeTokenPending:  PaymentStatusPending,
+		}
+		for token, want := range tests {
+			payment, err := gateway.Authorize(context.Background(), &PaymentRequest{TransactionID: 1, Amount: NewMoney(1200, CurrencyIDR), Token: token})
+			assert.Nil(t, err)
+			assert.Equal(t, want, payment.Status, token)
+			assert.NotEmpty(t, payment.Reference)
+		}
+
+		first, _ := gateway.Authorize(context.Background(), &PaymentRequest{})
+		second, _ := gateway.Authorize(context.Background(), &PaymentRequest{})
+		assert.NotEqual(t, first.Reference, second.Reference)
+	})
+
+	t.Run("success-webhook", func(t *testing.T) {
+		payload := []byte(`{"reference": "fake_1", "status": "captured"}`)
+
+		event, err := gateway.ParseWebhook(payload, gateway.Sign(payload))
+		assert.Nil(t, err)
+		assert.Equal(t, &PaymentEvent{Reference: "fake_1", Status: PaymentStatusCaptured}, event)
+	})
+
+	t.Run("error-webhook-signature", func(t *testing.T) {
+		payload := []byte(`{"reference": "fake_1", "status": "captured"}`)
+		other := &FakePaymentGateway{WebhookSecret: "other"}
+
+		_, err := gateway.ParseWebhook(payload, other.Sign(payload))
+		assert.Equal(t, ErrInvalidWebhookSignature, err)
+		_, err = gateway.ParseWebhook([]byte(`{"reference": "fake_1", "status": "refunded"}`), gateway.Sign(payload))
+		assert.Equal(t, ErrInvalidWebhookSignature, err)
+
+		// without a secret anyone could sign, so nothing is accepted
+		unsigned := &FakePaymentGateway{}
+		_, err = unsigned.ParseWebhook(payload, unsigned.Sign(payload))
+		assert.Equal(t, ErrInvalidWebhookSignature, err)
+	})
+
+	t.Run("error-webhook-event", func(t *testing.T) {
+		for _, payload := range [][]byte{[]byte(`{"reference": "fake_1"`), []byte(`{"status": "captured"}`), []byte(`{"reference": "fake_1", "status": "pending"}`)} {
+			_, err := gateway.ParseWebhook(payload, gateway.Sign(payload))
+			assert.True(t, errors.Is(err, ErrBadRequest), string(payload))
+		}
+	})
+}
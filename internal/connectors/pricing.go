@@ -0,0 +1,121 @@
+package connectors
+
+import "fmt"
+
+// orderPrice the amounts of an order, the per detail amounts are in the order of its details
+type orderPrice struct {
+	SubTotals []Money
+	Discounts []Money
+	Taxes     []Money
+
+	Subtotal     Money
+	Discount     Money
+	Tax          Money
+	TaxInclusive bool
+	Shipping     Money
+	GrandTotal   Money
+}
+
+// priceOrder prices the details of an order from their products, or their variants for the details with one,
+// discounted by coupon when it is not nil and taxed by calc.
+// Every detail must be priced in the same currency, ErrCurrencyMismatch is returned otherwise,
+// and ErrMoneyOverflow is returned when an amount of the order does not fit.
+// An order without detail is priced in DefaultCurrency.
+func priceOrder(details []*TransactionDetailRecord, products map[int]*ProductRecord, variants map[int]*ProductVariantRecord, coupon *CouponRecord, calc TaxCalculator) (*orderPrice, error) {
+	currency := DefaultCurrency()
+	if len(details) > 0 {
+		currency = detailPrice(details[0], products, variants).Currency
+	}
+
+	price := &orderPrice{
+		SubTotals:    make([]Money, 0, len(details)),
+		Discounts:    make([]Money, 0, len(details)),
+		Taxes:        make([]Money, 0, len(details)),
+		Subtotal:     NewMoney(0, currency),
+		Discount:     NewMoney(0, currency),
+		Tax:          NewMoney(0, currency),
+		TaxInclusive: calc.Inclusive(),
+		Shipping:     NewMoney(0, currency),
+	}
+
+	lines := make([]couponLine, 0, len(details))
+	for _, detail := range details {
+		p := products[detail.ProductID]
+		unitPrice := detailPrice(detail, products, variants)
+		if unitPrice.Currency != currency {
+			return nil, newCurrencyMismatch("product %d is priced in %s, the order is in %s", p.ID, unitPrice.Currency, currency)
+		}
+
+		subTotal, err := unitPrice.Mul(int64(detail.Qty))
+		if err != nil {
+			return nil, err
+		}
+		price.Subtotal, err = price.Subtotal.Add(subTotal)
+		if err != nil {
+			return nil, err
+		}
+		price.SubTotals = append(price.SubTotals, subTotal)
+		lines = append(lines, couponLine{ProductID: p.ID, VariantID: detail.VariantID, BrandID: p.BrandID, Price: unitPrice.Amount, Qty: detail.Qty, SubTotal: subTotal.Amount})
+	}
+
+	discounts := make([]int64, len(details))
+	if coupon != nil {
+		var err error
+		discounts, err = couponDiscounts(coupon, currency, lines)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	taxLines := make([]TaxLine, 0, len(details))
+	for i, detail := range details {
+		p := products[detail.ProductID]
+		taxLines = append(taxLines, TaxLine{ProductID: p.ID, TaxClass: p.TaxClass, Qty: detail.Qty, Amount: lines[i].SubTotal - discounts[i]})
+	}
+	taxes, err := calc.Tax(taxLines)
+	if err != nil {
+		return nil, err
+	}
+	if len(taxes) != len(taxLines) {
+		return nil, fmt.Errorf("tax calculator returned %d taxes for %d lines", len(taxes), len(taxLines))
+	}
+
+	// a discount is at most its sub total, only the tax can overflow
+	for i := range details {
+		price.Discounts = append(price.Discounts, NewMoney(discounts[i], currency))
+		price.Discount.Amount += discounts[i]
+
+		price.Taxes = append(price.Taxes, NewMoney(taxes[i], currency))
+		price.Tax, err = price.Tax.Add(price.Taxes[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// an inclusive tax is already part of the discounted subtotal
+	price.GrandTotal, err = price.Subtotal.Sub(price.Discount)
+	if err != nil {
+		return nil, err
+	}
+	if !price.TaxInclusive {
+		price.GrandTotal, err = price.GrandTotal.Add(price.Tax)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return price, nil
+}
+
+// addShipping charges cost for shipping the order on top of its grand total.
+// ErrCurrencyMismatch is returned when cost is not in the currency of the order.
+func (p *orderPrice) addShipping(cost Money) error {
+	grandTotal, err := p.GrandTotal.Add(cost)
+	if err != nil {
+		return err
+	}
+
+	p.Shipping = cost
+	p.GrandTotal = grandTotal
+	return nil
+}
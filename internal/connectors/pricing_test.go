@@ -0,0 +1,67 @@
+package connectors
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestPriceOrder(t *testing.T) {
+	products := map[int]*ProductRecord{
+		1: {ID: 1, BrandID: 1, Price: NewMoney(1200, CurrencyIDR), TaxClass: TaxClassStandard},
+		2: {ID: 2, BrandID: 2, Price: NewMoney(1000, CurrencyIDR), TaxClass: TaxClassReduced},
+		3: {ID: 3, BrandID: 1, Price: NewMoney(50, "USD"), TaxClass: TaxClassStandard},
+		4: {ID: 4, BrandID: 1, Price: NewMoney(math.MaxInt64/2, CurrencyIDR), TaxClass: TaxClassStandard},
+	}
+	calc := &RateTaxCalculator{Rates: map[string]int{TaxClassStandard: 1100, TaxClassReduced: 500}}
+
+	t.Run("success", func(t *testing.T) {
+		details := []*TransactionDetailRecord{{ProductID: 1, Qty: 2}, {ProductID: 2, Qty: 1}}
+		coupon := &CouponRecord{Type: CouponTypePercentage, Value: 10, BrandID: 1}
+
+		price, err := priceOrder(details, products, nil, coupon, calc)
+		if err != nil {
+			t.Fatalf("priceOrder got error %v", err)
+		}
+		// 2400 - 240 is taxed 238 at 11%, 1000 is taxed 50 at 5%
+		if price.Subtotal != NewMoney(3400, CurrencyIDR) || price.Discount != NewMoney(240, CurrencyIDR) ||
+			price.Tax != NewMoney(288, CurrencyIDR) || price.GrandTotal != NewMoney(3448, CurrencyIDR) {
+			t.Errorf("unexpected order price %v %v %v %v", price.Subtotal, price.Discount, price.Tax, price.GrandTotal)
+		}
+		if price.SubTotals[0] != NewMoney(2400, CurrencyIDR) || price.Discounts[1] != NewMoney(0, CurrencyIDR) || price.Taxes[1] != NewMoney(50, CurrencyIDR) {
+			t.Errorf("unexpected detail prices %v %v %v", price.SubTotals, price.Discounts, price.Taxes)
+		}
+	})
+
+	t.Run("success-variant-price", func(t *testing.T) {
+		variants := map[int]*ProductVariantRecord{7: {ID: 7, ProductID: 1, Price: NewMoney(1500, CurrencyIDR)}}
+		details := []*TransactionDetailRecord{{ProductID: 1, VariantID: 7, Qty: 2}, {ProductID: 1, Qty: 1}}
+
+		price, err := priceOrder(details, products, variants, nil, calc)
+		if err != nil {
+			t.Fatalf("priceOrder got error %v", err)
+		}
+		// the variant is priced on its own, the product itself at its price, both taxed at the class of the product
+		if price.SubTotals[0] != NewMoney(3000, CurrencyIDR) || price.SubTotals[1] != NewMoney(1200, CurrencyIDR) || price.Tax != NewMoney(462, CurrencyIDR) {
+			t.Errorf("unexpected order price %v %v", price.SubTotals, price.Tax)
+		}
+	})
+
+	t.Run("error-mixed-currencies", func(t *testing.T) {
+		details := []*TransactionDetailRecord{{ProductID: 1, Qty: 1}, {ProductID: 3, Qty: 1}}
+
+		_, err := priceOrder(details, products, nil, nil, calc)
+		if !errors.Is(err, ErrCurrencyMismatch) {
+			t.Errorf("expecting ErrCurrencyMismatch, got %v", err)
+		}
+	})
+
+	t.Run("error-overflow", func(t *testing.T) {
+		details := []*TransactionDetailRecord{{ProductID: 4, Qty: 3}}
+
+		_, err := priceOrder(details, products, nil, nil, calc)
+		if !errors.Is(err, ErrMoneyOverflow) {
+			t.Errorf("expecting ErrMoneyOverflow, got %v", err)
+		}
+	})
+}
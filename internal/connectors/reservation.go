@@ -0,0 +1,32 @@
+package connectors
+
+import (
+	"time"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+// defaultReservationTTL how long a pending order holds its stock when the connector is not configured with its own ttl
+const defaultReservationTTL = 15 * time.Minute
+
+// reservationTTLFromConfig the order.reservation.ttl configuration, in minutes
+func reservationTTLFromConfig() time.Duration {
+	return time.Duration(config.GetInt("order.reservation.ttl")) * time.Minute
+}
+
+// reservationExpiry the end of a reservation made at from and held for ttl, a ttl of zero or less holds it for defaultReservationTTL
+func reservationExpiry(from time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		ttl = defaultReservationTTL
+	}
+	return from.Add(ttl)
+}
+
+// availableQty the qty that can still be ordered out of qty on hand with reserved held by pending orders.
+// It is never negative, even when the qty on hand was adjusted below the reserved qty.
+func availableQty(qty, reserved int) int {
+	if qty < reserved {
+		return 0
+	}
+	return qty - reserved
+}
@@ -0,0 +1,425 @@
+package connectors
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+const (
+	// searchNameWeight, searchBrandWeight and searchCategoryWeight how much a match in the name of a product,
+	// in the name of its brand and in the name of one of its categories adds to its relevance
+	searchNameWeight     = 3
+	searchBrandWeight    = 2
+	searchCategoryWeight = 1
+)
+
+var (
+	// searchFieldWeights the weight of every searchField
+	searchFieldWeights = [...]float64{
+		searchFieldName:     searchNameWeight,
+		searchFieldBrand:    searchBrandWeight,
+		searchFieldCategory: searchCategoryWeight,
+	}
+)
+
+// searchField the text of a product a term is found in
+type searchField int
+
+const (
+	searchFieldName searchField = iota
+	searchFieldBrand
+	searchFieldCategory
+)
+
+// SearchQuery the text searched for and the page of products returned
+type SearchQuery struct {
+	// Query the words searched for, a product matching any of them is returned
+	Query string
+
+	// Limit maximum number of products returned
+	Limit int
+
+	// Offset number of products skipped, the most relevant first
+	Offset int
+}
+
+// SearchHit a product matching a SearchQuery
+type SearchHit struct {
+	*ProductRecord
+
+	// Score the relevance of the product, a higher score is a better match.
+	// It only orders the products of one search.
+	Score float64
+}
+
+// BrandFacet the number of products of a brand matching a SearchQuery
+type BrandFacet struct {
+	BrandID int
+	Name    string
+	Count   int
+}
+
+// PriceBucketFacet the number of products priced in Currency from Min up to but not including Max matching a SearchQuery
+type PriceBucketFacet struct {
+	Currency string
+	Min      int64
+
+	// Max the upper bound of the bucket, nil for the last bucket which has none
+	Max *int64
+
+	Count int
+}
+
+// SearchResult a page of the products matching a SearchQuery, with the counts of every product matching it
+type SearchResult struct {
+	// Hits the most relevant first, products as relevant as each other are ordered by id
+	Hits []*SearchHit
+
+	// Total the number of products matching the query, on every page
+	Total int
+
+	// Brands the matching products per brand, the brands with the most first then by brand id
+	Brands []*BrandFacet
+
+	// PriceBuckets the matching products per currency and price bucket, ordered by currency then by price,
+	// the buckets without products are left out
+	PriceBuckets []*PriceBucketFacet
+}
+
+// Searcher finds the products whose name, brand or categories match the words of a query, tolerating typos.
+// Every implementation scores the products it finds with searchScore, so they rank and match typos alike.
+type Searcher interface {
+	// SearchProducts returns the page of q of the products matching it, the most relevant first,
+	// with the number of matching products per brand and per price bucket.
+	// A query without words matches no product.
+	SearchProducts(ctx context.Context, q *SearchQuery) (*SearchResult, error)
+}
+
+// newSearchResult the page of q of every hit of a search, the most relevant first, with the hits counted per brand
+// and per price bucket of bounds. brands names the brands of the hits, a hit whose brand is not named is counted in
+// Total but in no brand facet.
+func newSearchResult(q *SearchQuery, hits []*SearchHit, brands map[int]string, bounds []int64) *SearchResult {
+	brandCounts := make(map[int]int)
+	bucketCounts := make(map[string]map[int]int)
+	for _, hit := range hits {
+		if _, ok := brands[hit.BrandID]; ok {
+			brandCounts[hit.BrandID]++
+		}
+		if bucketCounts[hit.Price.Currency] == nil {
+			bucketCounts[hit.Price.Currency] = make(map[int]int)
+		}
+		bucketCounts[hit.Price.Currency][priceBucket(bounds, hit.Price.Amount)]++
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	result := &SearchResult{
+		Hits:         make([]*SearchHit, 0),
+		Total:        len(hits),
+		Brands:       make([]*BrandFacet, 0, len(brandCounts)),
+		PriceBuckets: make([]*PriceBucketFacet, 0),
+	}
+	if q.Offset < len(hits) {
+		result.Hits = hits[q.Offset:]
+		if len(result.Hits) > q.Limit {
+			result.Hits = result.Hits[:q.Limit]
+		}
+	}
+
+	for brandID, count := range brandCounts {
+		result.Brands = append(result.Brands, &BrandFacet{BrandID: brandID, Name: brands[brandID], Count: count})
+	}
+	sort.Slice(result.Brands, func(i, j int) bool {
+		if result.Brands[i].Count != result.Brands[j].Count {
+			return result.Brands[i].Count > result.Brands[j].Count
+		}
+		return result.Brands[i].BrandID < result.Brands[j].BrandID
+	})
+
+	currencies := make([]string, 0, len(bucketCounts))
+	for currency := range bucketCounts {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+	for _, currency := range currencies {
+		for bucket := 0; bucket <= len(bounds); bucket++ {
+			if count := bucketCounts[currency][bucket]; count > 0 {
+				result.PriceBuckets = append(result.PriceBuckets, priceBucketFacet(bounds, currency, bucket, count))
+			}
+		}
+	}
+
+	return result
+}
+
+// priceBucketsFromConfig the bounds of the price buckets of the search.facet.price.buckets configuration,
+// a comma separated list of amounts in minor units. The amounts that are not positive numbers are left out.
+func priceBucketsFromConfig() []int64 {
+	bounds := make([]int64, 0)
+	for _, s := range strings.Split(config.Get("search.facet.price.buckets"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		bound, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || bound <= 0 {
+			log.Warnf("invalid price bucket %q, leaving it out", s)
+			continue
+		}
+		bounds = append(bounds, bound)
+	}
+	return normalizePriceBuckets(bounds)
+}
+
+// normalizePriceBuckets sorts bounds and removes the duplicates
+func normalizePriceBuckets(bounds []int64) []int64 {
+	sorted := make([]int64, len(bounds))
+	copy(sorted, bounds)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	distinct := make([]int64, 0, len(sorted))
+	for i, bound := range sorted {
+		if i == 0 || bound != sorted[i-1] {
+			distinct = append(distinct, bound)
+		}
+	}
+	return distinct
+}
+
+// priceBucket the index of the bucket of bounds amount is in, len(bounds) for the last bucket
+func priceBucket(bounds []int64, amount int64) int {
+	return sort.Search(len(bounds), func(i int) bool {
+		return amount < bounds[i]
+	})
+}
+
+// priceBucketFacet the facet of the bucket of bounds at index bucket
+func priceBucketFacet(bounds []int64, currency string, bucket int, count int) *PriceBucketFacet {
+	facet := &PriceBucketFacet{Currency: currency, Count: count}
+	if bucket > 0 {
+		facet.Min = bounds[bucket-1]
+	}
+	if bucket < len(bounds) {
+		upper := bounds[bucket]
+		facet.Max = &upper
+	}
+	return facet
+}
+
+// searchTerms the distinct lower case words of text, the letters and digits between the other characters
+func searchTerms(text string) []string {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, term := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// maxTypos the number of typos a query term of length runes tolerates: none up to 3 letters, one up to 7, two above
+func maxTypos(length int) int {
+	switch {
+	case length <= 3:
+		return 0
+	case length <= 7:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// termMatch how well the query term matches the indexed word: 1 when it is the word, 0.75 when the word starts with it,
+// 0.5 for a word one typo away and 0.25 for two typos, 0 when it does not match
+func termMatch(term, word string) float64 {
+	if term == word {
+		return 1
+	}
+	if strings.HasPrefix(word, term) {
+		return 0.75
+	}
+
+	t, w := []rune(term), []rune(word)
+	typos := maxTypos(len(t))
+	if typos == 0 {
+		return 0
+	}
+	if d := editDistance(t, w, typos); d <= typos {
+		return 0.75 - 0.25*float64(d)
+	}
+	return 0
+}
+
+// editDistance the number of letters to insert, delete, replace or swap with the next one to turn a into b,
+// any distance above limit is returned as limit+1
+func editDistance(a, b []rune, limit int) int {
+	if diff := len(a) - len(b); diff > limit || -diff > limit {
+		return limit + 1
+	}
+
+	// the distances of the prefixes of a two letters shorter, one letter shorter and as long as the current one
+	before := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] && before[j-2]+1 < cur[j] {
+				cur[j] = before[j-2] + 1
+			}
+		}
+		before, prev, cur = prev, cur, before
+	}
+
+	if prev[len(b)] > limit {
+		return limit + 1
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// searchFields the words of the name, brand and categories of a product
+func searchFields(name string, brand string, categories []string) map[searchField][]string {
+	fields := map[searchField][]string{
+		searchFieldName:  searchTerms(name),
+		searchFieldBrand: searchTerms(brand),
+	}
+	for _, category := range categories {
+		fields[searchFieldCategory] = append(fields[searchFieldCategory], searchTerms(category)...)
+	}
+	return fields
+}
+
+// searchScore the relevance of a product with the words of fields to the terms of a query, 0 when it does not match.
+// Every term adds the weight of every field it matches times how well it matches the best word of the field.
+func searchScore(terms []string, fields map[searchField][]string) float64 {
+	score := 0.0
+	for _, term := range terms {
+		for field, words := range fields {
+			best := 0.0
+			for _, word := range words {
+				if match := termMatch(term, word); match > best {
+					best = match
+				}
+			}
+			score += searchFieldWeights[field] * best
+		}
+	}
+	return score
+}
+
+// searchIndex an inverted index of the words of the name, brand and categories of every product
+type searchIndex struct {
+	// postings the products every word is found in, with the fields of the product it is found in
+	postings map[string]map[int]map[searchField]bool
+
+	// words the words of every product keyed by product id, to take them out of postings when it is indexed again
+	words map[int][]string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[int]map[searchField]bool),
+		words:    make(map[int][]string),
+	}
+}
+
+// index replaces the words of the product of productID with the words of its name, brand and categories
+func (idx *searchIndex) index(productID int, name string, brand string, categories []string) {
+	idx.remove(productID)
+
+	fields := searchFields(name, brand, categories)
+	words := make([]string, 0)
+	for field, terms := range fields {
+		for _, term := range terms {
+			products, ok := idx.postings[term]
+			if !ok {
+				products = make(map[int]map[searchField]bool)
+				idx.postings[term] = products
+			}
+			if products[productID] == nil {
+				products[productID] = make(map[searchField]bool)
+				words = append(words, term)
+			}
+			products[productID][field] = true
+		}
+	}
+	idx.words[productID] = words
+}
+
+// remove takes the product of productID out of the index
+func (idx *searchIndex) remove(productID int) {
+	for _, word := range idx.words[productID] {
+		delete(idx.postings[word], productID)
+		if len(idx.postings[word]) == 0 {
+			delete(idx.postings, word)
+		}
+	}
+	delete(idx.words, productID)
+}
+
+// search the score of every product matching a term of query, the searchScore of its words
+// computed from the postings of the words matching a term instead of from every product
+func (idx *searchIndex) search(query string) map[int]float64 {
+	scores := make(map[int]float64)
+	for _, term := range searchTerms(query) {
+		best := make(map[int]map[searchField]float64)
+		// every word is compared for the typos, the vocabulary of a catalog is small enough for it
+		for word, products := range idx.postings {
+			match := termMatch(term, word)
+			if match == 0 {
+				continue
+			}
+			for productID, fields := range products {
+				if best[productID] == nil {
+					best[productID] = make(map[searchField]float64)
+				}
+				for field := range fields {
+					if match > best[productID][field] {
+						best[productID][field] = match
+					}
+				}
+			}
+		}
+
+		for productID, fields := range best {
+			for field, match := range fields {
+				scores[productID] += searchFieldWeights[field] * match
+			}
+		}
+	}
+	return scores
+}
@@ -0,0 +1,202 @@
+package connectors
+
+import (
+	"context"
+	"database/sql/driver"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestTermMatch(t *testing.T) {
+	tests := []struct {
+		term string
+		word string
+		want float64
+	}{
+		{"legion", "legion", 1},
+		{"mac", "macbook", 0.75},
+		{"macbok", "macbook", 0.5},
+		{"lenvo", "lenovo", 0.5},
+		{"legoin", "legion", 0.5},
+		{"labtops", "laptop", 0},
+		{"notebok", "notebooks", 0},
+		{"notebuuk", "notebook", 0.25},
+		{"rig", "rog", 0},
+		{"lgeoin", "legion", 0},
+		{"asus", "apple", 0},
+	}
+
+	for _, tt := range tests {
+		if got := termMatch(tt.term, tt.word); got != tt.want {
+			t.Errorf("termMatch(%q, %q) got %v, want %v", tt.term, tt.word, got, tt.want)
+		}
+	}
+}
+
+func TestSearchTerms(t *testing.T) {
+	got := searchTerms("  MacBook-Pro 14\", macbook ")
+	want := []string{"macbook", "pro", "14"}
+	if len(got) != len(want) {
+		t.Fatalf("searchTerms got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("searchTerms got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestPriceBucket(t *testing.T) {
+	bounds := normalizePriceBuckets([]int64{5000, 1000, 5000})
+	tests := []struct {
+		amount int64
+		want   int
+	}{
+		{0, 0},
+		{999, 0},
+		{1000, 1},
+		{4999, 1},
+		{5000, 2},
+		{100000, 2},
+	}
+
+	for _, tt := range tests {
+		if got := priceBucket(bounds, tt.amount); got != tt.want {
+			t.Errorf("priceBucket(%d) got %d, want %d", tt.amount, got, tt.want)
+		}
+	}
+
+	facet := priceBucketFacet(bounds, CurrencyIDR, 1, 3)
+	if facet.Min != 1000 || facet.Max == nil || *facet.Max != 5000 || facet.Count != 3 {
+		t.Errorf("priceBucketFacet got %+v", facet)
+	}
+	if facet := priceBucketFacet(bounds, CurrencyIDR, 2, 1); facet.Min != 5000 || facet.Max != nil {
+		t.Errorf("priceBucketFacet of the last bucket got %+v", facet)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	idx := newSearchIndex()
+	idx.index(1, "macbook pro", "apple", []string{"laptop"})
+	idx.index(2, "legion", "lenovo", []string{"gaming laptop"})
+
+	scores := idx.search("laptop")
+	if len(scores) != 2 || scores[1] != searchCategoryWeight || scores[2] != searchCategoryWeight {
+		t.Errorf("search laptop got %v", scores)
+	}
+
+	scores = idx.search("apple macbok")
+	if len(scores) != 1 || scores[1] != searchBrandWeight+searchNameWeight*0.5 {
+		t.Errorf("search apple macbok got %v", scores)
+	}
+
+	// indexing again replaces the words of the product
+	idx.index(1, "ipad", "apple", nil)
+	if scores = idx.search("macbook"); len(scores) != 0 {
+		t.Errorf("search macbook after the rename got %v", scores)
+	}
+	if scores = idx.search("laptop"); len(scores) != 1 {
+		t.Errorf("search laptop after the rename got %v", scores)
+	}
+
+	idx.remove(2)
+	if scores = idx.search("legion lenovo laptop"); len(scores) != 0 {
+		t.Errorf("search after the removal got %v", scores)
+	}
+	if len(idx.postings) != 2 {
+		t.Errorf("postings after the removal got %v, want ipad and apple", idx.postings)
+	}
+}
+
+func TestSearchBackendsAgree(t *testing.T) {
+	ctx := context.Background()
+	memory := NewInMemoryDB()
+	laptop, err := memory.CreateCategory(ctx, &CategoryRecord{Name: "laptop"})
+	if err != nil {
+		t.Fatalf("CreateCategory got %s", err)
+	}
+	for _, p := range []*ProductRecord{
+		{BrandID: 1, Name: "macbook air", Qty: 1, Price: NewMoney(900, CurrencyIDR), CategoryIDs: []int{laptop.ID}},
+		{BrandID: 2, Name: "thinkpad x1", Qty: 1, Price: NewMoney(1500, CurrencyIDR), CategoryIDs: []int{laptop.ID}},
+	} {
+		if _, err := memory.CreateProduct(ctx, p); err != nil {
+			t.Fatalf("CreateProduct got %s", err)
+		}
+	}
+
+	// the rows of searchCandidates for the catalog of memory. The fulltext indexes only narrow the candidates down,
+	// every product is returned so the scoring alone decides what matches.
+	ids := make([]int, 0, len(memory.products))
+	for id := range memory.products {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	candidates := func() *sqlmock.Rows {
+		rows := sqlmock.NewRows([]string{"id", "brand_id", "name", "price", "currency", "qty", "tax_class", "brand", "categories"})
+		for _, id := range ids {
+			p := memory.products[id]
+			var brand, categories driver.Value
+			if b, ok := memory.brands[p.BrandID]; ok {
+				brand = b.Name
+			}
+			names := make([]string, 0)
+			for _, categoryID := range memory.productCategories[id] {
+				names = append(names, memory.categories[categoryID].Name)
+			}
+			if len(names) > 0 {
+				categories = strings.Join(names, " ")
+			}
+			rows.AddRow(p.ID, p.BrandID, p.Name, p.Price.Amount, p.Price.Currency, p.Qty, p.TaxClass, brand, categories)
+		}
+		return rows
+	}
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"macbok", []int{1, 4}},
+		{"lenvo laptop", []int{5, 2, 4}},
+		{"apple", []int{1, 4}},
+		{"thinkpda", []int{5}},
+		{"rig", []int{}},
+	}
+
+	for _, tt := range tests {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("SELECT (.+) FROM products WHERE MATCH\\(name\\)").
+			WithArgs(tt.query, tt.query, tt.query).
+			WillReturnRows(candidates())
+		mySQL := &MySQLDB{instance: db}
+
+		for name, searcher := range map[string]Searcher{"in memory": memory, "mysql": mySQL} {
+			result, err := searcher.SearchProducts(ctx, &SearchQuery{Query: tt.query, Limit: 10})
+			if err != nil {
+				t.Errorf("%s search %q got %s", name, tt.query, err)
+				continue
+			}
+			got := make([]int, 0, len(result.Hits))
+			for _, hit := range result.Hits {
+				got = append(got, hit.ID)
+			}
+			if result.Total != len(tt.want) || len(got) != len(tt.want) {
+				t.Errorf("%s search %q got %v, want %v", name, tt.query, got, tt.want)
+				continue
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("%s search %q got %v, want %v", name, tt.query, got, tt.want)
+					break
+				}
+			}
+		}
+		db.Close()
+	}
+}
@@ -0,0 +1,121 @@
+package connectors
+
+import (
+	"context"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+const (
+	// ShipmentStatusInTransit the parcel is handed to the carrier, every shipment starts in it
+	ShipmentStatusInTransit = "in_transit"
+
+	// ShipmentStatusOutForDelivery the carrier is delivering the parcel today
+	ShipmentStatusOutForDelivery = "out_for_delivery"
+
+	// ShipmentStatusDelivered the customer received the parcel
+	ShipmentStatusDelivered = "delivered"
+
+	// ShipmentStatusReturned the parcel could not be delivered and went back to the sender
+	ShipmentStatusReturned = "returned"
+)
+
+var (
+	// shipmentStatusTransitions every status a shipment may move to from a given status.
+	// delivered and returned are final.
+	shipmentStatusTransitions = map[string][]string{
+		ShipmentStatusInTransit:      {ShipmentStatusOutForDelivery, ShipmentStatusDelivered, ShipmentStatusReturned},
+		ShipmentStatusOutForDelivery: {ShipmentStatusDelivered, ShipmentStatusReturned},
+	}
+)
+
+// CanTransitionShipmentStatus reports whether a shipment in status from may move to status to
+func CanTransitionShipmentStatus(from, to string) bool {
+	for _, status := range shipmentStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// shipmentOnItsWay reports whether a shipment in status is still to be delivered or returned
+func shipmentOnItsWay(status string) bool {
+	return status == ShipmentStatusInTransit || status == ShipmentStatusOutForDelivery
+}
+
+// ShippingRateRequest the order a ShippingRateProvider is asked to price the shipping of
+type ShippingRateRequest struct {
+	Address PostalAddress
+
+	// Qty the units ordered, every detail added up
+	Qty int
+
+	// Subtotal the sub total of the order after its discount, the shipping cost is in its currency
+	Subtotal Money
+}
+
+// ShippingRateProvider prices the shipping of orders, CreateTransaction calls it once per order with a shipping address
+type ShippingRateProvider interface {
+	// Rate returns the shipping cost of req in the currency of req.Subtotal
+	Rate(ctx context.Context, req *ShippingRateRequest) (Money, error)
+}
+
+// FlatShippingRateProvider a ShippingRateProvider charging a flat amount per order plus an amount per unit,
+// whatever the address. The amounts are in minor units of the currency of the order.
+type FlatShippingRateProvider struct {
+	Flat    int64
+	PerItem int64
+
+	// FreeFrom orders whose discounted subtotal reaches it ship free, zero never ships free
+	FreeFrom int64
+}
+
+// NewFlatShippingRateProviderFromConfig the FlatShippingRateProvider of the shipping.rate.* configuration
+func NewFlatShippingRateProviderFromConfig() *FlatShippingRateProvider {
+	return &FlatShippingRateProvider{
+		Flat:     int64(config.GetInt("shipping.rate.flat")),
+		PerItem:  int64(config.GetInt("shipping.rate.per.item")),
+		FreeFrom: int64(config.GetInt("shipping.rate.free.from")),
+	}
+}
+
+// Rate returns Flat plus PerItem for every unit of req, or nothing once the subtotal reaches FreeFrom
+func (p *FlatShippingRateProvider) Rate(ctx context.Context, req *ShippingRateRequest) (Money, error) {
+	currency := req.Subtotal.Currency
+	if p.FreeFrom > 0 && req.Subtotal.Amount >= p.FreeFrom {
+		return NewMoney(0, currency), nil
+	}
+
+	perItem, err := NewMoney(p.PerItem, currency).Mul(int64(req.Qty))
+	if err != nil {
+		return Money{}, err
+	}
+	return NewMoney(p.Flat, currency).Add(perItem)
+}
+
+// freeShipping the provider of a connector without one, it charges nothing
+var freeShipping ShippingRateProvider = &FlatShippingRateProvider{}
+
+// shipOrder adds the shipping cost of the details of an order shipped to address to its price, charged by rates.
+// An order without an address is not charged. ErrCurrencyMismatch is returned when rates charges in another currency.
+func shipOrder(ctx context.Context, price *orderPrice, details []*TransactionDetailRecord, address *PostalAddress, rates ShippingRateProvider) error {
+	if address == nil {
+		return nil
+	}
+
+	qty := 0
+	for _, detail := range details {
+		qty += detail.Qty
+	}
+	subtotal, err := price.Subtotal.Sub(price.Discount)
+	if err != nil {
+		return err
+	}
+
+	cost, err := rates.Rate(ctx, &ShippingRateRequest{Address: *address, Qty: qty, Subtotal: subtotal})
+	if err != nil {
+		return err
+	}
+	return price.addShipping(cost)
+}
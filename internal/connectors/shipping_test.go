@@ -0,0 +1,56 @@
+package connectors
+
+import (
+	"context"
+	"testing"
+)
+
+func TestFlatShippingRateProvider(t *testing.T) {
+	provider := &FlatShippingRateProvider{Flat: 100, PerItem: 25, FreeFrom: 2000}
+
+	tests := []struct {
+		name     string
+		qty      int
+		subtotal int64
+		want     int64
+	}{
+		{"flat-plus-per-item", 3, 1500, 175},
+		{"free-from", 3, 2000, 0},
+	}
+
+	for _, tt := range tests {
+		got, err := provider.Rate(context.Background(), &ShippingRateRequest{Qty: tt.qty, Subtotal: NewMoney(tt.subtotal, "IDR")})
+		if err != nil {
+			t.Errorf("%s: Rate got error %v", tt.name, err)
+			continue
+		}
+		if got != NewMoney(tt.want, "IDR") {
+			t.Errorf("%s: Rate got %+v, want %d IDR", tt.name, got, tt.want)
+		}
+	}
+
+	got, _ := freeShipping.Rate(context.Background(), &ShippingRateRequest{Qty: 3, Subtotal: NewMoney(100, "USD")})
+	if got != NewMoney(0, "USD") {
+		t.Errorf("freeShipping: Rate got %+v, want 0 USD", got)
+	}
+}
+
+func TestCanTransitionShipmentStatus(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{ShipmentStatusInTransit, ShipmentStatusOutForDelivery, true},
+		{ShipmentStatusInTransit, ShipmentStatusDelivered, true},
+		{ShipmentStatusOutForDelivery, ShipmentStatusReturned, true},
+		{ShipmentStatusOutForDelivery, ShipmentStatusInTransit, false},
+		{ShipmentStatusDelivered, ShipmentStatusReturned, false},
+		{ShipmentStatusReturned, ShipmentStatusInTransit, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransitionShipmentStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionShipmentStatus(%s, %s) got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
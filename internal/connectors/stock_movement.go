@@ -0,0 +1,35 @@
+package connectors
+
+const (
+	// StockReasonInitial the qty a product is created with
+	StockReasonInitial = "initial"
+
+	// StockReasonSale the qty taken by an order
+	StockReasonSale = "sale"
+
+	// StockReasonCancel the qty given back by a cancelled order
+	StockReasonCancel = "cancel"
+
+	// StockReasonRefund the qty given back by a refunded order
+	StockReasonRefund = "refund"
+
+	// StockReasonRestock the qty added by a delivery from the supplier
+	StockReasonRestock = "restock"
+
+	// StockReasonAdjustment a manual correction, eg. after a stock count or an edit of the product
+	StockReasonAdjustment = "adjustment"
+
+	// StockReasonTransfer the qty moved out of a warehouse into another, the qty of the product does not change
+	StockReasonTransfer = "transfer"
+
+	// systemActor the actor recorded for stock changes that are not made by a known person
+	systemActor = "system"
+)
+
+// restoreStockReason the stock movement reason of an order moving into a status that restores its stock
+func restoreStockReason(status string) string {
+	if status == TransactionStatusRefunded {
+		return StockReasonRefund
+	}
+	return StockReasonCancel
+}
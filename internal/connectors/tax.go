@@ -0,0 +1,94 @@
+package connectors
+
+import (
+	"math"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+const (
+	// TaxClassStandard the tax class of most products, and of a product without a class
+	TaxClassStandard = "standard"
+
+	// TaxClassReduced a lower rate, eg. for basic goods
+	TaxClassReduced = "reduced"
+
+	// TaxClassExempt no tax is charged
+	TaxClassExempt = "exempt"
+)
+
+// TaxLine an order line after its discount, the unit a TaxCalculator works on
+type TaxLine struct {
+	ProductID int
+	TaxClass  string
+	Qty       int
+
+	// Amount the sub total of the line minus its discount, in minor units of the currency of the order
+	Amount int64
+}
+
+// TaxCalculator computes the tax of an order, CreateTransaction calls it once per order with every line
+type TaxCalculator interface {
+	// Tax returns the tax of every line, in the order of lines
+	Tax(lines []TaxLine) ([]int64, error)
+
+	// Inclusive reports whether the amounts already include the tax, so it is not added to the grand total
+	Inclusive() bool
+}
+
+// RateTaxCalculator a TaxCalculator charging a flat rate per tax class, rounded half up per line
+type RateTaxCalculator struct {
+	// Rates the rate of every tax class in basis points, eg. 1100 is 11%.
+	// A class missing from it is charged the rate of TaxClassStandard.
+	Rates map[string]int
+
+	// PricesIncludeTax the tax is taken out of the amounts instead of added on top of them
+	PricesIncludeTax bool
+}
+
+// NewRateTaxCalculatorFromConfig the RateTaxCalculator of the tax.* configuration
+func NewRateTaxCalculatorFromConfig() *RateTaxCalculator {
+	return &RateTaxCalculator{
+		Rates: map[string]int{
+			TaxClassStandard: percentToBasisPoints(config.GetFloat("tax.rate.standard")),
+			TaxClassReduced:  percentToBasisPoints(config.GetFloat("tax.rate.reduced")),
+			TaxClassExempt:   0,
+		},
+		PricesIncludeTax: config.GetBoolean("tax.prices.include.tax"),
+	}
+}
+
+// percentToBasisPoints 11.5 percent is 1150 basis points
+func percentToBasisPoints(percent float64) int {
+	return int(math.Round(percent * 100))
+}
+
+// Tax returns the tax of every line, in the order of lines
+func (c *RateTaxCalculator) Tax(lines []TaxLine) ([]int64, error) {
+	taxes := make([]int64, len(lines))
+	for i, line := range lines {
+		rate, ok := c.Rates[line.TaxClass]
+		if !ok {
+			rate = c.Rates[TaxClassStandard]
+		}
+		if rate == 0 || line.Amount <= 0 {
+			continue
+		}
+
+		if c.PricesIncludeTax {
+			// the amount is net * (1 + rate), the tax is the part above net
+			taxes[i] = mulDivRound(line.Amount, int64(rate), int64(10000+rate))
+		} else {
+			taxes[i] = mulDivRound(line.Amount, int64(rate), 10000)
+		}
+	}
+	return taxes, nil
+}
+
+// Inclusive reports whether the amounts already include the tax
+func (c *RateTaxCalculator) Inclusive() bool {
+	return c.PricesIncludeTax
+}
+
+// noTax the calculator of a connector without one, it charges nothing
+var noTax TaxCalculator = &RateTaxCalculator{}
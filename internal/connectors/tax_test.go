@@ -0,0 +1,43 @@
+package connectors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRateTaxCalculator(t *testing.T) {
+	rates := map[string]int{TaxClassStandard: 1100, TaxClassReduced: 500, TaxClassExempt: 0}
+	lines := []TaxLine{
+		{ProductID: 1, TaxClass: TaxClassStandard, Qty: 1, Amount: 1200},
+		{ProductID: 2, TaxClass: TaxClassReduced, Qty: 1, Amount: 1050},
+		{ProductID: 3, TaxClass: TaxClassExempt, Qty: 1, Amount: 1100},
+		{ProductID: 4, TaxClass: "luxury", Qty: 1, Amount: 1005},
+		{ProductID: 5, TaxClass: TaxClassStandard, Qty: 1, Amount: 0},
+	}
+
+	tests := []struct {
+		name       string
+		calculator *RateTaxCalculator
+		want       []int64
+	}{
+		// an unknown class is charged the standard rate, 110.55 rounds up to 111
+		{"exclusive", &RateTaxCalculator{Rates: rates}, []int64{132, 53, 0, 111, 0}},
+		// 1200 is 1081.08 net plus 118.92 tax at 11%, 1050 is 1000 net plus 50 at 5%
+		{"inclusive", &RateTaxCalculator{Rates: rates, PricesIncludeTax: true}, []int64{119, 50, 0, 100, 0}},
+		{"no-rates", &RateTaxCalculator{}, []int64{0, 0, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.calculator.Tax(lines)
+		if err != nil {
+			t.Errorf("%s: Tax got error %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Tax got %v, want %v", tt.name, got, tt.want)
+		}
+		if tt.calculator.Inclusive() != tt.calculator.PricesIncludeTax {
+			t.Errorf("%s: Inclusive got %v", tt.name, tt.calculator.Inclusive())
+		}
+	}
+}
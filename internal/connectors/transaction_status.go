@@ -0,0 +1,60 @@
+package connectors
+
+import "fmt"
+
+const (
+	// TransactionStatusPending the order is placed and its stock is reserved, waiting for payment
+	TransactionStatusPending = "pending"
+
+	// TransactionStatusPaid the order is paid, its stock is taken from the products and it is waiting to be shipped
+	TransactionStatusPaid = "paid"
+
+	// TransactionStatusShipped the order left the warehouse
+	TransactionStatusShipped = "shipped"
+
+	// TransactionStatusCompleted the order is received by the customer
+	TransactionStatusCompleted = "completed"
+
+	// TransactionStatusCancelled the order is cancelled before shipping, its reservation is released or its stock restored
+	TransactionStatusCancelled = "cancelled"
+
+	// TransactionStatusRefunded the order is paid back, its stock is restored
+	TransactionStatusRefunded = "refunded"
+)
+
+var (
+	// transactionStatusTransitions every status an order may move to from a given status.
+	// cancelled and refunded are final.
+	transactionStatusTransitions = map[string][]string{
+		TransactionStatusPending:   {TransactionStatusPaid, TransactionStatusCancelled},
+		TransactionStatusPaid:      {TransactionStatusShipped, TransactionStatusCancelled, TransactionStatusRefunded},
+		TransactionStatusShipped:   {TransactionStatusCompleted, TransactionStatusRefunded},
+		TransactionStatusCompleted: {TransactionStatusRefunded},
+	}
+)
+
+// CanTransitionTransactionStatus reports whether an order in status from may move to status to
+func CanTransitionTransactionStatus(from, to string) bool {
+	for _, status := range transactionStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// takesStock reports whether moving an order from status from to status to takes its qty from the products.
+// A pending order only reserves its qty, it is taken once the order is paid.
+func takesStock(from, to string) bool {
+	return from == TransactionStatusPending && to == TransactionStatusPaid
+}
+
+// restoresStock reports whether moving an order from status from to status to gives its qty back to the products
+func restoresStock(from, to string) bool {
+	return from != TransactionStatusPending && (to == TransactionStatusCancelled || to == TransactionStatusRefunded)
+}
+
+// userActor the actor recorded in the status history for changes made by the customer themselves
+func userActor(userID int) string {
+	return fmt.Sprintf("user:%d", userID)
+}
@@ -0,0 +1,53 @@
+package connectors
+
+import "testing"
+
+func TestCanTransitionTransactionStatus(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		want bool
+	}{
+		{TransactionStatusPending, TransactionStatusPaid, true},
+		{TransactionStatusPending, TransactionStatusCancelled, true},
+		{TransactionStatusPending, TransactionStatusShipped, false},
+		{TransactionStatusPaid, TransactionStatusShipped, true},
+		{TransactionStatusPaid, TransactionStatusRefunded, true},
+		{TransactionStatusShipped, TransactionStatusCancelled, false},
+		{TransactionStatusShipped, TransactionStatusCompleted, true},
+		{TransactionStatusCompleted, TransactionStatusRefunded, true},
+		{TransactionStatusCancelled, TransactionStatusPending, false},
+		{TransactionStatusRefunded, TransactionStatusPaid, false},
+		{TransactionStatusPending, TransactionStatusPending, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransitionTransactionStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionTransactionStatus(%s, %s) got %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+func TestStockTransitions(t *testing.T) {
+	tests := []struct {
+		from     string
+		to       string
+		takes    bool
+		restores bool
+	}{
+		{TransactionStatusPending, TransactionStatusPaid, true, false},
+		{TransactionStatusPending, TransactionStatusCancelled, false, false},
+		{TransactionStatusPaid, TransactionStatusCancelled, false, true},
+		{TransactionStatusPaid, TransactionStatusShipped, false, false},
+		{TransactionStatusShipped, TransactionStatusRefunded, false, true},
+	}
+
+	for _, tt := range tests {
+		if got := takesStock(tt.from, tt.to); got != tt.takes {
+			t.Errorf("takesStock(%s, %s) got %v, want %v", tt.from, tt.to, got, tt.takes)
+		}
+		if got := restoresStock(tt.from, tt.to); got != tt.restores {
+			t.Errorf("restoresStock(%s, %s) got %v, want %v", tt.from, tt.to, got, tt.restores)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package connectors
+
+import "sort"
+
+// stockKey the stock an ordered qty is reserved from and taken from, the variant of VariantID or the product itself when it is zero
+type stockKey struct {
+	ProductID int
+	VariantID int
+}
+
+// sortStockKeys orders keys the way their rows are locked, the products in ascending id order followed by the variants
+// in ascending id order, so concurrent orders touching the same stock queue up instead of deadlocking
+func sortStockKeys(keys []stockKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		return stockKeyLess(keys[i], keys[j])
+	})
+}
+
+// stockKeyLess reports whether the row of a is locked before the row of b, see sortStockKeys
+func stockKeyLess(a, b stockKey) bool {
+	if (a.VariantID == 0) != (b.VariantID == 0) {
+		return a.VariantID == 0
+	}
+	if a.VariantID != b.VariantID {
+		return a.VariantID < b.VariantID
+	}
+	return a.ProductID < b.ProductID
+}
+
+// orderedStock the qty of details added up per stockKey, with the keys in lock order, see sortStockKeys
+func orderedStock(details []*TransactionDetailRecord) (map[stockKey]int, []stockKey) {
+	orderedQty := make(map[stockKey]int)
+	keys := make([]stockKey, 0, len(details))
+	for _, detail := range details {
+		key := stockKey{ProductID: detail.ProductID, VariantID: detail.VariantID}
+		if _, ok := orderedQty[key]; !ok {
+			keys = append(keys, key)
+		}
+		orderedQty[key] += detail.Qty
+	}
+	sortStockKeys(keys)
+	return orderedQty, keys
+}
+
+// detailPrice the unit price of a detail, the price of its variant when it has one
+func detailPrice(detail *TransactionDetailRecord, products map[int]*ProductRecord, variants map[int]*ProductVariantRecord) Money {
+	if detail.VariantID != 0 {
+		return variants[detail.VariantID].Price
+	}
+	return products[detail.ProductID].Price
+}
+
+// copyVariant a copy of rec that shares none of its options
+func copyVariant(rec *ProductVariantRecord) *ProductVariantRecord {
+	v := *rec
+	v.Options = make(map[string]string, len(rec.Options))
+	for name, value := range rec.Options {
+		v.Options[name] = value
+	}
+	return &v
+}
@@ -0,0 +1,313 @@
+package connectors
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+const (
+	// DefaultWarehouseID the warehouse of the stock changes that do not name one, eg. the qty a product is created with.
+	// It holds the stock there was before the warehouses.
+	DefaultWarehouseID = 1
+
+	// AllocationSingleWarehouseFirst ships an order from the first warehouse holding all of it, a detail no warehouse holds
+	// all of is split over the warehouses in id order
+	AllocationSingleWarehouseFirst = "single_warehouse_first"
+
+	// AllocationNearest ships every detail from the warehouses nearest to the shipping address first
+	AllocationNearest = "nearest"
+
+	// AllocationSplit ships every detail from the warehouses in id order, splitting it over as many as it takes
+	AllocationSplit = "split"
+)
+
+// AllocationLine a detail of an order whose qty is reserved in, then taken from, the warehouses
+type AllocationLine struct {
+	ProductID int
+	VariantID int
+	Qty       int
+}
+
+// AllocationRequest the order an AllocationStrategy picks the warehouses of
+type AllocationRequest struct {
+	Lines []AllocationLine
+
+	// Stock the qty every warehouse holds of the products and variants of the lines,
+	// less the qty the other pending orders reserved there
+	Stock []*WarehouseStockRecord
+
+	// Warehouses every warehouse, ordered by id
+	Warehouses []*WarehouseRecord
+
+	// Address the address the order is shipped to, nil when it is not shipped
+	Address *PostalAddress
+}
+
+// AllocationStrategy picks the warehouses the stock of an order is taken from. CreateTransaction calls it once per order
+// and reserves the qty in the warehouses it picks, paying the order takes the qty from them. It is only called again
+// when the order is paid after its reservation expired, or once its warehouses no longer hold the qty it reserved.
+type AllocationStrategy interface {
+	// Allocate returns the allocations of every line, in the order of the lines.
+	// ErrInsufficientStock is returned when the warehouses do not hold the qty of a line.
+	Allocate(req *AllocationRequest) ([][]*AllocationRecord, error)
+}
+
+// SingleWarehouseFirstStrategy the AllocationStrategy of AllocationSingleWarehouseFirst
+type SingleWarehouseFirstStrategy struct{}
+
+// NearestWarehouseStrategy the AllocationStrategy of AllocationNearest. A warehouse in the city of the address is nearer
+// than one in its country, which is nearer than the others; an order without address ships like AllocationSplit.
+type NearestWarehouseStrategy struct{}
+
+// SplitStrategy the AllocationStrategy of AllocationSplit
+type SplitStrategy struct{}
+
+// defaultAllocation the strategy of a connector without one
+var defaultAllocation AllocationStrategy = SingleWarehouseFirstStrategy{}
+
+// NewAllocationStrategyFromConfig the AllocationStrategy of the order.allocation.strategy configuration.
+// An unknown strategy falls back to AllocationSingleWarehouseFirst.
+func NewAllocationStrategyFromConfig() AllocationStrategy {
+	switch name := strings.ToLower(config.Get("order.allocation.strategy")); name {
+	case AllocationSingleWarehouseFirst:
+		return SingleWarehouseFirstStrategy{}
+	case AllocationNearest:
+		return NearestWarehouseStrategy{}
+	case AllocationSplit:
+		return SplitStrategy{}
+	default:
+		log.Warnf("unknown allocation strategy %q, using %s", name, AllocationSingleWarehouseFirst)
+		return defaultAllocation
+	}
+}
+
+// Allocate ships every line from the first warehouse holding all of them when there is one. Otherwise every line
+// is shipped from the first warehouse holding all of it, or split over the warehouses in id order.
+func (SingleWarehouseFirstStrategy) Allocate(req *AllocationRequest) ([][]*AllocationRecord, error) {
+	left := newWarehouseStock(req.Stock)
+
+	for _, warehouse := range req.Warehouses {
+		if allocations, ok := left.takeAll(req.Lines, warehouse.ID); ok {
+			return allocations, nil
+		}
+	}
+
+	allocations := make([][]*AllocationRecord, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		var lineAllocations []*AllocationRecord
+		for _, warehouse := range req.Warehouses {
+			if left.holds(warehouse.ID, line) {
+				lineAllocations = left.take(line, []*WarehouseRecord{warehouse})
+				break
+			}
+		}
+		if lineAllocations == nil {
+			lineAllocations = left.take(line, req.Warehouses)
+		}
+		if lineAllocations == nil {
+			return nil, ErrInsufficientStock
+		}
+		allocations = append(allocations, lineAllocations)
+	}
+	return allocations, nil
+}
+
+// Allocate ships every line from the warehouses nearest to the address first, splitting it over the next nearest ones
+func (NearestWarehouseStrategy) Allocate(req *AllocationRequest) ([][]*AllocationRecord, error) {
+	warehouses := make([]*WarehouseRecord, len(req.Warehouses))
+	copy(warehouses, req.Warehouses)
+	if req.Address != nil {
+		sort.SliceStable(warehouses, func(i, j int) bool {
+			return warehouseDistance(warehouses[i], req.Address) < warehouseDistance(warehouses[j], req.Address)
+		})
+	}
+
+	return takeInOrder(req, warehouses)
+}
+
+// Allocate ships every line from the warehouses in id order, splitting it over as many as it takes
+func (SplitStrategy) Allocate(req *AllocationRequest) ([][]*AllocationRecord, error) {
+	return takeInOrder(req, req.Warehouses)
+}
+
+// takeInOrder takes every line of req from warehouses in their order
+func takeInOrder(req *AllocationRequest, warehouses []*WarehouseRecord) ([][]*AllocationRecord, error) {
+	left := newWarehouseStock(req.Stock)
+
+	allocations := make([][]*AllocationRecord, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lineAllocations := left.take(line, warehouses)
+		if lineAllocations == nil {
+			return nil, ErrInsufficientStock
+		}
+		allocations = append(allocations, lineAllocations)
+	}
+	return allocations, nil
+}
+
+// warehouseDistance how far a warehouse is from an address: 0 in its city, 1 in its country, 2 anywhere else
+func warehouseDistance(warehouse *WarehouseRecord, address *PostalAddress) int {
+	if !strings.EqualFold(warehouse.Country, address.Country) {
+		return 2
+	}
+	if !strings.EqualFold(warehouse.City, address.City) {
+		return 1
+	}
+	return 0
+}
+
+// warehouseStockKey the stock of a product or variant kept in a warehouse
+type warehouseStockKey struct {
+	WarehouseID int
+	stockKey
+}
+
+// warehouseStock the qty left in every warehouse while an order is allocated
+type warehouseStock map[warehouseStockKey]int
+
+func newWarehouseStock(stock []*WarehouseStockRecord) warehouseStock {
+	left := make(warehouseStock, len(stock))
+	for _, s := range stock {
+		left[warehouseStockKey{WarehouseID: s.WarehouseID, stockKey: stockKey{ProductID: s.ProductID, VariantID: s.VariantID}}] += s.Qty
+	}
+	return left
+}
+
+// holds reports whether warehouseID has the qty of line left
+func (left warehouseStock) holds(warehouseID int, line AllocationLine) bool {
+	return left[warehouseStockKey{WarehouseID: warehouseID, stockKey: stockKey{ProductID: line.ProductID, VariantID: line.VariantID}}] >= line.Qty
+}
+
+// takeAll takes every line from warehouseID when it holds all of them, nothing is taken otherwise
+func (left warehouseStock) takeAll(lines []AllocationLine, warehouseID int) ([][]*AllocationRecord, bool) {
+	// lines of the same product or variant need their qty added up
+	needed := make(map[stockKey]int)
+	for _, line := range lines {
+		needed[stockKey{ProductID: line.ProductID, VariantID: line.VariantID}] += line.Qty
+	}
+	for key, qty := range needed {
+		if left[warehouseStockKey{WarehouseID: warehouseID, stockKey: key}] < qty {
+			return nil, false
+		}
+	}
+
+	allocations := make([][]*AllocationRecord, 0, len(lines))
+	for _, line := range lines {
+		left[warehouseStockKey{WarehouseID: warehouseID, stockKey: stockKey{ProductID: line.ProductID, VariantID: line.VariantID}}] -= line.Qty
+		allocations = append(allocations, []*AllocationRecord{{WarehouseID: warehouseID, Qty: line.Qty}})
+	}
+	return allocations, true
+}
+
+// take takes the qty of line from warehouses in their order, as much as each holds.
+// Nothing is taken and nil is returned when they do not hold all of it.
+func (left warehouseStock) take(line AllocationLine, warehouses []*WarehouseRecord) []*AllocationRecord {
+	allocations := make([]*AllocationRecord, 0)
+	qty := line.Qty
+	for _, warehouse := range warehouses {
+		if qty == 0 {
+			break
+		}
+		key := warehouseStockKey{WarehouseID: warehouse.ID, stockKey: stockKey{ProductID: line.ProductID, VariantID: line.VariantID}}
+		taken := left[key]
+		if taken > qty {
+			taken = qty
+		}
+		if taken > 0 {
+			allocations = append(allocations, &AllocationRecord{WarehouseID: warehouse.ID, Qty: taken})
+			qty -= taken
+		}
+	}
+	if qty > 0 {
+		return nil
+	}
+
+	for _, allocation := range allocations {
+		left[warehouseStockKey{WarehouseID: allocation.WarehouseID, stockKey: stockKey{ProductID: line.ProductID, VariantID: line.VariantID}}] -= allocation.Qty
+	}
+	return allocations
+}
+
+// reservableStock the stock of every warehouse less the qty reserved there, a warehouse reserving more than it holds has none
+func reservableStock(stock []*WarehouseStockRecord, reserved map[warehouseStockKey]int) []*WarehouseStockRecord {
+	reservable := make([]*WarehouseStockRecord, 0, len(stock))
+	for _, s := range stock {
+		r := *s
+		r.Qty -= reserved[warehouseStockKey{WarehouseID: s.WarehouseID, stockKey: stockKey{ProductID: s.ProductID, VariantID: s.VariantID}}]
+		if r.Qty < 0 {
+			r.Qty = 0
+		}
+		reservable = append(reservable, &r)
+	}
+	return reservable
+}
+
+// reservedAllocations splits the qty an order reserved in every warehouse over the lines of req, in their order and
+// taking from the warehouses in id order. It reports false when the reservation does not cover the qty of every line,
+// eg. once it expired, or when a warehouse no longer holds the qty reserved there, eg. after a transfer.
+func reservedAllocations(req *AllocationRequest, reserved map[warehouseStockKey]int) ([][]*AllocationRecord, bool) {
+	left := newWarehouseStock(req.Stock)
+	for key, qty := range reserved {
+		if left[key] < qty {
+			return nil, false
+		}
+	}
+
+	unallocated := make(map[warehouseStockKey]int, len(reserved))
+	for key, qty := range reserved {
+		unallocated[key] = qty
+	}
+	allocations := make([][]*AllocationRecord, 0, len(req.Lines))
+	for _, line := range req.Lines {
+		lineAllocations := make([]*AllocationRecord, 0)
+		qty := line.Qty
+		for _, warehouse := range req.Warehouses {
+			key := warehouseStockKey{WarehouseID: warehouse.ID, stockKey: stockKey{ProductID: line.ProductID, VariantID: line.VariantID}}
+			taken := unallocated[key]
+			if taken > qty {
+				taken = qty
+			}
+			if taken > 0 {
+				lineAllocations = append(lineAllocations, &AllocationRecord{WarehouseID: warehouse.ID, Qty: taken})
+				unallocated[key] -= taken
+				qty -= taken
+			}
+		}
+		if qty > 0 {
+			return nil, false
+		}
+		allocations = append(allocations, lineAllocations)
+	}
+	return allocations, true
+}
+
+// allocatedStock the qty of allocations added up per product or variant and warehouse, with the keys in lock order:
+// the keys of sortStockKeys, then the warehouses of a key in id order
+func allocatedStock(lines []AllocationLine, allocations [][]*AllocationRecord) (map[warehouseStockKey]int, []warehouseStockKey) {
+	qty := make(map[warehouseStockKey]int)
+	keys := make([]warehouseStockKey, 0)
+	for i, line := range lines {
+		for _, allocation := range allocations[i] {
+			key := warehouseStockKey{WarehouseID: allocation.WarehouseID, stockKey: stockKey{ProductID: line.ProductID, VariantID: line.VariantID}}
+			if _, ok := qty[key]; !ok {
+				keys = append(keys, key)
+			}
+			qty[key] += allocation.Qty
+		}
+	}
+
+	sortWarehouseStockKeys(keys)
+	return qty, keys
+}
+
+// sortWarehouseStockKeys sorts keys like sortStockKeys, the warehouses of a product or variant in id order
+func sortWarehouseStockKeys(keys []warehouseStockKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].stockKey != keys[j].stockKey {
+			return stockKeyLess(keys[i].stockKey, keys[j].stockKey)
+		}
+		return keys[i].WarehouseID < keys[j].WarehouseID
+	})
+}
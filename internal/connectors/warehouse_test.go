@@ -0,0 +1,153 @@
+package connectors
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// allocationString writes allocations as "warehouse:qty" per warehouse, the lines separated by "|"
+func allocationString(allocations [][]*AllocationRecord) string {
+	lines := make([]string, 0, len(allocations))
+	for _, lineAllocations := range allocations {
+		parts := make([]string, 0, len(lineAllocations))
+		for _, allocation := range lineAllocations {
+			parts = append(parts, fmt.Sprintf("%d:%d", allocation.WarehouseID, allocation.Qty))
+		}
+		lines = append(lines, strings.Join(parts, ","))
+	}
+	return strings.Join(lines, "|")
+}
+
+func TestAllocationStrategy(t *testing.T) {
+	warehouses := []*WarehouseRecord{
+		{ID: 1, Code: "MAIN", City: "jakarta", Country: "ID"},
+		{ID: 2, Code: "SUB", City: "surabaya", Country: "ID"},
+		{ID: 3, Code: "SIN", City: "singapore", Country: "SG"},
+	}
+	stock := []*WarehouseStockRecord{
+		{WarehouseID: 1, ProductID: 1, Qty: 2},
+		{WarehouseID: 2, ProductID: 1, Qty: 3},
+		{WarehouseID: 2, ProductID: 2, Qty: 1},
+		{WarehouseID: 3, ProductID: 2, Qty: 5},
+		{WarehouseID: 3, ProductID: 2, VariantID: 7, Qty: 1},
+	}
+
+	tests := []struct {
+		name     string
+		strategy AllocationStrategy
+		lines    []AllocationLine
+		address  *PostalAddress
+		want     string
+		wantErr  error
+	}{
+		{"single-warehouse-holding-all", SingleWarehouseFirstStrategy{}, []AllocationLine{{ProductID: 1, Qty: 3}, {ProductID: 2, Qty: 1}}, nil, "2:3|2:1", nil},
+		{"single-warehouse-per-line", SingleWarehouseFirstStrategy{}, []AllocationLine{{ProductID: 1, Qty: 2}, {ProductID: 2, Qty: 2}}, nil, "1:2|3:2", nil},
+		{"single-warehouse-split-line", SingleWarehouseFirstStrategy{}, []AllocationLine{{ProductID: 1, Qty: 4}, {ProductID: 2, Qty: 1}}, nil, "1:2,2:2|2:1", nil},
+		{"split", SplitStrategy{}, []AllocationLine{{ProductID: 1, Qty: 3}, {ProductID: 2, Qty: 1}}, nil, "1:2,2:1|2:1", nil},
+		{"split-same-product-twice", SplitStrategy{}, []AllocationLine{{ProductID: 1, Qty: 2}, {ProductID: 1, Qty: 2}}, nil, "1:2|2:2", nil},
+		{"nearest-same-city", NearestWarehouseStrategy{}, []AllocationLine{{ProductID: 1, Qty: 3}, {ProductID: 2, Qty: 1}}, &PostalAddress{City: "Surabaya", Country: "ID"}, "2:3|2:1", nil},
+		{"nearest-same-country", NearestWarehouseStrategy{}, []AllocationLine{{ProductID: 2, Qty: 2}}, &PostalAddress{City: "bandung", Country: "ID"}, "2:1,3:1", nil},
+		{"nearest-other-country", NearestWarehouseStrategy{}, []AllocationLine{{ProductID: 1, Qty: 3}, {ProductID: 2, Qty: 1}}, &PostalAddress{City: "singapore", Country: "SG"}, "1:2,2:1|3:1", nil},
+		{"nearest-without-address", NearestWarehouseStrategy{}, []AllocationLine{{ProductID: 2, Qty: 1}}, nil, "2:1", nil},
+		{"variant-apart-from-product", SplitStrategy{}, []AllocationLine{{ProductID: 2, VariantID: 7, Qty: 1}}, nil, "3:1", nil},
+		{"error-insufficient-stock", SingleWarehouseFirstStrategy{}, []AllocationLine{{ProductID: 1, Qty: 6}}, nil, "", ErrInsufficientStock},
+		{"error-insufficient-variant-stock", SplitStrategy{}, []AllocationLine{{ProductID: 2, VariantID: 7, Qty: 2}}, nil, "", ErrInsufficientStock},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.strategy.Allocate(&AllocationRequest{Lines: tt.lines, Stock: stock, Warehouses: warehouses, Address: tt.address})
+		if err != tt.wantErr {
+			t.Errorf("%s: Allocate got error %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if s := allocationString(got); s != tt.want {
+			t.Errorf("%s: Allocate got %s, want %s", tt.name, s, tt.want)
+		}
+	}
+}
+
+func TestAllocatedStock(t *testing.T) {
+	lines := []AllocationLine{{ProductID: 2, Qty: 3}, {ProductID: 1, VariantID: 7, Qty: 1}, {ProductID: 2, Qty: 1}}
+	allocations := [][]*AllocationRecord{
+		{{WarehouseID: 2, Qty: 2}, {WarehouseID: 1, Qty: 1}},
+		{{WarehouseID: 1, Qty: 1}},
+		{{WarehouseID: 2, Qty: 1}},
+	}
+
+	qty, keys := allocatedStock(lines, allocations)
+	want := []warehouseStockKey{
+		{WarehouseID: 1, stockKey: stockKey{ProductID: 2}},
+		{WarehouseID: 2, stockKey: stockKey{ProductID: 2}},
+		{WarehouseID: 1, stockKey: stockKey{ProductID: 1, VariantID: 7}},
+	}
+	if fmt.Sprint(keys) != fmt.Sprint(want) {
+		t.Errorf("allocatedStock got keys %v, want %v", keys, want)
+	}
+	if qty[want[1]] != 3 {
+		t.Errorf("allocatedStock got qty %d for %v, want 3", qty[want[1]], want[1])
+	}
+}
+
+func TestReservableStock(t *testing.T) {
+	stock := []*WarehouseStockRecord{
+		{WarehouseID: 1, ProductID: 1, Qty: 2},
+		{WarehouseID: 2, ProductID: 1, Qty: 3},
+		{WarehouseID: 2, ProductID: 1, VariantID: 7, Qty: 1},
+	}
+	reserved := map[warehouseStockKey]int{
+		{WarehouseID: 1, stockKey: stockKey{ProductID: 1}}:               3,
+		{WarehouseID: 2, stockKey: stockKey{ProductID: 1}}:               1,
+		{WarehouseID: 1, stockKey: stockKey{ProductID: 1, VariantID: 7}}: 1,
+	}
+
+	got := reservableStock(stock, reserved)
+	want := []int{0, 2, 1}
+	for i, s := range got {
+		if s.Qty != want[i] {
+			t.Errorf("reservableStock got qty %d in warehouse %d, want %d", s.Qty, s.WarehouseID, want[i])
+		}
+	}
+	if stock[0].Qty != 2 {
+		t.Errorf("reservableStock changed the stock it was given")
+	}
+}
+
+func TestReservedAllocations(t *testing.T) {
+	warehouses := []*WarehouseRecord{{ID: 1}, {ID: 2}}
+	stock := []*WarehouseStockRecord{
+		{WarehouseID: 1, ProductID: 1, Qty: 2},
+		{WarehouseID: 2, ProductID: 1, Qty: 3},
+		{WarehouseID: 2, ProductID: 2, Qty: 1},
+	}
+	lines := []AllocationLine{{ProductID: 1, Qty: 1}, {ProductID: 2, Qty: 1}, {ProductID: 1, Qty: 3}}
+
+	tests := []struct {
+		name     string
+		reserved map[warehouseStockKey]int
+		want     string
+		wantOK   bool
+	}{
+		{"split-over-lines-in-warehouse-order", map[warehouseStockKey]int{
+			{WarehouseID: 1, stockKey: stockKey{ProductID: 1}}: 2,
+			{WarehouseID: 2, stockKey: stockKey{ProductID: 1}}: 2,
+			{WarehouseID: 2, stockKey: stockKey{ProductID: 2}}: 1,
+		}, "1:1|2:1|1:1,2:2", true},
+		{"expired", map[warehouseStockKey]int{}, "", false},
+		{"warehouse-no-longer-holds-it", map[warehouseStockKey]int{
+			{WarehouseID: 2, stockKey: stockKey{ProductID: 1}}: 4,
+			{WarehouseID: 2, stockKey: stockKey{ProductID: 2}}: 1,
+		}, "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := reservedAllocations(&AllocationRequest{Lines: lines, Stock: stock, Warehouses: warehouses}, tt.reserved)
+		if ok != tt.wantOK {
+			t.Errorf("%s: reservedAllocations got ok %v, want %v", tt.name, ok, tt.wantOK)
+			continue
+		}
+		if s := allocationString(got); s != tt.want {
+			t.Errorf("%s: reservedAllocations got %s, want %s", tt.name, s, tt.want)
+		}
+	}
+}
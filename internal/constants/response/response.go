@@ -39,8 +39,17 @@ func SetConfig(key, value string) {
 	viper.Set(key, value)
 }
 
-// Get fetch response configuration as string value
+// Get fetch response configuration as string value, the http status text when the key is not configured
 func Get(key string, httpCode int, typeResponse string) string {
+	if ret, ok := Lookup(key, httpCode, typeResponse); ok {
+		return ret
+	}
+
+	return http.StatusText(httpCode)
+}
+
+// Lookup fetch response configuration as string value and reports whether the key is configured
+func Lookup(key string, httpCode int, typeResponse string) (string, bool) {
 	if !initialized {
 		initialize()
 	}
@@ -52,20 +61,21 @@ func Get(key string, httpCode int, typeResponse string) string {
 		newKey += "." + typeResponse
 	}
 
-	ret := viper.GetString(newKey)
-	if len(ret) == 0 {
-		if ret, ok := defCfg[newKey]; ok {
-			return ret
-		}
-		log.Debugf("%s config key not found", newKey)
+	if ret := viper.GetString(newKey); len(ret) > 0 {
+		return ret, true
 	}
+	if ret, ok := defCfg[newKey]; ok {
+		return ret, true
+	}
+	log.Debugf("%s config key not found", newKey)
 
-	ret = http.StatusText(httpCode)
-
-	return ret
+	return "", false
 }
 
 // Set response configuration key value
 func Set(key, value string) {
+	if !initialized {
+		initialize()
+	}
 	defCfg[key] = value
 }
@@ -0,0 +1,248 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/arieffian/mw-backend-test/internal/config"
+)
+
+// Cursor the position of a row in the order of its list, handed to clients as an opaque string
+type Cursor struct {
+	// Value the value of the column the list is sorted by, empty for a list sorted by id alone
+	Value string
+
+	// ID the id of the row, it orders the rows with the same Value
+	ID int
+}
+
+// IDCursor the cursor of the row of id in a list sorted by id alone
+func IDCursor(id int) *Cursor {
+	return &Cursor{ID: id}
+}
+
+// Encode the opaque string of the cursor handed to clients
+func (c *Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(c.ID) + ":" + c.Value))
+}
+
+// DecodeCursor reads back a cursor made by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("cursor %q is malformed", s)
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("cursor %q is malformed", s)
+	}
+	return &Cursor{Value: parts[1], ID: id}, nil
+}
+
+// Page the rows of a list a repository returns, a nil page returns every row.
+// The rows are skipped by Offset, or with keyset pagination taken after or before the row of a cursor.
+type Page struct {
+	// Limit maximum number of rows returned, zero returns every row
+	Limit int
+
+	// Offset number of rows skipped, it is ignored with After or Before
+	Offset int
+
+	// After only the rows after the row of the cursor
+	After *Cursor
+
+	// Before only the last rows before the row of the cursor, they keep the order of the list
+	Before *Cursor
+}
+
+// Meta the meta block of a list response
+type Meta struct {
+	// Total the number of rows of the list, on every page
+	Total int `json:"total"`
+	Limit int `json:"limit"`
+
+	// Offset the rows skipped before the page, omitted when it is paged with cursors
+	Offset int `json:"offset,omitempty"`
+
+	// Next and Prev the links of the next and previous pages, omitted on the last and first page
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+}
+
+// DefaultLimit the page size of a request without limit, the pagination.limit.default configuration
+func DefaultLimit() int {
+	return config.GetInt("pagination.limit.default")
+}
+
+// MaxLimit the largest page size a request may ask, the pagination.limit.max configuration
+func MaxLimit() int {
+	return config.GetInt("pagination.limit.max")
+}
+
+// FromQuery the page asked by the limit, offset, after and before parameters of query.
+// An offset can not be combined with a cursor, nor after with before.
+func FromQuery(query url.Values) (*Page, error) {
+	page := &Page{Limit: DefaultLimit()}
+
+	if sLimit := query.Get("limit"); sLimit != "" {
+		limit, err := strconv.Atoi(sLimit)
+		if err != nil || limit < 1 || limit > MaxLimit() {
+			return nil, fmt.Errorf("Parameter limit must be between 1 and %d", MaxLimit())
+		}
+		page.Limit = limit
+	}
+
+	if sOffset := query.Get("offset"); sOffset != "" {
+		offset, err := strconv.Atoi(sOffset)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("Parameter offset must be a number of 0 or greater")
+		}
+		page.Offset = offset
+	}
+
+	for name, cursor := range map[string]**Cursor{"after": &page.After, "before": &page.Before} {
+		if s := query.Get(name); s != "" {
+			c, err := DecodeCursor(s)
+			if err != nil {
+				return nil, fmt.Errorf("Parameter %s is not a valid cursor", name)
+			}
+			*cursor = c
+		}
+	}
+
+	switch {
+	case page.After != nil && page.Before != nil:
+		return nil, fmt.Errorf("Parameter after can not be combined with before")
+	case page.Keyset() && query.Get("offset") != "":
+		return nil, fmt.Errorf("Parameter offset can not be combined with after or before")
+	}
+	return page, nil
+}
+
+// Keyset reports whether the page starts or ends at a cursor
+func (p *Page) Keyset() bool {
+	return p != nil && (p.After != nil || p.Before != nil)
+}
+
+// Fetch the page a list is read with to tell whether there are rows past the page, it holds one row more than p.
+// Meta trims the rows read with it back to p.
+func (p *Page) Fetch() *Page {
+	fetch := *p
+	fetch.Limit++
+	return &fetch
+}
+
+// Bounds the rows of the page out of the n rows of a list sorted in its order, from start up to but not including end.
+// cmp compares the row at i with a cursor, returning a negative number when the row is before it, zero when it is
+// the row of the cursor and a positive number when the row is after it.
+func (p *Page) Bounds(n int, cmp func(i int, c *Cursor) int) (start, end int) {
+	if p == nil {
+		return 0, n
+	}
+
+	switch {
+	case p.After != nil:
+		start = firstFrom(n, func(i int) bool { return cmp(i, p.After) > 0 })
+		end = n
+	case p.Before != nil:
+		end = firstFrom(n, func(i int) bool { return cmp(i, p.Before) >= 0 })
+		if p.Limit > 0 && end-p.Limit > 0 {
+			start = end - p.Limit
+		}
+		return start, end
+	default:
+		start, end = p.Offset, n
+		if start > n {
+			start = n
+		}
+	}
+
+	if p.Limit > 0 && start+p.Limit < end {
+		end = start + p.Limit
+	}
+	return start, end
+}
+
+// firstFrom the first of n rows for which after holds, every row after it holds too; n when there is none
+func firstFrom(n int, after func(i int) bool) int {
+	for i := 0; i < n; i++ {
+		if after(i) {
+			return i
+		}
+	}
+	return n
+}
+
+// Total the number of rows of the list when the n rows read with the page tell it, which they do when
+// the page starts at the first row and is not full
+func (p *Page) Total(n int) (int, bool) {
+	if p == nil || p.Limit <= 0 {
+		return n, true
+	}
+	if p.Keyset() || p.Offset > 0 || n >= p.Limit {
+		return 0, false
+	}
+	return n, true
+}
+
+// Meta the meta block of the rows of a list read with p.Fetch(), and the bounds of the rows of p out of the n read.
+// The links are made of u with the page parameters replaced: cursors of the rows returned by cursor, or offsets
+// when cursor is nil or the client asked for an offset.
+func (p *Page) Meta(u *url.URL, n int, total int, cursor func(i int) *Cursor) (start, end int, meta *Meta) {
+	more := n > p.Limit
+	end = n
+	if more {
+		end = p.Limit
+		// the row read past the page before a cursor is the first one
+		if p.Before != nil {
+			start, end = n-p.Limit, n
+		}
+	}
+
+	meta = &Meta{Total: total, Limit: p.Limit}
+	query := u.Query()
+	_, offsets := query["offset"]
+	if cursor == nil || (offsets && !p.Keyset()) {
+		meta.Offset = p.Offset
+		if p.Offset+p.Limit < total {
+			meta.Next = link(u, "offset", strconv.Itoa(p.Offset+p.Limit))
+		}
+		if p.Offset > 0 {
+			prev := p.Offset - p.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			meta.Prev = link(u, "offset", strconv.Itoa(prev))
+		}
+		return start, end, meta
+	}
+
+	// a page after a cursor has rows before it and a page before a cursor has rows after it, the row of the cursor at least
+	if end > start && (more || p.Before != nil) {
+		meta.Next = link(u, "after", cursor(end-1).Encode())
+	}
+	if end > start && (p.After != nil || (p.Before != nil && more)) {
+		meta.Prev = link(u, "before", cursor(start).Encode())
+	}
+	return start, end, meta
+}
+
+// link u with the page parameters replaced by name set to value
+func link(u *url.URL, name, value string) string {
+	query := u.Query()
+	query.Del("offset")
+	query.Del("after")
+	query.Del("before")
+	query.Set(name, value)
+
+	l := url.URL{Path: u.Path, RawQuery: query.Encode()}
+	return l.String()
+}
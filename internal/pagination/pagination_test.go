@@ -0,0 +1,164 @@
+package pagination
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	cursor := &Cursor{Value: "macbook: pro", ID: 7}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	decoded, err = DecodeCursor(IDCursor(3).Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, &Cursor{ID: 3}, decoded)
+
+	for _, s := range []string{"%%%", "NDI", "YWJjOmRlZg"} {
+		_, err = DecodeCursor(s)
+		assert.NotNil(t, err, s)
+	}
+}
+
+func TestFromQuery(t *testing.T) {
+	after := IDCursor(5).Encode()
+
+	tests := []struct {
+		name    string
+		query   string
+		want    *Page
+		wantErr string
+	}{
+		{"default", "", &Page{Limit: DefaultLimit()}, ""},
+		{"offset", "limit=5&offset=10", &Page{Limit: 5, Offset: 10}, ""},
+		{"after", "limit=5&after=" + after, &Page{Limit: 5, After: IDCursor(5)}, ""},
+		{"before", "before=" + after, &Page{Limit: DefaultLimit(), Before: IDCursor(5)}, ""},
+		{"limit-zero", "limit=0", nil, "Parameter limit must be between 1 and 100"},
+		{"limit-too-big", "limit=101", nil, "Parameter limit must be between 1 and 100"},
+		{"offset-negative", "offset=-1", nil, "Parameter offset must be a number of 0 or greater"},
+		{"after-invalid", "after=%25%25", nil, "Parameter after is not a valid cursor"},
+		{"after-and-before", "after=" + after + "&before=" + after, nil, "Parameter after can not be combined with before"},
+		{"offset-and-after", "offset=0&after=" + after, nil, "Parameter offset can not be combined with after or before"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			assert.Nil(t, err)
+
+			page, err := FromQuery(query)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, page)
+		})
+	}
+}
+
+func TestBounds(t *testing.T) {
+	// a list of the ids 10, 20, 30, 40, 50
+	cmp := func(i int, c *Cursor) int { return (i+1)*10 - c.ID }
+
+	tests := []struct {
+		name      string
+		page      *Page
+		wantStart int
+		wantEnd   int
+	}{
+		{"nil", nil, 0, 5},
+		{"no-limit", &Page{Offset: 1}, 1, 5},
+		{"offset", &Page{Limit: 2, Offset: 1}, 1, 3},
+		{"offset-past-the-end", &Page{Limit: 2, Offset: 9}, 5, 5},
+		{"after", &Page{Limit: 2, After: IDCursor(20)}, 2, 4},
+		{"after-removed-row", &Page{Limit: 2, After: IDCursor(25)}, 2, 4},
+		{"after-last", &Page{Limit: 2, After: IDCursor(50)}, 5, 5},
+		{"before", &Page{Limit: 2, Before: IDCursor(40)}, 1, 3},
+		{"before-start", &Page{Limit: 2, Before: IDCursor(20)}, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := tt.page.Bounds(5, cmp)
+			assert.Equal(t, tt.wantStart, start)
+			assert.Equal(t, tt.wantEnd, end)
+		})
+	}
+}
+
+func TestTotal(t *testing.T) {
+	total, ok := (*Page)(nil).Total(4)
+	assert.True(t, ok)
+	assert.Equal(t, 4, total)
+
+	total, ok = (&Page{Limit: 3}).Total(2)
+	assert.True(t, ok)
+	assert.Equal(t, 2, total)
+
+	_, ok = (&Page{Limit: 3}).Total(3)
+	assert.False(t, ok)
+
+	_, ok = (&Page{Limit: 3, Offset: 3}).Total(1)
+	assert.False(t, ok)
+
+	_, ok = (&Page{Limit: 3, After: IDCursor(1)}).Total(1)
+	assert.False(t, ok)
+}
+
+func TestMeta(t *testing.T) {
+	ids := []int{10, 20, 30}
+	cursor := func(i int) *Cursor { return IDCursor(ids[i]) }
+
+	t.Run("first-page", func(t *testing.T) {
+		u, _ := url.Parse("/brand?limit=2")
+		page := &Page{Limit: 2}
+
+		start, end, meta := page.Meta(u, 3, 5, cursor)
+		assert.Equal(t, 0, start)
+		assert.Equal(t, 2, end)
+		assert.Equal(t, &Meta{Total: 5, Limit: 2, Next: "/brand?after=" + IDCursor(20).Encode() + "&limit=2"}, meta)
+	})
+
+	t.Run("after-last-page", func(t *testing.T) {
+		u, _ := url.Parse("/brand?limit=2&after=" + IDCursor(20).Encode())
+		page := &Page{Limit: 2, After: IDCursor(20)}
+
+		start, end, meta := page.Meta(u, 1, 3, func(i int) *Cursor { return IDCursor(30) })
+		assert.Equal(t, 0, start)
+		assert.Equal(t, 1, end)
+		assert.Equal(t, &Meta{Total: 3, Limit: 2, Prev: "/brand?before=" + IDCursor(30).Encode() + "&limit=2"}, meta)
+	})
+
+	t.Run("before-page", func(t *testing.T) {
+		u, _ := url.Parse("/brand?limit=2&before=" + IDCursor(40).Encode())
+		page := &Page{Limit: 2, Before: IDCursor(40)}
+
+		// the extra row read before the page is the first one
+		start, end, meta := page.Meta(u, 3, 5, cursor)
+		assert.Equal(t, 1, start)
+		assert.Equal(t, 3, end)
+		assert.Equal(t, "/brand?before="+IDCursor(20).Encode()+"&limit=2", meta.Prev)
+		assert.Equal(t, "/brand?after="+IDCursor(30).Encode()+"&limit=2", meta.Next)
+	})
+
+	t.Run("offset", func(t *testing.T) {
+		u, _ := url.Parse("/search?q=mac&limit=2&offset=3")
+		page := &Page{Limit: 2, Offset: 3}
+
+		start, end, meta := page.Meta(u, 2, 6, nil)
+		assert.Equal(t, 0, start)
+		assert.Equal(t, 2, end)
+		assert.Equal(t, &Meta{
+			Total:  6,
+			Limit:  2,
+			Offset: 3,
+			Next:   "/search?limit=2&offset=5&q=mac",
+			Prev:   "/search?limit=2&offset=1&q=mac",
+		}, meta)
+	})
+}
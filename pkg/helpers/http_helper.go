@@ -8,12 +8,25 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+// statusReason the ErrorJSON.Reason of a failure response that does not come with its own error
+var statusReason = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusUnprocessableEntity: "validation_failed",
+	http.StatusInternalServerError: "internal_error",
+}
+
 // ResponseJSON define the structure of all response
 type ResponseJSON struct {
 	Status  int         `json:"status"`
 	Message string      `json:"message"`
 	Data    interface{} `json:"data"`
-	Error   *ErrorJSON  `json:"error,omitempty"`
+
+	// Meta describes the data, eg. the total and the links of the pages around a page of a list, omitted when nil
+	Meta  interface{} `json:"meta,omitempty"`
+	Error *ErrorJSON  `json:"error,omitempty"`
 }
 
 // ErrorJSON define the structure of an error
@@ -22,21 +35,31 @@ type ErrorJSON struct {
 	Reason       string `json:"reason"`           //
 	ErrTittleMsg string `json:"error_user_title"` // for user
 	ErrBodyMsg   string `json:"error_user_msg"`   // for user
+
+	// Fields every failed field of a validation error
+	Fields []*FieldErrorJSON `json:"fields,omitempty"`
 }
 
 // WriteHTTPResponse into the response writer, according to the response code and headers.
 // headerMap and data argument are both optional
 func WriteHTTPResponse(ctx context.Context, w http.ResponseWriter, httpRespCode int, message string, headerMap map[string]string, data interface{}, errors *ErrorJSON) {
+	WriteHTTPMetaResponse(ctx, w, httpRespCode, message, headerMap, data, nil, errors)
+}
+
+// WriteHTTPMetaResponse is WriteHTTPResponse with the meta block of data, meta is optional too
+func WriteHTTPMetaResponse(ctx context.Context, w http.ResponseWriter, httpRespCode int, message string, headerMap map[string]string, data interface{}, meta interface{}, errors *ErrorJSON) {
 	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(httpRespCode)
+	// headers must be set before WriteHeader, anything added afterwards is silently dropped
 	for k, v := range headerMap {
 		w.Header().Add(k, v)
 	}
+	w.WriteHeader(httpRespCode)
 
 	rJSON := &ResponseJSON{
 		Status:  httpRespCode,
 		Message: message,
 		Data:    data,
+		Meta:    meta,
 		Error:   errors,
 	}
 
@@ -48,7 +71,7 @@ func WriteHTTPResponse(ctx context.Context, w http.ResponseWriter, httpRespCode
 		if errors == nil {
 			rJSON.Error = &ErrorJSON{
 				Message:      http.StatusText(httpRespCode),
-				Reason:       "",
+				Reason:       statusReason[httpRespCode],
 				ErrTittleMsg: http.StatusText(httpRespCode),
 				ErrBodyMsg:   http.StatusText(httpRespCode),
 			}
@@ -0,0 +1,144 @@
+package helpers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/locales/en"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator"
+	log "github.com/sirupsen/logrus"
+)
+
+// FieldErrorJSON define the structure of a single failed field of a validation error
+type FieldErrorJSON struct {
+	Field   string `json:"field"`   // json path of the field, eg. detail[0].qty
+	Tag     string `json:"tag"`     // the failed validate tag, eg. required
+	Param   string `json:"param"`   // the tag parameter, eg. 0 for gt=0
+	Message string `json:"message"` // for user
+}
+
+var (
+	// translator turns the failed tags into english messages, it is built once by englishTranslator
+	translator     ut.Translator
+	translatorOnce sync.Once
+
+	// validationMessages the english message of every tag used by the requests, {0} is the field and {1} the tag parameter.
+	// A tag without a message falls back to the validator error text.
+	validationMessages = map[string]string{
+		"required":   "{0} is a required field",
+		"numeric":    "{0} must be a valid numeric value",
+		"email":      "{0} must be a valid email address",
+		"alpha":      "{0} can only contain alphabetic characters",
+		"oneof":      "{0} must be one of [{1}]",
+		"gt":         "{0} must be greater than {1}",
+		"gte":        "{0} must be {1} or greater",
+		"lt":         "{0} must be less than {1}",
+		"lte":        "{0} must be {1} or less",
+		"min":        "{0} must be {1} or greater",
+		"max":        "{0} must be {1} or less",
+		"len":        "{0} must be equal to {1}",
+		"min-string": "{0} must be at least {1} characters in length",
+		"max-string": "{0} must be a maximum of {1} characters in length",
+		"len-string": "{0} must be {1} characters in length",
+	}
+)
+
+// NewValidator returns a validator that reports fields by their json name and has the english messages registered,
+// so its errors can be listed with ValidationFields
+func NewValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	trans := englishTranslator()
+	for tag := range validationMessages {
+		if strings.HasSuffix(tag, "-string") {
+			continue
+		}
+		err := v.RegisterTranslation(tag, trans, func(ut.Translator) error { return nil }, translateFieldError)
+		if err != nil {
+			log.Errorf("Can not register validation translation %s. Got %s", tag, err)
+		}
+	}
+
+	return v
+}
+
+// englishTranslator the translator holding the english message of every tag of validationMessages,
+// built by the first call and shared by every validator afterwards
+func englishTranslator() ut.Translator {
+	translatorOnce.Do(func() {
+		enLocale := en.New()
+		translator, _ = ut.New(enLocale, enLocale).GetTranslator("en")
+		for key, text := range validationMessages {
+			if err := translator.Add(key, text, false); err != nil {
+				log.Errorf("Can not add validation message %s. Got %s", key, err)
+			}
+		}
+	})
+	return translator
+}
+
+// translateFieldError the message of a failed field, the length tags read as characters on a string field
+func translateFieldError(trans ut.Translator, fe validator.FieldError) string {
+	key := fe.Tag()
+	if fe.Kind() == reflect.String {
+		if _, ok := validationMessages[key+"-string"]; ok {
+			key += "-string"
+		}
+	}
+
+	message, err := trans.T(key, fe.Field(), fe.Param())
+	if err != nil {
+		log.Warnf("Can not translate validation tag %s. Got %s", fe.Tag(), err)
+		return fe.(error).Error()
+	}
+	return message
+}
+
+// ValidationFields lists every failed field of the validator.ValidationErrors in the chain of err, nil when there is none.
+// The message of a tag can be overridden by lookup, which may be nil, where {field} and {param} are replaced
+// with the field name and the tag parameter.
+func ValidationFields(err error, lookup func(tag string) (string, bool)) []*FieldErrorJSON {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+
+	fields := make([]*FieldErrorJSON, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		field := fe.Namespace()
+		// drop the name of the request struct, the client only knows the json path
+		if i := strings.Index(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+
+		var message string
+		var ok bool
+		if lookup != nil {
+			message, ok = lookup(fe.Tag())
+		}
+		if ok {
+			message = strings.NewReplacer("{field}", fe.Field(), "{param}", fe.Param()).Replace(message)
+		} else {
+			message = fe.Translate(englishTranslator())
+		}
+
+		fields = append(fields, &FieldErrorJSON{
+			Field:   field,
+			Tag:     fe.Tag(),
+			Param:   fe.Param(),
+			Message: message,
+		})
+	}
+
+	return fields
+}
@@ -0,0 +1,21 @@
+ALTER TABLE `transactions`
+  ADD COLUMN `status` VARCHAR(20) NOT NULL DEFAULT 'pending' AFTER `grand_total`;
+
+-- orders placed before the lifecycle existed were final the moment they were inserted
+UPDATE `transactions` SET `status` = 'completed';
+
+CREATE TABLE `transaction_status_history` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `transaction_id` INT UNSIGNED NOT NULL,
+  `from_status` VARCHAR(20) NULL,
+  `to_status` VARCHAR(20) NOT NULL,
+  `actor` VARCHAR(255) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_transaction_status_history_transactions1_idx` (`transaction_id` ASC),
+  CONSTRAINT `fk_transaction_status_history_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
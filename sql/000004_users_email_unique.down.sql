@@ -0,0 +1,3 @@
+ALTER TABLE `users`
+  DROP INDEX `users_email_UNIQUE`,
+  DROP COLUMN `deleted_at`;
@@ -0,0 +1,3 @@
+ALTER TABLE `users`
+  ADD COLUMN `deleted_at` DATETIME NULL AFTER `address`,
+  ADD UNIQUE INDEX `users_email_UNIQUE` (`email` ASC);
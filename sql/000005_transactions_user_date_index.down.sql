@@ -0,0 +1 @@
+ALTER TABLE `transactions` DROP INDEX `transactions_user_date_idx` ;
@@ -0,0 +1,3 @@
+-- serves the newest-first order history of a user and its (date, id) cursor
+ALTER TABLE `transactions`
+  ADD INDEX `transactions_user_date_idx` (`user_id` ASC, `date` DESC, `id` DESC);
@@ -0,0 +1,26 @@
+CREATE TABLE `stock_movements` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `product_id` INT UNSIGNED NOT NULL,
+  `delta` INT NOT NULL,
+  `reason` VARCHAR(20) NOT NULL,
+  `transaction_id` INT UNSIGNED NULL,
+  `actor` VARCHAR(255) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_stock_movements_products1_idx` (`product_id` ASC, `id` ASC),
+  INDEX `fk_stock_movements_transactions1_idx` (`transaction_id` ASC),
+  CONSTRAINT `fk_stock_movements_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_stock_movements_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- open the ledger of every existing product with its current qty, so the movements of a product always add up to its qty
+INSERT INTO `stock_movements` (`product_id`, `delta`, `reason`, `transaction_id`, `actor`, `created_at`)
+  SELECT `id`, `qty`, 'initial', NULL, 'system', NOW() FROM `products`;
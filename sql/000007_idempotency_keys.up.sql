@@ -0,0 +1,17 @@
+CREATE TABLE `idempotency_keys` (
+  `idempotency_key` VARCHAR(255) NOT NULL,
+  `request_hash` CHAR(64) NOT NULL,
+  `transaction_id` INT UNSIGNED NOT NULL,
+  `response_status` SMALLINT UNSIGNED NOT NULL,
+  `response_body` MEDIUMTEXT NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  PRIMARY KEY (`idempotency_key`),
+  INDEX `idempotency_keys_expires_at_idx` (`expires_at` ASC),
+  INDEX `fk_idempotency_keys_transactions1_idx` (`transaction_id` ASC),
+  CONSTRAINT `fk_idempotency_keys_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
@@ -0,0 +1,4 @@
+DROP TABLE `transaction_detail_discounts` ;
+ALTER TABLE `transaction_detail` DROP COLUMN `id` ;
+DROP TABLE `coupon_redemptions` ;
+DROP TABLE `coupons` ;
@@ -0,0 +1,74 @@
+CREATE TABLE `coupons` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `code` VARCHAR(64) NOT NULL,
+  `type` VARCHAR(20) NOT NULL,
+  `value` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `buy_qty` INT UNSIGNED NOT NULL DEFAULT 0,
+  `get_qty` INT UNSIGNED NOT NULL DEFAULT 0,
+  `brand_id` INT UNSIGNED NULL,
+  `min_spend` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  `usage_limit` INT UNSIGNED NOT NULL DEFAULT 0,
+  `usage_limit_per_user` INT UNSIGNED NOT NULL DEFAULT 0,
+  `starts_at` DATETIME NULL,
+  `ends_at` DATETIME NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `coupons_code_unique` (`code` ASC),
+  INDEX `fk_coupons_brands1_idx` (`brand_id` ASC),
+  CONSTRAINT `fk_coupons_brands1`
+    FOREIGN KEY (`brand_id`)
+    REFERENCES `brands` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+CREATE TABLE `coupon_redemptions` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `coupon_id` INT UNSIGNED NOT NULL,
+  `user_id` INT UNSIGNED NOT NULL,
+  `transaction_id` INT UNSIGNED NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_coupon_redemptions_coupons1_idx` (`coupon_id` ASC, `user_id` ASC),
+  INDEX `fk_coupon_redemptions_users1_idx` (`user_id` ASC),
+  UNIQUE INDEX `coupon_redemptions_transaction_unique` (`transaction_id` ASC),
+  CONSTRAINT `fk_coupon_redemptions_coupons1`
+    FOREIGN KEY (`coupon_id`)
+    REFERENCES `coupons` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_coupon_redemptions_users1`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_coupon_redemptions_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- a detail needs its own key for its discount lines, the same product may appear twice in an order
+ALTER TABLE `transaction_detail`
+  ADD COLUMN `id` INT UNSIGNED NOT NULL AUTO_INCREMENT FIRST,
+  ADD PRIMARY KEY (`id`);
+
+CREATE TABLE `transaction_detail_discounts` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `transaction_detail_id` INT UNSIGNED NOT NULL,
+  `coupon_id` INT UNSIGNED NOT NULL,
+  `amount` BIGINT UNSIGNED NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_transaction_detail_discounts_transaction_detail1_idx` (`transaction_detail_id` ASC),
+  INDEX `fk_transaction_detail_discounts_coupons1_idx` (`coupon_id` ASC),
+  CONSTRAINT `fk_transaction_detail_discounts_transaction_detail1`
+    FOREIGN KEY (`transaction_detail_id`)
+    REFERENCES `transaction_detail` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_transaction_detail_discounts_coupons1`
+    FOREIGN KEY (`coupon_id`)
+    REFERENCES `coupons` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
@@ -0,0 +1,3 @@
+ALTER TABLE `transaction_detail` DROP COLUMN `tax` ;
+ALTER TABLE `transactions` DROP COLUMN `tax_inclusive`, DROP COLUMN `tax`, DROP COLUMN `discount`, DROP COLUMN `subtotal` ;
+ALTER TABLE `products` DROP COLUMN `tax_class` ;
@@ -0,0 +1,17 @@
+ALTER TABLE `products`
+  ADD COLUMN `tax_class` VARCHAR(20) NOT NULL DEFAULT 'standard' AFTER `price`;
+
+ALTER TABLE `transactions`
+  ADD COLUMN `subtotal` BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER `date`,
+  ADD COLUMN `discount` BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER `subtotal`,
+  ADD COLUMN `tax` BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER `discount`,
+  ADD COLUMN `tax_inclusive` TINYINT(1) NOT NULL DEFAULT 0 AFTER `tax`;
+
+ALTER TABLE `transaction_detail`
+  ADD COLUMN `tax` BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER `sub_total`;
+
+-- orders placed before the breakdown existed were charged no tax, their grand total is the subtotal minus the discount
+UPDATE `transactions` t SET
+  `subtotal` = (SELECT COALESCE(SUM(td.`sub_total`), 0) FROM `transaction_detail` td WHERE td.`transaction_id` = t.`id`),
+  `discount` = (SELECT COALESCE(SUM(d.`amount`), 0) FROM `transaction_detail_discounts` d
+    JOIN `transaction_detail` td ON td.`id` = d.`transaction_detail_id` WHERE td.`transaction_id` = t.`id`);
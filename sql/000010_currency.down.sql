@@ -0,0 +1,3 @@
+ALTER TABLE `coupons` DROP COLUMN `currency` ;
+ALTER TABLE `transactions` DROP COLUMN `currency` ;
+ALTER TABLE `products` DROP COLUMN `currency` ;
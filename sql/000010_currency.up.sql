@@ -0,0 +1,12 @@
+-- every amount stored so far is in rupiah
+ALTER TABLE `products`
+  ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'IDR' AFTER `price`;
+
+ALTER TABLE `transactions`
+  ADD COLUMN `currency` CHAR(3) NOT NULL DEFAULT 'IDR' AFTER `date`;
+
+-- a coupon without a currency applies to orders in any currency, the amount of a fixed coupon and the min spend need one
+ALTER TABLE `coupons`
+  ADD COLUMN `currency` CHAR(3) NULL AFTER `value`;
+
+UPDATE `coupons` SET `currency` = 'IDR' WHERE `type` = 'fixed' OR `min_spend` > 0;
@@ -0,0 +1 @@
+DROP TABLE `cart_items` ;
@@ -0,0 +1,19 @@
+-- the cart of a user, a product appears once per cart with the qty the user wants
+CREATE TABLE `cart_items` (
+  `user_id` INT UNSIGNED NOT NULL,
+  `product_id` INT UNSIGNED NOT NULL,
+  `qty` INT UNSIGNED NOT NULL,
+  `added_at` DATETIME NOT NULL,
+  PRIMARY KEY (`user_id`, `product_id`),
+  INDEX `fk_cart_items_products1_idx` (`product_id` ASC),
+  CONSTRAINT `fk_cart_items_users1`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_cart_items_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
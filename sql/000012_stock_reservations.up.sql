@@ -0,0 +1,36 @@
+-- the qty a pending order holds until it is paid, a row is active while its expires_at is in the future
+CREATE TABLE `stock_reservations` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `product_id` INT UNSIGNED NOT NULL,
+  `transaction_id` INT UNSIGNED NOT NULL,
+  `qty` INT UNSIGNED NOT NULL,
+  `expires_at` DATETIME NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_stock_reservations_products1_idx` (`product_id` ASC, `expires_at` ASC),
+  INDEX `fk_stock_reservations_transactions1_idx` (`transaction_id` ASC),
+  INDEX `stock_reservations_expires_at_idx` (`expires_at` ASC),
+  CONSTRAINT `fk_stock_reservations_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_stock_reservations_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- pending orders placed before reservations took their qty from the products when they were placed,
+-- give it back and reserve it instead so paying them takes it again
+INSERT INTO `stock_reservations` (`product_id`, `transaction_id`, `qty`, `expires_at`, `created_at`)
+  SELECT td.`product_id`, td.`transaction_id`, SUM(td.`qty`), NOW() + INTERVAL 15 MINUTE, NOW()
+  FROM `transaction_detail` td JOIN `transactions` t ON t.`id` = td.`transaction_id`
+  WHERE t.`status` = 'pending' GROUP BY td.`transaction_id`, td.`product_id`;
+
+UPDATE `products` p JOIN (SELECT `product_id`, SUM(`qty`) AS `qty` FROM `stock_reservations` GROUP BY `product_id`) r ON r.`product_id` = p.`id`
+  SET p.`qty` = p.`qty` + r.`qty`;
+
+INSERT INTO `stock_movements` (`product_id`, `delta`, `reason`, `transaction_id`, `actor`, `created_at`)
+  SELECT `product_id`, `qty`, 'adjustment', `transaction_id`, 'system', NOW() FROM `stock_reservations`;
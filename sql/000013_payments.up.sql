@@ -0,0 +1,20 @@
+-- the payments of an order through a payment gateway, an order may have several, eg. a declined one before the captured one
+CREATE TABLE `payments` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `transaction_id` INT UNSIGNED NOT NULL,
+  `gateway` VARCHAR(32) NOT NULL,
+  `reference` VARCHAR(255) NOT NULL,
+  `amount` BIGINT UNSIGNED NOT NULL,
+  `currency` CHAR(3) NOT NULL,
+  `status` VARCHAR(20) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `updated_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `payments_gateway_reference_unique` (`gateway` ASC, `reference` ASC),
+  INDEX `fk_payments_transactions1_idx` (`transaction_id` ASC),
+  CONSTRAINT `fk_payments_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
@@ -0,0 +1,3 @@
+DROP TABLE `shipments` ;
+ALTER TABLE `transactions` DROP COLUMN `shipping_country`, DROP COLUMN `shipping_postal_code`, DROP COLUMN `shipping_city`, DROP COLUMN `shipping_street`, DROP COLUMN `shipping_phone`, DROP COLUMN `shipping_recipient`, DROP COLUMN `shipping_cost` ;
+DROP TABLE `addresses` ;
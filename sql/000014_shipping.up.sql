@@ -0,0 +1,50 @@
+-- the address book of every user, orders are shipped to a copy of one of its addresses
+CREATE TABLE `addresses` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `user_id` INT UNSIGNED NOT NULL,
+  `label` VARCHAR(64) NOT NULL DEFAULT '',
+  `recipient` VARCHAR(255) NOT NULL,
+  `phone` VARCHAR(32) NOT NULL,
+  `street` VARCHAR(255) NOT NULL,
+  `city` VARCHAR(128) NOT NULL,
+  `postal_code` VARCHAR(16) NOT NULL,
+  `country` CHAR(2) NOT NULL,
+  `is_default` TINYINT(1) NOT NULL DEFAULT 0,
+  `created_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_addresses_users1_idx` (`user_id` ASC),
+  CONSTRAINT `fk_addresses_users1`
+    FOREIGN KEY (`user_id`)
+    REFERENCES `users` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- the address is copied, so the order keeps it when the address book changes. Orders placed before have none and shipped free.
+ALTER TABLE `transactions`
+  ADD COLUMN `shipping_cost` BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER `tax_inclusive`,
+  ADD COLUMN `shipping_recipient` VARCHAR(255) NULL AFTER `shipping_cost`,
+  ADD COLUMN `shipping_phone` VARCHAR(32) NULL AFTER `shipping_recipient`,
+  ADD COLUMN `shipping_street` VARCHAR(255) NULL AFTER `shipping_phone`,
+  ADD COLUMN `shipping_city` VARCHAR(128) NULL AFTER `shipping_street`,
+  ADD COLUMN `shipping_postal_code` VARCHAR(16) NULL AFTER `shipping_city`,
+  ADD COLUMN `shipping_country` CHAR(2) NULL AFTER `shipping_postal_code`;
+
+-- the parcels of an order handed to a carrier
+CREATE TABLE `shipments` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `transaction_id` INT UNSIGNED NOT NULL,
+  `carrier` VARCHAR(64) NOT NULL,
+  `tracking_number` VARCHAR(128) NOT NULL,
+  `status` VARCHAR(20) NOT NULL,
+  `created_at` DATETIME NOT NULL,
+  `updated_at` DATETIME NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `shipments_carrier_tracking_number_unique` (`carrier` ASC, `tracking_number` ASC),
+  INDEX `fk_shipments_transactions1_idx` (`transaction_id` ASC),
+  CONSTRAINT `fk_shipments_transactions1`
+    FOREIGN KEY (`transaction_id`)
+    REFERENCES `transactions` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
@@ -0,0 +1,31 @@
+-- the category tree of the catalog, a category without parent is a root
+CREATE TABLE `categories` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `parent_id` INT UNSIGNED NULL,
+  `name` VARCHAR(255) NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_categories_categories1_idx` (`parent_id` ASC),
+  CONSTRAINT `fk_categories_categories1`
+    FOREIGN KEY (`parent_id`)
+    REFERENCES `categories` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- the categories a product is listed in, deleting the product or the category removes the link
+CREATE TABLE `product_categories` (
+  `product_id` INT UNSIGNED NOT NULL,
+  `category_id` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`product_id`, `category_id`),
+  INDEX `fk_product_categories_categories1_idx` (`category_id` ASC),
+  CONSTRAINT `fk_product_categories_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_product_categories_categories1`
+    FOREIGN KEY (`category_id`)
+    REFERENCES `categories` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
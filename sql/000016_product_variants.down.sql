@@ -0,0 +1,4 @@
+ALTER TABLE `stock_movements` DROP INDEX `stock_movements_variant_id_idx`, DROP COLUMN `variant_id` ;
+ALTER TABLE `stock_reservations` DROP FOREIGN KEY `fk_stock_reservations_product_variants1`, DROP INDEX `fk_stock_reservations_product_variants1_idx`, DROP COLUMN `variant_id` ;
+ALTER TABLE `transaction_detail` DROP FOREIGN KEY `fk_transaction_detail_product_variants1`, DROP INDEX `fk_transaction_detail_product_variants1_idx`, DROP COLUMN `variant_id` ;
+DROP TABLE `product_variants` ;
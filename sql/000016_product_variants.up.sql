@@ -0,0 +1,42 @@
+-- the variants a product is sold in, eg. a strap color or a case size, each with its own sku, price and stock
+CREATE TABLE `product_variants` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `product_id` INT UNSIGNED NOT NULL,
+  `sku` VARCHAR(64) NOT NULL,
+  `options` JSON NOT NULL,
+  `price` BIGINT UNSIGNED NOT NULL,
+  `currency` CHAR(3) NOT NULL,
+  `qty` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `product_variants_sku_unique` (`sku` ASC),
+  INDEX `fk_product_variants_products1_idx` (`product_id` ASC),
+  CONSTRAINT `fk_product_variants_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- a detail, reservation or stock movement without a variant is on the qty of the product itself
+ALTER TABLE `transaction_detail`
+  ADD COLUMN `variant_id` INT UNSIGNED NULL AFTER `product_id`,
+  ADD INDEX `fk_transaction_detail_product_variants1_idx` (`variant_id` ASC),
+  ADD CONSTRAINT `fk_transaction_detail_product_variants1`
+    FOREIGN KEY (`variant_id`)
+    REFERENCES `product_variants` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION;
+
+ALTER TABLE `stock_reservations`
+  ADD COLUMN `variant_id` INT UNSIGNED NULL AFTER `product_id`,
+  ADD INDEX `fk_stock_reservations_product_variants1_idx` (`variant_id` ASC, `expires_at` ASC),
+  ADD CONSTRAINT `fk_stock_reservations_product_variants1`
+    FOREIGN KEY (`variant_id`)
+    REFERENCES `product_variants` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION;
+
+-- the movements of a deleted variant stay in the history of its product
+ALTER TABLE `stock_movements`
+  ADD COLUMN `variant_id` INT UNSIGNED NULL AFTER `product_id`,
+  ADD INDEX `stock_movements_variant_id_idx` (`variant_id` ASC);
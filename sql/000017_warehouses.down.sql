@@ -0,0 +1,4 @@
+ALTER TABLE `stock_movements` DROP FOREIGN KEY `fk_stock_movements_warehouses1`, DROP INDEX `fk_stock_movements_warehouses1_idx`, DROP COLUMN `warehouse_id` ;
+DROP TABLE `transaction_detail_allocations` ;
+DROP TABLE `warehouse_stock` ;
+DROP TABLE `warehouses` ;
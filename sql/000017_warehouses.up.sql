@@ -0,0 +1,76 @@
+-- the stock locations, the qty of a product or variant is the qty it has in every warehouse added up
+CREATE TABLE `warehouses` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `code` VARCHAR(32) NOT NULL,
+  `name` VARCHAR(255) NOT NULL,
+  `city` VARCHAR(128) NOT NULL,
+  `country` CHAR(2) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE INDEX `warehouses_code_unique` (`code` ASC))
+ENGINE = InnoDB;
+
+-- the default warehouse, it holds the stock there was before the warehouses
+INSERT INTO `warehouses` (`id`, `code`, `name`, `city`, `country`) VALUES (1, 'MAIN', 'main warehouse', 'jakarta', 'ID');
+
+-- a row without a variant is the qty of the product itself
+CREATE TABLE `warehouse_stock` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `warehouse_id` INT UNSIGNED NOT NULL,
+  `product_id` INT UNSIGNED NOT NULL,
+  `variant_id` INT UNSIGNED NULL,
+  `qty` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `warehouse_stock_location_idx` (`warehouse_id` ASC, `product_id` ASC, `variant_id` ASC),
+  INDEX `fk_warehouse_stock_products1_idx` (`product_id` ASC),
+  INDEX `fk_warehouse_stock_product_variants1_idx` (`variant_id` ASC),
+  CONSTRAINT `fk_warehouse_stock_warehouses1`
+    FOREIGN KEY (`warehouse_id`)
+    REFERENCES `warehouses` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_warehouse_stock_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_warehouse_stock_product_variants1`
+    FOREIGN KEY (`variant_id`)
+    REFERENCES `product_variants` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+INSERT INTO `warehouse_stock` (`warehouse_id`, `product_id`, `variant_id`, `qty`) SELECT 1, `id`, NULL, `qty` FROM `products` WHERE `qty` > 0;
+INSERT INTO `warehouse_stock` (`warehouse_id`, `product_id`, `variant_id`, `qty`) SELECT 1, `product_id`, `id`, `qty` FROM `product_variants` WHERE `qty` > 0;
+
+-- the warehouses the qty of a paid detail was taken from, a detail may be split over several
+CREATE TABLE `transaction_detail_allocations` (
+  `id` INT UNSIGNED NOT NULL AUTO_INCREMENT,
+  `transaction_detail_id` INT UNSIGNED NOT NULL,
+  `warehouse_id` INT UNSIGNED NOT NULL,
+  `qty` INT UNSIGNED NOT NULL,
+  PRIMARY KEY (`id`),
+  INDEX `fk_transaction_detail_allocations_transaction_detail1_idx` (`transaction_detail_id` ASC),
+  INDEX `fk_transaction_detail_allocations_warehouses1_idx` (`warehouse_id` ASC),
+  CONSTRAINT `fk_transaction_detail_allocations_transaction_detail1`
+    FOREIGN KEY (`transaction_detail_id`)
+    REFERENCES `transaction_detail` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION,
+  CONSTRAINT `fk_transaction_detail_allocations_warehouses1`
+    FOREIGN KEY (`warehouse_id`)
+    REFERENCES `warehouses` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION)
+ENGINE = InnoDB;
+
+-- the movements before the warehouses moved the stock of the default warehouse
+ALTER TABLE `stock_movements` ADD COLUMN `warehouse_id` INT UNSIGNED NOT NULL DEFAULT 1 AFTER `variant_id`;
+ALTER TABLE `stock_movements`
+  ALTER COLUMN `warehouse_id` DROP DEFAULT,
+  ADD INDEX `fk_stock_movements_warehouses1_idx` (`warehouse_id` ASC),
+  ADD CONSTRAINT `fk_stock_movements_warehouses1`
+    FOREIGN KEY (`warehouse_id`)
+    REFERENCES `warehouses` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION;
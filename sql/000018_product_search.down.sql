@@ -0,0 +1,3 @@
+ALTER TABLE `categories` DROP INDEX `categories_name_fulltext` ;
+ALTER TABLE `brands` DROP INDEX `brands_name_fulltext` ;
+ALTER TABLE `products` DROP INDEX `products_name_fulltext` ;
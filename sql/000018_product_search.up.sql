@@ -0,0 +1,10 @@
+-- serve the relevance of GET /search. The ngram parser indexes every pair of letters of a word,
+-- so a word with a typo still shares most of its pairs with the word that was meant.
+ALTER TABLE `products`
+  ADD FULLTEXT INDEX `products_name_fulltext` (`name`) WITH PARSER ngram;
+
+ALTER TABLE `brands`
+  ADD FULLTEXT INDEX `brands_name_fulltext` (`name`) WITH PARSER ngram;
+
+ALTER TABLE `categories`
+  ADD FULLTEXT INDEX `categories_name_fulltext` (`name`) WITH PARSER ngram;
@@ -0,0 +1,8 @@
+ALTER TABLE `stock_movements`
+  DROP FOREIGN KEY `fk_stock_movements_products1`;
+ALTER TABLE `stock_movements`
+  ADD CONSTRAINT `fk_stock_movements_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE CASCADE
+    ON UPDATE NO ACTION;
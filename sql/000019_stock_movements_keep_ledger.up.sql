@@ -0,0 +1,9 @@
+-- the stock ledger outlives its product, a product with stock movements can no longer be deleted
+ALTER TABLE `stock_movements`
+  DROP FOREIGN KEY `fk_stock_movements_products1`;
+ALTER TABLE `stock_movements`
+  ADD CONSTRAINT `fk_stock_movements_products1`
+    FOREIGN KEY (`product_id`)
+    REFERENCES `products` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION;
@@ -0,0 +1 @@
+ALTER TABLE `stock_reservations` DROP FOREIGN KEY `fk_stock_reservations_warehouses1`, DROP INDEX `fk_stock_reservations_warehouses1_idx`, DROP COLUMN `warehouse_id` ;
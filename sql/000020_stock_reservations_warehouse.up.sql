@@ -0,0 +1,10 @@
+-- the reservations before the allocation at order creation held the stock of the default warehouse
+ALTER TABLE `stock_reservations` ADD COLUMN `warehouse_id` INT UNSIGNED NOT NULL DEFAULT 1 AFTER `variant_id`;
+ALTER TABLE `stock_reservations`
+  ALTER COLUMN `warehouse_id` DROP DEFAULT,
+  ADD INDEX `fk_stock_reservations_warehouses1_idx` (`warehouse_id` ASC),
+  ADD CONSTRAINT `fk_stock_reservations_warehouses1`
+    FOREIGN KEY (`warehouse_id`)
+    REFERENCES `warehouses` (`id`)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION;
@@ -0,0 +1,2 @@
+UPDATE `payments` SET `reference` = CONCAT('unanswered_', `id`), `status` = 'failed' WHERE `reference` IS NULL;
+ALTER TABLE `payments` MODIFY COLUMN `reference` VARCHAR(255) NOT NULL;
@@ -0,0 +1,2 @@
+-- a payment is stored before the gateway is asked, so a second payment of the order is refused first; it gets its reference with the answer
+ALTER TABLE `payments` MODIFY COLUMN `reference` VARCHAR(255) NULL;